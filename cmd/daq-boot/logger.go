@@ -0,0 +1,210 @@
+// Copyright 2020 The go-lpc Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"log/syslog"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// logConfig describes how the output of DAQ processes is logged.
+type logConfig struct {
+	maxSize int64         // maximum size of a log file before rotation (0: no limit)
+	maxAge  time.Duration // maximum age of a log file before rotation (0: no limit)
+	keep    int           // number of rotated log files to keep
+	syslog  bool          // whether to also forward process output to syslog
+}
+
+// logger is an io.Writer that timestamps and prefixes each line of
+// the output of a process, writes it to a rotated log file and,
+// optionally, forwards it to syslog.
+type logger struct {
+	mu   sync.Mutex
+	cfg  logConfig
+	name string // name of the process
+	path string // path to the current log file
+
+	f    *os.File
+	size int64     // size of the current log file
+	born time.Time // creation time of the current log file
+	part []byte    // last, incomplete, line
+	buf  []byte
+
+	sys io.WriteCloser
+	now func() time.Time
+}
+
+func newLogger(dir, name string, cfg logConfig) (*logger, error) {
+	l := &logger{
+		cfg:  cfg,
+		name: name,
+		path: filepath.Join(dir, name+".log"),
+		now:  time.Now,
+	}
+
+	// keep the log of the previous run around.
+	fi, err := os.Stat(l.path)
+	if err == nil && fi.Size() > 0 {
+		err = l.shift()
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	err = l.open()
+	if err != nil {
+		return nil, err
+	}
+
+	if cfg.syslog {
+		sys, err := syslog.New(syslog.LOG_INFO|syslog.LOG_DAEMON, name)
+		if err != nil {
+			_ = l.f.Close()
+			return nil, fmt.Errorf("could not connect to syslog for %q: %w", name, err)
+		}
+		l.sys = sys
+	}
+
+	return l, nil
+}
+
+func (l *logger) open() error {
+	f, err := os.Create(l.path)
+	if err != nil {
+		return fmt.Errorf("could not create log file %q: %w", l.path, err)
+	}
+	l.f = f
+	l.size = 0
+	l.born = l.now()
+	return nil
+}
+
+// shift shifts the rotated log files by one, making room for the
+// current log file.
+func (l *logger) shift() error {
+	if l.cfg.keep <= 0 {
+		err := os.Remove(l.path)
+		if err != nil && !os.IsNotExist(err) {
+			return fmt.Errorf("could not remove log file %q: %w", l.path, err)
+		}
+		return nil
+	}
+
+	for i := l.cfg.keep - 1; i > 0; i-- {
+		src := fmt.Sprintf("%s.%d", l.path, i)
+		dst := fmt.Sprintf("%s.%d", l.path, i+1)
+		err := os.Rename(src, dst)
+		if err != nil && !os.IsNotExist(err) {
+			return fmt.Errorf("could not rotate log file %q: %w", src, err)
+		}
+	}
+	err := os.Rename(l.path, l.path+".1")
+	if err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("could not rotate log file %q: %w", l.path, err)
+	}
+	return nil
+}
+
+func (l *logger) rotate() error {
+	err := l.f.Close()
+	if err != nil {
+		return fmt.Errorf("could not close log file %q: %w", l.path, err)
+	}
+	err = l.shift()
+	if err != nil {
+		return err
+	}
+	return l.open()
+}
+
+func (l *logger) Write(p []byte) (int, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.f == nil {
+		return 0, os.ErrClosed
+	}
+
+	n := len(p)
+	for len(p) > 0 {
+		i := bytes.IndexByte(p, '\n')
+		if i < 0 {
+			l.part = append(l.part, p...)
+			break
+		}
+		l.part = append(l.part, p[:i]...)
+		err := l.writeLine(l.part)
+		l.part = l.part[:0]
+		if err != nil {
+			return n - len(p), err
+		}
+		p = p[i+1:]
+	}
+	return n, nil
+}
+
+func (l *logger) writeLine(line []byte) error {
+	now := l.now()
+	l.buf = append(l.buf[:0], now.UTC().Format("2006-01-02 15:04:05.000")...)
+	l.buf = append(l.buf, " ["...)
+	l.buf = append(l.buf, l.name...)
+	l.buf = append(l.buf, "] "...)
+	l.buf = append(l.buf, line...)
+	l.buf = append(l.buf, '\n')
+
+	var (
+		tooBig = l.cfg.maxSize > 0 && l.size > 0 && l.size+int64(len(l.buf)) > l.cfg.maxSize
+		tooOld = l.cfg.maxAge > 0 && now.Sub(l.born) >= l.cfg.maxAge
+	)
+	if tooBig || tooOld {
+		err := l.rotate()
+		if err != nil {
+			return err
+		}
+	}
+
+	n, err := l.f.Write(l.buf)
+	l.size += int64(n)
+	if err != nil {
+		return fmt.Errorf("could not write to log file %q: %w", l.path, err)
+	}
+
+	if l.sys != nil {
+		// syslog has its own timestamps.
+		_, _ = l.sys.Write(line)
+	}
+	return nil
+}
+
+// Close flushes any incomplete line and closes the log file.
+func (l *logger) Close() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.f == nil {
+		return nil
+	}
+
+	var err error
+	if len(l.part) > 0 {
+		err = l.writeLine(l.part)
+		l.part = l.part[:0]
+	}
+	if l.sys != nil {
+		_ = l.sys.Close()
+		l.sys = nil
+	}
+	if e := l.f.Close(); e != nil && err == nil {
+		err = fmt.Errorf("could not close log file %q: %w", l.path, e)
+	}
+	l.f = nil
+	return err
+}
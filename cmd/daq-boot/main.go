@@ -3,6 +3,20 @@
 // license that can be found in the LICENSE file.
 
 // Command daq-boot (re)starts all the C++ DAQ processes.
+//
+// Processes can be run on remote hosts, over SSH, with -remote:
+//
+//	$> daq-boot -remote dimwriter=daq01,dim-eda=root@eda01:2222
+//
+// The output of remote processes is logged locally, as for local
+// processes. The SSH options given with -ssh-opts apply to all hosts, and
+// can be overridden per host with -ssh-host-opts:
+//
+//	$> daq-boot -remote dim-eda=root@eda01 -ssh-host-opts 'eda01=-i /root/.ssh/eda -oConnectTimeout=2'
+//
+// With -pmon, local processes are monitored with pmon, and the status of
+// remote processes is polled over SSH (with pgrep) and written to a
+// <name>-status.log file, changes of status being logged locally.
 package main // import "github.com/go-lpc/mim/cmd/daq-boot"
 
 import (
@@ -13,8 +27,10 @@ import (
 	"os/exec"
 	"os/signal"
 	"path/filepath"
+	"strings"
 	"time"
 
+	"github.com/go-lpc/mim/internal/cliconf"
 	"github.com/sbinet/pmon"
 	"golang.org/x/sync/errgroup"
 )
@@ -26,38 +42,120 @@ var (
 		// exec.Command("dim-eda"),
 		exec.Command("dimwriter"),
 	}
-	dir = os.Getenv("SDHCALLOGDIR")
 
-	doMon  = flag.Bool("pmon", false, "enable pmon monitoring")
-	doFreq = flag.Duration("freq", 1*time.Second, "pmon frequency")
+	cli = cliconf.New(flag.CommandLine)
+	dir = cli.OutDir(os.Getenv("SDHCALLOGDIR"))
+
+	doMon  = cli.Bool("pmon", false, "enable pmon monitoring of local processes")
+	doFreq = cli.Freq(1 * time.Second)
+
+	logSize = cli.Int64("log-max-size", 64<<20, "maximum size in bytes of a process log file before rotation (0: no limit)")
+	logAge  = cli.Duration("log-max-age", 24*time.Hour, "maximum age of a process log file before rotation (0: no limit)")
+	logKeep = cli.Int("log-keep", 7, "number of rotated log files to keep per process")
+	logSys  = cli.Bool("syslog", false, "forward process output to syslog")
+
+	rmtProcs = cli.String("remote", "", "comma-separated list of name=[user@]host[:port] processes to run on remote hosts, over SSH")
+	rmtOpts  = cli.String("ssh-opts", "-oBatchMode=yes", "space-separated list of SSH options for remote hosts")
+	rmtHosts = cli.String("ssh-host-opts", "", "semicolon-separated list of host=opts SSH options of specific remote hosts, overriding -ssh-opts")
 
 	stop = make(chan os.Signal, 1)
 )
 
 func main() {
-	flag.Parse()
-
 	log.SetPrefix("daq-boot: ")
 	log.SetFlags(0)
 
-	err := run(*doMon, *doFreq, cmds, dir, stop)
+	err := cli.Parse(os.Args[1:])
+	if err != nil {
+		log.Fatalf("could not parse input arguments: %+v", err)
+	}
+
+	lcfg := logConfig{
+		maxSize: *logSize,
+		maxAge:  *logAge,
+		keep:    *logKeep,
+		syslog:  *logSys,
+	}
+
+	hosts, err := parseHostOpts(*rmtHosts)
+	if err != nil {
+		log.Fatalf("could not parse SSH host options: %+v", err)
+	}
+
+	rmts, err := parseRemotes(*rmtProcs, strings.Fields(*rmtOpts), hosts)
+	if err != nil {
+		log.Fatalf("could not parse remote processes: %+v", err)
+	}
+
+	err = run(*doMon, *doFreq, cmds, rmts, *dir, lcfg, stop)
 	if err != nil {
 		log.Fatalf("%+v", err)
 	}
 }
 
-func run(doMon bool, freq time.Duration, cmds []*exec.Cmd, dir string, stop chan os.Signal) error {
+// proc is a DAQ process, run locally or on a remote host.
+type proc struct {
+	name string
+	cmd  *exec.Cmd
+	rmt  *remote // remote host of the process (nil: local)
+}
+
+func (p proc) where() string {
+	if p.rmt == nil {
+		return "localhost"
+	}
+	return p.rmt.String()
+}
+
+// killall kills the processes named after p on its host.
+func (p proc) killall() error {
+	if p.rmt == nil {
+		return p.run(exec.Command("killall", p.name))
+	}
+	return p.run(p.rmt.command("killall", p.name))
+}
+
+func (proc) run(cmd *exec.Cmd) error {
+	cmd.Stderr = os.Stderr
+	cmd.Stdout = os.Stdout
+	return cmd.Run()
+}
+
+func newProcs(cmds []*exec.Cmd, rmts map[string]remote) ([]proc, error) {
+	var (
+		procs = make([]proc, len(cmds))
+		names = make(map[string]bool, len(cmds))
+	)
+	for i, cmd := range cmds {
+		p := proc{name: filepath.Base(cmd.Path), cmd: cmd}
+		if r, ok := rmts[p.name]; ok {
+			p.rmt = &r
+			p.cmd = r.command(cmd.Args...)
+		}
+		procs[i] = p
+		names[p.name] = true
+	}
+	for name := range rmts {
+		if !names[name] {
+			return nil, fmt.Errorf("unknown remote process %q", name)
+		}
+	}
+	return procs, nil
+}
+
+func run(doMon bool, freq time.Duration, cmds []*exec.Cmd, rmts map[string]remote, dir string, lcfg logConfig, stop chan os.Signal) error {
 	signal.Notify(stop, os.Interrupt)
 	defer signal.Stop(stop)
 
-	for _, cmd := range cmds {
-		name := filepath.Base(cmd.Path)
-		kill := exec.Command("killall", name)
-		kill.Stderr = os.Stderr
-		kill.Stdout = os.Stdout
-		err := kill.Run()
+	procs, err := newProcs(cmds, rmts)
+	if err != nil {
+		return err
+	}
+
+	for _, p := range procs {
+		err := p.killall()
 		if err != nil {
-			log.Printf("could not kill %q: %+v", name, err)
+			log.Printf("could not kill %q on %s: %+v", p.name, p.where(), err)
 		}
 	}
 
@@ -69,14 +167,10 @@ func run(doMon bool, freq time.Duration, cmds []*exec.Cmd, dir string, stop chan
 		grp  errgroup.Group
 		kill = make(chan int)
 	)
-	grp.Go(func() error {
-		return start(cmds[0], dir, kill, doMon, freq)
-	})
-
-	for i := range cmds[1:] {
-		name := cmds[i+1]
+	for i := range procs {
+		p := procs[i]
 		grp.Go(func() error {
-			return start(name, dir, kill, doMon, freq)
+			return start(p, dir, lcfg, kill, doMon, freq)
 		})
 	}
 
@@ -85,31 +179,54 @@ func run(doMon bool, freq time.Duration, cmds []*exec.Cmd, dir string, stop chan
 		close(kill)
 	}()
 
-	err := grp.Wait()
+	err = grp.Wait()
 	if err != nil {
 		return fmt.Errorf("could not boot DAQ: %w", err)
 	}
 	return nil
 }
 
-func start(cmd *exec.Cmd, dir string, kill chan int, doMon bool, freq time.Duration) error {
-	name := filepath.Base(cmd.Path)
-	out, err := os.Create(filepath.Join(dir, name+".log"))
+func start(p proc, dir string, lcfg logConfig, kill chan int, doMon bool, freq time.Duration) error {
+	var (
+		name = p.name
+		cmd  = p.cmd
+	)
+	out, err := newLogger(dir, name, lcfg)
 	if err != nil {
-		return fmt.Errorf("could not create output log file for %q: %w", name, err)
+		return fmt.Errorf("could not create output log for %q: %w", name, err)
 	}
 	defer out.Close()
 
 	cmd.Stdout = out
 	cmd.Stderr = out
 
-	log.Printf("starting %q...", name)
+	log.Printf("starting %q on %s...", name, p.where())
 	err = cmd.Start()
 	if err != nil {
-		return fmt.Errorf("could not start %q: %w", name, err)
+		return fmt.Errorf("could not start %q on %s: %w", name, p.where(), err)
 	}
 
-	if doMon {
+	if doMon && p.rmt != nil {
+		f, err := os.Create(filepath.Join(dir, name+"-status.log"))
+		if err != nil {
+			return fmt.Errorf("could not create status log file for command %q: %w", name, err)
+		}
+		defer f.Close()
+
+		var (
+			done   = make(chan struct{})
+			polled = make(chan struct{})
+		)
+		go func() {
+			defer close(polled)
+			p.rmt.poll(f, name, freq, done)
+		}()
+		defer func() {
+			close(done)
+			<-polled
+		}()
+	}
+	if doMon && p.rmt == nil {
 		p, err := pmon.Monitor(cmd.Process.Pid)
 		if err != nil {
 			return fmt.Errorf("could not start monitoring %q (pid=%d): %w", name, cmd.Process.Pid, err)
@@ -149,9 +266,18 @@ func start(cmd *exec.Cmd, dir string, kill chan int, doMon bool, freq time.Durat
 		if err != nil {
 			return fmt.Errorf("could not kill %q: %+v", name, err)
 		}
+		if p.rmt != nil {
+			// killing the SSH client does not kill the remote process.
+			err = p.killall()
+			if err != nil {
+				log.Printf("could not kill %q on %s: %+v", name, p.where(), err)
+			}
+		}
+		// wait for the process output to be drained into its log.
+		<-errch
 	case err = <-errch:
 		if err != nil {
-			return fmt.Errorf("could not run %q: %w", name, err)
+			return fmt.Errorf("could not run %q on %s: %w", name, p.where(), err)
 		}
 	}
 
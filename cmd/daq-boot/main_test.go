@@ -5,11 +5,14 @@
 package main
 
 import (
+	"fmt"
 	"io/ioutil"
 	"os"
 	"os/exec"
 	"path/filepath"
+	"reflect"
 	"strconv"
+	"strings"
 	"testing"
 	"time"
 )
@@ -33,9 +36,23 @@ func TestRun(t *testing.T) {
 		}
 	}
 
+	// fake ssh, running the remote command locally.
+	ssh := filepath.Join(dir, "ssh")
+	err = ioutil.WriteFile(ssh, []byte(`#!/bin/sh
+while [ "$1" != "--" ]; do shift; done
+shift
+exec sh -c "$*"
+`), 0755)
+	if err != nil {
+		t.Fatalf("could not create fake ssh: %+v", err)
+	}
+	defer func(cmd string) { sshCmd = cmd }(sshCmd)
+	sshCmd = ssh
+
 	for _, tc := range []struct {
 		name string
 		cmds []*exec.Cmd
+		rmts map[string]remote
 		mon  bool
 		stop bool
 	}{
@@ -75,6 +92,19 @@ func TestRun(t *testing.T) {
 			stop: true,
 			mon:  true,
 		},
+		{
+			name: "remote-stop-pmon",
+			cmds: []*exec.Cmd{
+				exec.Command(cmds[0], "-timeout=10s"),
+				exec.Command(cmds[1], "-timeout=10s"),
+				exec.Command(cmds[2], "-timeout=10s"),
+			},
+			rmts: map[string]remote{
+				"run-cpu-1": {host: "daq01", port: "2222", opts: []string{"-oBatchMode=yes"}},
+			},
+			stop: true,
+			mon:  true,
+		},
 	} {
 		t.Run(tc.name, func(t *testing.T) {
 			dir, err := ioutil.TempDir("", "daq-boot-")
@@ -90,10 +120,224 @@ func TestRun(t *testing.T) {
 					stop <- os.Interrupt
 				}()
 			}
-			err = run(tc.mon, 1*time.Second, tc.cmds, dir, stop)
+			err = run(tc.mon, 1*time.Second, tc.cmds, tc.rmts, dir, logConfig{keep: 2}, stop)
 			if err != nil {
 				t.Fatalf("could not run processes: %+v", err)
 			}
+
+			if !tc.mon {
+				return
+			}
+			for name := range tc.rmts {
+				status, err := ioutil.ReadFile(filepath.Join(dir, name+"-status.log"))
+				if err != nil {
+					t.Fatalf("could not read status of %q: %+v", name, err)
+				}
+				if !strings.Contains(string(status), " running (pid=") {
+					t.Fatalf("invalid status of %q:\n%s", name, status)
+				}
+			}
+		})
+	}
+}
+
+func TestParseRemotes(t *testing.T) {
+	opts := []string{"-oBatchMode=yes"}
+	for _, tc := range []struct {
+		v     string
+		hosts string
+		want  map[string]remote
+		err   string
+	}{
+		{
+			v:    "",
+			want: map[string]remote{},
+		},
+		{
+			v: "dimwriter=daq01, dim-eda=root@eda01:2222",
+			want: map[string]remote{
+				"dimwriter": {host: "daq01", opts: opts},
+				"dim-eda":   {user: "root", host: "eda01", port: "2222", opts: opts},
+			},
+		},
+		{
+			v:   "dimwriter",
+			err: `invalid remote process "dimwriter" (want name=[user@]host[:port])`,
+		},
+		{
+			v:   "dimwriter=root@",
+			err: `invalid remote process "dimwriter=root@": missing host`,
+		},
+		{
+			v:   "dns=daq01,dns=daq02",
+			err: `duplicate remote process "dns"`,
+		},
+		{
+			v:     "dimwriter=daq01, dim-eda=root@eda01:2222",
+			hosts: "eda01=-i /root/.ssh/eda -oBatchMode=no",
+			want: map[string]remote{
+				"dimwriter": {host: "daq01", opts: opts},
+				"dim-eda": {
+					user: "root", host: "eda01", port: "2222",
+					opts: []string{"-i", "/root/.ssh/eda", "-oBatchMode=no", "-oBatchMode=yes"},
+				},
+			},
+		},
+		{
+			v:     "dimwriter=daq01",
+			hosts: "eda01=-i /root/.ssh/eda",
+			err:   `SSH options for unknown remote host "eda01"`,
+		},
+		{
+			v:     "dimwriter=daq01",
+			hosts: "-i /root/.ssh/eda",
+			err:   `invalid SSH host options "-i /root/.ssh/eda" (want host=opts)`,
+		},
+		{
+			v:     "dimwriter=daq01",
+			hosts: "daq01=-v; daq01=-q",
+			err:   `duplicate SSH options for host "daq01"`,
+		},
+	} {
+		t.Run(tc.v+";"+tc.hosts, func(t *testing.T) {
+			hosts, err := parseHostOpts(tc.hosts)
+			var got map[string]remote
+			if err == nil {
+				got, err = parseRemotes(tc.v, opts, hosts)
+			}
+			switch {
+			case err != nil && tc.err != "":
+				if got, want := err.Error(), tc.err; got != want {
+					t.Fatalf("invalid error:\ngot= %s\nwant=%s", got, want)
+				}
+				return
+			case err != nil:
+				t.Fatalf("could not parse remotes: %+v", err)
+			case tc.err != "":
+				t.Fatalf("expected an error")
+			}
+			if !reflect.DeepEqual(got, tc.want) {
+				t.Fatalf("invalid remotes:\ngot= %+v\nwant=%+v", got, tc.want)
+			}
 		})
 	}
+
+	r := remote{user: "root", host: "eda01", port: "2222", opts: opts}
+	cmd := r.command("dim-eda", "-v")
+	if got, want := cmd.Args, []string{sshCmd, "-oBatchMode=yes", "-p", "2222", "root@eda01", "--", "dim-eda", "-v"}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("invalid remote command:\ngot= %q\nwant=%q", got, want)
+	}
+
+	cmd = r.command("dim-eda", "-dir=/data/run 42", "", "it's;$(reboot)")
+	if got, want := cmd.Args, []string{sshCmd, "-oBatchMode=yes", "-p", "2222", "root@eda01", "--", "dim-eda", `'-dir=/data/run 42'`, `''`, `'it'\''s;$(reboot)'`}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("invalid quoted remote command:\ngot= %q\nwant=%q", got, want)
+	}
+
+	// the remote shell hands the arguments over unchanged.
+	args := []string{"-dir=/data/run 42", "", "it's;$(reboot)", "*"}
+	cmd = r.command(append([]string{"printf", "[%s]"}, args...)...)
+	out, err := exec.Command("sh", "-c", strings.Join(cmd.Args[6:], " ")).Output()
+	if err != nil {
+		t.Fatalf("could not run quoted command: %+v", err)
+	}
+	if got, want := string(out), "[-dir=/data/run 42][][it's;$(reboot)][*]"; got != want {
+		t.Fatalf("invalid remote arguments:\ngot= %q\nwant=%q", got, want)
+	}
+
+	_, err = newProcs([]*exec.Cmd{exec.Command("dns")}, map[string]remote{"dimdb": r})
+	if got, want := fmt.Sprint(err), `unknown remote process "dimdb"`; got != want {
+		t.Fatalf("invalid error: got=%q, want=%q", got, want)
+	}
+}
+
+func TestLogger(t *testing.T) {
+	dir, err := ioutil.TempDir("", "daq-boot-")
+	if err != nil {
+		t.Fatalf("could not create tmpdir: %+v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	fname := filepath.Join(dir, "proc.log")
+	err = ioutil.WriteFile(fname, []byte("previous run\n"), 0644)
+	if err != nil {
+		t.Fatalf("could not create previous log file: %+v", err)
+	}
+
+	l, err := newLogger(dir, "proc", logConfig{maxSize: 80, maxAge: time.Hour, keep: 2})
+	if err != nil {
+		t.Fatalf("could not create logger: %+v", err)
+	}
+	defer l.Close()
+
+	now := time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+	l.now = func() time.Time { return now }
+	l.born = now
+
+	read := func(name string) string {
+		t.Helper()
+		raw, err := ioutil.ReadFile(filepath.Join(dir, name))
+		if err != nil {
+			t.Fatalf("could not read %q: %+v", name, err)
+		}
+		return string(raw)
+	}
+
+	if got, want := read("proc.log.1"), "previous run\n"; got != want {
+		t.Fatalf("invalid previous log:\ngot= %q\nwant=%q", got, want)
+	}
+
+	_, err = l.Write([]byte("line-1\nline"))
+	if err != nil {
+		t.Fatalf("could not write: %+v", err)
+	}
+	_, err = l.Write([]byte("-2\n"))
+	if err != nil {
+		t.Fatalf("could not write: %+v", err)
+	}
+
+	want := "2020-01-02 03:04:05.000 [proc] line-1\n2020-01-02 03:04:05.000 [proc] line-2\n"
+	if got := read("proc.log"); got != want {
+		t.Fatalf("invalid log:\ngot= %q\nwant=%q", got, want)
+	}
+
+	// size-based rotation.
+	_, err = l.Write([]byte("line-3\n"))
+	if err != nil {
+		t.Fatalf("could not write: %+v", err)
+	}
+	if got, want := read("proc.log"), "2020-01-02 03:04:05.000 [proc] line-3\n"; got != want {
+		t.Fatalf("invalid log after size rotation:\ngot= %q\nwant=%q", got, want)
+	}
+	if got := read("proc.log.1"); got != want {
+		t.Fatalf("invalid rotated log:\ngot= %q\nwant=%q", got, want)
+	}
+	if got, want := read("proc.log.2"), "previous run\n"; got != want {
+		t.Fatalf("invalid rotated previous log:\ngot= %q\nwant=%q", got, want)
+	}
+
+	// time-based rotation.
+	now = now.Add(time.Hour)
+	_, err = l.Write([]byte("line-4"))
+	if err != nil {
+		t.Fatalf("could not write: %+v", err)
+	}
+	err = l.Close()
+	if err != nil {
+		t.Fatalf("could not close logger: %+v", err)
+	}
+
+	if got, want := read("proc.log"), "2020-01-02 04:04:05.000 [proc] line-4\n"; got != want {
+		t.Fatalf("invalid log after time rotation:\ngot= %q\nwant=%q", got, want)
+	}
+	if got, want := read("proc.log.2"), want; got != want {
+		t.Fatalf("invalid rotated log:\ngot= %q\nwant=%q", got, want)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "proc.log.3")); !os.IsNotExist(err) {
+		t.Fatalf("too many rotated log files: %+v", err)
+	}
+
+	_, err = l.Write([]byte("closed\n"))
+	if err == nil {
+		t.Fatalf("expected an error writing to a closed logger")
+	}
 }
@@ -0,0 +1,183 @@
+// Copyright 2020 The go-lpc Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"os/exec"
+	"strings"
+	"time"
+)
+
+// sshCmd is the command used to run processes on remote hosts.
+var sshCmd = "ssh"
+
+// remote describes a host where a DAQ process is run, over SSH.
+type remote struct {
+	user string   // user on the remote host ("": SSH default)
+	host string   // remote host
+	port string   // SSH port ("": SSH default)
+	opts []string // additional SSH options
+}
+
+// parseRemotes parses a comma-separated list of name=[user@]host[:port]
+// process placements, and returns the remote host of each process name.
+// opts are the SSH options used for all hosts, hosts the SSH options of
+// specific hosts (see parseHostOpts).
+func parseRemotes(v string, opts []string, hosts map[string][]string) (map[string]remote, error) {
+	rmts := make(map[string]remote)
+	used := make(map[string]bool, len(hosts))
+	var toks []string
+	if v != "" {
+		toks = strings.Split(v, ",")
+	}
+	for _, tok := range toks {
+		tok = strings.TrimSpace(tok)
+		i := strings.Index(tok, "=")
+		if i <= 0 || i == len(tok)-1 {
+			return nil, fmt.Errorf("invalid remote process %q (want name=[user@]host[:port])", tok)
+		}
+		name, dst := tok[:i], tok[i+1:]
+		if _, dup := rmts[name]; dup {
+			return nil, fmt.Errorf("duplicate remote process %q", name)
+		}
+
+		r := remote{host: dst, opts: opts}
+		if j := strings.Index(r.host, "@"); j >= 0 {
+			r.user, r.host = r.host[:j], r.host[j+1:]
+		}
+		if host, port, err := net.SplitHostPort(r.host); err == nil {
+			r.host, r.port = host, port
+		}
+		if r.host == "" {
+			return nil, fmt.Errorf("invalid remote process %q: missing host", tok)
+		}
+		if xs, ok := hosts[r.host]; ok {
+			// SSH uses the first value given for an option: per-host
+			// options come first to override the ones of all hosts.
+			r.opts = append(append([]string(nil), xs...), opts...)
+			used[r.host] = true
+		}
+		rmts[name] = r
+	}
+	for host := range hosts {
+		if !used[host] {
+			return nil, fmt.Errorf("SSH options for unknown remote host %q", host)
+		}
+	}
+	return rmts, nil
+}
+
+// parseHostOpts parses a semicolon-separated list of host=opts per-host
+// SSH options, where opts is a space-separated list of SSH options.
+func parseHostOpts(v string) (map[string][]string, error) {
+	hosts := make(map[string][]string)
+	if v == "" {
+		return hosts, nil
+	}
+	for _, tok := range strings.Split(v, ";") {
+		tok = strings.TrimSpace(tok)
+		i := strings.Index(tok, "=")
+		if i <= 0 {
+			return nil, fmt.Errorf("invalid SSH host options %q (want host=opts)", tok)
+		}
+		host := tok[:i]
+		if _, dup := hosts[host]; dup {
+			return nil, fmt.Errorf("duplicate SSH options for host %q", host)
+		}
+		hosts[host] = strings.Fields(tok[i+1:])
+	}
+	return hosts, nil
+}
+
+func (r remote) String() string {
+	dst := r.host
+	if r.user != "" {
+		dst = r.user + "@" + dst
+	}
+	return dst
+}
+
+// command returns the command running args on the remote host.
+// SSH joins its arguments into a single command line, interpreted by
+// the shell of the remote user: each argument is quoted so it reaches
+// the remote process unchanged.
+func (r remote) command(args ...string) *exec.Cmd {
+	xs := append([]string(nil), r.opts...)
+	if r.port != "" {
+		xs = append(xs, "-p", r.port)
+	}
+	xs = append(xs, r.String(), "--")
+	for _, arg := range args {
+		xs = append(xs, shellQuote(arg))
+	}
+	return exec.Command(sshCmd, xs...)
+}
+
+// pids returns the IDs of the processes named name running on the remote
+// host.
+func (r remote) pids(name string) ([]string, error) {
+	out, err := r.command("pgrep", "-x", name).Output()
+	if err != nil {
+		var eerr *exec.ExitError
+		if errors.As(err, &eerr) && eerr.ExitCode() == 1 {
+			// no process matched.
+			return nil, nil
+		}
+		return nil, err
+	}
+	return strings.Fields(string(out)), nil
+}
+
+// poll polls, every freq and until done is closed, the status of the
+// process name on the remote host.
+// Statuses are written to w and their changes are logged.
+func (r remote) poll(w io.Writer, name string, freq time.Duration, done <-chan struct{}) {
+	tck := time.NewTicker(freq)
+	defer tck.Stop()
+
+	last := ""
+	for {
+		var state, status string
+		pids, err := r.pids(name)
+		switch {
+		case err != nil:
+			state = "unknown"
+			status = fmt.Sprintf("unknown (%v)", err)
+		case len(pids) == 0:
+			state = "stopped"
+			status = state
+		default:
+			state = "running"
+			status = fmt.Sprintf("running (pid=%s)", strings.Join(pids, ","))
+		}
+		fmt.Fprintf(w, "%s %s\n", time.Now().UTC().Format(time.RFC3339), status)
+		if state != last {
+			log.Printf("%q on %s: %s", name, r, status)
+			last = state
+		}
+
+		select {
+		case <-done:
+			return
+		case <-tck.C:
+		}
+	}
+}
+
+// shellQuote quotes s for a POSIX shell.
+func shellQuote(s string) string {
+	if s != "" && strings.Trim(s, shellSafe) == "" {
+		return s
+	}
+	return "'" + strings.Replace(s, "'", `'\''`, -1) + "'"
+}
+
+// shellSafe is the set of characters that need no quoting in a POSIX shell.
+const shellSafe = "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789@%+=:,./_-"
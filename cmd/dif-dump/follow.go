@@ -0,0 +1,72 @@
+// Copyright 2020 The go-lpc Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"time"
+)
+
+// followPoll is the interval between checks for new data at the end of a
+// followed file.
+var followPoll = 250 * time.Millisecond
+
+// follower reads a growing file, with tail -f semantics: at the end of the
+// file, it waits for more data to be written instead of returning io.EOF,
+// until done is closed.
+//
+// Partially written DIF blocks are thus decoded once they are complete.
+type follower struct {
+	f    *os.File
+	done <-chan struct{}
+	wait func() // called before waiting for more data, e.g. to flush outputs
+}
+
+func (r *follower) Read(p []byte) (int, error) {
+	for {
+		n, err := r.f.Read(p)
+		if n > 0 || !errors.Is(err, io.EOF) {
+			return n, err
+		}
+
+		err = r.truncated()
+		if err != nil {
+			return 0, err
+		}
+
+		if r.wait != nil {
+			r.wait()
+		}
+		select {
+		case <-r.done:
+			return 0, io.EOF
+		case <-time.After(followPoll):
+		}
+	}
+}
+
+func (r *follower) Seek(offset int64, whence int) (int64, error) {
+	return r.f.Seek(offset, whence)
+}
+
+// truncated returns an error if the file was truncated below the current
+// read offset, e.g. when it is re-created for a new run.
+func (r *follower) truncated() error {
+	pos, err := r.f.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return fmt.Errorf("could not retrieve file offset: %w", err)
+	}
+	fi, err := r.f.Stat()
+	if err != nil {
+		return fmt.Errorf("could not stat file: %w", err)
+	}
+	if fi.Size() < pos {
+		return fmt.Errorf("file truncated (size=%d, offset=%d)", fi.Size(), pos)
+	}
+	return nil
+}
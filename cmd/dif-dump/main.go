@@ -6,6 +6,15 @@
 //
 // Usage: dif-dump [OPTIONS] FILE1 [FILE2 [FILE3 ...]]
 //
+// With -json, dif-dump decodes the files concurrently (see -j) and
+// displays a JSON report of the statistics of each file (size, number of
+// DIF blocks and frames, DIF IDs, decoding error) and their totals.
+// dif-dump exits with a non-zero status if any file could not be decoded.
+//
+// With -f, dif-dump follows a single file being written, as tail -f does:
+// at the end of the file, it waits for more data and resumes decoding once
+// the next DIF block is complete, until interrupted.
+//
 // Example:
 //
 //  $> dif-dump ./testdata/Event_425050855_109_109_183
@@ -30,14 +39,28 @@ import (
 	"io"
 	"log"
 	"os"
+	"os/signal"
+	"runtime"
+	"syscall"
 
+	"github.com/go-lpc/mim/internal/cliconf"
 	"github.com/go-lpc/mim/internal/eformat"
+	"github.com/go-lpc/mim/internal/zseek"
 )
 
 const usage = `dif-dump decodes and displays DIF data files.
 
 Usage: dif-dump [OPTIONS] FILE1 [FILE2 [FILE3 ...]]
 
+With -json, dif-dump decodes the files concurrently (see -j) and
+displays a JSON report of the statistics of each file (size, number of
+DIF blocks and frames, DIF IDs, decoding error) and their totals.
+dif-dump exits with a non-zero status if any file could not be decoded.
+
+With -f, dif-dump follows a single file being written, as tail -f does:
+at the end of the file, it waits for more data and resumes decoding once
+the next DIF block is complete, until interrupted.
+
 Example:
 
  $> dif-dump ./testdata/Event_425050855_109_109_183
@@ -66,7 +89,10 @@ func xmain(w io.Writer, args []string) {
 	var (
 		fset = flag.NewFlagSet("dif", flag.ExitOnError)
 
-		eda = fset.Bool("eda", false, "enable EDA hack")
+		eda   = fset.Bool("eda", false, "enable EDA hack")
+		rep   = fset.Bool("json", false, "display a JSON report of the files statistics instead of their content")
+		njobs = fset.Int("j", runtime.NumCPU(), "number of files processed concurrently (with -json)")
+		follw = fset.Bool("f", false, "follow the file as it grows, waiting for more data at its end")
 	)
 
 	fset.Usage = func() {
@@ -74,6 +100,8 @@ func xmain(w io.Writer, args []string) {
 		fset.PrintDefaults()
 	}
 
+	cliconf.Version(fset)
+
 	err := fset.Parse(args)
 	if err != nil {
 		log.Fatalf("could not parse input arguments: %+v", err)
@@ -84,15 +112,54 @@ func xmain(w io.Writer, args []string) {
 		log.Fatalf("missing path to input DIF file")
 	}
 
+	if *follw {
+		switch {
+		case *rep:
+			log.Fatalf("-f and -json are mutually exclusive")
+		case fset.NArg() > 1:
+			log.Fatalf("-f only follows a single file")
+		}
+
+		done := make(chan struct{})
+		sig := make(chan os.Signal, 1)
+		signal.Notify(sig, os.Interrupt, syscall.SIGTERM)
+		defer signal.Stop(sig)
+		go func() {
+			<-sig
+			close(done)
+		}()
+
+		fname := fset.Arg(0)
+		err := process(w, fname, *eda, done)
+		if err != nil {
+			log.Fatalf("could not follow file %q: %+v", fname, err)
+		}
+		return
+	}
+
+	if *rep {
+		n, err := writeReport(w, fset.Args(), *eda, *njobs)
+		if err != nil {
+			log.Fatalf("could not write report: %+v", err)
+		}
+		if n > 0 {
+			log.Fatalf("could not decode %d file(s)", n)
+		}
+		return
+	}
+
 	for _, fname := range fset.Args() {
-		err := process(w, fname, *eda)
+		err := process(w, fname, *eda, nil)
 		if err != nil {
 			log.Fatalf("could not dump file %q: %+v", fname, err)
 		}
 	}
 }
 
-func process(w io.Writer, fname string, eda bool) error {
+// process decodes and displays the DIF blocks of the provided file.
+// With a non-nil follow channel, process waits for more data at the end of
+// the file until follow is closed.
+func process(w io.Writer, fname string, eda bool, follow <-chan struct{}) error {
 	wbuf := bufio.NewWriter(w)
 	defer wbuf.Flush()
 
@@ -102,18 +169,68 @@ func process(w io.Writer, fname string, eda bool) error {
 	}
 	defer f.Close()
 
-	dec := eformat.NewDecoder(0, f)
+	var rs io.ReadSeeker = f
+	if follow != nil {
+		rs = &follower{
+			f:    f,
+			done: follow,
+			wait: func() { _ = wbuf.Flush() },
+		}
+	}
+
+	r, err := zseek.Open(rs)
+	if err != nil {
+		return fmt.Errorf("could not open %q: %w", fname, err)
+	}
+
+	dec := eformat.NewDecoder(0, r)
 	dec.IsEDA = eda
+	var (
+		d   eformat.DIF
+		set = false
+
+		prov eformat.Provenance
+		// printProv displays provenance trailers, whenever they change.
+		printProv = func() {
+			p, ok := dec.Provenance()
+			if !ok || p == prov {
+				return
+			}
+			prov = p
+			fmt.Fprintf(wbuf, "=== Provenance (v%d) ===\n", p.Version)
+			fmt.Fprintf(wbuf, "Board:       % 10d\n", p.Board)
+			fmt.Fprintf(wbuf, "Slot:        % 10d\n", p.Slot)
+			fmt.Fprintf(wbuf, "Firmware:    0x%08x\n", p.Firmware)
+			fmt.Fprintf(wbuf, "Software:    %s\n", p.Software)
+		}
+	)
 loop:
 	for {
-		var d eformat.DIF
 		err := dec.Decode(&d)
+		printProv()
 		if err != nil {
 			if errors.Is(err, io.EOF) {
 				break loop
 			}
+			if follow != nil && errors.Is(err, io.ErrUnexpectedEOF) {
+				// interrupted while the last DIF block was being written.
+				break loop
+			}
 			return fmt.Errorf("could not decode DIF: %w", err)
 		}
+		if s, ok := dec.Settings(); ok && !set {
+			set = true
+			fmt.Fprintf(wbuf, "=== Settings (v%d) ===\n", s.Version)
+			fmt.Fprintf(wbuf, "Board:       % 10d\n", s.Board)
+			fmt.Fprintf(wbuf, "Run:         % 10d\n", s.Run)
+			fmt.Fprintf(wbuf, "RFM mask:    % 10d\n", s.RFM)
+			fmt.Fprintf(wbuf, "RShaper:     % 10d\n", s.RShaper)
+			fmt.Fprintf(wbuf, "Thr. delta:  % 10d\n", s.Delta)
+			fmt.Fprintf(wbuf, "HR hash:     0x%016x\n", s.HRHash)
+			if s.Version >= 2 {
+				fmt.Fprintf(wbuf, "Firmware:    0x%08x\n", s.Firmware)
+			}
+		}
 		fmt.Fprintf(wbuf, "=== DIF-ID 0x%x ===\n", d.Header.ID)
 		fmt.Fprintf(wbuf, "DIF trigger: % 10d\n", d.Header.DTC)
 		fmt.Fprintf(wbuf, "ACQ trigger: % 10d\n", d.Header.ATC)
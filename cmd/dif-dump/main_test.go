@@ -5,15 +5,20 @@
 package main
 
 import (
+	"bytes"
+	"encoding/json"
 	"fmt"
 	"io"
 	"io/ioutil"
 	"os"
 	"path/filepath"
+	"reflect"
 	"strings"
 	"testing"
+	"time"
 
 	"github.com/go-lpc/mim/internal/eformat"
+	"github.com/go-lpc/mim/internal/zseek"
 )
 
 func TestDump(t *testing.T) {
@@ -73,6 +78,8 @@ func TestProcess(t *testing.T) {
 	for _, tc := range []struct {
 		name string
 		eda  bool
+		zstd bool
+		prov *eformat.Provenance
 		data eformat.DIF
 		want string
 		err  error
@@ -113,6 +120,70 @@ Time DIF:       1122867
 Frames:               2
   hroc=0x01 BCID= 1710876 0a0102030405060708090a0b0c0d0e0f
   hroc=0x02 BCID= 2763564 0b15161718191a1b1c1dd2d3d4d5d6d7
+`,
+		},
+		{
+			name: "simple-dif-zstd",
+			zstd: true,
+			data: eformat.DIF{
+				Header: eformat.GlobalHeader{
+					ID:        0x42,
+					DTC:       10,
+					ATC:       11,
+					GTC:       12,
+					AbsBCID:   0x0000112233445566,
+					TimeDIFTC: 0x00112233,
+				},
+				Frames: []eformat.Frame{
+					{
+						Header: 1,
+						BCID:   0x001a1b1c,
+						Data:   [16]uint8{0xa, 1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11, 12, 13, 14, 15},
+					},
+					{
+						Header: 2,
+						BCID:   0x002a2b2c,
+						Data: [16]uint8{
+							0xb, 21, 22, 23, 24, 25, 26, 27, 28, 29,
+							210, 211, 212, 213, 214, 215,
+						},
+					},
+				},
+			},
+			want: `=== DIF-ID 0x42 ===
+DIF trigger:         10
+ACQ trigger:         11
+Gbl trigger:         12
+Abs BCID:     18838586676582
+Time DIF:       1122867
+Frames:               2
+  hroc=0x01 BCID= 1710876 0a0102030405060708090a0b0c0d0e0f
+  hroc=0x02 BCID= 2763564 0b15161718191a1b1c1dd2d3d4d5d6d7
+`,
+		},
+		{
+			name: "simple-dif-provenance",
+			prov: &eformat.Provenance{Board: 2, Slot: 3, Firmware: 0x01000000, Software: "v0.5.0"},
+			data: eformat.DIF{
+				Header: eformat.GlobalHeader{
+					ID:  0x42,
+					DTC: 10,
+					ATC: 11,
+					GTC: 12,
+				},
+			},
+			want: `=== DIF-ID 0x42 ===
+DIF trigger:         10
+ACQ trigger:         11
+Gbl trigger:         12
+Abs BCID:             0
+Time DIF:             0
+Frames:               0
+=== Provenance (v1) ===
+Board:                2
+Slot:                 3
+Firmware:    0x01000000
+Software:    v0.5.0
 `,
 		},
 		{
@@ -131,8 +202,23 @@ Frames:               2
 			defer f.Close()
 
 			switch {
+			case tc.err == nil && tc.zstd:
+				zw, err := zseek.NewWriter(f, 3)
+				if err != nil {
+					t.Fatalf("could not create zstd writer: %+v", err)
+				}
+				err = eformat.NewEncoder(zw).Encode(&tc.data)
+				if err != nil {
+					t.Fatalf("could not encode dif: %+v", err)
+				}
+				err = zw.Close()
+				if err != nil {
+					t.Fatalf("could not close zstd writer: %+v", err)
+				}
 			case tc.err == nil:
-				err = eformat.NewEncoder(f).Encode(&tc.data)
+				enc := eformat.NewEncoder(f)
+				enc.Provenance = tc.prov
+				err = enc.Encode(&tc.data)
 				if err != nil {
 					t.Fatalf("could not encode dif: %+v", err)
 				}
@@ -149,7 +235,7 @@ Frames:               2
 			}
 
 			out := new(strings.Builder)
-			err = process(out, fname, tc.eda)
+			err = process(out, fname, tc.eda, nil)
 			switch {
 			case err != nil && tc.err != nil:
 				if got, want := err.Error(), tc.err.Error(); got != want {
@@ -167,3 +253,175 @@ Frames:               2
 		})
 	}
 }
+
+func TestReport(t *testing.T) {
+	tmp, err := ioutil.TempDir("", "mim-dif-dump-")
+	if err != nil {
+		t.Fatalf("could not create tmp dir: %+v", err)
+	}
+	defer os.RemoveAll(tmp)
+
+	var fnames []string
+	for i, n := range []int{3, 2, -1} {
+		fname := filepath.Join(tmp, fmt.Sprintf("dif-%d.raw", i))
+		f, err := os.Create(fname)
+		if err != nil {
+			t.Fatalf("could not create file: %+v", err)
+		}
+		nblks := n
+		if n < 0 {
+			nblks = 3
+		}
+		enc := eformat.NewEncoder(f)
+		for j := 0; j < nblks; j++ {
+			err = enc.Encode(&eformat.DIF{
+				Header: eformat.GlobalHeader{ID: uint8(0x42 + j%2), DTC: uint32(j)},
+				Frames: make([]eformat.Frame, j+1),
+			})
+			if err != nil {
+				t.Fatalf("could not encode DIF: %+v", err)
+			}
+		}
+		if n < 0 {
+			// file truncated in the middle of a hardroc frame.
+			fi, err := f.Stat()
+			if err != nil {
+				t.Fatalf("could not stat file: %+v", err)
+			}
+			err = f.Truncate(fi.Size() - 10)
+			if err != nil {
+				t.Fatalf("could not truncate file: %+v", err)
+			}
+		}
+		err = f.Close()
+		if err != nil {
+			t.Fatalf("could not close file: %+v", err)
+		}
+		fnames = append(fnames, fname)
+	}
+	fnames = append(fnames, filepath.Join(tmp, "not-there.raw"))
+
+	out := new(strings.Builder)
+	n, err := writeReport(out, fnames, false, 2)
+	if err != nil {
+		t.Fatalf("could not write report: %+v", err)
+	}
+	if n != 2 {
+		t.Fatalf("invalid number of failed files: got=%d, want=2", n)
+	}
+
+	var rep report
+	err = json.Unmarshal([]byte(out.String()), &rep)
+	if err != nil {
+		t.Fatalf("could not decode report: %+v\n%s", err, out.String())
+	}
+
+	type stat struct {
+		blocks, frames int64
+		difs           []int
+		err            bool
+	}
+	want := []stat{
+		{blocks: 3, frames: 6, difs: []int{0x42, 0x43}},
+		{blocks: 2, frames: 3, difs: []int{0x42, 0x43}},
+		{blocks: 2, frames: 3, difs: []int{0x42, 0x43}, err: true},
+		{difs: []int{}, err: true},
+	}
+	if len(rep.Files) != len(want) {
+		t.Fatalf("invalid number of files: got=%d, want=%d", len(rep.Files), len(want))
+	}
+	var size int64
+	for i, st := range rep.Files {
+		got := stat{st.Blocks, st.Frames, st.DIFs, st.Err != ""}
+		if st.Name != fnames[i] || !reflect.DeepEqual(got, want[i]) {
+			t.Fatalf("invalid stats for file %d:\ngot= %+v\nwant=%+v", i, st, want[i])
+		}
+		size += st.Size
+	}
+
+	if got, want := rep.Totals, (totals{Files: 4, Failed: 2, Size: size, Blocks: 7, Frames: 12}); got != want {
+		t.Fatalf("invalid totals:\ngot= %+v\nwant=%+v", got, want)
+	}
+}
+
+func TestFollow(t *testing.T) {
+	tmp, err := ioutil.TempDir("", "mim-dif-dump-")
+	if err != nil {
+		t.Fatalf("could not create tmp dir: %+v", err)
+	}
+	defer os.RemoveAll(tmp)
+
+	defer func(poll time.Duration) {
+		followPoll = poll
+	}(followPoll)
+	followPoll = time.Millisecond
+
+	var raw bytes.Buffer
+	enc := eformat.NewEncoder(&raw)
+	for i := 0; i < 3; i++ {
+		err = enc.Encode(&eformat.DIF{
+			Header: eformat.GlobalHeader{ID: 0x42, DTC: uint32(i)},
+			Frames: make([]eformat.Frame, i+1),
+		})
+		if err != nil {
+			t.Fatalf("could not encode DIF: %+v", err)
+		}
+	}
+	blks := raw.Bytes()
+
+	fname := filepath.Join(tmp, "follow.raw")
+	f, err := os.Create(fname)
+	if err != nil {
+		t.Fatalf("could not create file: %+v", err)
+	}
+	defer f.Close()
+
+	var (
+		out  = new(strings.Builder)
+		done = make(chan struct{})
+		errc = make(chan error)
+	)
+	go func() {
+		errc <- process(out, fname, false, done)
+	}()
+
+	// write the DIF blocks in chunks, splitting them in the middle.
+	const chunk = 50
+	for beg := 0; beg < len(blks); beg += chunk {
+		end := beg + chunk
+		if end > len(blks) {
+			end = len(blks)
+		}
+		_, err = f.Write(blks[beg:end])
+		if err != nil {
+			t.Fatalf("could not write DIF data: %+v", err)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	close(done)
+
+	err = <-errc
+	if err != nil {
+		t.Fatalf("could not follow file: %+v", err)
+	}
+
+	if got, want := strings.Count(out.String(), "=== DIF-ID 0x42 ==="), 3; got != want {
+		t.Fatalf("invalid number of DIF blocks: got=%d, want=%d\n%s", got, want, out.String())
+	}
+	if got, want := strings.Count(out.String(), "hroc=0x00"), 6; got != want {
+		t.Fatalf("invalid number of frames: got=%d, want=%d\n%s", got, want, out.String())
+	}
+
+	t.Run("truncated", func(t *testing.T) {
+		err := f.Truncate(10)
+		if err != nil {
+			t.Fatalf("could not truncate file: %+v", err)
+		}
+
+		r := follower{f: f, done: make(chan struct{})}
+		_, err = r.Read(make([]byte, 10))
+		if got, want := fmt.Sprint(err), fmt.Sprintf("file truncated (size=10, offset=%d)", len(blks)); got != want {
+			t.Fatalf("invalid error:\ngot= %s\nwant=%s", got, want)
+		}
+	})
+}
@@ -0,0 +1,141 @@
+// Copyright 2020 The go-lpc Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"sync"
+
+	"github.com/go-lpc/mim/internal/eformat"
+	"github.com/go-lpc/mim/internal/zseek"
+)
+
+// report is the machine-readable summary of a set of DIF files.
+type report struct {
+	Files  []fileStats `json:"files"`
+	Totals totals      `json:"totals"`
+}
+
+// fileStats holds the statistics of a single DIF file.
+type fileStats struct {
+	Name   string `json:"name"`
+	Size   int64  `json:"size"`   // size of the file, in bytes
+	Blocks int64  `json:"blocks"` // number of decoded DIF blocks
+	Frames int64  `json:"frames"` // number of decoded hardroc frames
+	DIFs   []int  `json:"difs"`   // sorted list of DIF IDs
+	Err    string `json:"error,omitempty"`
+}
+
+type totals struct {
+	Files  int   `json:"files"`
+	Failed int   `json:"failed"` // number of files that could not be fully decoded
+	Size   int64 `json:"size"`
+	Blocks int64 `json:"blocks"`
+	Frames int64 `json:"frames"`
+}
+
+// writeReport decodes the provided files, with at most njobs files
+// processed concurrently, and writes a JSON report to w.
+// writeReport returns the number of files that could not be fully decoded.
+func writeReport(w io.Writer, fnames []string, eda bool, njobs int) (int, error) {
+	if njobs < 1 {
+		njobs = 1
+	}
+
+	var (
+		rep = report{Files: make([]fileStats, len(fnames))}
+		wg  sync.WaitGroup
+		sem = make(chan struct{}, njobs)
+	)
+	for i, fname := range fnames {
+		wg.Add(1)
+		sem <- struct{}{}
+		go func(i int, fname string) {
+			defer wg.Done()
+			defer func() { <-sem }()
+			rep.Files[i] = statFile(fname, eda)
+		}(i, fname)
+	}
+	wg.Wait()
+
+	for _, st := range rep.Files {
+		rep.Totals.Files++
+		if st.Err != "" {
+			rep.Totals.Failed++
+		}
+		rep.Totals.Size += st.Size
+		rep.Totals.Blocks += st.Blocks
+		rep.Totals.Frames += st.Frames
+	}
+
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	err := enc.Encode(rep)
+	if err != nil {
+		return rep.Totals.Failed, fmt.Errorf("could not encode report: %w", err)
+	}
+	return rep.Totals.Failed, nil
+}
+
+// statFile decodes the provided DIF file and collects its statistics.
+// Decoding errors are recorded in the returned statistics.
+func statFile(fname string, eda bool) fileStats {
+	st := fileStats{Name: fname, DIFs: []int{}}
+	err := st.fill(fname, eda)
+	if err != nil {
+		st.Err = err.Error()
+	}
+	return st
+}
+
+func (st *fileStats) fill(fname string, eda bool) error {
+	f, err := os.Open(fname)
+	if err != nil {
+		return fmt.Errorf("could not open %q: %w", fname, err)
+	}
+	defer f.Close()
+
+	fi, err := f.Stat()
+	if err != nil {
+		return fmt.Errorf("could not stat %q: %w", fname, err)
+	}
+	st.Size = fi.Size()
+
+	r, err := zseek.Open(f)
+	if err != nil {
+		return fmt.Errorf("could not open %q: %w", fname, err)
+	}
+
+	var (
+		dec  = eformat.NewDecoder(0, r)
+		d    eformat.DIF
+		difs = make(map[int]struct{})
+	)
+	dec.IsEDA = eda
+	defer func() {
+		for id := range difs {
+			st.DIFs = append(st.DIFs, id)
+		}
+		sort.Ints(st.DIFs)
+	}()
+
+	for {
+		err := dec.Decode(&d)
+		if err != nil {
+			if errors.Is(err, io.EOF) {
+				return nil
+			}
+			return fmt.Errorf("could not decode DIF block %d: %w", st.Blocks, err)
+		}
+		st.Blocks++
+		st.Frames += int64(len(d.Frames))
+		difs[int(d.Header.ID)] = struct{}{}
+	}
+}
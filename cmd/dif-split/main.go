@@ -4,19 +4,40 @@
 
 // Command dif-split splits a DIF/EDA binary file into n DIF files,
 // one per DIF-ID.
+//
+// DIF blocks are decoded sequentially and routed to one encoder
+// goroutine per DIF-ID, so the order of the blocks is preserved within
+// each output file.
+// dif-split regularly records the input offset and the sizes of the
+// output files into an index file (the output file name with an ".idx"
+// suffix). An interrupted split can be resumed from that index with
+// the -resume flag.
+//
+// Once the split is complete, dif-split writes a JSON manifest (the output
+// file name with a ".json" suffix) listing, for each output file, its
+// DIF-ID, its number of DIF blocks, its GTC range and its size, so that
+// DIF data can be located without scanning the output files.
 package main // import "github.com/go-lpc/mim/cmd/dif-split"
 
 import (
+	"bufio"
+	"encoding/json"
 	"errors"
 	"flag"
 	"fmt"
 	"io"
+	"io/ioutil"
 	"log"
 	"os"
 	"path/filepath"
+	"sort"
 	"strings"
+	"sync"
+	"time"
 
+	"github.com/go-lpc/mim/internal/cliconf"
 	"github.com/go-lpc/mim/internal/eformat"
+	"github.com/go-lpc/mim/internal/zseek"
 )
 
 var (
@@ -31,8 +52,11 @@ func xmain(args []string) {
 	var (
 		fset = flag.NewFlagSet("dif", flag.ExitOnError)
 
-		oname = fset.String("o", "out.raw", "path to output DIF file")
-		eda   = fset.Bool("eda", false, "enable EDA hack")
+		oname  = fset.String("o", "out.raw", "path to output DIF file")
+		eda    = fset.Bool("eda", false, "enable EDA hack")
+		resume = fset.Bool("resume", false, "resume an interrupted split from its index file")
+		ckpt   = fset.Int("ckpt", 10000, "number of DIF blocks between two index checkpoints")
+		freq   = fset.Duration("progress", 5*time.Second, "interval between progress reports (0 to disable)")
 	)
 
 	fset.Usage = func() {
@@ -40,12 +64,15 @@ func xmain(args []string) {
 
 ex:
  $> dif-split -o out.raw ./input.eda.raw
+ $> dif-split -o out.raw -resume ./input.eda.raw
 
 options:
 `)
 		fset.PrintDefaults()
 	}
 
+	cliconf.Version(fset)
+
 	err := fset.Parse(args)
 	if err != nil {
 		log.Fatalf("could not parse input arguments: %+v", err)
@@ -61,60 +88,539 @@ options:
 		msg.Fatalf("invalid output DIF raw file")
 	}
 
+	cfg := config{
+		eda:    *eda,
+		resume: *resume,
+		ckpt:   *ckpt,
+		freq:   *freq,
+	}
+
 	for _, arg := range fset.Args() {
-		err := process(*oname, *eda, arg)
+		err := process(*oname, arg, cfg)
 		if err != nil {
 			msg.Fatalf("could not split DIF file %q: %+v", arg, err)
 		}
 	}
 }
 
-func process(oname string, isEDA bool, fname string) error {
+type config struct {
+	eda    bool          // enable EDA hack
+	resume bool          // resume from index file
+	ckpt   int           // number of blocks between checkpoints
+	freq   time.Duration // interval between progress reports
+}
+
+func process(oname, fname string, cfg config) error {
 	f, err := os.Open(fname)
 	if err != nil {
 		return fmt.Errorf("could not open EDA file: %w", err)
 	}
 	defer f.Close()
 
-	out := make(map[uint8]*eformat.Encoder)
+	fi, err := f.Stat()
+	if err != nil {
+		return fmt.Errorf("could not stat EDA file: %w", err)
+	}
+
+	r, err := zseek.Open(f)
+	if err != nil {
+		return fmt.Errorf("could not open EDA file: %w", err)
+	}
+
+	// offsets of the index file are offsets into the decompressed stream.
+	size := fi.Size()
+	if zr, ok := r.(*zseek.Reader); ok {
+		size = zr.Size()
+	}
+
+	spl := newSplitter(oname)
+	defer spl.close()
+
+	var beg int64
+	if cfg.resume {
+		idx, err := readIndex(spl.index)
+		switch {
+		case err == nil:
+			beg = idx.offset
+			msg.Printf("resuming split at offset %d...", beg)
+			err = spl.reopen(idx)
+			if err != nil {
+				return fmt.Errorf("could not reopen output files: %w", err)
+			}
+			_, err = r.Seek(beg, io.SeekStart)
+			if err != nil {
+				return fmt.Errorf("could not seek EDA file: %w", err)
+			}
+		case errors.Is(err, os.ErrNotExist):
+			msg.Printf("no index file %q: starting from scratch", spl.index)
+		default:
+			return fmt.Errorf("could not read index file: %w", err)
+		}
+	}
 
-	dec := eformat.NewDecoder(0, f)
-	dec.IsEDA = isEDA
+	cr := &countingReader{r: bufio.NewReader(r), n: beg}
+	dec := eformat.NewDecoder(0, cr)
+	dec.IsEDA = cfg.eda
 
+	var (
+		nblk  = 0
+		start = time.Now()
+		last  = start
+	)
 loop:
 	for {
-		var d eformat.DIF
-		err := dec.Decode(&d)
+		d := spl.get()
+		err := dec.Decode(d)
 		if err != nil {
+			spl.put(d)
 			if errors.Is(err, io.EOF) {
 				break loop
 			}
 			return fmt.Errorf("could not decode DIF: %w", err)
 		}
 
-		enc, ok := out[d.Header.ID]
-		if !ok {
-			oid := outFileFrom(oname, d.Header.ID)
-			msg.Printf("creating output file %q...", oid)
-			o, err := os.Create(oid)
+		err = spl.send(d)
+		if err != nil {
+			return fmt.Errorf("could not encode DIF: %w", err)
+		}
+		nblk++
+
+		if cfg.ckpt > 0 && nblk%cfg.ckpt == 0 {
+			err = spl.checkpoint(cr.n)
 			if err != nil {
-				return fmt.Errorf("could not create output file: %w", err)
+				return fmt.Errorf("could not checkpoint split: %w", err)
 			}
-			defer o.Close()
+		}
+
+		if cfg.freq > 0 && time.Since(last) >= cfg.freq {
+			last = time.Now()
+			msg.Printf("%s %d blocks", progress(cr.n, size), nblk)
+		}
+	}
+
+	err = spl.checkpoint(cr.n)
+	if err != nil {
+		return fmt.Errorf("could not checkpoint split: %w", err)
+	}
+
+	err = spl.close()
+	if err != nil {
+		return fmt.Errorf("could not close output files: %w", err)
+	}
+
+	err = spl.writeManifest(fname)
+	if err != nil {
+		return fmt.Errorf("could not write manifest: %w", err)
+	}
+
+	if cfg.freq > 0 {
+		msg.Printf("%s %d blocks (%v)", progress(cr.n, size), nblk, time.Since(start).Round(time.Millisecond))
+	}
+
+	return nil
+}
+
+// splitter routes DIF blocks to per-DIF-ID output files.
+type splitter struct {
+	oname    string
+	index    string // path to the index file
+	manifest string // path to the manifest file
+	idx      index  // last checkpoint
+	outs     map[uint8]*output
+	pool     sync.Pool
+	errc     chan error
+	done     bool
+}
+
+func newSplitter(oname string) *splitter {
+	return &splitter{
+		oname:    oname,
+		index:    oname + ".idx",
+		manifest: oname + ".json",
+		outs:     make(map[uint8]*output),
+		pool: sync.Pool{
+			New: func() interface{} { return new(eformat.DIF) },
+		},
+		errc: make(chan error, 1),
+	}
+}
+
+func (spl *splitter) get() *eformat.DIF {
+	return spl.pool.Get().(*eformat.DIF)
+}
 
-			enc = eformat.NewEncoder(o)
-			out[d.Header.ID] = enc
+func (spl *splitter) put(d *eformat.DIF) {
+	spl.pool.Put(d)
+}
+
+// send routes the DIF block to the encoder of its DIF-ID.
+// The DIF block is given back to the pool once encoded.
+func (spl *splitter) send(d *eformat.DIF) error {
+	select {
+	case err := <-spl.errc:
+		spl.put(d)
+		return err
+	default:
+	}
+
+	out, ok := spl.outs[d.Header.ID]
+	if !ok {
+		oid := outFileFrom(spl.oname, d.Header.ID)
+		msg.Printf("creating output file %q...", oid)
+		f, err := os.Create(oid)
+		if err != nil {
+			spl.put(d)
+			return fmt.Errorf("could not create output file: %w", err)
 		}
+		out = spl.start(d.Header.ID, f, stats{})
+	}
+	out.ch <- item{dif: d}
+	return nil
+}
 
-		err = enc.Encode(&d)
+// reopen reopens the output files recorded in the provided index,
+// discarding any data written after the index checkpoint.
+func (spl *splitter) reopen(idx index) error {
+	for _, id := range idx.ids() {
+		st := idx.outs[id]
+		size := st.size
+		oid := outFileFrom(spl.oname, id)
+		f, err := os.OpenFile(oid, os.O_WRONLY, 0644)
 		if err != nil {
-			return fmt.Errorf("could not encode DIF: %w", err)
+			return fmt.Errorf("could not open output file: %w", err)
+		}
+		err = f.Truncate(size)
+		if err != nil {
+			_ = f.Close()
+			return fmt.Errorf("could not truncate output file %q: %w", oid, err)
+		}
+		_, err = f.Seek(size, io.SeekStart)
+		if err != nil {
+			_ = f.Close()
+			return fmt.Errorf("could not seek output file %q: %w", oid, err)
+		}
+		spl.start(id, f, st)
+	}
+	return nil
+}
+
+func (spl *splitter) start(id uint8, f *os.File, st stats) *output {
+	cw := &countingWriter{w: f, n: st.size}
+	w := bufio.NewWriter(cw)
+	out := &output{
+		id:   id,
+		f:    f,
+		cw:   cw,
+		w:    w,
+		enc:  eformat.NewEncoder(w),
+		st:   st,
+		ch:   make(chan item, 256),
+		quit: make(chan struct{}),
+	}
+	spl.outs[id] = out
+	go out.run(spl)
+	return out
+}
+
+// checkpoint waits for all the DIF blocks routed so far to be written
+// out and records the input offset and output file sizes in the index.
+func (spl *splitter) checkpoint(offset int64) error {
+	idx := index{
+		offset: offset,
+		outs:   make(map[uint8]stats, len(spl.outs)),
+	}
+	acks := make(chan ack, len(spl.outs))
+	for _, out := range spl.outs {
+		out.ch <- item{ckpt: acks}
+	}
+	for range spl.outs {
+		ack := <-acks
+		idx.outs[ack.id] = ack.st
+	}
+
+	select {
+	case err := <-spl.errc:
+		return err
+	default:
+	}
+
+	spl.idx = idx
+	return writeIndex(spl.index, idx)
+}
+
+func (spl *splitter) close() error {
+	if spl.done {
+		return nil
+	}
+	spl.done = true
+
+	var err error
+	for _, out := range spl.outs {
+		close(out.ch)
+	}
+	for _, out := range spl.outs {
+		<-out.quit
+		e := out.f.Close()
+		if e != nil && err == nil {
+			err = e
+		}
+	}
+	select {
+	case e := <-spl.errc:
+		if err == nil {
+			err = e
+		}
+	default:
+	}
+	return err
+}
+
+func (spl *splitter) fail(err error) {
+	select {
+	case spl.errc <- err:
+	default:
+	}
+}
+
+// output is an encoder goroutine writing DIF blocks to a DIF file.
+type output struct {
+	id   uint8
+	f    *os.File
+	cw   *countingWriter
+	w    *bufio.Writer
+	enc  *eformat.Encoder
+	st   stats // statistics of the DIF blocks written so far
+	ch   chan item
+	quit chan struct{}
+}
+
+// stats describes the DIF blocks of an output file.
+type stats struct {
+	size   int64  // size of the output file, in bytes
+	blocks int64  // number of DIF blocks
+	gtcMin uint32 // smallest GTC
+	gtcMax uint32 // largest GTC
+}
+
+func (st *stats) add(d *eformat.DIF) {
+	gtc := d.Header.GTC
+	switch {
+	case st.blocks == 0:
+		st.gtcMin, st.gtcMax = gtc, gtc
+	case gtc < st.gtcMin:
+		st.gtcMin = gtc
+	case gtc > st.gtcMax:
+		st.gtcMax = gtc
+	}
+	st.blocks++
+}
+
+type item struct {
+	dif  *eformat.DIF
+	ckpt chan<- ack // non-nil for checkpoint requests
+}
+
+type ack struct {
+	id uint8
+	st stats
+}
+
+func (out *output) run(spl *splitter) {
+	defer close(out.quit)
+
+	var err error
+	for it := range out.ch {
+		if it.ckpt != nil {
+			if err == nil {
+				err = out.w.Flush()
+				if err != nil {
+					spl.fail(fmt.Errorf("could not flush output file %q: %w", out.f.Name(), err))
+				}
+			}
+			out.st.size = out.cw.n
+			it.ckpt <- ack{id: out.id, st: out.st}
+			continue
+		}
+		if err == nil {
+			err = out.enc.Encode(it.dif)
+			if err != nil {
+				spl.fail(fmt.Errorf("could not encode DIF to %q: %w", out.f.Name(), err))
+			} else {
+				out.st.add(it.dif)
+			}
+		}
+		spl.put(it.dif)
+	}
+
+	if err == nil {
+		err = out.w.Flush()
+		if err != nil {
+			spl.fail(fmt.Errorf("could not flush output file %q: %w", out.f.Name(), err))
+		}
+	}
+}
+
+// index records the state of a split at a checkpoint.
+type index struct {
+	offset int64           // offset in the input file
+	outs   map[uint8]stats // output files, by DIF-ID
+}
+
+func (idx index) ids() []uint8 {
+	ids := make([]uint8, 0, len(idx.outs))
+	for id := range idx.outs {
+		ids = append(ids, id)
+	}
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+	return ids
+}
+
+func writeIndex(fname string, idx index) error {
+	var buf strings.Builder
+	fmt.Fprintf(&buf, "offset %d\n", idx.offset)
+	for _, id := range idx.ids() {
+		st := idx.outs[id]
+		fmt.Fprintf(&buf, "dif %d %d %d %d %d\n", id, st.size, st.blocks, st.gtcMin, st.gtcMax)
+	}
+
+	tmp := fname + ".tmp"
+	err := ioutil.WriteFile(tmp, []byte(buf.String()), 0644)
+	if err != nil {
+		return fmt.Errorf("could not write index file: %w", err)
+	}
+	err = os.Rename(tmp, fname)
+	if err != nil {
+		return fmt.Errorf("could not rename index file: %w", err)
+	}
+	return nil
+}
+
+func readIndex(fname string) (index, error) {
+	idx := index{outs: make(map[uint8]stats)}
+	raw, err := ioutil.ReadFile(fname)
+	if err != nil {
+		return idx, err
+	}
+
+	for i, line := range strings.Split(strings.TrimSpace(string(raw)), "\n") {
+		var (
+			id uint8
+			st stats
+		)
+		switch {
+		case i == 0:
+			_, err = fmt.Sscanf(line, "offset %d", &idx.offset)
+		case strings.Count(line, " ") == 2:
+			// index written before block statistics were recorded.
+			_, err = fmt.Sscanf(line, "dif %d %d", &id, &st.size)
+			msg.Printf("no block statistics for DIF-%d in index: the manifest will only describe the resumed part", id)
+			idx.outs[id] = st
+		default:
+			_, err = fmt.Sscanf(line, "dif %d %d %d %d %d", &id, &st.size, &st.blocks, &st.gtcMin, &st.gtcMax)
+			idx.outs[id] = st
 		}
+		if err != nil {
+			return idx, fmt.Errorf("invalid index line %d (%q): %w", i+1, line, err)
+		}
+	}
+	return idx, nil
+}
+
+// manifest describes the output files of a split.
+type manifest struct {
+	Input  string         `json:"input"`  // path to the input file
+	Blocks int64          `json:"blocks"` // number of DIF blocks
+	Files  []manifestFile `json:"files"`
+}
+
+// manifestFile describes an output file of a split.
+type manifestFile struct {
+	Name   string `json:"name"` // file name, relative to the manifest directory
+	DIFID  uint8  `json:"dif_id"`
+	Blocks int64  `json:"blocks"`  // number of DIF blocks
+	GTCMin uint32 `json:"gtc_min"` // smallest global trigger counter
+	GTCMax uint32 `json:"gtc_max"` // largest global trigger counter
+	Size   int64  `json:"size"`    // size in bytes
+}
+
+// writeManifest writes the manifest of the output files, as recorded at
+// the last checkpoint.
+func (spl *splitter) writeManifest(input string) error {
+	idx := spl.idx
+	man := manifest{
+		Input: input,
+		Files: make([]manifestFile, 0, len(idx.outs)),
+	}
+	for _, id := range idx.ids() {
+		st := idx.outs[id]
+		man.Blocks += st.blocks
+		man.Files = append(man.Files, manifestFile{
+			Name:   filepath.Base(outFileFrom(spl.oname, id)),
+			DIFID:  id,
+			Blocks: st.blocks,
+			GTCMin: st.gtcMin,
+			GTCMax: st.gtcMax,
+			Size:   st.size,
+		})
 	}
 
+	raw, err := json.MarshalIndent(man, "", "  ")
+	if err != nil {
+		return fmt.Errorf("could not marshal manifest: %w", err)
+	}
+	raw = append(raw, '\n')
+
+	tmp := spl.manifest + ".tmp"
+	err = ioutil.WriteFile(tmp, raw, 0644)
+	if err != nil {
+		return fmt.Errorf("could not write manifest file: %w", err)
+	}
+	err = os.Rename(tmp, spl.manifest)
+	if err != nil {
+		return fmt.Errorf("could not rename manifest file: %w", err)
+	}
 	return nil
 }
 
+// progress returns a progress bar for the cur/tot ratio.
+func progress(cur, tot int64) string {
+	const width = 40
+	frac := 1.0
+	if tot > 0 {
+		frac = float64(cur) / float64(tot)
+	}
+	n := int(frac * width)
+	if n > width {
+		n = width
+	}
+	return fmt.Sprintf("[%s%s] %5.1f%%",
+		strings.Repeat("=", n), strings.Repeat(" ", width-n),
+		100*frac,
+	)
+}
+
+type countingReader struct {
+	r io.Reader
+	n int64
+}
+
+func (r *countingReader) Read(p []byte) (int, error) {
+	n, err := r.r.Read(p)
+	r.n += int64(n)
+	return n, err
+}
+
+type countingWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (w *countingWriter) Write(p []byte) (int, error) {
+	n, err := w.w.Write(p)
+	w.n += int64(n)
+	return n, err
+}
+
 func outFileFrom(fname string, id uint8) string {
 	var (
 		ext   = filepath.Ext(fname)
@@ -5,6 +5,8 @@
 package main
 
 import (
+	"bytes"
+	"encoding/json"
 	"io/ioutil"
 	"os"
 	"path/filepath"
@@ -120,4 +122,215 @@ func TestSplit(t *testing.T) {
 		}
 	}
 
+	man, err := readManifest(oname + ".json")
+	if err != nil {
+		t.Fatalf("could not read manifest: %+v", err)
+	}
+	size := func(fname string) int64 {
+		fi, err := os.Stat(fname)
+		if err != nil {
+			t.Fatalf("could not stat %q: %+v", fname, err)
+		}
+		return fi.Size()
+	}
+	want := manifest{
+		Input:  f.Name(),
+		Blocks: 2,
+		Files: []manifestFile{
+			{
+				Name: "out-001.raw", DIFID: 1, Blocks: 1, GTCMin: 12, GTCMax: 12,
+				Size: size(filepath.Join(tmpdir, "out-001.raw")),
+			},
+			{
+				Name: "out-002.raw", DIFID: 2, Blocks: 1, GTCMin: 22, GTCMax: 22,
+				Size: size(filepath.Join(tmpdir, "out-002.raw")),
+			},
+		},
+	}
+	if !reflect.DeepEqual(man, want) {
+		t.Fatalf("invalid manifest:\ngot= %+v\nwant=%+v", man, want)
+	}
+}
+
+func readManifest(fname string) (manifest, error) {
+	var man manifest
+	raw, err := ioutil.ReadFile(fname)
+	if err != nil {
+		return man, err
+	}
+	err = json.Unmarshal(raw, &man)
+	return man, err
+}
+
+func TestSplitResume(t *testing.T) {
+	tmpdir, err := ioutil.TempDir("", "dif-split-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpdir)
+
+	var (
+		iname = filepath.Join(tmpdir, "dif.raw")
+		oname = filepath.Join(tmpdir, "out.raw")
+		rname = filepath.Join(tmpdir, "ref.raw")
+		cfg   = config{ckpt: 3}
+	)
+
+	newDIF := func(i int) eformat.DIF {
+		return eformat.DIF{
+			Header: eformat.GlobalHeader{
+				ID:  uint8(1 + i%3),
+				DTC: uint32(i),
+				GTC: uint32(i / 3),
+			},
+			Frames: []eformat.Frame{
+				{Header: uint8(i), BCID: uint32(i), Data: [16]uint8{uint8(i)}},
+			},
+		}
+	}
+
+	f, err := os.Create(iname)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	enc := eformat.NewEncoder(f)
+	for i := 0; i < 10; i++ {
+		d := newDIF(i)
+		err = enc.Encode(&d)
+		if err != nil {
+			t.Fatalf("could not encode DIF %d: %+v", i, err)
+		}
+	}
+
+	// split a truncated input, as if dif-split was still running.
+	err = process(oname, iname, cfg)
+	if err != nil {
+		t.Fatalf("could not split first part: %+v", err)
+	}
+
+	for i := 10; i < 20; i++ {
+		d := newDIF(i)
+		err = enc.Encode(&d)
+		if err != nil {
+			t.Fatalf("could not encode DIF %d: %+v", i, err)
+		}
+	}
+	err = f.Close()
+	if err != nil {
+		t.Fatalf("could not close input file: %+v", err)
+	}
+
+	// garbage written after the last checkpoint must be discarded.
+	o, err := os.OpenFile(outFileFrom(oname, 1), os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = o.Write([]byte("garbage"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = o.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cfg.resume = true
+	err = process(oname, iname, cfg)
+	if err != nil {
+		t.Fatalf("could not resume split: %+v", err)
+	}
+
+	err = process(rname, iname, config{ckpt: 100})
+	if err != nil {
+		t.Fatalf("could not split reference: %+v", err)
+	}
+
+	for id := uint8(1); id <= 3; id++ {
+		got, err := ioutil.ReadFile(outFileFrom(oname, id))
+		if err != nil {
+			t.Fatalf("could not read output file: %+v", err)
+		}
+		want, err := ioutil.ReadFile(outFileFrom(rname, id))
+		if err != nil {
+			t.Fatalf("could not read reference file: %+v", err)
+		}
+		if !bytes.Equal(got, want) {
+			t.Fatalf("DIF-%d: resumed split differs from reference", id)
+		}
+	}
+
+	// the manifest accounts for the blocks split before the resume.
+	got, err := readManifest(oname + ".json")
+	if err != nil {
+		t.Fatalf("could not read manifest: %+v", err)
+	}
+	want, err := readManifest(rname + ".json")
+	if err != nil {
+		t.Fatalf("could not read reference manifest: %+v", err)
+	}
+	for i := range want.Files {
+		want.Files[i].Name = got.Files[i].Name
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("invalid manifest:\ngot= %+v\nwant=%+v", got, want)
+	}
+	if got, want := got.Files[0], (manifestFile{Name: "out-001.raw", DIFID: 1, Blocks: 7, GTCMin: 0, GTCMax: 6, Size: got.Files[0].Size}); got != want {
+		t.Fatalf("invalid manifest entry:\ngot= %+v\nwant=%+v", got, want)
+	}
+
+	idx, err := readIndex(oname + ".idx")
+	if err != nil {
+		t.Fatalf("could not read index file: %+v", err)
+	}
+	fi, err := os.Stat(iname)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := idx.offset, fi.Size(); got != want {
+		t.Fatalf("invalid index offset: got=%d, want=%d", got, want)
+	}
+}
+
+func TestReadIndex(t *testing.T) {
+	tmpdir, err := ioutil.TempDir("", "dif-split-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpdir)
+
+	fname := filepath.Join(tmpdir, "out.raw.idx")
+	want := index{
+		offset: 1234,
+		outs: map[uint8]stats{
+			1: {size: 100, blocks: 3, gtcMin: 1, gtcMax: 3},
+			2: {size: 200, blocks: 4, gtcMin: 2, gtcMax: 8},
+		},
+	}
+	err = writeIndex(fname, want)
+	if err != nil {
+		t.Fatalf("could not write index: %+v", err)
+	}
+	got, err := readIndex(fname)
+	if err != nil {
+		t.Fatalf("could not read index: %+v", err)
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("invalid index r/w round-trip:\ngot= %+v\nwant=%+v", got, want)
+	}
+
+	// indices without block statistics.
+	err = ioutil.WriteFile(fname, []byte("offset 1234\ndif 1 100\ndif 2 200\n"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err = readIndex(fname)
+	if err != nil {
+		t.Fatalf("could not read index: %+v", err)
+	}
+	want.outs = map[uint8]stats{1: {size: 100}, 2: {size: 200}}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("invalid index:\ngot= %+v\nwant=%+v", got, want)
+	}
 }
@@ -0,0 +1,134 @@
+// Copyright 2020 The go-lpc Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"log"
+	"sync"
+	"time"
+)
+
+// alertPolicy describes how notifications about stalled files are sent.
+type alertPolicy struct {
+	dedup    time.Duration // minimum interval between two notifications about the same file
+	escalate time.Duration // delay after which an unresolved alert is escalated (0: never)
+}
+
+type alertKind int
+
+const (
+	alertStalled   alertKind = iota // file stopped growing
+	alertEscalated                  // file still not growing after the escalation delay
+	alertRecovered                  // file grows again
+)
+
+func (k alertKind) String() string {
+	switch k {
+	case alertStalled:
+		return "alert"
+	case alertEscalated:
+		return "escalated alert"
+	case alertRecovered:
+		return "recovered"
+	}
+	return "unknown"
+}
+
+// notice is a notification about a monitored file.
+type notice struct {
+	kind      alertKind
+	fname     string
+	size      int64         // current size of the file
+	stalled   time.Duration // time elapsed since the first alert
+	escalated bool          // whether the escalation contacts are notified
+}
+
+// alertState tracks the notifications sent about a stalled file.
+type alertState struct {
+	first      time.Time // time of the first alert
+	last       time.Time // time of the last notification
+	escalated  bool
+	suppressed int // number of alerts suppressed since the last notification
+}
+
+// alerter applies an alert policy to the stalled files reported by the
+// monitor, and sends the resulting notices.
+type alerter struct {
+	policy alertPolicy
+	now    func() time.Time
+	send   func(n notice)
+
+	mu    sync.Mutex
+	files map[string]*alertState
+}
+
+func newAlerter(policy alertPolicy, send func(n notice)) *alerter {
+	return &alerter{
+		policy: policy,
+		now:    time.Now,
+		send:   send,
+		files:  make(map[string]*alertState),
+	}
+}
+
+// stalled reports that fname did not grow since the last listing.
+func (a *alerter) stalled(fname string, size int64) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	now := a.now()
+	st, ok := a.files[fname]
+	if !ok {
+		a.files[fname] = &alertState{first: now, last: now}
+		a.send(notice{kind: alertStalled, fname: fname, size: size})
+		return
+	}
+
+	n := notice{
+		kind:      alertStalled,
+		fname:     fname,
+		size:      size,
+		stalled:   now.Sub(st.first),
+		escalated: st.escalated,
+	}
+	switch {
+	case a.policy.escalate > 0 && !st.escalated && n.stalled >= a.policy.escalate:
+		st.escalated = true
+		n.kind = alertEscalated
+		n.escalated = true
+	case now.Sub(st.last) >= a.policy.dedup:
+		// reminder.
+	default:
+		st.suppressed++
+		return
+	}
+
+	if st.suppressed > 0 {
+		log.Printf("%d alert(s) about %q suppressed since %v", st.suppressed, fname, st.last.Format(time.RFC3339))
+	}
+	st.last = now
+	st.suppressed = 0
+	a.send(n)
+}
+
+// grew reports that fname grew since the last listing.
+// A recovery notice is sent if fname was stalled.
+func (a *alerter) grew(fname string, size int64) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	st, ok := a.files[fname]
+	if !ok {
+		return
+	}
+	delete(a.files, fname)
+	a.send(notice{
+		kind:      alertRecovered,
+		fname:     fname,
+		size:      size,
+		stalled:   a.now().Sub(st.first),
+		escalated: st.escalated,
+	})
+}
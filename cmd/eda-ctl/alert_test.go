@@ -0,0 +1,58 @@
+// Copyright 2020 The go-lpc Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"fmt"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestAlerter(t *testing.T) {
+	var (
+		now  = time.Date(2021, 5, 12, 10, 0, 0, 0, time.UTC)
+		sent []string
+		a    = newAlerter(
+			alertPolicy{dedup: 10 * time.Minute, escalate: time.Hour},
+			func(n notice) {
+				sent = append(sent, fmt.Sprintf("%s %s size=%d stalled=%v esc=%v", n.kind, n.fname, n.size, n.stalled, n.escalated))
+			},
+		)
+	)
+	a.now = func() time.Time { return now }
+
+	const freq = 30 * time.Second
+	tick := func(n int) {
+		for i := 0; i < n; i++ {
+			now = now.Add(freq)
+			a.stalled("f1", 42)
+		}
+	}
+
+	a.grew("f1", 42) // not stalled: no notice.
+	tick(1)
+	tick(10) // deduplicated.
+	tick(10) // one reminder.
+	tick(100)
+	a.grew("f1", 64)
+	a.grew("f1", 128)
+	tick(1)
+
+	want := []string{
+		"alert f1 size=42 stalled=0s esc=false",
+		"alert f1 size=42 stalled=10m0s esc=false",
+		"alert f1 size=42 stalled=20m0s esc=false",
+		"alert f1 size=42 stalled=30m0s esc=false",
+		"alert f1 size=42 stalled=40m0s esc=false",
+		"alert f1 size=42 stalled=50m0s esc=false",
+		"escalated alert f1 size=42 stalled=1h0m0s esc=true",
+		"recovered f1 size=64 stalled=1h0m0s esc=true",
+		"alert f1 size=42 stalled=0s esc=false",
+	}
+	if !reflect.DeepEqual(sent, want) {
+		t.Fatalf("invalid notices:\ngot= %q\nwant=%q", sent, want)
+	}
+}
@@ -0,0 +1,144 @@
+// Copyright 2020 The go-lpc Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"sort"
+	"time"
+)
+
+// heartbeat is a status message sent by a DAQ client once it is ready,
+// one JSON object per line (see eda.Heartbeat).
+type heartbeat struct {
+	Time   time.Time `json:"time"`
+	State  string    `json:"state"`
+	Run    uint32    `json:"run"`
+	Cycles int64     `json:"cycles"`
+	Bytes  int64     `json:"bytes"`
+}
+
+// Client describes the liveness of a DAQ client sending heartbeats.
+type Client struct {
+	State  string    `json:"state"`
+	Run    uint32    `json:"run"`
+	Cycles int64     `json:"cycles"`
+	Bytes  int64     `json:"bytes"`
+	Last   time.Time `json:"last"`  // reception time of the last heartbeat
+	Alive  bool      `json:"alive"` // whether heartbeats are received
+	Closed bool      `json:"closed"`
+}
+
+// readBeats reads the heartbeats sent by the client on the provided
+// connection, until the connection is closed.
+func (srv *server) readBeats(addr string, r io.Reader) {
+	srv.mu.Lock()
+	if srv.clients == nil {
+		srv.clients = make(map[string]*Client)
+	}
+	srv.clients[addr] = &Client{State: "ready", Last: srv.now(), Alive: true}
+	srv.mu.Unlock()
+
+	sc := bufio.NewScanner(r)
+	for sc.Scan() {
+		var beat heartbeat
+		err := json.Unmarshal(sc.Bytes(), &beat)
+		if err != nil {
+			log.Printf("could not decode heartbeat from %q: %+v", addr, err)
+			continue
+		}
+
+		srv.mu.Lock()
+		c, ok := srv.clients[addr]
+		if ok {
+			c.State = beat.State
+			c.Run = beat.Run
+			c.Cycles = beat.Cycles
+			c.Bytes = beat.Bytes
+			c.Last = srv.now()
+		}
+		srv.mu.Unlock()
+	}
+
+	srv.mu.Lock()
+	defer srv.mu.Unlock()
+
+	c, ok := srv.clients[addr]
+	if !ok {
+		return
+	}
+	c.Closed = true
+	if c.State != "stopped" {
+		log.Printf("lost heartbeats connection from %q (state=%s): %v", addr, c.State, sc.Err())
+	}
+}
+
+// checkBeats checks the liveness of the clients sending heartbeats, and
+// sends alerts about the clients whose heartbeats stopped.
+// Stopped clients are forgotten.
+func (srv *server) checkBeats() {
+	if srv.beatTimeout <= 0 {
+		return
+	}
+
+	type item struct {
+		addr   string
+		alive  bool
+		cycles int64
+	}
+
+	var (
+		now   = srv.now()
+		items []item
+	)
+	srv.mu.Lock()
+	for addr, c := range srv.clients {
+		if c.Closed && c.State == "stopped" {
+			delete(srv.clients, addr)
+			items = append(items, item{addr, true, c.Cycles})
+			continue
+		}
+		c.Alive = !c.Closed && now.Sub(c.Last) < srv.beatTimeout
+		items = append(items, item{addr, c.Alive, c.Cycles})
+	}
+	srv.mu.Unlock()
+
+	sort.Slice(items, func(i, j int) bool { return items[i].addr < items[j].addr })
+	for _, it := range items {
+		if it.alive {
+			srv.beatAlerts.grew(it.addr, it.cycles)
+			continue
+		}
+		log.Printf("no heartbeat from %q in the last %v (cycles=%d)", it.addr, srv.beatTimeout, it.cycles)
+		srv.beatAlerts.stalled(it.addr, it.cycles)
+	}
+}
+
+// notifyBeat sends the provided notice about the heartbeats of a client by
+// mail and SMS.
+func (srv *server) notifyBeat(n notice) {
+	log.Printf("sending %s notice for heartbeats of %q...", n.kind, n.fname)
+
+	var subject string
+	switch n.kind {
+	case alertRecovered:
+		subject = fmt.Sprintf("[eda-ctl] heartbeats recovered: %q", n.fname)
+	case alertEscalated:
+		subject = fmt.Sprintf("[eda-ctl] ESCALATED heartbeat alert: %q", n.fname)
+	default:
+		subject = fmt.Sprintf("[eda-ctl] heartbeat alert: %q", n.fname)
+	}
+
+	srv.alertMail(subject, fmt.Sprintf("client: %q\ncycles: %d\ntimeout: %v\nstalled: %v",
+		n.fname, n.size, srv.beatTimeout, n.stalled.Round(time.Second),
+	), n.escalated)
+	srv.alertSMS(fmt.Sprintf("eda-ctl: heartbeat %s client=%s cycles=%d timeout=%v stalled=%v",
+		n.kind, n.fname, n.size, srv.beatTimeout, n.stalled.Round(time.Second),
+	))
+}
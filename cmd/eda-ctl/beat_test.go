@@ -0,0 +1,82 @@
+// Copyright 2020 The go-lpc Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestHeartbeats(t *testing.T) {
+	var (
+		now  = time.Date(2021, 5, 12, 10, 0, 0, 0, time.UTC)
+		sent []string
+		srv  = &server{
+			out:         newRing(10),
+			now:         func() time.Time { return now },
+			beatTimeout: time.Minute,
+		}
+	)
+	srv.beatAlerts = newAlerter(alertPolicy{dedup: time.Hour}, func(n notice) {
+		sent = append(sent, n.kind.String()+":"+n.fname)
+	})
+	srv.beatAlerts.now = srv.now
+
+	srv.readBeats("eda01:1234", strings.NewReader(`{"state":"running","run":42,"cycles":10,"bytes":1024}
+not-json
+{"state":"running","run":42,"cycles":12,"bytes":2048}
+`))
+	srv.readBeats("eda02:1234", strings.NewReader(`{"state":"running","run":42,"cycles":5,"bytes":512}
+{"state":"stopped","run":42,"cycles":6,"bytes":600}
+`))
+	srv.readBeats("eda03:1234", strings.NewReader(`{"state":"running","run":42,"cycles":1,"bytes":100}`))
+	srv.clients["eda03:1234"].Closed = false // still connected.
+
+	st := srv.status()
+	want := map[string]Client{
+		"eda01:1234": {State: "running", Run: 42, Cycles: 12, Bytes: 2048, Last: now, Alive: true, Closed: true},
+		"eda02:1234": {State: "stopped", Run: 42, Cycles: 6, Bytes: 600, Last: now, Alive: true, Closed: true},
+		"eda03:1234": {State: "running", Run: 42, Cycles: 1, Bytes: 100, Last: now, Alive: true},
+	}
+	if got := st.Clients; !reflect.DeepEqual(got, want) {
+		t.Fatalf("invalid clients:\ngot= %+v\nwant=%+v", got, want)
+	}
+
+	// eda01 lost its connection while running, eda02 stopped.
+	srv.checkBeats()
+	if got, want := sent, []string{"alert:eda01:1234"}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("invalid alerts: got=%q, want=%q", got, want)
+	}
+	if _, ok := srv.clients["eda02:1234"]; ok {
+		t.Fatalf("stopped client not forgotten")
+	}
+
+	// eda03 hung.
+	now = now.Add(2 * time.Minute)
+	srv.checkBeats()
+	if got, want := sent, []string{"alert:eda01:1234", "alert:eda03:1234"}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("invalid alerts: got=%q, want=%q", got, want)
+	}
+	if srv.clients["eda03:1234"].Alive {
+		t.Fatalf("hung client reported alive")
+	}
+
+	// eda03 is back.
+	srv.clients["eda03:1234"].Last = now
+	srv.checkBeats()
+	if got, want := sent, []string{"alert:eda01:1234", "alert:eda03:1234", "recovered:eda03:1234"}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("invalid alerts: got=%q, want=%q", got, want)
+	}
+
+	// no check.
+	srv.beatTimeout = 0
+	now = now.Add(time.Hour)
+	srv.checkBeats()
+	if got, want := len(sent), 3; got != want {
+		t.Fatalf("invalid number of alerts: got=%d, want=%d", got, want)
+	}
+}
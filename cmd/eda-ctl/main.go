@@ -3,6 +3,18 @@
 // license that can be found in the LICENSE file.
 
 // Command eda-ctl controls the C acq_chb_client process.
+//
+// eda-ctl monitors the output files of the running command and sends mail
+// and SMS alerts when a file stops growing.
+// DAQ clients announce they are ready on the status port (:8877), and may
+// then send heartbeats on the same connection (see eda.Heartbeat). A client
+// without heartbeat for -beat-timeout is considered hung and alerts are
+// sent: unlike file sizes, heartbeats tell a hung DAQ from a DAQ without
+// beam.
+// Alerts about the same file are sent at most once per -alert-dedup
+// interval. An alert still unresolved after -alert-escalate is also sent
+// to the MAIL_ESCALATION_TGTS contacts, and a "recovered" notice is sent
+// once the file grows again.
 package main // import "github.com/go-lpc/mim/cmd/eda-ctl"
 
 import (
@@ -25,27 +37,40 @@ import (
 	"sync"
 	"time"
 
+	"github.com/go-lpc/mim/internal/cliconf"
 	mail "gopkg.in/gomail.v2"
 )
 
 func main() {
 	var (
-		name = flag.String("cmd", "acq_chb_client", "command to run")
-		addr = flag.String("addr", ":8866", "[ip]:port to listen on")
-		dir  = flag.String("dir", "", "directory to monitor")
-		freq = flag.Duration("freq", 30*time.Second, "probing interval")
+		cli  = cliconf.New(flag.CommandLine)
+		name = cli.String("cmd", "acq_chb_client", "command to run")
+		addr = cli.Addr(":8866")
+		dir  = cli.String("dir", "", "directory to monitor")
+		freq = cli.Freq(30 * time.Second)
+		logs = cli.Int("log-lines", 100, "number of command output lines kept for status requests")
+
+		policy alertPolicy
+		beat   time.Duration
 	)
 
-	flag.Parse()
+	cli.DurationVar(&policy.dedup, "alert-dedup", 15*time.Minute, "minimum interval between two alerts about the same file")
+	cli.DurationVar(&policy.escalate, "alert-escalate", time.Hour, "delay after which an unresolved alert is sent to the escalation contacts (0: never)")
+	cli.DurationVar(&beat, "beat-timeout", time.Minute, "delay without heartbeat after which a DAQ client is considered hung (0: no check)")
 
 	log.SetPrefix("eda-ctl: ")
 	log.SetFlags(0)
 
-	run(*name, *addr, *dir, *freq)
+	err := cli.Parse(os.Args[1:])
+	if err != nil {
+		log.Fatalf("could not parse input arguments: %+v", err)
+	}
+
+	run(*name, *addr, *dir, *freq, *logs, policy, beat)
 }
 
-func run(name, addr, dir string, freq time.Duration) {
-	srv, err := newServer(addr, dir, freq)
+func run(name, addr, dir string, freq time.Duration, logs int, policy alertPolicy, beat time.Duration) {
+	srv, err := newServer(addr, dir, freq, logs, policy, beat)
 	if err != nil {
 		log.Fatalf("could not create server: %+v", err)
 	}
@@ -57,15 +82,25 @@ type server struct {
 	conn net.Listener
 	stat net.Listener
 
-	mu  sync.Mutex
-	cmd *exec.Cmd
+	mu    sync.Mutex
+	cmd   *exec.Cmd
+	start time.Time     // start time of the command
+	quit  chan struct{} // closed when the command has exited
+	out   *ring         // tail of the command output
+	runID string        // run number of the command
+	table map[string]int64
 
 	dir    string
 	freq   time.Duration
-	alerts map[string]int // keep track of the number of alerts per file
+	alerts *alerter
+
+	now         func() time.Time
+	clients     map[string]*Client // DAQ clients sending heartbeats, by address
+	beatTimeout time.Duration      // delay without heartbeat before a client is considered hung
+	beatAlerts  *alerter
 }
 
-func newServer(addr, dir string, freq time.Duration) (*server, error) {
+func newServer(addr, dir string, freq time.Duration, logs int, policy alertPolicy, beat time.Duration) (*server, error) {
 	srv, err := net.Listen("tcp", addr)
 	if err != nil {
 		return nil, fmt.Errorf("could not listen on %q: %w", addr, err)
@@ -74,13 +109,19 @@ func newServer(addr, dir string, freq time.Duration) (*server, error) {
 	if err != nil {
 		return nil, fmt.Errorf("could not listen on %q: %w", addr, err)
 	}
-	return &server{
-		conn:   srv,
-		stat:   stat,
-		dir:    dir,
-		freq:   freq,
-		alerts: make(map[string]int),
-	}, nil
+	s := &server{
+		conn: srv,
+		stat: stat,
+		out:  newRing(logs),
+		dir:  dir,
+		freq: freq,
+		now:  time.Now,
+
+		beatTimeout: beat,
+	}
+	s.alerts = newAlerter(policy, s.notify)
+	s.beatAlerts = newAlerter(policy, s.notifyBeat)
+	return s, nil
 }
 
 func (srv *server) run(name string) {
@@ -134,8 +175,16 @@ func (srv *server) handle(conn net.Conn, name string) {
 			log.Printf("starting command... [done]")
 
 			run := req.Args[4]
+			srv.mu.Lock()
+			srv.runID = run
+			srv.table = nil
+			srv.mu.Unlock()
 			go srv.monitor(name, run, done)
 
+		case "status":
+			st := srv.status()
+			_ = json.NewEncoder(conn).Encode(Reply{Msg: "ok", Status: &st})
+
 		case "stop":
 			log.Printf("stopping command...")
 			err = srv.stopCmd()
@@ -168,9 +217,11 @@ func (srv *server) startCmd(name string, args ...string) error {
 		log.Printf("killing previously launched command (pid=%d)... err=%+v", pid, err)
 	}
 
+	srv.out.Reset()
+	srv.clients = nil
 	srv.cmd = exec.Command(name, args...)
-	srv.cmd.Stderr = os.Stderr
-	srv.cmd.Stdout = os.Stdout
+	srv.cmd.Stderr = io.MultiWriter(os.Stderr, srv.out)
+	srv.cmd.Stdout = io.MultiWriter(os.Stdout, srv.out)
 
 	err := srv.cmd.Start()
 	if err != nil {
@@ -182,6 +233,18 @@ func (srv *server) startCmd(name string, args ...string) error {
 		srv.cmd = nil
 		return err
 	}
+	srv.start = time.Now()
+
+	// make sure the process is eventually reaped, and record its exit.
+	var (
+		proc = srv.cmd
+		quit = make(chan struct{})
+	)
+	srv.quit = quit
+	go func() {
+		_ = proc.Wait()
+		close(quit)
+	}()
 
 	return nil
 }
@@ -200,8 +263,6 @@ func (srv *server) stopCmd() error {
 
 	cmd := srv.cmd
 	srv.cmd = nil
-	// make sure the process is eventually reaped by PID-1
-	go func() { _ = cmd.Wait() }()
 
 	err := cmd.Process.Signal(os.Interrupt)
 	if err != nil {
@@ -221,8 +282,119 @@ type Request struct {
 }
 
 type Reply struct {
-	Msg string `json:"msg"`
-	Err string `json:"err,omitempty"`
+	Msg    string  `json:"msg"`
+	Err    string  `json:"err,omitempty"`
+	Status *Status `json:"status,omitempty"`
+}
+
+// Status describes the state of the managed command.
+type Status struct {
+	Running bool              `json:"running"`
+	PID     int               `json:"pid,omitempty"`
+	Uptime  float64           `json:"uptime,omitempty"` // in seconds
+	Run     string            `json:"run,omitempty"`
+	Logs    []string          `json:"logs"`              // last lines of the command output
+	Files   map[string]int64  `json:"files"`             // monitored files and their sizes
+	Clients map[string]Client `json:"clients,omitempty"` // DAQ clients sending heartbeats
+}
+
+func (srv *server) status() Status {
+	srv.mu.Lock()
+	defer srv.mu.Unlock()
+
+	st := Status{
+		Run:   srv.runID,
+		Logs:  srv.out.Lines(),
+		Files: make(map[string]int64, len(srv.table)),
+	}
+	for k, v := range srv.table {
+		st.Files[k] = v
+	}
+	if len(srv.clients) > 0 {
+		st.Clients = make(map[string]Client, len(srv.clients))
+		for k, v := range srv.clients {
+			st.Clients[k] = *v
+		}
+	}
+
+	if srv.cmd == nil {
+		return st
+	}
+	st.PID = srv.cmd.Process.Pid
+	select {
+	case <-srv.quit:
+		// command exited on its own.
+	default:
+		st.Running = true
+		st.Uptime = time.Since(srv.start).Seconds()
+	}
+	return st
+}
+
+// ring is an io.Writer keeping the last lines written to it.
+type ring struct {
+	mu    sync.Mutex
+	lines []string
+	cur   int    // index of the next line to write, once lines is full
+	max   int    // maximum number of lines
+	part  []byte // last, incomplete, line
+}
+
+func newRing(n int) *ring {
+	if n <= 0 {
+		n = 1
+	}
+	return &ring{max: n}
+}
+
+func (r *ring) Write(p []byte) (int, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	n := len(p)
+	for len(p) > 0 {
+		i := bytes.IndexByte(p, '\n')
+		if i < 0 {
+			r.part = append(r.part, p...)
+			break
+		}
+		r.push(string(append(r.part, p[:i]...)))
+		r.part = r.part[:0]
+		p = p[i+1:]
+	}
+	return n, nil
+}
+
+func (r *ring) push(line string) {
+	if len(r.lines) < r.max {
+		r.lines = append(r.lines, line)
+		return
+	}
+	r.lines[r.cur] = line
+	r.cur = (r.cur + 1) % r.max
+}
+
+// Lines returns the lines kept by the ring, oldest first.
+// A trailing incomplete line is included.
+func (r *ring) Lines() []string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	o := make([]string, 0, len(r.lines)+1)
+	o = append(o, r.lines[r.cur:]...)
+	o = append(o, r.lines[:r.cur]...)
+	if len(r.part) > 0 {
+		o = append(o, string(r.part))
+	}
+	return o
+}
+
+func (r *ring) Reset() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.lines = r.lines[:0]
+	r.cur = 0
+	r.part = r.part[:0]
 }
 
 func (srv *server) waitReady(ready chan error) {
@@ -262,6 +434,9 @@ func (srv *server) waitReady(ready chan error) {
 		}
 		ready <- nil
 	}
+
+	// heartbeats follow the ready message on the same connection.
+	srv.readBeats(conn.RemoteAddr().String(), conn)
 }
 
 func (srv *server) monitor(name, run string, quit chan int) {
@@ -280,6 +455,7 @@ func (srv *server) monitor(name, run string, quit chan int) {
 		case <-quit:
 			return
 		case <-tick.C:
+			srv.checkBeats()
 			log.Printf("[mon]: listing contents of %q for client=%q...", run, name)
 			cur, err := srv.list(srv.dir, run)
 			if err != nil {
@@ -288,6 +464,9 @@ func (srv *server) monitor(name, run string, quit chan int) {
 			}
 			srv.compare(table, cur)
 			table = cur
+			srv.mu.Lock()
+			srv.table = cur
+			srv.mu.Unlock()
 			keys := make([]string, 0, len(table))
 			for k := range table {
 				keys = append(keys, k)
@@ -327,49 +506,68 @@ func (srv *server) compare(ref, chk map[string]int64) {
 		}
 		refsz := ref[fname]
 		chksz := chk[fname]
-		if refsz == chksz {
+		switch {
+		case refsz == chksz:
 			// file didn't grow!
-			srv.alert(fname, refsz)
+			log.Printf("file %q didn't change in the last %v (size=%d bytes)",
+				fname, srv.freq, refsz,
+			)
+			srv.alerts.stalled(fname, refsz)
+		default:
+			srv.alerts.grew(fname, chksz)
 		}
 	}
 }
 
-func (srv *server) alert(fname string, size int64) {
-	log.Printf("file %q didn't change in the last %v (size=%d bytes)",
-		fname, srv.freq, size,
-	)
-	srv.alerts[fname]++
-
-	const maxAlerts = 5
-	if srv.alerts[fname] < maxAlerts {
-		srv.alertMail(fname, size)
-		srv.alertSMS(fname, size)
+// notify sends the provided notice about a file by mail and SMS.
+func (srv *server) notify(n notice) {
+	log.Printf("sending %s notice for file %q...", n.kind, n.fname)
+
+	var subject string
+	switch n.kind {
+	case alertRecovered:
+		subject = fmt.Sprintf("[eda-ctl] file recovered: %q", n.fname)
+	case alertEscalated:
+		subject = fmt.Sprintf("[eda-ctl] ESCALATED file alert: %q", n.fname)
+	default:
+		subject = fmt.Sprintf("[eda-ctl] file alert: %q", n.fname)
 	}
+
+	srv.alertMail(subject, fmt.Sprintf("file: %q\nsize: %d bytes\nfreq: %v\nstalled: %v",
+		n.fname, n.size, srv.freq, n.stalled.Round(time.Second),
+	), n.escalated)
+	srv.alertSMS(fmt.Sprintf("eda-ctl: %s file=%s size=%d freq=%v stalled=%v",
+		n.kind, n.fname, n.size, srv.freq, n.stalled.Round(time.Second),
+	))
 }
 
 var (
-	alertMailUsr  = os.Getenv("MAIL_USERNAME")
-	alertMailPwd  = os.Getenv("MAIL_PASSWORD")
-	alertMailSrv  = os.Getenv("MAIL_SERVER")
-	alertMailPort = atoi(os.Getenv("MAIL_PORT"))
-	alertMailTgts = strings.Split(os.Getenv("MAIL_TGTS"), ",")
+	alertMailUsr     = os.Getenv("MAIL_USERNAME")
+	alertMailPwd     = os.Getenv("MAIL_PASSWORD")
+	alertMailSrv     = os.Getenv("MAIL_SERVER")
+	alertMailPort    = atoi(os.Getenv("MAIL_PORT"))
+	alertMailTgts    = splitList(os.Getenv("MAIL_TGTS"))
+	alertMailEscTgts = splitList(os.Getenv("MAIL_ESCALATION_TGTS"))
 )
 
-func (srv *server) alertMail(fname string, size int64) {
+func (srv *server) alertMail(subject, body string, escalated bool) {
 	if alertMailUsr == "" || alertMailPwd == "" ||
 		alertMailSrv == "" || alertMailPort == 0 ||
-		alertMailTgts == nil || len(alertMailTgts) == 0 {
+		len(alertMailTgts) == 0 {
 		log.Printf("could not send mail alert: missing credentials")
 		return
 	}
 
+	tgts := alertMailTgts
+	if escalated {
+		tgts = append(tgts[:len(tgts):len(tgts)], alertMailEscTgts...)
+	}
+
 	msg := mail.NewMessage()
 	msg.SetHeader("From", alertMailUsr)
-	msg.SetHeader("Bcc", alertMailTgts...)
-	msg.SetHeader("Subject", fmt.Sprintf("[eda-ctl] file alert: %q", fname))
-	msg.SetBody("text/plain", fmt.Sprintf("file: %q\nsize: %d bytes\nfreq: %v",
-		fname, size, srv.freq,
-	))
+	msg.SetHeader("Bcc", tgts...)
+	msg.SetHeader("Subject", subject)
+	msg.SetBody("text/plain", body)
 
 	dial := mail.NewDialer(alertMailSrv, alertMailPort, alertMailUsr, alertMailPwd)
 	dial.TLSConfig = &tls.Config{
@@ -385,7 +583,7 @@ var (
 	alertSMSEndPoint = os.Getenv("SMS_ENDPOINT")
 )
 
-func (srv *server) alertSMS(fname string, size int64) {
+func (srv *server) alertSMS(text string) {
 	if alertSMSEndPoint == "" {
 		log.Printf("could not send sms alert: no end-point")
 		return
@@ -400,9 +598,7 @@ func (srv *server) alertSMS(fname string, size int64) {
 	}
 	msg.Action = "send"
 	msg.Data.All = true
-	msg.Data.Msg = fmt.Sprintf("eda-ctl: alert file=%s size=%d freq=%v",
-		fname, size, srv.freq,
-	)
+	msg.Data.Msg = text
 
 	data := new(bytes.Buffer)
 	err := json.NewEncoder(data).Encode(msg)
@@ -431,6 +627,19 @@ func (srv *server) alertSMS(fname string, size int64) {
 	}
 }
 
+// splitList splits a comma-separated list, dropping empty items.
+func splitList(s string) []string {
+	var o []string
+	for _, v := range strings.Split(s, ",") {
+		v = strings.TrimSpace(v)
+		if v == "" {
+			continue
+		}
+		o = append(o, v)
+	}
+	return o
+}
+
 func atoi(s string) int {
 	v, err := strconv.Atoi(s)
 	if err != nil {
@@ -0,0 +1,79 @@
+// Copyright 2020 The go-lpc Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"fmt"
+	"os/exec"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestRing(t *testing.T) {
+	r := newRing(3)
+
+	if got, want := r.Lines(), []string{}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("invalid empty ring: got=%q, want=%q", got, want)
+	}
+
+	for i := 0; i < 5; i++ {
+		fmt.Fprintf(r, "line-%d\n", i)
+	}
+	fmt.Fprintf(r, "part")
+	fmt.Fprintf(r, "ial")
+
+	want := []string{"line-2", "line-3", "line-4", "partial"}
+	if got := r.Lines(); !reflect.DeepEqual(got, want) {
+		t.Fatalf("invalid ring:\ngot= %q\nwant=%q", got, want)
+	}
+
+	fmt.Fprintf(r, "\nlast\n")
+	want = []string{"line-4", "partial", "last"}
+	if got := r.Lines(); !reflect.DeepEqual(got, want) {
+		t.Fatalf("invalid ring:\ngot= %q\nwant=%q", got, want)
+	}
+
+	r.Reset()
+	if got, want := r.Lines(), []string{}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("invalid reset ring: got=%q, want=%q", got, want)
+	}
+}
+
+func TestStatus(t *testing.T) {
+	sh, err := exec.LookPath("sh")
+	if err != nil {
+		t.Skipf("could not find sh: %+v", err)
+	}
+
+	srv := &server{out: newRing(10)}
+
+	st := srv.status()
+	if st.Running || st.PID != 0 {
+		t.Fatalf("invalid status of idle server: %+v", st)
+	}
+
+	err = srv.startCmd(sh, "-c", "echo hello; echo world")
+	if err != nil {
+		t.Fatalf("could not start command: %+v", err)
+	}
+
+	select {
+	case <-srv.quit:
+	case <-time.After(5 * time.Second):
+		t.Fatalf("command did not exit")
+	}
+
+	st = srv.status()
+	if st.Running {
+		t.Fatalf("exited command reported as running")
+	}
+	if st.PID == 0 {
+		t.Fatalf("missing PID of exited command")
+	}
+	if got, want := st.Logs, []string{"hello", "world"}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("invalid logs: got=%q, want=%q", got, want)
+	}
+}
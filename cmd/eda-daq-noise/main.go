@@ -4,6 +4,8 @@
 
 // Command eda-daq-noise is a stand-alone program for data acquisition
 // on the EDA board (DCC needed only for clock), with multiple RFMs.
+//
+// Deprecated: use "eda-daq -trig=noise" instead.
 package main // import "github.com/go-lpc/mim/cmd/eda-daq-noise"
 
 import (
@@ -11,6 +13,7 @@ import (
 	"log"
 
 	"github.com/go-lpc/mim/eda"
+	"github.com/go-lpc/mim/internal/cliconf"
 )
 
 func main() {
@@ -19,18 +22,26 @@ func main() {
 		run    = flag.Int("run", 0, "run number to use for data acquisition")
 		rfm    = flag.Int("rfm-mask", 0, "RFM mask")
 		thresh = flag.Int("thresh", 0, "DAC threshold")
+		presc  = flag.Int("prescale", 1, "keep the data of 1 acquisition cycle out of n")
+		rate   = flag.Float64("max-rate", 0, "maximum acquisition cycle rate in Hz (0: no limit)")
 	)
 
+	cliconf.Version(flag.CommandLine)
 	flag.Parse()
 
 	log.SetPrefix("eda-daq: ")
 	log.SetFlags(0)
 
-	xmain(*cfg, *run, *thresh, *rfm)
+	log.Printf("eda-daq-noise is deprecated: use \"eda-daq -trig=noise -cfg-dir=%s\" instead", *cfg)
+
+	xmain(*cfg, *run, *thresh, *rfm,
+		eda.WithNoisePrescale(*presc),
+		eda.WithNoiseMaxRate(*rate),
+	)
 }
 
-func xmain(cfg string, run, threshold, rfmMask int) {
-	err := eda.RunStandalone(cfg, run, threshold, rfmMask)
+func xmain(cfg string, run, threshold, rfmMask int, opts ...eda.Option) {
+	err := eda.RunStandalone(cfg, run, threshold, rfmMask, opts...)
 	if err != nil {
 		log.Fatalf("could not run standalone: %+v", err)
 	}
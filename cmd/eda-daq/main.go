@@ -3,20 +3,41 @@
 // license that can be found in the LICENSE file.
 
 // Command eda-daq drives the EDA data acquisition in stand-alone mode.
+//
+// The trigger mode is selected with the -trig flag:
+//   - dcc: acquisitions are driven by the DCC, data is sent to eda-srv,
+//   - noise: acquisitions are started by software and data is written
+//     to a local file (this replaces the eda-daq-noise command),
+//   - pattern: the DAQ FIFOs are bypassed and each RFM sends a known test
+//     pattern to eda-srv, to validate the DAQ chain without hardrocs.
+//
+// The run is stopped on SIGINT or SIGTERM, or once the DAQ loop exits on
+// its own (see -max-duration and -max-cycles, or on acquisition failure).
+//
+// Flag values may also be read from MIM_<NAME> environment variables or
+// from a configuration file of name=value lines, with the -config flag.
+// Flags set on the command line take precedence over the environment,
+// which takes precedence over the configuration file.
 package main // import "github.com/go-lpc/mim/cmd/eda-daq"
 
 import (
+	"context"
 	"flag"
 	"fmt"
 	"log"
 	"net"
+	"net/http"
 	"os"
 	"os/signal"
 	"runtime/pprof"
+	"strings"
 	"syscall"
 	"time"
 
+	"github.com/go-lpc/mim/conddb"
 	"github.com/go-lpc/mim/eda"
+	"github.com/go-lpc/mim/internal/cliconf"
+	"github.com/go-lpc/mim/obs"
 )
 
 func main() {
@@ -25,15 +46,63 @@ func main() {
 
 func xmain(args []string) error {
 	var (
-		fset = flag.NewFlagSet("eda-daq", flag.ContinueOnError)
+		fset = cliconf.New(flag.NewFlagSet("eda-daq", flag.ContinueOnError))
 
-		runnbr    = fset.Int("run", -1, "run number")
+		runnbr    = fset.Run(-1)
 		threshold = fset.Int("thresh", -1, "threshold")
 		rshaper   = fset.Int("rshaper", -1, "R shaper")
 		rfmOn     = fset.Int("rfm", -1, "RFM-ON mask")
 		srvAddr   = fset.String("srv-addr", ":8877", "eda-srv [address]:port to dial")
-		odir      = fset.String("o", "/home/root/run", "output dir")
+		odir      = fset.OutDir("/home/root/run")
+		devmem    = fset.DevMem()
+		devshm    = fset.DevSHM()
+		cfgMode   = fset.String("cfg-mode", "csv", "configuration mode (csv, db)")
+		dbName    = fset.String("db", "tmvsrv", "name of the condition database (db mode)")
+		detID     = fset.Int("detector-id", -1, "detector ID to configure (db mode, default: last detector)")
+		edaID     = fset.Int("eda-id", -1, "EDA board ID within detector (db mode, default: all)")
+		tindex    = fset.Bool("time-index", false, "write a wall-clock time index of DIF blocks in output dir")
+		trig      = fset.String("trig", "dcc", "trigger mode (dcc, noise, pattern, external)")
+		cfgDir    = fset.String("cfg-dir", "/dev/shm/config_base", "directory holding the CSV configuration files (csv mode)")
+		presc     = fset.Int("noise-prescale", 1, "keep the data of 1 acquisition cycle out of n (noise mode)")
+		rate      = fset.Float64("noise-max-rate", 0, "maximum acquisition cycle rate in Hz (noise mode, 0: no limit)")
+		empty     = fset.Int("empty-prescale", 1, "send 1 DIF block without hardroc frames out of n to the DIF data sinks (0: none)")
+		patFrames = fset.Int("pattern-frames", 64, "number of hardroc frames per cycle and RFM (pattern mode)")
+		patRate   = fset.Float64("pattern-rate", 10, "maximum acquisition cycle rate in Hz (pattern mode, 0: no limit)")
+		dbWatch   = fset.Duration("db-watch", time.Minute, "interval between checks for new HR configurations (db mode, 0 to disable)")
+		compAlgo  = fset.String("compress", "", "compression algorithm of local raw files (zstd, default: none)")
+		compLevel = fset.Int("compress-level", 3, "compression level of local raw files")
+		fwCheck   = fset.Bool("fw-check", false, "check the FPGA firmware version register (not implemented by released firmwares)")
+		force     = fset.Bool("force", false, "run against FPGA firmware versions unknown to the driver")
+		runDB     = fset.Bool("run-db", false, "record run metadata in the run bookkeeping tables of the condition database")
+		summary   = fset.Bool("run-summary", false, "send an end-of-run summary to the DIF data sinks")
+		prov      = fset.Bool("provenance", false, "append a provenance trailer (board, slot, firmware and software versions) to DIF blocks")
+		minFree   = fset.Uint64("min-free", 64, "minimum free space in MiB of the output filesystems (0: no check)")
+		clockSrc  = fset.String("clock-check", "", "source of the clock synchronization check at run start (chrony or NTP server, default: none)")
+		clockMax  = fset.Duration("clock-max-offset", 10*time.Millisecond, "maximum offset of the clock at run start (see -clock-check)")
+		strict    = fset.Bool("strict-time", false, "refuse to start runs when the clock is not synchronized (see -clock-check)")
+		trigThr   = fset.Uint("trig-threshold", 0, "hardroc discriminator (0 or 1) triggering the readout")
+		dualThr   = fset.Bool("dual-threshold", false, "record the hit counters of both discriminators along DIF blocks")
+		scPulse   = fset.Duration("sc-pulse", time.Microsecond, "width of the slow-control reset pulse")
+		scTimeout = fset.Duration("sc-timeout", time.Second, "timeout of the slow-control serializer")
+		scRetries = fset.Int("sc-retries", 0, "number of retries of a failed hardroc slow-control")
+		fpgaCheck = fset.Duration("fpga-check", time.Second, "interval between checks of the FPGA configuration during a run (0: no check)")
+		fpgaRetry = fset.Int("fpga-retries", 1, "number of re-configurations of the FPGA after it lost its configuration during a run")
+		legacyCnt = fset.Bool("legacy-counters", false, "use the historical layout of DIF header counters (cycle number starting at 1 in DTC and GTC, hits in ATC)")
+		batchMax  = fset.Int("readout-batch", 1, "maximum number of acquisition cycles read out before sending their DIF data (dcc mode, 1: no batching)")
+		batchDt   = fset.Duration("readout-budget", 50*time.Millisecond, "maximum latency of the first acquisition cycle of a readout batch (see -readout-batch)")
+		maxProcs  = fset.Int("max-procs", 0, "GOMAXPROCS during runs (0: unchanged)")
+		cpuReader = fset.Int("cpu-reader", -1, "CPU the goroutine reading the RFM FIFOs is bound to during runs (-1: any)")
+		cpuSender = fset.Int("cpu-senders", -1, "CPU the goroutines sending DIF data are bound to during runs (-1: any)")
+		maxDur    = fset.Duration("max-duration", 0, "maximum duration of the run, after which it is stopped (0: no limit)")
+		maxCycles = fset.Int64("max-cycles", 0, "maximum number of acquisition cycles of the run, after which it is stopped (0: no limit)")
+		beat      = fset.Duration("heartbeat", 10*time.Second, "interval between heartbeats sent to eda-ctl (0: none)")
+		bus       = fset.String("bus", "mem", "access to the FPGA buses (mem: memory device of -dev-mem, uio: UIO devices)")
+		sinkStats = fset.Duration("sink-stats", 0, "interval between polls of the socket statistics of the DIF data sinks during the run (0: none, see -metrics-addr)")
+		metrics   = fset.String("metrics-addr", "", "address of the HTTP server exposing the metrics of the device as JSON (default: none)")
+		backend   = fset.String("backend", "go", "implementation of the EDA device ("+strings.Join(eda.Backends(), ", ")+")")
+		trigCnt   eda.TriggerCounter
 	)
+	fset.Var(&trigCnt, "trig-counter", "counter recorded in the DTC, ATC and GTC fields of DIF headers (cycle, fpga)")
 
 	log.SetPrefix("eda-daq: ")
 	log.SetFlags(0)
@@ -45,22 +114,147 @@ func xmain(args []string) error {
 
 	log.Printf("run=%d threshold=%d R-shaper=%d RFM-ON[3:0]=%d", *runnbr, *threshold, *rshaper, *rfmOn)
 
-	switch {
-	case *runnbr < 0:
-		return fmt.Errorf("invalid run number value (=%v)", *runnbr)
-	case *threshold < 0:
-		return fmt.Errorf("invalid threshold value (=%v)", *threshold)
-	case *rshaper < 0:
-		return fmt.Errorf("invalid R-shaper value (=%v)", *rshaper)
-	case *rfmOn < 0:
-		return fmt.Errorf("invalid RFM mask value (=%v)", *rfmOn)
-	}
-
-	err = run(
-		uint32(*runnbr), uint32(*threshold), uint32(*rshaper), uint32(*rfmOn),
-		*srvAddr, *odir,
-		"/dev/mem", "dev/shm", "/dev/shm/config_base",
-	)
+	cfg := config{
+		mode:   *cfgMode,
+		dbname: *dbName,
+		detID:  *detID,
+		edaID:  *edaID,
+		dir:    *cfgDir,
+		tindex: *tindex,
+		watch:  *dbWatch,
+		trig:   *trig,
+		presc:  *presc,
+		rate:   *rate,
+		empty:  *empty,
+		comp:   *compAlgo,
+		lvl:    *compLevel,
+		fwChk:  *fwCheck,
+		force:  *force,
+		rundb:  *runDB,
+		prov:   *prov,
+		summ:   *summary,
+		free:   *minFree << 20,
+		clock: clockCheck{
+			src:    *clockSrc,
+			max:    *clockMax,
+			strict: *strict,
+		},
+		thresh: thresholds{
+			trig: uint8(*trigThr),
+			dual: *dualThr,
+		},
+		pat: pattern{
+			frames: *patFrames,
+			rate:   *patRate,
+		},
+		sc: eda.SCTiming{
+			Pulse:   *scPulse,
+			Timeout: *scTimeout,
+		},
+		scRetries: *scRetries,
+		fpga: fpgaWatch{
+			period:  *fpgaCheck,
+			retries: *fpgaRetry,
+		},
+		cnt: counters{
+			trig:   trigCnt,
+			legacy: *legacyCnt,
+		},
+		batch: batching{
+			max:    *batchMax,
+			budget: *batchDt,
+		},
+		max: runLimits{
+			dur:    *maxDur,
+			cycles: *maxCycles,
+		},
+		cpu: eda.CPUAffinity{
+			MaxProcs: *maxProcs,
+			Reader:   *cpuReader,
+			Senders:  *cpuSender,
+		},
+		stats:   *sinkStats,
+		metrics: *metrics,
+		beat:    *beat,
+		backend: *backend,
+		bus:     *bus,
+	}
+
+	switch cfg.comp {
+	case "", "zstd":
+		// ok.
+	default:
+		return fmt.Errorf("invalid compression algorithm %q", cfg.comp)
+	}
+
+	if !hasBackend(cfg.backend) {
+		return fmt.Errorf(
+			"invalid backend %q (registered: %s)",
+			cfg.backend, strings.Join(eda.Backends(), ", "),
+		)
+	}
+
+	switch cfg.trig {
+	case "dcc":
+		// ok.
+	case "pattern":
+		if cfg.pat.frames < 0 {
+			return fmt.Errorf("invalid number of pattern frames (=%v)", cfg.pat.frames)
+		}
+	case "noise":
+		if cfg.mode != "csv" {
+			return fmt.Errorf("trigger mode %q requires the csv configuration mode", cfg.trig)
+		}
+		if cfg.backend != "go" {
+			return fmt.Errorf("trigger mode %q requires the go backend", cfg.trig)
+		}
+	case "external":
+		return fmt.Errorf("trigger mode %q not supported", cfg.trig)
+	default:
+		return fmt.Errorf("invalid trigger mode %q", cfg.trig)
+	}
+
+	switch cfg.mode {
+	case "csv":
+		switch {
+		case *runnbr < 0:
+			return fmt.Errorf("invalid run number value (=%v)", *runnbr)
+		case *threshold < 0:
+			return fmt.Errorf("invalid threshold value (=%v)", *threshold)
+		case *rshaper < 0:
+			return fmt.Errorf("invalid R-shaper value (=%v)", *rshaper)
+		case *rfmOn < 0:
+			return fmt.Errorf("invalid RFM mask value (=%v)", *rfmOn)
+		}
+	case "db":
+		// threshold and RFM mask are retrieved from the condition database.
+		// A non-zero RFM mask restricts the chambers to configure.
+		switch {
+		case *runnbr < 0:
+			return fmt.Errorf("invalid run number value (=%v)", *runnbr)
+		case *rshaper < 0:
+			return fmt.Errorf("invalid R-shaper value (=%v)", *rshaper)
+		}
+		if *threshold < 0 {
+			*threshold = 0
+		}
+		if *rfmOn < 0 {
+			*rfmOn = 0
+		}
+	default:
+		return fmt.Errorf("invalid configuration mode %q", cfg.mode)
+	}
+
+	switch cfg.trig {
+	case "noise":
+		err = runNoise(*runnbr, *threshold, *rshaper, *rfmOn, cfg)
+	default:
+		err = run(
+			uint32(*runnbr), uint32(*threshold), uint32(*rshaper), uint32(*rfmOn),
+			*srvAddr, *odir,
+			*devmem, *devshm, cfg,
+		)
+	}
 	if err != nil {
 		return fmt.Errorf("could not run eda-daq: %+v", err)
 	}
@@ -68,7 +262,144 @@ func xmain(args []string) error {
 	return nil
 }
 
-func run(run, threshold, rshaper, rfm uint32, srvAddr, odir, devmem, devshm, cfgdir string) error {
+// config describes where the EDA device configuration is retrieved from,
+// and which optional run outputs are enabled.
+type config struct {
+	mode string // csv or db
+
+	dir string // directory holding the CSV configuration files (csv mode)
+
+	dbname string        // name of the condition database (db mode)
+	detID  int           // detector ID (db mode, <0: last detector)
+	edaID  int           // EDA board ID (db mode, <0: all)
+	watch  time.Duration // interval between checks for new HR configurations (db mode)
+
+	tindex bool // whether to write a wall-clock time index of DIF blocks
+
+	trig  string  // trigger mode (dcc or noise)
+	presc int     // prescale factor of acquisition cycles (noise mode)
+	rate  float64 // maximum rate of acquisition cycles (noise mode)
+	empty int     // prescale factor of DIF blocks without hardroc frames
+
+	pat pattern // test pattern settings (pattern mode)
+
+	comp string // compression algorithm of local raw files ("": none)
+	lvl  int    // compression level of local raw files
+
+	fwChk bool // whether to check the FPGA firmware version
+	force bool // whether to run against unknown FPGA firmwares
+	rundb bool // whether to record runs in the condition database
+	prov  bool // whether to append provenance trailers to DIF blocks
+	summ  bool // whether to send end-of-run summaries to the DIF data sinks
+
+	free uint64 // minimum free space of the output filesystems, in bytes
+
+	clock clockCheck // clock synchronization check at run start
+
+	thresh thresholds // discriminator settings
+
+	sc        eda.SCTiming // timing of the slow-control serializer
+	scRetries int          // number of retries of a failed slow-control
+
+	fpga  fpgaWatch // checks of the FPGA configuration during runs
+	cnt   counters  // layout of the counters of DIF headers
+	batch batching  // batching of acquisition cycles (dcc mode)
+
+	max runLimits       // limits after which the run is stopped
+	cpu eda.CPUAffinity // CPU affinity of the DAQ pipeline
+
+	beat  time.Duration // interval between heartbeats sent to eda-ctl
+	stats time.Duration // interval between polls of the socket statistics of the DIF data sinks (0: none)
+
+	metrics string // address of the HTTP server exposing the device metrics ("": none)
+	backend string // implementation of the EDA device
+	bus     string // access to the FPGA buses (mem or uio)
+}
+
+// counters describes the counters recorded in DIF headers.
+type counters struct {
+	trig   eda.TriggerCounter // counter of the DTC, ATC and GTC fields
+	legacy bool               // whether to use the historical layout
+}
+
+// clockCheck describes how the clock synchronization is checked at run start.
+type clockCheck struct {
+	src    string        // source of the check ("": no check)
+	max    time.Duration // maximum offset of the clock
+	strict bool          // whether to refuse to start runs with an unsynchronized clock
+}
+
+// batching describes how acquisition cycles are batched before being sent.
+type batching struct {
+	max    int           // maximum number of cycles per batch (<=1: no batching)
+	budget time.Duration // maximum latency of the first cycle of a batch
+}
+
+// runLimits describes the limits after which a run is stopped.
+type runLimits struct {
+	dur    time.Duration // maximum duration of the run (0: no limit)
+	cycles int64         // maximum number of acquisition cycles (0: no limit)
+}
+
+// fpgaWatch describes how the FPGA configuration is checked during runs.
+type fpgaWatch struct {
+	period  time.Duration // interval between checks (0: no check)
+	retries int           // number of re-configurations per run
+}
+
+// thresholds describes how the hardroc discriminators are read out.
+type thresholds struct {
+	trig uint8 // discriminator triggering the readout (0 or 1)
+	dual bool  // whether to record the hit counters of both discriminators
+}
+
+// pattern describes the test pattern produced in the pattern trigger mode.
+type pattern struct {
+	frames int     // number of hardroc frames per cycle and RFM
+	rate   float64 // maximum rate of acquisition cycles
+}
+
+// runNoise runs a noise acquisition, with acquisition cycles started by
+// software and data written to a local file.
+func runNoise(run, threshold, rshaper, rfm int, cfg config) error {
+	opts := []eda.Option{
+		eda.WithRShaper(uint32(rshaper)),
+		eda.WithNoisePrescale(cfg.presc),
+		eda.WithNoiseMaxRate(cfg.rate),
+		eda.WithCompression(cfg.comp, cfg.lvl),
+		eda.WithFirmwareCheck(cfg.fwChk),
+		eda.WithForceFirmware(cfg.force),
+		eda.WithProvenance(cfg.prov),
+		eda.WithMinFreeSpace(cfg.free),
+		eda.WithClockCheck(cfg.clock.src, cfg.clock.max, cfg.clock.strict),
+		eda.WithTriggerThreshold(cfg.thresh.trig),
+		eda.WithDualThreshold(cfg.thresh.dual),
+		eda.WithSCTiming(cfg.sc),
+		eda.WithSCRetries(cfg.scRetries),
+		eda.WithFPGAWatch(cfg.fpga.period, cfg.fpga.retries),
+		eda.WithTriggerCounter(cfg.cnt.trig),
+		eda.WithLegacyCounters(cfg.cnt.legacy),
+		eda.WithCPUAffinity(cfg.cpu),
+		eda.WithBus(cfg.bus, ""),
+		eda.WithMaxRunDuration(cfg.max.dur),
+		eda.WithMaxRunCycles(cfg.max.cycles),
+	}
+	if cfg.metrics != "" {
+		opts = append(opts, eda.WithMetrics(serveMetrics(cfg.metrics)))
+	}
+	if cfg.rundb {
+		db, err := conddb.Open(cfg.dbname)
+		if err != nil {
+			return fmt.Errorf("could not open run bookkeeping db %q: %w", cfg.dbname, err)
+		}
+		defer db.Close()
+		opts = append(opts, eda.WithRunDB(db))
+	}
+
+	return eda.RunStandalone(cfg.dir, run, threshold, rfm, opts...)
+}
+
+func run(run, threshold, rshaper, rfm uint32, srvAddr, odir, devmem, devshm string, cfg config) error {
 	conn, err := net.Dial("tcp", srvAddr)
 	if err != nil {
 		return fmt.Errorf("could not dial eda-srv %q: %w", srvAddr, err)
@@ -76,58 +407,117 @@ func run(run, threshold, rshaper, rfm uint32, srvAddr, odir, devmem, devshm, cfg
 	defer conn.Close()
 
 	stop := make(chan os.Signal, 1)
-	signal.Notify(stop, os.Interrupt, syscall.SIGUSR1)
+	signal.Notify(stop, os.Interrupt, syscall.SIGTERM, syscall.SIGUSR1)
 	defer signal.Stop(stop)
 
-	dev, err := eda.NewDevice(
-		devmem, odir,
+	opts := []eda.Option{
 		eda.WithCtlAddr(":8877"),
+		eda.WithHeartbeat(cfg.beat),
+		eda.WithBackend(cfg.backend),
+		eda.WithBus(cfg.bus, ""),
+		eda.WithMaxRunDuration(cfg.max.dur),
+		eda.WithMaxRunCycles(cfg.max.cycles),
 		eda.WithThreshold(threshold),
 		eda.WithRShaper(rshaper),
 		eda.WithRFMMask(rfm),
 		eda.WithDevSHM(devshm),
-		eda.WithConfigDir(cfgdir),
-		eda.WithResetBCID(5*time.Minute),
-	)
+		eda.WithResetBCID(5 * time.Minute),
+		eda.WithTimeIndex(cfg.tindex),
+		eda.WithCompression(cfg.comp, cfg.lvl),
+		eda.WithFirmwareCheck(cfg.fwChk),
+		eda.WithForceFirmware(cfg.force),
+		eda.WithProvenance(cfg.prov),
+		eda.WithRunSummary(cfg.summ),
+		eda.WithEmptyBlockPrescale(cfg.empty),
+		eda.WithMinFreeSpace(cfg.free),
+		eda.WithClockCheck(cfg.clock.src, cfg.clock.max, cfg.clock.strict),
+		eda.WithTriggerThreshold(cfg.thresh.trig),
+		eda.WithDualThreshold(cfg.thresh.dual),
+		eda.WithSCTiming(cfg.sc),
+		eda.WithSCRetries(cfg.scRetries),
+		eda.WithFPGAWatch(cfg.fpga.period, cfg.fpga.retries),
+		eda.WithTriggerCounter(cfg.cnt.trig),
+		eda.WithLegacyCounters(cfg.cnt.legacy),
+		eda.WithReadoutBatching(cfg.batch.max, cfg.batch.budget),
+		eda.WithSinkStats(cfg.stats),
+		eda.WithCPUAffinity(cfg.cpu),
+	}
+	switch cfg.mode {
+	case "db":
+		opts = append(opts, eda.WithEDAID(cfg.edaID))
+	default:
+		opts = append(opts, eda.WithConfigDir(cfg.dir))
+	}
+	if cfg.metrics != "" {
+		opts = append(opts, eda.WithMetrics(serveMetrics(cfg.metrics)))
+	}
+	if cfg.trig == "pattern" {
+		opts = append(opts, eda.WithTestPattern(cfg.pat.frames, cfg.pat.rate))
+	}
+	if cfg.rundb {
+		db, err := conddb.Open(cfg.dbname)
+		if err != nil {
+			return fmt.Errorf("could not open run bookkeeping db %q: %w", cfg.dbname, err)
+		}
+		defer db.Close()
+		opts = append(opts, eda.WithRunDB(db))
+	}
+
+	dev, err := eda.Open(devmem, odir, devshm, opts...)
 	if err != nil {
 		return fmt.Errorf("could not initialize EDA device: %w", err)
 	}
 	defer dev.Close()
 
-	err = dev.Configure()
+	switch cfg.mode {
+	case "db":
+		err = configureFromDB(dev, cfg)
+	default:
+		err = dev.Configure()
+	}
 	if err != nil {
 		return fmt.Errorf("could not configure EDA device: %w", err)
 	}
 
+	// also notifies eda-ctl that the device is ready.
 	err = dev.Initialize()
 	if err != nil {
 		return fmt.Errorf("could not initialize EDA device: %w", err)
 	}
 
-	{
-		conn, err := net.Dial("tcp", ":8877")
-		if err != nil {
-			return fmt.Errorf("could not dial eda-ctl: %w", err)
-		}
-		defer conn.Close()
+	err = dev.Start(run)
+	if err != nil {
+		return fmt.Errorf("could not start EDA device: %w", err)
+	}
 
-		_, err = conn.Write([]byte("eda-ready"))
+	if cfg.mode == "db" && cfg.watch > 0 {
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		err = watchHRConfig(ctx, cfg)
 		if err != nil {
-			return fmt.Errorf("could not send eda-ctl ready: %w", err)
+			log.Printf("could not watch HR configurations: %+v", err)
 		}
 	}
 
-	err = dev.Start(run)
-	if err != nil {
-		return fmt.Errorf("could not start EDA device: %w", err)
+	// the run ends on a signal, or when the DAQ loop exits on its own
+	// (run limit reached or acquisition failure).
+	var done <-chan struct{}
+	if w, ok := dev.(interface{ Done() <-chan struct{} }); ok {
+		done = w.Done()
 	}
 
 loop:
-	for v := range stop {
-		switch v {
-		case syscall.SIGUSR1:
-			printStacks()
-		case syscall.SIGINT:
+	for {
+		select {
+		case v := <-stop:
+			switch v {
+			case syscall.SIGUSR1:
+				printStacks()
+			case syscall.SIGINT, syscall.SIGTERM:
+				break loop
+			}
+		case <-done:
+			log.Printf("DAQ loop exited")
 			break loop
 		}
 	}
@@ -140,6 +530,83 @@ loop:
 	return nil
 }
 
+func configureFromDB(dev eda.Driver, cfg config) error {
+	db, err := conddb.Open(cfg.dbname, conddb.WithQueryHook(func(qi conddb.QueryInfo) {
+		log.Printf("conddb: %s: rows=%d, duration=%v, err=%v", qi.Name, qi.Rows, qi.Duration, qi.Err)
+	}))
+	if err != nil {
+		return fmt.Errorf("could not open condition db %q: %w", cfg.dbname, err)
+	}
+	defer db.Close()
+
+	ctx := context.Background()
+
+	detid := uint32(cfg.detID)
+	if cfg.detID < 0 {
+		detid, err = db.LastDetectorID(ctx)
+		if err != nil {
+			return fmt.Errorf("could not retrieve last detector ID: %w", err)
+		}
+	}
+	log.Printf("configuring from db=%q, detector=%d", cfg.dbname, detid)
+
+	err = dev.ConfigureFromDB(ctx, db, detid)
+	if err != nil {
+		return fmt.Errorf("could not configure from db: %w", err)
+	}
+
+	return nil
+}
+
+// watchHRConfig warns operators when a new HR configuration is published
+// in the condition database while a run is going on.
+func watchHRConfig(ctx context.Context, cfg config) error {
+	db, err := conddb.Open(cfg.dbname, conddb.WithWatchInterval(cfg.watch))
+	if err != nil {
+		return fmt.Errorf("could not open condition db %q: %w", cfg.dbname, err)
+	}
+
+	ch, err := db.WatchHRConfig(ctx)
+	if err != nil {
+		_ = db.Close()
+		return fmt.Errorf("could not watch HR configurations: %w", err)
+	}
+
+	go func() {
+		defer db.Close()
+		for name := range ch {
+			log.Printf(
+				"new HR configuration %q published in db=%q: stop and re-configure the run to apply it",
+				name, cfg.dbname,
+			)
+		}
+	}()
+
+	return nil
+}
+
+// serveMetrics serves the metrics recorded in the returned registry as
+// JSON over HTTP on addr.
+func serveMetrics(addr string) *obs.Registry {
+	reg := obs.NewRegistry()
+	go func() {
+		err := http.ListenAndServe(addr, reg)
+		if err != nil {
+			log.Printf("could not serve metrics on %q: %+v", addr, err)
+		}
+	}()
+	return reg
+}
+
+func hasBackend(name string) bool {
+	for _, v := range eda.Backends() {
+		if v == name {
+			return true
+		}
+	}
+	return false
+}
+
 func printStacks() {
 	_ = pprof.Lookup("goroutine").WriteTo(os.Stdout, 1)
 }
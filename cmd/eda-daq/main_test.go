@@ -41,6 +41,46 @@ func TestXMain(t *testing.T) {
 			args: []string{"-run=42", "-thresh=10", "-rshaper=3", "-rfm=1"},
 			want: fmt.Errorf("could not run eda-daq: could not dial eda-srv \":8877\": dial tcp :8877: connect: connection refused"),
 		},
+		{
+			args: []string{"-run=42", "-cfg-mode=xml"},
+			want: fmt.Errorf("invalid configuration mode \"xml\""),
+		},
+		{
+			args: []string{"-run=-1", "-rshaper=3", "-cfg-mode=db"},
+			want: fmt.Errorf("invalid run number value (=-1)"),
+		},
+		{
+			args: []string{"-run=42", "-rshaper=-1", "-cfg-mode=db"},
+			want: fmt.Errorf("invalid R-shaper value (=-1)"),
+		},
+		{
+			args: []string{"-run=42", "-rshaper=3", "-cfg-mode=db", "-detector-id=139"},
+			want: fmt.Errorf("could not run eda-daq: could not dial eda-srv \":8877\": dial tcp :8877: connect: connection refused"),
+		},
+		{
+			args: []string{"-run=42", "-thresh=10", "-rshaper=3", "-rfm=1", "-trig=external"},
+			want: fmt.Errorf("trigger mode \"external\" not supported"),
+		},
+		{
+			args: []string{"-run=42", "-thresh=10", "-rshaper=3", "-rfm=1", "-trig=ext"},
+			want: fmt.Errorf("invalid trigger mode \"ext\""),
+		},
+		{
+			args: []string{"-run=42", "-thresh=10", "-rshaper=3", "-rfm=1", "-trig=pattern", "-pattern-frames=-1"},
+			want: fmt.Errorf("invalid number of pattern frames (=-1)"),
+		},
+		{
+			args: []string{"-run=42", "-rshaper=3", "-cfg-mode=db", "-trig=noise"},
+			want: fmt.Errorf("trigger mode \"noise\" requires the csv configuration mode"),
+		},
+		{
+			args: []string{"-run=42", "-thresh=10", "-rshaper=3", "-rfm=1", "-backend=c"},
+			want: fmt.Errorf("invalid backend \"c\" (registered: go)"),
+		},
+		{
+			args: []string{"-config=/dev/null/not-there"},
+			want: fmt.Errorf("could not parse input arguments: could not load configuration file: could not read \"/dev/null/not-there\": open /dev/null/not-there: not a directory"),
+		},
 	} {
 		t.Run("", func(t *testing.T) {
 			got := xmain(tc.args)
@@ -108,7 +148,10 @@ func TestRun(t *testing.T) {
 	)
 
 	err = run(runID, threshold, rshaper, rfmMask, ":8877",
-		"outdir", devmem.Name(), devshm, "../../eda/testdata",
+		"outdir", devmem.Name(), devshm, config{
+			mode: "csv",
+			dir:  "../../eda/testdata",
+		},
 	)
 	if err != nil {
 		t.Fatalf("could not run eda-daq: %+v", err)
@@ -3,30 +3,168 @@
 // license that can be found in the LICENSE file.
 
 // Command eda-spy spies the content of EDA registers.
+//
+// Usage: eda-spy [OPTIONS] [dump | fifo RFM | counters RFM | config RFM | disk]
+//
+// The fifo, counters and config commands display the DAQ FIFO status, the
+// hit and trigger counters and the slow-control RAM of an RFM slot.
+//
+// The disk command displays the free space of the filesystems the board
+// writes run files to, and whether file writes are paused for lack of
+// space (see eda.WithMinFreeSpace).
+//
+// By default, eda-spy maps the memory device of the local EDA board.
+// With the -addr flag, eda-spy asks an eda-svc server to dump the
+// registers of the board(s) it serves, so registers can be inspected
+// while eda-svc holds the device:
+//
+//	$> eda-spy -addr eda01:9999 dump
+//	$> eda-spy -addr eda01:9999 -board 2 fifo 1
+//	$> eda-spy -addr eda01:9999 -board 2 counters 1
 package main // import "github.com/go-lpc/mim/cmd/eda-spy"
 
 import (
+	"encoding/json"
+	"flag"
 	"fmt"
+	"io"
 	"log"
+	"net"
 	"os"
+	"strconv"
 	"time"
 
 	"github.com/go-lpc/mim/eda"
+	"github.com/go-lpc/mim/internal/cliconf"
 )
 
 func main() {
-	dev, err := eda.NewDevice("/dev/mem", "")
+	var (
+		cli    = cliconf.New(flag.CommandLine)
+		addr   = flag.String("addr", "", "eda-svc [address]:port to dial (default: inspect the local device)")
+		devmem = cli.DevMem()
+		board  = flag.Int("board", -1, "EDA board ID to inspect (remote mode, default: first board)")
+	)
+
+	log.SetPrefix("eda-spy: ")
+	log.SetFlags(0)
+
+	err := cli.Parse(os.Args[1:])
 	if err != nil {
-		log.Fatalf("could open device: %+v", err)
+		log.Fatalf("could not parse input arguments: %+v", err)
+	}
+
+	kind, rfm, err := parseCmd(flag.Args())
+	if err != nil {
+		log.Fatalf("could not parse command: %+v", err)
 	}
-	defer dev.Close()
 
 	fmt.Printf("------------------------------------------------\n")
 	const layout = "2006-01-02 15:04:05 MST"
 	fmt.Printf("%v\n", time.Now().Format(layout))
 
-	err = dev.DumpRegisters(os.Stdout)
+	switch *addr {
+	case "":
+		err = local(os.Stdout, *devmem, kind, rfm)
+	default:
+		err = remote(os.Stdout, *addr, *board, kind, rfm)
+	}
+	if err != nil {
+		log.Fatalf("could not dump %s: %+v", kind, err)
+	}
+}
+
+// parseCmd parses the dump command and its RFM argument.
+func parseCmd(args []string) (kind string, rfm int, err error) {
+	if len(args) == 0 {
+		return "registers", 0, nil
+	}
+	switch args[0] {
+	case "dump":
+		if len(args) != 1 {
+			return "", 0, fmt.Errorf("invalid number of arguments for dump (got=%d, want=0)", len(args)-1)
+		}
+		return "registers", 0, nil
+	case "fifo", "counters", "config":
+		if len(args) != 2 {
+			return "", 0, fmt.Errorf("invalid number of arguments for %s (got=%d, want=1)", args[0], len(args)-1)
+		}
+		rfm, err = strconv.Atoi(args[1])
+		if err != nil {
+			return "", 0, fmt.Errorf("could not parse RFM slot %q: %w", args[1], err)
+		}
+		return args[0], rfm, nil
+	case "disk":
+		if len(args) != 1 {
+			return "", 0, fmt.Errorf("invalid number of arguments for disk (got=%d, want=0)", len(args)-1)
+		}
+		return "disk", 0, nil
+	default:
+		return "", 0, fmt.Errorf("unknown command %q", args[0])
+	}
+}
+
+func local(w io.Writer, devmem, kind string, rfm int) error {
+	dev, err := eda.NewDevice(devmem, "")
 	if err != nil {
-		log.Fatalf("could not dump registers: %+v", err)
+		return fmt.Errorf("could not open device: %w", err)
+	}
+	defer dev.Close()
+
+	switch kind {
+	case "fifo":
+		return dev.DumpFIFOStatus(w, rfm)
+	case "counters":
+		return dev.DumpCounters(w, rfm)
+	case "config":
+		return dev.DumpConfig(w, rfm)
+	case "disk":
+		return dev.DumpDiskSpace(w)
+	default:
+		return dev.DumpRegisters(w)
 	}
 }
+
+// remote sends a dump request to the eda-svc server at addr.
+func remote(w io.Writer, addr string, board int, kind string, rfm int) error {
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		return fmt.Errorf("could not dial eda-svc %q: %w", addr, err)
+	}
+	defer conn.Close()
+
+	req := struct {
+		Name  string `json:"name"`
+		Board *int   `json:"board,omitempty"`
+		Args  []int  `json:"args,omitempty"`
+	}{
+		Name: "dump-" + kind,
+	}
+	if board >= 0 {
+		req.Board = &board
+	}
+	switch kind {
+	case "fifo", "counters", "config":
+		req.Args = []int{rfm}
+	}
+
+	err = json.NewEncoder(conn).Encode(req)
+	if err != nil {
+		return fmt.Errorf("could not send %q request: %w", req.Name, err)
+	}
+
+	var rep struct {
+		Msg  string `json:"msg"`
+		Data string `json:"data"`
+	}
+	err = json.NewDecoder(conn).Decode(&rep)
+	if err != nil {
+		return fmt.Errorf("could not read %q reply: %w", req.Name, err)
+	}
+	if rep.Msg != "ok" {
+		return fmt.Errorf("eda-svc could not dump %s: %s", kind, rep.Msg)
+	}
+
+	_, err = io.WriteString(w, rep.Data)
+	return err
+}
@@ -0,0 +1,108 @@
+// Copyright 2020 The go-lpc Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"encoding/json"
+	"net"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseCmd(t *testing.T) {
+	for _, tc := range []struct {
+		args []string
+		kind string
+		rfm  int
+		err  string
+	}{
+		{args: nil, kind: "registers"},
+		{args: []string{"dump"}, kind: "registers"},
+		{args: []string{"fifo", "2"}, kind: "fifo", rfm: 2},
+		{args: []string{"dump", "1"}, err: "invalid number of arguments for dump (got=1, want=0)"},
+		{args: []string{"fifo"}, err: "invalid number of arguments for fifo (got=0, want=1)"},
+		{args: []string{"counters", "0"}, kind: "counters"},
+		{args: []string{"config", "3"}, kind: "config", rfm: 3},
+		{args: []string{"config"}, err: "invalid number of arguments for config (got=0, want=1)"},
+		{args: []string{"fifo", "x"}, err: `could not parse RFM slot "x": strconv.Atoi: parsing "x": invalid syntax`},
+		{args: []string{"disk"}, kind: "disk"},
+		{args: []string{"disk", "1"}, err: "invalid number of arguments for disk (got=1, want=0)"},
+		{args: []string{"peek"}, err: `unknown command "peek"`},
+	} {
+		t.Run(strings.Join(tc.args, " "), func(t *testing.T) {
+			kind, rfm, err := parseCmd(tc.args)
+			switch {
+			case err != nil && tc.err != "":
+				if got, want := err.Error(), tc.err; got != want {
+					t.Fatalf("invalid error:\ngot= %v\nwant=%v", got, want)
+				}
+				return
+			case err != nil:
+				t.Fatalf("could not parse command: %+v", err)
+			case tc.err != "":
+				t.Fatalf("expected an error")
+			}
+			if kind != tc.kind || rfm != tc.rfm {
+				t.Fatalf("invalid command: got=(%q, %d), want=(%q, %d)", kind, rfm, tc.kind, tc.rfm)
+			}
+		})
+	}
+}
+
+func TestRemote(t *testing.T) {
+	srv, err := net.Listen("tcp", "localhost:0")
+	if err != nil {
+		t.Fatalf("could not create eda-svc server: %+v", err)
+	}
+	defer srv.Close()
+
+	type request struct {
+		Name  string `json:"name"`
+		Board *int   `json:"board"`
+		Args  []int  `json:"args"`
+	}
+	reqs := make(chan request, 2)
+	go func() {
+		for _, msg := range []string{"ok", "boom"} {
+			conn, err := srv.Accept()
+			if err != nil {
+				return
+			}
+			var req request
+			_ = json.NewDecoder(conn).Decode(&req)
+			reqs <- req
+			_ = json.NewEncoder(conn).Encode(map[string]string{
+				"msg":  msg,
+				"data": "pio.state= 0x1\n",
+			})
+			conn.Close()
+		}
+	}()
+
+	out := new(strings.Builder)
+	err = remote(out, srv.Addr().String(), 2, "fifo", 3)
+	if err != nil {
+		t.Fatalf("could not dump remote registers: %+v", err)
+	}
+	if got, want := out.String(), "pio.state= 0x1\n"; got != want {
+		t.Fatalf("invalid output: got=%q, want=%q", got, want)
+	}
+	board := 2
+	if got, want := <-reqs, (request{Name: "dump-fifo", Board: &board, Args: []int{3}}); !reflect.DeepEqual(got, want) {
+		t.Fatalf("invalid request:\ngot= %+v\nwant=%+v", got, want)
+	}
+
+	err = remote(out, srv.Addr().String(), -1, "registers", 0)
+	if err == nil {
+		t.Fatalf("expected an error")
+	}
+	if got, want := err.Error(), "eda-svc could not dump registers: boom"; got != want {
+		t.Fatalf("invalid error:\ngot= %v\nwant=%v", got, want)
+	}
+	if got, want := <-reqs, (request{Name: "dump-registers"}); !reflect.DeepEqual(got, want) {
+		t.Fatalf("invalid request:\ngot= %+v\nwant=%+v", got, want)
+	}
+}
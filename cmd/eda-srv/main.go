@@ -3,6 +3,15 @@
 // license that can be found in the LICENSE file.
 
 // Command eda-srv manages the data output files from EDA.
+//
+// EDA hosts announce the files they wrote: eda-srv fetches them and
+// removes them from the EDA host.
+//
+// With -poll, eda-srv also periodically lists the output directory of the
+// EDA host (see -remote-dir) and pulls the eda_*.raw files of the runs
+// in progress, starting from the run given with -run. A file is pulled
+// once a newer file (a later iteration or a later run) exists. Files are
+// thus recovered even if the announcing client on the EDA host died.
 package main // import "github.com/go-lpc/mim/cmd/eda-srv"
 
 import (
@@ -15,6 +24,8 @@ import (
 	"os"
 	"os/exec"
 	"path/filepath"
+
+	"github.com/go-lpc/mim/internal/cliconf"
 )
 
 func main() {
@@ -22,12 +33,24 @@ func main() {
 	log.SetFlags(0)
 
 	var (
-		odir = flag.String("dir", "", "output directory where to store files fetched from EDA")
-		host = flag.String("host", "", "EDA host where to fetch files from")
-		addr = flag.String("addr", ":8080", "[ip]:[port] to listen on")
+		cli  = cliconf.New(flag.CommandLine)
+		odir = cli.OutDir("")
+		host = cli.String("host", "", "EDA host where to fetch files from")
+		addr = cli.Addr(":8080")
+		poll = cli.Duration("poll", 0, "interval between listings of the EDA output directory (0: announced files only)")
+		rdir = cli.String("remote-dir", "/home/root/run", "output directory on the EDA host (with -poll)")
+		run  = cli.Int("run", -1, "first run to pull files from, with -poll (<0: any run)")
 	)
+	cli.Alias("dir", "o")
 
-	flag.Parse()
+	err := cli.Parse(os.Args[1:])
+	if err != nil {
+		log.Fatalf("could not parse input arguments: %+v", err)
+	}
+
+	if *poll > 0 {
+		go newPoller(*host, *rdir, *odir, *run).loop(*poll)
+	}
 
 	runFileSrv(*odir, *host, *addr)
 }
@@ -77,8 +100,14 @@ func serve(conn net.Conn, odir, host string) {
 			log.Printf("could not send ACK message back: %+v", err)
 		}
 
+		if !beginTransfer(fname) {
+			log.Printf("file %q already pulled", fname)
+			continue
+		}
+
 		log.Printf("fetching file %q...", fname)
 		err = fetch(odir, host, fname)
+		endTransfer(fname, err == nil)
 		if err != nil {
 			log.Printf("could not fetch file %q from %q: %+v", fname, host, err)
 			return
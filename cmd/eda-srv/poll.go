@@ -0,0 +1,225 @@
+// Copyright 2020 The go-lpc Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"log"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// transfers tracks the files fetched from EDA hosts, so a file announced
+// by an EDA host and found by the poller is only fetched once.
+var transfers = struct {
+	sync.Mutex
+	files map[string]bool // file name -> done
+}{files: make(map[string]bool)}
+
+// beginTransfer returns whether the provided file should be fetched,
+// i.e. whether it is neither being fetched nor already fetched.
+func beginTransfer(fname string) bool {
+	transfers.Lock()
+	defer transfers.Unlock()
+	if _, dup := transfers.files[fname]; dup {
+		return false
+	}
+	transfers.files[fname] = false
+	return true
+}
+
+// endTransfer records the end of the transfer of the provided file.
+// Failed transfers may be attempted again.
+func endTransfer(fname string, ok bool) {
+	transfers.Lock()
+	defer transfers.Unlock()
+	if !ok {
+		delete(transfers.files, fname)
+		return
+	}
+	transfers.files[fname] = true
+}
+
+// remoteFile describes a file in the output directory of an EDA host.
+type remoteFile struct {
+	name string // path of the file on the EDA host
+	size int64
+}
+
+// poller pulls the DAQ files of the runs in progress from the output
+// directory of an EDA host, without relying on announcements from the
+// EDA host.
+//
+// The EDA host writes the iterations of a run one after the other, so a
+// file is only pulled once a newer file (a later iteration of the same
+// run, or a file of a later run) was listed: the newest file may still
+// be written to, even if its size did not change between two listings.
+// The last file of a run is thus left to the announcing client on the
+// EDA host, or pulled once the next run started.
+type poller struct {
+	host string
+	dir  string // output directory on the EDA host
+	odir string // local output directory
+	run  int    // first run of the files to pull (<0: any run)
+
+	list   func(host, dir string) ([]remoteFile, error)
+	fetch  func(odir, host, fname string) error
+	remove func(host, fname string) error
+
+	newest rawID // newest DAQ file listed so far
+}
+
+// rawID identifies a DAQ file of a run.
+type rawID struct {
+	run int
+	itr int // iteration of the file within the run
+}
+
+// before returns whether the file id was written before the file o.
+func (id rawID) before(o rawID) bool {
+	if id.run != o.run {
+		return id.run < o.run
+	}
+	return id.itr < o.itr
+}
+
+func newPoller(host, dir, odir string, run int) *poller {
+	return &poller{
+		host:   host,
+		dir:    dir,
+		odir:   odir,
+		run:    run,
+		list:   list,
+		fetch:  fetch,
+		remove: remove,
+		newest: rawID{run: -1, itr: -1},
+	}
+}
+
+// loop polls the EDA host at the provided interval, forever.
+func (p *poller) loop(freq time.Duration) {
+	tck := time.NewTicker(freq)
+	defer tck.Stop()
+
+	for range tck.C {
+		err := p.poll()
+		if err != nil {
+			log.Printf("could not poll %q: %+v", p.host, err)
+		}
+	}
+}
+
+// poll lists the output directory of the EDA host and pulls the files
+// that are complete.
+func (p *poller) poll() error {
+	files, err := p.list(p.host, p.dir)
+	if err != nil {
+		return fmt.Errorf("could not list %s:%s: %w", p.host, p.dir, err)
+	}
+
+	var (
+		names = make([]string, 0, len(files))
+		ids   = make([]rawID, 0, len(files))
+	)
+	for _, f := range files {
+		id, ok := p.match(f.name)
+		if !ok {
+			continue
+		}
+		names = append(names, f.name)
+		ids = append(ids, id)
+		if p.newest.before(id) {
+			p.newest = id
+		}
+	}
+
+	for i, fname := range names {
+		if !ids[i].before(p.newest) {
+			// newest file: possibly still being written.
+			continue
+		}
+		if !beginTransfer(fname) {
+			continue
+		}
+
+		log.Printf("pulling file %q...", fname)
+		err = p.fetch(p.odir, p.host, fname)
+		if err != nil {
+			endTransfer(fname, false)
+			log.Printf("could not fetch file %q from %q: %+v", fname, p.host, err)
+			continue
+		}
+		endTransfer(fname, true)
+
+		log.Printf("removing file %q...", fname)
+		err = p.remove(p.host, fname)
+		if err != nil {
+			log.Printf("could not remove file %q from %q: %+v", fname, p.host, err)
+		}
+	}
+	return nil
+}
+
+// match returns the run and iteration of the provided file, and whether
+// it is a DAQ file of a run to pull.
+func (p *poller) match(fname string) (rawID, bool) {
+	var (
+		name = path.Base(fname)
+		id   rawID
+	)
+	ok, _ := filepath.Match("eda_*.raw", name)
+	if !ok {
+		return id, false
+	}
+	_, err := fmt.Sscanf(name, "eda_%d.%d.raw", &id.run, &id.itr)
+	if err != nil {
+		return id, false
+	}
+	return id, p.run < 0 || id.run >= p.run
+}
+
+// list lists the regular files of the provided directory of an EDA host.
+func list(host, dir string) ([]remoteFile, error) {
+	cmd := exec.Command("ssh", "-oCiphers=aes128-ctr", "root@"+host, "--", "/bin/ls", "-ln", dir)
+	cmd.Stderr = os.Stderr
+
+	out, err := cmd.Output()
+	if err != nil {
+		return nil, fmt.Errorf("could not list files from %q: %w", host, err)
+	}
+	return parseList(dir, out)
+}
+
+// parseList parses the output of 'ls -ln dir'.
+func parseList(dir string, out []byte) ([]remoteFile, error) {
+	var (
+		files []remoteFile
+		scan  = bufio.NewScanner(bytes.NewReader(out))
+	)
+	for scan.Scan() {
+		toks := strings.Fields(scan.Text())
+		if len(toks) < 9 || !strings.HasPrefix(toks[0], "-") {
+			// not a regular file (e.g. 'total' line, directory).
+			continue
+		}
+		size, err := strconv.ParseInt(toks[4], 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("could not parse size of %q: %w", toks[len(toks)-1], err)
+		}
+		files = append(files, remoteFile{
+			name: path.Join(dir, toks[len(toks)-1]),
+			size: size,
+		})
+	}
+	return files, scan.Err()
+}
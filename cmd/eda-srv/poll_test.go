@@ -0,0 +1,140 @@
+// Copyright 2020 The go-lpc Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"fmt"
+	"reflect"
+	"testing"
+)
+
+func TestParseList(t *testing.T) {
+	out := []byte(`total 12
+drwxr-xr-x    2 0        0             4096 Jan  1 00:00 old
+-rw-r--r--    1 0        0             1234 Jan  1 00:00 eda_042.000.raw
+-rw-r--r--    1 0        0               12 Jan  1 00:01 eda_042.001.raw
+`)
+	got, err := parseList("/home/root/run", out)
+	if err != nil {
+		t.Fatalf("could not parse listing: %+v", err)
+	}
+	want := []remoteFile{
+		{name: "/home/root/run/eda_042.000.raw", size: 1234},
+		{name: "/home/root/run/eda_042.001.raw", size: 12},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("invalid listing:\ngot= %+v\nwant=%+v", got, want)
+	}
+
+	_, err = parseList("/dir", []byte("-rw-r--r-- 1 0 0 x Jan 1 00:00 f\n"))
+	if err == nil {
+		t.Fatalf("expected an error")
+	}
+}
+
+func TestPoller(t *testing.T) {
+	var (
+		files   []remoteFile
+		fetched []string
+		removed []string
+		fail    = map[string]bool{}
+	)
+	p := newPoller("eda01", "/run", "/data", 42)
+	p.list = func(host, dir string) ([]remoteFile, error) {
+		return files, nil
+	}
+	p.fetch = func(odir, host, fname string) error {
+		if fail[fname] {
+			return fmt.Errorf("no route to host")
+		}
+		fetched = append(fetched, fname)
+		return nil
+	}
+	p.remove = func(host, fname string) error {
+		removed = append(removed, fname)
+		for i, f := range files {
+			if f.name == fname {
+				files = append(files[:i:i], files[i+1:]...)
+				break
+			}
+		}
+		return nil
+	}
+
+	defer func() {
+		transfers.Lock()
+		transfers.files = make(map[string]bool)
+		transfers.Unlock()
+	}()
+	// file announced (and fetched) by the EDA host.
+	if !beginTransfer("/run/eda_042.003.raw") {
+		t.Fatalf("could not begin transfer")
+	}
+	endTransfer("/run/eda_042.003.raw", true)
+
+	for i, step := range []struct {
+		files []remoteFile
+		fail  string
+		want  []string
+	}{
+		{
+			files: []remoteFile{
+				{"/run/eda_041.000.raw", 10},
+				{"/run/eda_042.000.raw", 10},
+				{"/run/eda_042.001.raw", 5},
+				{"/run/hr_sc_042.csv", 10},
+			},
+			want: []string{"/run/eda_042.000.raw"},
+		},
+		{
+			// newest file, even with a stable size.
+			files: []remoteFile{
+				{"/run/eda_041.000.raw", 10},
+				{"/run/eda_042.001.raw", 5},
+				{"/run/hr_sc_042.csv", 10},
+			},
+			want: []string{"/run/eda_042.000.raw"},
+		},
+		{
+			files: []remoteFile{
+				{"/run/eda_041.000.raw", 10},
+				{"/run/eda_042.001.raw", 8},
+				{"/run/eda_042.002.raw", 2},
+				{"/run/hr_sc_042.csv", 10},
+			},
+			fail: "/run/eda_042.001.raw",
+			want: []string{"/run/eda_042.000.raw"},
+		},
+		{
+			want: []string{"/run/eda_042.000.raw", "/run/eda_042.001.raw"},
+		},
+		{
+			// run 42 ended: its last file is complete.
+			files: []remoteFile{
+				{"/run/eda_041.000.raw", 10},
+				{"/run/eda_042.002.raw", 4},
+				{"/run/eda_042.003.raw", 4},
+				{"/run/eda_043.000.raw", 1},
+				{"/run/hr_sc_042.csv", 10},
+			},
+			want: []string{"/run/eda_042.000.raw", "/run/eda_042.001.raw", "/run/eda_042.002.raw"},
+		},
+	} {
+		if step.files != nil {
+			files = step.files
+		}
+		fail = map[string]bool{step.fail: true}
+		err := p.poll()
+		if err != nil {
+			t.Fatalf("step %d: could not poll: %+v", i, err)
+		}
+		if !reflect.DeepEqual(fetched, step.want) {
+			t.Fatalf("step %d: invalid fetched files:\ngot= %q\nwant=%q", i, fetched, step.want)
+		}
+		if !reflect.DeepEqual(removed, step.want) {
+			t.Fatalf("step %d: invalid removed files:\ngot= %q\nwant=%q", i, removed, step.want)
+		}
+	}
+}
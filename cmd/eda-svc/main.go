@@ -6,28 +6,158 @@ package main
 
 import (
 	"flag"
+	"fmt"
 	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/go-lpc/mim/eda"
+	"github.com/go-lpc/mim/internal/cliconf"
+	"github.com/go-lpc/mim/obs"
 )
 
 func main() {
 	var (
-		addr = flag.String("addr", ":9999", "eda-ctl [addr]:port")
-		odir = flag.String("o", "/home/root/run", "output dir")
+		cli  = cliconf.New(flag.CommandLine)
+		addr = cli.Addr(":9999")
+		odir = cli.OutDir("/home/root/run")
 
-		devmem = flag.String("dev-mem", "/dev/mem", "")
-		devshm = flag.String("dev-shm", "/dev/shm", "")
-		daq    = flag.String("mode", "dcc", "dcc/inj/noise run mode")
+		devmem = cli.DevMem()
+		devshm = cli.DevSHM()
+		daq    = flag.String("mode", "dcc", "dcc/inj/noise run mode (overridden by the trigger mode of scan requests)")
+		boards = flag.String("boards", "", "comma-separated list of id=dev-mem EDA boards to serve (default: single board on -dev-mem)")
+		presc  = flag.Int("noise-prescale", 1, "keep the data of 1 acquisition cycle out of n (noise mode)")
+		rate   = flag.Float64("noise-max-rate", 0, "maximum acquisition cycle rate in Hz (noise mode, 0: no limit)")
+		empty  = flag.Int("empty-prescale", 1, "send 1 DIF block without hardroc frames out of n to the DIF data sinks (0: none)")
+		comp   = flag.String("compress", "", "compression algorithm of local raw files (zstd, default: none)")
+		lvl    = flag.Int("compress-level", 3, "compression level of local raw files")
+		fwChk  = flag.Bool("fw-check", false, "check the FPGA firmware version register (not implemented by released firmwares)")
+		force  = flag.Bool("force", false, "run against FPGA firmware versions unknown to the driver")
+		summ   = flag.Bool("run-summary", false, "send an end-of-run summary to the DIF data sinks")
+		prov   = flag.Bool("provenance", false, "append a provenance trailer (board, slot, firmware and software versions) to DIF blocks")
+		free   = flag.Uint64("min-free", 64, "minimum free space in MiB of the output filesystems (0: no check)")
+		clkSrc = flag.String("clock-check", "", "source of the clock synchronization check at run start (chrony or NTP server, default: none)")
+		clkMax = flag.Duration("clock-max-offset", 10*time.Millisecond, "maximum offset of the clock at run start (see -clock-check)")
+		strict = flag.Bool("strict-time", false, "refuse to start runs when the clock is not synchronized (see -clock-check)")
+		thresh = flag.Uint("trig-threshold", 0, "hardroc discriminator (0 or 1) triggering the readout")
+		dual   = flag.Bool("dual-threshold", false, "record the hit counters of both discriminators along DIF blocks")
+		scPuls = flag.Duration("sc-pulse", time.Microsecond, "width of the slow-control reset pulse")
+		scTime = flag.Duration("sc-timeout", time.Second, "timeout of the slow-control serializer")
+		scRetr = flag.Int("sc-retries", 0, "number of retries of a failed hardroc slow-control")
+		fpgaCk = flag.Duration("fpga-check", time.Second, "interval between checks of the FPGA configuration during a run (0: no check)")
+		fpgaRe = flag.Int("fpga-retries", 1, "number of re-configurations of the FPGA after it lost its configuration during a run")
+		legacy = flag.Bool("legacy-counters", false, "use the historical layout of DIF header counters (cycle number starting at 1 in DTC and GTC, hits in ATC)")
+		batch  = flag.Int("readout-batch", 1, "maximum number of acquisition cycles read out before sending their DIF data (dcc mode, 1: no batching)")
+		budget = flag.Duration("readout-budget", 50*time.Millisecond, "maximum latency of the first acquisition cycle of a readout batch (see -readout-batch)")
+		procs  = flag.Int("max-procs", 0, "GOMAXPROCS during runs (0: unchanged)")
+		cpuRd  = flag.Int("cpu-reader", -1, "CPU the goroutine reading the RFM FIFOs is bound to during runs (-1: any)")
+		cpuTx  = flag.Int("cpu-senders", -1, "CPU the goroutines sending DIF data are bound to during runs (-1: any)")
+		bus    = flag.String("bus", "mem", "access to the FPGA buses (mem: memory device of -dev-mem, uio: UIO devices)")
+		maxDur = flag.Duration("max-duration", 0, "maximum duration of a run, after which it is stopped (0: no limit)")
+		maxCyc = flag.Int64("max-cycles", 0, "maximum number of acquisition cycles of a run, after which it is stopped (0: no limit)")
+		stats  = flag.Duration("sink-stats", 0, "interval between polls of the socket statistics of the DIF data sinks during runs (0: none, see -metrics-addr)")
+		mAddr  = flag.String("metrics-addr", "", "address of the HTTP server exposing the metrics of the devices as JSON (default: none)")
+		drv    = flag.String("backend", "go", "implementation of the EDA devices ("+strings.Join(eda.Backends(), ", ")+")")
+		trigCt eda.TriggerCounter
 	)
+	flag.Var(&trigCt, "trig-counter", "counter recorded in the DTC, ATC and GTC fields of DIF headers (cycle, fpga)")
 
 	log.SetPrefix("eda-ctl: ")
 	log.SetFlags(0)
 
-	flag.Parse()
+	err := cli.Parse(os.Args[1:])
+	if err != nil {
+		log.Fatalf("could not parse input arguments: %+v", err)
+	}
+
+	opts := []eda.Option{
+		eda.WithDAQMode(*daq),
+		eda.WithNoisePrescale(*presc),
+		eda.WithNoiseMaxRate(*rate),
+		eda.WithEmptyBlockPrescale(*empty),
+		eda.WithCompression(*comp, *lvl),
+		eda.WithFirmwareCheck(*fwChk),
+		eda.WithForceFirmware(*force),
+		eda.WithProvenance(*prov),
+		eda.WithRunSummary(*summ),
+		eda.WithMinFreeSpace(*free << 20),
+		eda.WithClockCheck(*clkSrc, *clkMax, *strict),
+		eda.WithTriggerThreshold(uint8(*thresh)),
+		eda.WithDualThreshold(*dual),
+		eda.WithSCTiming(eda.SCTiming{Pulse: *scPuls, Timeout: *scTime}),
+		eda.WithSCRetries(*scRetr),
+		eda.WithFPGAWatch(*fpgaCk, *fpgaRe),
+		eda.WithTriggerCounter(trigCt),
+		eda.WithLegacyCounters(*legacy),
+		eda.WithReadoutBatching(*batch, *budget),
+		eda.WithCPUAffinity(eda.CPUAffinity{MaxProcs: *procs, Reader: *cpuRd, Senders: *cpuTx}),
+		eda.WithBackend(*drv),
+		eda.WithBus(*bus, ""),
+		eda.WithMaxRunDuration(*maxDur),
+		eda.WithMaxRunCycles(*maxCyc),
+		eda.WithSinkStats(*stats),
+	}
+	if *mAddr != "" {
+		opts = append(opts, eda.WithMetrics(serveMetrics(*mAddr)))
+	}
+
+	if *boards == "" {
+		err = eda.Serve(*addr, *odir, *devmem, *devshm, opts...)
+		if err != nil {
+			log.Fatalf("could not create eda-ctl service: %+v", err)
+		}
+		return
+	}
 
-	err := eda.Serve(*addr, *odir, *devmem, *devshm, eda.WithDAQMode(*daq))
+	bs, err := parseBoards(*boards, *odir, *devshm)
+	if err != nil {
+		log.Fatalf("could not parse boards: %+v", err)
+	}
+
+	err = eda.ServeBoards(*addr, bs, opts...)
 	if err != nil {
 		log.Fatalf("could not create eda-ctl service: %+v", err)
 	}
 }
+
+// serveMetrics serves the metrics recorded in the returned registry as
+// JSON over HTTP on addr.
+func serveMetrics(addr string) *obs.Registry {
+	reg := obs.NewRegistry()
+	go func() {
+		err := http.ListenAndServe(addr, reg)
+		if err != nil {
+			log.Printf("could not serve metrics on %q: %+v", addr, err)
+		}
+	}()
+	return reg
+}
+
+// parseBoards parses a comma-separated list of id=dev-mem board
+// descriptions.
+// Each board gets its own output and shared memory sub-directories.
+func parseBoards(v, odir, devshm string) ([]eda.Board, error) {
+	var boards []eda.Board
+	for _, txt := range strings.Split(v, ",") {
+		toks := strings.SplitN(strings.TrimSpace(txt), "=", 2)
+		if len(toks) != 2 || toks[1] == "" {
+			return nil, fmt.Errorf("invalid board description %q", txt)
+		}
+		id, err := strconv.Atoi(toks[0])
+		if err != nil {
+			return nil, fmt.Errorf("invalid board ID %q: %w", toks[0], err)
+		}
+		sub := fmt.Sprintf("board-%02d", id)
+		boards = append(boards, eda.Board{
+			ID:     id,
+			DevMem: toks[1],
+			DevSHM: filepath.Join(devshm, sub),
+			ODir:   filepath.Join(odir, sub),
+		})
+	}
+	return boards, nil
+}
@@ -3,19 +3,24 @@
 // license that can be found in the LICENSE file.
 
 // Command eda2lcio converts an EDA raw data file to an LCIO one.
+//
+// DIF blocks are decoded, converted and written to the LCIO file by
+// concurrent stages. The memory used by the events in flight between
+// these stages is bounded by -max-mem.
 package main // import "github.com/go-lpc/mim/cmd/eda2lcio"
 
 import (
 	"compress/flate"
 	"flag"
 	"fmt"
-	"io"
 	"log"
 	"os"
 	"path/filepath"
 
+	"github.com/go-lpc/mim/internal/cliconf"
 	"github.com/go-lpc/mim/internal/eformat"
 	"github.com/go-lpc/mim/internal/xcnv"
+	"github.com/go-lpc/mim/internal/zseek"
 	"go-hep.org/x/hep/lcio"
 )
 
@@ -27,6 +32,8 @@ func main() {
 	var (
 		oname = flag.String("o", "out.lcio", "path to output LCIO file")
 		compr = flag.Int("lvl", flate.DefaultCompression, "compression level for output LCIO file")
+		expr  = flag.String("filter", "", "filter expression selecting DIF blocks to convert (e.g. \"frames>0 && difid==0xb7\")")
+		mem   = flag.Int64("max-mem", 1024, "maximum memory in MiB of the events in flight (0: sequential conversion)")
 	)
 
 	flag.Usage = func() {
@@ -34,12 +41,15 @@ func main() {
 
 ex:
  $> eda2lcio -o out.lcio -lvl=9 ./input.eda.raw
+ $> eda2lcio -o out.lcio -filter="frames>0" ./input.eda.raw
+ $> eda2lcio -o out.lcio -max-mem=256 ./input.eda.raw
 
 options:
 `)
 		flag.PrintDefaults()
 	}
 
+	cliconf.Version(flag.CommandLine)
 	flag.Parse()
 
 	if flag.NArg() != 1 {
@@ -52,19 +62,38 @@ options:
 		msg.Fatalf("invalid output LCIO file name")
 	}
 
-	err := process(*oname, *compr, flag.Arg(0))
+	if *mem < 0 {
+		flag.Usage()
+		msg.Fatalf("invalid -max-mem value %d", *mem)
+	}
+
+	opts := []xcnv.Option{xcnv.WithMaxMem(*mem << 20)}
+	if *expr != "" {
+		filter, err := xcnv.ParseFilter(*expr)
+		if err != nil {
+			msg.Fatalf("could not parse filter expression: %+v", err)
+		}
+		opts = append(opts, xcnv.WithFilter(filter))
+	}
+
+	err := process(*oname, *compr, flag.Arg(0), opts...)
 	if err != nil {
 		msg.Fatalf("could not convert EDA file: %+v", err)
 	}
 }
 
-func process(oname string, lvl int, fname string) error {
+func process(oname string, lvl int, fname string, opts ...xcnv.Option) error {
 	f, err := os.Open(fname)
 	if err != nil {
 		return fmt.Errorf("could not open EDA file: %w", err)
 	}
 	defer f.Close()
 
+	r, err := zseek.Open(f)
+	if err != nil {
+		return fmt.Errorf("could not open EDA file: %w", err)
+	}
+
 	run, err := runNbrFrom(fname)
 	if err != nil {
 		return fmt.Errorf("could not infer run from %q: %w", fname, err)
@@ -78,8 +107,8 @@ func process(oname string, lvl int, fname string) error {
 
 	w.SetCompressionLevel(lvl)
 
-	dec := eformat.NewDecoder(edaIDFrom(f), f)
-	err = xcnv.EDA2LCIO(w, dec, run, msg)
+	dec := eformat.NewAutoDecoder(r)
+	err = xcnv.EDA2LCIO(w, dec, run, msg, opts...)
 	if err != nil {
 		return fmt.Errorf("could not convert EDA to LCIO: %w", err)
 	}
@@ -92,15 +121,6 @@ func process(oname string, lvl int, fname string) error {
 	return nil
 }
 
-func edaIDFrom(f io.ReaderAt) uint8 {
-	p := []byte{0}
-	_, err := f.ReadAt(p, 1)
-	if err != nil {
-		panic(err)
-	}
-	return uint8(p[0])
-}
-
 func runNbrFrom(fname string) (int32, error) {
 	var (
 		name = filepath.Base(fname)
@@ -31,6 +31,10 @@ func TestRunNbrFrom(t *testing.T) {
 			fname: "../some/dir/eda_009.000.raw",
 			run:   9,
 		},
+		{
+			fname: "../some/dir/eda_010.000.raw.zst",
+			run:   10,
+		},
 	} {
 		t.Run(tc.fname, func(t *testing.T) {
 			got, err := runNbrFrom(tc.fname)
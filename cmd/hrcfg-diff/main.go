@@ -0,0 +1,373 @@
+// Copyright 2020 The go-lpc Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Command hrcfg-diff compares two hardroc configurations and prints the
+// differences, field by field.
+//
+// Usage: hrcfg-diff [OPTIONS] A B
+//
+// Configurations are read from:
+//   - db:NAME: the HR configuration NAME of the condition database
+//     (see -db), for the DIFs listed with -dif,
+//   - FILE.json: a JSON list of ASIC configurations, as stored in the
+//     condition database,
+//   - FILE.csv: the slow-control bitstream of the hardrocs of a RFM, one
+//     "hr;bit-address;value" line per bit, as read back from EDA boards.
+//     The DIF ID of the RFM is set with -dif.
+//   - FILE.sc: the raw slow-control image of the hardrocs of a RFM, as
+//     returned by the export-sc command of eda-svc.
+//     The DIF ID of the RFM is set with -dif.
+//
+// ASICs are matched by DIF ID and ASIC header:
+//
+//	$> hrcfg-diff -dif=1,2 db:cfg_run_728 db:cfg_run_731
+//	dif=1 asic=3 B0: 272 -> 280
+//	dif=1 asic=3 Mask1[12]: 1 -> 0
+//	dif=2 asic=48 only in A
+//
+// hrcfg-diff exits with status 1 when the configurations differ.
+package main // import "github.com/go-lpc/mim/cmd/hrcfg-diff"
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/go-lpc/mim/conddb"
+	"github.com/go-lpc/mim/internal/cliconf"
+)
+
+const (
+	nHR         = 8
+	nBitsCfgHR  = 872
+	nBytesCfgHR = nBitsCfgHR / 8
+	szSCHeader  = 4 // loop-back header of slow-control images
+)
+
+var openDB = func(name string) (*conddb.DB, error) {
+	return conddb.Open(name)
+}
+
+func main() {
+	log.SetPrefix("hrcfg-diff: ")
+	log.SetFlags(0)
+
+	diff, err := xmain(os.Stdout, os.Args[1:])
+	if err != nil {
+		log.Fatalf("%+v", err)
+	}
+	if diff {
+		os.Exit(1)
+	}
+}
+
+// xmain compares the configurations and reports whether they differ.
+func xmain(w io.Writer, args []string) (bool, error) {
+	var (
+		fset   = flag.NewFlagSet("hrcfg-diff", flag.ContinueOnError)
+		dbname = fset.String("db", "tmvsrv", "name of the condition database (db: configurations)")
+		difs   = fset.String("dif", "", "comma-separated list of DIF IDs to compare (default: all DIFs of JSON files)")
+	)
+
+	cliconf.Version(fset)
+
+	err := fset.Parse(args)
+	if err != nil {
+		return false, fmt.Errorf("could not parse input arguments: %w", err)
+	}
+
+	if fset.NArg() != 2 {
+		return false, fmt.Errorf("invalid number of arguments (got=%d, want=2)", fset.NArg())
+	}
+
+	ids, err := parseDIFs(*difs)
+	if err != nil {
+		return false, err
+	}
+
+	src := source{dbname: *dbname, difs: ids}
+	a, err := src.load(fset.Arg(0))
+	if err != nil {
+		return false, fmt.Errorf("could not load configuration %q: %w", fset.Arg(0), err)
+	}
+	b, err := src.load(fset.Arg(1))
+	if err != nil {
+		return false, fmt.Errorf("could not load configuration %q: %w", fset.Arg(1), err)
+	}
+
+	return diff(w, a, b), nil
+}
+
+// key identifies an ASIC within a configuration.
+type key struct {
+	dif uint8
+	hdr uint8
+}
+
+// diff prints the differences between the a and b configurations and
+// reports whether they differ.
+func diff(w io.Writer, a, b map[key]conddb.ASIC) bool {
+	keys := make([]key, 0, len(a)+len(b))
+	for k := range a {
+		keys = append(keys, k)
+	}
+	for k := range b {
+		if _, dup := a[k]; !dup {
+			keys = append(keys, k)
+		}
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if keys[i].dif != keys[j].dif {
+			return keys[i].dif < keys[j].dif
+		}
+		return keys[i].hdr < keys[j].hdr
+	})
+
+	var (
+		asics  int
+		fields int
+	)
+	for _, k := range keys {
+		ca, okA := a[k]
+		cb, okB := b[k]
+		switch {
+		case !okB:
+			fmt.Fprintf(w, "dif=%d asic=%d only in A\n", k.dif, k.hdr)
+			asics++
+		case !okA:
+			fmt.Fprintf(w, "dif=%d asic=%d only in B\n", k.dif, k.hdr)
+			asics++
+		default:
+			diffs := conddb.DiffASIC(ca, cb)
+			for _, d := range diffs {
+				fmt.Fprintf(w, "dif=%d asic=%d %v\n", k.dif, k.hdr, d)
+			}
+			if len(diffs) > 0 {
+				asics++
+				fields += len(diffs)
+			}
+		}
+	}
+
+	if asics > 0 {
+		fmt.Fprintf(w, "%d/%d ASICs differ (%d fields)\n", asics, len(keys), fields)
+	}
+	return asics > 0
+}
+
+type source struct {
+	dbname string
+	difs   []uint8
+}
+
+// load loads the ASIC configurations described by arg.
+func (src source) load(arg string) (map[key]conddb.ASIC, error) {
+	var (
+		asics []conddb.ASIC
+		err   error
+	)
+	switch {
+	case strings.HasPrefix(arg, "db:"):
+		asics, err = src.loadDB(strings.TrimPrefix(arg, "db:"))
+	case strings.HasSuffix(arg, ".json"):
+		asics, err = src.loadJSON(arg)
+	case strings.HasSuffix(arg, ".csv"):
+		asics, err = src.loadCSV(arg)
+	case strings.HasSuffix(arg, ".sc"):
+		asics, err = src.loadImage(arg)
+	default:
+		return nil, fmt.Errorf("unknown configuration kind (want db:NAME, FILE.json, FILE.csv or FILE.sc)")
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	cfg := make(map[key]conddb.ASIC, len(asics))
+	for _, asic := range asics {
+		k := key{dif: asic.DIFID, hdr: asic.Header}
+		if _, dup := cfg[k]; dup {
+			return nil, fmt.Errorf("duplicate ASIC %d for DIF %d", k.hdr, k.dif)
+		}
+		cfg[k] = asic
+	}
+	return cfg, nil
+}
+
+func (src source) loadDB(name string) ([]conddb.ASIC, error) {
+	if len(src.difs) == 0 {
+		return nil, fmt.Errorf("no DIF to compare (see -dif)")
+	}
+
+	db, err := openDB(src.dbname)
+	if err != nil {
+		return nil, fmt.Errorf("could not open condition db %q: %w", src.dbname, err)
+	}
+	defer db.Close()
+
+	cfgs, err := db.ASICConfigs(context.Background(), name, src.difs)
+	if err != nil {
+		return nil, fmt.Errorf("could not retrieve ASIC configurations: %w", err)
+	}
+
+	var asics []conddb.ASIC
+	for _, dif := range src.difs {
+		asics = append(asics, cfgs[dif]...)
+	}
+	return asics, nil
+}
+
+func (src source) loadJSON(fname string) ([]conddb.ASIC, error) {
+	raw, err := ioutil.ReadFile(fname)
+	if err != nil {
+		return nil, fmt.Errorf("could not read file: %w", err)
+	}
+
+	var asics []conddb.ASIC
+	err = json.Unmarshal(raw, &asics)
+	if err != nil {
+		return nil, fmt.Errorf("could not decode ASIC configurations: %w", err)
+	}
+
+	if len(src.difs) == 0 {
+		return asics, nil
+	}
+
+	o := asics[:0]
+	for _, asic := range asics {
+		if src.hasDIF(asic.DIFID) {
+			o = append(o, asic)
+		}
+	}
+	return o, nil
+}
+
+// loadCSV decodes the ASIC configurations from the slow-control bitstream
+// of the hardrocs of a RFM.
+func (src source) loadCSV(fname string) ([]conddb.ASIC, error) {
+	if len(src.difs) != 1 {
+		return nil, fmt.Errorf("bitstreams need exactly one DIF ID (see -dif)")
+	}
+
+	f, err := os.Open(fname)
+	if err != nil {
+		return nil, fmt.Errorf("could not open file: %w", err)
+	}
+	defer f.Close()
+
+	var (
+		bits = make([][]byte, nHR)
+		sc   = bufio.NewScanner(f)
+		line = 0
+	)
+	for i := range bits {
+		bits[i] = make([]byte, nBitsCfgHR)
+	}
+	for sc.Scan() {
+		line++
+		txt := strings.TrimSpace(sc.Text())
+		if txt == "" || strings.HasPrefix(txt, "#") {
+			continue
+		}
+		toks := strings.Split(txt, ";")
+		if len(toks) != 3 {
+			return nil, fmt.Errorf("invalid bitstream line %d: %q", line, txt)
+		}
+		var vs [3]uint64
+		for i, tok := range toks {
+			vs[i], err = strconv.ParseUint(tok, 10, 32)
+			if err != nil {
+				return nil, fmt.Errorf("invalid bitstream line %d: %q: %w", line, txt, err)
+			}
+		}
+		hr, addr, bit := vs[0], vs[1], vs[2]
+		if hr >= nHR || addr >= nBitsCfgHR || bit > 1 {
+			return nil, fmt.Errorf("invalid bitstream line %d: %q", line, txt)
+		}
+		// bitstreams are stored from the most significant bit address.
+		bits[hr][nBitsCfgHR-1-addr] = uint8(bit)
+	}
+	err = sc.Err()
+	if err != nil {
+		return nil, fmt.Errorf("could not scan bitstream: %w", err)
+	}
+
+	return src.fromBits(bits)
+}
+
+// loadImage decodes the ASIC configurations from the raw slow-control
+// image of the hardrocs of a RFM.
+func (src source) loadImage(fname string) ([]conddb.ASIC, error) {
+	if len(src.difs) != 1 {
+		return nil, fmt.Errorf("slow-control images need exactly one DIF ID (see -dif)")
+	}
+
+	img, err := ioutil.ReadFile(fname)
+	if err != nil {
+		return nil, fmt.Errorf("could not read file: %w", err)
+	}
+	if got, want := len(img), szSCHeader+nHR*nBytesCfgHR; got != want {
+		return nil, fmt.Errorf("invalid slow-control image size (got=%d, want=%d)", got, want)
+	}
+	img = img[szSCHeader:]
+
+	// the image starts with the last register of the last hardroc,
+	// bit addresses increasing from LSB to MSB.
+	bits := make([][]byte, nHR)
+	for hr := range bits {
+		bits[hr] = make([]byte, nBitsCfgHR)
+		for addr := 0; addr < nBitsCfgHR; addr++ {
+			v := img[(nHR-1-hr)*nBytesCfgHR+nBytesCfgHR-1-addr/8]
+			bits[hr][nBitsCfgHR-1-addr] = (v >> (addr % 8)) & 0x1
+		}
+	}
+
+	return src.fromBits(bits)
+}
+
+// fromBits decodes the ASIC configurations from the slow-control bits of
+// the hardrocs of a RFM, stored from the most significant bit address.
+func (src source) fromBits(bits [][]byte) ([]conddb.ASIC, error) {
+	asics := make([]conddb.ASIC, nHR)
+	for i := range asics {
+		err := asics[i].FromHRConfig(bits[i])
+		if err != nil {
+			return nil, fmt.Errorf("could not decode bitstream of HR %d: %w", i, err)
+		}
+		asics[i].DIFID = src.difs[0]
+	}
+	return asics, nil
+}
+
+func (src source) hasDIF(id uint8) bool {
+	for _, dif := range src.difs {
+		if dif == id {
+			return true
+		}
+	}
+	return false
+}
+
+func parseDIFs(v string) ([]uint8, error) {
+	if v == "" {
+		return nil, nil
+	}
+	var ids []uint8
+	for _, tok := range strings.Split(v, ",") {
+		id, err := strconv.ParseUint(strings.TrimSpace(tok), 10, 8)
+		if err != nil {
+			return nil, fmt.Errorf("invalid DIF ID %q: %w", tok, err)
+		}
+		ids = append(ids, uint8(id))
+	}
+	return ids, nil
+}
@@ -0,0 +1,211 @@
+// Copyright 2020 The go-lpc Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/go-lpc/mim/conddb"
+)
+
+func TestHRCfgDiff(t *testing.T) {
+	tmp, err := ioutil.TempDir("", "hrcfg-diff-")
+	if err != nil {
+		t.Fatalf("could not create tmp dir: %+v", err)
+	}
+	defer os.RemoveAll(tmp)
+
+	raw, err := ioutil.ReadFile("../../eda/testdata/asic-rfm-001.json")
+	if err != nil {
+		t.Fatalf("could not read ASICs: %+v", err)
+	}
+	var asics []conddb.ASIC
+	err = json.Unmarshal(raw, &asics)
+	if err != nil {
+		t.Fatalf("could not decode ASICs: %+v", err)
+	}
+
+	fnameA := filepath.Join(tmp, "a.json")
+	err = ioutil.WriteFile(fnameA, raw, 0644)
+	if err != nil {
+		t.Fatalf("could not write ASICs: %+v", err)
+	}
+
+	// bitstream of the same configuration.
+	fnameC := filepath.Join(tmp, "a.csv")
+	writeBitstream(t, fnameC, asics[:nHR])
+
+	// slow-control image of the same configuration.
+	fnameS := filepath.Join(tmp, "a.sc")
+	writeImage(t, fnameS, asics[:nHR])
+
+	fnameT := filepath.Join(tmp, "short.sc")
+	err = ioutil.WriteFile(fnameT, make([]byte, 10), 0644)
+	if err != nil {
+		t.Fatalf("could not write slow-control image: %+v", err)
+	}
+
+	mod := append([]conddb.ASIC(nil), asics...)
+	mod[1].B0 = 300
+	mod[1].Mask0 &^= 1 << 12
+	mod = append(mod[:2], mod[3:]...)
+	fnameB := filepath.Join(tmp, "b.json")
+	raw, err = json.Marshal(mod)
+	if err != nil {
+		t.Fatalf("could not encode ASICs: %+v", err)
+	}
+	err = ioutil.WriteFile(fnameB, raw, 0644)
+	if err != nil {
+		t.Fatalf("could not write ASICs: %+v", err)
+	}
+
+	for _, tc := range []struct {
+		name string
+		args []string
+		diff bool
+		want string
+		err  string
+	}{
+		{
+			name: "same",
+			args: []string{fnameA, fnameA},
+		},
+		{
+			name: "json-bitstream",
+			args: []string{"-dif=1", fnameA, fnameC},
+		},
+		{
+			name: "bitstream-image",
+			args: []string{"-dif=1", fnameC, fnameS},
+		},
+		{
+			name: "image-json",
+			args: []string{"-dif=1", fnameS, fnameB},
+			diff: true,
+			want: fmt.Sprintf(`dif=1 asic=%[1]d B0: %[3]d -> 300
+dif=1 asic=%[1]d Mask0[12]: 1 -> 0
+dif=1 asic=%[2]d only in A
+2/%[4]d ASICs differ (2 fields)
+`, asics[1].Header, asics[2].Header, asics[1].B0, nHR),
+		},
+		{
+			name: "image-size",
+			args: []string{"-dif=1", fnameA, fnameT},
+			err:  fmt.Sprintf("could not load configuration %q: invalid slow-control image size (got=10, want=876)", fnameT),
+		},
+		{
+			name: "json-json",
+			args: []string{fnameA, fnameB},
+			diff: true,
+			want: fmt.Sprintf(`dif=1 asic=%[1]d B0: %[4]d -> 300
+dif=1 asic=%[1]d Mask0[12]: 1 -> 0
+dif=1 asic=%[2]d only in A
+2/%[3]d ASICs differ (2 fields)
+`, asics[1].Header, asics[2].Header, len(asics), asics[1].B0),
+		},
+		{
+			name: "no-dif",
+			args: []string{fnameA, fnameC},
+			err:  fmt.Sprintf("could not load configuration %q: bitstreams need exactly one DIF ID (see -dif)", fnameC),
+		},
+		{
+			name: "db-no-dif",
+			args: []string{"db:cfg", fnameA},
+			err:  `could not load configuration "db:cfg": no DIF to compare (see -dif)`,
+		},
+		{
+			name: "unknown",
+			args: []string{"cfg.xml", fnameA},
+			err:  `could not load configuration "cfg.xml": unknown configuration kind (want db:NAME, FILE.json, FILE.csv or FILE.sc)`,
+		},
+		{
+			name: "nargs",
+			args: []string{fnameA},
+			err:  "invalid number of arguments (got=1, want=2)",
+		},
+		{
+			name: "invalid-dif",
+			args: []string{"-dif=1,x", fnameA, fnameB},
+			err:  `invalid DIF ID "x": strconv.ParseUint: parsing "x": invalid syntax`,
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			out := new(strings.Builder)
+			diff, err := xmain(out, tc.args)
+			switch {
+			case err != nil && tc.err != "":
+				if got, want := err.Error(), tc.err; got != want {
+					t.Fatalf("invalid error:\ngot= %q\nwant=%q", got, want)
+				}
+				return
+			case err != nil:
+				t.Fatalf("could not diff configurations: %+v", err)
+			case tc.err != "":
+				t.Fatalf("expected an error (%s)", tc.err)
+			}
+			if got, want := diff, tc.diff; got != want {
+				t.Fatalf("invalid diff status: got=%v, want=%v", got, want)
+			}
+			if got, want := out.String(), tc.want; got != want {
+				t.Fatalf("invalid output:\ngot:\n%s\nwant:\n%s", got, want)
+			}
+		})
+	}
+}
+
+// writeImage writes the raw slow-control image of the provided hardrocs,
+// as exported from EDA boards.
+func writeImage(t *testing.T, fname string, asics []conddb.ASIC) {
+	t.Helper()
+
+	img := make([]byte, szSCHeader+nHR*nBytesCfgHR)
+	copy(img, "\xca\xfe\xfa\xde")
+	for hr := 0; hr < nHR; hr++ {
+		bits := asics[hr].HRConfig()
+		for addr := 0; addr < nBitsCfgHR; addr++ {
+			i := szSCHeader + (nHR-1-hr)*nBytesCfgHR + nBytesCfgHR - 1 - addr/8
+			img[i] |= bits[nBitsCfgHR-1-addr] << (addr % 8)
+		}
+	}
+	err := ioutil.WriteFile(fname, img, 0644)
+	if err != nil {
+		t.Fatalf("could not write slow-control image: %+v", err)
+	}
+}
+
+// writeBitstream writes the slow-control bitstream of the provided
+// hardrocs, as read back from EDA boards.
+func writeBitstream(t *testing.T, fname string, asics []conddb.ASIC) {
+	t.Helper()
+
+	f, err := os.Create(fname)
+	if err != nil {
+		t.Fatalf("could not create bitstream: %+v", err)
+	}
+	defer f.Close()
+
+	w := bufio.NewWriter(f)
+	for i := nHR - 1; i >= 0; i-- {
+		bits := asics[i].HRConfig()
+		for j, bit := range bits {
+			fmt.Fprintf(w, "%d;%d;%d\n", i, nBitsCfgHR-1-j, bit)
+		}
+	}
+	err = w.Flush()
+	if err != nil {
+		t.Fatalf("could not flush bitstream: %+v", err)
+	}
+	err = f.Close()
+	if err != nil {
+		t.Fatalf("could not close bitstream: %+v", err)
+	}
+}
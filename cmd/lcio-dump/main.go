@@ -31,6 +31,7 @@ import (
 	"log"
 	"os"
 
+	"github.com/go-lpc/mim/internal/cliconf"
 	"github.com/go-lpc/mim/internal/eformat"
 	"github.com/go-lpc/mim/internal/xcnv"
 	"go-hep.org/x/hep/lcio"
@@ -76,6 +77,8 @@ func xmain(w io.Writer, args []string) {
 		fset.PrintDefaults()
 	}
 
+	cliconf.Version(fset)
+
 	err := fset.Parse(args)
 	if err != nil {
 		log.Fatalf("could not parse input arguments: %+v", err)
@@ -118,9 +121,9 @@ func process(w io.Writer, fname string, eda bool) error {
 		ch <- xcnv.LCIO2EDA(wp, r, 100, msg)
 	}()
 
+	var d eformat.DIF
 loop:
 	for {
-		var d eformat.DIF
 		err := dec.Decode(&d)
 		if err != nil {
 			if errors.Is(err, io.EOF) {
@@ -0,0 +1,260 @@
+// Copyright 2020 The go-lpc Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Command lcio-edit reads a LCIO file and rewrites some of its fields:
+// run number, detector name, event timestamps and collections.
+//
+// All the requested edits are applied in a single pass over the input file.
+package main // import "github.com/go-lpc/mim/cmd/lcio-edit"
+
+import (
+	"compress/flate"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"strings"
+
+	"github.com/go-lpc/mim/internal/cliconf"
+	"go-hep.org/x/hep/lcio"
+)
+
+func main() {
+	log.SetPrefix("lcio-edit: ")
+	log.SetFlags(0)
+
+	var (
+		runnbr = flag.Int("run", -1, "run number to use for output LCIO file (-1: keep input run number)")
+		det    = flag.String("det", "", "detector name to use for output LCIO file (default: keep input detector name)")
+		tshift = flag.Int64("ts-shift", 0, "offset to add to all event timestamps")
+		drop   = flag.String("drop", "", "comma-separated list of collections to drop")
+		rename = flag.String("rename", "", "comma-separated list of old=new collection names to rename")
+		oname  = flag.String("o", "out.lcio", "path to output rewritten LCIO file")
+		compr  = flag.Int("compr", flate.DefaultCompression, "compression level to use for output file")
+	)
+
+	flag.Usage = func() {
+		fmt.Printf(`Usage: lcio-edit [OPTIONS] FILE.lcio
+
+ex:
+ $> lcio-edit -o output.lcio -run=1234 -det=SD-HCAL -drop=foo -rename=RU_XDAQ=raw ./input.lcio
+ lcio-edit: processing event 0...
+ lcio-edit: processing event 10...
+ lcio-edit: processing event 20...
+ lcio-edit: processing event 30...
+ lcio-edit: processed 36 events
+
+options:
+`)
+		flag.PrintDefaults()
+	}
+
+	cliconf.Version(flag.CommandLine)
+	flag.Parse()
+
+	if flag.NArg() != 1 {
+		flag.Usage()
+		log.Fatalf("missing input LCIO file to rewrite")
+	}
+
+	ed, err := newEditor(*runnbr, *det, *tshift, *drop, *rename)
+	if err != nil {
+		log.Fatalf("invalid edit: %+v", err)
+	}
+
+	r, err := lcio.Open(flag.Arg(0))
+	if err != nil {
+		log.Fatalf("could not open input LCIO file: %+v", err)
+	}
+	defer r.Close()
+
+	w, err := lcio.Create(*oname)
+	if err != nil {
+		log.Fatalf("could not create output LCIO file: %+v", err)
+	}
+	defer w.Close()
+
+	w.SetCompressionLevel(*compr)
+
+	n, err := numEvents(flag.Arg(0))
+	if err != nil {
+		log.Fatalf("could not assess number of events: %+v", err)
+	}
+	log.Printf("input:  %s", flag.Arg(0))
+	log.Printf("events: %d", n)
+
+	err = process(w, r, ed, int(n/10))
+	if err != nil {
+		log.Fatalf("could not rewrite %q: %+v", flag.Arg(0), err)
+	}
+
+	err = w.Close()
+	if err != nil {
+		log.Fatalf("could not close output file: %+v", err)
+	}
+}
+
+// editor holds the edits to apply to run headers and events.
+type editor struct {
+	run    int32 // run number (-1: keep)
+	det    string
+	tshift int64
+	drop   map[string]struct{}
+	rename map[string]string
+}
+
+func newEditor(run int, det string, tshift int64, drop, rename string) (*editor, error) {
+	if run < -1 {
+		return nil, fmt.Errorf("invalid run number %d", run)
+	}
+
+	ed := &editor{
+		run:    int32(run),
+		det:    det,
+		tshift: tshift,
+		drop:   make(map[string]struct{}),
+		rename: make(map[string]string),
+	}
+
+	for _, name := range splitList(drop) {
+		ed.drop[name] = struct{}{}
+	}
+
+	for _, txt := range splitList(rename) {
+		toks := strings.SplitN(txt, "=", 2)
+		if len(toks) != 2 || toks[0] == "" || toks[1] == "" {
+			return nil, fmt.Errorf("invalid collection rename %q", txt)
+		}
+		if _, dup := ed.rename[toks[0]]; dup {
+			return nil, fmt.Errorf("collection %q renamed multiple times", toks[0])
+		}
+		if _, dropped := ed.drop[toks[0]]; dropped {
+			return nil, fmt.Errorf("collection %q is both dropped and renamed", toks[0])
+		}
+		ed.rename[toks[0]] = toks[1]
+	}
+
+	return ed, nil
+}
+
+func splitList(v string) []string {
+	var o []string
+	for _, s := range strings.Split(v, ",") {
+		s = strings.TrimSpace(s)
+		if s == "" {
+			continue
+		}
+		o = append(o, s)
+	}
+	return o
+}
+
+func (ed *editor) editRunHeader(rhdr *lcio.RunHeader) {
+	if ed.run >= 0 {
+		rhdr.RunNumber = ed.run
+	}
+	if ed.det != "" {
+		rhdr.Detector = ed.det
+	}
+}
+
+func (ed *editor) editEvent(evt lcio.Event) (lcio.Event, error) {
+	out := lcio.Event{
+		RunNumber:   evt.RunNumber,
+		EventNumber: evt.EventNumber,
+		TimeStamp:   evt.TimeStamp + ed.tshift,
+		Detector:    evt.Detector,
+		Params:      evt.Params,
+	}
+	if ed.run >= 0 {
+		out.RunNumber = ed.run
+	}
+	if ed.det != "" {
+		out.Detector = ed.det
+	}
+
+	for _, name := range evt.Names() {
+		if _, drop := ed.drop[name]; drop {
+			continue
+		}
+		oname := name
+		if v, ok := ed.rename[name]; ok {
+			oname = v
+		}
+		if out.Has(oname) {
+			return out, fmt.Errorf(
+				"could not rename collection %q: duplicate collection %q",
+				name, oname,
+			)
+		}
+		out.Add(oname, evt.Get(name))
+	}
+
+	return out, nil
+}
+
+func numEvents(fname string) (int64, error) {
+	r, err := lcio.Open(fname)
+	if err != nil {
+		return 0, fmt.Errorf("could not open %q: %w", fname, err)
+	}
+	defer r.Close()
+
+	var n int64
+	for r.Next() {
+		n++
+	}
+
+	err = r.Err()
+	if err != nil && err != io.EOF {
+		return 0, fmt.Errorf("could not assess number of events in %q: %w", fname, err)
+	}
+
+	return n, nil
+}
+
+func process(w *lcio.Writer, r *lcio.Reader, ed *editor, freq int) error {
+	if freq <= 0 {
+		freq = 1
+	}
+
+	var (
+		rhdr lcio.RunHeader
+		i    = 0
+	)
+	for r.Next() {
+		if i == 0 {
+			rhdr = r.RunHeader()
+			ed.editRunHeader(&rhdr)
+
+			err := w.WriteRunHeader(&rhdr)
+			if err != nil {
+				return fmt.Errorf("could not write run header: %w", err)
+			}
+
+		}
+
+		evt, err := ed.editEvent(r.Event())
+		if err != nil {
+			return fmt.Errorf("could not edit evt %d: %w", evt.EventNumber, err)
+		}
+		if i%freq == 0 {
+			log.Printf("processing event %d...", evt.EventNumber)
+		}
+		err = w.WriteEvent(&evt)
+		if err != nil {
+			return fmt.Errorf("could not write evt %d: %w", evt.EventNumber, err)
+		}
+		i++
+	}
+
+	err := r.Err()
+	if err != nil && err != io.EOF {
+		return fmt.Errorf("could not read LCIO file: %w", err)
+	}
+
+	log.Printf("processed %d events", i)
+
+	return nil
+}
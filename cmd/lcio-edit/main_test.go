@@ -0,0 +1,183 @@
+// Copyright 2020 The go-lpc Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"go-hep.org/x/hep/lcio"
+)
+
+func TestEdit(t *testing.T) {
+	tmp, err := ioutil.TempDir("", "mim-")
+	if err != nil {
+		t.Fatalf("could not create tmp dir: %+v", err)
+	}
+	defer os.RemoveAll(tmp)
+
+	var (
+		iname = filepath.Join(tmp, "in.lcio")
+		oname = filepath.Join(tmp, "out.lcio")
+		nevts = 3
+	)
+
+	{
+		w, err := lcio.Create(iname)
+		if err != nil {
+			t.Fatalf("could not create input LCIO file: %+v", err)
+		}
+		defer w.Close()
+
+		err = w.WriteRunHeader(&lcio.RunHeader{
+			RunNumber: 42,
+			Detector:  "det",
+		})
+		if err != nil {
+			t.Fatalf("could not write run header: %+v", err)
+		}
+
+		for i := 0; i < nevts; i++ {
+			evt := lcio.Event{
+				RunNumber:   42,
+				EventNumber: int32(i),
+				TimeStamp:   int64(100 + i),
+				Detector:    "det",
+			}
+			evt.Add("RU_XDAQ", &lcio.GenericObject{
+				Data: []lcio.GenericObjectData{{I32s: []int32{int32(i), 1, 2}}},
+			})
+			evt.Add("junk", &lcio.GenericObject{
+				Data: []lcio.GenericObjectData{{I32s: []int32{-1}}},
+			})
+			err = w.WriteEvent(&evt)
+			if err != nil {
+				t.Fatalf("could not write event %d: %+v", i, err)
+			}
+		}
+
+		err = w.Close()
+		if err != nil {
+			t.Fatalf("could not close input LCIO file: %+v", err)
+		}
+	}
+
+	ed, err := newEditor(1234, "SD-HCAL", 10, "junk", "RU_XDAQ=raw")
+	if err != nil {
+		t.Fatalf("could not create editor: %+v", err)
+	}
+
+	{
+		r, err := lcio.Open(iname)
+		if err != nil {
+			t.Fatalf("could not open input LCIO file: %+v", err)
+		}
+		defer r.Close()
+
+		w, err := lcio.Create(oname)
+		if err != nil {
+			t.Fatalf("could not create output LCIO file: %+v", err)
+		}
+		defer w.Close()
+
+		err = process(w, r, ed, 1)
+		if err != nil {
+			t.Fatalf("could not process LCIO file: %+v", err)
+		}
+
+		err = w.Close()
+		if err != nil {
+			t.Fatalf("could not close output LCIO file: %+v", err)
+		}
+	}
+
+	r, err := lcio.Open(oname)
+	if err != nil {
+		t.Fatalf("could not open output LCIO file: %+v", err)
+	}
+	defer r.Close()
+
+	i := 0
+	for r.Next() {
+		if i == 0 {
+			rhdr := r.RunHeader()
+			if got, want := rhdr.RunNumber, int32(1234); got != want {
+				t.Fatalf("invalid run header number: got=%d, want=%d", got, want)
+			}
+			if got, want := rhdr.Detector, "SD-HCAL"; got != want {
+				t.Fatalf("invalid run header detector: got=%q, want=%q", got, want)
+			}
+		}
+		evt := r.Event()
+		if got, want := evt.RunNumber, int32(1234); got != want {
+			t.Fatalf("evt %d: invalid run number: got=%d, want=%d", i, got, want)
+		}
+		if got, want := evt.Detector, "SD-HCAL"; got != want {
+			t.Fatalf("evt %d: invalid detector: got=%q, want=%q", i, got, want)
+		}
+		if got, want := evt.TimeStamp, int64(110+i); got != want {
+			t.Fatalf("evt %d: invalid timestamp: got=%d, want=%d", i, got, want)
+		}
+		if got, want := evt.Names(), []string{"raw"}; !reflect.DeepEqual(got, want) {
+			t.Fatalf("evt %d: invalid collections: got=%q, want=%q", i, got, want)
+		}
+		raw := evt.Get("raw").(*lcio.GenericObject)
+		if got, want := raw.Data[0].I32s, []int32{int32(i), 1, 2}; !reflect.DeepEqual(got, want) {
+			t.Fatalf("evt %d: invalid raw data: got=%v, want=%v", i, got, want)
+		}
+		i++
+	}
+	if i != nevts {
+		t.Fatalf("invalid number of events: got=%d, want=%d", i, nevts)
+	}
+}
+
+func TestNewEditor(t *testing.T) {
+	for _, tc := range []struct {
+		name   string
+		run    int
+		drop   string
+		rename string
+		err    string
+	}{
+		{name: "keep-all", run: -1},
+		{name: "invalid-run", run: -2, err: "invalid run number -2"},
+		{name: "invalid-rename", run: -1, rename: "foo", err: `invalid collection rename "foo"`},
+		{name: "empty-rename", run: -1, rename: "foo=", err: `invalid collection rename "foo="`},
+		{name: "dup-rename", run: -1, rename: "foo=bar,foo=baz", err: `collection "foo" renamed multiple times`},
+		{name: "drop-rename", run: -1, drop: "foo", rename: "foo=bar", err: `collection "foo" is both dropped and renamed`},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			_, err := newEditor(tc.run, "", 0, tc.drop, tc.rename)
+			switch {
+			case err == nil && tc.err == "":
+				// ok
+			case err == nil:
+				t.Fatalf("expected an error (%s)", tc.err)
+			case err.Error() != tc.err:
+				t.Fatalf("invalid error:\ngot= %v\nwant=%v", err, tc.err)
+			}
+		})
+	}
+}
+
+func TestEditDuplicate(t *testing.T) {
+	ed, err := newEditor(-1, "", 0, "", "a=b")
+	if err != nil {
+		t.Fatalf("could not create editor: %+v", err)
+	}
+
+	var evt lcio.Event
+	evt.Add("a", &lcio.GenericObject{})
+	evt.Add("b", &lcio.GenericObject{})
+
+	_, err = ed.editEvent(evt)
+	if err == nil {
+		t.Fatalf("expected an error")
+	}
+}
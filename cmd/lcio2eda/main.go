@@ -11,7 +11,10 @@ import (
 	"io"
 	"log"
 	"os"
+	"strings"
+	"time"
 
+	"github.com/go-lpc/mim/internal/cliconf"
 	"github.com/go-lpc/mim/internal/xcnv"
 	"go-hep.org/x/hep/lcio"
 )
@@ -23,6 +26,9 @@ var (
 func main() {
 	var (
 		oname = flag.String("o", "out.raw", "path to output EDA raw file")
+		expr  = flag.String("filter", "", "filter expression selecting DIF blocks to convert (e.g. \"frames>0 && difid==0xb7\")")
+		skip  = flag.Bool("skip-bad", false, "log and skip undecodable RU_XDAQ payloads instead of failing")
+		freq  = flag.Duration("progress", 5*time.Second, "interval between progress reports (0 to disable)")
 	)
 
 	flag.Usage = func() {
@@ -30,12 +36,14 @@ func main() {
 
 ex:
  $> lcio2eda -o out.raw ./input.lcio
+ $> lcio2eda -o out.raw -skip-bad -progress=1m ./input.lcio
 
 options:
 `)
 		flag.PrintDefaults()
 	}
 
+	cliconf.Version(flag.CommandLine)
 	flag.Parse()
 
 	if flag.NArg() != 1 {
@@ -48,7 +56,19 @@ options:
 		msg.Fatalf("invalid output EDA file name")
 	}
 
-	err := process(*oname, flag.Arg(0))
+	var opts []xcnv.Option
+	if *expr != "" {
+		filter, err := xcnv.ParseFilter(*expr)
+		if err != nil {
+			msg.Fatalf("could not parse filter expression: %+v", err)
+		}
+		opts = append(opts, xcnv.WithFilter(filter))
+	}
+	if *skip {
+		opts = append(opts, xcnv.WithSkipBad(true))
+	}
+
+	err := process(*oname, flag.Arg(0), *freq, opts...)
 	if err != nil {
 		msg.Fatalf("could not convert LCIO file: %+v", err)
 	}
@@ -74,16 +94,18 @@ func numEvents(fname string) (int64, error) {
 	return n, nil
 }
 
-func process(oname, fname string) error {
+func process(oname, fname string, freq time.Duration, opts ...xcnv.Option) error {
 	n, err := numEvents(fname)
 	if err != nil {
 		msg.Fatalf("could not assess number of events: %+v", err)
 	}
 	msg.Printf("input:  %s", fname)
 	msg.Printf("events: %d", n)
-	freq := int(n / 10)
-	if freq == 0 {
-		freq = 1
+
+	var prog *progress
+	if freq > 0 {
+		prog = newProgress(n, freq)
+		opts = append(opts, xcnv.WithProgress(prog.update))
 	}
 
 	r, err := lcio.Open(fname)
@@ -98,10 +120,13 @@ func process(oname, fname string) error {
 	}
 	defer f.Close()
 
-	err = xcnv.LCIO2EDA(f, r, freq, msg)
+	err = xcnv.LCIO2EDA(f, r, 0, msg, opts...)
 	if err != nil {
 		return fmt.Errorf("could not convert to EDA: %w", err)
 	}
+	if prog != nil {
+		prog.done()
+	}
 
 	err = f.Close()
 	if err != nil {
@@ -109,3 +134,73 @@ func process(oname, fname string) error {
 	}
 	return nil
 }
+
+// progress reports the progress of a conversion, with its event rate and
+// estimated time of arrival.
+type progress struct {
+	tot   int64         // total number of events
+	freq  time.Duration // interval between reports
+	start time.Time
+	last  time.Time // time of the last report
+	cur   int64     // number of processed events
+	now   func() time.Time
+}
+
+func newProgress(tot int64, freq time.Duration) *progress {
+	p := &progress{tot: tot, freq: freq, now: time.Now}
+	p.start = p.now()
+	p.last = p.start
+	return p
+}
+
+func (p *progress) update(n int) {
+	p.cur = int64(n)
+	now := p.now()
+	if now.Sub(p.last) < p.freq {
+		return
+	}
+	p.last = now
+	msg.Printf("%s", p.report(now))
+}
+
+// done reports the final state of the conversion.
+func (p *progress) done() {
+	elapsed := p.now().Sub(p.start)
+	msg.Printf("%s %d/%d events (%v)", bar(p.cur, p.tot), p.cur, p.tot, elapsed.Round(time.Millisecond))
+}
+
+func (p *progress) report(now time.Time) string {
+	var (
+		elapsed = now.Sub(p.start)
+		rate    = 0.0
+		eta     = "n/a"
+	)
+	if secs := elapsed.Seconds(); secs > 0 {
+		rate = float64(p.cur) / secs
+	}
+	if rate > 0 && p.cur <= p.tot {
+		left := time.Duration(float64(p.tot-p.cur) / rate * float64(time.Second))
+		eta = left.Round(time.Second).String()
+	}
+	return fmt.Sprintf(
+		"%s %d/%d events, %.1f evt/s, ETA: %s",
+		bar(p.cur, p.tot), p.cur, p.tot, rate, eta,
+	)
+}
+
+// bar returns a progress bar for the cur/tot ratio.
+func bar(cur, tot int64) string {
+	const width = 40
+	frac := 1.0
+	if tot > 0 {
+		frac = float64(cur) / float64(tot)
+	}
+	n := int(frac * width)
+	if n > width {
+		n = width
+	}
+	return fmt.Sprintf("[%s%s] %5.1f%%",
+		strings.Repeat("=", n), strings.Repeat(" ", width-n),
+		100*frac,
+	)
+}
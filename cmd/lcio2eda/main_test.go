@@ -9,7 +9,9 @@ import (
 	"io/ioutil"
 	"os"
 	"path/filepath"
+	"strconv"
 	"testing"
+	"time"
 
 	"github.com/go-lpc/mim/internal/eformat"
 	"github.com/go-lpc/mim/internal/xcnv"
@@ -95,8 +97,46 @@ func TestLCIO2EDA(t *testing.T) {
 		t.Fatalf("invalid number of events: got=%d, want=%d", got, want)
 	}
 
-	err = process(fname, fname+".lcio")
+	err = process(fname, fname+".lcio", time.Second)
 	if err != nil {
 		t.Fatalf("could not process LCIO->EDA: %+v", err)
 	}
 }
+
+func TestProgress(t *testing.T) {
+	start := time.Date(2021, 5, 12, 10, 0, 0, 0, time.UTC)
+	now := start
+	p := newProgress(1000, time.Minute)
+	p.start = start
+	p.last = start
+	p.now = func() time.Time { return now }
+
+	for _, tc := range []struct {
+		n    int
+		dt   time.Duration
+		want string
+	}{
+		{
+			n:    0,
+			dt:   0,
+			want: "[                                        ]   0.0% 0/1000 events, 0.0 evt/s, ETA: n/a",
+		},
+		{
+			n:    250,
+			dt:   10 * time.Second,
+			want: "[==========                              ]  25.0% 250/1000 events, 25.0 evt/s, ETA: 30s",
+		},
+		{
+			n:    1000,
+			dt:   40 * time.Second,
+			want: "[========================================] 100.0% 1000/1000 events, 25.0 evt/s, ETA: 0s",
+		},
+	} {
+		t.Run(strconv.Itoa(tc.n), func(t *testing.T) {
+			p.update(tc.n)
+			if got, want := p.report(start.Add(tc.dt)), tc.want; got != want {
+				t.Fatalf("invalid report:\ngot= %q\nwant=%q", got, want)
+			}
+		})
+	}
+}
@@ -0,0 +1,143 @@
+// Copyright 2020 The go-lpc Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Command mim-chaos is a TCP proxy injecting network faults, to test the
+// DAQ network protocols (e.g. EDA boards sending DIF data to their sinks)
+// under adverse conditions, before they happen during beam time.
+//
+// Usage: mim-chaos [OPTIONS] -target HOST:PORT
+//
+// mim-chaos listens on -addr and forwards each connection to -target,
+// chunk by chunk, in both directions. The following faults can be
+// injected:
+//   - latency: each chunk is delayed by -latency, plus a random delay of
+//     at most -jitter,
+//   - partial writes: a chunk is forwarded in several smaller writes, with
+//     probability -partial,
+//   - connection resets: the connection is reset (RST) on both sides,
+//     with probability -reset per chunk,
+//   - reordering: a chunk is swapped with the next one, with probability
+//     -reorder. This corrupts the stream, which receivers must detect.
+//
+// For example, to reset a DIF data connection every ~1000 DIF blocks:
+//
+//	$> mim-chaos -addr :9100 -target daq01:9000 -reset 0.001
+//
+// and point the EDA board to :9100 instead of daq01:9000.
+//
+// The counts of forwarded bytes and injected faults are printed every
+// -stats interval and on exit. Runs can be reproduced with -seed.
+package main // import "github.com/go-lpc/mim/cmd/mim-chaos"
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"net"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/go-lpc/mim/internal/cliconf"
+)
+
+func main() {
+	log.SetPrefix("mim-chaos: ")
+	log.SetFlags(0)
+
+	err := xmain(os.Args[1:])
+	if err != nil {
+		log.Fatalf("%+v", err)
+	}
+}
+
+func xmain(args []string) error {
+	var (
+		fset   = flag.NewFlagSet("mim-chaos", flag.ContinueOnError)
+		addr   = fset.String("addr", ":9100", "[address]:port to listen on")
+		target = fset.String("target", "", "[address]:port to forward connections to")
+		seed   = fset.Int64("seed", 0, "seed of the fault injection (0: time-based)")
+		freq   = fset.Duration("stats", 10*time.Second, "interval between statistics reports (0: on exit only)")
+
+		flt faults
+	)
+
+	fset.DurationVar(&flt.latency, "latency", 0, "latency added to each forwarded chunk")
+	fset.DurationVar(&flt.jitter, "jitter", 0, "maximum random latency added on top of -latency")
+	fset.Float64Var(&flt.partial, "partial", 0, "probability to forward a chunk in several partial writes")
+	fset.Float64Var(&flt.reset, "reset", 0, "probability to reset the connection, per chunk")
+	fset.Float64Var(&flt.reorder, "reorder", 0, "probability to swap a chunk with the next one")
+	fset.IntVar(&flt.chunk, "chunk", 4096, "maximum size in bytes of a forwarded chunk")
+
+	cliconf.Version(fset)
+
+	err := fset.Parse(args)
+	if err != nil {
+		return fmt.Errorf("could not parse input arguments: %w", err)
+	}
+
+	if *target == "" {
+		return fmt.Errorf("missing target address (see -target)")
+	}
+	for _, v := range []struct {
+		name string
+		p    float64
+	}{
+		{"partial", flt.partial},
+		{"reset", flt.reset},
+		{"reorder", flt.reorder},
+	} {
+		if v.p < 0 || v.p > 1 {
+			return fmt.Errorf("invalid -%s probability %v", v.name, v.p)
+		}
+	}
+
+	if *seed == 0 {
+		*seed = time.Now().UnixNano()
+	}
+
+	ln, err := net.Listen("tcp", *addr)
+	if err != nil {
+		return fmt.Errorf("could not listen on %q: %w", *addr, err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	sig := make(chan os.Signal, 1)
+	signal.Notify(sig, os.Interrupt, syscall.SIGTERM)
+	defer signal.Stop(sig)
+	go func() {
+		select {
+		case <-sig:
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+
+	msg := log.New(os.Stdout, "mim-chaos: ", 0)
+	p := newProxy(*target, flt, *seed, msg)
+	msg.Printf("forwarding %v -> %s (seed=%d)", ln.Addr(), *target, *seed)
+
+	if *freq > 0 {
+		go func() {
+			tck := time.NewTicker(*freq)
+			defer tck.Stop()
+			for {
+				select {
+				case <-ctx.Done():
+					return
+				case <-tck.C:
+					msg.Printf("stats: %v", &p.stats)
+				}
+			}
+		}()
+	}
+
+	err = p.serve(ctx, ln)
+	msg.Printf("stats: %v", &p.stats)
+	return err
+}
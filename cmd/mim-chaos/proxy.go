@@ -0,0 +1,302 @@
+// Copyright 2020 The go-lpc Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"math/rand"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	holdTimeout = 100 * time.Millisecond // maximum delay of a chunk held back for reordering
+)
+
+// errReset is returned by forward when a connection reset was injected.
+var errReset = errors.New("injected connection reset")
+
+// faults describes the faults injected in the forwarded streams.
+type faults struct {
+	latency time.Duration // latency added to each chunk
+	jitter  time.Duration // maximum random latency added on top of latency
+	partial float64       // probability to split a chunk into partial writes
+	reset   float64       // probability to reset the connection, per chunk
+	reorder float64       // probability to swap a chunk with the next one
+	chunk   int           // maximum size of a chunk
+}
+
+// stats counts the traffic of the proxy and the injected faults.
+type stats struct {
+	conns    int64 // number of proxied connections
+	bytes    int64 // number of forwarded bytes
+	partials int64 // number of chunks split into partial writes
+	resets   int64 // number of injected connection resets
+	reorders int64 // number of swapped chunks
+}
+
+func (st *stats) String() string {
+	return fmt.Sprintf(
+		"conns=%d bytes=%d partial-writes=%d resets=%d reorders=%d",
+		atomic.LoadInt64(&st.conns),
+		atomic.LoadInt64(&st.bytes),
+		atomic.LoadInt64(&st.partials),
+		atomic.LoadInt64(&st.resets),
+		atomic.LoadInt64(&st.reorders),
+	)
+}
+
+// proxy forwards TCP connections to a target address, injecting faults
+// in both directions.
+type proxy struct {
+	target string
+	flt    faults
+	msg    *log.Logger
+
+	mu  sync.Mutex
+	rnd *rand.Rand
+
+	stats stats
+}
+
+func newProxy(target string, flt faults, seed int64, msg *log.Logger) *proxy {
+	if flt.chunk <= 0 {
+		flt.chunk = 4096
+	}
+	return &proxy{
+		target: target,
+		flt:    flt,
+		msg:    msg,
+		rnd:    rand.New(rand.NewSource(seed)),
+	}
+}
+
+// float returns a pseudo-random number in [0,1).
+func (p *proxy) float() float64 {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.rnd.Float64()
+}
+
+// intn returns a pseudo-random number in [0,n).
+func (p *proxy) intn(n int64) int64 {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.rnd.Int63n(n)
+}
+
+// serve accepts connections on ln and proxies them, until ctx is done.
+func (p *proxy) serve(ctx context.Context, ln net.Listener) error {
+	go func() {
+		<-ctx.Done()
+		ln.Close()
+	}()
+
+	var wg sync.WaitGroup
+	defer wg.Wait()
+
+	for {
+		conn, err := ln.Accept()
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
+			return fmt.Errorf("could not accept connection: %w", err)
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			p.handle(ctx, conn)
+		}()
+	}
+}
+
+// handle proxies the provided client connection to the target.
+func (p *proxy) handle(ctx context.Context, cli net.Conn) {
+	defer cli.Close()
+
+	var dialer net.Dialer
+	srv, err := dialer.DialContext(ctx, "tcp", p.target)
+	if err != nil {
+		p.msg.Printf("could not dial %q for %v: %+v", p.target, cli.RemoteAddr(), err)
+		return
+	}
+	defer srv.Close()
+
+	atomic.AddInt64(&p.stats.conns, 1)
+	p.msg.Printf("proxying %v -> %v", cli.RemoteAddr(), p.target)
+
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		select {
+		case <-ctx.Done():
+			cli.Close()
+			srv.Close()
+		case <-done:
+		}
+	}()
+
+	errc := make(chan error, 2)
+	go func() { errc <- p.pipe(srv, cli) }()
+	go func() { errc <- p.pipe(cli, srv) }()
+
+	for i := 0; i < 2; i++ {
+		err := <-errc
+		switch {
+		case err == nil:
+			// half-close: wait for the other direction.
+			continue
+		case errors.Is(err, errReset):
+			p.msg.Printf("reset connection %v -> %v", cli.RemoteAddr(), p.target)
+			resetConn(cli)
+			resetConn(srv)
+		case ctx.Err() == nil:
+			p.msg.Printf("connection %v -> %v: %+v", cli.RemoteAddr(), p.target, err)
+		}
+		return
+	}
+}
+
+// pipe forwards data from src to dst, and half-closes dst at the end of
+// the src stream.
+func (p *proxy) pipe(dst, src net.Conn) error {
+	err := p.forward(dst, src)
+	if err != nil {
+		return err
+	}
+	if c, ok := dst.(interface{ CloseWrite() error }); ok {
+		_ = c.CloseWrite()
+	}
+	return nil
+}
+
+// forward copies src to dst, chunk by chunk, injecting faults.
+//
+// A chunk held back to be swapped with the next one is flushed after
+// holdTimeout when no other chunk comes, so request/response protocols
+// do not stall.
+func (p *proxy) forward(dst io.Writer, src io.Reader) error {
+	var (
+		buf  = make([]byte, p.flt.chunk)
+		held []byte // chunk held back, to be swapped with the next one
+	)
+	hold := func(chunk []byte) {
+		held = append(held[:0], chunk...)
+		if d, ok := src.(interface{ SetReadDeadline(time.Time) error }); ok {
+			_ = d.SetReadDeadline(time.Now().Add(holdTimeout))
+		}
+	}
+	flush := func() error {
+		if held == nil {
+			return nil
+		}
+		if d, ok := src.(interface{ SetReadDeadline(time.Time) error }); ok {
+			_ = d.SetReadDeadline(time.Time{})
+		}
+		err := p.write(dst, held)
+		held = nil
+		return err
+	}
+
+	for {
+		n, err := src.Read(buf)
+		if n > 0 {
+			chunk := buf[:n]
+			if p.flt.reset > 0 && p.float() < p.flt.reset {
+				atomic.AddInt64(&p.stats.resets, 1)
+				return errReset
+			}
+			p.delay()
+
+			switch {
+			case held != nil:
+				werr := p.write(dst, chunk)
+				if werr == nil {
+					werr = flush()
+				}
+				if werr != nil {
+					return werr
+				}
+			case p.flt.reorder > 0 && p.float() < p.flt.reorder:
+				atomic.AddInt64(&p.stats.reorders, 1)
+				hold(chunk)
+			default:
+				werr := p.write(dst, chunk)
+				if werr != nil {
+					return werr
+				}
+			}
+		}
+		if err != nil {
+			var nerr net.Error
+			if errors.As(err, &nerr) && nerr.Timeout() && held != nil {
+				// no chunk to swap with.
+				atomic.AddInt64(&p.stats.reorders, -1)
+				werr := flush()
+				if werr != nil {
+					return werr
+				}
+				continue
+			}
+			werr := flush()
+			if werr != nil {
+				return werr
+			}
+			if errors.Is(err, io.EOF) {
+				return nil
+			}
+			return err
+		}
+	}
+}
+
+// write writes a chunk to dst, possibly in several partial writes.
+func (p *proxy) write(dst io.Writer, chunk []byte) error {
+	if len(chunk) > 1 && p.flt.partial > 0 && p.float() < p.flt.partial {
+		atomic.AddInt64(&p.stats.partials, 1)
+		for len(chunk) > 0 {
+			n := 1 + int(p.intn(int64(len(chunk))))
+			_, err := dst.Write(chunk[:n])
+			if err != nil {
+				return err
+			}
+			atomic.AddInt64(&p.stats.bytes, int64(n))
+			chunk = chunk[n:]
+			time.Sleep(time.Millisecond)
+		}
+		return nil
+	}
+
+	n, err := dst.Write(chunk)
+	atomic.AddInt64(&p.stats.bytes, int64(n))
+	return err
+}
+
+// delay sleeps for the configured latency and jitter.
+func (p *proxy) delay() {
+	d := p.flt.latency
+	if p.flt.jitter > 0 {
+		d += time.Duration(p.intn(int64(p.flt.jitter)))
+	}
+	if d > 0 {
+		time.Sleep(d)
+	}
+}
+
+// resetConn closes a TCP connection with a RST, instead of the orderly
+// FIN sequence.
+func resetConn(conn net.Conn) {
+	if c, ok := conn.(*net.TCPConn); ok {
+		_ = c.SetLinger(0)
+	}
+	_ = conn.Close()
+}
@@ -0,0 +1,236 @@
+// Copyright 2020 The go-lpc Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"io/ioutil"
+	"log"
+	"math/rand"
+	"net"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestForward(t *testing.T) {
+	for _, tc := range []struct {
+		name  string
+		flt   faults
+		input string
+		want  string
+		err   error
+		stats stats
+	}{
+		{
+			name:  "no-fault",
+			input: "aaaabbbbccccdddd",
+			want:  "aaaabbbbccccdddd",
+			stats: stats{bytes: 16},
+		},
+		{
+			name:  "partial",
+			flt:   faults{partial: 1},
+			input: "aaaabbbbccccdddd",
+			want:  "aaaabbbbccccdddd",
+			stats: stats{bytes: 16, partials: 4},
+		},
+		{
+			name:  "reorder",
+			flt:   faults{reorder: 1},
+			input: "aaaabbbbccccdddd",
+			want:  "bbbbaaaaddddcccc",
+			stats: stats{bytes: 16, reorders: 2},
+		},
+		{
+			name:  "reorder-eof",
+			flt:   faults{reorder: 1},
+			input: "aaaabbbbcccc",
+			want:  "bbbbaaaacccc",
+			stats: stats{bytes: 12, reorders: 2},
+		},
+		{
+			name:  "reset",
+			flt:   faults{reset: 1},
+			input: "aaaabbbb",
+			err:   errReset,
+			stats: stats{resets: 1},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			tc.flt.chunk = 4
+			var (
+				p   = newProxy("", tc.flt, 1234, log.New(ioutil.Discard, "", 0))
+				dst = new(bytes.Buffer)
+			)
+			err := p.forward(dst, strings.NewReader(tc.input))
+			if !errors.Is(err, tc.err) {
+				t.Fatalf("invalid error: got=%v, want=%v", err, tc.err)
+			}
+			if got, want := dst.String(), tc.want; got != want {
+				t.Fatalf("invalid output: got=%q, want=%q", got, want)
+			}
+			if got, want := p.stats, tc.stats; got != want {
+				t.Fatalf("invalid stats: got=%+v, want=%+v", got, want)
+			}
+		})
+	}
+}
+
+func TestProxy(t *testing.T) {
+	srv, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("could not create echo server: %+v", err)
+	}
+	defer srv.Close()
+	go func() {
+		for {
+			conn, err := srv.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				_, _ = io.Copy(conn, conn)
+			}()
+		}
+	}()
+
+	newProxy := func(t *testing.T, flt faults) (*proxy, string, func()) {
+		t.Helper()
+		ln, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			t.Fatalf("could not create proxy listener: %+v", err)
+		}
+		var (
+			p           = newProxy(srv.Addr().String(), flt, 1234, log.New(ioutil.Discard, "", 0))
+			ctx, cancel = context.WithCancel(context.Background())
+			done        = make(chan error, 1)
+		)
+		go func() { done <- p.serve(ctx, ln) }()
+		return p, ln.Addr().String(), func() {
+			cancel()
+			err := <-done
+			if err != nil {
+				t.Fatalf("could not run proxy: %+v", err)
+			}
+		}
+	}
+
+	t.Run("stream", func(t *testing.T) {
+		p, addr, stop := newProxy(t, faults{partial: 0.5, jitter: time.Millisecond})
+		defer stop()
+
+		conn, err := net.Dial("tcp", addr)
+		if err != nil {
+			t.Fatalf("could not dial proxy: %+v", err)
+		}
+		defer conn.Close()
+
+		want := make([]byte, 64<<10)
+		rand.New(rand.NewSource(42)).Read(want)
+		go func() {
+			_, _ = conn.Write(want)
+			_ = conn.(*net.TCPConn).CloseWrite()
+		}()
+
+		got, err := ioutil.ReadAll(conn)
+		if err != nil {
+			t.Fatalf("could not read echo: %+v", err)
+		}
+		if !bytes.Equal(got, want) {
+			t.Fatalf("stream corrupted by proxy (got %d bytes, want %d)", len(got), len(want))
+		}
+		if atomic.LoadInt64(&p.stats.partials) == 0 {
+			t.Fatalf("no partial writes injected")
+		}
+	})
+
+	t.Run("request-response", func(t *testing.T) {
+		_, addr, stop := newProxy(t, faults{reorder: 1})
+		defer stop()
+
+		conn, err := net.Dial("tcp", addr)
+		if err != nil {
+			t.Fatalf("could not dial proxy: %+v", err)
+		}
+		defer conn.Close()
+
+		// a held back chunk is flushed when no other chunk comes.
+		for _, req := range []string{"HDR\x00", "ACK\x00"} {
+			_, err = conn.Write([]byte(req))
+			if err != nil {
+				t.Fatalf("could not send request: %+v", err)
+			}
+			rep := make([]byte, len(req))
+			_ = conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+			_, err = io.ReadFull(conn, rep)
+			if err != nil {
+				t.Fatalf("could not read response: %+v", err)
+			}
+			if got, want := string(rep), req; got != want {
+				t.Fatalf("invalid response: got=%q, want=%q", got, want)
+			}
+		}
+	})
+
+	t.Run("reset", func(t *testing.T) {
+		p, addr, stop := newProxy(t, faults{reset: 1})
+		defer stop()
+
+		conn, err := net.Dial("tcp", addr)
+		if err != nil {
+			t.Fatalf("could not dial proxy: %+v", err)
+		}
+		defer conn.Close()
+
+		_, err = conn.Write([]byte("HDR\x00"))
+		if err != nil {
+			t.Fatalf("could not send request: %+v", err)
+		}
+		_ = conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+		n, err := conn.Read(make([]byte, 4))
+		if err == nil || n != 0 {
+			t.Fatalf("expected a reset connection (n=%d, err=%v)", n, err)
+		}
+		var nerr net.Error
+		if errors.As(err, &nerr) && nerr.Timeout() {
+			t.Fatalf("connection was not reset: %+v", err)
+		}
+		if got, want := atomic.LoadInt64(&p.stats.resets), int64(1); got != want {
+			t.Fatalf("invalid number of resets: got=%d, want=%d", got, want)
+		}
+	})
+}
+
+func TestXMain(t *testing.T) {
+	for _, tc := range []struct {
+		args []string
+		err  string
+	}{
+		{
+			args: nil,
+			err:  "missing target address (see -target)",
+		},
+		{
+			args: []string{"-target=localhost:9000", "-reset=2"},
+			err:  "invalid -reset probability 2",
+		},
+	} {
+		t.Run("", func(t *testing.T) {
+			err := xmain(tc.args)
+			if err == nil {
+				t.Fatalf("expected an error")
+			}
+			if got, want := err.Error(), tc.err; got != want {
+				t.Fatalf("invalid error: got=%q, want=%q", got, want)
+			}
+		})
+	}
+}
@@ -7,6 +7,7 @@ package main // import "github.com/go-lpc/mim/cmd/mim-daq"
 
 import (
 	"context"
+	"flag"
 	"fmt"
 	"io"
 	"os"
@@ -16,10 +17,12 @@ import (
 	"github.com/go-daq/tdaq/config"
 	"github.com/go-daq/tdaq/flags"
 	"github.com/go-daq/tdaq/log"
+	"github.com/go-lpc/mim/internal/cliconf"
 	"github.com/peterh/liner"
 )
 
 func main() {
+	cliconf.Version(flag.CommandLine)
 	cmd := flags.NewRunControl()
 
 	run(cmd, os.Stdout)
@@ -7,6 +7,7 @@ package main // import "github.com/go-lpc/mim/cmd/mim-rpi"
 
 import (
 	"context"
+	"flag"
 	"log"
 	"math/rand"
 	"os"
@@ -14,9 +15,11 @@ import (
 
 	"github.com/go-daq/tdaq"
 	"github.com/go-daq/tdaq/flags"
+	"github.com/go-lpc/mim/internal/cliconf"
 )
 
 func main() {
+	cliconf.Version(flag.CommandLine)
 	cmd := flags.New()
 
 	dev := rpi{
@@ -0,0 +1,258 @@
+// Copyright 2020 The go-lpc Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Command mim-runs queries the run bookkeeping tables of the MIM database.
+//
+// Usage: mim-runs [OPTIONS] COMMAND [ARGS]
+//
+// Commands:
+//   - init: create the run bookkeeping tables,
+//   - list [-n N] [-json]: list the last N runs (default: 20),
+//   - show RUN: show the records and settings of a run,
+//   - files [-kind KIND] RUN: list the files of a run, as host:path,
+//   - add-file RUN HOST PATH KIND: record the location of a file of a run.
+//
+// Runs are recorded by EDA boards at start and stop (see eda.WithRunDB).
+// Files produced elsewhere (e.g. LCIO files converted on storage hosts)
+// can be recorded with add-file:
+//
+//	$> mim-runs add-file 42 lpccaf /data/run_042.slcio lcio
+//	$> scp $(mim-runs files -kind lcio 42) .
+package main // import "github.com/go-lpc/mim/cmd/mim-runs"
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"strconv"
+	"text/tabwriter"
+	"time"
+
+	"github.com/go-lpc/mim/conddb"
+	"github.com/go-lpc/mim/internal/cliconf"
+	"github.com/go-lpc/mim/model"
+)
+
+var openDB = func(name string) (*conddb.DB, error) {
+	return conddb.Open(name)
+}
+
+func main() {
+	log.SetPrefix("mim-runs: ")
+	log.SetFlags(0)
+
+	err := xmain(os.Stdout, os.Args[1:])
+	if err != nil {
+		log.Fatalf("%+v", err)
+	}
+}
+
+func xmain(w io.Writer, args []string) error {
+	var (
+		fset   = flag.NewFlagSet("mim-runs", flag.ContinueOnError)
+		dbname = fset.String("db", "tmvsrv", "name of the MIM database")
+	)
+
+	cliconf.Version(fset)
+
+	err := fset.Parse(args)
+	if err != nil {
+		return fmt.Errorf("could not parse input arguments: %w", err)
+	}
+
+	args = fset.Args()
+	if len(args) == 0 {
+		return fmt.Errorf("missing command")
+	}
+
+	var cmd func(ctx context.Context, w io.Writer, db *conddb.DB, args []string) error
+	switch args[0] {
+	case "init":
+		cmd = cmdInit
+	case "list":
+		cmd = cmdList
+	case "show":
+		cmd = cmdShow
+	case "files":
+		cmd = cmdFiles
+	case "add-file":
+		cmd = cmdAddFile
+	default:
+		return fmt.Errorf("unknown command %q", args[0])
+	}
+
+	db, err := openDB(*dbname)
+	if err != nil {
+		return fmt.Errorf("could not open MIM db %q: %w", *dbname, err)
+	}
+
+	err = cmd(context.Background(), w, db, args[1:])
+	if err != nil {
+		_ = db.Close()
+		return fmt.Errorf("could not run %q: %w", args[0], err)
+	}
+
+	return db.Close()
+}
+
+func cmdInit(ctx context.Context, w io.Writer, db *conddb.DB, args []string) error {
+	if len(args) != 0 {
+		return fmt.Errorf("invalid number of arguments (got=%d, want=0)", len(args))
+	}
+	return db.CreateRunTables(ctx)
+}
+
+func cmdList(ctx context.Context, w io.Writer, db *conddb.DB, args []string) error {
+	var (
+		fset = flag.NewFlagSet("list", flag.ContinueOnError)
+		n    = fset.Int("n", 20, "number of runs to list")
+		js   = fset.Bool("json", false, "list runs as JSON")
+	)
+	err := fset.Parse(args)
+	if err != nil {
+		return err
+	}
+
+	runs, err := db.Runs(ctx, *n)
+	if err != nil {
+		return err
+	}
+
+	if *js {
+		infos := make([]model.RunInfo, len(runs))
+		for i, run := range runs {
+			infos[i] = run.Info()
+		}
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		return enc.Encode(infos)
+	}
+
+	return printRuns(w, runs)
+}
+
+func cmdShow(ctx context.Context, w io.Writer, db *conddb.DB, args []string) error {
+	id, err := parseRun(args, 1)
+	if err != nil {
+		return err
+	}
+
+	runs, err := db.LookupRun(ctx, id)
+	if err != nil {
+		return err
+	}
+	if len(runs) == 0 {
+		return fmt.Errorf("no such run %d", id)
+	}
+
+	err = printRuns(w, runs)
+	if err != nil {
+		return err
+	}
+
+	for _, run := range runs {
+		fmt.Fprintf(w, "\nsettings (board=%d):\n", run.Board)
+		buf := new(bytes.Buffer)
+		err = json.Indent(buf, []byte(run.Settings), "", "  ")
+		if err != nil {
+			// not JSON: print settings as-is.
+			buf.Reset()
+			buf.WriteString(run.Settings)
+		}
+		fmt.Fprintf(w, "%s\n", buf.Bytes())
+	}
+
+	files, err := db.RunFiles(ctx, id)
+	if err != nil {
+		return err
+	}
+	if len(files) == 0 {
+		return nil
+	}
+
+	fmt.Fprintf(w, "\nfiles:\n")
+	tw := tabwriter.NewWriter(w, 0, 8, 2, ' ', 0)
+	for _, f := range files {
+		fmt.Fprintf(tw, "%s\t%s:%s\n", f.Kind, f.Host, f.Path)
+	}
+	return tw.Flush()
+}
+
+func cmdFiles(ctx context.Context, w io.Writer, db *conddb.DB, args []string) error {
+	var (
+		fset = flag.NewFlagSet("files", flag.ContinueOnError)
+		kind = fset.String("kind", "", "kind of files to list (default: all)")
+	)
+	err := fset.Parse(args)
+	if err != nil {
+		return err
+	}
+
+	id, err := parseRun(fset.Args(), 1)
+	if err != nil {
+		return err
+	}
+
+	files, err := db.RunFiles(ctx, id)
+	if err != nil {
+		return err
+	}
+
+	for _, f := range files {
+		if *kind != "" && f.Kind != *kind {
+			continue
+		}
+		fmt.Fprintf(w, "%s:%s\n", f.Host, f.Path)
+	}
+	return nil
+}
+
+func cmdAddFile(ctx context.Context, w io.Writer, db *conddb.DB, args []string) error {
+	id, err := parseRun(args, 4)
+	if err != nil {
+		return err
+	}
+
+	return db.AddRunFile(ctx, conddb.RunFile{
+		Run:  id,
+		Host: args[1],
+		Path: args[2],
+		Kind: args[3],
+	})
+}
+
+// parseRun parses the run number of a command with n arguments.
+func parseRun(args []string, n int) (uint32, error) {
+	if len(args) != n {
+		return 0, fmt.Errorf("invalid number of arguments (got=%d, want=%d)", len(args), n)
+	}
+	id, err := strconv.ParseUint(args[0], 10, 32)
+	if err != nil {
+		return 0, fmt.Errorf("could not parse run number %q: %w", args[0], err)
+	}
+	return uint32(id), nil
+}
+
+func printRuns(w io.Writer, runs []conddb.Run) error {
+	const layout = "2006-01-02 15:04:05"
+
+	tw := tabwriter.NewWriter(w, 0, 8, 2, ' ', 0)
+	fmt.Fprintf(tw, "RUN\tBOARD\tHOST\tMODE\tSTART (UTC)\tDURATION\tCYCLES\n")
+	for _, run := range runs {
+		dur := "running"
+		if !run.Stop.IsZero() {
+			dur = run.Stop.Sub(run.Start).Round(time.Second).String()
+		}
+		fmt.Fprintf(tw, "%d\t%d\t%s\t%s\t%s\t%s\t%d\n",
+			run.ID, run.Board, run.Host, run.Mode,
+			run.Start.UTC().Format(layout), dur, run.Cycles,
+		)
+	}
+	return tw.Flush()
+}
@@ -0,0 +1,152 @@
+// Copyright 2020 The go-lpc Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"database/sql/driver"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-lpc/mim/conddb"
+	"github.com/go-lpc/mim/conddbtest"
+)
+
+func TestMIMRuns(t *testing.T) {
+	fake := conddbtest.New()
+	defer fake.Close()
+
+	start := time.Date(2021, 5, 12, 10, 0, 0, 0, time.UTC)
+	runs := conddbtest.Rows{
+		Names: []string{"run_id", "board", "host", "mode", "start_time", "stop_time", "cycles", "settings"},
+		Values: [][]driver.Value{
+			{int64(43), int64(1), "eda01", "noise", start.Add(time.Hour).Unix(), int64(0), int64(0), `{"Run":43}`},
+			{int64(42), int64(1), "eda01", "dcc", start.Unix(), start.Add(90 * time.Second).Unix(), int64(12), `{"Run":42}`},
+		},
+	}
+	fake.Handle(conddbtest.Query{
+		Prefix: "SELECT run_id, host, path, kind FROM run_files",
+		Rows: conddbtest.Rows{
+			Names: []string{"run_id", "host", "path", "kind"},
+			Values: [][]driver.Value{
+				{int64(42), "eda01", "/home/root/run/hr_sc_042.csv", "hr-sc"},
+				{int64(42), "lpccaf", "/data/run_042.slcio", "lcio"},
+			},
+		},
+	})
+	fake.Handle(conddbtest.Query{
+		Prefix: "SELECT run_id, board, host, mode, start_time, stop_time, cycles, settings FROM runs WHERE",
+		Args:   []driver.Value{int64(42)},
+		Rows:   conddbtest.Rows{Names: runs.Names, Values: runs.Values[1:]},
+	})
+	fake.Handle(conddbtest.Query{
+		Prefix: "SELECT run_id, board, host, mode, start_time, stop_time, cycles, settings FROM runs WHERE",
+		Rows:   conddbtest.Rows{Names: runs.Names},
+	})
+	fake.Handle(conddbtest.Query{
+		Prefix: "SELECT run_id, board, host, mode, start_time, stop_time, cycles, settings FROM runs ORDER BY",
+		Rows:   runs,
+	})
+	fake.Handle(conddbtest.Query{Prefix: "CREATE TABLE"})
+	fake.Handle(conddbtest.Query{Prefix: "INSERT INTO run_files", Affected: 1})
+
+	defer func(open func(string) (*conddb.DB, error)) { openDB = open }(openDB)
+	openDB = func(name string) (*conddb.DB, error) {
+		db, err := fake.Open()
+		if err != nil {
+			return nil, err
+		}
+		return conddb.NewDB(db, name), nil
+	}
+
+	for _, tc := range []struct {
+		args []string
+		want string
+		err  string
+	}{
+		{args: []string{"init"}},
+		{
+			args: []string{"list"},
+			want: `RUN  BOARD  HOST   MODE   START (UTC)          DURATION  CYCLES
+43   1      eda01  noise  2021-05-12 11:00:00  running   0
+42   1      eda01  dcc    2021-05-12 10:00:00  1m30s     12
+`,
+		},
+		{
+			args: []string{"list", "-json", "-n", "2"},
+			want: `[
+  {
+    "id": 43,
+    "board": 1,
+    "host": "eda01",
+    "mode": "noise",
+    "start": "2021-05-12T11:00:00Z",
+    "cycles": 0,
+    "settings": {
+      "Run": 43
+    }
+  },
+  {
+    "id": 42,
+    "board": 1,
+    "host": "eda01",
+    "mode": "dcc",
+    "start": "2021-05-12T10:00:00Z",
+    "stop": "2021-05-12T10:01:30Z",
+    "cycles": 12,
+    "settings": {
+      "Run": 42
+    }
+  }
+]
+`,
+		},
+		{
+			args: []string{"show", "42"},
+			want: `RUN  BOARD  HOST   MODE  START (UTC)          DURATION  CYCLES
+42   1      eda01  dcc   2021-05-12 10:00:00  1m30s     12
+
+settings (board=1):
+{
+  "Run": 42
+}
+
+files:
+hr-sc  eda01:/home/root/run/hr_sc_042.csv
+lcio   lpccaf:/data/run_042.slcio
+`,
+		},
+		{
+			args: []string{"files", "-kind", "lcio", "42"},
+			want: "lpccaf:/data/run_042.slcio\n",
+		},
+		{args: []string{"add-file", "42", "lpccaf", "/data/run_042.slcio", "lcio"}},
+		{args: []string{}, err: "missing command"},
+		{args: []string{"drop"}, err: `unknown command "drop"`},
+		{args: []string{"show", "44"}, err: `could not run "show": no such run 44`},
+		{args: []string{"show"}, err: `could not run "show": invalid number of arguments (got=0, want=1)`},
+		{args: []string{"files", "x"}, err: `could not run "files": could not parse run number "x": strconv.ParseUint: parsing "x": invalid syntax`},
+	} {
+		t.Run(strings.Join(tc.args, " "), func(t *testing.T) {
+			out := new(strings.Builder)
+			err := xmain(out, tc.args)
+			switch {
+			case err != nil && tc.err != "":
+				if got, want := err.Error(), tc.err; got != want {
+					t.Fatalf("invalid error:\ngot= %v\nwant=%v", got, want)
+				}
+				return
+			case err != nil:
+				t.Fatalf("could not run mim-runs: %+v", err)
+			case tc.err != "":
+				t.Fatalf("expected an error (%s)", tc.err)
+			}
+
+			if got, want := out.String(), tc.want; got != want {
+				t.Fatalf("invalid output:\ngot:\n%s\nwant:\n%s", got, want)
+			}
+		})
+	}
+}
@@ -12,6 +12,7 @@ import (
 	"time"
 
 	"github.com/go-lpc/mim/conddb"
+	"github.com/go-lpc/mim/internal/cliconf"
 	_ "github.com/go-sql-driver/mysql"
 )
 
@@ -28,6 +29,7 @@ func main() {
 		dif   = flag.Int("dif", 0x9, "DIF ID to inspect")
 	)
 
+	cliconf.Version(flag.CommandLine)
 	flag.Parse()
 
 	log.Printf("dif: %03d", *dif)
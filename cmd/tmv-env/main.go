@@ -10,6 +10,7 @@ import (
 	"flag"
 	"log"
 
+	"github.com/go-lpc/mim/internal/cliconf"
 	"github.com/go-lpc/mim/internal/xbuild"
 )
 
@@ -21,6 +22,7 @@ func xmain() {
 	log.SetPrefix("tmv-env: ")
 	log.SetFlags(0)
 
+	cliconf.Version(flag.CommandLine)
 	flag.Parse()
 
 	err := xbuild.Docker()
@@ -14,6 +14,7 @@ import (
 	"path/filepath"
 	"strings"
 
+	"github.com/go-lpc/mim/internal/cliconf"
 	"github.com/go-lpc/mim/internal/xbuild"
 )
 
@@ -28,6 +29,7 @@ func xmain() {
 	dir := flag.String("dir", ".", "path to directory to mount")
 	tty := flag.Bool("i", false, "request a TTY")
 
+	cliconf.Version(flag.CommandLine)
 	flag.Parse()
 
 	if flag.NArg() <= 0 {
@@ -10,6 +10,7 @@ import (
 	"context"
 	"database/sql"
 	"fmt"
+	"strings"
 	"time"
 
 	_ "github.com/go-sql-driver/mysql"
@@ -31,10 +32,47 @@ var (
 type DB struct {
 	db   *sql.DB
 	name string // name of the MIM database
+
+	hook func(QueryInfo) // called after each query, if any.
+	poll time.Duration   // polling interval of Watch methods
+}
+
+// Option configures a connection to the MIM database.
+type Option func(*DB)
+
+// WithQueryHook registers a function that is called after each query
+// run against the MIM database, whether it succeeded or not.
+//
+// The hook is called synchronously and should thus return quickly.
+func WithQueryHook(hook func(QueryInfo)) Option {
+	return func(db *DB) {
+		db.hook = hook
+	}
+}
+
+// WithWatchInterval sets the interval at which the MIM database is
+// polled by the Watch methods (default: 30s).
+func WithWatchInterval(d time.Duration) Option {
+	return func(db *DB) {
+		if d <= 0 {
+			return
+		}
+		db.poll = d
+	}
+}
+
+// QueryInfo describes a query that was run against the MIM database.
+type QueryInfo struct {
+	Name     string        // name of the query (e.g. "LastHRConfig")
+	Query    string        // SQL statement
+	Start    time.Time     // time at which the query was started
+	Duration time.Duration // duration of the query, including rows scanning
+	Rows     int           // number of rows retrieved (-1 if unknown)
+	Err      error         // error encountered while running the query, if any
 }
 
 // Open opens a connection to the MIM database dbname.
-func Open(dbname string) (*DB, error) {
+func Open(dbname string, opts ...Option) (*DB, error) {
 	db, err := sql.Open(drvName, dsn(dbname))
 	if err != nil {
 		return nil, fmt.Errorf("conddb: could not open %q db: %w", dbname, err)
@@ -45,7 +83,31 @@ func Open(dbname string) (*DB, error) {
 		return nil, fmt.Errorf("conddb: could not ping %q db: %w", dbname, err)
 	}
 
-	return &DB{db: db, name: dbname}, nil
+	return NewDB(db, dbname, opts...), nil
+}
+
+// NewDB returns a MIM database from an already opened connection.
+// NewDB takes ownership of the provided connection.
+func NewDB(db *sql.DB, dbname string, opts ...Option) *DB {
+	cdb := &DB{db: db, name: dbname, poll: 30 * time.Second}
+	for _, opt := range opts {
+		opt(cdb)
+	}
+	return cdb
+}
+
+func (db *DB) observe(name, query string, start time.Time, rows int, err error) {
+	if db.hook == nil {
+		return
+	}
+	db.hook(QueryInfo{
+		Name:     name,
+		Query:    query,
+		Start:    start,
+		Duration: time.Since(start),
+		Rows:     rows,
+		Err:      err,
+	})
 }
 
 func dsn(db string) string {
@@ -69,18 +131,24 @@ func (db *DB) Close() error {
 }
 
 func (db *DB) QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error) {
-	return db.db.QueryContext(ctx, query, args...)
+	start := time.Now()
+	rows, err := db.db.QueryContext(ctx, query, args...)
+	db.observe("QueryContext", query, start, -1, err)
+	return rows, err
 }
 
-func (db *DB) LastHRConfig(ctx context.Context) (string, error) {
+func (db *DB) LastHRConfig(ctx context.Context) (hrcfg string, err error) {
+	const query = "SELECT hrconfig FROM detectors ORDER BY datetime DESC LIMIT 1"
+
+	n := 0
+	defer func(start time.Time) {
+		db.observe("LastHRConfig", query, start, n, err)
+	}(time.Now())
+
 	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
 	defer cancel()
 
-	hrcfg := ""
-	rows, err := db.db.QueryContext(
-		ctx,
-		"SELECT hrconfig FROM detectors ORDER BY datetime DESC LIMIT 1",
-	)
+	rows, err := db.db.QueryContext(ctx, query)
 	if err != nil {
 		return hrcfg, fmt.Errorf("conddb: could not query HR cfg: %w", err)
 	}
@@ -91,6 +159,7 @@ func (db *DB) LastHRConfig(ctx context.Context) (string, error) {
 		if err != nil {
 			return hrcfg, fmt.Errorf("conddb: could not get HR cfg value: %w", err)
 		}
+		n++
 	}
 
 	if err := rows.Err(); err != nil {
@@ -104,15 +173,63 @@ func (db *DB) LastHRConfig(ctx context.Context) (string, error) {
 	return hrcfg, nil
 }
 
-func (db *DB) LastDetectorID(ctx context.Context) (uint32, error) {
+// WatchHRConfig polls the MIM database for newly published HR
+// configurations.
+//
+// The returned channel receives the name of each HR configuration
+// published after WatchHRConfig was called, and is closed when ctx is
+// canceled.
+// Errors encountered while polling are reported to the query hook, if
+// any, and do not stop the polling.
+func (db *DB) WatchHRConfig(ctx context.Context) (<-chan string, error) {
+	cur, err := db.LastHRConfig(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("conddb: could not retrieve current HR cfg: %w", err)
+	}
+
+	ch := make(chan string)
+	go func() {
+		defer close(ch)
+
+		tck := time.NewTicker(db.poll)
+		defer tck.Stop()
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-tck.C:
+			}
+
+			hrcfg, err := db.LastHRConfig(ctx)
+			if err != nil || hrcfg == cur {
+				continue
+			}
+			cur = hrcfg
+
+			select {
+			case ch <- hrcfg:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	return ch, nil
+}
+
+func (db *DB) LastDetectorID(ctx context.Context) (detid uint32, err error) {
+	const query = "SELECT identifier FROM detectors ORDER BY datetime DESC LIMIT 1"
+
+	n := 0
+	defer func(start time.Time) {
+		db.observe("LastDetectorID", query, start, n, err)
+	}(time.Now())
+
 	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
 	defer cancel()
 
-	var detid uint32
-	rows, err := db.db.QueryContext(
-		ctx,
-		"SELECT identifier FROM detectors ORDER BY datetime DESC LIMIT 1",
-	)
+	rows, err := db.db.QueryContext(ctx, query)
 	if err != nil {
 		return detid, fmt.Errorf("conddb: could not query detector-id: %w", err)
 	}
@@ -123,6 +240,7 @@ func (db *DB) LastDetectorID(ctx context.Context) (uint32, error) {
 		if err != nil {
 			return detid, fmt.Errorf("conddb: could not get detector-id value: %w", err)
 		}
+		n++
 	}
 
 	if err := rows.Err(); err != nil {
@@ -136,27 +254,25 @@ func (db *DB) LastDetectorID(ctx context.Context) (uint32, error) {
 	return detid, nil
 }
 
-func (db *DB) ASICConfig(ctx context.Context, hrConfig string, difID uint8) ([]ASIC, error) {
-	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
-	defer cancel()
-
-	var (
-		cfg = make([]ASIC, 0, numASICs)
-		err error
-	)
-
-	rows, err := db.db.QueryContext(
-		ctx,
-		`
+func (db *DB) ASICConfig(ctx context.Context, hrConfig string, difID uint8) (cfg []ASIC, err error) {
+	const query = `
 SELECT asics.* FROM asics
 JOIN hrconfig_asics ON asics.identifier=hrconfig_asics.asic
 JOIN hrconfig       ON hrconfig.identifier=hrconfig_asics.hrconfig
 WHERE (
 	hrconfig.name=? AND asics.dif_id=?
 )
-`,
-		hrConfig, difID,
-	)
+`
+
+	cfg = make([]ASIC, 0, numASICs)
+	defer func(start time.Time) {
+		db.observe("ASICConfig", query, start, len(cfg), err)
+	}(time.Now())
+
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	rows, err := db.db.QueryContext(ctx, query, hrConfig, difID)
 	if err != nil {
 		return cfg, fmt.Errorf("conddb: could not run ASIC cfg query: %w", err)
 	}
@@ -165,23 +281,7 @@ WHERE (
 	i := 0
 	for rows.Next() {
 		var asic ASIC
-		err = rows.Scan(
-			&asic.PrimaryID, &asic.Header, &asic.DIFID,
-			&asic.Razchnextval, &asic.Razchnintval,
-			&asic.Trigextval, &asic.EnTrigOut,
-			&asic.Trig0b, &asic.Trig1b, &asic.Trig2b,
-			&asic.SmallDAC,
-			&asic.B2, &asic.B1, &asic.B0,
-			&asic.Mask2, &asic.Mask1, &asic.Mask0,
-			&asic.Sw50f0, &asic.Sw100f0, &asic.Sw100k0,
-			&asic.Sw50k0, &asic.Sw50f1, &asic.Sw100f1,
-			&asic.Sw100k1, &asic.Sw50k1,
-			&asic.Cmdb0fsb1, &asic.Cmdb1fsb1, &asic.Cmdb2fsb1,
-			&asic.Cmdb3fsb1,
-			&asic.Sw50f2, &asic.Sw100f2, &asic.Sw100k2, &asic.Sw50k2,
-			&asic.Cmdb0fsb2, &asic.Cmdb1fsb2, &asic.Cmdb2fsb2, &asic.Cmdb3fsb2,
-			&asic.PreAmpGain,
-		)
+		err = scanASIC(rows, &asic)
 		if err != nil {
 			return cfg, fmt.Errorf("conddb: could not scan row %d for ASIC cfg: %w", i, err)
 		}
@@ -201,12 +301,99 @@ WHERE (
 	return cfg, nil
 }
 
-func (db *DB) DAQStates(ctx context.Context) ([]DAQState, error) {
+// ASICConfigs retrieves the ASICs configuration of all the provided DIFs
+// for the hrConfig HardRoc configuration, in a single query.
+//
+// The returned map is indexed by DIF ID. DIFs without any ASIC in the
+// configuration have no entry in the map.
+func (db *DB) ASICConfigs(ctx context.Context, hrConfig string, difIDs []uint8) (cfgs map[uint8][]ASIC, err error) {
+	cfgs = make(map[uint8][]ASIC, len(difIDs))
+	if len(difIDs) == 0 {
+		return cfgs, nil
+	}
+
+	args := make([]interface{}, 0, 1+len(difIDs))
+	args = append(args, hrConfig)
+	for _, dif := range difIDs {
+		args = append(args, dif)
+	}
+
+	query := `
+SELECT asics.* FROM asics
+JOIN hrconfig_asics ON asics.identifier=hrconfig_asics.asic
+JOIN hrconfig       ON hrconfig.identifier=hrconfig_asics.hrconfig
+WHERE (
+	hrconfig.name=? AND asics.dif_id IN (?` + strings.Repeat(",?", len(difIDs)-1) + `)
+)
+`
+
+	n := 0
+	defer func(start time.Time) {
+		db.observe("ASICConfigs", query, start, n, err)
+	}(time.Now())
+
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	rows, err := db.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return cfgs, fmt.Errorf("conddb: could not run ASIC cfgs query: %w", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var asic ASIC
+		err = scanASIC(rows, &asic)
+		if err != nil {
+			return cfgs, fmt.Errorf("conddb: could not scan row %d for ASIC cfgs: %w", n, err)
+		}
+		n++
+
+		cfgs[asic.DIFID] = append(cfgs[asic.DIFID], asic)
+	}
+
+	if err := rows.Err(); err != nil {
+		return cfgs, fmt.Errorf("conddb: could not scan db for ASIC cfgs: %w", err)
+	}
+
+	if err := ctx.Err(); err != nil {
+		return cfgs, fmt.Errorf("conddb: context error while retrieving ASIC cfgs: %w", err)
+	}
+
+	return cfgs, nil
+}
+
+func scanASIC(rows *sql.Rows, asic *ASIC) error {
+	return rows.Scan(
+		&asic.PrimaryID, &asic.Header, &asic.DIFID,
+		&asic.Razchnextval, &asic.Razchnintval,
+		&asic.Trigextval, &asic.EnTrigOut,
+		&asic.Trig0b, &asic.Trig1b, &asic.Trig2b,
+		&asic.SmallDAC,
+		&asic.B2, &asic.B1, &asic.B0,
+		&asic.Mask2, &asic.Mask1, &asic.Mask0,
+		&asic.Sw50f0, &asic.Sw100f0, &asic.Sw100k0,
+		&asic.Sw50k0, &asic.Sw50f1, &asic.Sw100f1,
+		&asic.Sw100k1, &asic.Sw50k1,
+		&asic.Cmdb0fsb1, &asic.Cmdb1fsb1, &asic.Cmdb2fsb1,
+		&asic.Cmdb3fsb1,
+		&asic.Sw50f2, &asic.Sw100f2, &asic.Sw100k2, &asic.Sw50k2,
+		&asic.Cmdb0fsb2, &asic.Cmdb1fsb2, &asic.Cmdb2fsb2, &asic.Cmdb3fsb2,
+		&asic.PreAmpGain,
+	)
+}
+
+func (db *DB) DAQStates(ctx context.Context) (cfg []DAQState, err error) {
+	const query = "SELECT * FROM daqstates"
+
+	defer func(start time.Time) {
+		db.observe("DAQStates", query, start, len(cfg), err)
+	}(time.Now())
+
 	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
 	defer cancel()
 
-	var cfg []DAQState
-	rows, err := db.db.QueryContext(ctx, "SELECT * FROM daqstates")
+	rows, err := db.db.QueryContext(ctx, query)
 	if err != nil {
 		return cfg, fmt.Errorf(
 			"conddb: could not run daqstates query: %w",
@@ -243,3 +430,66 @@ func (db *DB) DAQStates(ctx context.Context) ([]DAQState, error) {
 
 	return cfg, nil
 }
+
+// Chamber describes the location of a DIF within a detector.
+//
+// For EDA RFMs (DIF-ID < 100), ASU holds the EDA board ID and IY
+// the EDA slot the RFM is plugged into.
+type Chamber struct {
+	DIF uint32
+	ASU uint32
+	IY  uint32
+}
+
+// Chambers returns the chambers definition of the provided detector.
+func (db *DB) Chambers(ctx context.Context, detID uint32) (chambers []Chamber, err error) {
+	const query = "SELECT dif, asu, iy FROM chambers WHERE detector=? ORDER BY dif"
+
+	defer func(start time.Time) {
+		db.observe("Chambers", query, start, len(chambers), err)
+	}(time.Now())
+
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	rows, err := db.db.QueryContext(ctx, query, detID)
+	if err != nil {
+		return chambers, fmt.Errorf("conddb: could not query chambers: %w", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var ch Chamber
+		err = rows.Scan(&ch.DIF, &ch.ASU, &ch.IY)
+		if err != nil {
+			return chambers, fmt.Errorf("conddb: could not scan chambers: %w", err)
+		}
+		chambers = append(chambers, ch)
+	}
+
+	if err := rows.Err(); err != nil {
+		return chambers, fmt.Errorf("conddb: could not scan db for chambers: %w", err)
+	}
+
+	if err := ctx.Err(); err != nil {
+		return chambers, fmt.Errorf("conddb: context error while retrieving chambers: %w", err)
+	}
+
+	return chambers, nil
+}
+
+// DIFGeometry returns the position of the DIFs of the provided detector,
+// indexed by DIF ID, as defined by its chambers table (see Chamber).
+// It allows monitoring to draw hit maps in detector coordinates.
+func (db *DB) DIFGeometry(ctx context.Context, detID uint32) (map[uint32]Chamber, error) {
+	chambers, err := db.Chambers(ctx, detID)
+	if err != nil {
+		return nil, fmt.Errorf("conddb: could not retrieve DIF geometry: %w", err)
+	}
+
+	geom := make(map[uint32]Chamber, len(chambers))
+	for _, ch := range chambers {
+		geom[ch.DIF] = ch
+	}
+	return geom, nil
+}
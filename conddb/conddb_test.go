@@ -11,17 +11,27 @@ import (
 	"reflect"
 	"strings"
 	"testing"
+	"time"
 
-	"github.com/go-lpc/mim/internal/fakedb"
-	_ "github.com/go-lpc/mim/internal/fakedb"
+	"github.com/go-lpc/mim/conddbtest"
 )
 
 func init() {
-	drvName = "fakedb"
+	drvName = conddbtest.DriverName
+}
+
+// run registers rows as the result of any query and runs f.
+func run(fake *conddbtest.DB, rows conddbtest.Rows, f func(ctx context.Context) error) error {
+	fake.Reset()
+	fake.Handle(conddbtest.Query{Rows: rows})
+	return f(context.Background())
 }
 
 func TestOpen(t *testing.T) {
-	db, err := Open("fakedb")
+	fake := conddbtest.New()
+	defer fake.Close()
+
+	db, err := Open(fake.Name())
 	if err != nil {
 		t.Fatalf("could not open conddb: %+v", err)
 	}
@@ -29,13 +39,16 @@ func TestOpen(t *testing.T) {
 }
 
 func TestLastHRConfig(t *testing.T) {
-	db, err := Open("fakedb")
+	fake := conddbtest.New()
+	defer fake.Close()
+
+	db, err := Open(fake.Name())
 	if err != nil {
 		t.Fatalf("could not open conddb: %+v", err)
 	}
 	defer db.Close()
 
-	_ = fakedb.Run(context.Background(), fakedb.Rows{
+	_ = run(fake, conddbtest.Rows{
 		Names: []string{"hrconfig"},
 		Values: [][]driver.Value{
 			{"LPC2020_0"},
@@ -55,13 +68,16 @@ func TestLastHRConfig(t *testing.T) {
 }
 
 func TestLastDetectorID(t *testing.T) {
-	db, err := Open("fakedb")
+	fake := conddbtest.New()
+	defer fake.Close()
+
+	db, err := Open(fake.Name())
 	if err != nil {
 		t.Fatalf("could not open conddb: %+v", err)
 	}
 	defer db.Close()
 
-	_ = fakedb.Run(context.Background(), fakedb.Rows{
+	_ = run(fake, conddbtest.Rows{
 		Names: []string{"identifier"},
 		Values: [][]driver.Value{
 			{uint32(139)},
@@ -80,8 +96,145 @@ func TestLastDetectorID(t *testing.T) {
 
 }
 
+func TestWatchHRConfig(t *testing.T) {
+	fake := conddbtest.New()
+	defer fake.Close()
+
+	db, err := Open(fake.Name(), WithWatchInterval(5*time.Millisecond))
+	if err != nil {
+		t.Fatalf("could not open conddb: %+v", err)
+	}
+	defer db.Close()
+
+	hrcfg := func(name string) {
+		fake.Reset()
+		fake.Handle(conddbtest.Query{
+			Prefix: "SELECT hrconfig FROM detectors",
+			Rows: conddbtest.Rows{
+				Names:  []string{"hrconfig"},
+				Values: [][]driver.Value{{name}},
+			},
+		})
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	hrcfg("LPC2020_0")
+	ch, err := db.WatchHRConfig(ctx)
+	if err != nil {
+		t.Fatalf("could not watch HR cfg: %+v", err)
+	}
+
+	select {
+	case name := <-ch:
+		t.Fatalf("unexpected HR cfg notification %q", name)
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	hrcfg("LPC2020_1")
+	select {
+	case name := <-ch:
+		if got, want := name, "LPC2020_1"; got != want {
+			t.Fatalf("invalid HR cfg: got=%q, want=%q", got, want)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("timeout waiting for HR cfg notification")
+	}
+
+	cancel()
+	select {
+	case _, ok := <-ch:
+		if ok {
+			t.Fatalf("expected watch channel to be closed")
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("timeout waiting for watch channel to be closed")
+	}
+
+	fake.Reset()
+	_, err = db.WatchHRConfig(context.Background())
+	if err == nil {
+		t.Fatalf("expected an error")
+	}
+}
+
+func TestQueryHook(t *testing.T) {
+	fake := conddbtest.New()
+	defer fake.Close()
+
+	var infos []QueryInfo
+	db, err := Open(fake.Name(), WithQueryHook(func(qi QueryInfo) {
+		infos = append(infos, qi)
+	}))
+	if err != nil {
+		t.Fatalf("could not open conddb: %+v", err)
+	}
+	defer db.Close()
+
+	_ = run(fake, conddbtest.Rows{
+		Names: []string{"dif", "asu", "iy"},
+		Values: [][]driver.Value{
+			{uint32(1), uint32(1), uint32(0)},
+			{uint32(2), uint32(1), uint32(1)},
+		},
+	}, func(ctx context.Context) error {
+		_, err := db.Chambers(ctx, 139)
+		if err != nil {
+			t.Fatalf("could not retrieve chambers: %+v", err)
+		}
+		return nil
+	})
+
+	_ = run(fake, conddbtest.Rows{
+		Names: []string{"identifier"},
+		Values: [][]driver.Value{
+			{"not-a-number"},
+		},
+	}, func(ctx context.Context) error {
+		_, err := db.LastDetectorID(ctx)
+		if err == nil {
+			t.Fatalf("expected an error")
+		}
+		return nil
+	})
+
+	if got, want := len(infos), 2; got != want {
+		t.Fatalf("invalid number of hook calls: got=%d, want=%d", got, want)
+	}
+
+	for i, tc := range []struct {
+		name string
+		rows int
+		err  bool
+	}{
+		{name: "Chambers", rows: 2},
+		{name: "LastDetectorID", rows: 0, err: true},
+	} {
+		qi := infos[i]
+		if got, want := qi.Name, tc.name; got != want {
+			t.Fatalf("invalid query[%d] name: got=%q, want=%q", i, got, want)
+		}
+		if got, want := qi.Rows, tc.rows; got != want {
+			t.Fatalf("invalid query[%d] rows: got=%d, want=%d", i, got, want)
+		}
+		if got, want := qi.Err != nil, tc.err; got != want {
+			t.Fatalf("invalid query[%d] error: got=%v, want=%v", i, qi.Err, want)
+		}
+		if qi.Query == "" {
+			t.Fatalf("invalid query[%d]: empty SQL statement", i)
+		}
+		if qi.Duration < 0 {
+			t.Fatalf("invalid query[%d] duration: %v", i, qi.Duration)
+		}
+	}
+}
+
 func TestQueryContext(t *testing.T) {
-	db, err := Open("fakedb")
+	fake := conddbtest.New()
+	defer fake.Close()
+
+	db, err := Open(fake.Name())
 	if err != nil {
 		t.Fatalf("could not open conddb: %+v", err)
 	}
@@ -89,7 +242,7 @@ func TestQueryContext(t *testing.T) {
 
 	const queryLastDetID = "SELECT identifier FROM detectors ORDER BY datetime DESC LIMIT 1"
 
-	_ = fakedb.Run(context.Background(), fakedb.Rows{
+	_ = run(fake, conddbtest.Rows{
 		Names: []string{"identifier"},
 		Values: [][]driver.Value{
 			{uint32(139)},
@@ -121,7 +274,10 @@ func TestQueryContext(t *testing.T) {
 }
 
 func TestDAQStates(t *testing.T) {
-	db, err := Open("fakedb")
+	fake := conddbtest.New()
+	defer fake.Close()
+
+	db, err := Open(fake.Name())
 	if err != nil {
 		t.Fatalf("could not open conddb: %+v", err)
 	}
@@ -133,7 +289,7 @@ func TestDAQStates(t *testing.T) {
 		{12, 22, 32, 42},
 		{13, 23, 33, 43},
 	}
-	_ = fakedb.Run(context.Background(), fakedb.Rows{
+	_ = run(fake, conddbtest.Rows{
 		Names: []string{
 			"identifier", "hrconfig", "rshape", "trigger_type",
 		},
@@ -157,8 +313,81 @@ func TestDAQStates(t *testing.T) {
 
 }
 
+func TestChambers(t *testing.T) {
+	fake := conddbtest.New()
+	defer fake.Close()
+
+	db, err := Open(fake.Name())
+	if err != nil {
+		t.Fatalf("could not open conddb: %+v", err)
+	}
+	defer db.Close()
+
+	want := []Chamber{
+		{DIF: 1, ASU: 1, IY: 0},
+		{DIF: 2, ASU: 1, IY: 2},
+		{DIF: 120, ASU: 3, IY: 4},
+	}
+	_ = run(fake, conddbtest.Rows{
+		Names: []string{"dif", "asu", "iy"},
+		Values: [][]driver.Value{
+			{want[0].DIF, want[0].ASU, want[0].IY},
+			{want[1].DIF, want[1].ASU, want[1].IY},
+			{want[2].DIF, want[2].ASU, want[2].IY},
+		},
+	}, func(ctx context.Context) error {
+		chambers, err := db.Chambers(ctx, 139)
+		if err != nil {
+			t.Fatalf("could not retrieve chambers: %+v", err)
+		}
+
+		if got, want := chambers, want; !reflect.DeepEqual(got, want) {
+			t.Fatalf("invalid chambers:\ngot= %#v\nwant=%#v", got, want)
+		}
+		return nil
+	})
+}
+
+func TestDIFGeometry(t *testing.T) {
+	fake := conddbtest.New()
+	defer fake.Close()
+
+	db, err := Open(fake.Name())
+	if err != nil {
+		t.Fatalf("could not open conddb: %+v", err)
+	}
+	defer db.Close()
+
+	want := map[uint32]Chamber{
+		1:   {DIF: 1, ASU: 1, IY: 0},
+		2:   {DIF: 2, ASU: 1, IY: 2},
+		120: {DIF: 120, ASU: 3, IY: 4},
+	}
+	_ = run(fake, conddbtest.Rows{
+		Names: []string{"dif", "asu", "iy"},
+		Values: [][]driver.Value{
+			{want[1].DIF, want[1].ASU, want[1].IY},
+			{want[2].DIF, want[2].ASU, want[2].IY},
+			{want[120].DIF, want[120].ASU, want[120].IY},
+		},
+	}, func(ctx context.Context) error {
+		geom, err := db.DIFGeometry(ctx, 139)
+		if err != nil {
+			t.Fatalf("could not retrieve DIF geometry: %+v", err)
+		}
+
+		if got, want := geom, want; !reflect.DeepEqual(got, want) {
+			t.Fatalf("invalid DIF geometry:\ngot= %#v\nwant=%#v", got, want)
+		}
+		return nil
+	})
+}
+
 func TestASICConfig(t *testing.T) {
-	db, err := Open("fakedb")
+	fake := conddbtest.New()
+	defer fake.Close()
+
+	db, err := Open(fake.Name())
 	if err != nil {
 		t.Fatalf("could not open conddb: %+v", err)
 	}
@@ -247,7 +476,7 @@ func TestASICConfig(t *testing.T) {
 		},
 	}
 
-	_ = fakedb.Run(context.Background(), fakedb.Rows{
+	_ = run(fake, conddbtest.Rows{
 		Names: []string{
 			"identifier",
 			"header",
@@ -392,3 +621,74 @@ func TestASICConfig(t *testing.T) {
 		return nil
 	})
 }
+
+func TestASICConfigs(t *testing.T) {
+	fake := conddbtest.New()
+	defer fake.Close()
+
+	db, err := Open(fake.Name())
+	if err != nil {
+		t.Fatalf("could not open conddb: %+v", err)
+	}
+	defer db.Close()
+
+	asics := []ASIC{
+		{PrimaryID: 1, Header: 1, DIFID: 1, B0: 250, PreAmpGain: []byte("ab")},
+		{PrimaryID: 2, Header: 1, DIFID: 2, B0: 251, PreAmpGain: []byte("cd")},
+		{PrimaryID: 3, Header: 2, DIFID: 1, B0: 252, PreAmpGain: []byte("ef")},
+	}
+
+	// build the result set from the ASIC fields, in table order.
+	var rows conddbtest.Rows
+	rt := reflect.TypeOf(ASIC{})
+	for i := 0; i < rt.NumField(); i++ {
+		rows.Names = append(rows.Names, rt.Field(i).Tag.Get("json"))
+	}
+	for _, asic := range asics {
+		rv := reflect.ValueOf(asic)
+		row := make([]driver.Value, rv.NumField())
+		for i := range row {
+			row[i] = rv.Field(i).Interface()
+		}
+		rows.Values = append(rows.Values, row)
+	}
+
+	_ = run(fake, rows, func(ctx context.Context) error {
+		cfgs, err := db.ASICConfigs(ctx, "LPC2020_0", []uint8{1, 2, 3})
+		if err != nil {
+			t.Fatalf("could not retrieve asics cfgs: %+v", err)
+		}
+
+		want := map[uint8][]ASIC{
+			1: {asics[0], asics[2]},
+			2: {asics[1]},
+		}
+		if !reflect.DeepEqual(cfgs, want) {
+			t.Fatalf("invalid asics cfgs:\ngot= %#v\nwant=%#v", cfgs, want)
+		}
+
+		calls := fake.Calls()
+		if len(calls) != 1 {
+			t.Fatalf("invalid number of queries: got=%d, want=1", len(calls))
+		}
+		if !strings.Contains(calls[0].Query, "asics.dif_id IN (?,?,?)") {
+			t.Fatalf("invalid query:\n%s", calls[0].Query)
+		}
+		if got, want := calls[0].Args, []driver.Value{"LPC2020_0", int64(1), int64(2), int64(3)}; !reflect.DeepEqual(got, want) {
+			t.Fatalf("invalid query args: got=%v, want=%v", got, want)
+		}
+
+		// no DIF: no query.
+		cfgs, err = db.ASICConfigs(ctx, "LPC2020_0", nil)
+		if err != nil {
+			t.Fatalf("could not retrieve asics cfgs: %+v", err)
+		}
+		if len(cfgs) != 0 {
+			t.Fatalf("invalid asics cfgs: %v", cfgs)
+		}
+		if got, want := len(fake.Calls()), 1; got != want {
+			t.Fatalf("invalid number of queries: got=%d, want=%d", got, want)
+		}
+		return nil
+	})
+}
@@ -4,19 +4,153 @@
 
 package conddb
 
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// TriggerMode is the trigger mode of a DAQ state, as stored in the
+// trigger_type column.
+type TriggerMode int
+
+// Trigger modes of a DAQ state.
+const (
+	TriggerDCC   TriggerMode = 0 // triggers and readout commands sent by the DCC
+	TriggerNoise TriggerMode = 1 // self-triggered acquisition, driven by software
+	TriggerExt   TriggerMode = 2 // external trigger
+)
+
+var triggerModeNames = [...]string{
+	TriggerDCC:   "dcc",
+	TriggerNoise: "noise",
+	TriggerExt:   "ext",
+}
+
+func (m TriggerMode) String() string {
+	if m < 0 || int(m) >= len(triggerModeNames) {
+		return "TriggerMode(" + strconv.Itoa(int(m)) + ")"
+	}
+	return triggerModeNames[m]
+}
+
+// Validate returns an error if m is not a known trigger mode.
+func (m TriggerMode) Validate() error {
+	if m < 0 || int(m) >= len(triggerModeNames) {
+		return fmt.Errorf("conddb: invalid trigger mode %d (valid: 0=dcc, 1=noise, 2=ext)", int(m))
+	}
+	return nil
+}
+
+// ParseTriggerMode parses a trigger mode from its name (dcc, noise or
+// ext) or its numerical value.
+func ParseTriggerMode(s string) (TriggerMode, error) {
+	s = strings.TrimSpace(s)
+	for i, name := range triggerModeNames {
+		if strings.EqualFold(s, name) {
+			return TriggerMode(i), nil
+		}
+	}
+	v, err := strconv.Atoi(s)
+	if err != nil {
+		return 0, fmt.Errorf("conddb: could not parse trigger mode %q", s)
+	}
+	m := TriggerMode(v)
+	return m, m.Validate()
+}
+
+// RShaper is the resistance shaper setting of the hardroc ASICs of a DAQ
+// state, as stored in the rshape column.
+//
+// Bit 0 closes the 100k switches and bit 1 the 50k switches of the
+// hardroc shapers.
+type RShaper int
+
+// Valid resistance shaper settings.
+const (
+	RShaperMin RShaper = 0
+	RShaperMax RShaper = 3
+)
+
+var rshaperNames = [...]string{
+	0: "none",
+	1: "100k",
+	2: "50k",
+	3: "100k+50k",
+}
+
+func (v RShaper) String() string {
+	if v < RShaperMin || v > RShaperMax {
+		return "RShaper(" + strconv.Itoa(int(v)) + ")"
+	}
+	return rshaperNames[v]
+}
+
+// Validate returns an error if v is not a valid resistance shaper setting.
+func (v RShaper) Validate() error {
+	if v < RShaperMin || v > RShaperMax {
+		return fmt.Errorf("conddb: invalid R-shaper %d (valid: %d-%d)", int(v), RShaperMin, RShaperMax)
+	}
+	return nil
+}
+
+// ParseRShaper parses a resistance shaper setting from its name (none,
+// 100k, 50k or 100k+50k) or its numerical value.
+func ParseRShaper(s string) (RShaper, error) {
+	s = strings.TrimSpace(s)
+	for i, name := range rshaperNames {
+		if strings.EqualFold(s, name) {
+			return RShaper(i), nil
+		}
+	}
+	i, err := strconv.Atoi(s)
+	if err != nil {
+		return 0, fmt.Errorf("conddb: could not parse R-shaper %q", s)
+	}
+	v := RShaper(i)
+	return v, v.Validate()
+}
+
 type DAQState struct {
 	ID          uint64
 	HRConfig    int32
-	RShape      uint16
-	TriggerMode uint16
+	RShape      RShaper
+	TriggerMode TriggerMode
+}
+
+// Validate returns an error if the DAQ state holds an invalid trigger
+// mode or resistance shaper setting.
+func (daq DAQState) Validate() error {
+	err := daq.TriggerMode.Validate()
+	if err != nil {
+		return fmt.Errorf("conddb: invalid DAQ state %d: %w", daq.ID, err)
+	}
+	err = daq.RShape.Validate()
+	if err != nil {
+		return fmt.Errorf("conddb: invalid DAQ state %d: %w", daq.ID, err)
+	}
+	return nil
 }
 
 type RFM struct {
-	ID   int `json:"rfm"`
-	EDA  int `json:"eda"`
-	Slot int `json:"slot"`
-	DAQ  struct {
-		RShaper     int `json:"rshaper"`
-		TriggerMode int `json:"trigger_type"`
-	} `json:"daq_state"`
+	ID   int         `json:"rfm"`
+	EDA  int         `json:"eda"`
+	Slot int         `json:"slot"`
+	DAQ  RFMDAQState `json:"daq_state"`
+}
+
+// RFMDAQState is the DAQ state applied to an RFM.
+type RFMDAQState struct {
+	RShaper     RShaper     `json:"rshaper"`
+	TriggerMode TriggerMode `json:"trigger_type"`
+}
+
+// Validate returns an error if the DAQ state holds an invalid trigger
+// mode or resistance shaper setting.
+func (daq RFMDAQState) Validate() error {
+	err := daq.TriggerMode.Validate()
+	if err != nil {
+		return err
+	}
+	return daq.RShaper.Validate()
 }
@@ -0,0 +1,137 @@
+// Copyright 2020 The go-lpc Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package conddb
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestTriggerMode(t *testing.T) {
+	for _, tc := range []struct {
+		str  string
+		want TriggerMode
+		name string
+		err  string
+	}{
+		{str: "dcc", want: TriggerDCC, name: "dcc"},
+		{str: "Noise", want: TriggerNoise, name: "noise"},
+		{str: "2", want: TriggerExt, name: "ext"},
+		{str: " 0 ", want: TriggerDCC, name: "dcc"},
+		{str: "3", err: "conddb: invalid trigger mode 3 (valid: 0=dcc, 1=noise, 2=ext)"},
+		{str: "-1", err: "conddb: invalid trigger mode -1 (valid: 0=dcc, 1=noise, 2=ext)"},
+		{str: "dcx", err: `conddb: could not parse trigger mode "dcx"`},
+	} {
+		t.Run(tc.str, func(t *testing.T) {
+			got, err := ParseTriggerMode(tc.str)
+			switch {
+			case err != nil && tc.err != "":
+				if got, want := err.Error(), tc.err; got != want {
+					t.Fatalf("invalid error:\ngot= %v\nwant=%v", got, want)
+				}
+				return
+			case err != nil:
+				t.Fatalf("could not parse trigger mode: %+v", err)
+			case tc.err != "":
+				t.Fatalf("expected an error (%s)", tc.err)
+			}
+			if got != tc.want {
+				t.Fatalf("invalid trigger mode: got=%d, want=%d", got, tc.want)
+			}
+			if got, want := got.String(), tc.name; got != want {
+				t.Fatalf("invalid name: got=%q, want=%q", got, want)
+			}
+		})
+	}
+
+	if got, want := TriggerMode(42).String(), "TriggerMode(42)"; got != want {
+		t.Fatalf("invalid name: got=%q, want=%q", got, want)
+	}
+}
+
+func TestRShaper(t *testing.T) {
+	for _, tc := range []struct {
+		str  string
+		want RShaper
+		name string
+		err  string
+	}{
+		{str: "0", want: 0, name: "none"},
+		{str: "100k", want: 1, name: "100k"},
+		{str: "50K", want: 2, name: "50k"},
+		{str: "3", want: 3, name: "100k+50k"},
+		{str: "4", err: "conddb: invalid R-shaper 4 (valid: 0-3)"},
+		{str: "10k", err: `conddb: could not parse R-shaper "10k"`},
+	} {
+		t.Run(tc.str, func(t *testing.T) {
+			got, err := ParseRShaper(tc.str)
+			switch {
+			case err != nil && tc.err != "":
+				if got, want := err.Error(), tc.err; got != want {
+					t.Fatalf("invalid error:\ngot= %v\nwant=%v", got, want)
+				}
+				return
+			case err != nil:
+				t.Fatalf("could not parse R-shaper: %+v", err)
+			case tc.err != "":
+				t.Fatalf("expected an error (%s)", tc.err)
+			}
+			if got != tc.want {
+				t.Fatalf("invalid R-shaper: got=%d, want=%d", got, tc.want)
+			}
+			if got, want := got.String(), tc.name; got != want {
+				t.Fatalf("invalid name: got=%q, want=%q", got, want)
+			}
+		})
+	}
+}
+
+func TestDAQStateValidate(t *testing.T) {
+	for _, tc := range []struct {
+		daq DAQState
+		err string
+	}{
+		{daq: DAQState{ID: 1, RShape: 3, TriggerMode: TriggerNoise}},
+		{
+			daq: DAQState{ID: 2, RShape: 5, TriggerMode: TriggerDCC},
+			err: "conddb: invalid DAQ state 2: conddb: invalid R-shaper 5 (valid: 0-3)",
+		},
+		{
+			daq: DAQState{ID: 3, RShape: 1, TriggerMode: 7},
+			err: "conddb: invalid DAQ state 3: conddb: invalid trigger mode 7 (valid: 0=dcc, 1=noise, 2=ext)",
+		},
+	} {
+		err := tc.daq.Validate()
+		switch {
+		case err != nil && tc.err != "":
+			if got, want := err.Error(), tc.err; got != want {
+				t.Fatalf("invalid error:\ngot= %v\nwant=%v", got, want)
+			}
+		case err != nil:
+			t.Fatalf("could not validate DAQ state: %+v", err)
+		case tc.err != "":
+			t.Fatalf("expected an error (%s)", tc.err)
+		}
+	}
+}
+
+func TestRFMJSON(t *testing.T) {
+	var rfm RFM
+	err := json.Unmarshal([]byte(`{"rfm":42,"eda":1,"slot":2,"daq_state":{"rshaper":3,"trigger_type":1}}`), &rfm)
+	if err != nil {
+		t.Fatalf("could not unmarshal RFM: %+v", err)
+	}
+	if rfm.DAQ.RShaper != 3 || rfm.DAQ.TriggerMode != TriggerNoise {
+		t.Fatalf("invalid RFM DAQ state: %+v", rfm.DAQ)
+	}
+
+	raw, err := json.Marshal(rfm)
+	if err != nil {
+		t.Fatalf("could not marshal RFM: %+v", err)
+	}
+	if got, want := string(raw), `{"rfm":42,"eda":1,"slot":2,"daq_state":{"rshaper":3,"trigger_type":1}}`; got != want {
+		t.Fatalf("invalid JSON:\ngot= %s\nwant=%s", got, want)
+	}
+}
@@ -0,0 +1,93 @@
+// Copyright 2020 The go-lpc Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package conddb
+
+import (
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+// FieldDiff describes a field of an ASIC configuration that differs
+// between two configurations.
+type FieldDiff struct {
+	Field string // name of the field, with the channel for per-channel fields (e.g. "Mask0[12]")
+	A, B  string // values of the field in both configurations
+}
+
+func (d FieldDiff) String() string {
+	return fmt.Sprintf("%s: %s -> %s", d.Field, d.A, d.B)
+}
+
+// DiffASIC returns the fields that differ between the a and b ASIC
+// configurations.
+// Masks and preamplifier gains are compared channel by channel.
+// The database identifiers of the configurations are not compared.
+func DiffASIC(a, b ASIC) []FieldDiff {
+	const nChans = 64
+
+	var (
+		diffs []FieldDiff
+		va    = reflect.ValueOf(a)
+		vb    = reflect.ValueOf(b)
+		typ   = va.Type()
+	)
+	for i := 0; i < typ.NumField(); i++ {
+		name := typ.Field(i).Name
+		switch name {
+		case "PrimaryID":
+			continue
+		case "PreAmpGain":
+			for ch := 0; ch < nChans; ch++ {
+				ga := preAmpGain(a.PreAmpGain, ch)
+				gb := preAmpGain(b.PreAmpGain, ch)
+				if ga != gb {
+					diffs = append(diffs, FieldDiff{
+						Field: fmt.Sprintf("%s[%d]", name, ch),
+						A:     ga, B: gb,
+					})
+				}
+			}
+			continue
+		}
+
+		fa, fb := va.Field(i), vb.Field(i)
+		if fa.Kind() == reflect.Uint64 { // per-channel masks
+			ma, mb := fa.Uint(), fb.Uint()
+			for ch := uint8(0); ch < nChans; ch++ {
+				ba, bb := bitU64(ma, ch), bitU64(mb, ch)
+				if ba != bb {
+					diffs = append(diffs, FieldDiff{
+						Field: fmt.Sprintf("%s[%d]", name, ch),
+						A:     strconv.Itoa(int(ba)), B: strconv.Itoa(int(bb)),
+					})
+				}
+			}
+			continue
+		}
+
+		sa := fmt.Sprint(fa.Interface())
+		sb := fmt.Sprint(fb.Interface())
+		if sa != sb {
+			diffs = append(diffs, FieldDiff{Field: name, A: sa, B: sb})
+		}
+	}
+	return diffs
+}
+
+// preAmpGain returns the preamplifier gain of the ch-th channel, as
+// stored in the hexadecimal representation of the gains.
+func preAmpGain(gains []byte, ch int) string {
+	if len(gains) < 2*ch+2 {
+		return "n/a"
+	}
+	raw := strings.TrimRight(string(gains[2*ch:2*ch+2]), "\x00")
+	v, err := strconv.ParseUint(raw, 16, 8)
+	if err != nil {
+		return strconv.Quote(raw)
+	}
+	return fmt.Sprintf("0x%02x", v)
+}
@@ -0,0 +1,56 @@
+// Copyright 2020 The go-lpc Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package conddb
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestDiffASIC(t *testing.T) {
+	a := loadASICs(t, 1)[0]
+
+	if diffs := DiffASIC(a, a); diffs != nil {
+		t.Fatalf("unexpected differences: %v", diffs)
+	}
+
+	b := a
+	b.PrimaryID++
+	b.B0 = 280
+	b.Sw50k2 ^= 1
+	b.Mask1 &^= 1 << 3
+	b.PreAmpGain = append([]byte(nil), a.PreAmpGain...)
+	copy(b.PreAmpGain[4:], "7f")
+
+	want := []FieldDiff{
+		{Field: "B0", A: "272", B: "280"},
+		{Field: "Mask1[3]", A: "1", B: "0"},
+		{Field: "Sw50k2", A: "0", B: "1"},
+		{Field: "PreAmpGain[2]", A: "0xff", B: "0x7f"},
+	}
+	got := DiffASIC(a, b)
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("invalid diff:\ngot= %v\nwant=%v", got, want)
+	}
+	if got, want := got[0].String(), "B0: 272 -> 280"; got != want {
+		t.Fatalf("invalid diff string: got=%q, want=%q", got, want)
+	}
+
+	// single-digit gains, as decoded from HR bitstreams.
+	b = a
+	b.PreAmpGain = append([]byte(nil), a.PreAmpGain...)
+	copy(b.PreAmpGain[4:], "5\x00")
+	b.PreAmpGain = b.PreAmpGain[:6]
+	got = DiffASIC(a, b)
+	if got, want := len(got), 1+61; got != want {
+		t.Fatalf("invalid number of differences: got=%d, want=%d", got, want)
+	}
+	if got, want := got[0], (FieldDiff{Field: "PreAmpGain[2]", A: "0xff", B: "0x05"}); got != want {
+		t.Fatalf("invalid diff: got=%v, want=%v", got, want)
+	}
+	if got, want := got[61], (FieldDiff{Field: "PreAmpGain[63]", A: "0xff", B: "n/a"}); got != want {
+		t.Fatalf("invalid diff: got=%v, want=%v", got, want)
+	}
+}
@@ -0,0 +1,250 @@
+// Copyright 2020 The go-lpc Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package conddb
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/go-lpc/mim/model"
+)
+
+// runTables holds the SQL statements creating the run bookkeeping tables.
+//
+// Times are stored as UNIX seconds (UTC). A run that has not been
+// stopped (yet) has a zero stop_time.
+var runTables = []string{
+	`CREATE TABLE IF NOT EXISTS runs (
+	run_id     INT UNSIGNED NOT NULL,
+	board      INT          NOT NULL,
+	host       VARCHAR(255) NOT NULL,
+	mode       VARCHAR(32)  NOT NULL,
+	start_time BIGINT       NOT NULL,
+	stop_time  BIGINT       NOT NULL DEFAULT 0,
+	cycles     BIGINT       NOT NULL DEFAULT 0,
+	settings   TEXT         NOT NULL,
+	PRIMARY KEY (run_id, board)
+)`,
+	`CREATE TABLE IF NOT EXISTS run_files (
+	run_id INT UNSIGNED  NOT NULL,
+	host   VARCHAR(255)  NOT NULL,
+	path   VARCHAR(1024) NOT NULL,
+	kind   VARCHAR(32)   NOT NULL
+)`,
+}
+
+// Run describes the bookkeeping record of a run, as taken by one EDA board.
+type Run struct {
+	ID       uint32
+	Board    int       // EDA board ID (-1 if unknown)
+	Host     string    // host that took the run
+	Mode     string    // DAQ mode (dcc, noise, ...)
+	Start    time.Time // start of the run
+	Stop     time.Time // end of the run (zero if still running)
+	Cycles   int64     // number of acquisition cycles, once stopped
+	Settings string    // run settings, JSON encoded
+}
+
+// Info returns the run record in the shared MIM data model.
+// Settings that are not valid JSON are stored as a JSON string.
+func (run Run) Info() model.RunInfo {
+	info := model.RunInfo{
+		ID:     run.ID,
+		Board:  run.Board,
+		Host:   run.Host,
+		Mode:   run.Mode,
+		Start:  run.Start,
+		Stop:   run.Stop,
+		Cycles: run.Cycles,
+	}
+	switch {
+	case run.Settings == "":
+		// no settings.
+	case json.Valid([]byte(run.Settings)):
+		info.Settings = json.RawMessage(run.Settings)
+	default:
+		raw, _ := json.Marshal(run.Settings)
+		info.Settings = raw
+	}
+	return info
+}
+
+// RunFile describes the location of a file holding data of a run.
+type RunFile struct {
+	Run  uint32
+	Host string // storage host
+	Path string // path of the file on the storage host
+	Kind string // kind of file (raw, lcio, settings, ...)
+}
+
+// CreateRunTables creates the run bookkeeping tables, if needed.
+func (db *DB) CreateRunTables(ctx context.Context) (err error) {
+	for _, stmt := range runTables {
+		err = db.exec(ctx, "CreateRunTables", stmt)
+		if err != nil {
+			return fmt.Errorf("conddb: could not create run tables: %w", err)
+		}
+	}
+	return nil
+}
+
+// BeginRun records the start of a run.
+func (db *DB) BeginRun(ctx context.Context, run Run) error {
+	const stmt = `
+INSERT INTO runs (run_id, board, host, mode, start_time, settings)
+VALUES (?, ?, ?, ?, ?, ?)
+`
+	err := db.exec(ctx, "BeginRun", stmt,
+		run.ID, run.Board, run.Host, run.Mode, run.Start.Unix(), run.Settings,
+	)
+	if err != nil {
+		return fmt.Errorf("conddb: could not record start of run %d: %w", run.ID, err)
+	}
+	return nil
+}
+
+// EndRun records the end of a run previously recorded with BeginRun.
+func (db *DB) EndRun(ctx context.Context, run uint32, board int, stop time.Time, cycles int64) error {
+	const stmt = "UPDATE runs SET stop_time=?, cycles=? WHERE run_id=? AND board=?"
+
+	err := db.exec(ctx, "EndRun", stmt, stop.Unix(), cycles, run, board)
+	if err != nil {
+		return fmt.Errorf("conddb: could not record end of run %d: %w", run, err)
+	}
+	return nil
+}
+
+// AddRunFile records the location of a file holding data of a run.
+func (db *DB) AddRunFile(ctx context.Context, f RunFile) error {
+	const stmt = "INSERT INTO run_files (run_id, host, path, kind) VALUES (?, ?, ?, ?)"
+
+	err := db.exec(ctx, "AddRunFile", stmt, f.Run, f.Host, f.Path, f.Kind)
+	if err != nil {
+		return fmt.Errorf("conddb: could not record file %q of run %d: %w", f.Path, f.Run, err)
+	}
+	return nil
+}
+
+// Runs returns the last n runs, most recent first.
+func (db *DB) Runs(ctx context.Context, n int) ([]Run, error) {
+	const query = `
+SELECT run_id, board, host, mode, start_time, stop_time, cycles, settings
+FROM runs ORDER BY start_time DESC, board LIMIT ?
+`
+	return db.runs(ctx, "Runs", query, n)
+}
+
+// LookupRun returns the records of the provided run, one per EDA board.
+func (db *DB) LookupRun(ctx context.Context, id uint32) ([]Run, error) {
+	const query = `
+SELECT run_id, board, host, mode, start_time, stop_time, cycles, settings
+FROM runs WHERE run_id=? ORDER BY board
+`
+	return db.runs(ctx, "LookupRun", query, id)
+}
+
+func (db *DB) runs(ctx context.Context, name, query string, args ...interface{}) (runs []Run, err error) {
+	defer func(start time.Time) {
+		db.observe(name, query, start, len(runs), err)
+	}(time.Now())
+
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	rows, err := db.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return runs, fmt.Errorf("conddb: could not query runs: %w", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var (
+			run         Run
+			start, stop int64
+		)
+		err = rows.Scan(
+			&run.ID, &run.Board, &run.Host, &run.Mode,
+			&start, &stop, &run.Cycles, &run.Settings,
+		)
+		if err != nil {
+			return runs, fmt.Errorf("conddb: could not scan runs: %w", err)
+		}
+		run.Start = time.Unix(start, 0).UTC()
+		if stop != 0 {
+			run.Stop = time.Unix(stop, 0).UTC()
+		}
+		runs = append(runs, run)
+	}
+
+	if err := rows.Err(); err != nil {
+		return runs, fmt.Errorf("conddb: could not scan db for runs: %w", err)
+	}
+
+	if err := ctx.Err(); err != nil {
+		return runs, fmt.Errorf("conddb: context error while retrieving runs: %w", err)
+	}
+
+	return runs, nil
+}
+
+// RunFiles returns the files recorded for the provided run.
+func (db *DB) RunFiles(ctx context.Context, id uint32) (files []RunFile, err error) {
+	const query = "SELECT run_id, host, path, kind FROM run_files WHERE run_id=? ORDER BY kind, host, path"
+
+	defer func(start time.Time) {
+		db.observe("RunFiles", query, start, len(files), err)
+	}(time.Now())
+
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	rows, err := db.db.QueryContext(ctx, query, id)
+	if err != nil {
+		return files, fmt.Errorf("conddb: could not query run files: %w", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var f RunFile
+		err = rows.Scan(&f.Run, &f.Host, &f.Path, &f.Kind)
+		if err != nil {
+			return files, fmt.Errorf("conddb: could not scan run files: %w", err)
+		}
+		files = append(files, f)
+	}
+
+	if err := rows.Err(); err != nil {
+		return files, fmt.Errorf("conddb: could not scan db for run files: %w", err)
+	}
+
+	if err := ctx.Err(); err != nil {
+		return files, fmt.Errorf("conddb: context error while retrieving run files: %w", err)
+	}
+
+	return files, nil
+}
+
+// exec runs a statement that does not return rows.
+func (db *DB) exec(ctx context.Context, name, stmt string, args ...interface{}) (err error) {
+	n := -1
+	defer func(start time.Time) {
+		db.observe(name, stmt, start, n, err)
+	}(time.Now())
+
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	res, err := db.db.ExecContext(ctx, stmt, args...)
+	if err != nil {
+		return err
+	}
+
+	if v, err := res.RowsAffected(); err == nil {
+		n = int(v)
+	}
+	return nil
+}
@@ -0,0 +1,173 @@
+// Copyright 2020 The go-lpc Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package conddb
+
+import (
+	"context"
+	"database/sql/driver"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/go-lpc/mim/conddbtest"
+)
+
+func TestRunBookkeeping(t *testing.T) {
+	fake := conddbtest.New()
+	defer fake.Close()
+
+	db, err := Open(fake.Name())
+	if err != nil {
+		t.Fatalf("could not open conddb: %+v", err)
+	}
+	defer db.Close()
+
+	var (
+		ctx   = context.Background()
+		start = time.Date(2021, 5, 12, 10, 0, 0, 0, time.UTC)
+		stop  = start.Add(time.Hour)
+	)
+
+	fake.Handle(conddbtest.Query{Prefix: "CREATE TABLE"})
+	fake.Handle(conddbtest.Query{Prefix: "INSERT INTO runs", Affected: 1})
+	fake.Handle(conddbtest.Query{Prefix: "UPDATE runs", Affected: 1})
+	fake.Handle(conddbtest.Query{Prefix: "INSERT INTO run_files", Affected: 1})
+
+	err = db.CreateRunTables(ctx)
+	if err != nil {
+		t.Fatalf("could not create run tables: %+v", err)
+	}
+
+	err = db.BeginRun(ctx, Run{
+		ID: 42, Board: 1, Host: "eda01", Mode: "dcc",
+		Start: start, Settings: `{"Run":42}`,
+	})
+	if err != nil {
+		t.Fatalf("could not begin run: %+v", err)
+	}
+
+	err = db.EndRun(ctx, 42, 1, stop, 1234)
+	if err != nil {
+		t.Fatalf("could not end run: %+v", err)
+	}
+
+	err = db.AddRunFile(ctx, RunFile{Run: 42, Host: "eda01", Path: "/data/hr_sc_042.csv", Kind: "hr-sc"})
+	if err != nil {
+		t.Fatalf("could not add run file: %+v", err)
+	}
+
+	calls := fake.Calls()
+	if got, want := len(calls), len(runTables)+3; got != want {
+		t.Fatalf("invalid number of statements: got=%d, want=%d", got, want)
+	}
+	calls = calls[len(runTables):]
+	for i, want := range [][]driver.Value{
+		{int64(42), int64(1), "eda01", "dcc", start.Unix(), `{"Run":42}`},
+		{stop.Unix(), int64(1234), int64(42), int64(1)},
+		{int64(42), "eda01", "/data/hr_sc_042.csv", "hr-sc"},
+	} {
+		if got := calls[i].Args; !reflect.DeepEqual(got, want) {
+			t.Fatalf("invalid args for %q:\ngot= %v\nwant=%v", calls[i].Query, got, want)
+		}
+	}
+
+	fake.Reset()
+	err = db.BeginRun(ctx, Run{ID: 42})
+	if err == nil {
+		t.Fatalf("expected an error")
+	}
+}
+
+func TestRuns(t *testing.T) {
+	fake := conddbtest.New()
+	defer fake.Close()
+
+	db, err := Open(fake.Name())
+	if err != nil {
+		t.Fatalf("could not open conddb: %+v", err)
+	}
+	defer db.Close()
+
+	start := time.Date(2021, 5, 12, 10, 0, 0, 0, time.UTC)
+	rows := conddbtest.Rows{
+		Names: []string{"run_id", "board", "host", "mode", "start_time", "stop_time", "cycles", "settings"},
+		Values: [][]driver.Value{
+			{int64(43), int64(1), "eda01", "noise", start.Add(time.Hour).Unix(), int64(0), int64(0), "{}"},
+			{int64(42), int64(1), "eda01", "dcc", start.Unix(), start.Add(time.Minute).Unix(), int64(12), "{}"},
+		},
+	}
+	want := []Run{
+		{ID: 43, Board: 1, Host: "eda01", Mode: "noise", Start: start.Add(time.Hour), Settings: "{}"},
+		{ID: 42, Board: 1, Host: "eda01", Mode: "dcc", Start: start, Stop: start.Add(time.Minute), Cycles: 12, Settings: "{}"},
+	}
+
+	_ = run(fake, rows, func(ctx context.Context) error {
+		got, err := db.Runs(ctx, 10)
+		if err != nil {
+			t.Fatalf("could not retrieve runs: %+v", err)
+		}
+		if !reflect.DeepEqual(got, want) {
+			t.Fatalf("invalid runs:\ngot= %+v\nwant=%+v", got, want)
+		}
+		return nil
+	})
+
+	_ = run(fake, conddbtest.Rows{Names: rows.Names, Values: rows.Values[1:]}, func(ctx context.Context) error {
+		got, err := db.LookupRun(ctx, 42)
+		if err != nil {
+			t.Fatalf("could not lookup run: %+v", err)
+		}
+		if !reflect.DeepEqual(got, want[1:]) {
+			t.Fatalf("invalid run:\ngot= %+v\nwant=%+v", got, want[1:])
+		}
+		return nil
+	})
+
+	_ = run(fake, conddbtest.Rows{
+		Names: []string{"run_id", "host", "path", "kind"},
+		Values: [][]driver.Value{
+			{int64(42), "lpccaf", "/data/run_042.slcio", "lcio"},
+			{int64(42), "eda01", "/dev/shm/eda/hr_sc_042.csv", "hr-sc"},
+		},
+	}, func(ctx context.Context) error {
+		got, err := db.RunFiles(ctx, 42)
+		if err != nil {
+			t.Fatalf("could not retrieve run files: %+v", err)
+		}
+		want := []RunFile{
+			{Run: 42, Host: "lpccaf", Path: "/data/run_042.slcio", Kind: "lcio"},
+			{Run: 42, Host: "eda01", Path: "/dev/shm/eda/hr_sc_042.csv", Kind: "hr-sc"},
+		}
+		if !reflect.DeepEqual(got, want) {
+			t.Fatalf("invalid run files:\ngot= %+v\nwant=%+v", got, want)
+		}
+		return nil
+	})
+}
+
+func TestRunInfo(t *testing.T) {
+	start := time.Date(2021, 5, 12, 10, 0, 0, 0, time.UTC)
+	for _, tc := range []struct {
+		settings string
+		want     string
+	}{
+		{settings: "", want: ""},
+		{settings: `{"Run":42}`, want: `{"Run":42}`},
+		{settings: "rshaper=3", want: `"rshaper=3"`},
+	} {
+		t.Run(tc.settings, func(t *testing.T) {
+			run := Run{ID: 42, Board: 1, Host: "eda01", Mode: "dcc", Start: start, Cycles: 12, Settings: tc.settings}
+			info := run.Info()
+			if info.ID != run.ID || info.Board != run.Board || info.Host != run.Host ||
+				info.Mode != run.Mode || !info.Start.Equal(run.Start) ||
+				!info.Running() || info.Cycles != run.Cycles {
+				t.Fatalf("invalid run info: %+v", info)
+			}
+			if got, want := string(info.Settings), tc.want; got != want {
+				t.Fatalf("invalid settings: got=%q, want=%q", got, want)
+			}
+		})
+	}
+}
@@ -0,0 +1,283 @@
+// Copyright 2020 The go-lpc Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Package conddbtest provides an in-memory fake of the MIM condition
+// database, to test code using conddb without a MySQL server.
+//
+// A fake database is populated with canned results, matched against the
+// SQL statements issued by the code under test:
+//
+//	fake := conddbtest.New()
+//	defer fake.Close()
+//
+//	fake.Handle(conddbtest.Query{
+//		Prefix: "SELECT hrconfig FROM detectors",
+//		Rows: conddbtest.Rows{
+//			Names:  []string{"hrconfig"},
+//			Values: [][]driver.Value{{"LPC2020_0"}},
+//		},
+//	})
+//
+//	sqldb, err := fake.Open()
+//	// ...
+//	db := conddb.NewDB(sqldb, "tmvsrv")
+package conddbtest // import "github.com/go-lpc/mim/conddbtest"
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"fmt"
+	"io"
+	"reflect"
+	"strings"
+	"sync"
+)
+
+// DriverName is the name under which the fake database driver is
+// registered with database/sql.
+const DriverName = "conddbtest"
+
+var registry = struct {
+	sync.Mutex
+	n   int
+	dbs map[string]*DB
+}{
+	dbs: make(map[string]*DB),
+}
+
+func init() {
+	sql.Register(DriverName, &Driver{})
+}
+
+// Query describes a canned result for SQL statements.
+type Query struct {
+	// Prefix is the SQL statement prefix to match.
+	// Runs of white spaces are ignored while matching.
+	Prefix string
+
+	// Args are the arguments to match (nil matches any arguments).
+	// Arguments are compared after their conversion by database/sql:
+	// e.g. all integer arguments are converted to int64.
+	Args []driver.Value
+
+	Rows Rows  // result set
+	Err  error // error returned instead of Rows, if any
+
+	// Affected is the number of rows affected by an INSERT, UPDATE or
+	// DELETE statement.
+	Affected int64
+}
+
+func (q Query) match(query string, args []driver.Value) bool {
+	if !strings.HasPrefix(normalize(query), normalize(q.Prefix)) {
+		return false
+	}
+	if q.Args == nil {
+		return true
+	}
+	if len(q.Args) == 0 && len(args) == 0 {
+		return true
+	}
+	return reflect.DeepEqual(q.Args, args)
+}
+
+// Call describes a SQL statement that was run against a fake database.
+type Call struct {
+	Query string
+	Args  []driver.Value
+}
+
+// Rows describes a result set.
+type Rows struct {
+	Names  []string
+	Values [][]driver.Value
+}
+
+// DB is a fake in-memory MIM database.
+type DB struct {
+	name string
+
+	mu    sync.Mutex
+	qs    []Query
+	calls []Call
+}
+
+// New creates a new, empty, fake database.
+// The fake database should be closed after use.
+func New() *DB {
+	registry.Lock()
+	defer registry.Unlock()
+
+	registry.n++
+	db := &DB{name: fmt.Sprintf("conddbtest-%d", registry.n)}
+	registry.dbs[db.name] = db
+	return db
+}
+
+// Name returns the data source name of the fake database.
+func (db *DB) Name() string { return db.name }
+
+// Open opens a database/sql connection to the fake database.
+func (db *DB) Open() (*sql.DB, error) {
+	return sql.Open(DriverName, db.name)
+}
+
+// Close removes the fake database from the registry of fake databases.
+func (db *DB) Close() error {
+	registry.Lock()
+	defer registry.Unlock()
+	delete(registry.dbs, db.name)
+	return nil
+}
+
+// Handle registers a canned result.
+// Queries are matched in registration order: the first matching canned
+// result is returned.
+func (db *DB) Handle(q Query) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	db.qs = append(db.qs, q)
+}
+
+// Calls returns the list of SQL statements run so far against the
+// fake database.
+func (db *DB) Calls() []Call {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	calls := make([]Call, len(db.calls))
+	copy(calls, db.calls)
+	return calls
+}
+
+// Reset removes all canned results and recorded calls.
+func (db *DB) Reset() {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	db.qs = nil
+	db.calls = nil
+}
+
+func (db *DB) query(query string, args []driver.Value) (driver.Rows, error) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	db.calls = append(db.calls, Call{Query: query, Args: args})
+	for _, q := range db.qs {
+		if !q.match(query, args) {
+			continue
+		}
+		if q.Err != nil {
+			return nil, q.Err
+		}
+		return &rows{names: q.Rows.Names, values: q.Rows.Values}, nil
+	}
+	return nil, fmt.Errorf("conddbtest: no result registered for query %q (args=%v)", query, args)
+}
+
+func (db *DB) exec(query string, args []driver.Value) (driver.Result, error) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	db.calls = append(db.calls, Call{Query: query, Args: args})
+	for _, q := range db.qs {
+		if !q.match(query, args) {
+			continue
+		}
+		if q.Err != nil {
+			return nil, q.Err
+		}
+		return driver.RowsAffected(q.Affected), nil
+	}
+	return nil, fmt.Errorf("conddbtest: no result registered for statement %q (args=%v)", query, args)
+}
+
+func normalize(query string) string {
+	return strings.Join(strings.Fields(query), " ")
+}
+
+// Driver is the fake database driver.
+type Driver struct{}
+
+// Open returns a new connection to the fake database.
+//
+// The name may be a MySQL-like data source name: only the part after the
+// last '/' is used to look up the fake database.
+func (drv *Driver) Open(name string) (driver.Conn, error) {
+	if i := strings.LastIndex(name, "/"); i >= 0 {
+		name = name[i+1:]
+	}
+
+	registry.Lock()
+	defer registry.Unlock()
+
+	db, ok := registry.dbs[name]
+	if !ok {
+		return nil, fmt.Errorf("conddbtest: unknown database %q", name)
+	}
+	return &conn{db: db}, nil
+}
+
+type conn struct {
+	db *DB
+}
+
+func (c *conn) Prepare(query string) (driver.Stmt, error) {
+	return &stmt{db: c.db, query: query}, nil
+}
+
+func (c *conn) Close() error { return nil }
+
+func (c *conn) Begin() (driver.Tx, error) {
+	return nil, fmt.Errorf("conddbtest: transactions not supported")
+}
+
+type stmt struct {
+	db    *DB
+	query string
+}
+
+func (stmt *stmt) Close() error  { return nil }
+func (stmt *stmt) NumInput() int { return -1 }
+
+func (stmt *stmt) Exec(args []driver.Value) (driver.Result, error) {
+	return stmt.db.exec(stmt.query, args)
+}
+
+func (stmt *stmt) Query(args []driver.Value) (driver.Rows, error) {
+	return stmt.db.query(stmt.query, args)
+}
+
+func (stmt *stmt) QueryContext(ctx context.Context, args []driver.NamedValue) (driver.Rows, error) {
+	vs := make([]driver.Value, len(args))
+	for i, arg := range args {
+		vs[i] = arg.Value
+	}
+	return stmt.db.query(stmt.query, vs)
+}
+
+type rows struct {
+	names  []string
+	values [][]driver.Value
+}
+
+func (rows *rows) Columns() []string { return rows.names }
+func (rows *rows) Close() error      { return nil }
+
+func (rows *rows) Next(dest []driver.Value) error {
+	if len(rows.values) == 0 {
+		return io.EOF
+	}
+	copy(dest, rows.values[0])
+	rows.values = rows.values[1:]
+	return nil
+}
+
+var (
+	_ driver.Driver           = (*Driver)(nil)
+	_ driver.Conn             = (*conn)(nil)
+	_ driver.Stmt             = (*stmt)(nil)
+	_ driver.StmtQueryContext = (*stmt)(nil)
+	_ driver.Rows             = (*rows)(nil)
+)
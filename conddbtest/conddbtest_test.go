@@ -0,0 +1,173 @@
+// Copyright 2020 The go-lpc Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package conddbtest
+
+import (
+	"context"
+	"database/sql/driver"
+	"fmt"
+	"reflect"
+	"testing"
+)
+
+func TestDB(t *testing.T) {
+	fake := New()
+	defer fake.Close()
+
+	fake.Handle(Query{
+		Prefix: "SELECT dif, asu, iy FROM chambers",
+		Args:   []driver.Value{int64(139)},
+		Rows: Rows{
+			Names:  []string{"dif", "asu", "iy"},
+			Values: [][]driver.Value{{int64(1), int64(2), int64(3)}},
+		},
+	})
+	fake.Handle(Query{
+		Prefix: "SELECT dif, asu, iy FROM chambers",
+		Err:    fmt.Errorf("boom"),
+	})
+	fake.Handle(Query{
+		Prefix: "SELECT  hrconfig\n  FROM detectors",
+		Rows: Rows{
+			Names:  []string{"hrconfig"},
+			Values: [][]driver.Value{{"LPC2020_0"}},
+		},
+	})
+
+	db, err := fake.Open()
+	if err != nil {
+		t.Fatalf("could not open fake db: %+v", err)
+	}
+	defer db.Close()
+
+	ctx := context.Background()
+
+	const (
+		queryChambers = "SELECT dif, asu, iy FROM chambers WHERE detector=? ORDER BY dif"
+		queryHRCfg    = "SELECT hrconfig FROM detectors ORDER BY datetime DESC LIMIT 1"
+	)
+
+	for i := 0; i < 2; i++ {
+		var dif, asu, iy uint32
+		err = db.QueryRowContext(ctx, queryChambers, uint32(139)).Scan(&dif, &asu, &iy)
+		if err != nil {
+			t.Fatalf("could not query chambers: %+v", err)
+		}
+		if got, want := []uint32{dif, asu, iy}, []uint32{1, 2, 3}; !reflect.DeepEqual(got, want) {
+			t.Fatalf("invalid chambers: got=%v, want=%v", got, want)
+		}
+	}
+
+	_, err = db.QueryContext(ctx, queryChambers, uint32(140))
+	if err == nil {
+		t.Fatalf("expected an error")
+	}
+	if got, want := err.Error(), "boom"; got != want {
+		t.Fatalf("invalid error: got=%q, want=%q", got, want)
+	}
+
+	var hrcfg string
+	err = db.QueryRowContext(ctx, queryHRCfg).Scan(&hrcfg)
+	if err != nil {
+		t.Fatalf("could not query hrconfig: %+v", err)
+	}
+	if got, want := hrcfg, "LPC2020_0"; got != want {
+		t.Fatalf("invalid hrconfig: got=%q, want=%q", got, want)
+	}
+
+	_, err = db.QueryContext(ctx, "SELECT * FROM daqstates")
+	if err == nil {
+		t.Fatalf("expected an error")
+	}
+	if got, want := err.Error(), `conddbtest: no result registered for query "SELECT * FROM daqstates" (args=[])`; got != want {
+		t.Fatalf("invalid error:\ngot= %q\nwant=%q", got, want)
+	}
+
+	want := []Call{
+		{Query: queryChambers, Args: []driver.Value{int64(139)}},
+		{Query: queryChambers, Args: []driver.Value{int64(139)}},
+		{Query: queryChambers, Args: []driver.Value{int64(140)}},
+		{Query: queryHRCfg, Args: []driver.Value{}},
+		{Query: "SELECT * FROM daqstates", Args: []driver.Value{}},
+	}
+	if got := fake.Calls(); !reflect.DeepEqual(got, want) {
+		t.Fatalf("invalid calls:\ngot= %#v\nwant=%#v", got, want)
+	}
+
+	fake.Reset()
+	if got := fake.Calls(); len(got) != 0 {
+		t.Fatalf("invalid calls after reset: %v", got)
+	}
+}
+
+func TestExec(t *testing.T) {
+	fake := New()
+	defer fake.Close()
+
+	fake.Handle(Query{
+		Prefix:   "UPDATE runs",
+		Args:     []driver.Value{int64(42)},
+		Affected: 1,
+	})
+	fake.Handle(Query{
+		Prefix: "UPDATE runs",
+		Err:    fmt.Errorf("boom"),
+	})
+
+	db, err := fake.Open()
+	if err != nil {
+		t.Fatalf("could not open fake db: %+v", err)
+	}
+	defer db.Close()
+
+	const stmt = "UPDATE runs SET cycles=10 WHERE run_id=?"
+
+	res, err := db.Exec(stmt, 42)
+	if err != nil {
+		t.Fatalf("could not exec statement: %+v", err)
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		t.Fatalf("could not retrieve number of affected rows: %+v", err)
+	}
+	if got, want := n, int64(1); got != want {
+		t.Fatalf("invalid number of affected rows: got=%d, want=%d", got, want)
+	}
+
+	_, err = db.Exec(stmt, 43)
+	if err == nil {
+		t.Fatalf("expected an error")
+	}
+	if got, want := err.Error(), "boom"; got != want {
+		t.Fatalf("invalid error: got=%q, want=%q", got, want)
+	}
+
+	_, err = db.Exec("DELETE FROM runs")
+	if err == nil {
+		t.Fatalf("expected an error")
+	}
+	if got, want := err.Error(), `conddbtest: no result registered for statement "DELETE FROM runs" (args=[])`; got != want {
+		t.Fatalf("invalid error:\ngot= %q\nwant=%q", got, want)
+	}
+}
+
+func TestUnknownDB(t *testing.T) {
+	fake := New()
+	_ = fake.Close()
+
+	db, err := fake.Open()
+	if err != nil {
+		t.Fatalf("could not open fake db: %+v", err)
+	}
+	defer db.Close()
+
+	err = db.Ping()
+	if err == nil {
+		t.Fatalf("expected an error")
+	}
+	if got, want := err.Error(), fmt.Sprintf("conddbtest: unknown database %q", fake.Name()); got != want {
+		t.Fatalf("invalid error: got=%q, want=%q", got, want)
+	}
+}
@@ -0,0 +1,384 @@
+// Copyright 2020 The go-lpc Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Package daq provides a high-level API to embed the MIM DAQ of an EDA
+// board in Go programs.
+//
+// A DAQ wires together the EDA device (or any backend registered with
+// eda.Register, e.g. a simulator), the condition database used to
+// configure it and to record runs, the monitoring sinks of the DIF data
+// and the logs and metrics of the application:
+//
+//	d, err := daq.New(
+//		daq.WithDevice("/dev/mem", "/data", "/dev/shm"),
+//		daq.WithCondDB("tmvsrv"),
+//		daq.WithRunBookkeeping(true),
+//		daq.WithEDA(eda.WithEDAID(1), eda.WithSinkHost("daq-host")),
+//	)
+//	if err != nil { ... }
+//	defer d.Close()
+//
+//	err = d.Configure(ctx)
+//	err = d.Start(run)
+//	...
+//	err = d.Stop()
+package daq // import "github.com/go-lpc/mim/daq"
+
+import (
+	"context"
+	"fmt"
+	"sync"
+
+	"github.com/go-lpc/mim/conddb"
+	"github.com/go-lpc/mim/eda"
+	"github.com/go-lpc/mim/obs"
+)
+
+// Option configures a DAQ.
+type Option func(*config)
+
+type config struct {
+	devmem string // memory device of the EDA board
+	odir   string // output directory
+	devshm string // shared memory directory
+
+	dbname string     // name of the condition database to open
+	db     *conddb.DB // condition database provided by the caller
+	detID  int        // detector ID (-1: last detector)
+	rundb  bool       // whether runs are recorded in the condition database
+
+	mons map[uint8][]string // DIF ID -> [addr]:port of monitor sinks
+
+	log     obs.Logger
+	metrics obs.Metrics
+
+	eda []eda.Option
+}
+
+func newConfig() config {
+	return config{
+		devmem: "/dev/mem",
+		odir:   "/home/root/run",
+		devshm: "/dev/shm",
+		detID:  -1,
+	}
+}
+
+// WithDevice sets the memory device of the EDA board, the output directory
+// of the runs and the shared memory directory.
+func WithDevice(devmem, odir, devshm string) Option {
+	return func(cfg *config) {
+		cfg.devmem = devmem
+		cfg.odir = odir
+		cfg.devshm = devshm
+	}
+}
+
+// WithCondDB configures the DAQ from the condition database of the
+// provided name. The database is opened by New and closed by Close.
+func WithCondDB(name string) Option {
+	return func(cfg *config) {
+		cfg.dbname = name
+		cfg.db = nil
+	}
+}
+
+// WithDB configures the DAQ from the provided condition database.
+// The database is not closed by Close.
+func WithDB(db *conddb.DB) Option {
+	return func(cfg *config) {
+		cfg.db = db
+		cfg.dbname = ""
+	}
+}
+
+// WithDetector sets the ID of the detector whose chambers are configured
+// from the condition database (default: the last detector).
+func WithDetector(id int) Option {
+	return func(cfg *config) {
+		cfg.detID = id
+	}
+}
+
+// WithRunBookkeeping enables the recording of runs in the condition
+// database.
+func WithRunBookkeeping(v bool) Option {
+	return func(cfg *config) {
+		cfg.rundb = v
+	}
+}
+
+// WithMonitor sends a copy of the data of the provided DIF to the monitor
+// sink at addr.
+func WithMonitor(dif uint8, addr string) Option {
+	return func(cfg *config) {
+		if cfg.mons == nil {
+			cfg.mons = make(map[uint8][]string)
+		}
+		cfg.mons[dif] = append(cfg.mons[dif], addr)
+	}
+}
+
+// WithLogger sets the logger of the DAQ and of its EDA device.
+func WithLogger(l obs.Logger) Option {
+	return func(cfg *config) {
+		cfg.log = l
+	}
+}
+
+// WithMetrics sets the metrics of the DAQ and of its EDA device.
+func WithMetrics(m obs.Metrics) Option {
+	return func(cfg *config) {
+		cfg.metrics = m
+	}
+}
+
+// WithEDA appends options to the ones of the EDA device, e.g. to select
+// its backend, the host of its DIF data sinks or its run limits.
+// Options set by the DAQ itself (logger, metrics, run database) take
+// precedence.
+func WithEDA(opts ...eda.Option) Option {
+	return func(cfg *config) {
+		cfg.eda = append(cfg.eda, opts...)
+	}
+}
+
+type state int
+
+const (
+	stateIdle state = iota
+	stateBooted
+	stateConfigured
+	stateRunning
+)
+
+// DAQ is the data acquisition of an EDA board.
+type DAQ struct {
+	cfg config
+
+	mu    sync.Mutex
+	dev   eda.Driver
+	db    *conddb.DB // condition database (nil: configuration from files)
+	owned bool       // whether the condition database is closed by Close
+	state state
+	mons  bool // whether the monitor sinks are registered with the device
+}
+
+// New creates a DAQ for the EDA board described by the options.
+func New(opts ...Option) (*DAQ, error) {
+	cfg := newConfig()
+	for _, opt := range opts {
+		opt(&cfg)
+	}
+
+	d := &DAQ{cfg: cfg, db: cfg.db}
+	if cfg.dbname != "" {
+		db, err := conddb.Open(cfg.dbname)
+		if err != nil {
+			return nil, fmt.Errorf("daq: could not open condition db %q: %w", cfg.dbname, err)
+		}
+		d.db = db
+		d.owned = true
+	}
+	if cfg.rundb && d.db == nil {
+		_ = d.closeDB()
+		return nil, fmt.Errorf("daq: run bookkeeping needs a condition database")
+	}
+
+	edaOpts := append([]eda.Option(nil), cfg.eda...)
+	if cfg.log != nil {
+		edaOpts = append(edaOpts, eda.WithLogger(cfg.log))
+	}
+	if cfg.metrics != nil {
+		edaOpts = append(edaOpts, eda.WithMetrics(cfg.metrics))
+	}
+	if cfg.rundb {
+		edaOpts = append(edaOpts, eda.WithRunDB(d.db))
+	}
+
+	dev, err := eda.Open(cfg.devmem, cfg.odir, cfg.devshm, edaOpts...)
+	if err != nil {
+		_ = d.closeDB()
+		return nil, fmt.Errorf("daq: could not open EDA device: %w", err)
+	}
+	d.dev = dev
+
+	return d, nil
+}
+
+// Device returns the EDA device of the DAQ.
+func (d *DAQ) Device() eda.Driver {
+	return d.dev
+}
+
+// Boot boots the provided RFMs.
+// Boot is only needed when the DAQ is configured from files: Configure
+// boots the RFMs of the detector chambers when the DAQ is configured from
+// the condition database.
+func (d *DAQ) Boot(rfms []conddb.RFM) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if d.state == stateRunning {
+		return fmt.Errorf("daq: could not boot RFMs: run in progress")
+	}
+	err := d.dev.Boot(rfms)
+	if err != nil {
+		return fmt.Errorf("daq: could not boot RFMs: %w", err)
+	}
+	d.state = stateBooted
+	d.mons = false // booting forgets the monitor sinks.
+	return nil
+}
+
+// Configure configures the RFMs and initializes the EDA device: the DIF
+// data sinks and monitors are connected and the hardrocs are configured.
+//
+// The RFMs and their ASICs configuration are read from the condition
+// database, if any. Otherwise, the configuration files of the EDA device
+// (see eda.WithConfigDir) are used.
+//
+// The monitor sinks (see WithMonitor) are registered once per boot of the
+// RFMs: configuring the DAQ again between runs keeps them.
+func (d *DAQ) Configure(ctx context.Context) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if d.state == stateRunning {
+		return fmt.Errorf("daq: could not configure: run in progress")
+	}
+
+	var err error
+	switch d.db {
+	case nil:
+		err = d.dev.Configure()
+	default:
+		// the RFMs of the detector are booted again.
+		d.mons = false
+		err = d.configureFromDB(ctx)
+	}
+	if err != nil {
+		return fmt.Errorf("daq: could not configure EDA device: %w", err)
+	}
+
+	if !d.mons {
+		for dif, addrs := range d.cfg.mons {
+			for _, addr := range addrs {
+				err = d.dev.AddDIFMonitor(addr, dif)
+				if err != nil {
+					return fmt.Errorf("daq: could not add monitor %q for DIF=%d: %w", addr, dif, err)
+				}
+			}
+		}
+		d.mons = true
+	}
+
+	err = d.dev.Initialize()
+	if err != nil {
+		return fmt.Errorf("daq: could not initialize EDA device: %w", err)
+	}
+	d.state = stateConfigured
+	return nil
+}
+
+func (d *DAQ) configureFromDB(ctx context.Context) error {
+	detID := uint32(d.cfg.detID)
+	if d.cfg.detID < 0 {
+		id, err := d.db.LastDetectorID(ctx)
+		if err != nil {
+			return fmt.Errorf("could not retrieve last detector ID: %w", err)
+		}
+		detID = id
+	}
+	return d.dev.ConfigureFromDB(ctx, d.db, detID)
+}
+
+// Start starts the run of the provided number.
+func (d *DAQ) Start(run uint32) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	switch d.state {
+	case stateConfigured:
+		// ok.
+	case stateRunning:
+		return fmt.Errorf("daq: could not start run %d: run in progress", run)
+	default:
+		return fmt.Errorf("daq: could not start run %d: DAQ not configured", run)
+	}
+
+	err := d.dev.Start(run)
+	if err != nil {
+		return fmt.Errorf("daq: could not start run %d: %w", run, err)
+	}
+	d.state = stateRunning
+	return nil
+}
+
+// Stop stops the current run.
+// The DAQ must be configured again before the next run.
+func (d *DAQ) Stop() error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if d.state != stateRunning {
+		return fmt.Errorf("daq: could not stop run: no run in progress")
+	}
+	return d.stop()
+}
+
+func (d *DAQ) stop() error {
+	d.state = stateIdle
+	err := d.dev.Stop()
+	if err != nil {
+		return fmt.Errorf("daq: could not stop run: %w", err)
+	}
+	return nil
+}
+
+// Done returns a channel closed when the acquisition of the current run
+// ends on its own, e.g. once a run limit is reached or when it fails.
+// Done must be called after Start.
+// The channel is never closed for EDA backends that do not report it.
+func (d *DAQ) Done() <-chan struct{} {
+	if dev, ok := d.dev.(interface{ Done() <-chan struct{} }); ok {
+		return dev.Done()
+	}
+	return nil
+}
+
+// Close stops the run in progress, if any, and releases the EDA device
+// and the condition database opened by New.
+func (d *DAQ) Close() error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	var errStop error
+	if d.state == stateRunning {
+		errStop = d.stop()
+	}
+
+	errDev := d.dev.Close()
+	errDB := d.closeDB()
+
+	switch {
+	case errStop != nil:
+		return errStop
+	case errDev != nil:
+		return fmt.Errorf("daq: could not close EDA device: %w", errDev)
+	case errDB != nil:
+		return fmt.Errorf("daq: could not close condition db: %w", errDB)
+	}
+	return nil
+}
+
+func (d *DAQ) closeDB() error {
+	if !d.owned || d.db == nil {
+		return nil
+	}
+	err := d.db.Close()
+	d.db = nil
+	d.owned = false
+	return err
+}
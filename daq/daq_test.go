@@ -0,0 +1,349 @@
+// Copyright 2020 The go-lpc Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package daq
+
+import (
+	"context"
+	"database/sql/driver"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"sync"
+	"testing"
+
+	"github.com/go-lpc/mim/conddb"
+	"github.com/go-lpc/mim/conddbtest"
+	"github.com/go-lpc/mim/eda"
+)
+
+// fakeDriver is an EDA device backend recording the calls of the DAQ.
+type fakeDriver struct {
+	mu   sync.Mutex
+	cmds []string
+	done chan struct{}
+}
+
+var fakes = struct {
+	sync.Mutex
+	devs map[string]*fakeDriver // by memory device
+}{
+	devs: make(map[string]*fakeDriver),
+}
+
+func init() {
+	eda.Register("daq-test", func(devmem, odir, devshm string, opts ...eda.Option) (eda.Driver, error) {
+		fakes.Lock()
+		defer fakes.Unlock()
+		dev := &fakeDriver{done: make(chan struct{})}
+		fakes.devs[devmem] = dev
+		return dev, nil
+	})
+	eda.Register("daq-test-eda", func(devmem, odir, devshm string, opts ...eda.Option) (eda.Driver, error) {
+		opts = append(opts, eda.WithBackend("go"))
+		dev, err := eda.Open(devmem, odir, devshm, opts...)
+		if err != nil {
+			return nil, err
+		}
+		return edaDriver{dev.(*eda.Device)}, nil
+	})
+}
+
+// edaDriver is an EDA device whose run control, needing an FPGA, does
+// nothing. The state of its DIFs is managed by the EDA device.
+type edaDriver struct {
+	*eda.Device
+}
+
+func (edaDriver) Initialize() error      { return nil }
+func (edaDriver) Start(run uint32) error { return nil }
+func (edaDriver) Stop() error            { return nil }
+
+// newFakeDevMem creates a fake memory device, spanning the lightweight
+// HPS-to-FPGA bus of an EDA board.
+func newFakeDevMem(t *testing.T) string {
+	const end = 0xff200000 + 0x00100000 // end of the lightweight HPS-to-FPGA bus.
+	fname := filepath.Join(t.TempDir(), "dev.mem")
+	err := ioutil.WriteFile(fname, nil, 0644)
+	if err != nil {
+		t.Fatalf("could not create fake memory device: %+v", err)
+	}
+	err = os.Truncate(fname, end+1)
+	if err != nil {
+		t.Fatalf("could not resize fake memory device: %+v", err)
+	}
+	return fname
+}
+
+func (dev *fakeDriver) record(format string, args ...interface{}) error {
+	dev.mu.Lock()
+	defer dev.mu.Unlock()
+	dev.cmds = append(dev.cmds, fmt.Sprintf(format, args...))
+	return nil
+}
+
+func (dev *fakeDriver) Boot(rfms []conddb.RFM) error { return dev.record("boot(%d)", len(rfms)) }
+func (dev *fakeDriver) Configure() error             { return dev.record("configure") }
+func (dev *fakeDriver) ConfigureFromDB(ctx context.Context, db *conddb.DB, detID uint32) error {
+	return dev.record("configure-db(%d)", detID)
+}
+func (dev *fakeDriver) ConfigureDIF(addr string, dif uint8, asics []conddb.ASIC) error {
+	return dev.record("configure-dif(%d)", dif)
+}
+func (dev *fakeDriver) AddDIFMonitor(addr string, dif uint8) error {
+	return dev.record("monitor(%d, %s)", dif, addr)
+}
+func (dev *fakeDriver) Initialize() error                   { return dev.record("initialize") }
+func (dev *fakeDriver) Start(run uint32) error              { return dev.record("start(%d)", run) }
+func (dev *fakeDriver) Stop() error                         { return dev.record("stop") }
+func (dev *fakeDriver) DrainFIFOs() (map[int]uint32, error) { return nil, dev.record("drain") }
+func (dev *fakeDriver) Close() error                        { return dev.record("close") }
+func (dev *fakeDriver) Done() <-chan struct{}               { return dev.done }
+
+func TestDAQ(t *testing.T) {
+	fake := conddbtest.New()
+	defer fake.Close()
+
+	fake.Handle(conddbtest.Query{
+		Prefix: "SELECT identifier FROM detectors",
+		Rows: conddbtest.Rows{
+			Names:  []string{"identifier"},
+			Values: [][]driver.Value{{int64(42)}},
+		},
+	})
+
+	sqldb, err := fake.Open()
+	if err != nil {
+		t.Fatalf("could not open fake db: %+v", err)
+	}
+	db := conddb.NewDB(sqldb, "tmvsrv")
+	defer db.Close()
+
+	for _, tc := range []struct {
+		name string
+		opts []Option
+		want []string
+	}{
+		{
+			name: "db",
+			opts: []Option{
+				WithDB(db),
+				WithRunBookkeeping(true),
+				WithMonitor(1, "mon:10001"),
+			},
+			want: []string{
+				"configure-db(42)",
+				"monitor(1, mon:10001)",
+				"initialize",
+				"start(7)",
+				"stop",
+				"close",
+			},
+		},
+		{
+			name: "db-detector",
+			opts: []Option{
+				WithDB(db),
+				WithDetector(3),
+			},
+			want: []string{
+				"configure-db(3)",
+				"initialize",
+				"start(7)",
+				"stop",
+				"close",
+			},
+		},
+		{
+			name: "files",
+			opts: nil,
+			want: []string{
+				"configure",
+				"initialize",
+				"start(7)",
+				"stop",
+				"close",
+			},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			devmem := "mem-" + tc.name
+			opts := append([]Option{
+				WithDevice(devmem, t.TempDir(), t.TempDir()),
+				WithEDA(eda.WithBackend("daq-test")),
+			}, tc.opts...)
+
+			d, err := New(opts...)
+			if err != nil {
+				t.Fatalf("could not create DAQ: %+v", err)
+			}
+
+			err = d.Start(7)
+			if err == nil {
+				t.Fatalf("expected an error")
+			}
+			if got, want := err.Error(), "daq: could not start run 7: DAQ not configured"; got != want {
+				t.Fatalf("invalid error: got=%q, want=%q", got, want)
+			}
+
+			err = d.Configure(context.Background())
+			if err != nil {
+				t.Fatalf("could not configure DAQ: %+v", err)
+			}
+
+			err = d.Start(7)
+			if err != nil {
+				t.Fatalf("could not start run: %+v", err)
+			}
+			if d.Done() == nil {
+				t.Fatalf("invalid nil done channel")
+			}
+
+			err = d.Configure(context.Background())
+			if err == nil {
+				t.Fatalf("expected an error")
+			}
+			if got, want := err.Error(), "daq: could not configure: run in progress"; got != want {
+				t.Fatalf("invalid error: got=%q, want=%q", got, want)
+			}
+
+			err = d.Stop()
+			if err != nil {
+				t.Fatalf("could not stop run: %+v", err)
+			}
+
+			err = d.Stop()
+			if err == nil {
+				t.Fatalf("expected an error")
+			}
+
+			err = d.Close()
+			if err != nil {
+				t.Fatalf("could not close DAQ: %+v", err)
+			}
+
+			fakes.Lock()
+			dev := fakes.devs[devmem]
+			fakes.Unlock()
+			if got, want := dev.cmds, tc.want; !reflect.DeepEqual(got, want) {
+				t.Fatalf("invalid device calls:\ngot= %q\nwant=%q", got, want)
+			}
+		})
+	}
+}
+
+func TestDAQClose(t *testing.T) {
+	d, err := New(
+		WithDevice("mem-close", t.TempDir(), t.TempDir()),
+		WithEDA(eda.WithBackend("daq-test")),
+		WithMonitor(2, "mon:20002"),
+		WithMonitor(1, "mon:10001"),
+		WithMonitor(1, "mon:10002"),
+	)
+	if err != nil {
+		t.Fatalf("could not create DAQ: %+v", err)
+	}
+
+	err = d.Configure(context.Background())
+	if err != nil {
+		t.Fatalf("could not configure DAQ: %+v", err)
+	}
+	err = d.Start(1)
+	if err != nil {
+		t.Fatalf("could not start run: %+v", err)
+	}
+
+	// closing the DAQ stops the run in progress.
+	err = d.Close()
+	if err != nil {
+		t.Fatalf("could not close DAQ: %+v", err)
+	}
+
+	fakes.Lock()
+	dev := fakes.devs["mem-close"]
+	fakes.Unlock()
+
+	mons := append([]string(nil), dev.cmds[1:4]...)
+	sort.Strings(mons)
+	if got, want := mons, []string{"monitor(1, mon:10001)", "monitor(1, mon:10002)", "monitor(2, mon:20002)"}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("invalid monitors:\ngot= %q\nwant=%q", got, want)
+	}
+	if got, want := dev.cmds[len(dev.cmds)-2:], []string{"stop", "close"}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("invalid device calls:\ngot= %q\nwant=%q", got, want)
+	}
+}
+
+func TestDAQReconfigure(t *testing.T) {
+	d, err := New(
+		WithDevice(newFakeDevMem(t), t.TempDir(), t.TempDir()),
+		WithEDA(
+			eda.WithBackend("daq-test-eda"),
+			eda.WithConfigDir("../eda/testdata"),
+			eda.WithCtlAddr(""),
+		),
+		WithMonitor(1, "mon:10001"),
+	)
+	if err != nil {
+		t.Fatalf("could not create DAQ: %+v", err)
+	}
+	defer d.Close()
+
+	err = d.Boot([]conddb.RFM{{ID: 1, Slot: 2}})
+	if err != nil {
+		t.Fatalf("could not boot RFMs: %+v", err)
+	}
+
+	for i := 0; i < 2; i++ {
+		err = d.Configure(context.Background())
+		if err != nil {
+			t.Fatalf("could not configure DAQ (#%d): %+v", i, err)
+		}
+
+		err = d.Start(uint32(7 + i))
+		if err != nil {
+			t.Fatalf("could not start run (#%d): %+v", i, err)
+		}
+
+		err = d.Stop()
+		if err != nil {
+			t.Fatalf("could not stop run (#%d): %+v", i, err)
+		}
+	}
+
+	// booting the RFMs again forgets the monitors: they are registered again.
+	err = d.Boot([]conddb.RFM{{ID: 1, Slot: 2}})
+	if err != nil {
+		t.Fatalf("could not boot RFMs: %+v", err)
+	}
+	err = d.Configure(context.Background())
+	if err != nil {
+		t.Fatalf("could not configure DAQ after boot: %+v", err)
+	}
+	err = d.Device().AddDIFMonitor("mon:10001", 1)
+	if err == nil {
+		t.Fatalf("monitor not registered after boot")
+	}
+
+	err = d.Close()
+	if err != nil {
+		t.Fatalf("could not close DAQ: %+v", err)
+	}
+}
+
+func TestDAQRunBookkeeping(t *testing.T) {
+	_, err := New(
+		WithDevice("mem-rundb", t.TempDir(), t.TempDir()),
+		WithEDA(eda.WithBackend("daq-test")),
+		WithRunBookkeeping(true),
+	)
+	if err == nil {
+		t.Fatalf("expected an error")
+	}
+	if got, want := err.Error(), "daq: run bookkeeping needs a condition database"; got != want {
+		t.Fatalf("invalid error: got=%q, want=%q", got, want)
+	}
+}
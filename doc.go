@@ -26,6 +26,10 @@ func versionOf(b *debug.BuildInfo) (version, sum string) {
 	}
 
 	const root = "github.com/go-lpc/mim"
+	if b.Main.Path == root {
+		// binary built from the mim module itself (e.g. a mim command).
+		return b.Main.Version, b.Main.Sum
+	}
 	for _, m := range b.Deps {
 		if m.Path != root {
 			continue
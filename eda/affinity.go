@@ -0,0 +1,98 @@
+// Copyright 2020 The go-lpc Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package eda
+
+import (
+	"runtime"
+)
+
+// CPUAffinity describes how the goroutines of the DAQ pipeline are
+// scheduled on the CPUs of the SoC during runs.
+//
+// On the dual-core Cyclone-V, binding the FIFO reader and the senders to
+// different cores keeps the TCP senders from delaying the readout.
+type CPUAffinity struct {
+	MaxProcs int // GOMAXPROCS during runs (0: unchanged)
+	Reader   int // CPU of the goroutine reading the RFM FIFOs (-1: any)
+	Senders  int // CPU of the goroutines running the senders (-1: any)
+}
+
+// pin applies the CPU affinity of the device to the DAQ pipeline p, from
+// the goroutine running the pipeline.
+// pin returns a function undoing the changes that can be undone.
+func (dev *Device) pin(p *pipeline) func() {
+	aff := dev.cfg.daq.cpu
+	if aff == nil {
+		return func() {}
+	}
+
+	var undo []func()
+	if aff.MaxProcs > 0 {
+		old := runtime.GOMAXPROCS(aff.MaxProcs)
+		undo = append(undo, func() { runtime.GOMAXPROCS(old) })
+	}
+
+	if aff.Reader >= 0 {
+		// the thread is not unlocked: it exits with the goroutine, and
+		// does not go back to the scheduler with a restricted affinity.
+		runtime.LockOSThread()
+		err := bindCPU(aff.Reader)
+		if err != nil {
+			dev.msg.Printf("could not bind FIFO reader to CPU %d: %+v", aff.Reader, err)
+		}
+	}
+
+	if aff.Senders >= 0 {
+		for i, s := range p.senders {
+			ps := newPinnedSender(s, aff.Senders, dev.msg.Printf)
+			p.senders[i] = ps
+			undo = append(undo, ps.close)
+		}
+	}
+
+	return func() {
+		for i := len(undo) - 1; i >= 0; i-- {
+			undo[i]()
+		}
+	}
+}
+
+// pinnedSender runs a sender on a goroutine bound to a CPU.
+type pinnedSender struct {
+	s    Sender
+	reqs chan *Cycle
+	errs chan error
+}
+
+func newPinnedSender(s Sender, cpu int, printf func(format string, args ...interface{})) *pinnedSender {
+	ps := &pinnedSender{
+		s:    s,
+		reqs: make(chan *Cycle),
+		errs: make(chan error),
+	}
+	go ps.run(cpu, printf)
+	return ps
+}
+
+func (ps *pinnedSender) run(cpu int, printf func(format string, args ...interface{})) {
+	runtime.LockOSThread()
+	err := bindCPU(cpu)
+	if err != nil {
+		printf("could not bind sender to CPU %d: %+v", cpu, err)
+	}
+	for cycle := range ps.reqs {
+		ps.errs <- ps.s.Send(cycle)
+	}
+}
+
+// Send sends the cycle with the wrapped sender, from the pinned goroutine.
+func (ps *pinnedSender) Send(cycle *Cycle) error {
+	ps.reqs <- cycle
+	return <-ps.errs
+}
+
+func (ps *pinnedSender) close() {
+	close(ps.reqs)
+}
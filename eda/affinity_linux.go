@@ -0,0 +1,16 @@
+// Copyright 2020 The go-lpc Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package eda
+
+import (
+	"golang.org/x/sys/unix"
+)
+
+// bindCPU binds the OS thread of the calling goroutine to the provided CPU.
+func bindCPU(cpu int) error {
+	var set unix.CPUSet
+	set.Set(cpu)
+	return unix.SchedSetaffinity(0, &set)
+}
@@ -2,22 +2,16 @@
 // Use of this source code is governed by a BSD-style
 // license that can be found in the LICENSE file.
 
+//go:build !linux
+// +build !linux
+
 package eda
 
 import (
-	"io"
+	"fmt"
+	"runtime"
 )
 
-type wbuf struct {
-	p []byte
-	c int
-}
-
-func (w *wbuf) Write(p []byte) (int, error) {
-	if w.c >= len(w.p) {
-		return 0, io.EOF
-	}
-	n := copy(w.p[w.c:], p)
-	w.c += n
-	return n, nil
+func bindCPU(cpu int) error {
+	return fmt.Errorf("eda: CPU affinity not supported on %s", runtime.GOOS)
 }
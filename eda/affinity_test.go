@@ -0,0 +1,75 @@
+// Copyright 2020 The go-lpc Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package eda
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"reflect"
+	"runtime"
+	"testing"
+)
+
+func TestCPUAffinity(t *testing.T) {
+	var (
+		errSend = errors.New("send error")
+		cycles  []int
+		sender  = SenderFunc(func(cycle *Cycle) error {
+			cycles = append(cycles, cycle.Num)
+			if cycle.Num < 0 {
+				return errSend
+			}
+			return nil
+		})
+	)
+
+	dev := &Device{msg: log.New(ioutil.Discard, "eda: ", 0)}
+	dev.cfg.daq.cpu = &CPUAffinity{MaxProcs: 1, Reader: 0, Senders: 0}
+
+	procs := runtime.GOMAXPROCS(0)
+	done := make(chan error)
+	go func() {
+		// binding failures, e.g. on a restricted CPU set, are only logged.
+		p := pipeline{senders: []Sender{sender}}
+		undo := dev.pin(&p)
+		defer undo()
+
+		if got, want := runtime.GOMAXPROCS(0), 1; got != want {
+			done <- fmt.Errorf("invalid GOMAXPROCS: got=%d, want=%d", got, want)
+			return
+		}
+		if _, ok := p.senders[0].(*pinnedSender); !ok {
+			done <- fmt.Errorf("sender not pinned: %T", p.senders[0])
+			return
+		}
+
+		for _, num := range []int{0, 1, 2} {
+			err := p.send(&Cycle{Num: num})
+			if err != nil {
+				done <- fmt.Errorf("could not send cycle %d: %w", num, err)
+				return
+			}
+		}
+		err := p.send(&Cycle{Num: -1})
+		if !errors.Is(err, errSend) {
+			done <- fmt.Errorf("invalid send error: got=%v, want=%v", err, errSend)
+			return
+		}
+		done <- nil
+	}()
+
+	err := <-done
+	if err != nil {
+		t.Fatalf("%+v", err)
+	}
+	if got, want := runtime.GOMAXPROCS(0), procs; got != want {
+		t.Fatalf("GOMAXPROCS not restored: got=%d, want=%d", got, want)
+	}
+	if got, want := cycles, []int{0, 1, 2, -1}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("invalid sent cycles: got=%v, want=%v", got, want)
+	}
+}
@@ -0,0 +1,135 @@
+// Copyright 2020 The go-lpc Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package eda
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/go-lpc/mim/conddb"
+)
+
+// Driver is the interface implemented by EDA device backends.
+type Driver interface {
+	Boot([]conddb.RFM) error
+	Configure() error
+	ConfigureFromDB(ctx context.Context, db *conddb.DB, detID uint32) error
+	ConfigureDIF(addr string, dif uint8, asics []conddb.ASIC) error
+	AddDIFMonitor(addr string, dif uint8) error
+	Initialize() error
+	Start(run uint32) error
+	Stop() error
+	DrainFIFOs() (map[int]uint32, error)
+
+	Close() error
+}
+
+var _ Driver = (*Device)(nil)
+
+// Backend creates a Driver for the EDA board with the provided memory
+// device, output directory and shared memory directory.
+type Backend func(devmem, odir, devshm string, opts ...Option) (Driver, error)
+
+var backends = struct {
+	sync.RWMutex
+	db map[string]Backend
+}{
+	db: make(map[string]Backend),
+}
+
+// Register makes a device backend available under the provided name.
+// The Go implementation of this package is registered as "go".
+//
+// Register panics if it is called twice with the same name or if backend
+// is nil.
+func Register(name string, backend Backend) {
+	backends.Lock()
+	defer backends.Unlock()
+
+	if backend == nil {
+		panic("eda: nil backend " + name)
+	}
+	if _, dup := backends.db[name]; dup {
+		panic("eda: backend " + name + " already registered")
+	}
+	backends.db[name] = backend
+}
+
+// Backends returns the sorted list of the names of registered backends.
+func Backends() []string {
+	backends.RLock()
+	defer backends.RUnlock()
+
+	names := make([]string, 0, len(backends.db))
+	for name := range backends.db {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Open creates a device with the backend selected with WithBackend
+// ("go" by default).
+func Open(devmem, odir, devshm string, opts ...Option) (Driver, error) {
+	backend, err := lookupBackend(opts)
+	if err != nil {
+		return nil, err
+	}
+	return backend(devmem, odir, devshm, opts...)
+}
+
+// lookupBackend returns the backend selected by the provided options.
+func lookupBackend(opts []Option) (Backend, error) {
+	cfg := newConfig()
+	for _, opt := range opts {
+		opt(&cfg)
+	}
+
+	backends.RLock()
+	backend, ok := backends.db[cfg.backend]
+	backends.RUnlock()
+	if !ok {
+		return nil, fmt.Errorf(
+			"eda: unknown backend %q (registered: %s)",
+			cfg.backend, strings.Join(Backends(), ", "),
+		)
+	}
+	return backend, nil
+}
+
+// openDevice creates a device, for the server, with the selected backend.
+// Dump requests are rejected for backends that do not support them.
+func openDevice(devmem, odir, devshm string, opts ...Option) (device, error) {
+	drv, err := Open(devmem, odir, devshm, opts...)
+	if err != nil {
+		return nil, err
+	}
+	if dev, ok := drv.(device); ok {
+		return dev, nil
+	}
+	return noDump{drv}, nil
+}
+
+type noDump struct {
+	Driver
+}
+
+func (noDump) dump(w io.Writer, kind string, rfm int) error {
+	return fmt.Errorf("eda: dump-%s not supported by device backend", kind)
+}
+
+func init() {
+	Register("go", func(devmem, odir, devshm string, opts ...Option) (Driver, error) {
+		dev, err := newDevice(devmem, odir, devshm, opts...)
+		if err != nil {
+			return nil, err
+		}
+		return dev, nil
+	})
+}
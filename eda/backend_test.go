@@ -0,0 +1,88 @@
+// Copyright 2020 The go-lpc Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package eda
+
+import (
+	"io/ioutil"
+	"reflect"
+	"testing"
+)
+
+type stubDriver struct {
+	Driver
+	devmem string
+}
+
+func TestBackends(t *testing.T) {
+	Register("test-stub", func(devmem, odir, devshm string, opts ...Option) (Driver, error) {
+		return &stubDriver{devmem: devmem}, nil
+	})
+
+	if got, want := Backends(), []string{"go", "test-stub"}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("invalid backends: got=%q, want=%q", got, want)
+	}
+
+	drv, err := Open("board-1", "", "", WithBackend("test-stub"))
+	if err != nil {
+		t.Fatalf("could not open device: %+v", err)
+	}
+	if got, want := drv.(*stubDriver).devmem, "board-1"; got != want {
+		t.Fatalf("invalid device: got=%q, want=%q", got, want)
+	}
+
+	dev, err := openDevice("board-1", "", "", WithBackend("test-stub"))
+	if err != nil {
+		t.Fatalf("could not open device: %+v", err)
+	}
+	err = dev.dump(ioutil.Discard, "regs", 0)
+	if err == nil {
+		t.Fatalf("expected an error")
+	}
+	if got, want := err.Error(), "eda: dump-regs not supported by device backend"; got != want {
+		t.Fatalf("invalid error: got=%q, want=%q", got, want)
+	}
+
+	_, err = Open("board-1", "", "", WithBackend("c"))
+	if err == nil {
+		t.Fatalf("expected an error")
+	}
+	if got, want := err.Error(), `eda: unknown backend "c" (registered: go, test-stub)`; got != want {
+		t.Fatalf("invalid error: got=%q, want=%q", got, want)
+	}
+
+	_, err = newServer("localhost:0", []Board{{ID: 1}}, WithBackend("c"))
+	if err == nil {
+		t.Fatalf("expected an error")
+	}
+
+	for _, tc := range []struct {
+		name    string
+		backend Backend
+		want    string
+	}{
+		{
+			name:    "test-stub",
+			backend: func(devmem, odir, devshm string, opts ...Option) (Driver, error) { return nil, nil },
+			want:    "eda: backend test-stub already registered",
+		},
+		{
+			name: "nil",
+			want: "eda: nil backend nil",
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			defer func() {
+				err := recover()
+				if err == nil {
+					t.Fatalf("expected a panic")
+				}
+				if got, want := err.(string), tc.want; got != want {
+					t.Fatalf("invalid panic message: got=%q, want=%q", got, want)
+				}
+			}()
+			Register(tc.name, tc.backend)
+		})
+	}
+}
@@ -0,0 +1,107 @@
+// Copyright 2020 The go-lpc Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package eda
+
+import (
+	"fmt"
+	"sync"
+)
+
+// boardQueue serializes the requests sent to an EDA board by eda-svc,
+// and tracks the state of the run of that board.
+//
+// A request is executed once the previous ones are done, e.g. a stop
+// sent while the board is being configured is deferred until the end of
+// the configuration. The state of the board is checked when the request
+// is executed: requests that can not be executed in that state are
+// rejected with a stateError.
+type boardQueue struct {
+	mu    sync.Mutex // held while a request is executed
+	state runState   // state of the run of the board
+	gen   int        // number of devices of the board released, modified with the server mutex held
+}
+
+// runState describes the run of an EDA board, as seen by eda-svc.
+type runState uint8
+
+const (
+	runIdle   runState = iota // no run started, or run stopped
+	runActive                 // run in progress
+	runEnded                  // run ended on its own, not yet stopped
+)
+
+// active returns whether a run was started and not yet stopped, i.e.
+// whether it is in progress or ended on its own.
+func (q *boardQueue) active() bool {
+	return q.state != runIdle
+}
+
+// do executes f once the previous requests of the board are done.
+func (q *boardQueue) do(f func() error) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return f()
+}
+
+// stateError is returned to requests that can not be executed in the
+// current state of an EDA board.
+// A stateError for a board with a run in progress is a busy error.
+type stateError struct {
+	board int
+	op    string   // rejected operation, e.g. "drain FIFOs of"
+	state runState // state of the run of the board
+}
+
+func (e *stateError) Error() string {
+	state := "no run in progress"
+	switch e.state {
+	case runActive:
+		state = "run in progress"
+	case runEnded:
+		state = "run ended, waiting for stop"
+	}
+	return fmt.Sprintf("could not %s EDA board %d: %s", e.op, e.board, state)
+}
+
+// Is reports a run in progress as a busy error. A run that ended on its
+// own is not: the board stays unavailable until the run is stopped.
+func (e *stateError) Is(target error) bool {
+	return target == errBusy && e.state == runActive
+}
+
+// requireIdle returns a stateError for op if a run was started and not
+// yet stopped on the board.
+func (q *boardQueue) requireIdle(board int, op string) error {
+	if q.active() {
+		return &stateError{board: board, op: op, state: q.state}
+	}
+	return nil
+}
+
+// requireRun returns a stateError for op if no run was started on the
+// board, or if it was already stopped.
+func (q *boardQueue) requireRun(board int, op string) error {
+	if !q.active() {
+		return &stateError{board: board, op: op}
+	}
+	return nil
+}
+
+// hasEnded returns whether the run in progress on dev ended on its own,
+// once one of its limits was reached or because its acquisition failed.
+// Devices that do not report the end of their runs (see Device.Done)
+// never end them on their own.
+func hasEnded(dev device) bool {
+	d, ok := dev.(interface{ Done() <-chan struct{} })
+	if !ok {
+		return false
+	}
+	select {
+	case <-d.Done():
+		return true
+	default:
+		return false
+	}
+}
@@ -0,0 +1,152 @@
+// Copyright 2020 The go-lpc Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package eda
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"golang.org/x/sys/unix"
+)
+
+const (
+	busMem = "mem" // physical memory device, e.g. /dev/mem
+	busUIO = "uio" // UIO devices exposing the FPGA bridges
+
+	uioSysDir = "/sys/class/uio" // default sysfs directory of UIO devices
+	uioDevDir = "/dev"           // directory of UIO device files
+)
+
+// openBus opens the access to the FPGA buses: the provided memory device
+// for the mem bus, nothing for the UIO bus whose devices are opened
+// region by region.
+func (dev *Device) openBus(devmem string) error {
+	switch dev.cfg.bus.kind {
+	case busMem:
+		if dev.cfg.bus.path != "" {
+			devmem = dev.cfg.bus.path
+		}
+		mem, err := os.OpenFile(devmem, os.O_RDWR|os.O_SYNC, 0666)
+		if err != nil {
+			return fmt.Errorf("eda: could not open %q: %w", devmem, err)
+		}
+		dev.mem.fd = mem
+		return nil
+	case busUIO:
+		return nil
+	default:
+		return fmt.Errorf("eda: invalid bus %q", dev.cfg.bus.kind)
+	}
+}
+
+// closeBus closes the files opened to access the FPGA buses.
+func (dev *Device) closeBus() error {
+	var err error
+	if dev.mem.fd != nil {
+		err = dev.mem.fd.Close()
+		dev.mem.fd = nil
+	}
+	for _, f := range dev.mem.uio {
+		e := f.Close()
+		if e != nil && err == nil {
+			err = e
+		}
+	}
+	dev.mem.uio = nil
+	return err
+}
+
+// mapRegion memory-maps span bytes of the FPGA buses, starting at the
+// physical address base.
+func (dev *Device) mapRegion(base int64, span int) ([]byte, error) {
+	var (
+		fd  int
+		off = base
+	)
+	switch dev.cfg.bus.kind {
+	case busUIO:
+		sys := dev.cfg.bus.path
+		if sys == "" {
+			sys = uioSysDir
+		}
+		name, moff, err := uioRegion(sys, base, span)
+		if err != nil {
+			return nil, err
+		}
+		fname := filepath.Join(uioDevDir, name)
+		f, err := os.OpenFile(fname, os.O_RDWR|os.O_SYNC, 0666)
+		if err != nil {
+			return nil, fmt.Errorf("eda: could not open %q: %w", fname, err)
+		}
+		dev.mem.uio = append(dev.mem.uio, f)
+		fd, off = int(f.Fd()), moff
+	default:
+		fd = int(dev.mem.fd.Fd())
+	}
+
+	return unix.Mmap(fd, off, span, unix.PROT_READ|unix.PROT_WRITE, unix.MAP_SHARED)
+}
+
+// uioRegion locates the UIO device exposing the physical region of span
+// bytes starting at base, from the sysfs directory sys of UIO devices.
+// uioRegion returns the name of the UIO device and the mmap offset
+// selecting the memory map of the region.
+func uioRegion(sys string, base int64, span int) (string, int64, error) {
+	devs, err := filepath.Glob(filepath.Join(sys, "uio*"))
+	if err != nil {
+		return "", 0, fmt.Errorf("eda: could not list UIO devices: %w", err)
+	}
+	sort.Strings(devs)
+
+	for _, dir := range devs {
+		maps, err := filepath.Glob(filepath.Join(dir, "maps", "map*"))
+		if err != nil {
+			return "", 0, fmt.Errorf("eda: could not list UIO maps of %q: %w", dir, err)
+		}
+		for _, m := range maps {
+			addr, err := readSysHex(filepath.Join(m, "addr"))
+			if err != nil {
+				return "", 0, err
+			}
+			size, err := readSysHex(filepath.Join(m, "size"))
+			if err != nil {
+				return "", 0, err
+			}
+			if int64(addr) != base || int64(size) < int64(span) {
+				continue
+			}
+			idx, err := strconv.Atoi(strings.TrimPrefix(filepath.Base(m), "map"))
+			if err != nil {
+				return "", 0, fmt.Errorf("eda: invalid UIO map %q: %w", m, err)
+			}
+			// the N-th memory map of a UIO device is selected with an
+			// offset of N pages.
+			return filepath.Base(dir), int64(idx) * int64(os.Getpagesize()), nil
+		}
+	}
+
+	return "", 0, fmt.Errorf(
+		"eda: no UIO device for region addr=0x%x, size=0x%x in %q",
+		base, span, sys,
+	)
+}
+
+func readSysHex(fname string) (uint64, error) {
+	raw, err := ioutil.ReadFile(fname)
+	if err != nil {
+		return 0, fmt.Errorf("eda: could not read %q: %w", fname, err)
+	}
+	txt := strings.TrimPrefix(strings.TrimSpace(string(raw)), "0x")
+	v, err := strconv.ParseUint(txt, 16, 64)
+	if err != nil {
+		return 0, fmt.Errorf("eda: could not parse %q: %w", fname, err)
+	}
+	return v, nil
+}
@@ -0,0 +1,123 @@
+// Copyright 2020 The go-lpc Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package eda
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/go-lpc/mim/eda/internal/regs"
+)
+
+func TestUIORegion(t *testing.T) {
+	tmp, err := ioutil.TempDir("", "eda-uio-")
+	if err != nil {
+		t.Fatalf("could not create tmp dir: %+v", err)
+	}
+	defer os.RemoveAll(tmp)
+
+	for _, m := range []struct {
+		dev  string
+		idx  string
+		addr string
+		size string
+	}{
+		{"uio0", "map0", "0xff200000", "0x00100000"}, // lw-h2f
+		{"uio1", "map0", "0xff240000", "0x00001000"},
+		{"uio1", "map1", "0xc0000000", "0x00100000"}, // h2f
+		{"uio2", "map0", "0xc0000000", "0x00001000"}, // h2f, too small
+	} {
+		dir := filepath.Join(tmp, m.dev, "maps", m.idx)
+		err := os.MkdirAll(dir, 0755)
+		if err != nil {
+			t.Fatalf("could not create %q: %+v", dir, err)
+		}
+		for _, f := range []struct{ name, v string }{{"addr", m.addr}, {"size", m.size}} {
+			err = ioutil.WriteFile(filepath.Join(dir, f.name), []byte(f.v+"\n"), 0644)
+			if err != nil {
+				t.Fatalf("could not create %s file: %+v", f.name, err)
+			}
+		}
+	}
+
+	page := int64(os.Getpagesize())
+	for _, tc := range []struct {
+		name string
+		base int64
+		span int
+		dev  string
+		off  int64
+		err  bool
+	}{
+		{name: "lw-h2f", base: regs.LW_H2F_BASE, span: regs.LW_H2F_SPAN, dev: "uio0", off: 0},
+		{name: "h2f", base: regs.H2F_BASE, span: regs.H2F_SPAN, dev: "uio1", off: page},
+		{name: "missing", base: 0x1000, span: 0x1000, err: true},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			dev, off, err := uioRegion(tmp, tc.base, tc.span)
+			switch {
+			case err != nil && tc.err:
+				return
+			case err != nil:
+				t.Fatalf("could not locate UIO region: %+v", err)
+			case tc.err:
+				t.Fatalf("expected an error")
+			}
+			if got, want := dev, tc.dev; got != want {
+				t.Fatalf("invalid UIO device: got=%q, want=%q", got, want)
+			}
+			if got, want := off, tc.off; got != want {
+				t.Fatalf("invalid UIO offset: got=%d, want=%d", got, want)
+			}
+		})
+	}
+}
+
+func TestOpenBus(t *testing.T) {
+	f, err := ioutil.TempFile("", "eda-mem-")
+	if err != nil {
+		t.Fatalf("could not create memory file: %+v", err)
+	}
+	f.Close()
+	defer os.Remove(f.Name())
+
+	for _, tc := range []struct {
+		name string
+		opt  Option
+		mem  string
+		err  string
+	}{
+		{name: "default", mem: f.Name()},
+		{name: "mem-path", opt: WithBus("mem", f.Name()), mem: "/dev/not-there"},
+		{name: "uio", opt: WithBus("uio", "")},
+		{name: "invalid", opt: WithBus("pci", ""), err: `eda: invalid bus "pci"`},
+		{name: "no-mem", mem: "/dev/not-there", err: `eda: could not open "/dev/not-there": open /dev/not-there: no such file or directory`},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			dev := &Device{cfg: newConfig()}
+			if tc.opt != nil {
+				tc.opt(&dev.cfg)
+			}
+			err := dev.openBus(tc.mem)
+			switch {
+			case err != nil && tc.err != "":
+				if got, want := err.Error(), tc.err; got != want {
+					t.Fatalf("invalid error:\ngot= %s\nwant=%s", got, want)
+				}
+				return
+			case err != nil:
+				t.Fatalf("could not open bus: %+v", err)
+			case tc.err != "":
+				t.Fatalf("expected an error")
+			}
+			err = dev.closeBus()
+			if err != nil {
+				t.Fatalf("could not close bus: %+v", err)
+			}
+		})
+	}
+}
@@ -0,0 +1,55 @@
+// Copyright 2020 The go-lpc Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package eda
+
+import (
+	"errors"
+	"fmt"
+)
+
+// ErrUnsupported is returned by operations needing a hardware feature the
+// device does not provide (see Device.Capabilities).
+var ErrUnsupported = errors.New("eda: operation not supported")
+
+// Capabilities describes the hardware features of an EDA board, as probed
+// when the device is opened.
+type Capabilities struct {
+	LwH2F bool // lightweight HPS-to-FPGA bus: PIO registers, slow control
+	H2F   bool // HPS-to-FPGA bus: DAQ FIFOs
+}
+
+// Capabilities returns the hardware features available on the device.
+//
+// The lightweight HPS-to-FPGA bus is required to open a device.
+// The HPS-to-FPGA bus may be missing (e.g. with some kernel
+// configurations): register access and slow control still work, but
+// reading out the DAQ FIFOs fails with ErrUnsupported.
+func (dev *Device) Capabilities() Capabilities {
+	return Capabilities{
+		LwH2F: dev.mem.lw != nil,
+		H2F:   dev.mem.h2f != nil,
+	}
+}
+
+// probeH2F maps the HPS-to-FPGA bus, if available.
+func (dev *Device) probeH2F() {
+	err := dev.mmapH2F()
+	if err != nil {
+		dev.msg.Printf("HPS-to-FPGA bus not available, DAQ FIFOs disabled: %+v", err)
+		if dev.mem.h2f != nil {
+			_ = dev.mem.h2f.Close()
+			dev.mem.h2f = nil
+		}
+		dev.err = nil
+	}
+}
+
+// needH2F returns ErrUnsupported if the HPS-to-FPGA bus is not available.
+func (dev *Device) needH2F(op string) error {
+	if dev.mem.h2f == nil {
+		return fmt.Errorf("%w: %s needs the HPS-to-FPGA bus", ErrUnsupported, op)
+	}
+	return nil
+}
@@ -5,11 +5,15 @@
 package eda
 
 import (
+	"crypto/tls"
 	"fmt"
+	"log"
+	"os"
 	"path/filepath"
 	"time"
 
 	"github.com/go-lpc/mim/conddb"
+	"github.com/go-lpc/mim/obs"
 )
 
 type Option func(*config)
@@ -23,6 +27,7 @@ func WithThreshold(v uint32) Option {
 func WithRFMMask(v uint32) Option {
 	return func(cfg *config) {
 		cfg.daq.rfm = v
+		cfg.daq.mask = v
 	}
 }
 
@@ -38,18 +43,51 @@ func WithCShaper(v uint32) Option {
 	}
 }
 
+// WithBus selects how the FPGA buses of the board are accessed:
+//   - "mem": by memory-mapping the physical memory device (default), path
+//     overriding the memory device provided to the constructor if not empty,
+//   - "uio": by memory-mapping the UIO devices exposing the FPGA bridges
+//     (as declared in the device tree), for kernels without /dev/mem
+//     access. path is the sysfs directory of UIO devices ("": /sys/class/uio).
+//     Devices are selected by the physical address of their memory map.
+func WithBus(kind, path string) Option {
+	return func(cfg *config) {
+		cfg.bus.kind = kind
+		cfg.bus.path = path
+	}
+}
+
 func WithDevSHM(dir string) Option {
 	return func(cfg *config) {
 		cfg.run.dir = dir
 	}
 }
 
+// WithCtlAddr sets the address of the eda-ctl status port, notified once
+// the device is initialized (see Heartbeat).
 func WithCtlAddr(addr string) Option {
 	return func(cfg *config) {
 		cfg.ctl.addr = addr
 	}
 }
 
+// WithHeartbeat sets the interval between heartbeats sent to eda-ctl
+// (see WithCtlAddr). A zero interval disables heartbeats: only the
+// "eda-ready" message is sent.
+func WithHeartbeat(freq time.Duration) Option {
+	return func(cfg *config) {
+		cfg.ctl.beat = freq
+	}
+}
+
+// WithBackend selects the registered backend implementing the devices
+// created with Open (see Register).
+func WithBackend(name string) Option {
+	return func(cfg *config) {
+		cfg.backend = name
+	}
+}
+
 func WithConfigDir(dir string) Option {
 	return func(cfg *config) {
 		if dir == "" {
@@ -60,6 +98,7 @@ func WithConfigDir(dir string) Option {
 		cfg.daq.fname = filepath.Join(dir, "dac_floor_4rfm.csv")
 		cfg.preamp.fname = filepath.Join(dir, "pa_gain_4rfm.csv")
 		cfg.mask.fname = filepath.Join(dir, "mask_4rfm.csv")
+		cfg.ctest.fname = filepath.Join(dir, "ctest_4rfm.csv")
 	}
 }
 
@@ -69,16 +108,486 @@ func WithDAQMode(mode string) Option {
 	}
 }
 
+// WithResetBCID sets how long the start of a run in DCC mode waits for the
+// reset-BCID DCC command, before starting the run without a BCID reset.
+// A zero timeout waits until the command is received or the run is
+// stopped (see Device.Stop).
 func WithResetBCID(timeout time.Duration) Option {
 	return func(cfg *config) {
 		cfg.daq.timeout = timeout
 	}
 }
 
+// WithResetBCIDRetries sets the number of additional attempts at waiting
+// for the reset-BCID DCC command, after the first attempt timed out.
+func WithResetBCIDRetries(n int) Option {
+	return func(cfg *config) {
+		cfg.daq.retries = n
+	}
+}
+
+// WithDAQBufferSize sets the size of the chunks making up the per-RFM
+// buffer holding the DIF data of a readout cycle, and the maximum size
+// that buffer may grow to.
+// Data exceeding the maximum size is dropped and accounted for as an
+// overflow.
+func WithDAQBufferSize(size, max int) Option {
+	return func(cfg *config) {
+		cfg.daq.bufsz = size
+		cfg.daq.bufmax = max
+	}
+}
+
+// WithEDAID selects the EDA board, within the detector, whose RFMs
+// should be configured by Device.ConfigureFromDB.
+// A negative value selects all the EDA RFMs of the detector.
+func WithEDAID(id int) Option {
+	return func(cfg *config) {
+		cfg.daq.eda = id
+	}
+}
+
+// WithSinkHost sets the host that receives DIF data when the device
+// is configured with Device.ConfigureFromDB.
+// Each DIF is sent to host:10000+dif-id.
+func WithSinkHost(host string) Option {
+	return func(cfg *config) {
+		cfg.daq.host = host
+	}
+}
+
+// WithTimeIndex enables the writing of a time index file alongside the
+// run files, associating each DIF block (DIF ID, trigger counter and
+// absolute BCID) with the host wall-clock time at readout.
+func WithTimeIndex(v bool) Option {
+	return func(cfg *config) {
+		cfg.daq.tindex = v
+	}
+}
+
+// WithDialTimeout sets the timeout for connecting to a DIF data sink.
+// When the sink host name resolves to multiple addresses, they are tried
+// in turn within that timeout.
+func WithDialTimeout(timeout time.Duration) Option {
+	return func(cfg *config) {
+		cfg.daq.dial.timeout = timeout
+	}
+}
+
+// WithSinkStats polls the kernel statistics of the TCP connections to
+// the DIF data sinks every period during runs, and records them in the
+// metrics of the device (see WithMetrics), so stalls of the network can
+// be told apart from stalls of the FPGA.
+// Socket statistics are only available on Linux.
+// A zero period disables the polling.
+func WithSinkStats(period time.Duration) Option {
+	return func(cfg *config) {
+		cfg.daq.stats = period
+	}
+}
+
+// WithSinkTLS enables TLS for the connections to the DIF data sinks
+// running on the provided hosts, or to all the sinks if no host is given.
+func WithSinkTLS(tlsCfg *tls.Config, hosts ...string) Option {
+	return func(cfg *config) {
+		cfg.daq.dial.tls = tlsCfg
+		cfg.daq.dial.hosts = nil
+		if len(hosts) == 0 {
+			return
+		}
+		cfg.daq.dial.hosts = make(map[string]struct{}, len(hosts))
+		for _, host := range hosts {
+			cfg.daq.dial.hosts[host] = struct{}{}
+		}
+	}
+}
+
+// WithNbLines sets the number of analog lines declared in the "nb-lines"
+// field of the DIF headers sent to the DIF data sinks (at most 15).
+// EDA boards have no analog readout: the default is zero.
+// Devices can not be created with more than 15 analog lines.
+func WithNbLines(n uint8) Option {
+	return func(cfg *config) {
+		cfg.daq.nlines = n
+	}
+}
+
+// WithTriggerThreshold selects the discriminator (0 or 1) of the hardrocs
+// whose hits trigger the readout.
+// The selected threshold is declared in the thresholds records written
+// with WithDualThreshold.
+func WithTriggerThreshold(thr uint8) Option {
+	return func(cfg *config) {
+		cfg.daq.thresh.trig = thr
+	}
+}
+
+// WithDualThreshold records the hit counters of both discriminators, along
+// with the trigger threshold, in a thresholds record prepended to each DIF
+// block (see eformat.Thresholds).
+// The DIF headers are left untouched.
+func WithDualThreshold(v bool) Option {
+	return func(cfg *config) {
+		cfg.daq.thresh.dual = v
+	}
+}
+
+// TriggerCounter selects the counter recorded in the trigger counter fields
+// (DTC, ATC and GTC) of DIF headers.
+type TriggerCounter uint8
+
+const (
+	CycleCounter TriggerCounter = iota // acquisition cycle number of the run, starting at 0 (see Cycle.Num)
+	FPGACounter                        // trigger counter of the FPGA, reset at the start of the run
+)
+
+var trigCounterNames = [...]string{
+	CycleCounter: "cycle",
+	FPGACounter:  "fpga",
+}
+
+func (c TriggerCounter) String() string {
+	if int(c) < len(trigCounterNames) {
+		return trigCounterNames[c]
+	}
+	return fmt.Sprintf("TriggerCounter(%d)", uint8(c))
+}
+
+// Set sets the trigger counter from its name ("cycle" or "fpga").
+// Set implements flag.Value.
+func (c *TriggerCounter) Set(name string) error {
+	for i, v := range trigCounterNames {
+		if v == name {
+			*c = TriggerCounter(i)
+			return nil
+		}
+	}
+	return fmt.Errorf("eda: invalid trigger counter %q (valid: cycle, fpga)", name)
+}
+
+// WithTriggerCounter selects the counter recorded in the DTC, ATC and GTC
+// fields of DIF headers (default: CycleCounter).
+func WithTriggerCounter(c TriggerCounter) Option {
+	return func(cfg *config) {
+		cfg.daq.cnt.trig = c
+	}
+}
+
+// WithLegacyCounters selects the historical layout of the counters of DIF
+// headers, for offline code relying on it: the acquisition cycle number,
+// starting at 1, in the DTC and GTC fields, and the threshold-0 hit counter
+// in the ATC field.
+// WithLegacyCounters takes precedence over WithTriggerCounter.
+func WithLegacyCounters(v bool) Option {
+	return func(cfg *config) {
+		cfg.daq.cnt.legacy = v
+	}
+}
+
+// WithRunSummary sends a summary of the run (see RunSummary) to each DIF
+// data sink when the run is stopped, or fails, before the connection to
+// the sink is closed.
+// Sinks must understand the "EOR\0" message: it is not sent by default.
+func WithRunSummary(v bool) Option {
+	return func(cfg *config) {
+		cfg.daq.summary = v
+	}
+}
+
+// WithProvenance appends a provenance trailer (EDA board ID, RFM slot,
+// FPGA firmware and software versions) after each DIF block, so the data
+// can be traced back to the board that produced it.
+func WithProvenance(v bool) Option {
+	return func(cfg *config) {
+		cfg.daq.prov = v
+	}
+}
+
+// WithNoisePrescale configures the noise trigger mode to only keep and
+// send the data of 1 acquisition cycle out of n.
+func WithNoisePrescale(n int) Option {
+	return func(cfg *config) {
+		cfg.daq.noise.prescale = n
+	}
+}
+
+// WithEmptyBlockPrescale configures the DAQ pipeline to only send 1 DIF
+// block without hardroc frames out of n, per RFM: n=0 drops all of them.
+// Empty blocks are dropped right after the DIF blocks of a cycle are
+// assembled, once the time index of the cycle was written, and before
+// the user framers (see WithFramer).
+// The default (n=1) sends all of them.
+func WithEmptyBlockPrescale(n int) Option {
+	return func(cfg *config) {
+		cfg.daq.empty.filter = n != 1
+		cfg.daq.empty.prescale = n
+	}
+}
+
+// WithNoiseMaxRate limits the rate (in Hz) at which acquisition cycles
+// are started in the noise trigger mode.
+// A zero rate disables rate limiting.
+func WithNoiseMaxRate(hz float64) Option {
+	return func(cfg *config) {
+		cfg.daq.noise.rate = hz
+	}
+}
+
+// WithTestPattern selects the test pattern DAQ mode: the DAQ FIFOs are
+// bypassed and each active RFM produces, at most hz times per second, a
+// readout cycle of the provided number of hardroc frames holding a known
+// counter pattern.
+// The synthesized data goes through the regular DAQ pipeline and DIF data
+// sinks, so the software and network chain can be validated without
+// hardrocs attached.
+// A zero rate disables rate limiting.
+func WithTestPattern(frames int, hz float64) Option {
+	return func(cfg *config) {
+		cfg.daq.mode = "pattern"
+		cfg.daq.pattern.frames = frames
+		cfg.daq.pattern.rate = hz
+	}
+}
+
+// WithFramer appends a stage to the DAQ pipeline, processing the DIF
+// blocks of each acquisition cycle before they are sent.
+// Framers run in the order they were added.
+func WithFramer(f Framer) Option {
+	return func(cfg *config) {
+		cfg.daq.framers = append(cfg.daq.framers, f)
+	}
+}
+
+// WithSender adds a sender to the DAQ pipeline, receiving the DIF blocks
+// of each acquisition cycle alongside the DIF data sinks.
+func WithSender(s Sender) Option {
+	return func(cfg *config) {
+		cfg.daq.senders = append(cfg.daq.senders, s)
+	}
+}
+
+// WithCompression compresses the raw data files written locally by the
+// device with the provided algorithm and compression level.
+// The only supported algorithm is "zstd": files are then written as
+// seekable zstd streams, with a ".zst" extension.
+// An empty algorithm disables compression.
+func WithCompression(algo string, level int) Option {
+	return func(cfg *config) {
+		cfg.run.compress.algo = algo
+		cfg.run.compress.level = level
+	}
+}
+
+// WithFirmwareCheck enables the check of the FPGA firmware version when
+// the device is created.
+// The firmware version register is not implemented by the released
+// firmwares (which read as v0.0), so the check is disabled by default.
+func WithFirmwareCheck(v bool) Option {
+	return func(cfg *config) {
+		cfg.fw.check = v
+	}
+}
+
+// WithForceFirmware allows the device to drive FPGA firmwares unknown to
+// this driver.
+// Unknown firmwares are otherwise refused when the device is created,
+// if the firmware check is enabled (see WithFirmwareCheck).
+func WithForceFirmware(v bool) Option {
+	return func(cfg *config) {
+		cfg.fw.force = v
+	}
+}
+
+// WithRunDB records the metadata of each run (start and stop times,
+// settings and files written by the device) in the run bookkeeping
+// tables of the provided MIM database.
+// Bookkeeping errors are logged and do not stop the acquisition.
+func WithRunDB(db *conddb.DB) Option {
+	return func(cfg *config) {
+		if db == nil {
+			cfg.run.db = nil
+			return
+		}
+		cfg.run.db = db
+	}
+}
+
+// WithMinFreeSpace sets the minimum free space, in bytes, of the
+// filesystems holding the output and shared memory directories.
+//
+// Runs are not started when a filesystem has less free space. During a
+// run, file writes (e.g. the time index) are paused while free space is
+// below the threshold and resumed once space is available again: DIF
+// data is still sent to the sinks.
+// A zero value disables the check.
+func WithMinFreeSpace(n uint64) Option {
+	return func(cfg *config) {
+		cfg.run.minFree = n
+	}
+}
+
+// WithMaxRunDuration stops runs once they lasted for the provided
+// duration, as with Stop.
+// A zero duration disables the limit.
+func WithMaxRunDuration(d time.Duration) Option {
+	return func(cfg *config) {
+		cfg.run.max.dur = d
+	}
+}
+
+// WithMaxRunCycles stops runs after n acquisition cycles, as with Stop.
+// A zero value disables the limit.
+func WithMaxRunCycles(n int64) Option {
+	return func(cfg *config) {
+		cfg.run.max.cycles = n
+	}
+}
+
+// WithReadoutBatching enables the batching of acquisition cycles in DCC
+// mode.
+// When the readout of the next cycle is already complete once a cycle was
+// read out of the DAQ FIFOs, that cycle is read as well, up to max cycles,
+// before the DIF data of the batch is framed and sent downstream in a single
+// message per RFM.
+// A batch is closed once its first cycle was read more than budget ago, or
+// when the DIF data buffers could not hold another cycle as large as the
+// largest cycle of the batch.
+// A max value of 0 or 1 disables batching.
+func WithReadoutBatching(max int, budget time.Duration) Option {
+	return func(cfg *config) {
+		cfg.daq.batch.max = max
+		cfg.daq.batch.budget = budget
+	}
+}
+
+// WithClockCheck enables the check of the synchronization of the clock of
+// the SoC at the start of runs.
+// The clock offset is retrieved from src, either "chrony" for the local
+// chrony daemon or the [host[:port]] of a NTP server, and recorded in the
+// run bookkeeping database.
+// Offsets larger than max are logged, or prevent the run from starting
+// when strict is set.
+// An empty source disables the check.
+func WithClockCheck(src string, max time.Duration, strict bool) Option {
+	return func(cfg *config) {
+		cfg.clock.src = src
+		cfg.clock.max = max
+		cfg.clock.strict = strict
+	}
+}
+
+// SCTiming describes the timing of the slow-control serializer, used to
+// send configurations to the hardrocs.
+// Zero fields select the default values.
+type SCTiming struct {
+	Pulse   time.Duration // width of the slow-control reset pulse (default: 1µs)
+	Poll    time.Duration // interval between polls of the SC-done flag (default: 10µs)
+	Timeout time.Duration // maximum time waiting for the SC-done flag (default: 1s)
+}
+
+// WithSCTiming sets the timing of the slow-control serializer.
+// Test stands with long cables or slow hardroc clocks may need a longer
+// reset pulse and timeout than the default ones.
+func WithSCTiming(t SCTiming) Option {
+	return func(cfg *config) {
+		if t.Pulse > 0 {
+			cfg.sc.timing.Pulse = t.Pulse
+		}
+		if t.Poll > 0 {
+			cfg.sc.timing.Poll = t.Poll
+		}
+		if t.Timeout > 0 {
+			cfg.sc.timing.Timeout = t.Timeout
+		}
+	}
+}
+
+// WithSCRetries sets the number of additional attempts at configuring the
+// hardrocs of an RFM, after the slow-control serializer timed out or its
+// loop-back check failed.
+func WithSCRetries(n int) Option {
+	return func(cfg *config) {
+		cfg.sc.retries = n
+	}
+}
+
+// WithFPGAWatch enables the check of the FPGA during runs, at most every
+// period: a lost PLL lock, or a register map reading back all ones (e.g.
+// after the FPGA was reprogrammed), stops the acquisition.
+// The FPGA and the hardrocs are then re-configured from the configuration
+// of the run, and the acquisition resumes, at most retries times per run.
+// A zero period disables the check.
+func WithFPGAWatch(period time.Duration, retries int) Option {
+	return func(cfg *config) {
+		cfg.daq.fpga.period = period
+		cfg.daq.fpga.retries = retries
+	}
+}
+
+// WithCPUAffinity configures the CPUs used by the DAQ pipeline during runs:
+// the goroutine reading the RFM FIFOs and the goroutines of the senders
+// are locked to their OS threads, which are bound to the requested CPUs.
+// Binding failures are logged and do not stop the run.
+// In stand-alone noise runs (see RunStandalone), the RFM FIFOs are read
+// and the data written to the output file by a single goroutine, bound
+// to the CPU of the reader.
+// By default, the Go scheduler is left alone.
+func WithCPUAffinity(aff CPUAffinity) Option {
+	return func(cfg *config) {
+		cfg.daq.cpu = &aff
+	}
+}
+
+// WithLogger configures a device, or an eda-svc server, to write its log
+// messages to l instead of the standard output.
+func WithLogger(l obs.Logger) Option {
+	return func(cfg *config) {
+		cfg.log = l
+	}
+}
+
+// WithMetrics configures a device to record its metrics in m:
+//   - eda.runs: counter of started runs,
+//   - eda.run: gauge of the current run number,
+//   - eda.cycles: counter of acquisition cycles,
+//   - eda.bytes: counter of DIF data bytes sent to the sinks,
+//   - eda.empty.dropped: counter of empty DIF blocks not sent to the sinks
+//     (see WithEmptyBlockPrescale),
+//   - eda.overflows: counter of acquisition cycles whose DIF data did not
+//     fit in the DIF data buffer of an RFM (see WithDAQBufferSize),
+//   - eda.sink.N.rtt, eda.sink.N.retrans, eda.sink.N.sendq: gauges of the
+//     smoothed round-trip time (in seconds), of the number of retransmitted
+//     segments and of the number of unacknowledged bytes of the connection
+//     to the DIF data sink of RFM slot N (see WithSinkStats),
+//   - eda.fpga.reconfigs: counter of FPGA re-configurations (see WithFPGAWatch),
+//   - eda.disk.low: gauge set to 1 while file writes are paused for lack
+//     of disk space (see WithMinFreeSpace), and to 0 otherwise.
+func WithMetrics(m obs.Metrics) Option {
+	return func(cfg *config) {
+		cfg.metrics = m
+	}
+}
+
 type config struct {
 	mode string // csv or db
 	ctl  struct {
-		addr string // addr+port to eda-ctl
+		addr string        // addr+port to eda-ctl
+		beat time.Duration // interval between heartbeats (0: none)
+	}
+
+	backend string // name of the device backend (see Register)
+
+	bus struct {
+		kind string // mem or uio
+		path string // memory device (mem) or sysfs directory of UIO devices (uio)
+	}
+
+	log     obs.Logger  // logger of the device (nil: standard output)
+	metrics obs.Metrics // metrics of the device (nil: none)
+
+	fw struct {
+		check bool // whether to read and check the firmware version
+		force bool // whether to drive unknown firmwares
 	}
 
 	hr struct {
@@ -98,10 +607,71 @@ type config struct {
 		floor [nRFM * nHR * 3]uint32
 		delta uint32 // delta threshold
 		rfm   uint32 // RFM ON mask
+		mask  uint32 // RFM ON mask requested with WithRFMMask (not reset by Boot)
 
-		addrs []string // [addr:port]s for sending DIF data
+		addrs map[int]string // slot -> [addr:port] for sending DIF data (non-nil once booted)
+		host  string         // host of DIF data sinks (for conddb configuration)
+		eda   int            // EDA board ID within detector (for conddb configuration)
+
+		mons map[int][]string // slot -> [addr:port] of monitor sinks
 
 		timeout time.Duration // timeout for reset-BCID
+		retries int           // number of retries for reset-BCID
+
+		fpga struct {
+			period  time.Duration // interval between FPGA checks during a run (0: no check)
+			retries int           // number of FPGA re-configurations per run
+		}
+
+		dial struct {
+			timeout time.Duration       // timeout for dialing DIF data sinks
+			tls     *tls.Config         // TLS configuration for DIF data sinks
+			hosts   map[string]struct{} // hosts of DIF data sinks using TLS (nil: all)
+		}
+		stats time.Duration // interval between polls of the sink socket statistics (0: none)
+
+		bufsz  int // chunk size of DIF data buffers
+		bufmax int // maximum size of DIF data buffers
+
+		tindex  bool  // whether to write a wall-clock time index of DIF blocks
+		nlines  uint8 // number of analog lines declared in DIF headers
+		prov    bool  // whether to append provenance trailers to DIF blocks
+		summary bool  // whether to send end-of-run summaries to the DIF data sinks
+
+		thresh struct {
+			trig uint8 // discriminator triggering the readout (0 or 1)
+			dual bool  // whether to record the hit counters of both discriminators
+		}
+
+		cnt struct {
+			trig   TriggerCounter // counter recorded in the DTC, ATC and GTC fields
+			legacy bool           // whether to use the historical layout of counters
+		}
+
+		noise struct {
+			prescale int     // keep 1 cycle out of prescale
+			rate     float64 // maximum cycle rate (Hz)
+		}
+
+		pattern struct {
+			frames int     // number of hardroc frames per cycle and RFM
+			rate   float64 // maximum cycle rate (Hz)
+		}
+
+		empty struct {
+			filter   bool // whether empty DIF blocks are filtered
+			prescale int  // send 1 empty DIF block out of prescale (0: none)
+		}
+
+		batch struct {
+			max    int           // maximum number of cycles per readout batch (<=1: no batching)
+			budget time.Duration // maximum latency of the first cycle of a batch
+		}
+
+		cpu *CPUAffinity // CPU affinity of the DAQ pipeline (nil: none)
+
+		framers []Framer // user stages of the DAQ pipeline
+		senders []Sender // user senders of the DAQ pipeline
 	}
 
 	preamp struct {
@@ -109,36 +679,111 @@ type config struct {
 		gains [nRFM * nHR * nChans]uint32
 	}
 
+	sc struct {
+		timing  SCTiming // timing of the slow-control serializer
+		retries int      // number of retries of a failed slow-control
+	}
+
 	mask struct {
 		fname string
 		table [nRFM * nHR * nChans]uint32
 	}
 
+	ctest struct {
+		fname  string // optional: Ctest switches are left untouched if empty
+		table  [nRFM * nHR * nChans]uint32
+		loaded bool // whether table was read from fname
+	}
+
 	run struct {
 		dir string
+		db  runDB // run bookkeeping database (nil: none)
+
+		minFree uint64 // minimum free space of output filesystems, in bytes (0: no check)
+
+		max runLimits // limits after which runs are stopped
+
+		compress struct {
+			algo  string // compression algorithm of raw files ("": none)
+			level int    // compression level
+		}
+	}
+
+	clock struct {
+		src    string        // source of the clock synchronization check ("": none)
+		max    time.Duration // maximum offset of the clock
+		strict bool          // whether to refuse to start runs with an unsynchronized clock
 	}
 }
 
 func newConfig() config {
 	cfg := config{
-		mode: "db",
+		mode:    "db",
+		backend: "go",
 	}
+	cfg.bus.kind = busMem
 	cfg.hr.db = newDbConfig()
 	cfg.hr.cshaper = 3
 	cfg.daq.mode = "dcc"
+	cfg.daq.host = "localhost"
+	cfg.daq.eda = -1
+	cfg.daq.bufsz = daqBufferSize
+	cfg.daq.bufmax = 4 * daqBufferSize
+	cfg.daq.dial.timeout = 10 * time.Second
 	cfg.hr.data = cfg.hr.buf[4:]
+	cfg.sc.timing = SCTiming{
+		Pulse:   1 * time.Microsecond,
+		Poll:    10 * time.Microsecond,
+		Timeout: 1 * time.Second,
+	}
 	return cfg
 }
 
+// logger returns the logger of a device, or of a server, with the
+// provided prefix.
+func (cfg *config) logger(prefix string) *log.Logger {
+	if cfg.log == nil {
+		return log.New(os.Stdout, prefix, 0)
+	}
+	return obs.StdLogger(cfg.log, prefix)
+}
+
+// check checks the values set by the options of a device.
+func (cfg *config) check() error {
+	// the nb-lines field is the upper nibble of a byte.
+	if cfg.daq.nlines > 0xf {
+		return fmt.Errorf("eda: invalid number of analog lines %d (valid: 0-15)", cfg.daq.nlines)
+	}
+	return nil
+}
+
+// count adds delta to the counter name of the metrics of the device.
+func (dev *Device) count(name string, delta int64) {
+	if dev.cfg.metrics == nil {
+		return
+	}
+	dev.cfg.metrics.Add(name, delta)
+}
+
+// gauge sets the gauge name of the metrics of the device to v.
+func (dev *Device) gauge(name string, v float64) {
+	if dev.cfg.metrics == nil {
+		return
+	}
+	dev.cfg.metrics.Set(name, v)
+}
+
 // dbConfig holds the configuration from the TMVDb
 // for each of the RFMs.
 type dbConfig struct {
-	asics map[uint8][]conddb.ASIC // rfm-id -> ASICs configuration
+	asics   map[uint8][]conddb.ASIC // rfm-id -> ASICs configuration
+	rshaper map[uint8]uint32        // rfm-id -> resistance shaper, from DAQ state
 }
 
 func newDbConfig() dbConfig {
 	return dbConfig{
-		asics: make(map[uint8][]conddb.ASIC, nRFM),
+		asics:   make(map[uint8][]conddb.ASIC, nRFM),
+		rshaper: make(map[uint8]uint32, nRFM),
 	}
 }
 
@@ -225,3 +225,36 @@ func testCfgWithCSV(dev *Device, thresh, rshaper uint32, rfms []int) error {
 	}
 	return nil
 }
+
+func TestConfigCheck(t *testing.T) {
+	for _, tc := range []struct {
+		nlines uint8
+		err    string
+	}{
+		{nlines: 0},
+		{nlines: 15},
+		{nlines: 16, err: "eda: invalid number of analog lines 16 (valid: 0-15)"},
+	} {
+		t.Run(strconv.Itoa(int(tc.nlines)), func(t *testing.T) {
+			cfg := newConfig()
+			WithNbLines(tc.nlines)(&cfg)
+
+			err := cfg.check()
+			switch {
+			case err != nil && tc.err != "":
+				if got, want := err.Error(), tc.err; got != want {
+					t.Fatalf("invalid error:\ngot= %v\nwant=%v", got, want)
+				}
+			case err != nil:
+				t.Fatalf("could not check config: %+v", err)
+			case tc.err != "":
+				t.Fatalf("expected an error")
+			}
+		})
+	}
+
+	_, err := newDevice("/dev/null", t.TempDir(), t.TempDir(), WithNbLines(16))
+	if err == nil {
+		t.Fatalf("expected an error creating a device with 16 analog lines")
+	}
+}
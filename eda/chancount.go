@@ -0,0 +1,117 @@
+// Copyright 2020 The go-lpc Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package eda
+
+import (
+	"fmt"
+	"time"
+)
+
+// CountChannel counts the hits of a single hardroc channel: the channel ch
+// of the hardroc hr of the RFM slot rfm is routed to the hit counters of
+// that slot, and the hits above the first threshold are counted for the
+// duration dur.
+// The read-registers of the hardrocs are reset afterwards.
+//
+// The hardrocs of the RFM must have been configured (see Initialize),
+// and no run may be in progress.
+func (dev *Device) CountChannel(rfm, hr, ch int, dur time.Duration) (uint32, error) {
+	err := checkChannel(rfm, hr, ch)
+	if err != nil {
+		return 0, err
+	}
+
+	n, err := dev.countChannel(rfm, hr, ch, dur)
+	errRR := dev.hrscSend(rfm, dev.hrscResetReadRegisters)
+	if err != nil {
+		return n, err
+	}
+	if errRR != nil {
+		return n, fmt.Errorf("eda: could not reset read-registers (rfm=%d): %w", rfm, errRR)
+	}
+	return n, nil
+}
+
+// CountChannels counts the hits of all the channels of the RFM slot rfm,
+// one channel after the other, as with CountChannel.
+// The returned counts are indexed by hardroc and channel.
+//
+// A scan of an RFM lasts nHR*nChans=512 times dur.
+func (dev *Device) CountChannels(rfm int, dur time.Duration) ([][]uint32, error) {
+	err := checkChannel(rfm, 0, 0)
+	if err != nil {
+		return nil, err
+	}
+
+	cnts := make([][]uint32, nHR)
+	for hr := range cnts {
+		cnts[hr] = make([]uint32, nChans)
+		for ch := range cnts[hr] {
+			cnts[hr][ch], err = dev.countChannel(rfm, hr, ch, dur)
+			if err != nil {
+				break
+			}
+		}
+		if err != nil {
+			break
+		}
+	}
+
+	errRR := dev.hrscSend(rfm, dev.hrscResetReadRegisters)
+	if err != nil {
+		return cnts, err
+	}
+	if errRR != nil {
+		return cnts, fmt.Errorf("eda: could not reset read-registers (rfm=%d): %w", rfm, errRR)
+	}
+	return cnts, nil
+}
+
+func (dev *Device) countChannel(rfm, hr, ch int, dur time.Duration) (uint32, error) {
+	err := dev.hrscSend(rfm, func(rfm int) error {
+		return dev.hrscSetReadRegister(rfm, hr, ch)
+	})
+	if err != nil {
+		return 0, fmt.Errorf(
+			"eda: could not select channel (rfm=%d, hr=%d, ch=%d): %w",
+			rfm, hr, ch, err,
+		)
+	}
+
+	err = dev.cntReset()
+	if err != nil {
+		return 0, err
+	}
+	err = dev.cntStart()
+	if err != nil {
+		return 0, err
+	}
+	time.Sleep(dur)
+	err = dev.cntStop()
+	if err != nil {
+		return 0, err
+	}
+
+	n := dev.cntHit0(rfm)
+	if dev.err != nil {
+		return 0, fmt.Errorf(
+			"eda: could not read hit counter (rfm=%d, hr=%d, ch=%d): %w",
+			rfm, hr, ch, dev.err,
+		)
+	}
+	return n, nil
+}
+
+func checkChannel(rfm, hr, ch int) error {
+	switch {
+	case rfm < 0 || rfm >= nRFM:
+		return fmt.Errorf("eda: invalid RFM slot %d", rfm)
+	case hr < 0 || hr >= nHR:
+		return fmt.Errorf("eda: invalid hardroc %d", hr)
+	case ch < 0 || ch >= nChans:
+		return fmt.Errorf("eda: invalid channel %d", ch)
+	}
+	return nil
+}
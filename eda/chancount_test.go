@@ -0,0 +1,69 @@
+// Copyright 2020 The go-lpc Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package eda
+
+import (
+	"testing"
+)
+
+func TestRRImage(t *testing.T) {
+	const (
+		ctrl = 0xcafefade
+		hdr  = nHR*nBytesCfgHR - nChans
+	)
+
+	for _, tc := range []struct {
+		hr, ch int
+		pos    int  // index of the selected byte
+		want   byte // selected byte
+	}{
+		{hr: -1, ch: -1},
+		{hr: 0, ch: 0, pos: szCfgHR - 1, want: 0x01},
+		{hr: 0, ch: 9, pos: szCfgHR - 2, want: 0x02},
+		{hr: 1, ch: 7, pos: szCfgHR - 9, want: 0x80},
+		{hr: nHR - 1, ch: nChans - 1, pos: hdr + 4, want: 0x80},
+	} {
+		var buf [szCfgHR]byte
+		rrImage(&buf, ctrl, tc.hr, tc.ch)
+
+		if got, want := buf[hdr:hdr+4], []byte{0xca, 0xfe, 0xfa, 0xde}; string(got) != string(want) {
+			t.Fatalf("hr=%d, ch=%d: invalid loopback header: got=%x, want=%x", tc.hr, tc.ch, got, want)
+		}
+		for i, v := range buf[hdr+4:] {
+			i += hdr + 4
+			want := byte(0)
+			if tc.ch >= 0 && i == tc.pos {
+				want = tc.want
+			}
+			if v != want {
+				t.Fatalf("hr=%d, ch=%d: invalid byte %d: got=0x%x, want=0x%x", tc.hr, tc.ch, i, v, want)
+			}
+		}
+	}
+}
+
+func TestCountChannelArgs(t *testing.T) {
+	dev := &Device{buf: make([]byte, 4)}
+	for _, tc := range []struct {
+		rfm, hr, ch int
+		err         string
+	}{
+		{rfm: -1, err: "eda: invalid RFM slot -1"},
+		{rfm: nRFM, err: "eda: invalid RFM slot 4"},
+		{hr: nHR, err: "eda: invalid hardroc 8"},
+		{ch: -1, err: "eda: invalid channel -1"},
+		{ch: nChans, err: "eda: invalid channel 64"},
+	} {
+		_, err := dev.CountChannel(tc.rfm, tc.hr, tc.ch, 0)
+		if err == nil || err.Error() != tc.err {
+			t.Fatalf("invalid error: got=%v, want=%s", err, tc.err)
+		}
+	}
+
+	_, err := dev.CountChannels(nRFM, 0)
+	if err == nil || err.Error() != "eda: invalid RFM slot 4" {
+		t.Fatalf("invalid error: %v", err)
+	}
+}
@@ -0,0 +1,94 @@
+// Copyright 2020 The go-lpc Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package eda
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"time"
+)
+
+// dccSync waits for a DCC command to be received by the EDA board.
+//
+// Each attempt polls the DCC command code until the expected command is
+// seen or the attempt times out. Failed attempts are retried.
+type dccSync struct {
+	msg  *log.Logger
+	read func() uint32 // reads the last DCC command code received
+
+	timeout time.Duration // timeout of a single attempt (0: no timeout)
+	retries int           // number of attempts after the first one
+	poll    time.Duration // polling interval (0: busy polling)
+
+	last uint32 // last observed DCC command code
+}
+
+// wait waits for the want DCC command code.
+// wait returns an error if the command was not observed, after all
+// attempts timed out or if ctx was canceled.
+func (s *dccSync) wait(ctx context.Context, want uint32) error {
+	s.last = dccCmdNone
+	for i := 0; i <= s.retries; i++ {
+		if i > 0 {
+			s.msg.Printf(
+				"retrying to wait for DCC command 0x%x (attempt %d/%d, last=0x%x)...",
+				want, i+1, s.retries+1, s.last,
+			)
+		}
+		err := s.attempt(ctx, want)
+		if err == nil {
+			return nil
+		}
+		if ctx.Err() != nil {
+			return fmt.Errorf(
+				"eda: could not wait for DCC command 0x%x (last=0x%x): %w",
+				want, s.last, ctx.Err(),
+			)
+		}
+	}
+	return fmt.Errorf(
+		"eda: no DCC command 0x%x after %d attempt(s) of %v (last=0x%x)",
+		want, s.retries+1, s.timeout, s.last,
+	)
+}
+
+func (s *dccSync) attempt(ctx context.Context, want uint32) error {
+	if s.timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, s.timeout)
+		defer cancel()
+	}
+
+	var tick <-chan time.Time
+	if s.poll > 0 {
+		t := time.NewTicker(s.poll)
+		defer t.Stop()
+		tick = t.C
+	}
+
+	for {
+		s.last = s.read()
+		if s.last == want {
+			return nil
+		}
+		if tick == nil {
+			select {
+			case <-ctx.Done():
+				return ctx.Err()
+			default:
+			}
+			continue
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-tick:
+		}
+	}
+}
+
+// dccCmdNone is a DCC command code that is never sent by the DCC.
+const dccCmdNone = 0xe
@@ -0,0 +1,95 @@
+// Copyright 2020 The go-lpc Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package eda
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"log"
+	"testing"
+	"time"
+
+	"github.com/go-lpc/mim/eda/internal/regs"
+)
+
+func TestDCCSync(t *testing.T) {
+	msg := log.New(ioutil.Discard, "", 0)
+
+	t.Run("ok", func(t *testing.T) {
+		n := 0
+		s := dccSync{
+			msg: msg,
+			read: func() uint32 {
+				n++
+				if n < 10 {
+					return 0
+				}
+				return regs.CMD_RESET_BCID
+			},
+			timeout: time.Second,
+		}
+		err := s.wait(context.Background(), regs.CMD_RESET_BCID)
+		if err != nil {
+			t.Fatalf("could not wait for DCC command: %+v", err)
+		}
+		if got, want := s.last, uint32(regs.CMD_RESET_BCID); got != want {
+			t.Fatalf("invalid last command: got=0x%x, want=0x%x", got, want)
+		}
+	})
+
+	t.Run("retries", func(t *testing.T) {
+		var (
+			start = time.Now()
+			s     = dccSync{
+				msg: msg,
+				read: func() uint32 {
+					if time.Since(start) < 30*time.Millisecond {
+						return 2
+					}
+					return regs.CMD_RESET_BCID
+				},
+				timeout: 20 * time.Millisecond,
+				retries: 5,
+				poll:    time.Millisecond,
+			}
+		)
+		err := s.wait(context.Background(), regs.CMD_RESET_BCID)
+		if err != nil {
+			t.Fatalf("could not wait for DCC command: %+v", err)
+		}
+	})
+
+	t.Run("timeout", func(t *testing.T) {
+		s := dccSync{
+			msg:     msg,
+			read:    func() uint32 { return 2 },
+			timeout: 5 * time.Millisecond,
+			retries: 2,
+		}
+		err := s.wait(context.Background(), regs.CMD_RESET_BCID)
+		if err == nil {
+			t.Fatalf("expected a timeout error")
+		}
+		if got, want := err.Error(), "eda: no DCC command 0x1 after 3 attempt(s) of 5ms (last=0x2)"; got != want {
+			t.Fatalf("invalid error:\ngot= %v\nwant=%v", got, want)
+		}
+	})
+
+	t.Run("canceled", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+
+		s := dccSync{
+			msg:     msg,
+			read:    func() uint32 { return 0 },
+			retries: 10,
+		}
+		err := s.wait(ctx, regs.CMD_RESET_BCID)
+		if !errors.Is(err, context.Canceled) {
+			t.Fatalf("invalid error: got=%+v, want=%v", err, context.Canceled)
+		}
+	})
+}
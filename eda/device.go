@@ -6,24 +6,33 @@ package eda
 
 import (
 	"bufio"
+	"bytes"
+	"context"
+	"crypto/tls"
+	"errors"
 	"fmt"
+	"hash/fnv"
 	"io"
 	"log"
 	"net"
 	"os"
 	"path"
 	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 
+	"github.com/go-lpc/mim"
 	"github.com/go-lpc/mim/conddb"
 	"github.com/go-lpc/mim/eda/internal/regs"
+	"github.com/go-lpc/mim/internal/cbuf"
+	"github.com/go-lpc/mim/internal/eformat"
 	"github.com/go-lpc/mim/internal/mmap"
-	"golang.org/x/sync/errgroup"
 )
 
 // TODO:
 //  - send file to eda-srv
-//  - send beat to eda-ctl
 
 const (
 	nRFM        = 4
@@ -36,6 +45,8 @@ const (
 	daqBufferSize = nRFM * (26 + nHR*(2+128*20))
 
 	nMsgHdr = 8 // 'HDR\0+u32'
+
+	nWordsPerHR = 5 // number of DAQ FIFO words per hardroc frame
 )
 
 const (
@@ -45,11 +56,15 @@ const (
 type device interface {
 	Boot([]conddb.RFM) error
 	ConfigureDIF(addr string, dif uint8, asics []conddb.ASIC) error
+	AddDIFMonitor(addr string, dif uint8) error
 	Initialize() error
 	Start(run uint32) error
 	Stop() error
+	DrainFIFOs() (map[int]uint32, error)
 
 	Close() error
+
+	dump(w io.Writer, kind string, rfm int) error
 }
 
 var _ device = (*Device)(nil)
@@ -59,12 +74,14 @@ type Device struct {
 	msg  *log.Logger
 	rfms []int // list of enabled RFM slots
 	mem  struct {
-		fd  *os.File
+		fd  *os.File   // memory device (mem bus)
+		uio []*os.File // UIO devices of the mapped regions (uio bus)
 		lw  *mmap.Handle
 		h2f *mmap.Handle
 	}
 
 	dir string
+	fw  Firmware // FPGA firmware version
 
 	err  error
 	buf  []byte
@@ -82,6 +99,7 @@ type Device struct {
 			cnt48MSB reg32
 			cnt48LSB reg32
 			cnt24    reg32
+			fwVers   reg32 // firmware version
 		}
 		ramSC [nRFM]hrCfg
 
@@ -93,45 +111,107 @@ type Device struct {
 
 	cfg config
 
+	sc [nRFM][]byte // slow-control images last sent to the hardrocs, per RFM slot
+
 	daq struct {
 		rfm []rfmSink // DIF data sink, one per RFM
 
-		done chan int // signal to stop daq
+		done chan int      // signal to stop daq
+		exit chan struct{} // closed when the DAQ loop exits
+
+		f      *rawFile
+		set    eformat.Settings // settings record of the current run
+		prog   strings.Builder  // progress line of the current cycle, with a custom logger
+		cycles int64            // number of acquisition cycles of the current run
+		clock  *ClockSync       // clock synchronization at the start of the current run (nil: not checked)
+		lim    runLimits        // limits of the current run
+
+		stop struct {
+			sync.Mutex
+			gen     int         // number of started runs
+			started bool        // whether the current run was successfully started
+			done    bool        // whether the current run was stopped
+			err     error       // outcome of the stop of the current run
+			cancel  func()      // aborts the start of the current run (nil: not starting)
+			tmr     *time.Timer // timer of the maximum run duration (nil: none)
+		}
+
+		tidx struct {
+			f *os.File
+			w *bufio.Writer
+		}
+	}
+
+	disk struct {
+		low  int32     // whether file writes are paused for lack of disk space (atomic)
+		last time.Time // time of the last disk space check
+	}
+
+	beat *heartbeat // heartbeats to eda-ctl (nil: none)
+
+	fpga struct {
+		last    time.Time // time of the last FPGA check
+		recover int       // number of FPGA re-configurations during the current run
+	}
+
+	batch struct {
+		hist []int64 // number of readout batches of the current run, by number of cycles
+	}
+
+	streams struct {
+		sync.Mutex
+		rfm [nRFM][]*streamReader // stream readers, per RFM slot
+	}
 
-		f *os.File
+	check struct {
+		chambers map[int]uint8 // slot -> DIF ID of the chambers table (nil: unknown)
+		mask     uint32        // RFM mask requested along the chambers table
+		sc       [nRFM]int     // slow-control loop-back results
+		report   RFMReport
 	}
 }
 
 type rfmSink struct {
-	id    uint8 // RFM/DIF ID
-	slot  int   // EDA slot
-	w     *wbuf
+	id    uint8    // RFM/DIF ID
+	slot  int      // EDA slot
+	src   hrSource // source of hardroc data (nil: DAQ FIFO)
+	w     *cbuf.Buffer
 	buf   []byte
 	cycle uint32
+	trig  uint32 // trigger counter (DTC) of the last DIF block
 	bcid  uint32 // BCID48 offset
+	abs   uint64 // absolute BCID of the last DIF block
 	sck   net.Conn
+	out   bytes.Buffer // DIF data of the current cycle, as handed to framers
+
+	ovf struct {
+		cycles int // number of readout cycles with dropped data
+		bytes  int // number of dropped bytes
+		last   int // number of bytes dropped since the last buffer reset, already accounted for
+	}
+
+	sent struct {
+		blocks int64 // number of DIF blocks sent to the sink during the current run
+		bytes  int64 // number of DIF data bytes sent to the sink during the current run
+	}
+
+	empty struct {
+		blocks  int64 // number of DIF blocks without hardroc frames during the current run
+		dropped int64 // number of empty DIF blocks not sent to the sink (see WithEmptyBlockPrescale)
+	}
+
+	mons []*monitorSink // monitor sinks, receiving copies of the DIF data
+	prov []byte         // provenance trailer appended to DIF blocks (nil: none)
 }
 
 func (sink *rfmSink) valid() bool { return sink.id != 0 }
 
 func newDevice(devmem, odir, devshm string, opts ...Option) (*Device, error) {
-	mem, err := os.OpenFile(devmem, os.O_RDWR|os.O_SYNC, 0666)
-	if err != nil {
-		return nil, fmt.Errorf("eda: could not open %q: %w", devmem, err)
-	}
-	defer func() {
-		if err != nil {
-			_ = mem.Close()
-		}
-	}()
-
 	dev := &Device{
-		msg: log.New(os.Stdout, "eda: ", 0),
 		dir: odir,
 		buf: make([]byte, 4),
 		cfg: newConfig(),
 	}
-	dev.mem.fd = mem
 
 	WithResetBCID(10 * time.Second)(&dev.cfg)
 	WithDevSHM(devshm)(&dev.cfg)
@@ -139,6 +219,22 @@ func newDevice(devmem, odir, devshm string, opts ...Option) (*Device, error) {
 	for _, opt := range opts {
 		opt(&dev.cfg)
 	}
+	dev.msg = dev.cfg.logger("eda: ")
+
+	err := dev.cfg.check()
+	if err != nil {
+		return nil, err
+	}
+
+	err = dev.openBus(devmem)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		if err != nil {
+			_ = dev.closeBus()
+		}
+	}()
 
 	// setup RFMs indices from provided mask
 	dev.rfms = nil
@@ -161,37 +257,27 @@ func newDevice(devmem, odir, devshm string, opts ...Option) (*Device, error) {
 		}
 	}()
 
-	err = dev.mmapH2F()
-	if err != nil {
-		return nil, fmt.Errorf("eda: could not initialize HPS-to-FPGA bus: %w", err)
-	}
+	dev.probeH2F()
 	defer func() {
-		if err != nil {
+		if err != nil && dev.mem.h2f != nil {
 			_ = dev.mem.h2f.Close()
 		}
 	}()
 
+	err = dev.checkFirmware()
+	if err != nil {
+		return nil, err
+	}
+
 	return dev, nil
 }
 
 func NewDevice(fname string, odir string, opts ...Option) (*Device, error) {
-	mem, err := os.OpenFile(fname, os.O_RDWR|os.O_SYNC, 0666)
-	if err != nil {
-		return nil, fmt.Errorf("eda: could not open %q: %w", fname, err)
-	}
-	defer func() {
-		if err != nil {
-			_ = mem.Close()
-		}
-	}()
-
 	dev := &Device{
-		msg: log.New(os.Stdout, "eda: ", 0),
 		dir: odir,
 		buf: make([]byte, 4),
 		cfg: newConfig(),
 	}
-	dev.mem.fd = mem
 	WithResetBCID(10 * time.Second)(&dev.cfg)
 	WithConfigDir("/dev/shm/config_base")(&dev.cfg)
 	WithDevSHM("/dev/shm")(&dev.cfg)
@@ -202,6 +288,22 @@ func NewDevice(fname string, odir string, opts ...Option) (*Device, error) {
 	for _, opt := range opts {
 		opt(&dev.cfg)
 	}
+	dev.msg = dev.cfg.logger("eda: ")
+
+	err := dev.cfg.check()
+	if err != nil {
+		return nil, err
+	}
+
+	err = dev.openBus(fname)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		if err != nil {
+			_ = dev.closeBus()
+		}
+	}()
 
 	// setup RFMs indices from provided mask
 	dev.rfms = nil
@@ -223,22 +325,57 @@ func NewDevice(fname string, odir string, opts ...Option) (*Device, error) {
 		}
 	}()
 
-	err = dev.mmapH2F()
-	if err != nil {
-		return nil, fmt.Errorf("eda: could not initialize HPS-to-FPGA bus: %w", err)
-	}
+	dev.probeH2F()
 	defer func() {
-		if err != nil {
+		if err != nil && dev.mem.h2f != nil {
 			_ = dev.mem.h2f.Close()
 		}
 	}()
 
+	err = dev.checkFirmware()
+	if err != nil {
+		return nil, err
+	}
+
 	return dev, nil
 }
 
+// Boot sets up the RFMs of the device from the provided RFM descriptions.
+// The DAQ mode of the device is set from the trigger mode of the RFMs
+// DAQ state, which must be the same for all RFMs.
 func (dev *Device) Boot(args []conddb.RFM) error {
+	dev.beat.setState(StateConfiguring)
+	mode := ""
+	for _, rfm := range args {
+		err := rfm.DAQ.Validate()
+		if err != nil {
+			return fmt.Errorf("eda: invalid DAQ state for RFM=%d: %w", rfm.ID, err)
+		}
+		v, err := daqModeFrom(rfm.DAQ.TriggerMode)
+		if err != nil {
+			return fmt.Errorf("eda: invalid DAQ state for RFM=%d: %w", rfm.ID, err)
+		}
+		if mode != "" && v != mode {
+			return fmt.Errorf(
+				"eda: inconsistent trigger modes across RFMs (%q, %q)",
+				mode, v,
+			)
+		}
+		mode = v
+	}
+	if mode != "" && dev.cfg.daq.mode != "pattern" {
+		dev.cfg.daq.mode = mode
+	}
+
+	// forget about the DIFs of a previous boot.
 	dev.rfms = nil
+	dev.check.chambers = nil
+	dev.check.mask = 0
 	dev.cfg.daq.rfm = 0
+	dev.cfg.daq.addrs = make(map[int]string, len(args))
+	dev.cfg.daq.mons = nil
+	dev.cfg.hr.db = newDbConfig()
+	dev.sc = [nRFM][]byte{}
 	for _, rfm := range args {
 		dev.msg.Printf(
 			"boot: rfm=%d, eda-id=%v, slot-id=%d",
@@ -248,25 +385,238 @@ func (dev *Device) Boot(args []conddb.RFM) error {
 		dev.daq.rfm[rfm.Slot].id = uint8(rfm.ID)
 		dev.cfg.daq.rfm |= (1 << rfm.Slot)
 		dev.cfg.hr.rshaper = uint32(rfm.DAQ.RShaper)
+		dev.cfg.hr.db.rshaper[uint8(rfm.ID)] = uint32(rfm.DAQ.RShaper)
 	}
 	return nil
 }
 
+// ConfigureDIF sets the ASICs configuration of the provided DIF and the
+// address of the sink its data will be sent to.
+//
+// The DIF must have been booted. Configuring a DIF again replaces its
+// previous configuration. Sink addresses must be unique across DIFs.
 func (dev *Device) ConfigureDIF(addr string, dif uint8, asics []conddb.ASIC) error {
-	// FIXME(sbinet): handle hysteresis, make sure addrs are unique.
-	dev.cfg.daq.addrs = append(dev.cfg.daq.addrs, addr)
+	slot, err := dev.checkDIF(addr, dif, asics)
+	if err != nil {
+		return err
+	}
 
+	dev.cfg.daq.addrs[slot] = addr
 	dev.setDBConfig(dif, asics)
 
-	err := dev.configASICs(dif)
+	return nil
+}
+
+// checkDIF checks the provided DIF can be configured with the ASICs and
+// sink address, and returns its slot.
+func (dev *Device) checkDIF(addr string, dif uint8, asics []conddb.ASIC) (int, error) {
+	slot := dev.slotOf(dif)
+	if slot < 0 {
+		return slot, fmt.Errorf("eda: could not configure DIF=%d: DIF not booted", dif)
+	}
+	if len(asics) != nHR {
+		return slot, fmt.Errorf(
+			"eda: could not configure DIF=%d: invalid number of ASICs (got=%d, want=%d)",
+			dif, len(asics), nHR,
+		)
+	}
+	for i, v := range dev.cfg.daq.addrs {
+		if i != slot && v == addr {
+			return slot, fmt.Errorf(
+				"eda: could not configure DIF=%d: sink address %q already used by DIF=%d",
+				dif, addr, dev.daq.rfm[i].id,
+			)
+		}
+	}
+	return slot, nil
+}
+
+// validateDIF checks the provided DIF can be configured with the ASICs,
+// sink address and monitor sinks, without modifying the device.
+func (dev *Device) validateDIF(addr string, dif uint8, asics []conddb.ASIC, mons []string) error {
+	slot, err := dev.checkDIF(addr, dif, asics)
 	if err != nil {
-		return fmt.Errorf("eda: could not configure DIF=%d: %w", dif, err)
+		return err
+	}
+	regd := append([]string(nil), dev.cfg.daq.mons[slot]...)
+	for _, mon := range mons {
+		err := dev.checkMonitor(dif, addr, regd, mon)
+		if err != nil {
+			return err
+		}
+		regd = append(regd, mon)
+	}
+	return nil
+}
+
+// slotOf returns the slot of the booted DIF, or -1.
+func (dev *Device) slotOf(dif uint8) int {
+	for _, slot := range dev.rfms {
+		if dev.daq.rfm[slot].id == dif {
+			return slot
+		}
+	}
+	return -1
+}
+
+// checkDIFs checks all booted DIFs have been configured.
+func (dev *Device) checkDIFs() error {
+	var missing []string
+	for _, slot := range dev.rfms {
+		if _, ok := dev.cfg.daq.addrs[slot]; ok {
+			continue
+		}
+		missing = append(missing, fmt.Sprintf(
+			"DIF=%d (slot=%d)", dev.daq.rfm[slot].id, slot,
+		))
+	}
+	if len(missing) > 0 {
+		return fmt.Errorf(
+			"eda: missing configuration for %d DIF(s): %s",
+			len(missing), strings.Join(missing, ", "),
+		)
+	}
+	return nil
+}
+
+// daqModeFrom returns the DAQ mode corresponding to a conddb trigger mode.
+func daqModeFrom(trig conddb.TriggerMode) (string, error) {
+	switch trig {
+	case conddb.TriggerDCC:
+		return "dcc", nil
+	case conddb.TriggerNoise:
+		return "noise", nil
+	case conddb.TriggerExt:
+		return "", fmt.Errorf("eda: external trigger mode not supported")
+	default:
+		return "", fmt.Errorf("eda: unknown trigger mode %v", trig)
+	}
+}
+
+// triggerModeFrom returns the conddb trigger mode corresponding to a DAQ mode.
+func triggerModeFrom(mode string) conddb.TriggerMode {
+	switch mode {
+	case "noise":
+		return conddb.TriggerNoise
+	default:
+		return conddb.TriggerDCC
+	}
+}
+
+// condDB is the subset of conddb.DB needed to configure a device.
+type condDB interface {
+	LastHRConfig(ctx context.Context) (string, error)
+	Chambers(ctx context.Context, detID uint32) ([]conddb.Chamber, error)
+	ASICConfigs(ctx context.Context, hrConfig string, difIDs []uint8) (map[uint8][]conddb.ASIC, error)
+}
+
+// runDB is the subset of conddb.DB needed to record runs.
+type runDB interface {
+	BeginRun(ctx context.Context, run conddb.Run) error
+	EndRun(ctx context.Context, run uint32, board int, stop time.Time, cycles int64) error
+	AddRunFile(ctx context.Context, f conddb.RunFile) error
+}
+
+var (
+	_ condDB = (*conddb.DB)(nil)
+	_ runDB  = (*conddb.DB)(nil)
+)
+
+// ConfigureFromDB boots and configures the device from the chambers
+// definition of the provided detector and the last HardRoc configuration
+// stored in the condition database.
+//
+// ConfigureFromDB performs the same steps than the "scan" and "configure"
+// commands of the eda-svc server, without the need for a DIM bridge.
+func (dev *Device) ConfigureFromDB(ctx context.Context, db *conddb.DB, detID uint32) error {
+	return dev.configureFromDB(ctx, db, detID)
+}
+
+func (dev *Device) configureFromDB(ctx context.Context, db condDB, detID uint32) error {
+	dev.beat.setState(StateConfiguring)
+	hrcfg, err := db.LastHRConfig(ctx)
+	if err != nil {
+		return fmt.Errorf("eda: could not retrieve last HR configuration: %w", err)
+	}
+
+	chambers, err := db.Chambers(ctx, detID)
+	if err != nil {
+		return fmt.Errorf("eda: could not retrieve chambers of detector %d: %w", detID, err)
+	}
+
+	var (
+		rfms  []conddb.RFM
+		slots = make(map[int]uint8)
+		mask  = dev.cfg.daq.mask // RFMs requested by the configuration (0: all)
+	)
+	for _, ch := range chambers {
+		if ch.DIF >= 100 {
+			// not an EDA RFM.
+			continue
+		}
+		if dev.cfg.daq.eda >= 0 && int(ch.ASU) != dev.cfg.daq.eda {
+			continue
+		}
+		if ch.IY >= nRFM {
+			return fmt.Errorf(
+				"eda: invalid slot %d for DIF=%d (eda=%d)",
+				ch.IY, ch.DIF, ch.ASU,
+			)
+		}
+		slots[int(ch.IY)] = uint8(ch.DIF)
+		if mask != 0 && (mask>>ch.IY)&1 == 0 {
+			// excluded by the RFM mask: reported as disabled by checkRFMs.
+			continue
+		}
+		rfm := conddb.RFM{
+			ID:   int(ch.DIF),
+			EDA:  int(ch.ASU),
+			Slot: int(ch.IY),
+		}
+		rfm.DAQ.RShaper = conddb.RShaper(dev.cfg.hr.rshaper)
+		rfm.DAQ.TriggerMode = triggerModeFrom(dev.cfg.daq.mode)
+		rfms = append(rfms, rfm)
+	}
+
+	if len(rfms) == 0 {
+		return fmt.Errorf("eda: no EDA RFM for detector %d", detID)
+	}
+
+	err = dev.Boot(rfms)
+	if err != nil {
+		return fmt.Errorf("eda: could not boot device: %w", err)
+	}
+	dev.check.chambers = slots
+	dev.check.mask = mask
+
+	difs := make([]uint8, len(rfms))
+	for i, rfm := range rfms {
+		difs[i] = uint8(rfm.ID)
+	}
+	cfgs, err := db.ASICConfigs(ctx, hrcfg, difs)
+	if err != nil {
+		return fmt.Errorf(
+			"eda: could not retrieve ASICs configuration for DIFs=%v: %w",
+			difs, err,
+		)
+	}
+
+	for _, rfm := range rfms {
+		dif := uint8(rfm.ID)
+		asics := cfgs[dif]
+		addr := net.JoinHostPort(dev.cfg.daq.host, strconv.Itoa(10000+rfm.ID))
+		dev.msg.Printf("configuring DIF=%d with addr=%q", dif, addr)
+		err = dev.ConfigureDIF(addr, dif, asics)
+		if err != nil {
+			return err
+		}
 	}
 
 	return nil
 }
 
 func (dev *Device) Configure() error {
+	dev.beat.setState(StateConfiguring)
 	if dev.cfg.mode != "csv" {
 		return fmt.Errorf(
 			"eda: configure called w/ invalid cfg-mode %q (want %q)",
@@ -298,18 +648,37 @@ func (dev *Device) configureFromCSV() error {
 		return fmt.Errorf("eda: could not read masks: %w", err)
 	}
 
+	if dev.cfg.ctest.fname != "" {
+		err = dev.readCtest(dev.cfg.ctest.fname)
+		switch {
+		case err == nil:
+			// ok.
+		case errors.Is(err, os.ErrNotExist):
+			dev.msg.Printf("no Ctest file %q: Ctest switches left untouched", dev.cfg.ctest.fname)
+			dev.cfg.ctest.fname = ""
+		default:
+			return fmt.Errorf("eda: could not read Ctest switches: %w", err)
+		}
+	}
+
 	return nil
 }
 
 func (dev *Device) Initialize() error {
 	var err error
-	if len(dev.cfg.daq.addrs) != 0 {
+	if dev.cfg.daq.addrs != nil {
+		err = dev.checkDIFs()
+		if err != nil {
+			return err
+		}
+
 		dev.msg.Printf("initialize rfm sinks: %v", dev.rfms)
-		for i, slot := range dev.rfms {
-			err = dev.serveRFM(slot, dev.cfg.daq.addrs[i])
+		for _, slot := range dev.rfms {
+			err = dev.serveRFM(slot, dev.cfg.daq.addrs[slot])
 			if err != nil {
 				return err
 			}
+			dev.serveMonitors(slot)
 		}
 	}
 
@@ -318,12 +687,22 @@ func (dev *Device) Initialize() error {
 		return fmt.Errorf("eda: could not initialize FPGA: %w", err)
 	}
 
+	if dev.cfg.daq.mode == "pattern" {
+		dev.msg.Printf("test pattern mode: hardrocs left unconfigured")
+		return dev.notifyReady()
+	}
+
 	err = dev.initHR()
 	if err != nil {
 		return fmt.Errorf("eda: could not initialize HardRoc: %w", err)
 	}
 
-	return nil
+	err = dev.checkRFMs()
+	if err != nil {
+		return err
+	}
+
+	return dev.notifyReady()
 }
 
 func (dev *Device) initFPGA() error {
@@ -379,7 +758,7 @@ func (dev *Device) initFPGA() error {
 		if err != nil {
 			return fmt.Errorf("eda: could not enable DCC RAM-full: %w", err)
 		}
-	case "noise":
+	case "noise", "pattern":
 		err = dev.syncSelectCmdSoft()
 		if err != nil {
 			return fmt.Errorf("eda: could not select SOFT cmd: %w", err)
@@ -389,10 +768,16 @@ func (dev *Device) initFPGA() error {
 		return fmt.Errorf("eda: invalid trigger mode: %v", dev.cfg.daq.mode)
 	}
 
+	err = dev.trigSelectThreshold()
+	if err != nil {
+		return fmt.Errorf("eda: could not select trigger threshold: %w", err)
+	}
+
 	return nil
 }
 
 func (dev *Device) initHR() error {
+	dev.check.sc = [nRFM]int{}
 	if dev.cfg.mode == "csv" {
 		return dev.initHRFromCSV()
 	}
@@ -400,83 +785,48 @@ func (dev *Device) initHR() error {
 }
 
 func (dev *Device) initHRFromDB() error {
-	// disable trig_out output pin (RFM v1 coupling problem)
-	dev.hrscSetBit(0, 854, 0)
-
-	dev.hrscSetRShaper(0, dev.cfg.hr.rshaper)
-	dev.hrscSetCShaper(0, dev.cfg.hr.cshaper)
-
-	// set chip IDs
-	for hr := uint32(0); hr < nHR; hr++ {
-		dev.hrscSetChipID(hr, hr+1)
-	}
-
 	// for each active RFM, tune the configuration and send it.
 	for _, slot := range dev.rfms {
 		rfm := uint32(slot)
 		dif := dev.daq.rfm[slot].id
-		asics := dev.cfg.hr.db.asics[dif]
-		// mask unused channels
-		for hr := uint32(0); hr < nHR; hr++ {
-			for ch := uint32(0); ch < nChans; ch++ {
-				m0 := bitU64(asics[hr].Mask0, ch)
-				m1 := bitU64(asics[hr].Mask1, ch)
-				m2 := bitU64(asics[hr].Mask2, ch)
-
-				mask := uint32(m0 | m1<<1 | m2<<2)
-				if verbose {
-					dev.msg.Printf("%d      %d      %d\n", hr, ch, mask)
-				}
-				dev.hrscSetMask(hr, ch, mask)
-			}
-		}
-
-		// set DAC thresholds
-		if verbose {
-			dev.msg.Printf("HR      thresh0     thresh1     thresh2\n")
+		rshaper, ok := dev.cfg.hr.db.rshaper[dif]
+		if !ok {
+			rshaper = dev.cfg.hr.rshaper
 		}
-		for hr := uint32(0); hr < nHR; hr++ {
-			th0 := uint32(asics[hr].B0)
-			th1 := uint32(asics[hr].B1)
-			th2 := uint32(asics[hr].B2)
 
-			if verbose {
-				dev.msg.Printf("%d      %d      %d      %d\n", hr, th0, th1, th2)
-			}
-			dev.hrscSetDAC0(hr, th0)
-			dev.hrscSetDAC1(hr, th1)
-			dev.hrscSetDAC2(hr, th2)
-		}
-
-		// set preamplifier gain
-		if verbose {
-			dev.msg.Printf("HR      chan        pa_gain\n")
-		}
-		for hr := uint32(0); hr < nHR; hr++ {
-			for ch := uint32(0); ch < nChans; ch++ {
-				v, err := strconv.ParseUint(string(asics[hr].PreAmpGain[2*ch:2*ch+2]), 16, 8)
-				if err != nil {
-					return err
-				}
-				gain := uint32(v)
-				if verbose {
-					dev.msg.Printf("%d      %d      %d\n", hr, ch, gain)
-				}
-				dev.hrscSetPreAmp(hr, ch, gain)
+		// the slow-control buffer is shared by all RFMs:
+		// load the configuration of this DIF, built during a previous
+		// run if the configuration did not change.
+		key := newSCKey(
+			dev.cfg.hr.db.asics[dif], rshaper, dev.cfg.hr.cshaper,
+			dev.ctestTable(rfm),
+		)
+		if !hrscCache.load(key, dev.cfg.hr.data) {
+			err := dev.buildHRFromDB(rfm, dif, rshaper)
+			if err != nil {
+				return err
 			}
+			hrscCache.store(key, dev.cfg.hr.data)
 		}
 
 		// send to HRs
-		err := dev.hrscSetConfig(int(rfm))
+		err := dev.hrscSend(int(rfm), dev.hrscSetConfig)
+		if errors.Is(err, errSCLoopBack) || errors.Is(err, errSCTimeout) {
+			// reported by checkRFMs.
+			dev.msg.Printf("could not configure HR (dif=%d,slot=%d): %+v", dif, rfm, err)
+			dev.check.sc[rfm] = scDead
+			continue
+		}
 		if err != nil {
 			return fmt.Errorf(
 				"eda: could not send configuration to HR (dif=%d,slot=%d): %w",
 				dif, rfm, err,
 			)
 		}
+		dev.check.sc[rfm] = scAlive
 		dev.msg.Printf("Hardroc configuration (dif=%d, RFM=%d): [done]\n", dif, rfm)
 
-		err = dev.hrscResetReadRegisters(int(rfm))
+		err = dev.hrscSend(int(rfm), dev.hrscResetReadRegisters)
 		if err != nil {
 			return fmt.Errorf(
 				"eda: could not reset read-registers for RFM=%d: %w",
@@ -492,6 +842,99 @@ func (dev *Device) initHRFromDB() error {
 	return nil
 }
 
+// buildHRFromDB builds the slow-control image of the hardrocs of the
+// provided RFM from the condition database configuration of its DIF.
+func (dev *Device) buildHRFromDB(rfm uint32, dif uint8, rshaper uint32) error {
+	asics := dev.cfg.hr.db.asics[dif]
+
+	err := dev.configASICs(dif)
+	if err != nil {
+		return fmt.Errorf("eda: could not configure DIF=%d: %w", dif, err)
+	}
+
+	// disable trig_out output pin (RFM v1 coupling problem)
+	dev.hrscSetBit(0, 854, 0)
+
+	dev.hrscSetRShaper(0, rshaper)
+	dev.hrscSetCShaper(0, dev.cfg.hr.cshaper)
+
+	// set chip IDs
+	for hr := uint32(0); hr < nHR; hr++ {
+		dev.hrscSetChipID(hr, hr+1)
+	}
+
+	// mask unused channels
+	for hr := uint32(0); hr < nHR; hr++ {
+		for ch := uint32(0); ch < nChans; ch++ {
+			m0 := bitU64(asics[hr].Mask0, ch)
+			m1 := bitU64(asics[hr].Mask1, ch)
+			m2 := bitU64(asics[hr].Mask2, ch)
+
+			mask := uint32(m0 | m1<<1 | m2<<2)
+			if verbose {
+				dev.msg.Printf("%d      %d      %d\n", hr, ch, mask)
+			}
+			dev.hrscSetMask(hr, ch, mask)
+		}
+	}
+
+	// select test capacitors (charge injection)
+	if ctest := dev.ctestTable(rfm); ctest != nil {
+		for hr := uint32(0); hr < nHR; hr++ {
+			for ch := uint32(0); ch < nChans; ch++ {
+				dev.hrscSetCtest(hr, ch, ctest[nChans*hr+ch])
+			}
+		}
+	}
+
+	// set DAC thresholds
+	if verbose {
+		dev.msg.Printf("HR      thresh0     thresh1     thresh2\n")
+	}
+	for hr := uint32(0); hr < nHR; hr++ {
+		th0 := uint32(asics[hr].B0)
+		th1 := uint32(asics[hr].B1)
+		th2 := uint32(asics[hr].B2)
+
+		if verbose {
+			dev.msg.Printf("%d      %d      %d      %d\n", hr, th0, th1, th2)
+		}
+		dev.hrscSetDAC0(hr, th0)
+		dev.hrscSetDAC1(hr, th1)
+		dev.hrscSetDAC2(hr, th2)
+	}
+
+	// set preamplifier gain
+	if verbose {
+		dev.msg.Printf("HR      chan        pa_gain\n")
+	}
+	for hr := uint32(0); hr < nHR; hr++ {
+		for ch := uint32(0); ch < nChans; ch++ {
+			v, err := strconv.ParseUint(string(asics[hr].PreAmpGain[2*ch:2*ch+2]), 16, 8)
+			if err != nil {
+				return err
+			}
+			gain := uint32(v)
+			if verbose {
+				dev.msg.Printf("%d      %d      %d\n", hr, ch, gain)
+			}
+			dev.hrscSetPreAmp(hr, ch, gain)
+		}
+	}
+
+	return nil
+}
+
+// ctestTable returns the Ctest switches of the hardrocs of the provided
+// RFM, or nil if the switches are left untouched.
+func (dev *Device) ctestTable(rfm uint32) []uint32 {
+	if !dev.cfg.ctest.loaded {
+		return nil
+	}
+	const n = nHR * nChans
+	return dev.cfg.ctest.table[n*rfm : n*(rfm+1)]
+}
+
 func (dev *Device) initHRFromCSV() error {
 	// disable trig_out output pin (RFM v1 coupling problem)
 	dev.hrscSetBit(0, 854, 0)
@@ -513,59 +956,42 @@ func (dev *Device) initHRFromCSV() error {
 	}
 
 	// for each active RFM, tune the configuration and send it.
+	base := newSCKey(dev.cfg.hr.data)
 	for _, rfm := range dev.rfms {
-		// mask unused channels
-		for hr := uint32(0); hr < nHR; hr++ {
-			for ch := uint32(0); ch < nChans; ch++ {
-				mask := dev.cfg.mask.table[nChans*(nHR*uint32(rfm)+hr)+ch]
-				if verbose {
-					dev.msg.Printf("%d      %d      %d\n", hr, ch, mask)
-				}
-				dev.hrscSetMask(hr, ch, mask)
-			}
-		}
-
-		// set DAC thresholds
-		if verbose {
-			dev.msg.Printf("HR      thresh0     thresh1     thresh2\n")
-		}
-		for hr := uint32(0); hr < nHR; hr++ {
-			th0 := dev.cfg.daq.floor[3*(nHR*uint32(rfm)+hr)+0] + dev.cfg.daq.delta
-			th1 := dev.cfg.daq.floor[3*(nHR*uint32(rfm)+hr)+1] + dev.cfg.daq.delta
-			th2 := dev.cfg.daq.floor[3*(nHR*uint32(rfm)+hr)+2] + dev.cfg.daq.delta
-			if verbose {
-				dev.msg.Printf("%d      %d      %d      %d\n", hr, th0, th1, th2)
-			}
-			dev.hrscSetDAC0(hr, th0)
-			dev.hrscSetDAC1(hr, th1)
-			dev.hrscSetDAC2(hr, th2)
-		}
-
-		// set preamplifier gain
-		if verbose {
-			dev.msg.Printf("HR      chan        pa_gain\n")
-		}
-		for hr := uint32(0); hr < nHR; hr++ {
-			for ch := uint32(0); ch < nChans; ch++ {
-				gain := dev.cfg.preamp.gains[nChans*hr+ch]
-				if verbose {
-					dev.msg.Printf("%d      %d      %d\n", hr, ch, gain)
-				}
-				dev.hrscSetPreAmp(hr, ch, gain)
-			}
+		var (
+			n   = nHR * nChans
+			key = newSCKey(
+				base,
+				dev.cfg.mask.table[n*rfm:n*(rfm+1)],
+				dev.cfg.daq.floor[3*nHR*rfm:3*nHR*(rfm+1)],
+				dev.cfg.daq.delta,
+				dev.cfg.preamp.gains[:n],
+				dev.ctestTable(uint32(rfm)),
+			)
+		)
+		if !hrscCache.load(key, dev.cfg.hr.data) {
+			dev.buildHRFromCSV(rfm)
+			hrscCache.store(key, dev.cfg.hr.data)
 		}
 
 		// send to HRs
-		err := dev.hrscSetConfig(rfm)
+		err := dev.hrscSend(rfm, dev.hrscSetConfig)
+		if errors.Is(err, errSCLoopBack) || errors.Is(err, errSCTimeout) {
+			// reported by checkRFMs.
+			dev.msg.Printf("could not configure HR (RFM=%d): %+v", rfm, err)
+			dev.check.sc[rfm] = scDead
+			continue
+		}
 		if err != nil {
 			return fmt.Errorf(
 				"eda: could not send configuration to HR (RFM=%d): %w",
 				rfm, err,
 			)
 		}
+		dev.check.sc[rfm] = scAlive
 		dev.msg.Printf("Hardroc configuration (RFM=%d): [done]\n", rfm)
 
-		err = dev.hrscResetReadRegisters(rfm)
+		err = dev.hrscSend(rfm, dev.hrscResetReadRegisters)
 		if err != nil {
 			return fmt.Errorf(
 				"eda: could not reset read-registers for RFM=%d: %w",
@@ -581,45 +1007,140 @@ func (dev *Device) initHRFromCSV() error {
 	return nil
 }
 
+// buildHRFromCSV tunes the slow-control image of the hardrocs of the
+// provided RFM with the masks, thresholds and gains of the CSV files.
+func (dev *Device) buildHRFromCSV(rfm int) {
+	// mask unused channels
+	for hr := uint32(0); hr < nHR; hr++ {
+		for ch := uint32(0); ch < nChans; ch++ {
+			mask := dev.cfg.mask.table[nChans*(nHR*uint32(rfm)+hr)+ch]
+			if verbose {
+				dev.msg.Printf("%d      %d      %d\n", hr, ch, mask)
+			}
+			dev.hrscSetMask(hr, ch, mask)
+		}
+	}
+
+	// select test capacitors (charge injection)
+	if ctest := dev.ctestTable(uint32(rfm)); ctest != nil {
+		for hr := uint32(0); hr < nHR; hr++ {
+			for ch := uint32(0); ch < nChans; ch++ {
+				dev.hrscSetCtest(hr, ch, ctest[nChans*hr+ch])
+			}
+		}
+	}
+
+	// set DAC thresholds
+	if verbose {
+		dev.msg.Printf("HR      thresh0     thresh1     thresh2\n")
+	}
+	for hr := uint32(0); hr < nHR; hr++ {
+		th0 := dev.cfg.daq.floor[3*(nHR*uint32(rfm)+hr)+0] + dev.cfg.daq.delta
+		th1 := dev.cfg.daq.floor[3*(nHR*uint32(rfm)+hr)+1] + dev.cfg.daq.delta
+		th2 := dev.cfg.daq.floor[3*(nHR*uint32(rfm)+hr)+2] + dev.cfg.daq.delta
+		if verbose {
+			dev.msg.Printf("%d      %d      %d      %d\n", hr, th0, th1, th2)
+		}
+		dev.hrscSetDAC0(hr, th0)
+		dev.hrscSetDAC1(hr, th1)
+		dev.hrscSetDAC2(hr, th2)
+	}
+
+	// set preamplifier gain
+	if verbose {
+		dev.msg.Printf("HR      chan        pa_gain\n")
+	}
+	for hr := uint32(0); hr < nHR; hr++ {
+		for ch := uint32(0); ch < nChans; ch++ {
+			gain := dev.cfg.preamp.gains[nChans*hr+ch]
+			if verbose {
+				dev.msg.Printf("%d      %d      %d\n", hr, ch, gain)
+			}
+			dev.hrscSetPreAmp(hr, ch, gain)
+		}
+	}
+}
+
+// Start starts the run of the provided number.
+// The run is automatically stopped once one of the limits configured with
+// WithMaxRunDuration and WithMaxRunCycles is reached.
 func (dev *Device) Start(run uint32) error {
-	err := dev.initRun(run)
+	return dev.startLimited(run, dev.cfg.run.max)
+}
+
+func (dev *Device) start(ctx context.Context, run uint32) error {
+	switch dev.cfg.daq.mode {
+	case "dcc", "noise":
+		err := dev.needH2F("DAQ in " + dev.cfg.daq.mode + " mode")
+		if err != nil {
+			return fmt.Errorf("eda: could not start run: %w", err)
+		}
+	}
+
+	err := dev.checkDiskSpace()
+	if err != nil {
+		return fmt.Errorf("eda: could not start run: %w", err)
+	}
+	err = dev.checkClock()
+	if err != nil {
+		return fmt.Errorf("eda: could not start run: %w", err)
+	}
+	atomic.StoreInt32(&dev.disk.low, 0)
+	dev.disk.last = time.Now()
+	dev.fpga.last = dev.disk.last
+	dev.fpga.recover = 0
+	dev.batch.hist = dev.batch.hist[:0]
+
+	err = dev.initRun(run)
 	if err != nil {
 		return fmt.Errorf("eda: could not init run: %w", err)
 	}
 
 	switch dev.cfg.daq.mode {
 	case "dcc":
-		return dev.startRunDCC(run)
+		err = dev.startRunDCC(ctx, run)
 	case "noise":
-		return dev.startRunNoise(run)
+		err = dev.startRunNoise(run)
+	case "pattern":
+		err = dev.startRunPattern(run)
 	default:
 		err := fmt.Errorf("eda: unknown trig-mode %q", dev.cfg.daq.mode)
 		dev.msg.Printf("%+v", err)
 		return err
 	}
+	if err != nil {
+		return err
+	}
+
+	dev.recordRunStart(run, dev.cfg.daq.mode, dev.runFiles(run))
+	dev.count("eda.runs", 1)
+	dev.gauge("eda.run", float64(run))
+	dev.beat.update(func(b *Heartbeat) {
+		*b = Heartbeat{State: StateRunning, Run: run}
+	})
+	return nil
 }
 
-func (dev *Device) startRunDCC(run uint32) error {
+func (dev *Device) startRunDCC(ctx context.Context, run uint32) error {
 	var err error
-	resetBCID := make(chan uint32)
-	go func() {
-		var dccCmd uint32 = 0xe
-		dev.msg.Printf("launching reset-BCID goroutine...")
-		for dccCmd != regs.CMD_RESET_BCID {
-			dccCmd = dev.syncDCCCmdMem()
-		}
-		dev.msg.Printf("launching reset-BCID goroutine... [done: v=0x%x]", dccCmd)
-		resetBCID <- dccCmd
-	}()
 
 	dev.msg.Printf("waiting for reset-BCID...")
-	timer := time.NewTimer(dev.cfg.daq.timeout)
-	defer timer.Stop()
-	select {
-	case <-timer.C:
-		dev.msg.Printf("waiting for reset-BCID... [timeout]")
-	case v := <-resetBCID:
-		dev.msg.Printf("waiting for reset-BCID... [ok=0x%x]", v)
+	dcc := dccSync{
+		msg:     dev.msg,
+		read:    dev.syncDCCCmdMem,
+		timeout: dev.cfg.daq.timeout,
+		retries: dev.cfg.daq.retries,
+	}
+	err = dcc.wait(ctx, regs.CMD_RESET_BCID)
+	switch {
+	case err == nil:
+		dev.msg.Printf("waiting for reset-BCID... [ok=0x%x]", dcc.last)
+	case ctx.Err() != nil:
+		// the run was stopped while being started.
+		return fmt.Errorf("eda: could not start run: %w", err)
+	default:
+		// the run is started anyway, without a BCID reset.
+		dev.msg.Printf("waiting for reset-BCID... [timeout: %+v]", err)
 	}
 
 	dev.msg.Printf("sync-state: %[1]d 0x%[1]x\n", dev.syncState())
@@ -653,6 +1174,7 @@ func (dev *Device) startRunDCC(run uint32) error {
 	}
 
 	dev.daq.done = make(chan int)
+	dev.daq.exit = make(chan struct{})
 
 	go dev.loop()
 	return nil
@@ -688,6 +1210,7 @@ func (dev *Device) startRunNoise(run uint32) error {
 	}
 
 	dev.daq.done = make(chan int)
+	dev.daq.exit = make(chan struct{})
 
 	go dev.loop()
 	return nil
@@ -727,6 +1250,14 @@ func (dev *Device) initRun(run uint32) error {
 		)
 	}
 
+	dev.daq.set = dev.settings(run)
+	dev.daq.cycles = 0
+
+	err = dev.initProvenance()
+	if err != nil {
+		return fmt.Errorf("eda: could not create provenance trailers: %w", err)
+	}
+
 	dev.msg.Printf("-----------------RUN NB %d-----------------\n", run)
 	fname = path.Join(dev.dir, fmt.Sprintf("hr_sc_%03d.csv", run))
 	err = dev.hrscWriteConfHRs(fname)
@@ -737,6 +1268,13 @@ func (dev *Device) initRun(run uint32) error {
 		)
 	}
 
+	if dev.cfg.daq.tindex {
+		err = dev.daqOpenTimeIndex(run)
+		if err != nil {
+			return fmt.Errorf("eda: could not create time index: %w", err)
+		}
+	}
+
 	err = dev.syncResetHR()
 	if err != nil {
 		return fmt.Errorf("eda: could not reset hardroc: %w", err)
@@ -745,6 +1283,53 @@ func (dev *Device) initRun(run uint32) error {
 	return nil
 }
 
+// settings returns the settings record of the provided run, prepended
+// to raw output files to make them self-describing.
+func (dev *Device) settings(run uint32) eformat.Settings {
+	h := fnv.New64a()
+	_, _ = h.Write(dev.cfg.hr.data) // can not fail.
+	return eformat.Settings{
+		Board:   uint32(dev.cfg.daq.eda),
+		Run:     run,
+		RFM:     dev.cfg.daq.rfm,
+		RShaper: dev.cfg.hr.rshaper,
+		Delta:   dev.cfg.daq.delta,
+		HRHash:  h.Sum64(),
+
+		Firmware: dev.fw.Word(),
+	}
+}
+
+// initProvenance creates the provenance trailers of the active RFMs.
+func (dev *Device) initProvenance() error {
+	for i := range dev.daq.rfm {
+		dev.daq.rfm[i].prov = nil
+	}
+	if !dev.cfg.daq.prov {
+		return nil
+	}
+
+	vers, _ := mim.Version()
+	if vers == "" {
+		vers = "(devel)"
+	}
+	for _, slot := range dev.rfms {
+		prov := eformat.Provenance{
+			Board:    uint32(dev.cfg.daq.eda),
+			Slot:     uint8(slot),
+			Firmware: dev.fw.Word(),
+			Software: vers,
+		}
+		buf := new(bytes.Buffer)
+		_, err := prov.WriteTo(buf)
+		if err != nil {
+			return err
+		}
+		dev.daq.rfm[slot].prov = buf.Bytes()
+	}
+	return nil
+}
+
 func (dev *Device) serveRFM(i int, addr string) error {
 	rfm := &dev.daq.rfm[i]
 	dev.msg.Printf(
@@ -752,7 +1337,7 @@ func (dev *Device) serveRFM(i int, addr string) error {
 		rfm.id, rfm.slot, addr,
 	)
 
-	conn, err := net.Dial("tcp", addr)
+	conn, err := dev.dialSink(addr)
 	if err != nil {
 		return fmt.Errorf("could not connect to %q for rfm=(id=%d, slot=%d): %+v", addr, rfm.id, rfm.slot, err)
 	}
@@ -761,242 +1346,152 @@ func (dev *Device) serveRFM(i int, addr string) error {
 	return nil
 }
 
-func (dev *Device) loop() {
-	switch dev.cfg.daq.mode {
-	case "dcc":
-		dev.loopDCC()
-	case "noise":
-		dev.loopNoise()
-	default:
-		err := fmt.Errorf("eda: invalid trig-mode %q", dev.cfg.daq.mode)
-		panic(err)
+// dialSink connects to the DIF data sink at addr, a host:port address
+// where host may be a host name, an IPv4 or an IPv6 address.
+func (dev *Device) dialSink(addr string) (net.Conn, error) {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return nil, fmt.Errorf("eda: invalid DIF data sink address %q: %w", addr, err)
 	}
-}
-
-func (dev *Device) loopDCC() {
-	var (
-		w      = dev.msg.Writer()
-		printf = fmt.Fprintf
-		errorf = func(format string, args ...interface{}) {
-			dev.err = fmt.Errorf(format, args...)
-			dev.msg.Printf("%+v", dev.err)
-		}
-		cycle int
-		err   error
-	)
 
-	if len(dev.daq.rfm) != 0 {
-		for i := range dev.daq.rfm {
-			rfm := &dev.daq.rfm[i]
-			if rfm.sck == nil {
-				continue
-			}
-			defer rfm.sck.Close()
-		}
+	dialer := &net.Dialer{Timeout: dev.cfg.daq.dial.timeout}
+	if tlsCfg := dev.sinkTLS(host); tlsCfg != nil {
+		return tls.DialWithDialer(dialer, "tcp", addr, tlsCfg)
 	}
+	return dialer.Dial("tcp", addr)
+}
 
-	for i := range dev.daq.rfm {
-		rfm := &dev.daq.rfm[i]
-		rfm.w = &wbuf{
-			p: make([]byte, daqBufferSize),
-		}
+func (dev *Device) sinkTLS(host string) *tls.Config {
+	dial := &dev.cfg.daq.dial
+	if dial.tls == nil {
+		return nil
+	}
+	if dial.hosts == nil {
+		return dial.tls
 	}
+	if _, ok := dial.hosts[host]; ok {
+		return dial.tls
+	}
+	return nil
+}
 
-	dev.daq.f, err = os.Create("/dev/shm/out.raw")
-	if err != nil {
-		errorf("could not create output data file: %+v", err)
+// daqCheckOverflow accounts for DIF data dropped while filling the DIF
+// data buffer of the provided slot.
+func (dev *Device) daqCheckOverflow(slot int) {
+	sink := &dev.daq.rfm[slot]
+	n := sink.w.Dropped() - sink.ovf.last
+	if n == 0 {
 		return
 	}
-	defer dev.daq.f.Close()
-
-	for {
-		printf(w, "trigger %07d, state: acq-", cycle)
-		// wait until readout is done
-	readout:
-		for {
-			state := dev.syncState()
-			switch state {
-			case regs.S_START_RO:
-				printf(w, "ro-") // readout of HR
-			case regs.S_WAIT_END_RO:
-				// ok.
-			case regs.S_FIFO_READY:
-				break readout
-			default:
-				select {
-				case <-dev.daq.done:
-					dev.daq.done <- 1
-					return
-				default:
-				}
-			}
-		}
-		printf(w, "cp-") // copy
-
-		// read hardroc data
-		for i, rfm := range dev.rfms {
-			dev.daqWriteDIFData(dev.daq.rfm[i].w, rfm)
-		}
-		err = dev.syncAckFIFO()
-		if err != nil {
-			errorf("eda: could not ACK FIFO: %w", err)
-			return
-		}
-		printf(w, "tx-")
-		var grp errgroup.Group
-		for i := range dev.daq.rfm {
-			if !dev.daq.rfm[i].valid() {
-				continue
-			}
-			ii := i
-			grp.Go(func() error {
-				err := dev.daqSendDIFData(ii)
-				if err != nil {
-					errorf("eda: could not send DIF data (RFM=%d): %w", dev.rfms[ii], err)
-					return err
-				}
-				return nil
-			})
-		}
-		err = grp.Wait()
-		if err != nil {
-			errorf("eda: could not send DIF data: %w", err)
-			return
-		}
-
-		printf(w, "\n")
-		cycle++
+	sink.ovf.last += n
+	sink.ovf.cycles++
+	sink.ovf.bytes += n
+	dev.count("eda.overflows", 1)
+	dev.msg.Printf(
+		"DAQ buffer overflow (RFM=%d, cycle=%d): %d bytes dropped (buffer size=%d)",
+		slot, sink.cycle, n, sink.w.Max(),
+	)
+}
 
-		select {
-		case <-dev.daq.done:
-			dev.daq.done <- 1
-			return
-		default:
-		}
+func (dev *Device) daqOpenTimeIndex(run uint32) error {
+	fname := path.Join(dev.dir, fmt.Sprintf("tindex_%03d.csv", run))
+	f, err := os.Create(fname)
+	if err != nil {
+		return fmt.Errorf("eda: could not create time index file %q: %w", fname, err)
 	}
+	dev.daq.tidx.f = f
+	dev.daq.tidx.w = bufio.NewWriter(f)
+	fmt.Fprintf(dev.daq.tidx.w, "# dif;dtc;abs-bcid;unix-ns\n")
+	return nil
 }
 
-func (dev *Device) loopNoise() {
-	var (
-		w      = dev.msg.Writer()
-		printf = fmt.Fprintf
-		errorf = func(format string, args ...interface{}) {
-			dev.err = fmt.Errorf(format, args...)
-			dev.msg.Printf("%+v", dev.err)
-		}
-		cycle int
-		err   error
-	)
-
-	if len(dev.daq.rfm) != 0 {
-		for i := range dev.daq.rfm {
-			rfm := &dev.daq.rfm[i]
-			if !rfm.valid() {
-				continue
-			}
-			defer rfm.sck.Close()
-		}
+// daqWriteTimeIndex associates the DIF blocks of the current readout
+// cycle with the provided wall-clock time.
+// Failing to write the index does not stop the acquisition: the index
+// is disabled for the rest of the run instead.
+// Cycles read while file writes are paused are not indexed.
+func (dev *Device) daqWriteTimeIndex(ts time.Time) {
+	w := dev.daq.tidx.w
+	if w == nil || dev.diskLow() {
+		return
+	}
+	for _, slot := range dev.rfms {
+		sink := &dev.daq.rfm[slot]
+		fmt.Fprintf(w, "%d;%d;%d;%d\n", sink.id, sink.trig, sink.abs, ts.UnixNano())
+	}
+	err := w.Flush()
+	if err != nil {
+		dev.msg.Printf("could not write time index, disabling it: %+v", err)
+		dev.daq.tidx.w = nil
 	}
+}
 
-	for i := range dev.daq.rfm {
-		rfm := &dev.daq.rfm[i]
-		rfm.w = &wbuf{
-			p: make([]byte, daqBufferSize),
-		}
-	}
-
-	for {
-		printf(w, "trigger %07d, state: acq-", cycle)
-		// wait until readout is done
-	readout:
-		for {
-			state := dev.syncState()
-			switch {
-			case state >= regs.S_RAMFULL:
-				break readout
-			default:
-				select {
-				case <-dev.daq.done:
-					dev.daq.done <- 1
-					return
-				default:
-				}
-			}
-		}
-		printf(w, "ramfull-")
-		err = dev.syncRAMFullExt()
+func (dev *Device) daqCloseTimeIndex() error {
+	f := dev.daq.tidx.f
+	if f == nil {
+		return nil
+	}
+	dev.daq.tidx.f = nil
+	if w := dev.daq.tidx.w; w != nil {
+		dev.daq.tidx.w = nil
+		err := w.Flush()
 		if err != nil {
-			errorf("could not set RAMFULL: %+v", err)
-			return
-		}
-
-	dataReady:
-		for {
-			state := dev.syncState()
-			switch {
-			case state >= regs.S_FIFO_READY:
-				break dataReady
-			default:
-				select {
-				case <-dev.daq.done:
-					dev.daq.done <- 1
-					return
-				default:
-				}
-			}
+			_ = f.Close()
+			return fmt.Errorf("eda: could not flush time index: %w", err)
 		}
+	}
 
-		printf(w, "cp-") // copy
+	err := f.Close()
+	if err != nil {
+		return fmt.Errorf("eda: could not close time index file: %w", err)
+	}
+	return nil
+}
 
-		// read hardroc data
-		for i, rfm := range dev.rfms {
-			dev.daqWriteDIFData(dev.daq.rfm[i].w, rfm)
-		}
-		err = dev.syncAckFIFO()
-		if err != nil {
-			errorf("eda: could not ACK FIFO: %w", err)
-			return
-		}
-		printf(w, "tx-")
-		var grp errgroup.Group
-		for i := range dev.daq.rfm {
-			if !dev.daq.rfm[i].valid() {
-				continue
-			}
-			ii := i
-			grp.Go(func() error {
-				err := dev.daqSendDIFData(ii)
-				if err != nil {
-					errorf("eda: could not send DIF data (RFM=%d): %w", dev.rfms[ii], err)
-					return err
-				}
-				return nil
-			})
-		}
-		err = grp.Wait()
-		if err != nil {
-			errorf("eda: could not send DIF data: %w", err)
-			return
-		}
+// Stop stops the current run.
+// Stopping a run already stopped, e.g. once one of its limits was
+// reached, returns the outcome of the first stop.
+func (dev *Device) Stop() error {
+	dev.daq.stop.Lock()
+	defer dev.daq.stop.Unlock()
+	if cancel := dev.daq.stop.cancel; cancel != nil {
+		// the run is still being started: abort its start.
+		cancel()
+		return nil
+	}
+	return dev.stopOnce()
+}
 
-		printf(w, "\n")
-		cycle++
+// closed is a closed channel, returned by Done when no run was started.
+var closed = func() chan struct{} {
+	ch := make(chan struct{})
+	close(ch)
+	return ch
+}()
+
+// Done returns a channel closed when the DAQ loop of the current run exits,
+// either because the run was stopped (by Stop or once one of its limits
+// was reached) or because the acquisition failed.
+// Done must be called after Start.
+func (dev *Device) Done() <-chan struct{} {
+	if dev.daq.exit == nil {
+		return closed
+	}
+	return dev.daq.exit
+}
 
-		select {
-		case <-dev.daq.done:
-			dev.daq.done <- 1
-			return
-		default:
-			err = dev.syncStart()
-			if err != nil {
-				errorf("eda: could not start acquisition: %w", err)
-				return
-			}
-		}
+// Wait waits for the DAQ loop of the current run to exit and returns the
+// error that made it fail, if any.
+// Wait must be called after Start.
+func (dev *Device) Wait() error {
+	<-dev.Done()
+	if dev.err != nil {
+		return fmt.Errorf("eda: error during DAQ: %w", dev.err)
 	}
+	return nil
 }
 
-func (dev *Device) Stop() error {
+func (dev *Device) stop() error {
 	const timeout = 10 * time.Second
 	tck := time.NewTimer(timeout)
 	defer tck.Stop()
@@ -1004,9 +1499,47 @@ func (dev *Device) Stop() error {
 	select {
 	case dev.daq.done <- 1:
 		<-dev.daq.done
+	case <-dev.daq.exit:
+		// the DAQ loop failed: its error is reported below.
 	case <-tck.C:
 		return fmt.Errorf("eda: could not stop DAQ (timeout=%v)", timeout)
 	}
+	dev.recordRunStop()
+	dev.beat.setState(StateStopped)
+
+	if n := dev.fpga.recover; n > 0 {
+		dev.msg.Printf("FPGA re-configured %d time(s) during the run", n)
+	}
+
+	if len(dev.batch.hist) > 1 {
+		dev.msg.Printf("readout batches: %s", batchSummary(dev.batch.hist))
+	}
+
+	for _, slot := range dev.rfms {
+		ovf := dev.daq.rfm[slot].ovf
+		if ovf.cycles == 0 {
+			continue
+		}
+		dev.msg.Printf(
+			"RFM=%d: %d readout cycle(s) overflowed the DAQ buffer (%d bytes dropped)",
+			slot, ovf.cycles, ovf.bytes,
+		)
+	}
+
+	for _, slot := range dev.rfms {
+		empty := dev.daq.rfm[slot].empty
+		if empty.dropped == 0 {
+			continue
+		}
+		dev.msg.Printf(
+			"RFM=%d: %d empty DIF block(s) out of %d not sent",
+			slot, empty.dropped, empty.blocks,
+		)
+	}
+
+	// the time index is closed before the hardware is stopped, but failing
+	// to close it must not leave the counters and the FPGA running.
+	errTidx := dev.daqCloseTimeIndex()
 
 	if dev.err != nil {
 		return fmt.Errorf("eda: error during DAQ: %w", dev.err)
@@ -1014,7 +1547,7 @@ func (dev *Device) Stop() error {
 
 	var err error
 	switch dev.cfg.daq.mode {
-	case "dcc":
+	case "dcc", "pattern":
 		err = dev.cntStop()
 		if err != nil {
 			return fmt.Errorf("eda: could not stop counters: %w", err)
@@ -1043,21 +1576,26 @@ func (dev *Device) Stop() error {
 		return fmt.Errorf("eda: could not reset Hardroc: %w", err)
 	}
 
-	return nil
+	return errTidx
 }
 
 func (dev *Device) Close() error {
-	if dev.mem.fd == nil {
+	dev.beat.close()
+	dev.beat = nil
+
+	if dev.mem.lw == nil {
 		return nil
 	}
 
 	var (
 		errLW  = dev.mem.lw.Close()
-		errH2F = dev.mem.h2f.Close()
-		errMem = dev.mem.fd.Close()
+		errH2F error
 	)
+	if dev.mem.h2f != nil {
+		errH2F = dev.mem.h2f.Close()
+	}
+	errMem := dev.closeBus()
 
-	dev.mem.fd = nil
 	dev.mem.h2f = nil
 	dev.mem.lw = nil
 
@@ -1076,11 +1614,43 @@ func (dev *Device) Close() error {
 	return nil
 }
 
+// DrainFIFOs empties the DAQ FIFOs of all the RFM slots and returns the
+// number of discarded words, by slot.
+//
+// DrainFIFOs allows to recover a board left in the middle of a run (e.g.
+// after a crash of the DAQ process) without rebooting it.
+// DrainFIFOs must not be called while a run is in progress.
+func (dev *Device) DrainFIFOs() (map[int]uint32, error) {
+	err := dev.needH2F("draining DAQ FIFOs")
+	if err != nil {
+		return nil, err
+	}
+
+	const max = 1 << 20 // maximum number of words drained per FIFO
+
+	words := make(map[int]uint32, nRFM)
+	for rfm := 0; rfm < nRFM; rfm++ {
+		n, err := dev.daqFIFOClear(rfm, max)
+		words[rfm] = n
+		if err != nil {
+			return words, err
+		}
+		if n > 0 {
+			dev.msg.Printf("drained %d word(s) from DAQ FIFO (rfm=%d)", n, rfm)
+		}
+	}
+	return words, nil
+}
+
 func (dev *Device) DumpFIFOStatus(w io.Writer, rfm int) error {
+	err := dev.needH2F("DAQ FIFO status")
+	if err != nil {
+		return err
+	}
+
 	var (
 		fifo   = &dev.regs.fifo.daqCSR[rfm]
 		buf    = bufio.NewWriter(w)
-		err    error
 		printf = func(format string, args ...interface{}) {
 			_, e := fmt.Fprintf(buf, format, args...)
 			if err == nil {
@@ -1189,6 +1759,69 @@ func (dev *Device) DumpConfig(w io.Writer, rfm int) error {
 	return nil
 }
 
+// view returns a view of the registers of the device, with its own I/O
+// state, so the registers can be inspected while an acquisition runs.
+func (dev *Device) view() (*Device, error) {
+	v := &Device{
+		msg: dev.msg,
+		dir: dev.dir,
+		fw:  dev.fw,
+		buf: make([]byte, 4),
+	}
+	v.mem = dev.mem
+	v.daq.rfm = make([]rfmSink, nRFM) // acquisition cycles are not tracked.
+
+	err := v.bindLwH2F()
+	if err != nil {
+		return nil, fmt.Errorf("eda: could not bind lw-h2f registers: %w", err)
+	}
+	if v.mem.h2f != nil {
+		err = v.bindH2F()
+		if err != nil {
+			return nil, fmt.Errorf("eda: could not bind h2f registers: %w", err)
+		}
+	}
+	return v, nil
+}
+
+// dump writes the registers (kind="registers"), the status of the DAQ
+// FIFO of an RFM slot (kind="fifo"), the counters of an RFM slot
+// (kind="counters"), the slow-control RAM of an RFM slot (kind="config")
+// or the free disk space (kind="disk") to w.
+//
+// Counters are dumped from a view of the device, which does not track
+// acquisition cycles: their cycle ID is always 0.
+func (dev *Device) dump(w io.Writer, kind string, rfm int) error {
+	if kind == "disk" {
+		return dev.DumpDiskSpace(w)
+	}
+
+	v, err := dev.view()
+	if err != nil {
+		return err
+	}
+
+	switch kind {
+	case "fifo", "counters", "config":
+		if rfm < 0 || rfm >= nRFM {
+			return fmt.Errorf("eda: invalid RFM slot %d", rfm)
+		}
+	}
+
+	switch kind {
+	case "registers":
+		return v.DumpRegisters(w)
+	case "fifo":
+		return v.DumpFIFOStatus(w, rfm)
+	case "counters":
+		return v.DumpCounters(w, rfm)
+	case "config":
+		return v.DumpConfig(w, rfm)
+	default:
+		return fmt.Errorf("eda: invalid dump kind %q", kind)
+	}
+}
+
 func (dev *Device) DumpRegisters(w io.Writer) error {
 	const (
 		lvl = regs.ALTERA_AVALON_FIFO_LEVEL_REG
@@ -1213,10 +1846,14 @@ func (dev *Device) DumpRegisters(w io.Writer) error {
 	fmt.Fprintf(w, "pio.cnt48MSB=    0x%08x\n", regs.pio.cnt48MSB.r())
 	fmt.Fprintf(w, "pio.cnt48LSB=    0x%08x\n", regs.pio.cnt48LSB.r())
 
-	fmt.Fprintf(w, "fifo.daqCSR[0]=  0x%08x\n", regs.fifo.daqCSR[0].r(lvl))
-	fmt.Fprintf(w, "fifo.daqCSR[1]=  0x%08x\n", regs.fifo.daqCSR[1].r(lvl))
-	fmt.Fprintf(w, "fifo.daqCSR[2]=  0x%08x\n", regs.fifo.daqCSR[2].r(lvl))
-	fmt.Fprintf(w, "fifo.daqCSR[3]=  0x%08x\n", regs.fifo.daqCSR[3].r(lvl))
+	if dev.mem.h2f != nil {
+		fmt.Fprintf(w, "fifo.daqCSR[0]=  0x%08x\n", regs.fifo.daqCSR[0].r(lvl))
+		fmt.Fprintf(w, "fifo.daqCSR[1]=  0x%08x\n", regs.fifo.daqCSR[1].r(lvl))
+		fmt.Fprintf(w, "fifo.daqCSR[2]=  0x%08x\n", regs.fifo.daqCSR[2].r(lvl))
+		fmt.Fprintf(w, "fifo.daqCSR[3]=  0x%08x\n", regs.fifo.daqCSR[3].r(lvl))
+	} else {
+		fmt.Fprintf(w, "fifo.daqCSR=     n/a (no HPS-to-FPGA bus)\n")
+	}
 
 	names := [...]string{
 		0: "idle",
@@ -1230,6 +1867,10 @@ func (dev *Device) DumpRegisters(w io.Writer) error {
 		8: "stop run",
 	}
 	state := dev.syncState()
-	fmt.Fprintf(w, "synchro FSM state= %d (%s)\n", state, names[state])
+	name := fmt.Sprintf("unknown(%d)", state)
+	if int(state) < len(names) {
+		name = names[state]
+	}
+	fmt.Fprintf(w, "synchro FSM state= %d (%s)\n", state, name)
 	return dev.err
 }
@@ -5,79 +5,154 @@
 package eda
 
 import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
 	"encoding/binary"
 	"errors"
 	"fmt"
 	"io"
+	"io/ioutil"
+	"log"
+	"math"
 	"net"
+	"net/http/httptest"
+	"path/filepath"
+	"reflect"
 	"strings"
 	"sync"
 	"testing"
+	"time"
 
+	"github.com/go-lpc/mim/conddb"
 	"github.com/go-lpc/mim/eda/internal/regs"
+	"github.com/go-lpc/mim/obs"
 )
 
-func TestRun(t *testing.T) {
-	sink := func(done chan int, rfm int) string {
-		srv, err := net.Listen("tcp", ":0")
+// newTestSink starts a DIF data sink acknowledging all the DIF data it
+// receives, until done is closed.
+func newTestSink(t *testing.T, done chan int) string {
+	srv, err := net.Listen("tcp", ":0")
+	if err != nil {
+		t.Fatalf("could not create rfm-server: %+v", err)
+	}
+	go func() {
+		conn, err := srv.Accept()
 		if err != nil {
-			t.Fatalf("could not create rfm-server: %+v", err)
-		}
-		go func() {
-			conn, err := srv.Accept()
-			if err != nil {
-				select {
-				case <-done:
-					return
-				default:
-					t.Errorf("could not accept connection from rfm-server: %+v", err)
-					return
-				}
+			select {
+			case <-done:
+				return
+			default:
+				t.Errorf("could not accept connection from rfm-server: %+v", err)
+				return
 			}
-			defer conn.Close()
-			defer srv.Close()
-
-			buf := make([]byte, 8+daqBufferSize)
-			for {
-				select {
-				case <-done:
-					return
-				default:
-					_, err := io.ReadFull(conn, buf[:8])
-					if err != nil {
-						if errors.Is(err, io.EOF) {
-							return
-						}
-						t.Errorf("could not read DAQ DIF header: %+v", err)
-						continue
-					}
-					copy(buf[:4], "ACK\x00")
-					_, err = conn.Write(buf[:4])
-					if err != nil {
-						t.Errorf("could not send back ACK: %+v", err)
-						continue
-					}
-					size := binary.LittleEndian.Uint32(buf[4:8])
-					if size == 0 {
-						continue
-					}
-					_, err = io.ReadFull(conn, buf[:size])
-					if err != nil {
-						t.Errorf("could not read DAQ DIF data: %+v", err)
-						continue
-					}
-					copy(buf[:4], "ACK\x00")
-					_, err = conn.Write(buf[:4])
-					if err != nil {
-						t.Errorf("could not send back ACK: %+v", err)
-						continue
+		}
+		defer conn.Close()
+		defer srv.Close()
+
+		buf := make([]byte, 8+daqBufferSize)
+		for {
+			select {
+			case <-done:
+				return
+			default:
+				_, err := io.ReadFull(conn, buf[:8])
+				if err != nil {
+					if errors.Is(err, io.EOF) {
+						return
 					}
+					t.Errorf("could not read DAQ DIF header: %+v", err)
+					continue
+				}
+				copy(buf[:4], "ACK\x00")
+				_, err = conn.Write(buf[:4])
+				if err != nil {
+					t.Errorf("could not send back ACK: %+v", err)
+					continue
+				}
+				size := binary.LittleEndian.Uint32(buf[4:8])
+				if size == 0 {
+					continue
+				}
+				_, err = io.ReadFull(conn, buf[:size])
+				if err != nil {
+					t.Errorf("could not read DAQ DIF data: %+v", err)
+					continue
+				}
+				copy(buf[:4], "ACK\x00")
+				_, err = conn.Write(buf[:4])
+				if err != nil {
+					t.Errorf("could not send back ACK: %+v", err)
+					continue
 				}
 			}
-		}()
-		return srv.Addr().String()
+		}
+	}()
+	return srv.Addr().String()
+}
+
+// newTestRun creates a device reading out the provided RFM from a fake
+// FPGA and sending its DIF data to a test sink, and configures and
+// initializes it.
+// The returned function releases the device and its resources.
+func newTestRun(t *testing.T, rfm int, sc uint32, opts ...Option) (*Device, *fakeDev, func()) {
+	t.Helper()
+
+	done := make(chan int)
+	rfmAddr := newTestSink(t, done)
+
+	fdev, err := newFakeDev()
+	if err != nil {
+		close(done)
+		t.Fatalf("coud not create fake device: %+v", err)
+	}
+
+	dev, err := NewDevice(
+		fdev.mem, fdev.tmpdir,
+		append([]Option{
+			WithDevSHM(fdev.shm),
+			WithCtlAddr(""),
+			WithConfigDir("./testdata"),
+			WithThreshold(0),
+			WithRFMMask(0),
+			WithRShaper(0),
+			WithCShaper(3),
+		}, opts...)...,
+	)
+	if err != nil {
+		fdev.close()
+		close(done)
+		t.Fatalf("could not create fake device: %+v", err)
+	}
+	cleanup := func() {
+		dev.Close()
+		fdev.close()
+		close(done)
 	}
 
+	dev.rfms = []int{rfm}
+	dev.cfg.daq.addrs = map[int]string{rfm: rfmAddr}
+
+	fdev.fpga(dev, rfm, sc, nil)
+
+	err = dev.Configure()
+	if err != nil {
+		cleanup()
+		t.Fatalf("could not configure device: %+v", err)
+	}
+
+	err = dev.Initialize()
+	if err != nil {
+		cleanup()
+		t.Fatalf("could not initialize device: %+v", err)
+	}
+
+	return dev, fdev, cleanup
+}
+
+func TestRun(t *testing.T) {
 	for _, tc := range []struct {
 		rfm  int
 		done uint32
@@ -100,48 +175,10 @@ func TestRun(t *testing.T) {
 		},
 	} {
 		t.Run(fmt.Sprintf("rfm=%d", tc.rfm), func(t *testing.T) {
-			done := make(chan int)
-			defer close(done)
-
-			rfmAddr := sink(done, tc.rfm)
-
-			fdev, err := newFakeDev()
-			if err != nil {
-				t.Fatalf("coud not create fake device: %+v", err)
-			}
-			defer fdev.close()
-
-			dev, err := NewDevice(
-				fdev.mem, fdev.tmpdir,
-				WithDevSHM(fdev.shm),
-				WithCtlAddr(""),
-				WithConfigDir("./testdata"),
-				WithThreshold(0),
-				WithRFMMask(0),
-				WithRShaper(0),
-				WithCShaper(3),
-			)
-			if err != nil {
-				t.Fatalf("could not create fake device: %+v", err)
-			}
-			defer dev.Close()
-
-			dev.rfms = []int{tc.rfm}
-			dev.cfg.daq.addrs = []string{rfmAddr}
+			dev, _, cleanup := newTestRun(t, tc.rfm, tc.done)
+			defer cleanup()
 
-			fdev.fpga(dev, tc.rfm, tc.done, nil)
-
-			err = dev.Configure()
-			if err != nil {
-				t.Fatalf("could not configure device: %+v", err)
-			}
-
-			err = dev.Initialize()
-			if err != nil {
-				t.Fatalf("could not initialize device: %+v", err)
-			}
-
-			err = dev.Start(42)
+			err := dev.Start(42)
 			if err != nil {
 				t.Fatalf("could not start run: %+v", err)
 			}
@@ -159,6 +196,235 @@ func TestRun(t *testing.T) {
 	}
 }
 
+func TestRunLimits(t *testing.T) {
+	msg := new(strings.Builder)
+	dev, _, cleanup := newTestRun(
+		t, 0, regs.O_SC_DONE_0,
+		WithLogger(log.New(msg, "", 0)),
+		WithMaxRunCycles(1),
+	)
+	defer cleanup()
+
+	err := dev.Start(42)
+	if err != nil {
+		t.Fatalf("could not start run: %+v", err)
+	}
+
+	// the run is stopped once its maximum number of cycles is reached.
+	select {
+	case <-dev.Done():
+	case <-time.After(5 * time.Second):
+		t.Fatalf("run not stopped after its maximum number of cycles")
+	}
+
+	err = dev.Stop()
+	if err != nil {
+		t.Fatalf("could not stop run: %+v", err)
+	}
+
+	if !strings.Contains(msg.String(), "run 42: maximum number of cycles reached") {
+		t.Fatalf("run not stopped for its maximum number of cycles:\n%s", msg.String())
+	}
+
+	// the progress of a cycle is logged as a single message.
+	if !strings.Contains(msg.String(), "\neda: trigger 0000000, state: acq-ro-cp-tx-\n") {
+		t.Fatalf("invalid cycle progress:\n%s", msg.String())
+	}
+}
+
+func TestStopWhileWaitingResetBCID(t *testing.T) {
+	dev, _, cleanup := newTestRun(
+		t, 0, regs.O_SC_DONE_0,
+		WithLogger(log.New(ioutil.Discard, "", 0)),
+		WithResetBCID(0),
+	)
+	defer cleanup()
+
+	// no reset-BCID command from the DCC.
+	dev.regs.pio.cnt24 = reg32{r: func() uint32 { return 0 }}
+
+	errc := make(chan error, 1)
+	go func() {
+		errc <- dev.Start(42)
+	}()
+
+	for {
+		dev.daq.stop.Lock()
+		starting := dev.daq.stop.cancel != nil
+		dev.daq.stop.Unlock()
+		if starting {
+			break
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	err := dev.Stop()
+	if err != nil {
+		t.Fatalf("could not stop run: %+v", err)
+	}
+
+	select {
+	case err := <-errc:
+		if !errors.Is(err, context.Canceled) {
+			t.Fatalf("invalid start error: %+v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("run start not aborted by stop")
+	}
+}
+
+func TestRunMetrics(t *testing.T) {
+	var (
+		msg = new(strings.Builder)
+		reg = obs.NewRegistry()
+	)
+	dev, _, cleanup := newTestRun(
+		t, 0, regs.O_SC_DONE_0,
+		WithLogger(log.New(msg, "", 0)),
+		WithMetrics(reg),
+	)
+	defer cleanup()
+
+	err := dev.Start(42)
+	if err != nil {
+		t.Fatalf("could not start run: %+v", err)
+	}
+
+	err = dev.Stop()
+	if err != nil {
+		t.Fatalf("could not stop run: %+v", err)
+	}
+
+	if !strings.Contains(msg.String(), "eda: Hardroc configuration") {
+		t.Fatalf("device messages not sent to logger:\n%s", msg.String())
+	}
+	if got, want := reg.Counter("eda.runs"), int64(1); got != want {
+		t.Fatalf("invalid number of runs: got=%d, want=%d", got, want)
+	}
+	if got, want := reg.Gauge("eda.run"), 42.0; got != want {
+		t.Fatalf("invalid run number: got=%v, want=%v", got, want)
+	}
+}
+
+func TestExportSC(t *testing.T) {
+	dev, _, cleanup := newTestRun(t, 2, regs.O_SC_DONE_2)
+	defer cleanup()
+
+	img, err := dev.ExportSC(2)
+	if err != nil {
+		t.Fatalf("could not export slow-control image: %+v", err)
+	}
+	if got, want := img, dev.cfg.hr.buf[:]; !bytes.Equal(got, want) {
+		t.Fatalf("invalid slow-control image:\ngot= %x\nwant=%x", got, want)
+	}
+}
+
+func TestRunTimeIndex(t *testing.T) {
+	dev, fdev, cleanup := newTestRun(
+		t, 1, regs.O_SC_DONE_1,
+		WithTimeIndex(true),
+	)
+	defer cleanup()
+
+	err := dev.Start(42)
+	if err != nil {
+		t.Fatalf("could not start run: %+v", err)
+	}
+
+	err = dev.Stop()
+	if err != nil {
+		t.Fatalf("could not stop run: %+v", err)
+	}
+
+	tidx, err := ioutil.ReadFile(filepath.Join(fdev.tmpdir, "tindex_042.csv"))
+	if err != nil {
+		t.Fatalf("could not read time index: %+v", err)
+	}
+	if !strings.HasPrefix(string(tidx), "# dif;dtc;abs-bcid;unix-ns\n") {
+		t.Fatalf("invalid time index header:\n%s", tidx)
+	}
+}
+
+func TestWait(t *testing.T) {
+	t.Run("stopped", func(t *testing.T) {
+		dev, _, cleanup := newTestRun(t, 3, regs.O_SC_DONE_3)
+		defer cleanup()
+
+		err := dev.Start(42)
+		if err != nil {
+			t.Fatalf("could not start run: %+v", err)
+		}
+
+		err = dev.Stop()
+		if err != nil {
+			t.Fatalf("could not stop run: %+v", err)
+		}
+
+		select {
+		case <-dev.Done():
+		default:
+			t.Fatalf("done channel of a stopped run not closed")
+		}
+		err = dev.Wait()
+		if err != nil {
+			t.Fatalf("DAQ loop failed: %+v", err)
+		}
+	})
+
+	t.Run("failed", func(t *testing.T) {
+		dev := &Device{msg: log.New(ioutil.Discard, "", 0)}
+
+		select {
+		case <-dev.Done():
+		default:
+			t.Fatalf("done channel of a device without run not closed")
+		}
+
+		dev.daq.done = make(chan int)
+		dev.daq.exit = make(chan struct{})
+		go func() {
+			dev.err = fmt.Errorf("boom")
+			close(dev.daq.exit)
+		}()
+
+		const want = "eda: error during DAQ: boom"
+		err := dev.Wait()
+		if err == nil || err.Error() != want {
+			t.Fatalf("invalid error: got=%v, want=%q", err, want)
+		}
+
+		// Stop does not wait for a DAQ loop that already exited.
+		start := time.Now()
+		err = dev.stop()
+		if err == nil || err.Error() != want {
+			t.Fatalf("invalid stop error: got=%v, want=%q", err, want)
+		}
+		if d := time.Since(start); d > time.Second {
+			t.Fatalf("stop waited for a dead DAQ loop (%v)", d)
+		}
+	})
+}
+
+func TestTimeIndex(t *testing.T) {
+	var (
+		buf = new(bytes.Buffer)
+		dev Device
+	)
+	dev.msg = log.New(ioutil.Discard, "", 0)
+	dev.rfms = []int{1, 3}
+	dev.daq.rfm = make([]rfmSink, nRFM)
+	dev.daq.rfm[1] = rfmSink{id: 0x42, trig: 2, abs: 1234}
+	dev.daq.rfm[3] = rfmSink{id: 0x43, trig: 3, abs: 5678}
+	dev.daq.tidx.w = bufio.NewWriter(buf)
+
+	dev.daqWriteTimeIndex(time.Unix(1, 42))
+
+	want := "66;2;1234;1000000042\n67;3;5678;1000000042\n"
+	if got := buf.String(); got != want {
+		t.Fatalf("invalid time index:\ngot:\n%s\nwant:\n%s", got, want)
+	}
+}
+
 func TestDumpRegisters(t *testing.T) {
 	fdev, err := newFakeDev()
 	if err != nil {
@@ -285,6 +551,31 @@ synchro FSM state= 8 (stop run)
 	}
 }
 
+func TestDumpRegistersUnknownState(t *testing.T) {
+	fdev, err := newFakeDev()
+	if err != nil {
+		t.Fatalf("could not create fake device: %+v", err)
+	}
+	defer fdev.close()
+
+	dev, err := NewDevice(fdev.mem, fdev.tmpdir, WithDevSHM(fdev.shm))
+	if err != nil {
+		t.Fatalf("could not create fake device: %+v", err)
+	}
+	defer dev.Close()
+
+	dev.regs.pio.state.w(0xf << regs.SHIFT_SYNCHRO_STATE)
+
+	o := new(strings.Builder)
+	err = dev.DumpRegisters(o)
+	if err != nil {
+		t.Fatalf("could not run dump-registers: %+v", err)
+	}
+	if want := "synchro FSM state= 15 (unknown(15))\n"; !strings.HasSuffix(o.String(), want) {
+		t.Fatalf("invalid dump-registers:\n%s\nwant suffix: %q", o.String(), want)
+	}
+}
+
 func TestDumpFIFOStatus(t *testing.T) {
 	for _, rfmID := range []int{0, 1, 2, 3} {
 		t.Run(fmt.Sprintf("rfm=%d", rfmID), func(t *testing.T) {
@@ -451,3 +742,554 @@ func TestNewDevice(t *testing.T) {
 		})
 	}
 }
+
+type fakeCondDB struct {
+	hrcfg    string
+	chambers []conddb.Chamber
+	asics    func(dif uint8) []conddb.ASIC
+	queries  int // number of ASICs configuration queries
+}
+
+func (db *fakeCondDB) LastHRConfig(ctx context.Context) (string, error) {
+	return db.hrcfg, nil
+}
+
+func (db *fakeCondDB) Chambers(ctx context.Context, detID uint32) ([]conddb.Chamber, error) {
+	return db.chambers, nil
+}
+
+func (db *fakeCondDB) ASICConfigs(ctx context.Context, hrcfg string, difs []uint8) (map[uint8][]conddb.ASIC, error) {
+	if hrcfg != db.hrcfg {
+		return nil, fmt.Errorf("invalid hr-cfg %q", hrcfg)
+	}
+	db.queries++
+	cfgs := make(map[uint8][]conddb.ASIC, len(difs))
+	for _, dif := range difs {
+		cfgs[dif] = db.asics(dif)
+	}
+	return cfgs, nil
+}
+
+func TestConfigureFromDB(t *testing.T) {
+	fdev, err := newFakeDev()
+	if err != nil {
+		t.Fatalf("could not create fake device: %+v", err)
+	}
+	defer fdev.close()
+
+	dev, err := NewDevice(fdev.mem, fdev.tmpdir,
+		WithDevSHM(fdev.shm),
+		WithEDAID(1),
+		WithSinkHost("example.com"),
+		WithRShaper(2),
+	)
+	if err != nil {
+		t.Fatalf("could not create fake device: %+v", err)
+	}
+	defer dev.Close()
+
+	db := &fakeCondDB{
+		hrcfg: "LPC2020_0",
+		chambers: []conddb.Chamber{
+			{DIF: 1, ASU: 1, IY: 2},
+			{DIF: 2, ASU: 1, IY: 0},
+			{DIF: 3, ASU: 2, IY: 1},   // other EDA
+			{DIF: 120, ASU: 1, IY: 3}, // not an EDA RFM
+		},
+		asics: func(dif uint8) []conddb.ASIC {
+			return loadASICs(t, dif)
+		},
+	}
+
+	err = dev.configureFromDB(context.Background(), db, 139)
+	if err != nil {
+		t.Fatalf("could not configure device from db: %+v", err)
+	}
+
+	if got, want := dev.cfg.mode, "db"; got != want {
+		t.Fatalf("invalid cfg mode: got=%q, want=%q", got, want)
+	}
+	if got, want := dev.rfms, []int{2, 0}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("invalid rfms: got=%v, want=%v", got, want)
+	}
+	if got, want := dev.cfg.daq.rfm, uint32(0x5); got != want {
+		t.Fatalf("invalid rfm mask: got=0x%x, want=0x%x", got, want)
+	}
+	if got, want := dev.cfg.hr.rshaper, uint32(2); got != want {
+		t.Fatalf("invalid r-shaper: got=%d, want=%d", got, want)
+	}
+	if got, want := dev.cfg.daq.addrs, map[int]string{
+		2: "example.com:10001", 0: "example.com:10002",
+	}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("invalid sink addrs: got=%q, want=%q", got, want)
+	}
+	for _, slot := range []int{0, 2} {
+		if !dev.daq.rfm[slot].valid() {
+			t.Fatalf("rfm[%d] should be valid", slot)
+		}
+	}
+	for _, dif := range []uint8{1, 2} {
+		if _, ok := dev.cfg.hr.db.asics[dif]; !ok {
+			t.Fatalf("missing ASICs configuration for DIF=%d", dif)
+		}
+	}
+	if got, want := db.queries, 1; got != want {
+		t.Fatalf("invalid number of ASICs configuration queries: got=%d, want=%d", got, want)
+	}
+
+	// only boot the chambers enabled by the requested RFM mask.
+	WithRFMMask(0x4)(&dev.cfg)
+	err = dev.configureFromDB(context.Background(), db, 139)
+	if err != nil {
+		t.Fatalf("could not configure device from db: %+v", err)
+	}
+	if got, want := dev.rfms, []int{2}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("invalid rfms: got=%v, want=%v", got, want)
+	}
+	if got, want := dev.check.chambers, map[int]uint8{2: 1, 0: 2}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("invalid chambers: got=%v, want=%v", got, want)
+	}
+
+	db.chambers = db.chambers[2:3]
+	err = dev.configureFromDB(context.Background(), db, 139)
+	if err == nil {
+		t.Fatalf("expected an error")
+	}
+	if got, want := err.Error(), "eda: no EDA RFM for detector 139"; got != want {
+		t.Fatalf("invalid error:\ngot= %q\nwant=%q", got, want)
+	}
+}
+
+func TestBootTriggerMode(t *testing.T) {
+	newRFM := func(id, slot int, trig conddb.TriggerMode) conddb.RFM {
+		rfm := conddb.RFM{ID: id, EDA: 1, Slot: slot}
+		rfm.DAQ.RShaper = 3
+		rfm.DAQ.TriggerMode = trig
+		return rfm
+	}
+
+	for _, tc := range []struct {
+		name string
+		rfms []conddb.RFM
+		mode string
+		err  string
+	}{
+		{
+			name: "dcc",
+			rfms: []conddb.RFM{newRFM(1, 0, conddb.TriggerDCC), newRFM(2, 1, conddb.TriggerDCC)},
+			mode: "dcc",
+		},
+		{
+			name: "noise",
+			rfms: []conddb.RFM{newRFM(1, 0, conddb.TriggerNoise)},
+			mode: "noise",
+		},
+		{
+			name: "no-rfm",
+			mode: "noise",
+		},
+		{
+			name: "ext",
+			rfms: []conddb.RFM{newRFM(1, 0, conddb.TriggerExt)},
+			err:  "eda: invalid DAQ state for RFM=1: eda: external trigger mode not supported",
+		},
+		{
+			name: "unknown",
+			rfms: []conddb.RFM{newRFM(1, 0, 42)},
+			err:  "eda: invalid DAQ state for RFM=1: conddb: invalid trigger mode 42 (valid: 0=dcc, 1=noise, 2=ext)",
+		},
+		{
+			name: "invalid-rshaper",
+			rfms: []conddb.RFM{{ID: 1, EDA: 1, DAQ: conddb.RFMDAQState{RShaper: 7}}},
+			err:  "eda: invalid DAQ state for RFM=1: conddb: invalid R-shaper 7 (valid: 0-3)",
+		},
+		{
+			name: "inconsistent",
+			rfms: []conddb.RFM{newRFM(1, 0, conddb.TriggerDCC), newRFM(2, 1, conddb.TriggerNoise)},
+			err:  `eda: inconsistent trigger modes across RFMs ("dcc", "noise")`,
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			dev := Device{
+				msg: log.New(ioutil.Discard, "", 0),
+				cfg: newConfig(),
+			}
+			dev.cfg.daq.mode = "noise"
+			dev.daq.rfm = make([]rfmSink, nRFM)
+
+			err := dev.Boot(tc.rfms)
+			switch {
+			case err != nil && tc.err != "":
+				if got, want := err.Error(), tc.err; got != want {
+					t.Fatalf("invalid error:\ngot= %v\nwant=%v", got, want)
+				}
+				return
+			case err != nil:
+				t.Fatalf("could not boot device: %+v", err)
+			case tc.err != "":
+				t.Fatalf("expected an error (%s)", tc.err)
+			}
+
+			if got, want := dev.cfg.daq.mode, tc.mode; got != want {
+				t.Fatalf("invalid DAQ mode: got=%q, want=%q", got, want)
+			}
+			if got, want := len(dev.rfms), len(tc.rfms); got != want {
+				t.Fatalf("invalid number of RFMs: got=%d, want=%d", got, want)
+			}
+		})
+	}
+}
+
+func TestConfigureDIF(t *testing.T) {
+	newRFM := func(id, slot, rshaper int) conddb.RFM {
+		rfm := conddb.RFM{ID: id, EDA: 1, Slot: slot}
+		rfm.DAQ.RShaper = conddb.RShaper(rshaper)
+		rfm.DAQ.TriggerMode = conddb.TriggerDCC
+		return rfm
+	}
+
+	dev := Device{
+		msg: log.New(ioutil.Discard, "", 0),
+		cfg: newConfig(),
+	}
+	dev.daq.rfm = make([]rfmSink, nRFM)
+
+	err := dev.Boot([]conddb.RFM{
+		newRFM(1, 2, 1),
+		newRFM(2, 0, 3),
+		newRFM(3, 1, 2),
+	})
+	if err != nil {
+		t.Fatalf("could not boot device: %+v", err)
+	}
+
+	for _, tc := range []struct {
+		addr  string
+		dif   uint8
+		asics []conddb.ASIC
+		err   string
+	}{
+		{
+			addr:  "example.com:10001",
+			dif:   1,
+			asics: loadASICs(t, 1),
+		},
+		{
+			// reconfiguring a DIF replaces its previous configuration.
+			addr:  "example.com:10001",
+			dif:   1,
+			asics: loadASICs(t, 1),
+		},
+		{
+			addr:  "example.com:10001",
+			dif:   2,
+			asics: loadASICs(t, 2),
+			err:   `eda: could not configure DIF=2: sink address "example.com:10001" already used by DIF=1`,
+		},
+		{
+			addr:  "example.com:10004",
+			dif:   4,
+			asics: loadASICs(t, 2),
+			err:   "eda: could not configure DIF=4: DIF not booted",
+		},
+		{
+			addr:  "example.com:10002",
+			dif:   2,
+			asics: loadASICs(t, 2)[:4],
+			err:   "eda: could not configure DIF=2: invalid number of ASICs (got=4, want=8)",
+		},
+	} {
+		err := dev.ConfigureDIF(tc.addr, tc.dif, tc.asics)
+		switch {
+		case err != nil && tc.err != "":
+			if got, want := err.Error(), tc.err; got != want {
+				t.Fatalf("invalid error:\ngot= %v\nwant=%v", got, want)
+			}
+		case err != nil:
+			t.Fatalf("could not configure DIF=%d: %+v", tc.dif, err)
+		case tc.err != "":
+			t.Fatalf("expected an error (%s)", tc.err)
+		}
+	}
+
+	if got, want := dev.cfg.daq.addrs, map[int]string{2: "example.com:10001"}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("invalid sink addrs: got=%q, want=%q", got, want)
+	}
+	if got, want := dev.cfg.hr.db.rshaper, map[uint8]uint32{1: 1, 2: 3, 3: 2}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("invalid r-shapers: got=%v, want=%v", got, want)
+	}
+
+	err = dev.Initialize()
+	if err == nil {
+		t.Fatalf("expected an error")
+	}
+	if got, want := err.Error(), "eda: missing configuration for 2 DIF(s): DIF=2 (slot=0), DIF=3 (slot=1)"; got != want {
+		t.Fatalf("invalid error:\ngot= %v\nwant=%v", got, want)
+	}
+
+	// booting again forgets about previously configured DIFs.
+	err = dev.Boot([]conddb.RFM{newRFM(2, 0, 3)})
+	if err != nil {
+		t.Fatalf("could not boot device: %+v", err)
+	}
+	if got := len(dev.cfg.daq.addrs); got != 0 {
+		t.Fatalf("invalid number of sink addrs: got=%d, want=0", got)
+	}
+	err = dev.ConfigureDIF("example.com:10001", 2, loadASICs(t, 2))
+	if err != nil {
+		t.Fatalf("could not configure DIF=2: %+v", err)
+	}
+}
+
+func TestDialSink(t *testing.T) {
+	serve := func(ln net.Listener) {
+		go func() {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			defer conn.Close()
+			_, _ = conn.Write([]byte("ACK\x00"))
+		}()
+	}
+
+	recv := func(t *testing.T, conn net.Conn) {
+		t.Helper()
+		defer conn.Close()
+		buf := make([]byte, 4)
+		_, err := io.ReadFull(conn, buf)
+		if err != nil {
+			t.Fatalf("could not read from sink: %+v", err)
+		}
+		if got, want := string(buf), "ACK\x00"; got != want {
+			t.Fatalf("invalid message: got=%q, want=%q", got, want)
+		}
+	}
+
+	newDev := func(opts ...Option) *Device {
+		dev := &Device{cfg: newConfig()}
+		for _, opt := range opts {
+			opt(&dev.cfg)
+		}
+		return dev
+	}
+
+	t.Run("ipv4", func(t *testing.T) {
+		ln, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			t.Fatalf("could not listen: %+v", err)
+		}
+		defer ln.Close()
+		serve(ln)
+
+		conn, err := newDev().dialSink(ln.Addr().String())
+		if err != nil {
+			t.Fatalf("could not dial sink: %+v", err)
+		}
+		recv(t, conn)
+	})
+
+	t.Run("hostname", func(t *testing.T) {
+		ln, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			t.Fatalf("could not listen: %+v", err)
+		}
+		defer ln.Close()
+		serve(ln)
+
+		_, port, _ := net.SplitHostPort(ln.Addr().String())
+		conn, err := newDev(WithDialTimeout(time.Second)).dialSink(net.JoinHostPort("localhost", port))
+		if err != nil {
+			t.Fatalf("could not dial sink: %+v", err)
+		}
+		recv(t, conn)
+	})
+
+	t.Run("ipv6", func(t *testing.T) {
+		ln, err := net.Listen("tcp", "[::1]:0")
+		if err != nil {
+			t.Skipf("no IPv6 support: %+v", err)
+		}
+		defer ln.Close()
+		serve(ln)
+
+		_, port, _ := net.SplitHostPort(ln.Addr().String())
+		conn, err := newDev().dialSink(net.JoinHostPort("::1", port))
+		if err != nil {
+			t.Fatalf("could not dial sink: %+v", err)
+		}
+		recv(t, conn)
+	})
+
+	t.Run("tls", func(t *testing.T) {
+		srv := httptest.NewTLSServer(nil)
+		defer srv.Close()
+
+		ln, err := tls.Listen("tcp", "127.0.0.1:0", srv.TLS)
+		if err != nil {
+			t.Fatalf("could not listen: %+v", err)
+		}
+		defer ln.Close()
+		serve(ln)
+
+		roots := x509.NewCertPool()
+		roots.AddCert(srv.Certificate())
+		tlsCfg := &tls.Config{RootCAs: roots}
+
+		dev := newDev(WithSinkTLS(tlsCfg, "127.0.0.1"))
+		if dev.sinkTLS("127.0.0.2") != nil {
+			t.Fatalf("unexpected TLS configuration for host not using TLS")
+		}
+
+		conn, err := dev.dialSink(ln.Addr().String())
+		if err != nil {
+			t.Fatalf("could not dial sink: %+v", err)
+		}
+		if _, ok := conn.(*tls.Conn); !ok {
+			t.Fatalf("sink connection does not use TLS: %T", conn)
+		}
+		recv(t, conn)
+	})
+
+	t.Run("invalid-addr", func(t *testing.T) {
+		_, err := newDev().dialSink("::1")
+		if err == nil {
+			t.Fatalf("expected an error")
+		}
+	})
+}
+
+func TestDrainFIFOs(t *testing.T) {
+	fdev, err := newFakeDev()
+	if err != nil {
+		t.Fatalf("could not create fake device: %+v", err)
+	}
+	defer fdev.close()
+
+	dev, err := NewDevice(fdev.mem, fdev.tmpdir, WithDevSHM(fdev.shm))
+	if err != nil {
+		t.Fatalf("could not create fake device: %+v", err)
+	}
+	defer dev.Close()
+
+	// simulate FIFOs holding a few words.
+	fill := [nRFM]uint32{0, 12, 0, 5}
+	for rfm := range fill {
+		rfm := rfm
+		dev.regs.fifo.daq[rfm].r = func() uint32 {
+			fill[rfm]--
+			return 0xcafe
+		}
+		dev.regs.fifo.daqCSR[rfm].pins[regs.ALTERA_AVALON_FIFO_STATUS_REG].r = func() uint32 {
+			if fill[rfm] == 0 {
+				return 1 << 1 // empty
+			}
+			return 0
+		}
+	}
+
+	words, err := dev.DrainFIFOs()
+	if err != nil {
+		t.Fatalf("could not drain FIFOs: %+v", err)
+	}
+	if got, want := words, map[int]uint32{0: 0, 1: 12, 2: 0, 3: 5}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("invalid drained words: got=%v, want=%v", got, want)
+	}
+
+	// a FIFO that never empties.
+	fill[2] = math.MaxUint32
+	_, err = dev.DrainFIFOs()
+	if err == nil {
+		t.Fatalf("expected an error")
+	}
+	if got, want := err.Error(), "eda: DAQ FIFO (rfm=2) not empty after 1048576 words"; got != want {
+		t.Fatalf("invalid error:\ngot= %q\nwant=%q", got, want)
+	}
+}
+
+func TestDeviceWithoutH2F(t *testing.T) {
+	fdev, err := newFakeDev()
+	if err != nil {
+		t.Fatalf("could not create fake device: %+v", err)
+	}
+	defer fdev.close()
+
+	dev, err := NewDevice(fdev.mem, fdev.tmpdir, WithDevSHM(fdev.shm))
+	if err != nil {
+		t.Fatalf("could not create fake device: %+v", err)
+	}
+	defer dev.Close()
+
+	if got, want := dev.Capabilities(), (Capabilities{LwH2F: true, H2F: true}); got != want {
+		t.Fatalf("invalid capabilities: got=%+v, want=%+v", got, want)
+	}
+
+	// simulate a kernel without the HPS-to-FPGA bridge.
+	err = dev.mem.h2f.Close()
+	if err != nil {
+		t.Fatalf("could not close h2f: %+v", err)
+	}
+	dev.mem.h2f = nil
+
+	if got, want := dev.Capabilities(), (Capabilities{LwH2F: true}); got != want {
+		t.Fatalf("invalid capabilities: got=%+v, want=%+v", got, want)
+	}
+
+	err = dev.DumpFIFOStatus(ioutil.Discard, 0)
+	if !errors.Is(err, ErrUnsupported) {
+		t.Fatalf("invalid dump-fifo error: %+v", err)
+	}
+
+	err = dev.dump(ioutil.Discard, "fifo", 0)
+	if !errors.Is(err, ErrUnsupported) {
+		t.Fatalf("invalid dump(fifo) error: %+v", err)
+	}
+
+	_, err = dev.DrainFIFOs()
+	if !errors.Is(err, ErrUnsupported) {
+		t.Fatalf("invalid drain-fifos error: %+v", err)
+	}
+
+	out := new(strings.Builder)
+	err = dev.dump(out, "registers", 0)
+	if err != nil {
+		t.Fatalf("could not dump registers: %+v", err)
+	}
+	if !strings.Contains(out.String(), "fifo.daqCSR=     n/a") {
+		t.Fatalf("invalid registers dump:\n%s", out.String())
+	}
+
+	// counters and configuration only need the lightweight bridge.
+	for _, kind := range []string{"counters", "config"} {
+		out.Reset()
+		err = dev.dump(out, kind, 1)
+		if err != nil {
+			t.Fatalf("could not dump %s: %+v", kind, err)
+		}
+		if out.Len() == 0 {
+			t.Fatalf("empty %s dump", kind)
+		}
+		err = dev.dump(ioutil.Discard, kind, nRFM)
+		if err == nil || err.Error() != "eda: invalid RFM slot 4" {
+			t.Fatalf("invalid dump(%s) error: %+v", kind, err)
+		}
+	}
+
+	for _, mode := range []string{"dcc", "noise"} {
+		dev.cfg.daq.mode = mode
+		err = dev.Start(42)
+		if !errors.Is(err, ErrUnsupported) {
+			t.Fatalf("invalid start error in %s mode: %+v", mode, err)
+		}
+	}
+
+	err = dev.daqFIFOInit(0)
+	if !errors.Is(err, ErrUnsupported) {
+		t.Fatalf("invalid DAQ FIFO init error: %+v", err)
+	}
+
+	err = (&standalone{dev: dev, run: 42}).runDAQ(context.Background())
+	if !errors.Is(err, ErrUnsupported) {
+		t.Fatalf("invalid standalone DAQ error: %+v", err)
+	}
+}
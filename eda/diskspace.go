@@ -0,0 +1,132 @@
+// Copyright 2020 The go-lpc Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package eda
+
+import (
+	"fmt"
+	"io"
+	"sync/atomic"
+	"text/tabwriter"
+	"time"
+
+	"golang.org/x/sys/unix"
+)
+
+const (
+	diskCheckPeriod = 5 * time.Second // interval between disk space checks during a run
+)
+
+// diskFree returns the space available to unprivileged users on the
+// filesystem holding dir, in bytes.
+var diskFree = func(dir string) (uint64, error) {
+	var st unix.Statfs_t
+	err := unix.Statfs(dir, &st)
+	if err != nil {
+		return 0, err
+	}
+	return uint64(st.Bavail) * uint64(st.Bsize), nil
+}
+
+// outputDirs returns the directories the device writes files to.
+func (dev *Device) outputDirs() []string {
+	var dirs []string
+	for _, dir := range []string{dev.dir, dev.cfg.run.dir} {
+		if dir == "" || (len(dirs) > 0 && dirs[0] == dir) {
+			continue
+		}
+		dirs = append(dirs, dir)
+	}
+	return dirs
+}
+
+// checkDiskSpace returns an error if a filesystem the device writes to has
+// less free space than configured with WithMinFreeSpace.
+func (dev *Device) checkDiskSpace() error {
+	min := dev.cfg.run.minFree
+	if min == 0 {
+		return nil
+	}
+	for _, dir := range dev.outputDirs() {
+		free, err := diskFree(dir)
+		if err != nil {
+			return fmt.Errorf("eda: could not probe free space of %q: %w", dir, err)
+		}
+		if free < min {
+			return fmt.Errorf(
+				"eda: low disk space on %q: %s free (min=%s)",
+				dir, humanBytes(free), humanBytes(min),
+			)
+		}
+	}
+	return nil
+}
+
+// watchDiskSpace checks the free space of the output filesystems, at most
+// every diskCheckPeriod, and pauses or resumes file writes accordingly.
+func (dev *Device) watchDiskSpace(now time.Time) {
+	if dev.cfg.run.minFree == 0 || now.Sub(dev.disk.last) < diskCheckPeriod {
+		return
+	}
+	dev.disk.last = now
+
+	err := dev.checkDiskSpace()
+	switch {
+	case err != nil && !dev.diskLow():
+		dev.msg.Printf("%+v: pausing file writes", err)
+		atomic.StoreInt32(&dev.disk.low, 1)
+		dev.gauge("eda.disk.low", 1)
+	case err == nil && dev.diskLow():
+		dev.msg.Printf("disk space available again: resuming file writes")
+		atomic.StoreInt32(&dev.disk.low, 0)
+		dev.gauge("eda.disk.low", 0)
+	}
+}
+
+// diskLow returns whether file writes are paused for lack of disk space.
+func (dev *Device) diskLow() bool {
+	return atomic.LoadInt32(&dev.disk.low) != 0
+}
+
+// DumpDiskSpace writes the free space of the filesystems the device
+// writes to, and whether file writes are paused, to w.
+func (dev *Device) DumpDiskSpace(w io.Writer) error {
+	tw := tabwriter.NewWriter(w, 0, 8, 2, ' ', 0)
+	fmt.Fprintf(tw, "DIR\tFREE\tMIN\n")
+	for _, dir := range dev.outputDirs() {
+		free, err := diskFree(dir)
+		if err != nil {
+			return fmt.Errorf("eda: could not probe free space of %q: %w", dir, err)
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\n", dir, humanBytes(free), humanBytes(dev.cfg.run.minFree))
+	}
+	err := tw.Flush()
+	if err != nil {
+		return fmt.Errorf("eda: could not dump disk space: %w", err)
+	}
+
+	state := "ok"
+	if dev.diskLow() {
+		state = "paused (low disk space)"
+	}
+	_, err = fmt.Fprintf(w, "file writes: %s\n", state)
+	if err != nil {
+		return fmt.Errorf("eda: could not dump disk space: %w", err)
+	}
+	return nil
+}
+
+// humanBytes formats n as a number of bytes, with binary prefixes.
+func humanBytes(n uint64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%dB", n)
+	}
+	div, exp := uint64(unit), 0
+	for v := n / unit; v >= unit && exp < 4; v /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f%ciB", float64(n)/float64(div), "KMGTP"[exp])
+}
@@ -0,0 +1,128 @@
+// Copyright 2020 The go-lpc Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package eda
+
+import (
+	"bufio"
+	"fmt"
+	"log"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestDiskSpace(t *testing.T) {
+	defer func(f func(string) (uint64, error)) { diskFree = f }(diskFree)
+
+	free := map[string]uint64{
+		"/data":    10 << 30,
+		"/dev/shm": 10 << 30,
+	}
+	diskFree = func(dir string) (uint64, error) {
+		v, ok := free[dir]
+		if !ok {
+			return 0, fmt.Errorf("no such filesystem")
+		}
+		return v, nil
+	}
+
+	var (
+		msg  = new(strings.Builder)
+		tidx = new(strings.Builder)
+		dev  = Device{dir: "/data", cfg: newConfig()}
+	)
+	dev.msg = log.New(msg, "", 0)
+	dev.cfg.run.dir = "/dev/shm"
+	dev.daq.tidx.w = bufio.NewWriter(tidx)
+	dev.daq.rfm = make([]rfmSink, nRFM)
+	dev.daq.rfm[1].id = 42
+	dev.rfms = []int{1}
+
+	// no threshold: no check.
+	free["/data"] = 0
+	err := dev.checkDiskSpace()
+	if err != nil {
+		t.Fatalf("could not check disk space: %+v", err)
+	}
+
+	WithMinFreeSpace(1 << 30)(&dev.cfg)
+	err = dev.checkDiskSpace()
+	if got, want := fmt.Sprint(err), `eda: low disk space on "/data": 0B free (min=1.0GiB)`; got != want {
+		t.Fatalf("invalid error:\ngot= %v\nwant=%v", got, want)
+	}
+
+	free["/data"] = 2 << 30
+	err = dev.checkDiskSpace()
+	if err != nil {
+		t.Fatalf("could not check disk space: %+v", err)
+	}
+
+	var (
+		start = time.Now()
+		cycle = func(dt time.Duration) {
+			dev.watchDiskSpace(start.Add(dt))
+			dev.daqWriteTimeIndex(start.Add(dt))
+		}
+	)
+	dev.disk.last = start
+
+	cycle(time.Second)
+	free["/dev/shm"] = 512 << 20
+	cycle(2 * time.Second) // not checked yet.
+	cycle(6 * time.Second)
+	if !dev.diskLow() {
+		t.Fatalf("file writes not paused")
+	}
+	cycle(7 * time.Second)
+	free["/dev/shm"] = 3 << 30
+	cycle(12 * time.Second)
+	if dev.diskLow() {
+		t.Fatalf("file writes not resumed")
+	}
+
+	// 2 cycles indexed before the pause, 1 after.
+	if got, want := strings.Count(tidx.String(), "\n"), 3; got != want {
+		t.Fatalf("invalid time index: got=%d lines, want=%d\n%s", got, want, tidx.String())
+	}
+	if got, want := msg.String(), ""+
+		`eda: low disk space on "/dev/shm": 512.0MiB free (min=1.0GiB): pausing file writes`+"\n"+
+		"disk space available again: resuming file writes\n"; got != want {
+		t.Fatalf("invalid log:\ngot= %q\nwant=%q", got, want)
+	}
+
+	out := new(strings.Builder)
+	atomic.StoreInt32(&dev.disk.low, 1)
+	err = dev.dump(out, "disk", 0)
+	if err != nil {
+		t.Fatalf("could not dump disk space: %+v", err)
+	}
+	if got, want := out.String(), `DIR       FREE    MIN
+/data     2.0GiB  1.0GiB
+/dev/shm  3.0GiB  1.0GiB
+file writes: paused (low disk space)
+`; got != want {
+		t.Fatalf("invalid disk space dump:\ngot:\n%s\nwant:\n%s", got, want)
+	}
+}
+
+func TestHumanBytes(t *testing.T) {
+	for _, tc := range []struct {
+		n    uint64
+		want string
+	}{
+		{0, "0B"},
+		{1023, "1023B"},
+		{1024, "1.0KiB"},
+		{1536, "1.5KiB"},
+		{64 << 20, "64.0MiB"},
+		{5 << 40, "5.0TiB"},
+		{3 << 60, "3072.0PiB"},
+	} {
+		if got := humanBytes(tc.n); got != tc.want {
+			t.Fatalf("invalid value for %d: got=%q, want=%q", tc.n, got, tc.want)
+		}
+	}
+}
@@ -0,0 +1,55 @@
+// Copyright 2020 The go-lpc Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Package edatest provides a fake memory device of an EDA board, to run
+// eda.Device without an EDA board.
+//
+// The FPGA registers of the fake memory device are plain memory: a device
+// created on it can be initialized and run in test pattern mode (see
+// eda.WithTestPattern), where the hardrocs and the DAQ FIFOs are not used.
+//
+//	devmem, err := edatest.NewDevMem(dir)
+//	// ...
+//	dev, err := eda.NewDevice(devmem, dir, eda.WithTestPattern(10, 0))
+package edatest // import "github.com/go-lpc/mim/eda/edatest"
+
+import (
+	"encoding/binary"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/go-lpc/mim/eda/internal/regs"
+)
+
+// NewDevMem creates a fake memory device in the provided directory and
+// returns its path.
+// The fake memory device spans the HPS-to-FPGA and lightweight
+// HPS-to-FPGA buses, with the PLL of the FPGA locked.
+func NewDevMem(dir string) (string, error) {
+	fname := filepath.Join(dir, "dev.mem")
+	f, err := os.Create(fname)
+	if err != nil {
+		return "", fmt.Errorf("edatest: could not create fake memory device: %w", err)
+	}
+	defer f.Close()
+
+	var state [4]byte
+	binary.LittleEndian.PutUint32(state[:], regs.O_PLL_LCK)
+	_, err = f.WriteAt(state[:], regs.LW_H2F_BASE+regs.LW_H2F_PIO_STATE_IN)
+	if err != nil {
+		return "", fmt.Errorf("edatest: could not lock PLL: %w", err)
+	}
+
+	err = f.Truncate(regs.LW_H2F_BASE + regs.LW_H2F_SPAN + 1)
+	if err != nil {
+		return "", fmt.Errorf("edatest: could not resize fake memory device: %w", err)
+	}
+
+	err = f.Close()
+	if err != nil {
+		return "", fmt.Errorf("edatest: could not close fake memory device: %w", err)
+	}
+	return fname, nil
+}
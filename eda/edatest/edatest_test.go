@@ -0,0 +1,44 @@
+// Copyright 2020 The go-lpc Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package edatest_test
+
+import (
+	"io/ioutil"
+	"log"
+	"testing"
+
+	"github.com/go-lpc/mim/eda"
+	"github.com/go-lpc/mim/eda/edatest"
+)
+
+func TestNewDevMem(t *testing.T) {
+	dir := t.TempDir()
+	devmem, err := edatest.NewDevMem(dir)
+	if err != nil {
+		t.Fatalf("could not create fake memory device: %+v", err)
+	}
+
+	dev, err := eda.NewDevice(
+		devmem, dir,
+		eda.WithDevSHM(dir),
+		eda.WithCtlAddr(""),
+		eda.WithLogger(log.New(ioutil.Discard, "", 0)),
+		eda.WithTestPattern(1, 0),
+	)
+	if err != nil {
+		t.Fatalf("could not create device: %+v", err)
+	}
+	defer dev.Close()
+
+	err = dev.Initialize()
+	if err != nil {
+		t.Fatalf("could not initialize device: %+v", err)
+	}
+
+	err = dev.Close()
+	if err != nil {
+		t.Fatalf("could not close device: %+v", err)
+	}
+}
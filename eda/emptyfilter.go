@@ -0,0 +1,67 @@
+// Copyright 2020 The go-lpc Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package eda
+
+import (
+	"github.com/go-lpc/mim/internal/eformat"
+)
+
+// emptyFilter removes the DIF blocks without hardroc frames from the
+// cycles, only keeping 1 empty block out of prescale per RFM (0: none).
+//
+// The time index of a cycle is written before its blocks are framed, so
+// filtered cycles are still accounted for locally.
+type emptyFilter struct {
+	dev      *Device
+	prescale int
+	view     eformat.DIFView
+}
+
+func newEmptyFilter(dev *Device, prescale int) *emptyFilter {
+	if prescale < 0 {
+		prescale = 0
+	}
+	return &emptyFilter{dev: dev, prescale: prescale}
+}
+
+func (f *emptyFilter) Frame(cycle *Cycle) error {
+	var (
+		dev  = f.dev
+		difs = cycle.DIFs[:0]
+	)
+	for _, blk := range cycle.DIFs {
+		if !f.empty(blk) {
+			difs = append(difs, blk)
+			continue
+		}
+		rfm := &dev.daq.rfm[blk.Slot]
+		rfm.empty.blocks++
+		if f.prescale > 0 && (rfm.empty.blocks-1)%int64(f.prescale) == 0 {
+			difs = append(difs, blk)
+			continue
+		}
+		rfm.empty.dropped++
+		dev.count("eda.empty.dropped", 1)
+	}
+	cycle.DIFs = difs
+	return nil
+}
+
+// empty returns whether the provided DIF block holds no hardroc frame.
+// Blocks that can not be decoded are not considered empty.
+func (f *emptyFilter) empty(blk DIFBlock) bool {
+	dec := eformat.NewDecoder(blk.ID, nil)
+	dec.IsEDA = true
+	for buf := blk.Data; len(buf) > 0; {
+		var err error
+		buf, err = dec.DecodeBytes(buf, &f.view)
+		if err != nil || len(f.view.Frames) > 0 {
+			return false
+		}
+	}
+	return len(blk.Data) > 0
+}
+
+var _ Framer = (*emptyFilter)(nil)
@@ -0,0 +1,102 @@
+// Copyright 2020 The go-lpc Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package eda
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+
+	"github.com/go-lpc/mim/internal/eformat"
+)
+
+func TestEmptyFilter(t *testing.T) {
+	encode := func(dif eformat.DIF) []byte {
+		buf := new(bytes.Buffer)
+		err := eformat.NewEncoder(buf).Encode(&dif)
+		if err != nil {
+			t.Fatalf("could not encode DIF: %+v", err)
+		}
+		return buf.Bytes()
+	}
+
+	var (
+		full = encode(eformat.DIF{
+			Header: eformat.GlobalHeader{ID: 10},
+			Frames: make([]eformat.Frame, 2),
+		})
+		empty = encode(eformat.DIF{
+			Header: eformat.GlobalHeader{ID: 10},
+		})
+		batch = append(append([]byte(nil), empty...), full...)
+	)
+
+	for _, tc := range []struct {
+		name     string
+		prescale int
+		want     [][]int // slots of the sent blocks, per cycle
+		dropped  int64
+	}{
+		{
+			name:     "drop-all",
+			prescale: 0,
+			want:     [][]int{{0, 2, 3}, {}, {0, 2, 3}, {}},
+			dropped:  4,
+		},
+		{
+			name:     "send-all",
+			prescale: 1,
+			want:     [][]int{{0, 1, 2, 3}, {1}, {0, 1, 2, 3}, {1}},
+		},
+		{
+			name:     "prescale-2",
+			prescale: 2,
+			want:     [][]int{{0, 1, 2, 3}, {}, {0, 1, 2, 3}, {}},
+			dropped:  2,
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			var (
+				dev Device
+				f   = newEmptyFilter(&dev, tc.prescale)
+				got [][]int
+			)
+			dev.daq.rfm = make([]rfmSink, nRFM)
+			for i := range tc.want {
+				cycle := Cycle{
+					Num: i,
+					DIFs: []DIFBlock{
+						{ID: 10, Slot: 0, Data: full},
+						{ID: 10, Slot: 1, Data: empty},
+						{ID: 10, Slot: 2, Data: []byte{0xff}}, // corrupted
+						{ID: 10, Slot: 3, Data: batch},
+					},
+				}
+				if i%2 == 1 {
+					cycle.DIFs = cycle.DIFs[1:2]
+				}
+				err := f.Frame(&cycle)
+				if err != nil {
+					t.Fatalf("could not filter cycle %d: %+v", i, err)
+				}
+				slots := []int{}
+				for _, blk := range cycle.DIFs {
+					slots = append(slots, blk.Slot)
+				}
+				got = append(got, slots)
+			}
+
+			if !reflect.DeepEqual(got, tc.want) {
+				t.Fatalf("invalid sent blocks:\ngot= %v\nwant=%v", got, tc.want)
+			}
+			if got, want := dev.daq.rfm[1].empty.blocks, int64(len(tc.want)); got != want {
+				t.Fatalf("invalid number of empty blocks: got=%d, want=%d", got, want)
+			}
+			if got, want := dev.daq.rfm[1].empty.dropped, tc.dropped; got != want {
+				t.Fatalf("invalid number of dropped blocks: got=%d, want=%d", got, want)
+			}
+		})
+	}
+}
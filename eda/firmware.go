@@ -0,0 +1,101 @@
+// Copyright 2020 The go-lpc Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package eda
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/go-lpc/mim/eda/internal/regs"
+)
+
+// Firmware describes the version of the FPGA firmware of an EDA board.
+type Firmware struct {
+	Major    uint8
+	Minor    uint8
+	Features uint16 // feature flags
+}
+
+// knownFirmwares lists the firmware versions supported by this driver.
+// Versions are added here as firmwares implementing the version register
+// are released.
+var knownFirmwares = []Firmware{
+	{Major: 0, Minor: 0}, // firmwares predating the version register.
+}
+
+func firmwareFrom(v uint32) Firmware {
+	return Firmware{
+		Major:    uint8(v >> regs.SHIFT_FW_MAJOR),
+		Minor:    uint8(v >> regs.SHIFT_FW_MINOR),
+		Features: uint16(v & regs.MASK_FW_FEATS),
+	}
+}
+
+// Word returns the firmware version word, as read from the FPGA.
+func (fw Firmware) Word() uint32 {
+	return uint32(fw.Major)<<regs.SHIFT_FW_MAJOR |
+		uint32(fw.Minor)<<regs.SHIFT_FW_MINOR |
+		uint32(fw.Features)
+}
+
+func (fw Firmware) String() string {
+	if fw == (Firmware{}) {
+		return "v0.0 (no version register)"
+	}
+	return fmt.Sprintf("v%d.%d (features=0x%04x)", fw.Major, fw.Minor, fw.Features)
+}
+
+// known returns whether the major and minor versions of the firmware are
+// supported by this driver.
+func (fw Firmware) known() bool {
+	for _, v := range knownFirmwares {
+		if v.Major == fw.Major && v.Minor == fw.Minor {
+			return true
+		}
+	}
+	return false
+}
+
+// Firmware returns the version of the FPGA firmware of the device.
+func (dev *Device) Firmware() Firmware {
+	return dev.fw
+}
+
+// checkFirmware reads the FPGA firmware version and refuses to drive
+// firmwares unknown to this driver, unless WithForceFirmware was used.
+// The version register is only read when enabled with WithFirmwareCheck.
+func (dev *Device) checkFirmware() error {
+	dev.fw = Firmware{}
+	if !dev.cfg.fw.check {
+		return nil
+	}
+
+	v := dev.regs.pio.fwVers.r()
+	if dev.err != nil {
+		return fmt.Errorf("eda: could not read firmware version: %w", dev.err)
+	}
+	dev.fw = firmwareFrom(v)
+	dev.msg.Printf("FPGA firmware: %v", dev.fw)
+
+	if dev.fw.known() {
+		return nil
+	}
+
+	known := make([]string, len(knownFirmwares))
+	for i, fw := range knownFirmwares {
+		known[i] = fmt.Sprintf("v%d.%d", fw.Major, fw.Minor)
+	}
+	if dev.cfg.fw.force {
+		dev.msg.Printf(
+			"WARNING: unknown FPGA firmware %v (known: %s), forcing",
+			dev.fw, strings.Join(known, ", "),
+		)
+		return nil
+	}
+	return fmt.Errorf(
+		"eda: unknown FPGA firmware %v (known: %s)",
+		dev.fw, strings.Join(known, ", "),
+	)
+}
@@ -0,0 +1,99 @@
+// Copyright 2020 The go-lpc Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package eda
+
+import (
+	"encoding/binary"
+	"os"
+	"testing"
+
+	"github.com/go-lpc/mim/eda/internal/regs"
+)
+
+func TestFirmware(t *testing.T) {
+	for _, tc := range []struct {
+		name  string
+		word  uint32
+		check bool
+		force bool
+		want  Firmware
+		err   string
+	}{
+		{
+			name:  "legacy",
+			word:  0,
+			check: true,
+			want:  Firmware{},
+		},
+		{
+			name:  "unchecked",
+			word:  0x02010000,
+			check: false,
+			want:  Firmware{},
+		},
+		{
+			name:  "unknown",
+			word:  0x02010000,
+			check: true,
+			err:   "eda: unknown FPGA firmware v2.1 (features=0x0000) (known: v0.0)",
+		},
+		{
+			name:  "unknown-forced",
+			word:  0x02010000,
+			check: true,
+			force: true,
+			want:  Firmware{Major: 2, Minor: 1},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			fdev, err := newFakeDev()
+			if err != nil {
+				t.Fatalf("could not create fake-dev: %+v", err)
+			}
+			defer fdev.close()
+
+			f, err := os.OpenFile(fdev.mem, os.O_RDWR, 0644)
+			if err != nil {
+				t.Fatalf("could not open fake dev-mem: %+v", err)
+			}
+			var buf [4]byte
+			binary.LittleEndian.PutUint32(buf[:], tc.word)
+			_, err = f.WriteAt(buf[:], regs.LW_H2F_BASE+regs.LW_H2F_PIO_FW_VERSION)
+			if err != nil {
+				t.Fatalf("could not write firmware version: %+v", err)
+			}
+			err = f.Close()
+			if err != nil {
+				t.Fatalf("could not close fake dev-mem: %+v", err)
+			}
+
+			dev, err := newDevice(
+				fdev.mem, fdev.tmpdir, fdev.shm,
+				WithFirmwareCheck(tc.check),
+				WithForceFirmware(tc.force),
+			)
+			switch {
+			case err != nil && tc.err != "":
+				if got, want := err.Error(), tc.err; got != want {
+					t.Fatalf("invalid error:\ngot= %v\nwant=%v", got, want)
+				}
+				return
+			case err != nil:
+				t.Fatalf("could not create device: %+v", err)
+			case tc.err != "":
+				_ = dev.Close()
+				t.Fatalf("expected an error")
+			}
+			defer dev.Close()
+
+			if got, want := dev.Firmware(), tc.want; got != want {
+				t.Fatalf("invalid firmware: got=%v, want=%v", got, want)
+			}
+			if got, want := dev.settings(1).Firmware, tc.want.Word(); got != want {
+				t.Fatalf("invalid settings firmware: got=0x%x, want=0x%x", got, want)
+			}
+		})
+	}
+}
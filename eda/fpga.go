@@ -0,0 +1,148 @@
+// Copyright 2020 The go-lpc Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package eda
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/go-lpc/mim/eda/internal/regs"
+)
+
+// ErrFPGALost is reported when the FPGA lost its configuration during a
+// run, e.g. after it was reprogrammed or its PLL lost lock.
+var ErrFPGALost = errors.New("eda: FPGA configuration lost")
+
+// checkFPGA checks the PLL lock and the sanity of the register map of the
+// FPGA. An unconfigured FPGA reads back all ones.
+func (dev *Device) checkFPGA() error {
+	var (
+		ctrl  = dev.regs.pio.ctrl.r()
+		state = dev.regs.pio.state.r()
+	)
+	if dev.err != nil {
+		return fmt.Errorf("eda: could not check FPGA: %w", dev.err)
+	}
+
+	switch {
+	case ctrl == 0xffffffff || state == 0xffffffff:
+		return fmt.Errorf(
+			"%w: invalid register map (ctrl=0x%x, state=0x%x)",
+			ErrFPGALost, ctrl, state,
+		)
+	case state&regs.O_PLL_LCK != regs.O_PLL_LCK:
+		return fmt.Errorf("%w: PLL not locked (state=0x%x)", ErrFPGALost, state)
+	}
+	return nil
+}
+
+// watchFPGA checks the FPGA at most every period set with WithFPGAWatch.
+func (dev *Device) watchFPGA(now time.Time) error {
+	period := dev.cfg.daq.fpga.period
+	if period == 0 || now.Sub(dev.fpga.last) < period {
+		return nil
+	}
+	dev.fpga.last = now
+	return dev.checkFPGA()
+}
+
+// recoverFPGA re-configures the FPGA and the hardrocs after the FPGA lost
+// its configuration, and re-arms the acquisition of the provided cycle.
+// The data of the interrupted cycle is discarded.
+func (dev *Device) recoverFPGA(cycle int, cause error) error {
+	n := dev.cfg.daq.fpga.retries
+	if dev.fpga.recover >= n {
+		return fmt.Errorf("eda: could not recover FPGA (attempts=%d): %w", n, cause)
+	}
+	dev.fpga.recover++
+	dev.count("eda.fpga.reconfigs", 1)
+	dev.beat.setState(StateConfiguring)
+	dev.msg.Printf(
+		"%+v: re-configuring FPGA (attempt %d/%d)...",
+		cause, dev.fpga.recover, n,
+	)
+
+	dev.daqResetBuffers()
+
+	err := dev.initFPGA()
+	if err != nil {
+		return fmt.Errorf("eda: could not re-initialize FPGA: %w", err)
+	}
+
+	if dev.cfg.daq.mode != "pattern" {
+		err = dev.initHR()
+		if err != nil {
+			return fmt.Errorf("eda: could not re-initialize HardRoc: %w", err)
+		}
+		for _, slot := range dev.rfms {
+			if dev.check.sc[slot] == scDead {
+				return fmt.Errorf(
+					"eda: could not re-configure HardRoc of RFM=%d: %w",
+					slot, cause,
+				)
+			}
+		}
+	}
+
+	err = dev.rearmFPGA(cycle)
+	if err != nil {
+		return err
+	}
+
+	dev.fpga.last = time.Now()
+	dev.beat.setState(StateRunning)
+	dev.msg.Printf("re-configuring FPGA... [ok]")
+	return nil
+}
+
+// rearmFPGA resets the counters and DAQ FIFOs, and re-arms the acquisition,
+// as Device.Start does.
+func (dev *Device) rearmFPGA(cycle int) error {
+	var err error
+	if dev.cfg.daq.mode != "pattern" {
+		for _, rfm := range dev.rfms {
+			err = dev.daqFIFOInit(rfm)
+			if err != nil {
+				return fmt.Errorf("eda: could not initialize DAQ FIFO (RFM=%d): %w", rfm, err)
+			}
+		}
+	}
+
+	err = dev.cntReset()
+	if err != nil {
+		return fmt.Errorf("eda: could not reset counters: %w", err)
+	}
+
+	switch dev.cfg.daq.mode {
+	case "dcc", "pattern":
+		err = dev.cntStart()
+		if err != nil {
+			return fmt.Errorf("eda: could not start counters: %w", err)
+		}
+	case "noise":
+		err = dev.syncResetBCID()
+		if err != nil {
+			return fmt.Errorf("eda: could not reset BCID: %w", err)
+		}
+		if cycle == 0 {
+			// later cycles are started by the noise waiter.
+			err = dev.syncStart()
+			if err != nil {
+				return fmt.Errorf("eda: could not start acquisition: %w", err)
+			}
+		}
+	}
+
+	if dev.cfg.daq.mode == "pattern" {
+		return nil
+	}
+
+	err = dev.syncArmFIFO()
+	if err != nil {
+		return fmt.Errorf("eda: could not arm FIFO: %w", err)
+	}
+	return nil
+}
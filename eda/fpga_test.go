@@ -0,0 +1,125 @@
+// Copyright 2020 The go-lpc Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package eda
+
+import (
+	"errors"
+	"log"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-lpc/mim/eda/internal/regs"
+)
+
+func TestFPGAWatch(t *testing.T) {
+	fdev, err := newFakeDev()
+	if err != nil {
+		t.Fatalf("could not create fake device: %+v", err)
+	}
+	defer fdev.close()
+
+	dev, err := NewDevice(fdev.mem, fdev.tmpdir, WithDevSHM(fdev.shm))
+	if err != nil {
+		t.Fatalf("could not create fake device: %+v", err)
+	}
+	defer dev.Close()
+
+	msg := new(strings.Builder)
+	dev.msg = log.New(msg, "", 0)
+
+	var (
+		ctrl  = uint32(0x18000022)
+		state = uint32(regs.O_PLL_LCK)
+	)
+	dev.regs.pio.ctrl.r = func() uint32 { return ctrl }
+	dev.regs.pio.state.r = func() uint32 { return state }
+
+	for _, tc := range []struct {
+		name  string
+		ctrl  uint32
+		state uint32
+		err   string
+	}{
+		{
+			name:  "ok",
+			ctrl:  0x18000022,
+			state: regs.O_PLL_LCK | regs.S_FIFO_READY<<regs.SHIFT_SYNCHRO_STATE,
+		},
+		{
+			name:  "reprogrammed",
+			ctrl:  0xffffffff,
+			state: 0xffffffff,
+			err:   "eda: FPGA configuration lost: invalid register map (ctrl=0xffffffff, state=0xffffffff)",
+		},
+		{
+			name:  "pll-unlocked",
+			ctrl:  0x18000022,
+			state: regs.S_IDLE << regs.SHIFT_SYNCHRO_STATE,
+			err:   "eda: FPGA configuration lost: PLL not locked (state=0x0)",
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			ctrl, state = tc.ctrl, tc.state
+			err := dev.checkFPGA()
+			switch {
+			case err != nil && tc.err != "":
+				if !errors.Is(err, ErrFPGALost) {
+					t.Fatalf("invalid error type: %+v", err)
+				}
+				if got, want := err.Error(), tc.err; got != want {
+					t.Fatalf("invalid error:\ngot= %q\nwant=%q", got, want)
+				}
+			case err != nil:
+				t.Fatalf("could not check FPGA: %+v", err)
+			case tc.err != "":
+				t.Fatalf("expected an error (%s)", tc.err)
+			}
+		})
+	}
+
+	now := time.Now()
+	ctrl, state = 0xffffffff, 0xffffffff
+
+	// no check by default.
+	err = dev.watchFPGA(now)
+	if err != nil {
+		t.Fatalf("unexpected FPGA check: %+v", err)
+	}
+
+	dev.cfg.daq.fpga.period = time.Minute
+	dev.cfg.daq.fpga.retries = 1
+	dev.fpga.last = now
+	err = dev.watchFPGA(now.Add(time.Second))
+	if err != nil {
+		t.Fatalf("unexpected FPGA check: %+v", err)
+	}
+
+	cause := dev.watchFPGA(now.Add(time.Hour))
+	if !errors.Is(cause, ErrFPGALost) {
+		t.Fatalf("invalid FPGA check: %+v", cause)
+	}
+
+	// the FPGA was reprogrammed and its PLL locked again.
+	ctrl, state = 0x0, regs.O_PLL_LCK
+	err = dev.recoverFPGA(1, cause)
+	if err != nil {
+		t.Fatalf("could not recover FPGA: %+v", err)
+	}
+	if got, want := dev.fpga.recover, 1; got != want {
+		t.Fatalf("invalid number of FPGA recoveries: got=%d, want=%d", got, want)
+	}
+	if !strings.Contains(msg.String(), "re-configuring FPGA (attempt 1/1)...") {
+		t.Fatalf("missing recovery log:\n%s", msg.String())
+	}
+
+	err = dev.recoverFPGA(2, cause)
+	if !errors.Is(err, ErrFPGALost) {
+		t.Fatalf("invalid recovery error: %+v", err)
+	}
+	if got, want := err.Error(), "eda: could not recover FPGA (attempts=1): "+cause.Error(); got != want {
+		t.Fatalf("invalid error:\ngot= %q\nwant=%q", got, want)
+	}
+}
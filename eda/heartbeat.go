@@ -0,0 +1,183 @@
+// Copyright 2020 The go-lpc Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package eda
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net"
+	"sync"
+	"time"
+)
+
+// States of a device, as reported by heartbeats.
+const (
+	StateConfiguring = "configuring"
+	StateReady       = "ready"
+	StateRunning     = "running"
+	StateStopped     = "stopped"
+)
+
+// Heartbeat is the status of a device, periodically sent to eda-ctl.
+//
+// Once initialized, a device configured with WithCtlAddr connects to
+// eda-ctl and sends the "eda-ready" message. Heartbeats then follow on the
+// same connection, one JSON object per line, every period set with
+// WithHeartbeat and on each change of state.
+type Heartbeat struct {
+	Time   time.Time `json:"time"`
+	State  string    `json:"state"`
+	Run    uint32    `json:"run"`
+	Cycles int64     `json:"cycles"` // number of acquisition cycles of the run
+	Bytes  int64     `json:"bytes"`  // number of DIF data bytes of the run
+
+	Batches int64 `json:"batches,omitempty"` // number of readout batches of the run (see WithReadoutBatching)
+}
+
+// heartbeat sends the heartbeats of a device to eda-ctl.
+// A nil heartbeat discards status updates.
+type heartbeat struct {
+	msg  *log.Logger
+	conn net.Conn
+	freq time.Duration
+
+	mu  sync.Mutex
+	cur Heartbeat
+
+	kick chan struct{} // requests an immediate heartbeat
+	quit chan struct{}
+	done chan struct{}
+}
+
+func newHeartbeat(conn net.Conn, freq time.Duration, msg *log.Logger) *heartbeat {
+	hb := &heartbeat{
+		msg:  msg,
+		conn: conn,
+		freq: freq,
+		cur:  Heartbeat{State: StateReady},
+		kick: make(chan struct{}, 1),
+		quit: make(chan struct{}),
+		done: make(chan struct{}),
+	}
+	go hb.run()
+	return hb
+}
+
+// notifyReady connects to eda-ctl, sends the "eda-ready" message and
+// starts sending heartbeats.
+// notifyReady is a no-op when no eda-ctl address was configured, and only
+// updates the state of the heartbeats when already connected.
+func (dev *Device) notifyReady() error {
+	if dev.beat != nil {
+		dev.beat.setState(StateReady)
+		return nil
+	}
+
+	addr := dev.cfg.ctl.addr
+	if addr == "" {
+		return nil
+	}
+
+	conn, err := net.DialTimeout("tcp", addr, dev.cfg.daq.dial.timeout)
+	if err != nil {
+		return fmt.Errorf("eda: could not dial eda-ctl %q: %w", addr, err)
+	}
+
+	_, err = conn.Write([]byte("eda-ready"))
+	if err != nil {
+		_ = conn.Close()
+		return fmt.Errorf("eda: could not send ready to eda-ctl %q: %w", addr, err)
+	}
+
+	if dev.cfg.ctl.beat <= 0 {
+		_ = conn.Close()
+		return nil
+	}
+
+	dev.beat = newHeartbeat(conn, dev.cfg.ctl.beat, dev.msg)
+	return nil
+}
+
+// setState sets the state of the device and sends a heartbeat.
+func (hb *heartbeat) setState(state string) {
+	hb.update(func(b *Heartbeat) { b.State = state })
+}
+
+// update updates the status of the device and sends a heartbeat if the
+// state of the device changed.
+func (hb *heartbeat) update(f func(b *Heartbeat)) {
+	if hb == nil {
+		return
+	}
+
+	hb.mu.Lock()
+	state := hb.cur.State
+	f(&hb.cur)
+	changed := hb.cur.State != state
+	hb.mu.Unlock()
+
+	if !changed {
+		return
+	}
+	select {
+	case hb.kick <- struct{}{}:
+	default:
+	}
+}
+
+func (hb *heartbeat) run() {
+	defer close(hb.done)
+	defer hb.conn.Close()
+
+	tck := time.NewTicker(hb.freq)
+	defer tck.Stop()
+
+	for {
+		err := hb.send()
+		if err != nil {
+			hb.msg.Printf("could not send heartbeat to eda-ctl, disabling heartbeats: %+v", err)
+			return
+		}
+
+		select {
+		case <-hb.quit:
+			// last heartbeat, with the final state of the device.
+			_ = hb.send()
+			return
+		case <-hb.kick:
+		case <-tck.C:
+		}
+	}
+}
+
+func (hb *heartbeat) send() error {
+	hb.mu.Lock()
+	beat := hb.cur
+	hb.mu.Unlock()
+
+	beat.Time = time.Now().UTC()
+	buf, err := json.Marshal(beat)
+	if err != nil {
+		return fmt.Errorf("eda: could not marshal heartbeat: %w", err)
+	}
+	buf = append(buf, '\n')
+
+	_ = hb.conn.SetWriteDeadline(time.Now().Add(hb.freq))
+	_, err = hb.conn.Write(buf)
+	if err != nil {
+		return fmt.Errorf("eda: could not write heartbeat: %w", err)
+	}
+	return nil
+}
+
+// close stops sending heartbeats.
+func (hb *heartbeat) close() {
+	if hb == nil {
+		return
+	}
+	close(hb.quit)
+	<-hb.done
+}
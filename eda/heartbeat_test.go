@@ -0,0 +1,134 @@
+// Copyright 2020 The go-lpc Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package eda
+
+import (
+	"bufio"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"log"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestHeartbeat(t *testing.T) {
+	srv, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("could not create eda-ctl listener: %+v", err)
+	}
+	defer srv.Close()
+
+	dev := &Device{
+		msg: log.New(ioutil.Discard, "", 0),
+		cfg: newConfig(),
+	}
+
+	// no eda-ctl.
+	err = dev.notifyReady()
+	if err != nil {
+		t.Fatalf("could not notify ready: %+v", err)
+	}
+	if dev.beat != nil {
+		t.Fatalf("unexpected heartbeats")
+	}
+	dev.beat.setState(StateRunning) // no-op.
+
+	WithCtlAddr(srv.Addr().String())(&dev.cfg)
+	WithHeartbeat(time.Hour)(&dev.cfg)
+
+	errc := make(chan error, 1)
+	go func() {
+		errc <- dev.notifyReady()
+	}()
+
+	conn, err := srv.Accept()
+	if err != nil {
+		t.Fatalf("could not accept connection: %+v", err)
+	}
+	defer conn.Close()
+
+	err = <-errc
+	if err != nil {
+		t.Fatalf("could not notify ready: %+v", err)
+	}
+	defer dev.Close()
+
+	r := bufio.NewReader(conn)
+	ready := make([]byte, len("eda-ready"))
+	_, err = io.ReadFull(r, ready)
+	if err != nil {
+		t.Fatalf("could not read ready message: %+v", err)
+	}
+	if got, want := string(ready), "eda-ready"; got != want {
+		t.Fatalf("invalid ready message: got=%q, want=%q", got, want)
+	}
+
+	next := func() Heartbeat {
+		t.Helper()
+		_ = conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+		line, err := r.ReadBytes('\n')
+		if err != nil {
+			t.Fatalf("could not read heartbeat: %+v", err)
+		}
+		var beat Heartbeat
+		err = json.Unmarshal(line, &beat)
+		if err != nil {
+			t.Fatalf("could not decode heartbeat %q: %+v", line, err)
+		}
+		if beat.Time.IsZero() {
+			t.Fatalf("missing heartbeat time: %q", line)
+		}
+		beat.Time = time.Time{}
+		return beat
+	}
+
+	if got, want := next(), (Heartbeat{State: StateReady}); got != want {
+		t.Fatalf("invalid heartbeat:\ngot= %+v\nwant=%+v", got, want)
+	}
+
+	// counters updates are sent with the next periodic heartbeat,
+	// state changes right away.
+	dev.beat.update(func(b *Heartbeat) { b.Cycles = 1 })
+	dev.beat.update(func(b *Heartbeat) {
+		*b = Heartbeat{State: StateRunning, Run: 42, Cycles: 12, Bytes: 1024}
+	})
+	if got, want := next(), (Heartbeat{State: StateRunning, Run: 42, Cycles: 12, Bytes: 1024}); got != want {
+		t.Fatalf("invalid heartbeat:\ngot= %+v\nwant=%+v", got, want)
+	}
+
+	// already connected: only the state is updated.
+	err = dev.notifyReady()
+	if err != nil {
+		t.Fatalf("could not notify ready: %+v", err)
+	}
+	if got, want := next(), (Heartbeat{State: StateReady, Run: 42, Cycles: 12, Bytes: 1024}); got != want {
+		t.Fatalf("invalid heartbeat:\ngot= %+v\nwant=%+v", got, want)
+	}
+
+	// last heartbeat when the device is closed.
+	dev.beat.update(func(b *Heartbeat) { b.State = StateStopped })
+	dev.beat.close()
+	dev.beat = nil
+
+	var last Heartbeat
+	for {
+		line, err := r.ReadBytes('\n')
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatalf("could not read heartbeat: %+v", err)
+		}
+		err = json.Unmarshal(line, &last)
+		if err != nil {
+			t.Fatalf("could not decode heartbeat %q: %+v", line, err)
+		}
+	}
+	if got, want := last.State, StateStopped; got != want {
+		t.Fatalf("invalid last state: got=%q, want=%q", got, want)
+	}
+}
@@ -66,6 +66,17 @@ const (
 	LW_H2F_PIO_CNT48_LSB = 0x00010230
 	LW_H2F_PIO_CNT24     = 0x00010220
 
+	// firmware version word: major (bits 31-24), minor (bits 23-16) and
+	// feature flags (bits 15-0).
+	// this register is not defined by the released firmwares: its address
+	// is provisional and it is only read when the firmware check is
+	// enabled (see eda.WithFirmwareCheck).
+	LW_H2F_PIO_FW_VERSION = 0x000102A0
+
+	SHIFT_FW_MAJOR = 24
+	SHIFT_FW_MINOR = 16
+	MASK_FW_FEATS  = 0x0000ffff
+
 	// masks for PIO_STATE_IN
 	O_HR_TRANSMITON_0 = 0x00000001
 	O_CHIPSAT_0       = 0x00000002
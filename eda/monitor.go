@@ -0,0 +1,190 @@
+// Copyright 2020 The go-lpc Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package eda
+
+import (
+	"fmt"
+	"log"
+	"net"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	monitorQueue   = 8               // number of DIF blocks queued for a monitor sink
+	monitorTimeout = 2 * time.Second // timeout for sending a DIF block to a monitor sink
+)
+
+// AddDIFMonitor registers an additional sink for the data of the provided
+// DIF, e.g. an online monitor.
+//
+// Each DIF block sent to the primary sink of the DIF (see ConfigureDIF) is
+// also sent to its monitor sinks. Monitor sinks are served independently
+// of the primary sink: blocks are dropped for a monitor sink that is slow
+// or unreachable, the primary data path is never delayed.
+//
+// The DIF must have been booted. Monitor sinks are forgotten when the
+// device is booted again.
+func (dev *Device) AddDIFMonitor(addr string, dif uint8) error {
+	slot := dev.slotOf(dif)
+	if slot < 0 {
+		return fmt.Errorf("eda: could not add monitor to DIF=%d: DIF not booted", dif)
+	}
+	err := dev.checkMonitor(dif, dev.cfg.daq.addrs[slot], dev.cfg.daq.mons[slot], addr)
+	if err != nil {
+		return err
+	}
+
+	if dev.cfg.daq.mons == nil {
+		dev.cfg.daq.mons = make(map[int][]string)
+	}
+	dev.cfg.daq.mons[slot] = append(dev.cfg.daq.mons[slot], addr)
+	return nil
+}
+
+// checkMonitor checks addr can be registered as a monitor sink of the
+// provided DIF, given its primary sink and its registered monitor sinks.
+func (dev *Device) checkMonitor(dif uint8, primary string, mons []string, addr string) error {
+	if _, _, err := net.SplitHostPort(addr); err != nil {
+		return fmt.Errorf("eda: could not add monitor to DIF=%d: invalid address %q: %w", dif, addr, err)
+	}
+	if addr == primary {
+		return fmt.Errorf("eda: could not add monitor to DIF=%d: %q is the primary sink", dif, addr)
+	}
+	for _, v := range mons {
+		if v == addr {
+			return fmt.Errorf("eda: could not add monitor to DIF=%d: monitor %q already registered", dif, addr)
+		}
+	}
+	return nil
+}
+
+// serveMonitors starts the monitor sinks of the provided slot, replacing
+// the ones started by a previous initialization.
+func (dev *Device) serveMonitors(slot int) {
+	dev.closeMonitors(slot)
+
+	rfm := &dev.daq.rfm[slot]
+	for _, addr := range dev.cfg.daq.mons[slot] {
+		dev.msg.Printf("serving RFM(dif=%d, slot=%d) to monitor %q", rfm.id, slot, addr)
+		m := newMonitorSink(addr, rfm.id, dev.msg, dev.dialSink, monitorTimeout)
+		rfm.mons = append(rfm.mons, m)
+	}
+}
+
+// closeMonitors stops the monitor sinks of the provided slot.
+func (dev *Device) closeMonitors(slot int) {
+	rfm := &dev.daq.rfm[slot]
+	for _, m := range rfm.mons {
+		m.close()
+	}
+	rfm.mons = nil
+}
+
+// monitorSink sends copies of the DIF blocks of a RFM to a secondary sink.
+//
+// Blocks are queued and sent by a dedicated goroutine. Blocks are dropped
+// when the queue is full, or once the sink failed.
+type monitorSink struct {
+	dropped int64 // number of dropped blocks (first for 64b alignment of atomics)
+	sent    int64 // number of sent blocks
+
+	addr    string
+	dif     uint8
+	msg     *log.Logger
+	timeout time.Duration // timeout for sending a block
+
+	queue chan []byte
+	free  chan []byte // recycled blocks
+	done  chan struct{}
+}
+
+func newMonitorSink(addr string, dif uint8, msg *log.Logger, dial func(addr string) (net.Conn, error), timeout time.Duration) *monitorSink {
+	m := &monitorSink{
+		addr:    addr,
+		dif:     dif,
+		msg:     msg,
+		timeout: timeout,
+		queue:   make(chan []byte, monitorQueue),
+		free:    make(chan []byte, monitorQueue),
+		done:    make(chan struct{}),
+	}
+	go m.run(dial)
+	return m
+}
+
+// send queues a copy of the provided DIF block.
+// send never blocks.
+func (m *monitorSink) send(data []byte) {
+	var blk []byte
+	select {
+	case blk = <-m.free:
+	default:
+	}
+	blk = append(blk[:0], data...)
+
+	select {
+	case m.queue <- blk:
+	default:
+		atomic.AddInt64(&m.dropped, 1)
+		m.recycle(blk)
+	}
+}
+
+func (m *monitorSink) recycle(blk []byte) {
+	select {
+	case m.free <- blk:
+	default:
+	}
+}
+
+func (m *monitorSink) run(dial func(addr string) (net.Conn, error)) {
+	defer close(m.done)
+
+	conn, err := dial(m.addr)
+	if err != nil {
+		m.msg.Printf("could not connect to monitor %q of DIF=%d, disabling it: %+v", m.addr, m.dif, err)
+	}
+
+	buf := make([]byte, nMsgHdr)
+	for blk := range m.queue {
+		if conn == nil {
+			atomic.AddInt64(&m.dropped, 1)
+			m.recycle(blk)
+			continue
+		}
+
+		if m.timeout > 0 {
+			_ = conn.SetDeadline(time.Now().Add(m.timeout))
+		}
+		err = sendDIFBlock(conn, buf, blk)
+		m.recycle(blk)
+		if err != nil {
+			m.msg.Printf("could not send DIF data to monitor %q of DIF=%d, disabling it: %+v", m.addr, m.dif, err)
+			atomic.AddInt64(&m.dropped, 1)
+			_ = conn.Close()
+			conn = nil
+			continue
+		}
+		atomic.AddInt64(&m.sent, 1)
+	}
+
+	if conn != nil {
+		_ = conn.Close()
+	}
+}
+
+// close stops the monitor sink, once the queued blocks have been sent.
+func (m *monitorSink) close() {
+	close(m.queue)
+	<-m.done
+
+	if n := atomic.LoadInt64(&m.dropped); n > 0 {
+		m.msg.Printf(
+			"monitor %q of DIF=%d: %d block(s) dropped, %d sent",
+			m.addr, m.dif, n, atomic.LoadInt64(&m.sent),
+		)
+	}
+}
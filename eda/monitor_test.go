@@ -0,0 +1,254 @@
+// Copyright 2020 The go-lpc Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package eda
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"net"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/go-lpc/mim/conddb"
+)
+
+func TestAddDIFMonitor(t *testing.T) {
+	dev := Device{msg: log.New(ioutil.Discard, "", 0), cfg: newConfig()}
+	dev.daq.rfm = make([]rfmSink, nRFM)
+
+	err := dev.Boot([]conddb.RFM{{ID: 1, Slot: 2}, {ID: 2, Slot: 3}})
+	if err != nil {
+		t.Fatalf("could not boot device: %+v", err)
+	}
+	dev.cfg.daq.addrs[2] = "example.com:10001"
+
+	for _, tc := range []struct {
+		addr string
+		dif  uint8
+		err  string
+	}{
+		{addr: "mon:10001", dif: 1},
+		{addr: "mon:10002", dif: 2},
+		{addr: "[::1]:10001", dif: 1},
+		{addr: "mon:10003", dif: 3, err: "eda: could not add monitor to DIF=3: DIF not booted"},
+		{addr: "mon", dif: 1, err: `eda: could not add monitor to DIF=1: invalid address "mon": address mon: missing port in address`},
+		{addr: "example.com:10001", dif: 1, err: `eda: could not add monitor to DIF=1: "example.com:10001" is the primary sink`},
+		{addr: "mon:10001", dif: 1, err: `eda: could not add monitor to DIF=1: monitor "mon:10001" already registered`},
+	} {
+		t.Run(fmt.Sprintf("%s-dif=%d", tc.addr, tc.dif), func(t *testing.T) {
+			err := dev.AddDIFMonitor(tc.addr, tc.dif)
+			switch {
+			case err != nil && tc.err != "":
+				if got, want := err.Error(), tc.err; got != want {
+					t.Fatalf("invalid error:\ngot= %v\nwant=%v", got, want)
+				}
+				return
+			case err != nil:
+				t.Fatalf("could not add monitor: %+v", err)
+			case tc.err != "":
+				t.Fatalf("expected an error (%s)", tc.err)
+			}
+		})
+	}
+
+	if got, want := fmt.Sprint(dev.cfg.daq.mons), "map[2:[mon:10001 [::1]:10001] 3:[mon:10002]]"; got != want {
+		t.Fatalf("invalid monitors: got=%s, want=%s", got, want)
+	}
+
+	err = dev.Boot([]conddb.RFM{{ID: 1, Slot: 2}})
+	if err != nil {
+		t.Fatalf("could not boot device: %+v", err)
+	}
+	if dev.cfg.daq.mons != nil {
+		t.Fatalf("monitors not reset by boot: %v", dev.cfg.daq.mons)
+	}
+}
+
+// monitorServer is a DIF data sink recording the DIF blocks it receives.
+// Blocks are only acknowledged once release is closed.
+type monitorServer struct {
+	srv     net.Listener
+	release chan struct{}
+
+	mu   sync.Mutex
+	blks [][]byte
+}
+
+func newMonitorServer(t *testing.T) *monitorServer {
+	srv, err := net.Listen("tcp", "localhost:0")
+	if err != nil {
+		t.Fatalf("could not create monitor server: %+v", err)
+	}
+	mon := &monitorServer{srv: srv, release: make(chan struct{})}
+	go func() {
+		conn, err := srv.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+
+		ack := func() error {
+			<-mon.release
+			_, err := conn.Write([]byte("ACK\x00"))
+			return err
+		}
+
+		hdr := make([]byte, 8)
+		for {
+			_, err := io.ReadFull(conn, hdr)
+			if err != nil || ack() != nil {
+				return
+			}
+			blk := make([]byte, binary.LittleEndian.Uint32(hdr[4:]))
+			if len(blk) > 0 {
+				_, err = io.ReadFull(conn, blk)
+				if err != nil || ack() != nil {
+					return
+				}
+			}
+			mon.mu.Lock()
+			mon.blks = append(mon.blks, blk)
+			mon.mu.Unlock()
+		}
+	}()
+	return mon
+}
+
+func (mon *monitorServer) addr() string { return mon.srv.Addr().String() }
+
+func (mon *monitorServer) blocks() [][]byte {
+	mon.mu.Lock()
+	defer mon.mu.Unlock()
+	return mon.blks
+}
+
+func TestMonitorSink(t *testing.T) {
+	var (
+		msg  = new(strings.Builder)
+		logg = log.New(msg, "", 0)
+		dial = func(addr string) (net.Conn, error) {
+			return net.Dial("tcp", addr)
+		}
+	)
+
+	t.Run("ok", func(t *testing.T) {
+		srv := newMonitorServer(t)
+		defer srv.srv.Close()
+		close(srv.release)
+
+		m := newMonitorSink(srv.addr(), 1, logg, dial, time.Second)
+		blk := []byte("DIF-block")
+		for i := 0; i < 3; i++ {
+			m.send(blk)
+			// wait for the block to be sent before sending the next one.
+			for atomic.LoadInt64(&m.sent) != int64(i+1) {
+				time.Sleep(time.Millisecond)
+			}
+		}
+		blk[0] = 'X' // blocks are copied
+		m.close()
+
+		blks := srv.blocks()
+		if got, want := len(blks), 3; got != want {
+			t.Fatalf("invalid number of blocks: got=%d, want=%d", got, want)
+		}
+		for i, blk := range blks {
+			if !bytes.Equal(blk, []byte("DIF-block")) {
+				t.Fatalf("invalid block %d: %q", i, blk)
+			}
+		}
+		if m.dropped != 0 {
+			t.Fatalf("invalid number of dropped blocks: %d", m.dropped)
+		}
+	})
+
+	t.Run("slow", func(t *testing.T) {
+		srv := newMonitorServer(t)
+		defer srv.srv.Close()
+
+		m := newMonitorSink(srv.addr(), 1, logg, dial, 50*time.Millisecond)
+		start := time.Now()
+		const n = 10 * monitorQueue
+		for i := 0; i < n; i++ {
+			m.send([]byte("DIF-block"))
+		}
+		if d := time.Since(start); d > time.Second {
+			t.Fatalf("slow monitor delayed the sender: %v", d)
+		}
+		m.close()
+		close(srv.release)
+
+		if m.dropped < n-monitorQueue {
+			t.Fatalf("invalid number of dropped blocks: %d", m.dropped)
+		}
+		if m.sent != 0 {
+			t.Fatalf("invalid number of sent blocks: %d", m.sent)
+		}
+	})
+
+	t.Run("unreachable", func(t *testing.T) {
+		msg.Reset()
+		dial := func(addr string) (net.Conn, error) {
+			return nil, fmt.Errorf("no route to host")
+		}
+		m := newMonitorSink("mon:10001", 1, logg, dial, time.Second)
+		m.send([]byte("DIF-block"))
+		m.send([]byte("DIF-block"))
+		m.close()
+
+		if got, want := m.dropped, int64(2); got != want {
+			t.Fatalf("invalid number of dropped blocks: got=%d, want=%d", got, want)
+		}
+		want := `could not connect to monitor "mon:10001" of DIF=1, disabling it: no route to host
+monitor "mon:10001" of DIF=1: 2 block(s) dropped, 0 sent
+`
+		if got := msg.String(); got != want {
+			t.Fatalf("invalid log:\ngot:\n%s\nwant:\n%s", got, want)
+		}
+	})
+}
+
+func TestServeMonitors(t *testing.T) {
+	srv := newMonitorServer(t)
+	defer srv.srv.Close()
+	close(srv.release)
+
+	dev := Device{msg: log.New(ioutil.Discard, "", 0), cfg: newConfig()}
+	dev.daq.rfm = make([]rfmSink, nRFM)
+	err := dev.Boot([]conddb.RFM{{ID: 1, Slot: 2}})
+	if err != nil {
+		t.Fatalf("could not boot device: %+v", err)
+	}
+	err = dev.AddDIFMonitor(srv.addr(), 1)
+	if err != nil {
+		t.Fatalf("could not add monitor: %+v", err)
+	}
+
+	dev.serveMonitors(2)
+	old := dev.daq.rfm[2].mons
+
+	// initializing the device again replaces its monitor sinks.
+	dev.serveMonitors(2)
+	defer dev.closeMonitors(2)
+
+	if got, want := len(dev.daq.rfm[2].mons), 1; got != want {
+		t.Fatalf("invalid number of monitors: got=%d, want=%d", got, want)
+	}
+	select {
+	case <-old[0].done:
+	default:
+		t.Fatalf("previous monitor sink not closed")
+	}
+	if got, want := dev.daq.rfm[2].mons[0].timeout, monitorTimeout; got != want {
+		t.Fatalf("invalid send timeout: got=%v, want=%v", got, want)
+	}
+}
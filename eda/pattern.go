@@ -0,0 +1,106 @@
+// Copyright 2020 The go-lpc Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package eda
+
+import (
+	"fmt"
+	"time"
+)
+
+// testPattern synthesizes hardroc data words with a known counter
+// pattern, in place of the DAQ FIFO of an RFM.
+//
+// Each readout cycle holds the configured number of hardroc frames,
+// spread over the nHR hardrocs of the RFM (IDs 1 to nHR).
+// The words of the frames hold a running counter, starting at 0 at the
+// beginning of the run and incremented at each word. The first word of
+// a frame holds the hardroc ID in its upper 8 bits and the lower 24 bits
+// of the counter.
+type testPattern struct {
+	frames int    // number of hardroc frames per cycle
+	cnt    uint32 // running word counter
+	word   int    // index of the next word within the cycle
+}
+
+func (pat *testPattern) level() uint32 {
+	pat.word = 0
+	return uint32(pat.frames * nWordsPerHR)
+}
+
+func (pat *testPattern) next() uint32 {
+	var (
+		v = pat.cnt
+		i = pat.word
+	)
+	if i%nWordsPerHR == 0 {
+		hr := 1 + (i/nWordsPerHR)*nHR/pat.frames
+		v = uint32(hr)<<24 | v&0xffffff
+	}
+	pat.cnt++
+	pat.word++
+	return v
+}
+
+// patternWaiter paces the readout cycles of the test pattern mode.
+type patternWaiter struct {
+	dev *Device
+	thr throttle
+}
+
+func (w *patternWaiter) wait(cycle int) error {
+	if d := w.thr.delay(time.Now()); d > 0 {
+		tmr := time.NewTimer(d)
+		defer tmr.Stop()
+		select {
+		case <-w.dev.daq.done:
+			return errStopped
+		case <-tmr.C:
+		}
+	}
+	select {
+	case <-w.dev.daq.done:
+		return errStopped
+	default:
+	}
+	w.thr.start(time.Now())
+	return nil
+}
+
+// patternReader fills the DIF data buffers of the active RFMs with their
+// test pattern.
+type patternReader struct {
+	dev *Device
+}
+
+func (r patternReader) read(cycle *Cycle) error {
+	dev := r.dev
+	for _, slot := range dev.rfms {
+		dev.daqWriteDIFData(dev.daq.rfm[slot].w, slot)
+		dev.daqCheckOverflow(slot)
+	}
+	return nil
+}
+
+func (dev *Device) startRunPattern(run uint32) error {
+	for _, slot := range dev.rfms {
+		dev.daq.rfm[slot].src = &testPattern{frames: dev.cfg.daq.pattern.frames}
+	}
+
+	err := dev.cntReset()
+	if err != nil {
+		return fmt.Errorf("eda: could not reset counters: %w", err)
+	}
+
+	err = dev.cntStart()
+	if err != nil {
+		return fmt.Errorf("eda: could not start counters: %w", err)
+	}
+
+	dev.daq.done = make(chan int)
+	dev.daq.exit = make(chan struct{})
+
+	go dev.loop()
+	return nil
+}
@@ -0,0 +1,326 @@
+// Copyright 2020 The go-lpc Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package eda
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"testing"
+
+	"github.com/go-lpc/mim/eda/internal/regs"
+	"github.com/go-lpc/mim/internal/cbuf"
+	"github.com/go-lpc/mim/internal/eformat"
+)
+
+func TestTestPattern(t *testing.T) {
+	const frames = 10
+
+	dev := &Device{
+		msg: log.New(ioutil.Discard, "", 0),
+		cfg: newConfig(),
+	}
+	WithTestPattern(frames, 0)(&dev.cfg)
+
+	zero := reg32{r: func() uint32 { return 0 }}
+	dev.regs.pio.cnt24 = zero
+	dev.regs.pio.cnt48MSB = zero
+	dev.regs.pio.cnt48LSB = zero
+	dev.rfms = []int{2}
+	dev.daq.rfm = make([]rfmSink, nRFM)
+	for i := range dev.daq.rfm {
+		dev.regs.pio.cntHit0[i] = zero
+		dev.daq.rfm[i].slot = i
+		dev.daq.rfm[i].buf = make([]byte, nMsgHdr)
+		dev.daq.rfm[i].w = cbuf.New(daqBufferSize, daqBufferSize)
+		dev.daq.rfm[i].src = &testPattern{frames: frames}
+	}
+	dev.daq.rfm[2].id = 42
+	dev.daq.done = make(chan int, 1)
+
+	const ncycles = 3
+	var blks [][]byte
+	WithSender(SenderFunc(func(cycle *Cycle) error {
+		for _, blk := range cycle.DIFs {
+			blks = append(blks, append([]byte(nil), blk.Data...))
+		}
+		if cycle.Num == ncycles-1 {
+			dev.daq.done <- 1
+		}
+		return nil
+	}))(&dev.cfg)
+
+	p, err := dev.newPipeline()
+	if err != nil {
+		t.Fatalf("could not create pipeline: %+v", err)
+	}
+	dev.run(p)
+	if dev.err != nil {
+		t.Fatalf("could not run pipeline: %+v", dev.err)
+	}
+
+	if got, want := len(blks), ncycles; got != want {
+		t.Fatalf("invalid number of DIF blocks: got=%d, want=%d", got, want)
+	}
+
+	cnt := uint32(0)
+	for i, raw := range blks {
+		dec := eformat.NewDecoder(42, bytes.NewReader(raw))
+		dec.IsEDA = true
+		var dif eformat.DIF
+		err = dec.Decode(&dif)
+		if err != nil {
+			t.Fatalf("could not decode DIF block %d: %+v", i, err)
+		}
+		if got, want := len(dif.Frames), frames; got != want {
+			t.Fatalf("cycle %d: invalid number of frames: got=%d, want=%d", i, got, want)
+		}
+		for j, frame := range dif.Frames {
+			hr := uint8(1 + j*nHR/frames)
+			if frame.Header != hr {
+				t.Fatalf("cycle %d, frame %d: invalid hardroc ID: got=%d, want=%d", i, j, frame.Header, hr)
+			}
+			if got, want := frame.BCID, cnt&0xffffff; got != want {
+				t.Fatalf("cycle %d, frame %d: invalid BCID: got=%d, want=%d", i, j, got, want)
+			}
+			cnt += nWordsPerHR
+		}
+	}
+}
+
+func TestProvenanceTrailer(t *testing.T) {
+	dev := &Device{
+		msg: log.New(ioutil.Discard, "", 0),
+		cfg: newConfig(),
+		fw:  Firmware{Major: 1},
+	}
+	WithEDAID(2)(&dev.cfg)
+	WithProvenance(true)(&dev.cfg)
+
+	zero := reg32{r: func() uint32 { return 0 }}
+	dev.regs.pio.cnt24 = zero
+	dev.regs.pio.cnt48MSB = zero
+	dev.regs.pio.cnt48LSB = zero
+	dev.regs.pio.cntHit0[3] = zero
+	dev.rfms = []int{3}
+	dev.daq.rfm = make([]rfmSink, nRFM)
+	dev.daq.rfm[3] = rfmSink{
+		id:   42,
+		slot: 3,
+		buf:  make([]byte, nMsgHdr),
+		src:  &testPattern{frames: 2},
+	}
+
+	err := dev.initProvenance()
+	if err != nil {
+		t.Fatalf("could not create provenance trailers: %+v", err)
+	}
+
+	buf := new(bytes.Buffer)
+	dev.daqWriteDIFData(buf, 3)
+	dev.daqWriteDIFData(buf, 3)
+
+	dec := eformat.NewDecoder(42, buf)
+	dec.IsEDA = true
+	for i := 0; i < 2; i++ {
+		err = dec.Decode(new(eformat.DIF))
+		if err != nil {
+			t.Fatalf("could not decode DIF block %d: %+v", i, err)
+		}
+	}
+	_ = dec.Decode(new(eformat.DIF)) // consume last trailer.
+
+	prov, ok := dec.Provenance()
+	if !ok {
+		t.Fatalf("missing provenance trailer")
+	}
+	if prov.Board != 2 || prov.Slot != 3 || prov.Firmware != dev.fw.Word() || prov.Software == "" {
+		t.Fatalf("invalid provenance trailer: %#v", prov)
+	}
+
+	WithProvenance(false)(&dev.cfg)
+	err = dev.initProvenance()
+	if err != nil {
+		t.Fatalf("could not reset provenance trailers: %+v", err)
+	}
+	if dev.daq.rfm[3].prov != nil {
+		t.Fatalf("provenance trailer not reset")
+	}
+}
+
+func TestDualThreshold(t *testing.T) {
+	dev := &Device{
+		msg: log.New(ioutil.Discard, "", 0),
+		cfg: newConfig(),
+	}
+
+	var ctrl uint32
+	dev.regs.pio.ctrl = reg32{
+		r: func() uint32 { return ctrl },
+		w: func(v uint32) { ctrl = v },
+	}
+
+	cnt := func(v uint32) reg32 { return reg32{r: func() uint32 { return v }} }
+	dev.regs.pio.cnt24 = cnt(0)
+	dev.regs.pio.cnt48MSB = cnt(0)
+	dev.regs.pio.cnt48LSB = cnt(0)
+	dev.regs.pio.cntHit0[1] = cnt(11)
+	dev.regs.pio.cntHit1[1] = cnt(22)
+	dev.rfms = []int{1}
+	dev.daq.rfm = make([]rfmSink, nRFM)
+	dev.daq.rfm[1] = rfmSink{
+		id:   42,
+		slot: 1,
+		buf:  make([]byte, nMsgHdr),
+		src:  &testPattern{frames: 2},
+	}
+
+	for _, tc := range []struct {
+		trig uint8
+		dual bool
+		ctrl uint32
+		err  string
+	}{
+		{trig: 0, dual: false, ctrl: 0},
+		{trig: 1, dual: false, ctrl: regs.O_SEL_TRIG_THRESH},
+		{trig: 0, dual: true, ctrl: 0},
+		{trig: 1, dual: true, ctrl: regs.O_SEL_TRIG_THRESH},
+		{trig: 2, err: "eda: invalid trigger threshold 2 (valid: 0, 1)"},
+	} {
+		t.Run(fmt.Sprintf("trig=%d-dual=%v", tc.trig, tc.dual), func(t *testing.T) {
+			WithTriggerThreshold(tc.trig)(&dev.cfg)
+			WithDualThreshold(tc.dual)(&dev.cfg)
+
+			err := dev.trigSelectThreshold()
+			switch {
+			case err != nil && tc.err != "":
+				if got, want := err.Error(), tc.err; got != want {
+					t.Fatalf("invalid error:\ngot= %v\nwant=%v", got, want)
+				}
+				return
+			case err != nil:
+				t.Fatalf("could not select trigger threshold: %+v", err)
+			case tc.err != "":
+				t.Fatalf("expected an error (%s)", tc.err)
+			}
+
+			if got, want := ctrl&regs.O_SEL_TRIG_THRESH, tc.ctrl; got != want {
+				t.Fatalf("invalid control pio: got=0x%x, want=0x%x", got, want)
+			}
+
+			buf := new(bytes.Buffer)
+			dev.daq.rfm[1].cycle = 0
+			dev.daqWriteDIFData(buf, 1)
+
+			dec := eformat.NewDecoder(42, buf)
+			dec.IsEDA = true
+			var dif eformat.DIF
+			err = dec.Decode(&dif)
+			if err != nil {
+				t.Fatalf("could not decode DIF block: %+v", err)
+			}
+
+			// the header keeps the cycle counters.
+			hdr := dif.Header
+			if hdr.DTC != 0 || hdr.ATC != 0 || hdr.GTC != 0 || hdr.NbLines != 0 {
+				t.Fatalf("invalid header: %#v", hdr)
+			}
+
+			thr, ok := dec.Thresholds()
+			if ok != tc.dual {
+				t.Fatalf("invalid thresholds record presence: got=%v, want=%v", ok, tc.dual)
+			}
+			if !tc.dual {
+				return
+			}
+			want := eformat.Thresholds{Version: 1, Trig: tc.trig, Hit0: 11, Hit1: 22}
+			if thr != want {
+				t.Fatalf("invalid thresholds record:\ngot= %#v\nwant=%#v", thr, want)
+			}
+		})
+	}
+}
+
+func TestTriggerCounters(t *testing.T) {
+	dev := &Device{
+		msg: log.New(ioutil.Discard, "", 0),
+		cfg: newConfig(),
+	}
+
+	cnt := func(v uint32) reg32 { return reg32{r: func() uint32 { return v }} }
+	dev.regs.pio.cnt24 = cnt(0)
+	dev.regs.pio.cnt48MSB = cnt(0)
+	dev.regs.pio.cnt48LSB = cnt(0)
+	dev.regs.pio.cntTrig = cnt(7)
+	dev.regs.pio.cntHit0[1] = cnt(11)
+	dev.regs.pio.cntHit1[1] = cnt(22)
+	dev.rfms = []int{1}
+	dev.daq.rfm = make([]rfmSink, nRFM)
+	dev.daq.rfm[1] = rfmSink{
+		id:   42,
+		slot: 1,
+		buf:  make([]byte, nMsgHdr),
+		src:  &testPattern{frames: 1},
+	}
+
+	for _, tc := range []struct {
+		name   string
+		trig   string
+		legacy bool
+		dual   bool
+		want   [3]uint32 // DTC, ATC and GTC of the second cycle
+	}{
+		{name: "cycle", trig: "cycle", want: [3]uint32{1, 1, 1}},
+		{name: "fpga", trig: "fpga", want: [3]uint32{7, 7, 7}},
+		{name: "cycle-dual", trig: "cycle", dual: true, want: [3]uint32{1, 1, 1}},
+		{name: "fpga-dual", trig: "fpga", dual: true, want: [3]uint32{7, 7, 7}},
+		{name: "legacy", trig: "fpga", legacy: true, want: [3]uint32{2, 11, 2}},
+		{name: "legacy-dual", trig: "cycle", legacy: true, dual: true, want: [3]uint32{2, 11, 2}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			var trig TriggerCounter
+			err := trig.Set(tc.trig)
+			if err != nil {
+				t.Fatalf("could not set trigger counter: %+v", err)
+			}
+			if got, want := trig.String(), tc.trig; got != want {
+				t.Fatalf("invalid trigger counter name: got=%q, want=%q", got, want)
+			}
+
+			WithTriggerCounter(trig)(&dev.cfg)
+			WithLegacyCounters(tc.legacy)(&dev.cfg)
+			WithDualThreshold(tc.dual)(&dev.cfg)
+
+			buf := new(bytes.Buffer)
+			dev.daq.rfm[1].cycle = 0
+			dev.daqWriteDIFData(buf, 1)
+			buf.Reset()
+			dev.daqWriteDIFData(buf, 1)
+
+			dec := eformat.NewDecoder(42, buf)
+			dec.IsEDA = true
+			var dif eformat.DIF
+			err = dec.Decode(&dif)
+			if err != nil {
+				t.Fatalf("could not decode DIF block: %+v", err)
+			}
+
+			hdr := dif.Header
+			if got, want := [3]uint32{hdr.DTC, hdr.ATC, hdr.GTC}, tc.want; got != want {
+				t.Fatalf("invalid counters: got=%v, want=%v", got, want)
+			}
+			if got, want := dev.daq.rfm[1].trig, tc.want[0]; got != want {
+				t.Fatalf("invalid trigger counter: got=%d, want=%d", got, want)
+			}
+		})
+	}
+
+	var trig TriggerCounter
+	err := trig.Set("dcc")
+	if got, want := fmt.Sprint(err), `eda: invalid trigger counter "dcc" (valid: cycle, fpga)`; got != want {
+		t.Fatalf("invalid error:\ngot= %v\nwant=%v", got, want)
+	}
+}
@@ -0,0 +1,153 @@
+// Copyright 2020 The go-lpc Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package eda
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net"
+	"strconv"
+
+	"github.com/go-lpc/mim/conddb"
+)
+
+// scanPayload is the payload of a "scan" request: the RFMs to boot.
+type scanPayload []conddb.RFM
+
+// configurePayload is the payload of a "configure" request: the ASICs
+// configuration and data sinks of each DIF.
+type configurePayload []difPayload
+
+type difPayload struct {
+	DIF   int           `json:"dif"`
+	ASICs []conddb.ASIC `json:"asics"`
+
+	// Monitors are [addr]:port of sinks receiving
+	// a copy of the DIF data, e.g. online monitors.
+	Monitors []string `json:"monitors,omitempty"`
+}
+
+// decodePayload decodes the arguments of a request into v, rejecting
+// unknown fields.
+func decodePayload(raw *json.RawMessage, v interface{}) error {
+	if raw == nil {
+		return fmt.Errorf("missing arguments")
+	}
+	dec := json.NewDecoder(bytes.NewReader(*raw))
+	dec.DisallowUnknownFields()
+	return dec.Decode(v)
+}
+
+func decodeScan(raw *json.RawMessage) (scanPayload, error) {
+	var p scanPayload
+	err := decodePayload(raw, &p)
+	if err != nil {
+		return nil, fmt.Errorf("invalid scan payload: %w", err)
+	}
+	err = p.validate()
+	if err != nil {
+		return nil, fmt.Errorf("invalid scan payload: %w", err)
+	}
+	return p, nil
+}
+
+func (p scanPayload) validate() error {
+	var (
+		ids   = make(map[int]int, len(p))
+		slots = make(map[int]int, len(p))
+	)
+	for i, rfm := range p {
+		if rfm.ID < 0 || rfm.ID > 0xff {
+			return fmt.Errorf("rfm[%d]: invalid DIF ID %d (valid: 0-255)", i, rfm.ID)
+		}
+		if rfm.Slot < 0 || rfm.Slot >= nRFM {
+			return fmt.Errorf("rfm[%d]: invalid slot %d (valid: 0-%d)", i, rfm.Slot, nRFM-1)
+		}
+		err := rfm.DAQ.Validate()
+		if err != nil {
+			return fmt.Errorf("rfm[%d]: %w", i, err)
+		}
+		if j, dup := ids[rfm.ID]; dup {
+			return fmt.Errorf("rfm[%d]: DIF ID %d already used by rfm[%d]", i, rfm.ID, j)
+		}
+		if j, dup := slots[rfm.Slot]; dup {
+			return fmt.Errorf("rfm[%d]: slot %d already used by rfm[%d]", i, rfm.Slot, j)
+		}
+		ids[rfm.ID] = i
+		slots[rfm.Slot] = i
+	}
+	return nil
+}
+
+func decodeConfigure(raw *json.RawMessage) (configurePayload, error) {
+	var p configurePayload
+	err := decodePayload(raw, &p)
+	if err != nil {
+		return nil, fmt.Errorf("invalid configure payload: %w", err)
+	}
+	err = p.validate()
+	if err != nil {
+		return nil, fmt.Errorf("invalid configure payload: %w", err)
+	}
+	return p, nil
+}
+
+func (p configurePayload) validate() error {
+	difs := make(map[int]int, len(p))
+	for i, arg := range p {
+		if arg.DIF < 0 || arg.DIF > 0xff {
+			return fmt.Errorf("dif[%d]: invalid DIF ID %d (valid: 0-255)", i, arg.DIF)
+		}
+		if j, dup := difs[arg.DIF]; dup {
+			return fmt.Errorf("dif[%d]: DIF=%d already configured by dif[%d]", i, arg.DIF, j)
+		}
+		difs[arg.DIF] = i
+		if len(arg.ASICs) != nHR {
+			return fmt.Errorf(
+				"dif[%d]: invalid number of ASICs for DIF=%d (got=%d, want=%d)",
+				i, arg.DIF, len(arg.ASICs), nHR,
+			)
+		}
+		for _, mon := range arg.Monitors {
+			_, _, err := net.SplitHostPort(mon)
+			if err != nil {
+				return fmt.Errorf("dif[%d]: invalid monitor address for DIF=%d: %w", i, arg.DIF, err)
+			}
+		}
+	}
+	return nil
+}
+
+// difValidator is implemented by devices checking a DIF configuration
+// against their state, without applying it.
+type difValidator interface {
+	validateDIF(addr string, dif uint8, asics []conddb.ASIC, mons []string) error
+}
+
+var _ difValidator = (*Device)(nil)
+
+// check checks each DIF of the payload against the state of the device,
+// so that a configure request is applied as a whole or not at all.
+// The DIF data of each DIF is sent to the sink of its ID on host dim
+// (see difSink).
+func (p configurePayload) check(dev device, dim string) error {
+	v, ok := dev.(difValidator)
+	if !ok {
+		return nil
+	}
+	for i, arg := range p {
+		err := v.validateDIF(difSink(dim, arg.DIF), uint8(arg.DIF), arg.ASICs, arg.Monitors)
+		if err != nil {
+			return fmt.Errorf("dif[%d]: %w", i, err)
+		}
+	}
+	return nil
+}
+
+// difSink returns the address of the sink of the provided DIF on host dim.
+func difSink(dim string, dif int) string {
+	return net.JoinHostPort(dim, strconv.Itoa(10000+dif))
+}
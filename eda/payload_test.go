@@ -0,0 +1,230 @@
+// Copyright 2020 The go-lpc Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package eda
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"strings"
+	"testing"
+
+	"github.com/go-lpc/mim/conddb"
+)
+
+func TestDecodePayload(t *testing.T) {
+	asics := "[" + strings.TrimSuffix(strings.Repeat("{},", nHR), ",") + "]"
+
+	for _, tc := range []struct {
+		name string
+		kind string
+		raw  string
+		err  string
+	}{
+		{
+			name: "scan",
+			kind: "scan",
+			raw:  `[{"rfm":1, "eda":1, "slot":0}, {"rfm":2, "eda":1, "slot":3, "daq_state":{"rshaper":3, "trigger_type":1}}]`,
+		},
+		{
+			name: "scan-nil",
+			kind: "scan",
+			err:  "invalid scan payload: missing arguments",
+		},
+		{
+			name: "scan-unknown-field",
+			kind: "scan",
+			raw:  `[{"rfm":1, "slots":2}]`,
+			err:  `invalid scan payload: json: unknown field "slots"`,
+		},
+		{
+			name: "scan-slot",
+			kind: "scan",
+			raw:  `[{"rfm":1, "slot":0}, {"rfm":2, "slot":4}]`,
+			err:  "invalid scan payload: rfm[1]: invalid slot 4 (valid: 0-3)",
+		},
+		{
+			name: "scan-dif",
+			kind: "scan",
+			raw:  `[{"rfm":256}]`,
+			err:  "invalid scan payload: rfm[0]: invalid DIF ID 256 (valid: 0-255)",
+		},
+		{
+			name: "scan-rshaper",
+			kind: "scan",
+			raw:  `[{"rfm":1, "daq_state":{"rshaper":4}}]`,
+			err:  "invalid scan payload: rfm[0]: conddb: invalid R-shaper 4 (valid: 0-3)",
+		},
+		{
+			name: "scan-dup-slot",
+			kind: "scan",
+			raw:  `[{"rfm":1, "slot":2}, {"rfm":2, "slot":2}]`,
+			err:  "invalid scan payload: rfm[1]: slot 2 already used by rfm[0]",
+		},
+		{
+			name: "scan-dup-dif",
+			kind: "scan",
+			raw:  `[{"rfm":1, "slot":1}, {"rfm":1, "slot":2}]`,
+			err:  "invalid scan payload: rfm[1]: DIF ID 1 already used by rfm[0]",
+		},
+		{
+			name: "configure",
+			kind: "configure",
+			raw:  `[{"dif":1, "asics":` + asics + `, "monitors":["mon:10001"]}]`,
+		},
+		{
+			name: "configure-unknown-field",
+			kind: "configure",
+			raw:  `[{"dif":1, "asics":` + asics + `, "monitor":"mon:10001"}]`,
+			err:  `invalid configure payload: json: unknown field "monitor"`,
+		},
+		{
+			name: "configure-unknown-asic-field",
+			kind: "configure",
+			raw:  `[{"dif":1, "asics":[{"b3":1}]}]`,
+			err:  `invalid configure payload: json: unknown field "b3"`,
+		},
+		{
+			name: "configure-dif",
+			kind: "configure",
+			raw:  `[{"dif":300, "asics":` + asics + `}]`,
+			err:  "invalid configure payload: dif[0]: invalid DIF ID 300 (valid: 0-255)",
+		},
+		{
+			name: "configure-asics",
+			kind: "configure",
+			raw:  `[{"dif":1, "asics":` + asics + `}, {"dif":2, "asics":[{}]}]`,
+			err:  "invalid configure payload: dif[1]: invalid number of ASICs for DIF=2 (got=1, want=8)",
+		},
+		{
+			name: "configure-dup-dif",
+			kind: "configure",
+			raw:  `[{"dif":1, "asics":` + asics + `}, {"dif":1, "asics":` + asics + `}]`,
+			err:  "invalid configure payload: dif[1]: DIF=1 already configured by dif[0]",
+		},
+		{
+			name: "configure-monitor",
+			kind: "configure",
+			raw:  `[{"dif":1, "asics":` + asics + `, "monitors":["mon"]}]`,
+			err:  "invalid configure payload: dif[0]: invalid monitor address for DIF=1: address mon: missing port in address",
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			var raw *json.RawMessage
+			if tc.raw != "" {
+				msg := json.RawMessage(tc.raw)
+				raw = &msg
+			}
+
+			var err error
+			switch tc.kind {
+			case "scan":
+				_, err = decodeScan(raw)
+			case "configure":
+				_, err = decodeConfigure(raw)
+			default:
+				t.Fatalf("invalid payload kind %q", tc.kind)
+			}
+
+			switch {
+			case err != nil && tc.err != "":
+				if got, want := err.Error(), tc.err; got != want {
+					t.Fatalf("invalid error:\ngot= %s\nwant=%s", got, want)
+				}
+			case err != nil:
+				t.Fatalf("could not decode payload: %+v", err)
+			case tc.err != "":
+				t.Fatalf("expected an error (%s)", tc.err)
+			}
+		})
+	}
+}
+
+func TestCheckConfigurePayload(t *testing.T) {
+	dev := Device{msg: log.New(ioutil.Discard, "", 0), cfg: newConfig()}
+	dev.daq.rfm = make([]rfmSink, nRFM)
+
+	err := dev.Boot([]conddb.RFM{{ID: 1, Slot: 2}, {ID: 2, Slot: 3}})
+	if err != nil {
+		t.Fatalf("could not boot device: %+v", err)
+	}
+	err = dev.ConfigureDIF("dim:10001", 1, make([]conddb.ASIC, nHR))
+	if err != nil {
+		t.Fatalf("could not configure DIF: %+v", err)
+	}
+	err = dev.AddDIFMonitor("mon:1", 1)
+	if err != nil {
+		t.Fatalf("could not add monitor: %+v", err)
+	}
+
+	asics := make([]conddb.ASIC, nHR)
+	for _, tc := range []struct {
+		name string
+		p    configurePayload
+		err  string
+	}{
+		{
+			name: "ok",
+			p: configurePayload{
+				{DIF: 1, ASICs: asics, Monitors: []string{"mon:2"}},
+				{DIF: 2, ASICs: asics, Monitors: []string{"mon:1"}},
+			},
+		},
+		{
+			name: "not-booted",
+			p: configurePayload{
+				{DIF: 1, ASICs: asics},
+				{DIF: 3, ASICs: asics},
+			},
+			err: "dif[1]: eda: could not configure DIF=3: DIF not booted",
+		},
+		{
+			name: "primary-sink",
+			p: configurePayload{
+				{DIF: 1, ASICs: asics},
+				{DIF: 2, ASICs: asics, Monitors: []string{"dim:10002"}},
+			},
+			err: `dif[1]: eda: could not add monitor to DIF=2: "dim:10002" is the primary sink`,
+		},
+		{
+			name: "registered-monitor",
+			p: configurePayload{
+				{DIF: 2, ASICs: asics},
+				{DIF: 1, ASICs: asics, Monitors: []string{"mon:1"}},
+			},
+			err: `dif[1]: eda: could not add monitor to DIF=1: monitor "mon:1" already registered`,
+		},
+		{
+			name: "dup-monitor",
+			p: configurePayload{
+				{DIF: 2, ASICs: asics, Monitors: []string{"mon:2", "mon:2"}},
+			},
+			err: `dif[0]: eda: could not add monitor to DIF=2: monitor "mon:2" already registered`,
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			err := tc.p.check(&dev, "dim")
+			switch {
+			case err != nil && tc.err != "":
+				if got, want := err.Error(), tc.err; got != want {
+					t.Fatalf("invalid error:\ngot= %s\nwant=%s", got, want)
+				}
+			case err != nil:
+				t.Fatalf("could not check payload: %+v", err)
+			case tc.err != "":
+				t.Fatalf("expected an error (%s)", tc.err)
+			}
+		})
+	}
+
+	// checks leave the device untouched.
+	if got, want := fmt.Sprint(dev.cfg.daq.addrs), "map[2:dim:10001]"; got != want {
+		t.Fatalf("invalid sinks: got=%s, want=%s", got, want)
+	}
+	if got, want := fmt.Sprint(dev.cfg.daq.mons), "map[2:[mon:1]]"; got != want {
+		t.Fatalf("invalid monitors: got=%s, want=%s", got, want)
+	}
+}
@@ -8,8 +8,10 @@ import (
 	"bufio"
 	"bytes"
 	"encoding/binary"
+	"errors"
 	"fmt"
 	"io"
+	"net"
 	"os"
 	"strconv"
 	"strings"
@@ -18,16 +20,10 @@ import (
 	"github.com/go-lpc/mim/eda/internal/regs"
 	"github.com/go-lpc/mim/internal/eformat"
 	"github.com/go-lpc/mim/internal/mmap"
-	"golang.org/x/sys/unix"
 )
 
 func (dev *Device) mmapLwH2F() error {
-	data, err := unix.Mmap(
-		int(dev.mem.fd.Fd()),
-		regs.LW_H2F_BASE, regs.LW_H2F_SPAN,
-		unix.PROT_READ|unix.PROT_WRITE,
-		unix.MAP_SHARED,
-	)
+	data, err := dev.mapRegion(regs.LW_H2F_BASE, regs.LW_H2F_SPAN)
 	if err != nil {
 		return fmt.Errorf("eda: could not mmap lw-h2f: %w", err)
 	}
@@ -45,11 +41,7 @@ func (dev *Device) mmapLwH2F() error {
 }
 
 func (dev *Device) mmapH2F() error {
-	data, err := unix.Mmap(
-		int(dev.mem.fd.Fd()),
-		regs.H2F_BASE, regs.H2F_SPAN, unix.PROT_READ|unix.PROT_WRITE,
-		unix.MAP_SHARED,
-	)
+	data, err := dev.mapRegion(regs.H2F_BASE, regs.H2F_SPAN)
 	if err != nil {
 		return fmt.Errorf("eda: could not mmap h2f: %w", err)
 	}
@@ -358,73 +350,181 @@ func (dev *Device) readMask(fname string) error {
 	return nil
 }
 
+// readCtest reads the test capacitor switches (1=closed) of each channel,
+// for each HR of each RFM.
+func (dev *Device) readCtest(fname string) error {
+	f, err := os.Open(fname)
+	if err != nil {
+		return fmt.Errorf("eda: could not open Ctest file: %w", err)
+	}
+	defer f.Close()
+
+	var (
+		scan = bufio.NewScanner(f)
+		line int
+		rfm  uint32
+		hr   uint32
+		ch   uint32
+	)
+	for scan.Scan() {
+		line++
+		txt := strings.TrimSpace(scan.Text())
+		if strings.HasPrefix(txt, "#") || txt == "" {
+			continue
+		}
+		toks := strings.Split(txt, ";")
+		if len(toks) != 4 {
+			return fmt.Errorf(
+				"eda: invalid Ctest file:%d: line=%q",
+				line, txt,
+			)
+		}
+
+		v, err := strconv.ParseUint(toks[0], 10, 32)
+		if err != nil {
+			return fmt.Errorf(
+				"eda: could not parse RFM id %q (line:%d): %w",
+				toks[0], line, err,
+			)
+		}
+		if uint32(v) != rfm {
+			return fmt.Errorf(
+				"eda: invalid RFM id=%d (line:%d), want=%d",
+				v, line, rfm,
+			)
+		}
+
+		v, err = strconv.ParseUint(toks[1], 10, 32)
+		if err != nil {
+			return fmt.Errorf(
+				"eda: could not parse HR id %q (line:%d): %w",
+				toks[1], line, err,
+			)
+		}
+		if uint32(v) != hr {
+			return fmt.Errorf(
+				"eda: invalid HR id=%d (line:%d), want=%d",
+				v, line, hr,
+			)
+		}
+
+		v, err = strconv.ParseUint(toks[2], 10, 32)
+		if err != nil {
+			return fmt.Errorf(
+				"eda: could not parse chan %q (line:%d): %w",
+				toks[2], line, err,
+			)
+		}
+		if uint32(v) != ch {
+			return fmt.Errorf(
+				"eda: invalid chan id=%d (line:%d), want=%d",
+				v, line, ch,
+			)
+		}
+
+		v, err = strconv.ParseUint(toks[3], 10, 32)
+		if err != nil {
+			return fmt.Errorf(
+				"eda: could not parse Ctest for (RFM=%d,HR=%d,ch=%d) (line:%d:%q): %w",
+				rfm, hr, ch, line, toks[3], err,
+			)
+		}
+		if v > 1 {
+			return fmt.Errorf(
+				"eda: invalid Ctest value %d for (RFM=%d,HR=%d,ch=%d) (line:%d)",
+				v, rfm, hr, ch, line,
+			)
+		}
+		dev.cfg.ctest.table[nChans*(nHR*rfm+hr)+ch] = uint32(v)
+		ch++
+
+		if ch >= nChans {
+			ch = 0
+			hr++
+		}
+		if hr >= nHR {
+			hr = 0
+			rfm++
+		}
+	}
+
+	err = scan.Err()
+	if err != nil {
+		return fmt.Errorf("eda: error while parsing Ctest bits: %w", err)
+	}
+	dev.cfg.ctest.loaded = true
+
+	return nil
+}
+
 func (dev *Device) bindLwH2F() error {
-	dev.regs.pio.state = newReg32(dev, dev.mem.lw, regs.LW_H2F_PIO_STATE_IN)
-	dev.regs.pio.ctrl = newReg32(dev, dev.mem.lw, regs.LW_H2F_PIO_CTRL_OUT)
-	dev.regs.pio.pulser = newReg32(dev, dev.mem.lw, regs.LW_H2F_PIO_PULSER)
-
-	dev.regs.ramSC[0] = newHRCfg(dev, dev.mem.lw, regs.LW_H2F_RAM_SC_RFM0)
-	dev.regs.ramSC[1] = newHRCfg(dev, dev.mem.lw, regs.LW_H2F_RAM_SC_RFM1)
-	dev.regs.ramSC[2] = newHRCfg(dev, dev.mem.lw, regs.LW_H2F_RAM_SC_RFM2)
-	dev.regs.ramSC[3] = newHRCfg(dev, dev.mem.lw, regs.LW_H2F_RAM_SC_RFM3)
-
-	dev.regs.pio.chkSC[0] = newReg32(dev, dev.mem.lw, regs.LW_H2F_PIO_SC_CHECK_RFM0)
-	dev.regs.pio.chkSC[1] = newReg32(dev, dev.mem.lw, regs.LW_H2F_PIO_SC_CHECK_RFM1)
-	dev.regs.pio.chkSC[2] = newReg32(dev, dev.mem.lw, regs.LW_H2F_PIO_SC_CHECK_RFM2)
-	dev.regs.pio.chkSC[3] = newReg32(dev, dev.mem.lw, regs.LW_H2F_PIO_SC_CHECK_RFM3)
-
-	dev.regs.pio.cntHit0[0] = newReg32(dev, dev.mem.lw, regs.LW_H2F_PIO_CNT_HIT0_RFM0)
-	dev.regs.pio.cntHit0[1] = newReg32(dev, dev.mem.lw, regs.LW_H2F_PIO_CNT_HIT0_RFM1)
-	dev.regs.pio.cntHit0[2] = newReg32(dev, dev.mem.lw, regs.LW_H2F_PIO_CNT_HIT0_RFM2)
-	dev.regs.pio.cntHit0[3] = newReg32(dev, dev.mem.lw, regs.LW_H2F_PIO_CNT_HIT0_RFM3)
-
-	dev.regs.pio.cntHit1[0] = newReg32(dev, dev.mem.lw, regs.LW_H2F_PIO_CNT_HIT1_RFM0)
-	dev.regs.pio.cntHit1[1] = newReg32(dev, dev.mem.lw, regs.LW_H2F_PIO_CNT_HIT1_RFM1)
-	dev.regs.pio.cntHit1[2] = newReg32(dev, dev.mem.lw, regs.LW_H2F_PIO_CNT_HIT1_RFM2)
-	dev.regs.pio.cntHit1[3] = newReg32(dev, dev.mem.lw, regs.LW_H2F_PIO_CNT_HIT1_RFM3)
-
-	dev.regs.pio.cntTrig = newReg32(dev, dev.mem.lw, regs.LW_H2F_PIO_CNT_TRIG)
-	dev.regs.pio.cnt48MSB = newReg32(dev, dev.mem.lw, regs.LW_H2F_PIO_CNT48_MSB)
-	dev.regs.pio.cnt48LSB = newReg32(dev, dev.mem.lw, regs.LW_H2F_PIO_CNT48_LSB)
-	dev.regs.pio.cnt24 = newReg32(dev, dev.mem.lw, regs.LW_H2F_PIO_CNT24)
+	dev.regs.pio.state = newReg32(dev, dev.mem.lw, "LW_H2F_PIO_STATE_IN", regs.LW_H2F_PIO_STATE_IN)
+	dev.regs.pio.ctrl = newReg32(dev, dev.mem.lw, "LW_H2F_PIO_CTRL_OUT", regs.LW_H2F_PIO_CTRL_OUT)
+	dev.regs.pio.pulser = newReg32(dev, dev.mem.lw, "LW_H2F_PIO_PULSER", regs.LW_H2F_PIO_PULSER)
+
+	dev.regs.ramSC[0] = newHRCfg(dev, dev.mem.lw, "LW_H2F_RAM_SC_RFM0", regs.LW_H2F_RAM_SC_RFM0)
+	dev.regs.ramSC[1] = newHRCfg(dev, dev.mem.lw, "LW_H2F_RAM_SC_RFM1", regs.LW_H2F_RAM_SC_RFM1)
+	dev.regs.ramSC[2] = newHRCfg(dev, dev.mem.lw, "LW_H2F_RAM_SC_RFM2", regs.LW_H2F_RAM_SC_RFM2)
+	dev.regs.ramSC[3] = newHRCfg(dev, dev.mem.lw, "LW_H2F_RAM_SC_RFM3", regs.LW_H2F_RAM_SC_RFM3)
+
+	dev.regs.pio.chkSC[0] = newReg32(dev, dev.mem.lw, "LW_H2F_PIO_SC_CHECK_RFM0", regs.LW_H2F_PIO_SC_CHECK_RFM0)
+	dev.regs.pio.chkSC[1] = newReg32(dev, dev.mem.lw, "LW_H2F_PIO_SC_CHECK_RFM1", regs.LW_H2F_PIO_SC_CHECK_RFM1)
+	dev.regs.pio.chkSC[2] = newReg32(dev, dev.mem.lw, "LW_H2F_PIO_SC_CHECK_RFM2", regs.LW_H2F_PIO_SC_CHECK_RFM2)
+	dev.regs.pio.chkSC[3] = newReg32(dev, dev.mem.lw, "LW_H2F_PIO_SC_CHECK_RFM3", regs.LW_H2F_PIO_SC_CHECK_RFM3)
+
+	dev.regs.pio.cntHit0[0] = newReg32(dev, dev.mem.lw, "LW_H2F_PIO_CNT_HIT0_RFM0", regs.LW_H2F_PIO_CNT_HIT0_RFM0)
+	dev.regs.pio.cntHit0[1] = newReg32(dev, dev.mem.lw, "LW_H2F_PIO_CNT_HIT0_RFM1", regs.LW_H2F_PIO_CNT_HIT0_RFM1)
+	dev.regs.pio.cntHit0[2] = newReg32(dev, dev.mem.lw, "LW_H2F_PIO_CNT_HIT0_RFM2", regs.LW_H2F_PIO_CNT_HIT0_RFM2)
+	dev.regs.pio.cntHit0[3] = newReg32(dev, dev.mem.lw, "LW_H2F_PIO_CNT_HIT0_RFM3", regs.LW_H2F_PIO_CNT_HIT0_RFM3)
+
+	dev.regs.pio.cntHit1[0] = newReg32(dev, dev.mem.lw, "LW_H2F_PIO_CNT_HIT1_RFM0", regs.LW_H2F_PIO_CNT_HIT1_RFM0)
+	dev.regs.pio.cntHit1[1] = newReg32(dev, dev.mem.lw, "LW_H2F_PIO_CNT_HIT1_RFM1", regs.LW_H2F_PIO_CNT_HIT1_RFM1)
+	dev.regs.pio.cntHit1[2] = newReg32(dev, dev.mem.lw, "LW_H2F_PIO_CNT_HIT1_RFM2", regs.LW_H2F_PIO_CNT_HIT1_RFM2)
+	dev.regs.pio.cntHit1[3] = newReg32(dev, dev.mem.lw, "LW_H2F_PIO_CNT_HIT1_RFM3", regs.LW_H2F_PIO_CNT_HIT1_RFM3)
+
+	dev.regs.pio.cntTrig = newReg32(dev, dev.mem.lw, "LW_H2F_PIO_CNT_TRIG", regs.LW_H2F_PIO_CNT_TRIG)
+	dev.regs.pio.cnt48MSB = newReg32(dev, dev.mem.lw, "LW_H2F_PIO_CNT48_MSB", regs.LW_H2F_PIO_CNT48_MSB)
+	dev.regs.pio.cnt48LSB = newReg32(dev, dev.mem.lw, "LW_H2F_PIO_CNT48_LSB", regs.LW_H2F_PIO_CNT48_LSB)
+	dev.regs.pio.cnt24 = newReg32(dev, dev.mem.lw, "LW_H2F_PIO_CNT24", regs.LW_H2F_PIO_CNT24)
+	dev.regs.pio.fwVers = newReg32(dev, dev.mem.lw, "LW_H2F_PIO_FW_VERSION", regs.LW_H2F_PIO_FW_VERSION)
 
 	return dev.err
 }
 
 func (dev *Device) bindH2F() error {
-	dev.regs.fifo.daq[0] = newReg32(dev, dev.mem.h2f, regs.H2F_FIFO_DAQ_RFM0)
-	dev.regs.fifo.daq[1] = newReg32(dev, dev.mem.h2f, regs.H2F_FIFO_DAQ_RFM1)
-	dev.regs.fifo.daq[2] = newReg32(dev, dev.mem.h2f, regs.H2F_FIFO_DAQ_RFM2)
-	dev.regs.fifo.daq[3] = newReg32(dev, dev.mem.h2f, regs.H2F_FIFO_DAQ_RFM3)
+	dev.regs.fifo.daq[0] = newReg32(dev, dev.mem.h2f, "H2F_FIFO_DAQ_RFM0", regs.H2F_FIFO_DAQ_RFM0)
+	dev.regs.fifo.daq[1] = newReg32(dev, dev.mem.h2f, "H2F_FIFO_DAQ_RFM1", regs.H2F_FIFO_DAQ_RFM1)
+	dev.regs.fifo.daq[2] = newReg32(dev, dev.mem.h2f, "H2F_FIFO_DAQ_RFM2", regs.H2F_FIFO_DAQ_RFM2)
+	dev.regs.fifo.daq[3] = newReg32(dev, dev.mem.h2f, "H2F_FIFO_DAQ_RFM3", regs.H2F_FIFO_DAQ_RFM3)
 
-	dev.regs.fifo.daqCSR[0] = newDAQFIFO(dev, dev.mem.h2f, regs.H2F_FIFO_DAQ_CSR_RFM0)
-	dev.regs.fifo.daqCSR[1] = newDAQFIFO(dev, dev.mem.h2f, regs.H2F_FIFO_DAQ_CSR_RFM1)
-	dev.regs.fifo.daqCSR[2] = newDAQFIFO(dev, dev.mem.h2f, regs.H2F_FIFO_DAQ_CSR_RFM2)
-	dev.regs.fifo.daqCSR[3] = newDAQFIFO(dev, dev.mem.h2f, regs.H2F_FIFO_DAQ_CSR_RFM3)
+	dev.regs.fifo.daqCSR[0] = newDAQFIFO(dev, dev.mem.h2f, "H2F_FIFO_DAQ_CSR_RFM0", regs.H2F_FIFO_DAQ_CSR_RFM0)
+	dev.regs.fifo.daqCSR[1] = newDAQFIFO(dev, dev.mem.h2f, "H2F_FIFO_DAQ_CSR_RFM1", regs.H2F_FIFO_DAQ_CSR_RFM1)
+	dev.regs.fifo.daqCSR[2] = newDAQFIFO(dev, dev.mem.h2f, "H2F_FIFO_DAQ_CSR_RFM2", regs.H2F_FIFO_DAQ_CSR_RFM2)
+	dev.regs.fifo.daqCSR[3] = newDAQFIFO(dev, dev.mem.h2f, "H2F_FIFO_DAQ_CSR_RFM3", regs.H2F_FIFO_DAQ_CSR_RFM3)
 
 	return dev.err
 }
 
-func (dev *Device) readU32(r io.ReaderAt, off int64) uint32 {
+func (dev *Device) readU32(r io.ReaderAt, name string, off int64) uint32 {
 	if dev.err != nil {
 		return 0
 	}
-	_, dev.err = r.ReadAt(dev.buf[:4], off)
-	if dev.err != nil {
-		dev.err = fmt.Errorf("eda: could not read register 0x%x: %w", off, dev.err)
+	_, err := r.ReadAt(dev.buf[:4], off)
+	if err != nil {
+		dev.err = &HWError{Op: "read", Reg: name, Addr: off, Err: err}
 		return 0
 	}
 	return binary.LittleEndian.Uint32(dev.buf[:4])
 }
 
-func (dev *Device) writeU32(w io.WriterAt, off int64, v uint32) {
+func (dev *Device) writeU32(w io.WriterAt, name string, off int64, v uint32) {
 	if dev.err != nil {
 		return
 	}
 	binary.LittleEndian.PutUint32(dev.buf[:4], v)
-	_, dev.err = w.WriteAt(dev.buf[:4], off)
-	if dev.err != nil {
-		dev.err = fmt.Errorf("eda: could not write register 0x%x: %w", off, dev.err)
+	_, err := w.WriteAt(dev.buf[:4], off)
+	if err != nil {
+		dev.err = &HWError{Op: "write", Reg: name, Addr: off, Err: err}
 		return
 	}
 }
@@ -749,28 +849,40 @@ func (dev *Device) syncEnableDCCRAMFull() error {
 	return nil
 }
 
-// func (dev *Device) trigSelectThreshold0() error {
-// 	ctrl := dev.regs.pio.ctrl.r()
-// 	ctrl &= ^uint32(regs.O_SEL_TRIG_THRESH)
-// 	dev.regs.pio.ctrl.w(ctrl)
-//
-// 	if dev.err != nil {
-// 		return fmt.Errorf("eda: could not select threshold-0: %w", dev.err)
-// 	}
-// 	return nil
-// }
-//
-// func (dev *Device) trigSelectThreshold1() error {
-// 	ctrl := dev.regs.pio.ctrl.r()
-// 	ctrl |= regs.O_SEL_TRIG_THRESH
-// 	dev.regs.pio.ctrl.w(ctrl)
-//
-// 	if dev.err != nil {
-// 		return fmt.Errorf("eda: could not select threshold-1: %w", dev.err)
-// 	}
-// 	return nil
-// }
-//
+func (dev *Device) trigSelectThreshold0() error {
+	ctrl := dev.regs.pio.ctrl.r()
+	ctrl &= ^uint32(regs.O_SEL_TRIG_THRESH)
+	dev.regs.pio.ctrl.w(ctrl)
+
+	if dev.err != nil {
+		return fmt.Errorf("eda: could not select threshold-0: %w", dev.err)
+	}
+	return nil
+}
+
+func (dev *Device) trigSelectThreshold1() error {
+	ctrl := dev.regs.pio.ctrl.r()
+	ctrl |= regs.O_SEL_TRIG_THRESH
+	dev.regs.pio.ctrl.w(ctrl)
+
+	if dev.err != nil {
+		return fmt.Errorf("eda: could not select threshold-1: %w", dev.err)
+	}
+	return nil
+}
+
+// trigSelectThreshold selects the discriminator of the hardrocs whose hits
+// trigger the readout.
+func (dev *Device) trigSelectThreshold() error {
+	switch dev.cfg.daq.thresh.trig {
+	case 0:
+		return dev.trigSelectThreshold0()
+	case 1:
+		return dev.trigSelectThreshold1()
+	}
+	return fmt.Errorf("eda: invalid trigger threshold %d (valid: 0, 1)", dev.cfg.daq.thresh.trig)
+}
+
 // func (dev *Device) trigEnable() error {
 // 	ctrl := dev.regs.pio.ctrl.r()
 // 	ctrl |= regs.O_ENA_TRIG
@@ -1164,11 +1276,11 @@ func (dev *Device) hrscWriteConfHRs(fname string) error {
 	return nil
 }
 
-// // hrscSetCtest switches the test capacitor (1=closed).
-// func (dev *Device) hrscSetCtest(hr, ch, v uint32) {
-// 	dev.hrscSetBit(hr, ch, v&0x01)
-// }
-//
+// hrscSetCtest switches the test capacitor (1=closed).
+func (dev *Device) hrscSetCtest(hr, ch, v uint32) {
+	dev.hrscSetBit(hr, ch, v&0x01)
+}
+
 // func (dev *Device) hrscSetAllCtestOff() {
 // 	for hr := uint32(0); hr < nHR; hr++ {
 // 		for ch := uint32(0); ch < nChans; ch++ {
@@ -1288,6 +1400,7 @@ func (dev *Device) hrscSetConfig(rfm int) error {
 			rfm, err,
 		)
 	}
+	dev.recordSC(rfm)
 
 	// trigger the slow control serializer
 	err = dev.hrscStartSC(rfm)
@@ -1299,9 +1412,9 @@ func (dev *Device) hrscSetConfig(rfm int) error {
 	}
 
 	// check loop-back header
-	time.Sleep(10 * time.Microsecond)
-	for !dev.hrscSCDone(rfm) {
-		time.Sleep(10 * time.Microsecond)
+	err = dev.hrscWaitSCDone(rfm)
+	if err != nil {
+		return err
 	}
 
 	chk := dev.regs.pio.chkSC[rfm].r()
@@ -1314,8 +1427,8 @@ func (dev *Device) hrscSetConfig(rfm int) error {
 
 	if chk != ctrl {
 		return fmt.Errorf(
-			"eda: invalid loopback register (rfm=%d): got=0x%x, want=0x%x",
-			rfm, chk, ctrl,
+			"%w (rfm=%d): got=0x%x, want=0x%x",
+			errSCLoopBack, rfm, chk, ctrl,
 		)
 	}
 
@@ -1323,6 +1436,14 @@ func (dev *Device) hrscSetConfig(rfm int) error {
 }
 
 func (dev *Device) hrscResetReadRegisters(rfm int) error {
+	return dev.hrscSetReadRegister(rfm, -1, -1)
+}
+
+// hrscSetReadRegister routes the channel ch of the hardroc hr of the RFM
+// slot rfm to the hit counters of that slot, via the read-registers of
+// the hardrocs.
+// No channel is routed if ch is negative.
+func (dev *Device) hrscSetReadRegister(rfm, hr, ch int) error {
 	ctrl := dev.regs.pio.chkSC[rfm].r()
 	if dev.err != nil {
 		return fmt.Errorf(
@@ -1337,14 +1458,8 @@ func (dev *Device) hrscResetReadRegisters(rfm int) error {
 	default:
 		ctrl = 0xcafefade
 	}
-	var (
-		buf [szCfgHR]byte
-		off = nHR*nBytesCfgHR - nChans
-	)
-	buf[off+0] = byte((ctrl >> 24) & 0xff)
-	buf[off+1] = byte((ctrl >> 16) & 0xff)
-	buf[off+2] = byte((ctrl >> 8) & 0xff)
-	buf[off+3] = byte(ctrl & 0xff)
+	var buf [szCfgHR]byte
+	rrImage(&buf, ctrl, hr, ch)
 
 	// reset sc
 	err := dev.hrscSelectReadRegister()
@@ -1376,7 +1491,7 @@ func (dev *Device) hrscResetReadRegisters(rfm int) error {
 	}
 
 	// trigger the slow control serializer
-	time.Sleep(10 * time.Microsecond)
+	time.Sleep(dev.cfg.sc.timing.Poll)
 	err = dev.hrscStartSC(rfm)
 	if err != nil {
 		return fmt.Errorf(
@@ -1386,9 +1501,9 @@ func (dev *Device) hrscResetReadRegisters(rfm int) error {
 	}
 
 	// check loop-back header
-	time.Sleep(10 * time.Microsecond)
-	for !dev.hrscSCDone(rfm) {
-		time.Sleep(10 * time.Microsecond)
+	err = dev.hrscWaitSCDone(rfm)
+	if err != nil {
+		return err
 	}
 
 	chk := dev.regs.pio.chkSC[rfm].r()
@@ -1401,8 +1516,8 @@ func (dev *Device) hrscResetReadRegisters(rfm int) error {
 
 	if chk != ctrl {
 		return fmt.Errorf(
-			"eda: invalid loopback register (rfm=%d): got=0x%x, want=0x%x",
-			rfm, chk, ctrl,
+			"%w (rfm=%d): got=0x%x, want=0x%x",
+			errSCLoopBack, rfm, chk, ctrl,
 		)
 	}
 
@@ -1417,109 +1532,23 @@ func (dev *Device) hrscResetReadRegisters(rfm int) error {
 	return nil
 }
 
-// func (dev *Device) hrscSetReadRegister(rfm, ch int) error {
-// 	ctrl := dev.regs.pio.chkSC[rfm].r()
-// 	if dev.err != nil {
-// 		return fmt.Errorf(
-// 			"eda: could not read check-sc register (rfm=%d): %w",
-// 			rfm, dev.err,
-// 		)
-// 	}
-//
-// 	switch ctrl {
-// 	case 0xcafefade:
-// 		ctrl = 0x36baffe5
-// 	default:
-// 		ctrl = 0xcafefade
-// 	}
-// 	var (
-// 		buf [szCfgHR]byte
-// 		off = nHR*nBytesCfgHR - nChans
-// 	)
-// 	buf[off+0] = byte((ctrl >> 24) & 0xff)
-// 	buf[off+1] = byte((ctrl >> 16) & 0xff)
-// 	buf[off+2] = byte((ctrl >> 8) & 0xff)
-// 	buf[off+3] = byte(ctrl & 0xff)
-//
-// 	// reset sc
-// 	err := dev.hrscSelectReadRegister()
-// 	if err != nil {
-// 		return fmt.Errorf(
-// 			"eda: could select read-register (rfm=%d): %w",
-// 			rfm, err,
-// 		)
-// 	}
-//
-// 	err = dev.hrscResetSC()
-// 	if err != nil {
-// 		return fmt.Errorf("eda: could not reset slow-control (rfm=%d): %w",
-// 			rfm, err,
-// 		)
-// 	}
-//
-// 	if !dev.hrscSCDone(rfm) {
-// 		return fmt.Errorf("eda: could not reset slow control (rfm=%d)", rfm)
-// 	}
-//
-// 	// select the same channel for all HR's
-// 	var (
-// 		quo, rem = div(uint32(ch), nHR) // channels: 0->64*nHR-1
-// 		v        = byte(0x1 << rem)
-// 	)
-// 	for i := 0; i < nHR; i++ {
-// 		off := nHR*nBytesCfgHR + 3 - i*nHR - int(quo) // last byte -> channels [0,8)
-// 		buf[off] = v
-// 	}
-//
-// 	// copy to FPGA
-// 	_, err = dev.regs.ramSC[rfm].w(buf[:szCfgHR])
-// 	if err != nil {
-// 		return fmt.Errorf(
-// 			"eda: could not write slow-control cfg to FPGA (rfm=%d): %w",
-// 			rfm, err,
-// 		)
-// 	}
-//
-// 	// trigger the slow control serializer
-// 	err = dev.hrscStartSC(rfm)
-// 	if err != nil {
-// 		return fmt.Errorf(
-// 			"eda: could not start slow-control serializer (rfm=%d): %w",
-// 			rfm, err,
-// 		)
-// 	}
-//
-// 	// check loop-back header
-// 	time.Sleep(10 * time.Microsecond)
-// 	for !dev.hrscSCDone(rfm) {
-// 		time.Sleep(10 * time.Microsecond)
-// 	}
-//
-// 	chk := dev.regs.pio.chkSC[rfm].r()
-// 	if dev.err != nil {
-// 		return fmt.Errorf(
-// 			"eda: could not read slow-control loopback register (rfm=%d): %w",
-// 			rfm, dev.err,
-// 		)
-// 	}
-//
-// 	if chk != ctrl {
-// 		return fmt.Errorf(
-// 			"eda: invalid loopback register (rfm=%d): got=0x%x, want=0x%x",
-// 			rfm, chk, ctrl,
-// 		)
-// 	}
-//
-// 	err = dev.hrscSelectSlowControl()
-// 	if err != nil {
-// 		return fmt.Errorf(
-// 			"eda: could not select slow-control (rfm=%d): %w",
-// 			rfm, err,
-// 		)
-// 	}
-//
-// 	return nil
-// }
+// rrImage fills buf with the read-registers image of an RFM: the ctrl
+// loopback header followed by the nHR read-registers of nChans bits,
+// the last byte holding the channels [0,8) of the first hardroc.
+// Only the channel ch of the hardroc hr is selected, if ch is not negative.
+func rrImage(buf *[szCfgHR]byte, ctrl uint32, hr, ch int) {
+	off := nHR*nBytesCfgHR - nChans
+	buf[off+0] = byte((ctrl >> 24) & 0xff)
+	buf[off+1] = byte((ctrl >> 16) & 0xff)
+	buf[off+2] = byte((ctrl >> 8) & 0xff)
+	buf[off+3] = byte(ctrl & 0xff)
+
+	if ch < 0 {
+		return
+	}
+	const nBytesRR = nChans / 8 // size of the read-register of a hardroc
+	buf[szCfgHR-1-hr*nBytesRR-ch/8] = byte(1 << (ch % 8))
+}
 
 func (dev *Device) hrscResetSC() error {
 	ctrl := dev.regs.pio.ctrl.r()
@@ -1529,7 +1558,7 @@ func (dev *Device) hrscResetSC() error {
 		return fmt.Errorf("eda: could not reset slow-control: %w", dev.err)
 	}
 
-	time.Sleep(1 * time.Microsecond)
+	time.Sleep(dev.cfg.sc.timing.Pulse)
 
 	ctrl = dev.regs.pio.ctrl.r()
 	ctrl &= ^uint32(regs.O_RESET_SC)
@@ -1538,7 +1567,7 @@ func (dev *Device) hrscResetSC() error {
 		return fmt.Errorf("eda: could not reset slow-control: %w", dev.err)
 	}
 
-	time.Sleep(1 * time.Microsecond)
+	time.Sleep(dev.cfg.sc.timing.Pulse)
 
 	return nil
 }
@@ -1606,6 +1635,52 @@ func (dev *Device) hrscSCDone(rfm int) bool {
 	return (dev.regs.pio.state.r() & mask) == mask
 }
 
+// hrscWaitSCDone waits for the slow-control serializer of the provided RFM
+// to be done, polling its SC-done flag as configured with WithSCTiming.
+func (dev *Device) hrscWaitSCDone(rfm int) error {
+	var (
+		poll    = dev.cfg.sc.timing.Poll
+		timeout = dev.cfg.sc.timing.Timeout
+		end     = time.Now().Add(timeout)
+	)
+
+	time.Sleep(poll)
+	for !dev.hrscSCDone(rfm) {
+		if dev.err != nil {
+			return fmt.Errorf(
+				"eda: could not read slow-control state (rfm=%d): %w",
+				rfm, dev.err,
+			)
+		}
+		if time.Now().After(end) {
+			return fmt.Errorf("%w (rfm=%d, timeout=%v)", errSCTimeout, rfm, timeout)
+		}
+		time.Sleep(poll)
+	}
+	return nil
+}
+
+// hrscSend sends a slow-control configuration to the hardrocs of the
+// provided RFM with send.
+// Sending is attempted again after a timeout or a loop-back mismatch,
+// as configured with WithSCRetries.
+func (dev *Device) hrscSend(rfm int, send func(rfm int) error) error {
+	n := dev.cfg.sc.retries + 1
+	for i := 1; ; i++ {
+		err := send(rfm)
+		switch {
+		case err == nil:
+			return nil
+		case i >= n:
+			return err
+		case errors.Is(err, errSCLoopBack), errors.Is(err, errSCTimeout):
+			dev.msg.Printf("slow-control failed (rfm=%d, attempt %d/%d): %+v", rfm, i, n, err)
+		default:
+			return err
+		}
+	}
+}
+
 func bit32(word, digit uint32) uint32 {
 	return (word >> digit) & 0x1
 }
@@ -1619,6 +1694,10 @@ func bitU64(v uint64, pos uint32) uint8 {
 }
 
 func (dev *Device) daqFIFOInit(rfm int) error {
+	err := dev.needH2F("DAQ FIFO initialization")
+	if err != nil {
+		return err
+	}
 	fifo := &dev.regs.fifo.daqCSR[rfm]
 
 	// clear event reg (write 1 to each field)
@@ -1696,11 +1775,11 @@ func (dev *Device) daqFIFOInit(rfm int) error {
 // 	reg := dev.regs.fifo.daqCSR[rfm].r(regs.ALTERA_AVALON_FIFO_STATUS_REG)
 // 	return bit32(reg, 0) != 0
 // }
-//
-// func (dev *Device) daqFIFOEmpty(rfm int) bool {
-// 	reg := dev.regs.fifo.daqCSR[rfm].r(regs.ALTERA_AVALON_FIFO_STATUS_REG)
-// 	return bit32(reg, 1) != 0
-// }
+
+func (dev *Device) daqFIFOEmpty(rfm int) bool {
+	reg := dev.regs.fifo.daqCSR[rfm].r(regs.ALTERA_AVALON_FIFO_STATUS_REG)
+	return bit32(reg, 1) != 0
+}
 
 func (dev *Device) daqFIFOFillLevel(rfm int) uint32 {
 	return dev.regs.fifo.daqCSR[rfm].r(regs.ALTERA_AVALON_FIFO_LEVEL_REG)
@@ -1709,17 +1788,30 @@ func (dev *Device) daqFIFOFillLevel(rfm int) uint32 {
 // func (dev *Device) daqFIFOData(rfm int) uint32 {
 // 	return dev.regs.fifo.daqCSR[rfm].r(regs.ALTERA_AVALON_FIFO_DATA_REG)
 // }
-//
-// func (dev *Device) daqFIFOClear(rfm int) uint32 {
-// 	var cnt uint32
-// 	for !dev.daqFIFOEmpty(rfm) {
-// 		// consume FIFO, one word at a time.
-// 		_ = dev.regs.fifo.daq[rfm].r() // FIFO_DATA_REG==0
-// 		cnt++
-// 	}
-// 	return cnt / 5
-// }
-//
+
+// daqFIFOClear consumes the DAQ FIFO of the provided RFM and returns the
+// number of discarded words.
+// At most max words are consumed, so a FIFO still being filled by the
+// FPGA can not stall the caller.
+func (dev *Device) daqFIFOClear(rfm int, max uint32) (uint32, error) {
+	var cnt uint32
+	for !dev.daqFIFOEmpty(rfm) && dev.err == nil {
+		if cnt >= max {
+			return cnt, fmt.Errorf(
+				"eda: DAQ FIFO (rfm=%d) not empty after %d words",
+				rfm, cnt,
+			)
+		}
+		// consume FIFO, one word at a time.
+		_ = dev.regs.fifo.daq[rfm].r() // FIFO_DATA_REG==0
+		cnt++
+	}
+	if dev.err != nil {
+		return cnt, fmt.Errorf("eda: could not clear DAQ FIFO (rfm=%d): %w", rfm, dev.err)
+	}
+	return cnt, nil
+}
+
 // // daqWriteHRData writes the hardroc data for rfm to the provided writer
 // // and returns the number of events read.
 // //
@@ -1825,10 +1917,55 @@ func (dev *Device) daqFIFOFillLevel(rfm int) uint32 {
 // 	return nRAMUnits
 // }
 
+// daqWriteThresholds writes the thresholds record of the current readout
+// cycle of the provided slot, when requested by the configuration.
+func (dev *Device) daqWriteThresholds(w io.Writer, slot int) {
+	if !dev.cfg.daq.thresh.dual {
+		return
+	}
+	thr := eformat.Thresholds{
+		Trig: dev.cfg.daq.thresh.trig,
+		Hit0: dev.cntHit0(slot),
+		Hit1: dev.cntHit1(slot),
+	}
+	_, _ = thr.WriteTo(w)
+}
+
+// daqCounters returns the DTC, ATC and GTC fields of the DIF header of the
+// current readout cycle of the provided slot.
+func (dev *Device) daqCounters(slot int) (dtc, atc, gtc uint32) {
+	rfm := &dev.daq.rfm[slot]
+	switch {
+	case dev.cfg.daq.cnt.legacy:
+		dtc, atc, gtc = rfm.cycle+1, dev.cntHit0(slot), rfm.cycle+1
+	case dev.cfg.daq.cnt.trig == FPGACounter:
+		v := dev.cntTrig()
+		dtc, atc, gtc = v, v, v
+	default:
+		dtc, atc, gtc = rfm.cycle, rfm.cycle, rfm.cycle
+	}
+	return dtc, atc, gtc
+}
+
+// hrSource provides the hardroc data words of an RFM readout cycle.
+type hrSource interface {
+	level() uint32 // number of data words of the cycle
+	next() uint32  // next data word
+}
+
+// fifoSource reads hardroc data words from the DAQ FIFO of an RFM.
+type fifoSource struct {
+	dev  *Device
+	slot int
+}
+
+func (src fifoSource) level() uint32 { return src.dev.daqFIFOFillLevel(src.slot) }
+func (src fifoSource) next() uint32  { return src.dev.regs.fifo.daq[src.slot].r() }
+
 func (dev *Device) daqWriteDIFData(w io.Writer, slot int) {
 	var (
 		rfm  = &dev.daq.rfm[slot]
-		fifo = &dev.regs.fifo.daq[slot]
+		fifo = rfm.src
 		wU8  = func(v uint8) {
 			rfm.buf[0] = v
 			_, _ = w.Write(rfm.buf[:1])
@@ -1849,27 +1986,32 @@ func (dev *Device) daqWriteDIFData(w io.Writer, slot int) {
 	}
 	bcid48Offset := rfm.bcid
 
+	dev.daqWriteThresholds(w, slot)
+
 	// DIF DAQ header
 	wU8(0xB0)
 	wU8(dev.daq.rfm[slot].id)
 	// counters
-	wU32(rfm.cycle + 1) // FIXME(sbinet): off-by-one ?
-	wU32(dev.cntHit0(slot))
-	//wU32(dev.cntHit1(rfm)) // FIXME(sbinet): hack
-	wU32(rfm.cycle + 1) // FIXME(sbinet): hack (and off-by-one?)
+	dtc, atc, gtc := dev.daqCounters(slot)
+	rfm.trig = dtc
+	wU32(dtc)
+	wU32(atc)
+	wU32(gtc)
 	// assemble and correct absolute BCID
 	bcid48 := uint64(dev.cntBCID48MSB())
 	bcid48 <<= 32
 	bcid48 |= uint64(dev.cntBCID48LSB())
 	bcid48 -= uint64(bcid48Offset)
+	rfm.abs = bcid48
 	// copy frame
 	wU16(uint16(bcid48>>32) & 0xffff)
 	wU32(uint32(bcid48))
 	bcid24 := dev.cntBCID24()
 	wU8(uint8(bcid24 >> 16))
 	wU16(uint16(bcid24 & 0xffff))
-	// unused "nb-lines"
-	wU8(0xff)
+	// nb-lines: number of analog lines in the upper nibble.
+	// EDA boards have no temperature sensors: always use the 0xB0 header.
+	wU8(dev.cfg.daq.nlines << 4)
 
 	// HR DAQ chunk
 	var (
@@ -1878,12 +2020,14 @@ func (dev *Device) daqWriteDIFData(w io.Writer, slot int) {
 	)
 	wU8(0xB4) // HR header
 
-	const nWordsPerHR = 5
-	n := int(dev.daqFIFOFillLevel(slot) / nWordsPerHR)
+	if fifo == nil {
+		fifo = fifoSource{dev, slot}
+	}
+	n := int(fifo.level() / nWordsPerHR)
 
 	for i := 0; i < n; i++ {
 		// read HR ID
-		id := fifo.r()
+		id := fifo.next()
 		hrID = int(id >> 24)
 		// insert trailer and header if new hardroc ID
 		if hrID != lastHR {
@@ -1893,61 +2037,99 @@ func (dev *Device) daqWriteDIFData(w io.Writer, slot int) {
 			}
 		}
 		wU32(id)
-		wU32(fifo.r())
-		wU32(fifo.r())
-		wU32(fifo.r())
-		wU32(fifo.r())
+		wU32(fifo.next())
+		wU32(fifo.next())
+		wU32(fifo.next())
+		wU32(fifo.next())
 		lastHR = hrID
 	}
 	wU8(0xA3)    // last HR trailer
 	wU8(0xA0)    // DIF DAQ trailer
 	wU16(0xC0C0) // fake CRC
+	if rfm.prov != nil {
+		_, _ = w.Write(rfm.prov)
+	}
 
 	rfm.cycle++
 }
 
-func (dev *Device) daqSendDIFData(i int) error {
-	var (
-		sink = &dev.daq.rfm[i]
-		buf  = sink.buf
-		w    = sink.w
-		sck  = sink.sck
-	)
-	defer func() {
-		w.c = 0
-	}()
-
-	errorf := func(format string, args ...interface{}) error {
-		err := fmt.Errorf(format, args...)
+func (dev *Device) daqSendDIFData(slot int, data []byte) error {
+	sink := &dev.daq.rfm[slot]
+	err := sendDIFBlock(sink.sck, sink.buf, data)
+	if err != nil {
 		dev.msg.Printf("%+v", err)
 		return err
 	}
+	sink.sent.blocks++
+	sink.sent.bytes += int64(len(data))
+
+	if false {
+		_, _ = dev.daq.f.Write(data)
+		dec := eformat.NewDecoder(sink.id, bytes.NewReader(data))
+		dec.IsEDA = true
+		var d eformat.DIF
+		err = dec.Decode(&d)
+		if err != nil {
+			dev.msg.Printf("could not decode DIF: %+v", err)
+		} else {
+			wbuf := dev.msg.Writer()
+			fmt.Fprintf(wbuf, "=== DIF-ID 0x%x ===\n", d.Header.ID)
+			fmt.Fprintf(wbuf, "DIF trigger: % 10d\n", d.Header.DTC)
+			fmt.Fprintf(wbuf, "ACQ trigger: % 10d\n", d.Header.ATC)
+			fmt.Fprintf(wbuf, "Gbl trigger: % 10d\n", d.Header.GTC)
+			fmt.Fprintf(wbuf, "Abs BCID:    % 10d\n", d.Header.AbsBCID)
+			fmt.Fprintf(wbuf, "Time DIF:    % 10d\n", d.Header.TimeDIFTC)
+			fmt.Fprintf(wbuf, "Frames:      % 10d\n", len(d.Frames))
+
+			for _, frame := range d.Frames {
+				fmt.Fprintf(wbuf, "  hroc=0x%02x BCID=% 8d %x\n",
+					frame.Header, frame.BCID, frame.Data,
+				)
+			}
+		}
+	}
 
+	return nil
+}
+
+// sendDIFBlock sends a DIF block to a DIF data sink: a header with the
+// size of the block, followed by the block itself, each of them
+// acknowledged by the sink.
+// buf is a scratch buffer of at least nMsgHdr bytes.
+func sendDIFBlock(sck net.Conn, buf, data []byte) error {
+	return sendMsg(sck, buf, "HDR\x00", "DIF", data)
+}
+
+// sendMsg sends a message to a DIF data sink: a header made of the magic
+// and of the size of the payload, followed by the payload itself, each of
+// them acknowledged by the sink.
+// kind names the message in error messages.
+func sendMsg(sck net.Conn, buf []byte, magic, kind string, data []byte) error {
 	hdr := buf[:8]
-	cur := w.c
-	copy(hdr, "HDR\x00")
+	cur := len(data)
+	copy(hdr, magic)
 	binary.LittleEndian.PutUint32(hdr[4:], uint32(cur))
 
 	_, err := sck.Write(hdr)
 	if err != nil {
-		return errorf(
-			"eda: could not send DIF data size header to %v: %w",
-			sck.RemoteAddr(), err,
+		return fmt.Errorf(
+			"eda: could not send %s data size header to %v: %w",
+			kind, sck.RemoteAddr(), err,
 		)
 	}
 
 	// wait for ACK
 	_, err = io.ReadFull(sck, hdr[:4])
 	if err != nil {
-		return errorf(
-			"eda: could not read ACK DIF header from %v: %+v",
-			sck.RemoteAddr(), err,
+		return fmt.Errorf(
+			"eda: could not read ACK %s header from %v: %+v",
+			kind, sck.RemoteAddr(), err,
 		)
 	}
 	if string(hdr[:4]) != "ACK\x00" {
-		return errorf(
-			"eda: invalid ACK DIF header from %v: %q",
-			sck.RemoteAddr(), hdr[:4],
+		return fmt.Errorf(
+			"eda: invalid ACK %s header from %v: %q",
+			kind, sck.RemoteAddr(), hdr[:4],
 		)
 	}
 
@@ -1955,52 +2137,26 @@ func (dev *Device) daqSendDIFData(i int) error {
 		return nil
 	}
 
-	_, err = sck.Write(w.p[:cur])
+	_, err = sck.Write(data)
 	if err != nil {
-		return errorf(
-			"eda: could not send DIF data to %v: %w",
-			sck.RemoteAddr(), err,
+		return fmt.Errorf(
+			"eda: could not send %s data to %v: %w",
+			kind, sck.RemoteAddr(), err,
 		)
 	}
 
-	if false {
-		_, _ = dev.daq.f.Write(w.p[:cur])
-		dec := eformat.NewDecoder(sink.id, bytes.NewReader(w.p[:cur]))
-		dec.IsEDA = true
-		var d eformat.DIF
-		err = dec.Decode(&d)
-		if err != nil {
-			dev.msg.Printf("could not decode DIF: %+v", err)
-		} else {
-			wbuf := dev.msg.Writer()
-			fmt.Fprintf(wbuf, "=== DIF-ID 0x%x ===\n", d.Header.ID)
-			fmt.Fprintf(wbuf, "DIF trigger: % 10d\n", d.Header.DTC)
-			fmt.Fprintf(wbuf, "ACQ trigger: % 10d\n", d.Header.ATC)
-			fmt.Fprintf(wbuf, "Gbl trigger: % 10d\n", d.Header.GTC)
-			fmt.Fprintf(wbuf, "Abs BCID:    % 10d\n", d.Header.AbsBCID)
-			fmt.Fprintf(wbuf, "Time DIF:    % 10d\n", d.Header.TimeDIFTC)
-			fmt.Fprintf(wbuf, "Frames:      % 10d\n", len(d.Frames))
-
-			for _, frame := range d.Frames {
-				fmt.Fprintf(wbuf, "  hroc=0x%02x BCID=% 8d %x\n",
-					frame.Header, frame.BCID, frame.Data,
-				)
-			}
-		}
-	}
-
 	// wait for ACK
 	_, err = io.ReadFull(sck, hdr[:4])
 	if err != nil {
-		return errorf(
-			"eda: could not read ACK DIF data from %v: %+v",
-			sck.RemoteAddr(), err,
+		return fmt.Errorf(
+			"eda: could not read ACK %s data from %v: %+v",
+			kind, sck.RemoteAddr(), err,
 		)
 	}
 	if string(hdr[:4]) != "ACK\x00" {
-		return errorf(
-			"eda: invalid ACK DIF data from %v: %q",
-			sck.RemoteAddr(), hdr[:4],
+		return fmt.Errorf(
+			"eda: invalid ACK %s data from %v: %q",
+			kind, sck.RemoteAddr(), hdr[:4],
 		)
 	}
 
@@ -6,6 +6,7 @@ package eda
 
 import (
 	"bytes"
+	"errors"
 	"fmt"
 	"io"
 	"io/ioutil"
@@ -14,6 +15,9 @@ import (
 	"os"
 	"path/filepath"
 	"testing"
+	"time"
+
+	"github.com/go-lpc/mim/eda/internal/regs"
 )
 
 func TestReadConf(t *testing.T) {
@@ -776,6 +780,88 @@ func TestReadMask(t *testing.T) {
 	}
 }
 
+func TestReadCtest(t *testing.T) {
+	t.Run("valid-ctest", func(t *testing.T) {
+		var dev Device
+
+		err := dev.readCtest("testdata/ctest_4rfm.csv")
+		if err != nil {
+			t.Fatalf("could not read config file: %+v", err)
+		}
+
+		var want [nRFM * nHR * nChans]uint32
+		for ch := 0; ch < 8; ch++ {
+			want[nChans*nHR*3+ch] = 1
+		}
+
+		if got := dev.cfg.ctest.table; got != want {
+			t.Fatalf("invalid ctest:\ngot= %v\nwant=%v", got, want)
+		}
+	})
+
+	tmp, err := ioutil.TempDir("", "mim-")
+	if err != nil {
+		t.Fatalf("could not create tmp dir: %+v", err)
+	}
+	defer os.RemoveAll(tmp)
+
+	for _, tc := range []struct {
+		name string
+		data string
+		want error
+	}{
+		{
+			name: "invalid-file-fmt",
+			data: `## comment
+0,0,0,1
+`,
+			want: fmt.Errorf(`eda: invalid Ctest file:2: line="0,0,0,1"`),
+		},
+		{
+			name: "invalid-ch-id",
+			data: `## comment
+0;0;1;1
+`,
+			want: fmt.Errorf("eda: invalid chan id=1 (line:2), want=0"),
+		},
+		{
+			name: "invalid-value",
+			data: `## comment
+0;0;0;x
+`,
+			want: fmt.Errorf(`eda: could not parse Ctest for (RFM=0,HR=0,ch=0) (line:2:"x"): strconv.ParseUint: parsing "x": invalid syntax`),
+		},
+		{
+			name: "invalid-ctest",
+			data: `## comment
+0;0;0;2
+`,
+			want: fmt.Errorf("eda: invalid Ctest value 2 for (RFM=0,HR=0,ch=0) (line:2)"),
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			var (
+				dev   Device
+				fname = filepath.Join(tmp, tc.name+".txt")
+			)
+
+			err := ioutil.WriteFile(fname, []byte(tc.data), 0644)
+			if err != nil {
+				t.Fatalf("could not create tmp file: %+v", err)
+			}
+
+			err = dev.readCtest(fname)
+			if err == nil {
+				t.Fatalf("expected an error")
+			}
+
+			if got, want := err.Error(), tc.want.Error(); got != want {
+				t.Fatalf("invalid error:\ngot= %v\nwant=%v", got, want)
+			}
+		})
+	}
+}
+
 func TestDAQSendDIFData(t *testing.T) {
 	for _, tc := range []struct {
 		name string
@@ -857,15 +943,11 @@ func TestDAQSendDIFData(t *testing.T) {
 			sck := tc.conn()
 			dev.daq.rfm = []rfmSink{
 				{
-					w: &wbuf{
-						p: make([]byte, daqBufferSize),
-						c: 66,
-					},
 					buf: make([]byte, 8),
 					sck: sck,
 				},
 			}
-			err := dev.daqSendDIFData(0)
+			err := dev.daqSendDIFData(0, make([]byte, 66))
 			switch {
 			case err == nil && tc.err == nil:
 				// ok.
@@ -882,3 +964,101 @@ func TestDAQSendDIFData(t *testing.T) {
 		})
 	}
 }
+
+func TestSCTiming(t *testing.T) {
+	var (
+		msg   = new(bytes.Buffer)
+		dev   = Device{msg: log.New(msg, "", 0), cfg: newConfig()}
+		polls = 0
+		done  = 3 // number of polls before the SC-done flag is set
+	)
+	WithSCTiming(SCTiming{Poll: time.Microsecond, Timeout: 20 * time.Millisecond})(&dev.cfg)
+	if got, want := dev.cfg.sc.timing.Pulse, time.Microsecond; got != want {
+		t.Fatalf("invalid default SC pulse: got=%v, want=%v", got, want)
+	}
+
+	dev.regs.pio.state.r = func() uint32 {
+		polls++
+		if polls < done {
+			return 0
+		}
+		return regs.O_SC_DONE_1
+	}
+
+	err := dev.hrscWaitSCDone(1)
+	if err != nil {
+		t.Fatalf("could not wait for SC-done: %+v", err)
+	}
+	if polls != done {
+		t.Fatalf("invalid number of polls: got=%d, want=%d", polls, done)
+	}
+
+	err = dev.hrscWaitSCDone(2)
+	if !errors.Is(err, errSCTimeout) {
+		t.Fatalf("invalid error: %+v", err)
+	}
+	if got, want := err.Error(), "eda: slow-control timeout (rfm=2, timeout=20ms)"; got != want {
+		t.Fatalf("invalid error:\ngot= %q\nwant=%q", got, want)
+	}
+
+	var attempts int
+	send := func(errs ...error) func(rfm int) error {
+		attempts = 0
+		return func(rfm int) error {
+			err := errs[attempts]
+			attempts++
+			return err
+		}
+	}
+
+	for _, tc := range []struct {
+		name     string
+		retries  int
+		errs     []error
+		attempts int
+		err      error
+	}{
+		{
+			name:     "ok",
+			errs:     []error{nil},
+			attempts: 1,
+		},
+		{
+			name:     "no-retry",
+			errs:     []error{errSCTimeout},
+			attempts: 1,
+			err:      errSCTimeout,
+		},
+		{
+			name:     "retry-ok",
+			retries:  2,
+			errs:     []error{errSCTimeout, errSCLoopBack, nil},
+			attempts: 3,
+		},
+		{
+			name:     "retry-fail",
+			retries:  1,
+			errs:     []error{errSCLoopBack, errSCLoopBack},
+			attempts: 2,
+			err:      errSCLoopBack,
+		},
+		{
+			name:     "other-error",
+			retries:  2,
+			errs:     []error{io.ErrUnexpectedEOF},
+			attempts: 1,
+			err:      io.ErrUnexpectedEOF,
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			WithSCRetries(tc.retries)(&dev.cfg)
+			err := dev.hrscSend(1, send(tc.errs...))
+			if !errors.Is(err, tc.err) || (err == nil) != (tc.err == nil) {
+				t.Fatalf("invalid error: got=%v, want=%v", err, tc.err)
+			}
+			if attempts != tc.attempts {
+				t.Fatalf("invalid number of attempts: got=%d, want=%d", attempts, tc.attempts)
+			}
+		})
+	}
+}
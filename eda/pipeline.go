@@ -0,0 +1,647 @@
+// Copyright 2020 The go-lpc Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package eda
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/go-lpc/mim/eda/internal/regs"
+	"github.com/go-lpc/mim/internal/cbuf"
+	"github.com/go-lpc/mim/internal/eformat"
+	"github.com/go-lpc/mim/model"
+	"golang.org/x/sync/errgroup"
+)
+
+// Cycle holds the data of one acquisition cycle, as it flows through the
+// stages of the DAQ pipeline:
+//
+//	waiter → reader → framers → senders
+//
+// The waiter waits for the end of the readout, the reader drains the RFM
+// FIFOs, the framers assemble the DIF blocks of the cycle and the senders
+// send them downstream.
+//
+// User stages can be inserted with WithFramer and WithSender.
+//
+// With readout batching (see WithReadoutBatching), a Cycle holds the data
+// of Cycles consecutive acquisition cycles, starting at cycle Num: the
+// data of each DIF block is then made of Cycles DIF blocks, in order.
+type Cycle struct {
+	Num    int        // acquisition cycle number, starting at 0
+	Cycles int        // number of acquisition cycles held by the DIF blocks
+	Time   time.Time  // wall-clock time of the end of the readout
+	DIFs   []DIFBlock // DIF blocks, one per active RFM
+}
+
+// DIFBlock is the data of one DIF for an acquisition cycle.
+type DIFBlock struct {
+	ID   uint8  // DIF ID
+	Slot int    // EDA slot of the RFM
+	Data []byte // DIF data, in the DIF format
+}
+
+// Info returns the counters of the acquisition cycle, for the provided
+// run, in the shared MIM data model.
+// The trigger counter and BCID of the cycle are taken from the first DIF
+// block that can be decoded. Blocks that can not be decoded are reported
+// without frames.
+func (cycle *Cycle) Info(run uint32) model.CycleInfo {
+	info := model.CycleInfo{
+		Run:  run,
+		ID:   cycle.Num,
+		Time: cycle.Time,
+		RFMs: make([]model.RFMCounters, len(cycle.DIFs)),
+	}
+
+	var (
+		view eformat.DIFView
+		hdr  = false
+	)
+	for i, blk := range cycle.DIFs {
+		rfm := &info.RFMs[i]
+		*rfm = model.RFMCounters{
+			Slot:  blk.Slot,
+			DIF:   blk.ID,
+			Bytes: len(blk.Data),
+		}
+
+		dec := eformat.NewDecoder(blk.ID, nil)
+		dec.IsEDA = true
+		for buf := blk.Data; len(buf) > 0; {
+			var err error
+			buf, err = dec.DecodeBytes(buf, &view)
+			if err != nil {
+				break
+			}
+			rfm.Frames += len(view.Frames)
+			if !hdr {
+				info.GTC = view.Header.GTC
+				info.AbsBCID = view.Header.AbsBCID
+				hdr = true
+			}
+		}
+	}
+	return info
+}
+
+// Framer processes the DIF blocks of an acquisition cycle before they are
+// sent, e.g. to filter or compress them.
+// A Framer may modify, replace or remove DIF blocks.
+// DIF data is only valid until the end of the cycle.
+type Framer interface {
+	Frame(cycle *Cycle) error
+}
+
+// FramerFunc adapts an ordinary function to the Framer interface.
+type FramerFunc func(cycle *Cycle) error
+
+// Frame calls f(cycle).
+func (f FramerFunc) Frame(cycle *Cycle) error { return f(cycle) }
+
+// Sender sends the DIF blocks of an acquisition cycle downstream.
+// The senders of a pipeline run concurrently and must not modify the cycle.
+// DIF data is only valid until Send returns.
+type Sender interface {
+	Send(cycle *Cycle) error
+}
+
+// SenderFunc adapts an ordinary function to the Sender interface.
+type SenderFunc func(cycle *Cycle) error
+
+// Send calls f(cycle).
+func (f SenderFunc) Send(cycle *Cycle) error { return f(cycle) }
+
+// errStopped is returned by a waiter when the acquisition was stopped.
+var errStopped = errors.New("eda: DAQ stopped")
+
+// dccRawFile is the local copy of the DIF data of runs in DCC mode.
+const dccRawFile = "/dev/shm/out.raw"
+
+// waiter waits for the end of the readout of an acquisition cycle.
+type waiter interface {
+	wait(cycle int) error
+}
+
+// reader reads the hardroc data of an acquisition cycle out of the RFMs.
+type reader interface {
+	read(cycle *Cycle) error
+}
+
+type pipeline struct {
+	waiter  waiter
+	reader  reader
+	keep    func(cycle int) bool // whether to keep the data of a cycle (nil: all)
+	ready   func() bool          // whether the readout of the next cycle is complete (nil: no batching)
+	framers []Framer
+	senders []Sender
+}
+
+func (dev *Device) newPipeline() (pipeline, error) {
+	var p pipeline
+	switch dev.cfg.daq.mode {
+	case "dcc":
+		w := dccWaiter{dev}
+		p.waiter = w
+		if dev.cfg.daq.batch.max > 1 {
+			p.ready = w.ready
+		}
+	case "noise":
+		thr := newThrottle(dev.cfg.daq.noise.prescale, dev.cfg.daq.noise.rate)
+		thr.start(time.Now()) // first cycle started by Device.Start
+		w := &noiseWaiter{dev: dev, thr: thr}
+		p.waiter = w
+		p.keep = w.thr.keep
+	case "pattern":
+		p.waiter = &patternWaiter{
+			dev: dev,
+			thr: newThrottle(1, dev.cfg.daq.pattern.rate),
+		}
+		p.reader = patternReader{dev}
+	default:
+		return p, fmt.Errorf("eda: invalid trig-mode %q", dev.cfg.daq.mode)
+	}
+
+	if p.reader == nil {
+		p.reader = fifoReader{dev}
+	}
+	p.framers = []Framer{difFramer{dev}}
+	if dev.cfg.daq.empty.filter {
+		p.framers = append(p.framers, newEmptyFilter(dev, dev.cfg.daq.empty.prescale))
+	}
+	p.framers = append(p.framers, dev.cfg.daq.framers...)
+	p.senders = append([]Sender{sinkSender{dev}}, dev.cfg.daq.senders...)
+	return p, nil
+}
+
+func (dev *Device) loop() {
+	defer close(dev.daq.exit)
+
+	p, err := dev.newPipeline()
+	if err != nil {
+		panic(err)
+	}
+	defer dev.pin(&p)()
+
+	defer dev.endStreams()
+
+	for i := range dev.daq.rfm {
+		rfm := &dev.daq.rfm[i]
+		if rfm.sck != nil {
+			defer rfm.sck.Close()
+		}
+		if rfm.mons != nil {
+			defer dev.closeMonitors(i)
+		}
+		if rfm.w == nil {
+			rfm.w = cbuf.New(dev.cfg.daq.bufsz, dev.cfg.daq.bufmax)
+		}
+		rfm.w.Reset()
+		rfm.ovf.last = 0
+		rfm.sent.blocks = 0
+		rfm.sent.bytes = 0
+		rfm.empty.blocks = 0
+		rfm.empty.dropped = 0
+	}
+	defer dev.watchSinks()()
+
+	if dev.cfg.daq.mode == "dcc" {
+		dev.daq.f, err = dev.createRaw(dccRawFile)
+		if err != nil {
+			dev.err = fmt.Errorf("could not create output data file: %+v", err)
+			dev.msg.Printf("%+v", dev.err)
+			return
+		}
+		defer func() {
+			err := dev.daq.f.Close()
+			if err != nil {
+				dev.msg.Printf("could not close output data file: %+v", err)
+			}
+		}()
+
+		_, err = dev.daq.set.WriteTo(dev.daq.f)
+		if err != nil {
+			dev.err = fmt.Errorf("could not write settings record: %+v", err)
+			dev.msg.Printf("%+v", dev.err)
+			return
+		}
+	}
+
+	dev.run(p)
+	if dev.err != nil {
+		// the run failed: Stop will not send the end-of-run summaries.
+		dev.sendRunSummaries()
+	}
+}
+
+// run runs the DAQ pipeline until the acquisition is stopped or fails.
+func (dev *Device) run(p pipeline) {
+	var cycle Cycle
+
+	dev.daq.prog.Reset()
+	for {
+		dev.progressf("trigger %07d, state: acq-", cycle.Num)
+		err := p.waiter.wait(cycle.Num)
+		if err == nil {
+			err = dev.watchFPGA(time.Now())
+		}
+		if errors.Is(err, ErrFPGALost) {
+			err = dev.recoverFPGA(cycle.Num, err)
+			if err == nil {
+				dev.progressf("fpga-reset")
+				dev.progressEnd()
+				continue
+			}
+		}
+		if err != nil {
+			if errors.Is(err, errStopped) {
+				dev.sendRunSummaries()
+				dev.daq.done <- 1
+				return
+			}
+			dev.err = err
+			dev.msg.Printf("%+v", dev.err)
+			return
+		}
+		cycle.Time = time.Now()
+		dev.watchDiskSpace(cycle.Time)
+		dev.progressf("cp-") // copy
+
+		err = p.reader.read(&cycle)
+		if err != nil {
+			dev.err = err
+			dev.msg.Printf("%+v", dev.err)
+			return
+		}
+
+		var sent int64 // number of DIF data bytes sent for this cycle
+		cycle.Cycles = 1
+		switch {
+		case p.keep == nil || p.keep(cycle.Num):
+			dev.daqWriteTimeIndex(cycle.Time)
+			if p.ready != nil {
+				cycle.Cycles, err = dev.readBatch(p, &cycle)
+			}
+			if err == nil {
+				err = p.process(&cycle)
+			}
+			dev.progressf("tx-")
+			if err == nil {
+				err = p.send(&cycle)
+			}
+			for _, blk := range cycle.DIFs {
+				sent += int64(len(blk.Data))
+			}
+		default:
+			// prescaled out: drop the data of this cycle.
+			dev.progressf("skip-")
+		}
+		dev.daqResetBuffers()
+		if err != nil {
+			dev.err = err
+			dev.msg.Printf("%+v", dev.err)
+			return
+		}
+
+		dev.progressEnd()
+		cycle.Num += cycle.Cycles
+		dev.daq.cycles = int64(cycle.Num)
+		dev.beat.update(func(b *Heartbeat) {
+			b.Cycles = int64(cycle.Num)
+			b.Bytes += sent
+			if p.ready != nil {
+				b.Batches++
+			}
+		})
+		dev.count("eda.cycles", int64(cycle.Cycles))
+		dev.count("eda.bytes", sent)
+
+		if dev.cycleLimit(cycle.Num) {
+			// no more cycles: wait for the run to be stopped.
+			go dev.autoStop(dev.daq.stop.gen, "maximum number of cycles")
+			<-dev.daq.done
+			dev.sendRunSummaries()
+			dev.daq.done <- 1
+			return
+		}
+
+		select {
+		case <-dev.daq.done:
+			dev.sendRunSummaries()
+			dev.daq.done <- 1
+			return
+		default:
+		}
+	}
+}
+
+func (p *pipeline) process(cycle *Cycle) error {
+	for _, f := range p.framers {
+		err := f.Frame(cycle)
+		if err != nil {
+			return fmt.Errorf("eda: could not frame DIF data: %w", err)
+		}
+	}
+	return nil
+}
+
+func (p *pipeline) send(cycle *Cycle) error {
+	var grp errgroup.Group
+	for i := range p.senders {
+		s := p.senders[i]
+		grp.Go(func() error {
+			return s.Send(cycle)
+		})
+	}
+	err := grp.Wait()
+	if err != nil {
+		return fmt.Errorf("eda: could not send DIF data: %w", err)
+	}
+	return nil
+}
+
+// daqResetBuffers resets the DIF data buffers of the active RFMs.
+func (dev *Device) daqResetBuffers() {
+	for _, slot := range dev.rfms {
+		rfm := &dev.daq.rfm[slot]
+		rfm.w.Reset()
+		rfm.ovf.last = 0
+	}
+}
+
+// readBatch reads out the acquisition cycles following the cycle just read,
+// as long as their readout is already complete and the batch stays within
+// the limits set with WithReadoutBatching.
+// readBatch returns the number of cycles of the batch.
+func (dev *Device) readBatch(p pipeline, cycle *Cycle) (int, error) {
+	var (
+		n    = 1
+		lens [nRFM]int // size of the DIF data buffers
+		est  int       // size of the largest cycle of the batch, for any RFM
+		max  = dev.cfg.daq.batch.max
+		dt   = dev.cfg.daq.batch.budget
+	)
+	grow := func() {
+		for _, slot := range dev.rfms {
+			cur := dev.daq.rfm[slot].w.Len()
+			if d := cur - lens[slot]; d > est {
+				est = d
+			}
+			lens[slot] = cur
+		}
+	}
+	fits := func() bool {
+		for _, slot := range dev.rfms {
+			w := dev.daq.rfm[slot].w
+			if w.Len()+est > w.Max() {
+				return false
+			}
+		}
+		return true
+	}
+
+	grow()
+	for n < max && time.Since(cycle.Time) < dt && fits() && p.ready() {
+		fmt.Fprintf(dev.msg.Writer(), "cp-")
+		err := p.reader.read(cycle)
+		if err != nil {
+			return n, err
+		}
+		dev.daqWriteTimeIndex(time.Now())
+		grow()
+		n++
+	}
+
+	for len(dev.batch.hist) <= n {
+		dev.batch.hist = append(dev.batch.hist, 0)
+	}
+	dev.batch.hist[n]++
+	return n, nil
+}
+
+// batchSummary formats the distribution of the sizes of readout batches.
+func batchSummary(hist []int64) string {
+	var (
+		o      strings.Builder
+		n      int64
+		cycles int64
+	)
+	for i, v := range hist {
+		if v == 0 {
+			continue
+		}
+		fmt.Fprintf(&o, "%d:%d ", i, v)
+		n += v
+		cycles += int64(i) * v
+	}
+	fmt.Fprintf(&o, "(%.2f cycles/batch)", float64(cycles)/float64(n))
+	return o.String()
+}
+
+// progressf reports the progress of the current acquisition cycle.
+// On the standard output, the states of a cycle are written as they are
+// reached. With a custom logger (see WithLogger), they are logged as a
+// single message once the cycle is done (see progressEnd).
+func (dev *Device) progressf(format string, args ...interface{}) {
+	if dev.cfg.log == nil {
+		fmt.Fprintf(dev.msg.Writer(), format, args...)
+		return
+	}
+	fmt.Fprintf(&dev.daq.prog, format, args...)
+}
+
+// progressEnd ends the progress line of the current acquisition cycle.
+func (dev *Device) progressEnd() {
+	if dev.cfg.log == nil {
+		fmt.Fprintf(dev.msg.Writer(), "\n")
+		return
+	}
+	dev.msg.Print(dev.daq.prog.String())
+	dev.daq.prog.Reset()
+}
+
+// dccWaiter waits for the readout of acquisition cycles driven by the DCC.
+type dccWaiter struct {
+	dev *Device
+}
+
+// ready reports whether the readout of the next cycle is complete.
+func (w dccWaiter) ready() bool {
+	return w.dev.syncState() == regs.S_FIFO_READY
+}
+
+func (w dccWaiter) wait(cycle int) error {
+	for {
+		switch w.dev.syncState() {
+		case regs.S_START_RO:
+			w.dev.progressf("ro-") // readout of HR
+		case regs.S_WAIT_END_RO:
+			// ok.
+		case regs.S_FIFO_READY:
+			return nil
+		default:
+			select {
+			case <-w.dev.daq.done:
+				return errStopped
+			default:
+			}
+			err := w.dev.watchFPGA(time.Now())
+			if err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// noiseWaiter starts acquisition cycles by software, and waits for their
+// readout.
+type noiseWaiter struct {
+	dev *Device
+	thr throttle
+}
+
+func (w *noiseWaiter) wait(cycle int) error {
+	dev := w.dev
+	if cycle > 0 {
+		// the first cycle is started by Device.Start.
+		err := w.start()
+		if err != nil {
+			return err
+		}
+	}
+
+	for dev.syncState() < regs.S_RAMFULL {
+		select {
+		case <-dev.daq.done:
+			return errStopped
+		default:
+		}
+		err := dev.watchFPGA(time.Now())
+		if err != nil {
+			return err
+		}
+	}
+	fmt.Fprintf(dev.msg.Writer(), "ramfull-")
+	err := dev.syncRAMFullExt()
+	if err != nil {
+		return fmt.Errorf("could not set RAMFULL: %+v", err)
+	}
+
+	for dev.syncState() < regs.S_FIFO_READY {
+		select {
+		case <-dev.daq.done:
+			return errStopped
+		default:
+		}
+		err := dev.watchFPGA(time.Now())
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// start starts a new acquisition cycle, once the rate limit allows it.
+func (w *noiseWaiter) start() error {
+	dev := w.dev
+	if d := w.thr.delay(time.Now()); d > 0 {
+		tmr := time.NewTimer(d)
+		select {
+		case <-dev.daq.done:
+			tmr.Stop()
+			return errStopped
+		case <-tmr.C:
+		}
+	}
+
+	w.thr.start(time.Now())
+	err := dev.syncStart()
+	if err != nil {
+		return fmt.Errorf("eda: could not start acquisition: %w", err)
+	}
+	return nil
+}
+
+// fifoReader drains the DAQ FIFOs of the active RFMs into their DIF data
+// buffers.
+type fifoReader struct {
+	dev *Device
+}
+
+func (r fifoReader) read(cycle *Cycle) error {
+	dev := r.dev
+	for _, slot := range dev.rfms {
+		dev.daqWriteDIFData(dev.daq.rfm[slot].w, slot)
+		dev.daqCheckOverflow(slot)
+	}
+	err := dev.syncAckFIFO()
+	if err != nil {
+		return fmt.Errorf("eda: could not ACK FIFO: %w", err)
+	}
+	return nil
+}
+
+// difFramer assembles the DIF blocks of a cycle from the DIF data buffers
+// of the active RFMs.
+type difFramer struct {
+	dev *Device
+}
+
+func (f difFramer) Frame(cycle *Cycle) error {
+	dev := f.dev
+	cycle.DIFs = cycle.DIFs[:0]
+	for _, slot := range dev.rfms {
+		rfm := &dev.daq.rfm[slot]
+		rfm.out.Reset()
+		_, _ = rfm.w.WriteTo(&rfm.out) // can not fail.
+		cycle.DIFs = append(cycle.DIFs, DIFBlock{
+			ID:   rfm.id,
+			Slot: slot,
+			Data: rfm.out.Bytes(),
+		})
+	}
+	return nil
+}
+
+// sinkSender sends DIF blocks to the DIF data sinks of their RFM, and
+// queues copies of them for the monitor sinks and stream readers of their
+// RFM.
+type sinkSender struct {
+	dev *Device
+}
+
+func (s sinkSender) Send(cycle *Cycle) error {
+	dev := s.dev
+	var grp errgroup.Group
+	for i := range cycle.DIFs {
+		blk := cycle.DIFs[i]
+		rfm := &dev.daq.rfm[blk.Slot]
+		if !rfm.valid() {
+			continue
+		}
+		for _, m := range rfm.mons {
+			m.send(blk.Data)
+		}
+		dev.sendStreams(blk.Slot, blk.Data)
+		if rfm.sck == nil {
+			continue
+		}
+		grp.Go(func() error {
+			err := dev.daqSendDIFData(blk.Slot, blk.Data)
+			if err != nil {
+				return fmt.Errorf("eda: could not send DIF data (RFM=%d): %w", blk.Slot, err)
+			}
+			return nil
+		})
+	}
+	return grp.Wait()
+}
+
+var (
+	_ Framer = (*difFramer)(nil)
+	_ Sender = (*sinkSender)(nil)
+)
@@ -0,0 +1,278 @@
+// Copyright 2020 The go-lpc Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package eda
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/go-lpc/mim/internal/cbuf"
+	"github.com/go-lpc/mim/internal/eformat"
+	"github.com/go-lpc/mim/model"
+	"github.com/go-lpc/mim/obs"
+)
+
+type waiterFunc func(cycle int) error
+
+func (f waiterFunc) wait(cycle int) error { return f(cycle) }
+
+type readerFunc func(cycle *Cycle) error
+
+func (f readerFunc) read(cycle *Cycle) error { return f(cycle) }
+
+func TestPipeline(t *testing.T) {
+	newDev := func() *Device {
+		dev := &Device{
+			msg: log.New(ioutil.Discard, "", 0),
+			cfg: newConfig(),
+		}
+		dev.rfms = []int{1, 3}
+		dev.daq.rfm = make([]rfmSink, nRFM)
+		for i := range dev.daq.rfm {
+			dev.daq.rfm[i].slot = i
+			dev.daq.rfm[i].w = cbuf.New(64, 64)
+		}
+		dev.daq.rfm[1].id = 11
+		dev.daq.rfm[3].id = 33
+		dev.daq.done = make(chan int, 1)
+		return dev
+	}
+
+	newPipeline := func(dev *Device, send Sender) pipeline {
+		return pipeline{
+			waiter: waiterFunc(func(cycle int) error {
+				if cycle == 4 {
+					return errStopped
+				}
+				return nil
+			}),
+			reader: readerFunc(func(cycle *Cycle) error {
+				for _, slot := range dev.rfms {
+					fmt.Fprintf(dev.daq.rfm[slot].w, "c%d-s%d", cycle.Num, slot)
+				}
+				return nil
+			}),
+			keep: func(cycle int) bool { return cycle%2 == 0 },
+			framers: []Framer{
+				difFramer{dev},
+				FramerFunc(func(cycle *Cycle) error {
+					// only keep the DIF in slot 1.
+					cycle.DIFs = cycle.DIFs[:1]
+					return nil
+				}),
+			},
+			senders: []Sender{sinkSender{dev}, send},
+		}
+	}
+
+	t.Run("ok", func(t *testing.T) {
+		var (
+			mu   sync.Mutex
+			got  []string
+			dev  = newDev()
+			send = SenderFunc(func(cycle *Cycle) error {
+				mu.Lock()
+				defer mu.Unlock()
+				for _, blk := range cycle.DIFs {
+					got = append(got, fmt.Sprintf("%d:%d:%s", blk.ID, blk.Slot, blk.Data))
+				}
+				return nil
+			})
+		)
+
+		dev.run(newPipeline(dev, send))
+		if dev.err != nil {
+			t.Fatalf("could not run pipeline: %+v", dev.err)
+		}
+		select {
+		case <-dev.daq.done:
+		default:
+			t.Fatalf("pipeline did not acknowledge the stop request")
+		}
+
+		want := []string{"11:1:c0-s1", "11:1:c2-s1"}
+		if !reflect.DeepEqual(got, want) {
+			t.Fatalf("invalid sent data:\ngot= %q\nwant=%q", got, want)
+		}
+		for _, slot := range dev.rfms {
+			if n := dev.daq.rfm[slot].w.Len(); n != 0 {
+				t.Fatalf("DIF data buffer of slot %d not reset (len=%d)", slot, n)
+			}
+		}
+	})
+
+	t.Run("send-error", func(t *testing.T) {
+		dev := newDev()
+		send := SenderFunc(func(cycle *Cycle) error {
+			return fmt.Errorf("boom")
+		})
+
+		dev.run(newPipeline(dev, send))
+		if dev.err == nil {
+			t.Fatalf("expected an error")
+		}
+		if got, want := dev.err.Error(), "eda: could not send DIF data: boom"; got != want {
+			t.Fatalf("invalid error:\ngot= %q\nwant=%q", got, want)
+		}
+	})
+
+	for _, tc := range []struct {
+		name string
+		max  int // maximum number of cycles per batch
+		size int // size of the DIF data buffers
+		want []string
+		hist []int64
+	}{
+		{
+			name: "batch",
+			max:  3,
+			size: 64,
+			want: []string{"0:3:r0r1r2", "3:2:r3r4"},
+			hist: []int64{0, 0, 1, 1},
+		},
+		{
+			name: "batch-full",
+			max:  3,
+			size: 5,
+			want: []string{"0:2:r0r1", "2:2:r2r3", "4:1:r4"},
+			hist: []int64{0, 1, 2},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			var (
+				got  []string
+				dev  = newDev()
+				read = 0
+			)
+			dev.rfms = []int{1}
+			dev.daq.rfm[1].w = cbuf.New(tc.size, tc.size)
+			WithReadoutBatching(tc.max, time.Hour)(&dev.cfg)
+
+			p := pipeline{
+				waiter: waiterFunc(func(cycle int) error {
+					if cycle >= 5 {
+						return errStopped
+					}
+					return nil
+				}),
+				reader: readerFunc(func(cycle *Cycle) error {
+					fmt.Fprintf(dev.daq.rfm[1].w, "r%d", read)
+					read++
+					return nil
+				}),
+				ready:   func() bool { return read < 5 },
+				framers: []Framer{difFramer{dev}},
+				senders: []Sender{SenderFunc(func(cycle *Cycle) error {
+					got = append(got, fmt.Sprintf("%d:%d:%s", cycle.Num, cycle.Cycles, cycle.DIFs[0].Data))
+					return nil
+				})},
+			}
+
+			dev.run(p)
+			if dev.err != nil {
+				t.Fatalf("could not run pipeline: %+v", dev.err)
+			}
+			if !reflect.DeepEqual(got, tc.want) {
+				t.Fatalf("invalid sent data:\ngot= %q\nwant=%q", got, tc.want)
+			}
+			if got, want := dev.daq.cycles, int64(5); got != want {
+				t.Fatalf("invalid number of cycles: got=%d, want=%d", got, want)
+			}
+			if !reflect.DeepEqual(dev.batch.hist, tc.hist) {
+				t.Fatalf("invalid batch sizes:\ngot= %v\nwant=%v", dev.batch.hist, tc.hist)
+			}
+		})
+	}
+
+	if got, want := batchSummary([]int64{0, 3, 0, 1}), "1:3 3:1 (1.50 cycles/batch)"; got != want {
+		t.Fatalf("invalid batch summary: got=%q, want=%q", got, want)
+	}
+}
+
+func TestCycleInfo(t *testing.T) {
+	encode := func(dif eformat.DIF) []byte {
+		buf := new(bytes.Buffer)
+		err := eformat.NewEncoder(buf).Encode(&dif)
+		if err != nil {
+			t.Fatalf("could not encode DIF: %+v", err)
+		}
+		return buf.Bytes()
+	}
+
+	var (
+		now    = time.Date(2021, 5, 12, 10, 0, 0, 0, time.UTC)
+		frames = make([]eformat.Frame, 3)
+		blk1   = encode(eformat.DIF{
+			Header: eformat.GlobalHeader{ID: 10, GTC: 4, AbsBCID: 1234},
+			Frames: frames,
+		})
+		blk2 = encode(eformat.DIF{
+			Header: eformat.GlobalHeader{ID: 12, GTC: 4, AbsBCID: 1234},
+		})
+	)
+
+	cycle := Cycle{
+		Num:  3,
+		Time: now,
+		DIFs: []DIFBlock{
+			{ID: 11, Slot: 1, Data: []byte{0xff}}, // corrupted
+			{ID: 10, Slot: 0, Data: blk1},
+			{ID: 12, Slot: 2, Data: blk2},
+			{ID: 10, Slot: 3, Data: append(append([]byte(nil), blk1...), blk1...)}, // batch of 2 cycles
+		},
+	}
+
+	want := model.CycleInfo{
+		Run:     42,
+		ID:      3,
+		Time:    now,
+		GTC:     4,
+		AbsBCID: 1234,
+		RFMs: []model.RFMCounters{
+			{Slot: 1, DIF: 11, Frames: 0, Bytes: 1},
+			{Slot: 0, DIF: 10, Frames: 3, Bytes: len(blk1)},
+			{Slot: 2, DIF: 12, Frames: 0, Bytes: len(blk2)},
+			{Slot: 3, DIF: 10, Frames: 6, Bytes: 2 * len(blk1)},
+		},
+	}
+	if got := cycle.Info(42); !reflect.DeepEqual(got, want) {
+		t.Fatalf("invalid cycle info:\ngot= %+v\nwant=%+v", got, want)
+	}
+}
+
+func TestCheckOverflow(t *testing.T) {
+	var (
+		msg = new(bytes.Buffer)
+		reg = obs.NewRegistry()
+		dev = &Device{msg: log.New(msg, "eda: ", 0)}
+	)
+	dev.cfg.metrics = reg
+	dev.daq.rfm = make([]rfmSink, nRFM)
+	dev.daq.rfm[1].w = cbuf.New(4, 4)
+
+	w := dev.daq.rfm[1].w
+	for i, data := range []string{"abc", "abcdef", "", "ab"} {
+		_, _ = w.Write([]byte(data))
+		dev.daq.rfm[1].cycle = uint32(i)
+		dev.daqCheckOverflow(1)
+	}
+
+	ovf := dev.daq.rfm[1].ovf
+	if got, want := ovf.cycles, 2; got != want {
+		t.Fatalf("invalid number of overflowing cycles: got=%d, want=%d", got, want)
+	}
+	if got, want := ovf.bytes, 7; got != want {
+		t.Fatalf("invalid number of dropped bytes: got=%d, want=%d", got, want)
+	}
+	if got, want := reg.Counter("eda.overflows"), int64(2); got != want {
+		t.Fatalf("invalid eda.overflows metric: got=%d, want=%d", got, want)
+	}
+}
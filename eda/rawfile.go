@@ -0,0 +1,68 @@
+// Copyright 2020 The go-lpc Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package eda
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/go-lpc/mim/internal/zseek"
+)
+
+// rawFile is a raw data file written by the device, optionally compressed.
+type rawFile struct {
+	f *os.File
+	z *zseek.Writer // nil when not compressed
+}
+
+// createRaw creates the raw data file fname, compressed with the algorithm
+// configured with WithCompression.
+// The extension of the compression algorithm is appended to fname.
+func (dev *Device) createRaw(fname string) (*rawFile, error) {
+	comp := dev.cfg.run.compress
+	switch comp.algo {
+	case "":
+	case "zstd":
+		fname += ".zst"
+	default:
+		return nil, fmt.Errorf("eda: invalid compression algorithm %q", comp.algo)
+	}
+
+	f, err := os.Create(fname)
+	if err != nil {
+		return nil, fmt.Errorf("eda: could not create raw file: %w", err)
+	}
+	raw := &rawFile{f: f}
+	if comp.algo == "" {
+		return raw, nil
+	}
+
+	raw.z, err = zseek.NewWriter(f, comp.level)
+	if err != nil {
+		_ = f.Close()
+		return nil, fmt.Errorf("eda: could not create raw file compressor: %w", err)
+	}
+	return raw, nil
+}
+
+func (raw *rawFile) Write(p []byte) (int, error) {
+	if raw.z != nil {
+		return raw.z.Write(p)
+	}
+	return raw.f.Write(p)
+}
+
+// Close completes the compressed stream, if any, and closes the file.
+func (raw *rawFile) Close() error {
+	if z := raw.z; z != nil {
+		raw.z = nil
+		err := z.Close()
+		if err != nil {
+			_ = raw.f.Close()
+			return err
+		}
+	}
+	return raw.f.Close()
+}
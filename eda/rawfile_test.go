@@ -0,0 +1,80 @@
+// Copyright 2020 The go-lpc Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package eda
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/go-lpc/mim/internal/zseek"
+)
+
+func TestRawFile(t *testing.T) {
+	tmp, err := ioutil.TempDir("", "eda-raw-")
+	if err != nil {
+		t.Fatalf("could not create tmp dir: %+v", err)
+	}
+	defer os.RemoveAll(tmp)
+
+	want := bytes.Repeat([]byte("hello EDA "), 1000)
+
+	for _, tc := range []struct {
+		algo  string
+		fname string
+		err   string
+	}{
+		{algo: "", fname: "out.raw"},
+		{algo: "zstd", fname: "out.raw.zst"},
+		{algo: "lz4", err: `eda: invalid compression algorithm "lz4"`},
+	} {
+		t.Run(tc.algo, func(t *testing.T) {
+			dev := &Device{cfg: newConfig()}
+			WithCompression(tc.algo, 3)(&dev.cfg)
+
+			raw, err := dev.createRaw(filepath.Join(tmp, "out.raw"))
+			switch {
+			case err != nil && tc.err != "":
+				if got, want := err.Error(), tc.err; got != want {
+					t.Fatalf("invalid error:\ngot= %v\nwant=%v", got, want)
+				}
+				return
+			case err != nil:
+				t.Fatalf("could not create raw file: %+v", err)
+			case tc.err != "":
+				t.Fatalf("expected an error")
+			}
+
+			_, err = raw.Write(want)
+			if err != nil {
+				t.Fatalf("could not write raw file: %+v", err)
+			}
+			err = raw.Close()
+			if err != nil {
+				t.Fatalf("could not close raw file: %+v", err)
+			}
+
+			f, err := os.Open(filepath.Join(tmp, tc.fname))
+			if err != nil {
+				t.Fatalf("could not open raw file: %+v", err)
+			}
+			defer f.Close()
+
+			r, err := zseek.Open(f)
+			if err != nil {
+				t.Fatalf("could not open raw stream: %+v", err)
+			}
+			got, err := ioutil.ReadAll(r)
+			if err != nil {
+				t.Fatalf("could not read raw file: %+v", err)
+			}
+			if !bytes.Equal(got, want) {
+				t.Fatalf("invalid raw file content")
+			}
+		})
+	}
+}
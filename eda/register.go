@@ -11,6 +11,23 @@ import (
 	"github.com/go-lpc/mim/eda/internal/regs"
 )
 
+// HWError describes a failed access to a register of an EDA board.
+type HWError struct {
+	Op   string // operation that failed ("read" or "write")
+	Reg  string // symbolic name of the register
+	Addr int64  // offset of the register within its memory region
+	Err  error  // underlying error
+}
+
+func (e *HWError) Error() string {
+	return fmt.Sprintf(
+		"eda: could not %s register %s (addr=0x%x): %+v",
+		e.Op, e.Reg, e.Addr, e.Err,
+	)
+}
+
+func (e *HWError) Unwrap() error { return e.Err }
+
 type rwer interface {
 	io.ReaderAt
 	io.WriterAt
@@ -21,26 +38,28 @@ type reg32 struct {
 	w func(v uint32)
 }
 
-func newReg32(dev *Device, rw rwer, offset int64) reg32 {
+func newReg32(dev *Device, rw rwer, name string, offset int64) reg32 {
 	return reg32{
 		r: func() uint32 {
-			return dev.readU32(rw, offset)
+			return dev.readU32(rw, name, offset)
 		},
 		w: func(v uint32) {
-			dev.writeU32(rw, offset, v)
+			dev.writeU32(rw, name, offset, v)
 		},
 	}
 }
 
 type hrCfg struct {
 	rw   rwer
+	name string
 	addr int64
 	size int64
 }
 
-func newHRCfg(dev *Device, rw rwer, offset int64) hrCfg {
+func newHRCfg(dev *Device, rw rwer, name string, offset int64) hrCfg {
 	return hrCfg{
 		rw:   rw,
+		name: name,
 		addr: offset,
 		size: 4 + nHR*nBytesCfgHR,
 	}
@@ -50,30 +69,38 @@ func (hr *hrCfg) r(i int) byte {
 	buf := make([]byte, 1)
 	_, err := hr.rw.ReadAt(buf, hr.addr+int64(i))
 	if err != nil {
-		panic(fmt.Errorf("could not read HR cfg at addr=0x%x + %d: %+v", hr.addr, i, err))
+		panic(&HWError{
+			Op:   "read",
+			Reg:  fmt.Sprintf("%s[%d]", hr.name, i),
+			Addr: hr.addr + int64(i),
+			Err:  err,
+		})
 	}
 	return buf[0]
 }
 
 func (hr *hrCfg) w(p []byte) (int, error) {
 	n, err := hr.rw.WriteAt(p, hr.addr)
-	return int(n), err
+	if err != nil {
+		return n, &HWError{Op: "write", Reg: hr.name, Addr: hr.addr, Err: err}
+	}
+	return n, nil
 }
 
 type daqFIFO struct {
 	pins [6]reg32
 }
 
-func newDAQFIFO(dev *Device, rw rwer, offset int64) daqFIFO {
+func newDAQFIFO(dev *Device, rw rwer, name string, offset int64) daqFIFO {
 	const sz = 4 // sizeof(uint32)
 	return daqFIFO{
 		pins: [6]reg32{
-			newReg32(dev, rw, offset+sz*regs.ALTERA_AVALON_FIFO_LEVEL_REG),
-			newReg32(dev, rw, offset+sz*regs.ALTERA_AVALON_FIFO_STATUS_REG),
-			newReg32(dev, rw, offset+sz*regs.ALTERA_AVALON_FIFO_EVENT_REG),
-			newReg32(dev, rw, offset+sz*regs.ALTERA_AVALON_FIFO_IENABLE_REG),
-			newReg32(dev, rw, offset+sz*regs.ALTERA_AVALON_FIFO_ALMOSTFULL_REG),
-			newReg32(dev, rw, offset+sz*regs.ALTERA_AVALON_FIFO_ALMOSTEMPTY_REG),
+			newReg32(dev, rw, name+".LEVEL", offset+sz*regs.ALTERA_AVALON_FIFO_LEVEL_REG),
+			newReg32(dev, rw, name+".STATUS", offset+sz*regs.ALTERA_AVALON_FIFO_STATUS_REG),
+			newReg32(dev, rw, name+".EVENT", offset+sz*regs.ALTERA_AVALON_FIFO_EVENT_REG),
+			newReg32(dev, rw, name+".IENABLE", offset+sz*regs.ALTERA_AVALON_FIFO_IENABLE_REG),
+			newReg32(dev, rw, name+".ALMOSTFULL", offset+sz*regs.ALTERA_AVALON_FIFO_ALMOSTFULL_REG),
+			newReg32(dev, rw, name+".ALMOSTEMPTY", offset+sz*regs.ALTERA_AVALON_FIFO_ALMOSTEMPTY_REG),
 		},
 	}
 }
@@ -0,0 +1,110 @@
+// Copyright 2020 The go-lpc Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package eda
+
+import (
+	"errors"
+	"io"
+	"testing"
+
+	"github.com/go-lpc/mim/eda/internal/regs"
+)
+
+type failingRW struct {
+	err error
+}
+
+func (rw failingRW) ReadAt(p []byte, off int64) (int, error)  { return 0, rw.err }
+func (rw failingRW) WriteAt(p []byte, off int64) (int, error) { return 0, rw.err }
+
+func TestHWError(t *testing.T) {
+	var (
+		errBus = errors.New("bus error")
+		rw     = failingRW{errBus}
+	)
+
+	for _, tc := range []struct {
+		name string
+		op   func(dev *Device) error
+		want HWError
+		msg  string
+	}{
+		{
+			name: "read",
+			op: func(dev *Device) error {
+				reg := newReg32(dev, rw, "LW_H2F_PIO_STATE_IN", regs.LW_H2F_PIO_STATE_IN)
+				_ = reg.r()
+				return dev.err
+			},
+			want: HWError{Op: "read", Reg: "LW_H2F_PIO_STATE_IN", Addr: regs.LW_H2F_PIO_STATE_IN},
+			msg:  "eda: could not read register LW_H2F_PIO_STATE_IN (addr=0x100a0): bus error",
+		},
+		{
+			name: "write",
+			op: func(dev *Device) error {
+				reg := newReg32(dev, rw, "LW_H2F_PIO_CTRL_OUT", regs.LW_H2F_PIO_CTRL_OUT)
+				reg.w(0x1)
+				return dev.err
+			},
+			want: HWError{Op: "write", Reg: "LW_H2F_PIO_CTRL_OUT", Addr: regs.LW_H2F_PIO_CTRL_OUT},
+		},
+		{
+			name: "fifo",
+			op: func(dev *Device) error {
+				fifo := newDAQFIFO(dev, rw, "H2F_FIFO_DAQ_CSR_RFM1", regs.H2F_FIFO_DAQ_CSR_RFM1)
+				_ = fifo.r(regs.ALTERA_AVALON_FIFO_STATUS_REG)
+				return dev.err
+			},
+			want: HWError{
+				Op:   "read",
+				Reg:  "H2F_FIFO_DAQ_CSR_RFM1.STATUS",
+				Addr: regs.H2F_FIFO_DAQ_CSR_RFM1 + 4*regs.ALTERA_AVALON_FIFO_STATUS_REG,
+			},
+		},
+		{
+			name: "sc-ram",
+			op: func(dev *Device) error {
+				ram := newHRCfg(dev, rw, "LW_H2F_RAM_SC_RFM2", regs.LW_H2F_RAM_SC_RFM2)
+				_, err := ram.w(make([]byte, szCfgHR))
+				return err
+			},
+			want: HWError{Op: "write", Reg: "LW_H2F_RAM_SC_RFM2", Addr: regs.LW_H2F_RAM_SC_RFM2},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			dev := &Device{buf: make([]byte, 4)}
+			err := tc.op(dev)
+			if err == nil {
+				t.Fatalf("expected an error")
+			}
+
+			var hwe *HWError
+			if !errors.As(err, &hwe) {
+				t.Fatalf("invalid error type %T: %+v", err, err)
+			}
+			if !errors.Is(err, errBus) {
+				t.Fatalf("error does not wrap the bus error: %+v", err)
+			}
+			tc.want.Err = errBus
+			if got, want := *hwe, tc.want; got != want {
+				t.Fatalf("invalid error:\ngot= %#v\nwant=%#v", got, want)
+			}
+			if tc.msg != "" {
+				if got, want := err.Error(), tc.msg; got != want {
+					t.Fatalf("invalid error message:\ngot= %q\nwant=%q", got, want)
+				}
+			}
+		})
+	}
+
+	// subsequent accesses are skipped once an access failed.
+	dev := &Device{buf: make([]byte, 4)}
+	reg := newReg32(dev, rw, "LW_H2F_PIO_STATE_IN", regs.LW_H2F_PIO_STATE_IN)
+	_ = reg.r()
+	newReg32(dev, failingRW{io.ErrUnexpectedEOF}, "LW_H2F_PIO_CNT24", regs.LW_H2F_PIO_CNT24).w(1)
+	if !errors.Is(dev.err, errBus) {
+		t.Fatalf("first error was overwritten: %+v", dev.err)
+	}
+}
@@ -0,0 +1,192 @@
+// Copyright 2020 The go-lpc Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package eda
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"text/tabwriter"
+)
+
+// errSCLoopBack is returned when the slow-control loop-back of an RFM
+// failed, i.e. when its hardrocs did not answer.
+var errSCLoopBack = errors.New("eda: invalid slow-control loop-back")
+
+// errSCTimeout is returned when the slow-control serializer of an RFM did
+// not complete in time.
+var errSCTimeout = errors.New("eda: slow-control timeout")
+
+// slow-control loop-back results.
+const (
+	scUntested = 0
+	scAlive    = 1
+	scDead     = -1
+)
+
+// RFMStatus describes an RFM slot, as checked by Initialize.
+type RFMStatus struct {
+	Slot     int
+	DIF      uint8 // DIF ID (0: unknown)
+	Enabled  bool  // whether the RFM is enabled by the configuration
+	Chamber  bool  // whether the RFM is listed in the chambers table of the detector
+	Excluded bool  // whether the RFM is excluded by the requested RFM mask
+	Tested   bool  // whether the slow-control loop-back was attempted
+	Alive    bool  // whether the slow-control loop-back succeeded
+}
+
+// RFMReport describes the consistency between the RFMs enabled by the
+// configuration, the chambers table of the detector and the RFMs answering
+// the slow-control loop-back.
+//
+// RFMs are expected to take data when listed in the chambers table and not
+// excluded by the requested RFM mask or, when the chambers table is not
+// known, when enabled by the configuration.
+type RFMReport struct {
+	Chambers bool // whether the chambers table of the detector is known
+	Slots    [nRFM]RFMStatus
+}
+
+func (rep RFMReport) expected(s RFMStatus) bool {
+	if rep.Chambers {
+		return s.Chamber && !s.Excluded
+	}
+	return s.Enabled
+}
+
+// Dead returns the RFMs expected to take data that will not: RFMs not
+// enabled by the configuration or failing the slow-control loop-back.
+func (rep RFMReport) Dead() []RFMStatus {
+	var o []RFMStatus
+	for _, s := range rep.Slots {
+		if rep.expected(s) && !(s.Enabled && s.Alive) {
+			o = append(o, s)
+		}
+	}
+	return o
+}
+
+// Unconfigured returns the RFMs enabled by the configuration that are not
+// listed in the chambers table of the detector.
+func (rep RFMReport) Unconfigured() []RFMStatus {
+	var o []RFMStatus
+	if !rep.Chambers {
+		return o
+	}
+	for _, s := range rep.Slots {
+		if s.Enabled && !s.Chamber {
+			o = append(o, s)
+		}
+	}
+	return o
+}
+
+// String returns a table of the RFM slots.
+func (rep RFMReport) String() string {
+	o := new(strings.Builder)
+	tw := tabwriter.NewWriter(o, 0, 8, 2, ' ', 0)
+	fmt.Fprintf(tw, "SLOT\tDIF\tENABLED\tCHAMBER\tSC-LOOPBACK\tSTATUS\n")
+	for _, s := range rep.Slots {
+		var (
+			dif     = "-"
+			chamber = "-"
+			sc      = "-"
+			status  = "unused"
+		)
+		if s.DIF != 0 {
+			dif = fmt.Sprintf("%d", s.DIF)
+		}
+		if rep.Chambers {
+			chamber = yesNo(s.Chamber)
+		}
+		if s.Tested {
+			sc = "ok"
+			if !s.Alive {
+				sc = "failed"
+			}
+		}
+		switch {
+		case rep.expected(s) && !(s.Enabled && s.Alive):
+			status = "expected but dead"
+		case s.Excluded && s.Chamber:
+			status = "disabled"
+		case rep.Chambers && s.Enabled && !s.Chamber:
+			status = "present but unconfigured"
+		case s.Enabled:
+			status = "ok"
+		}
+		fmt.Fprintf(tw, "%d\t%s\t%s\t%s\t%s\t%s\n",
+			s.Slot, dif, yesNo(s.Enabled), chamber, sc, status,
+		)
+	}
+	_ = tw.Flush()
+	return o.String()
+}
+
+func yesNo(v bool) string {
+	if v {
+		return "yes"
+	}
+	return "no"
+}
+
+// err returns an error describing the dead RFMs, if any.
+func (rep RFMReport) err() error {
+	dead := rep.Dead()
+	if len(dead) == 0 {
+		return nil
+	}
+	msgs := make([]string, len(dead))
+	for i, s := range dead {
+		why := "no slow-control loop-back"
+		if !s.Enabled {
+			why = "not enabled"
+		}
+		msgs[i] = fmt.Sprintf("slot=%d (DIF=%d, %s)", s.Slot, s.DIF, why)
+	}
+	return fmt.Errorf(
+		"eda: %d expected RFM(s) dead: %s",
+		len(dead), strings.Join(msgs, ", "),
+	)
+}
+
+// RFMReport returns the consistency report of the RFMs, as checked by the
+// last call to Initialize.
+func (dev *Device) RFMReport() RFMReport {
+	return dev.check.report
+}
+
+// checkRFMs compares the RFMs enabled by the configuration with the
+// chambers table of the detector (if known) and with the results of the
+// slow-control loop-back.
+// checkRFMs fails when an expected RFM is dead.
+func (dev *Device) checkRFMs() error {
+	var (
+		mask = dev.cfg.daq.rfm | dev.check.mask
+		rep  = RFMReport{Chambers: dev.check.chambers != nil}
+	)
+	for i := range rep.Slots {
+		s := &rep.Slots[i]
+		s.Slot = i
+		s.Enabled = (mask>>i)&1 == 1
+		if s.Enabled {
+			s.DIF = dev.daq.rfm[i].id
+		}
+		if dif, ok := dev.check.chambers[i]; ok {
+			s.Chamber = true
+			s.DIF = dif
+		}
+		s.Excluded = dev.check.mask != 0 && (dev.check.mask>>i)&1 == 0
+		s.Tested = dev.check.sc[i] != scUntested
+		s.Alive = dev.check.sc[i] == scAlive
+	}
+	dev.check.report = rep
+
+	dev.msg.Printf("RFM consistency check:\n%s", rep)
+	for _, s := range rep.Unconfigured() {
+		dev.msg.Printf("RFM slot=%d enabled but not in chambers table", s.Slot)
+	}
+	return rep.err()
+}
@@ -0,0 +1,120 @@
+// Copyright 2020 The go-lpc Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package eda
+
+import (
+	"io/ioutil"
+	"log"
+	"testing"
+)
+
+func TestCheckRFMs(t *testing.T) {
+	for _, tc := range []struct {
+		name     string
+		mask     uint32
+		chambers map[int]uint8
+		reqmask  uint32
+		sc       [nRFM]int
+		want     string
+		err      string
+	}{
+		{
+			name: "csv-ok",
+			mask: 0x5,
+			sc:   [nRFM]int{scAlive, scUntested, scAlive, scUntested},
+			want: `SLOT  DIF  ENABLED  CHAMBER  SC-LOOPBACK  STATUS
+0     10   yes      -        ok           ok
+1     -    no       -        -            unused
+2     12   yes      -        ok           ok
+3     -    no       -        -            unused
+`,
+		},
+		{
+			name: "csv-dead",
+			mask: 0x5,
+			sc:   [nRFM]int{scAlive, scUntested, scDead, scUntested},
+			want: `SLOT  DIF  ENABLED  CHAMBER  SC-LOOPBACK  STATUS
+0     10   yes      -        ok           ok
+1     -    no       -        -            unused
+2     12   yes      -        failed       expected but dead
+3     -    no       -        -            unused
+`,
+			err: "eda: 1 expected RFM(s) dead: slot=2 (DIF=12, no slow-control loop-back)",
+		},
+		{
+			name:     "db-mismatch",
+			mask:     0x3,
+			reqmask:  0xb,
+			chambers: map[int]uint8{0: 10, 1: 11, 2: 12},
+			sc:       [nRFM]int{scAlive, scDead, scUntested, scUntested},
+			want: `SLOT  DIF  ENABLED  CHAMBER  SC-LOOPBACK  STATUS
+0     10   yes      yes      ok           ok
+1     11   yes      yes      failed       expected but dead
+2     12   no       yes      -            disabled
+3     -    yes      no       -            present but unconfigured
+`,
+			err: "eda: 1 expected RFM(s) dead: slot=1 (DIF=11, no slow-control loop-back)",
+		},
+		{
+			name:     "db-not-booted",
+			mask:     0x1,
+			reqmask:  0x3,
+			chambers: map[int]uint8{0: 10, 1: 11},
+			sc:       [nRFM]int{scAlive, scUntested, scUntested, scUntested},
+			want: `SLOT  DIF  ENABLED  CHAMBER  SC-LOOPBACK  STATUS
+0     10   yes      yes      ok           ok
+1     11   yes      yes      -            expected but dead
+2     -    no       no       -            unused
+3     -    no       no       -            unused
+`,
+			err: "eda: 1 expected RFM(s) dead: slot=1 (DIF=11, no slow-control loop-back)",
+		},
+		{
+			name:     "db-ok",
+			mask:     0x2,
+			chambers: map[int]uint8{1: 11},
+			sc:       [nRFM]int{scUntested, scAlive, scUntested, scUntested},
+			want: `SLOT  DIF  ENABLED  CHAMBER  SC-LOOPBACK  STATUS
+0     -    no       no       -            unused
+1     11   yes      yes      ok           ok
+2     -    no       no       -            unused
+3     -    no       no       -            unused
+`,
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			dev := &Device{
+				msg: log.New(ioutil.Discard, "", 0),
+				cfg: newConfig(),
+			}
+			dev.daq.rfm = make([]rfmSink, nRFM)
+			for i := range dev.daq.rfm {
+				if (tc.mask>>i)&1 == 1 {
+					dev.daq.rfm[i].id = uint8(10 + i)
+				}
+			}
+			dev.cfg.daq.rfm = tc.mask
+			dev.check.chambers = tc.chambers
+			dev.check.mask = tc.reqmask
+			dev.check.sc = tc.sc
+
+			err := dev.checkRFMs()
+			switch {
+			case err != nil && tc.err != "":
+				if got, want := err.Error(), tc.err; got != want {
+					t.Fatalf("invalid error:\ngot= %v\nwant=%v", got, want)
+				}
+			case err != nil:
+				t.Fatalf("could not check RFMs: %+v", err)
+			case tc.err != "":
+				t.Fatalf("expected an error (%s)", tc.err)
+			}
+
+			if got, want := dev.RFMReport().String(), tc.want; got != want {
+				t.Fatalf("invalid report:\ngot:\n%s\nwant:\n%s", got, want)
+			}
+		})
+	}
+}
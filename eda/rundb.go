@@ -0,0 +1,96 @@
+// Copyright 2020 The go-lpc Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package eda
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path"
+	"time"
+
+	"github.com/go-lpc/mim/conddb"
+	"github.com/go-lpc/mim/internal/eformat"
+)
+
+// recordRunStart records the start of a run, acquired in the provided
+// mode, and the files written by the device for that run in the run
+// bookkeeping database, if any.
+func (dev *Device) recordRunStart(run uint32, mode string, files []conddb.RunFile) {
+	db := dev.cfg.run.db
+	if db == nil {
+		return
+	}
+
+	host, err := os.Hostname()
+	if err != nil {
+		dev.msg.Printf("could not retrieve hostname: %+v", err)
+	}
+
+	set, err := json.Marshal(struct {
+		eformat.Settings
+		Clock *ClockSync `json:",omitempty"`
+	}{dev.daq.set, dev.daq.clock})
+	if err != nil {
+		dev.msg.Printf("could not marshal settings of run %d: %+v", run, err)
+	}
+
+	ctx := context.Background()
+	err = db.BeginRun(ctx, conddb.Run{
+		ID:       run,
+		Board:    dev.cfg.daq.eda,
+		Host:     host,
+		Mode:     mode,
+		Start:    time.Now().UTC(),
+		Settings: string(set),
+	})
+	if err != nil {
+		dev.msg.Printf("could not record start of run: %+v", err)
+		return
+	}
+
+	for _, f := range files {
+		f.Run = run
+		f.Host = host
+		err = db.AddRunFile(ctx, f)
+		if err != nil {
+			dev.msg.Printf("could not record run file: %+v", err)
+		}
+	}
+}
+
+// runFiles returns the files written by the device for the provided run.
+func (dev *Device) runFiles(run uint32) []conddb.RunFile {
+	files := []conddb.RunFile{
+		{Kind: "settings", Path: path.Join(dev.dir, fmt.Sprintf("settings_%03d.csv", run))},
+		{Kind: "hr-sc", Path: path.Join(dev.dir, fmt.Sprintf("hr_sc_%03d.csv", run))},
+	}
+	if dev.cfg.daq.mode == "dcc" {
+		files = append(files, conddb.RunFile{Kind: "raw", Path: dccRawFile})
+	}
+	if dev.daq.tidx.f != nil {
+		files = append(files, conddb.RunFile{Kind: "time-index", Path: dev.daq.tidx.f.Name()})
+	}
+	return files
+}
+
+// recordRunStop records the end of the current run in the run bookkeeping
+// database, if any.
+func (dev *Device) recordRunStop() {
+	db := dev.cfg.run.db
+	if db == nil {
+		return
+	}
+
+	err := db.EndRun(
+		context.Background(),
+		dev.daq.set.Run, dev.cfg.daq.eda,
+		time.Now().UTC(), dev.daq.cycles,
+	)
+	if err != nil {
+		dev.msg.Printf("could not record end of run: %+v", err)
+	}
+}
@@ -0,0 +1,133 @@
+// Copyright 2020 The go-lpc Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package eda
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-lpc/mim/conddb"
+	"github.com/go-lpc/mim/internal/eformat"
+)
+
+type fakeRunDB struct {
+	runs  []conddb.Run
+	files []conddb.RunFile
+	err   error
+}
+
+func (db *fakeRunDB) BeginRun(ctx context.Context, run conddb.Run) error {
+	if db.err != nil {
+		return db.err
+	}
+	db.runs = append(db.runs, run)
+	return nil
+}
+
+func (db *fakeRunDB) EndRun(ctx context.Context, run uint32, board int, stop time.Time, cycles int64) error {
+	if db.err != nil {
+		return db.err
+	}
+	for i := range db.runs {
+		r := &db.runs[i]
+		if r.ID == run && r.Board == board {
+			r.Stop = stop
+			r.Cycles = cycles
+			return nil
+		}
+	}
+	return fmt.Errorf("no such run %d", run)
+}
+
+func (db *fakeRunDB) AddRunFile(ctx context.Context, f conddb.RunFile) error {
+	if db.err != nil {
+		return db.err
+	}
+	db.files = append(db.files, f)
+	return nil
+}
+
+func TestRunDB(t *testing.T) {
+	var (
+		msg = new(strings.Builder)
+		db  = new(fakeRunDB)
+		dev = Device{dir: "/data", cfg: newConfig()}
+	)
+	dev.msg = log.New(msg, "", 0)
+	dev.cfg.run.db = db
+	dev.cfg.daq.eda = 2
+	dev.daq.set = eformat.Settings{Board: 2, Run: 42, RShaper: 3}
+	dev.daq.clock = &ClockSync{Source: "chrony", Offset: 1500 * time.Microsecond, Synced: true}
+
+	dev.recordRunStart(42, dev.cfg.daq.mode, dev.runFiles(42))
+	dev.daq.cycles = 1234
+	dev.recordRunStop()
+
+	if len(db.runs) != 1 {
+		t.Fatalf("invalid number of runs: got=%d, want=1", len(db.runs))
+	}
+	run := db.runs[0]
+	if run.ID != 42 || run.Board != 2 || run.Mode != "dcc" || run.Cycles != 1234 {
+		t.Fatalf("invalid run record: %+v", run)
+	}
+	if run.Start.IsZero() || run.Stop.Before(run.Start) {
+		t.Fatalf("invalid run start/stop: %v, %v", run.Start, run.Stop)
+	}
+
+	var set eformat.Settings
+	err := json.Unmarshal([]byte(run.Settings), &set)
+	if err != nil {
+		t.Fatalf("could not unmarshal run settings: %+v", err)
+	}
+	if !reflect.DeepEqual(set, dev.daq.set) {
+		t.Fatalf("invalid run settings:\ngot= %+v\nwant=%+v", set, dev.daq.set)
+	}
+
+	var meta struct {
+		Clock ClockSync
+	}
+	err = json.Unmarshal([]byte(run.Settings), &meta)
+	if err != nil {
+		t.Fatalf("could not unmarshal run clock: %+v", err)
+	}
+	if got, want := meta.Clock, *dev.daq.clock; got != want {
+		t.Fatalf("invalid run clock:\ngot= %+v\nwant=%+v", got, want)
+	}
+
+	var paths []string
+	for _, f := range db.files {
+		if f.Run != 42 || f.Host != run.Host {
+			t.Fatalf("invalid run file: %+v", f)
+		}
+		paths = append(paths, f.Kind+":"+f.Path)
+	}
+	want := []string{
+		"settings:/data/settings_042.csv",
+		"hr-sc:/data/hr_sc_042.csv",
+		"raw:" + dccRawFile,
+	}
+	if !reflect.DeepEqual(paths, want) {
+		t.Fatalf("invalid run files:\ngot= %q\nwant=%q", paths, want)
+	}
+
+	// bookkeeping errors are only logged.
+	db.err = fmt.Errorf("db is down")
+	dev.recordRunStart(43, dev.cfg.daq.mode, dev.runFiles(43))
+	dev.recordRunStop()
+	if got, want := msg.String(), "could not record start of run: db is down\ncould not record end of run: db is down\n"; got != want {
+		t.Fatalf("invalid log:\ngot= %q\nwant=%q", got, want)
+	}
+
+	// no bookkeeping database.
+	dev.cfg.run.db = nil
+	dev.recordRunStart(44, dev.cfg.daq.mode, dev.runFiles(44))
+	dev.recordRunStop()
+}
@@ -0,0 +1,135 @@
+// Copyright 2020 The go-lpc Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package eda
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// runLimits are the limits after which a run is automatically stopped.
+type runLimits struct {
+	dur    time.Duration // maximum duration of a run (0: no limit)
+	cycles int64         // maximum number of acquisition cycles of a run (0: no limit)
+}
+
+// parseRunLimits parses the optional "max-duration=DURATION" and
+// "max-cycles=N" arguments of a start request, overriding the provided
+// limits.
+func parseRunLimits(lim runLimits, args []string) (runLimits, error) {
+	for _, arg := range args {
+		i := strings.Index(arg, "=")
+		if i < 0 {
+			return lim, fmt.Errorf("eda: invalid run limit %q", arg)
+		}
+		k, v := arg[:i], arg[i+1:]
+		switch k {
+		case "max-duration":
+			dur, err := time.ParseDuration(v)
+			if err != nil || dur < 0 {
+				return lim, fmt.Errorf("eda: invalid maximum run duration %q", v)
+			}
+			lim.dur = dur
+		case "max-cycles":
+			n, err := strconv.ParseInt(v, 10, 64)
+			if err != nil || n < 0 {
+				return lim, fmt.Errorf("eda: invalid maximum number of cycles %q", v)
+			}
+			lim.cycles = n
+		default:
+			return lim, fmt.Errorf("eda: unknown run limit %q", k)
+		}
+	}
+	return lim, nil
+}
+
+// runLimiter is implemented by devices whose runs can be started with
+// their own limits.
+type runLimiter interface {
+	startLimited(run uint32, lim runLimits) error
+}
+
+var _ runLimiter = (*Device)(nil)
+
+// startLimited starts a run, automatically stopped once one of the
+// provided limits is reached.
+// A run can not be started before the previous one was stopped.
+func (dev *Device) startLimited(run uint32, lim runLimits) error {
+	dev.daq.stop.Lock()
+	if dev.daq.stop.started && !dev.daq.stop.done {
+		dev.daq.stop.Unlock()
+		return fmt.Errorf("eda: could not start run %d: run %d in progress", run, dev.daq.set.Run)
+	}
+	dev.daq.stop.gen++
+	dev.daq.stop.started = false
+	dev.daq.stop.done = false
+	dev.daq.stop.err = nil
+	gen := dev.daq.stop.gen
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	dev.daq.stop.cancel = cancel
+	dev.daq.stop.Unlock()
+
+	dev.daq.lim = lim
+	err := dev.start(ctx, run)
+
+	dev.daq.stop.Lock()
+	dev.daq.stop.cancel = nil
+	if err != nil {
+		dev.daq.stop.Unlock()
+		return err
+	}
+	dev.daq.stop.started = true
+	if lim.dur > 0 {
+		dev.daq.stop.tmr = time.AfterFunc(lim.dur, func() {
+			dev.autoStop(gen, "maximum run duration")
+		})
+	}
+	dev.daq.stop.Unlock()
+	return nil
+}
+
+// cycleLimit returns whether the current run reached its maximum number
+// of acquisition cycles.
+func (dev *Device) cycleLimit(cycles int) bool {
+	max := dev.daq.lim.cycles
+	return max > 0 && int64(cycles) >= max
+}
+
+// autoStop stops the run gen, unless it was already stopped, once one
+// of its limits is reached.
+func (dev *Device) autoStop(gen int, reason string) {
+	dev.daq.stop.Lock()
+	defer dev.daq.stop.Unlock()
+
+	if gen != dev.daq.stop.gen || dev.daq.stop.done {
+		return
+	}
+	dev.msg.Printf("run %d: %s reached, stopping run...", dev.daq.set.Run, reason)
+	err := dev.stopOnce()
+	if err != nil {
+		dev.msg.Printf("could not stop run %d: %+v", dev.daq.set.Run, err)
+	}
+}
+
+// stopOnce stops the current run, if it was not already stopped, and
+// returns the outcome of the stop.
+// The stop mutex must be held.
+func (dev *Device) stopOnce() error {
+	if dev.daq.stop.done {
+		return dev.daq.stop.err
+	}
+	if dev.daq.stop.tmr != nil {
+		dev.daq.stop.tmr.Stop()
+		dev.daq.stop.tmr = nil
+	}
+	err := dev.stop()
+	dev.daq.stop.done = true
+	dev.daq.stop.err = err
+	return err
+}
@@ -0,0 +1,84 @@
+// Copyright 2020 The go-lpc Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package eda
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseRunLimits(t *testing.T) {
+	def := runLimits{dur: time.Hour, cycles: 10}
+	for _, tc := range []struct {
+		args []string
+		want runLimits
+		err  string
+	}{
+		{
+			args: nil,
+			want: def,
+		},
+		{
+			args: []string{"max-duration=8h"},
+			want: runLimits{dur: 8 * time.Hour, cycles: 10},
+		},
+		{
+			args: []string{"max-cycles=0", "max-duration=30m"},
+			want: runLimits{dur: 30 * time.Minute},
+		},
+		{
+			args: []string{"max-duration=-1s"},
+			err:  `eda: invalid maximum run duration "-1s"`,
+		},
+		{
+			args: []string{"max-cycles=1e3"},
+			err:  `eda: invalid maximum number of cycles "1e3"`,
+		},
+		{
+			args: []string{"max-size=1"},
+			err:  `eda: unknown run limit "max-size"`,
+		},
+		{
+			args: []string{"8h"},
+			err:  `eda: invalid run limit "8h"`,
+		},
+	} {
+		t.Run("", func(t *testing.T) {
+			got, err := parseRunLimits(def, tc.args)
+			switch {
+			case err != nil && tc.err != "":
+				if got, want := err.Error(), tc.err; got != want {
+					t.Fatalf("invalid error:\ngot= %s\nwant=%s", got, want)
+				}
+				return
+			case err != nil:
+				t.Fatalf("could not parse run limits: %+v", err)
+			case tc.err != "":
+				t.Fatalf("expected an error")
+			}
+			if got != tc.want {
+				t.Fatalf("invalid run limits: got=%+v, want=%+v", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestStartInProgress(t *testing.T) {
+	dev := &Device{cfg: newConfig()}
+	dev.daq.set.Run = 42
+	dev.daq.stop.gen = 1
+	dev.daq.stop.started = true
+
+	err := dev.Start(43)
+	if err == nil {
+		t.Fatalf("expected an error")
+	}
+	if got, want := err.Error(), "eda: could not start run 43: run 42 in progress"; got != want {
+		t.Fatalf("invalid error:\ngot= %s\nwant=%s", got, want)
+	}
+	if got, want := dev.daq.stop.gen, 1; got != want {
+		t.Fatalf("invalid run generation: got=%d, want=%d", got, want)
+	}
+}
@@ -0,0 +1,82 @@
+// Copyright 2020 The go-lpc Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package eda
+
+import (
+	"crypto/sha256"
+	"encoding/json"
+	"sync"
+)
+
+const (
+	scCacheSize = 64 // maximum number of cached slow-control images
+)
+
+// hrscCache holds the slow-control images of the hardrocs of a RFM, shared
+// by all the devices of the process.
+var hrscCache = newSCCache(scCacheSize)
+
+// scKey identifies a slow-control image by the hash of all the inputs
+// used to build it.
+type scKey [sha256.Size]byte
+
+func newSCKey(vs ...interface{}) scKey {
+	var (
+		key scKey
+		h   = sha256.New()
+		enc = json.NewEncoder(h)
+	)
+	for _, v := range vs {
+		_ = enc.Encode(v) // can not fail.
+	}
+	h.Sum(key[:0])
+	return key
+}
+
+// scCache is a bounded cache of slow-control images.
+// The oldest image is evicted when the cache is full.
+type scCache struct {
+	mu   sync.Mutex
+	max  int
+	keys []scKey // keys, in insertion order
+	imgs map[scKey][]byte
+}
+
+func newSCCache(max int) *scCache {
+	return &scCache{
+		max:  max,
+		imgs: make(map[scKey][]byte, max),
+	}
+}
+
+// load copies the image identified by key into dst and reports whether
+// it was found.
+func (c *scCache) load(key scKey, dst []byte) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	img, ok := c.imgs[key]
+	if !ok {
+		return false
+	}
+	copy(dst, img)
+	return true
+}
+
+// store stores a copy of the image identified by key.
+func (c *scCache) store(key scKey, img []byte) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if _, dup := c.imgs[key]; dup {
+		return
+	}
+	if len(c.keys) >= c.max {
+		delete(c.imgs, c.keys[0])
+		c.keys = c.keys[1:]
+	}
+	c.keys = append(c.keys, key)
+	c.imgs[key] = append([]byte(nil), img...)
+}
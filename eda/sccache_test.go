@@ -0,0 +1,137 @@
+// Copyright 2020 The go-lpc Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package eda
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"testing"
+
+	"github.com/go-lpc/mim/conddb"
+)
+
+func TestSCCache(t *testing.T) {
+	var (
+		cache = newSCCache(2)
+		buf   = make([]byte, 3)
+		keys  = []scKey{newSCKey(1), newSCKey(2), newSCKey(3)}
+	)
+
+	if keys[0] == keys[1] {
+		t.Fatalf("invalid keys")
+	}
+
+	img := []byte{1, 2, 3}
+	cache.store(keys[0], img)
+	img[0] = 42 // cache holds a copy.
+
+	if !cache.load(keys[0], buf) {
+		t.Fatalf("could not load image")
+	}
+	if got, want := buf, []byte{1, 2, 3}; !bytes.Equal(got, want) {
+		t.Fatalf("invalid image: got=%v, want=%v", got, want)
+	}
+
+	cache.store(keys[1], []byte{4, 5, 6})
+	cache.store(keys[2], []byte{7, 8, 9})
+	if cache.load(keys[0], buf) {
+		t.Fatalf("oldest image was not evicted")
+	}
+	for _, key := range keys[1:] {
+		if !cache.load(key, buf) {
+			t.Fatalf("could not load image")
+		}
+	}
+}
+
+func TestSCCacheFromDB(t *testing.T) {
+	raw, err := ioutil.ReadFile("testdata/asic-rfm-001.json")
+	if err != nil {
+		t.Fatalf("could not read ASICs: %+v", err)
+	}
+	var asics []conddb.ASIC
+	err = json.Unmarshal(raw, &asics)
+	if err != nil {
+		t.Fatalf("could not decode ASICs: %+v", err)
+	}
+
+	newDev := func() *Device {
+		dev := &Device{cfg: newConfig()}
+		dev.setDBConfig(1, asics)
+		return dev
+	}
+
+	var (
+		dev1 = newDev()
+		dev2 = newDev()
+		key  = newSCKey(asics, uint32(3), dev1.cfg.hr.cshaper, dev1.ctestTable(1))
+	)
+	err = dev1.buildHRFromDB(1, 1, 3)
+	if err != nil {
+		t.Fatalf("could not build configuration: %+v", err)
+	}
+
+	cache := newSCCache(1)
+	cache.store(key, dev1.cfg.hr.data)
+	if !cache.load(key, dev2.cfg.hr.data) {
+		t.Fatalf("could not load configuration")
+	}
+	if !bytes.Equal(dev1.cfg.hr.data, dev2.cfg.hr.data) {
+		t.Fatalf("invalid cached configuration")
+	}
+
+	mod := append([]conddb.ASIC(nil), asics...)
+	mod[2].B1++
+	if newSCKey(mod, uint32(3), dev1.cfg.hr.cshaper, dev1.ctestTable(1)) == key {
+		t.Fatalf("threshold change did not change the configuration key")
+	}
+	if newSCKey(asics, uint32(2), dev1.cfg.hr.cshaper, dev1.ctestTable(1)) == key {
+		t.Fatalf("R-shaper change did not change the configuration key")
+	}
+}
+
+func TestSCCacheFromCSV(t *testing.T) {
+	dev := &Device{cfg: newConfig()}
+	dev.buildHRFromCSV(3)
+	for hr := uint32(0); hr < nHR; hr++ {
+		for ch := uint32(0); ch < nChans; ch++ {
+			if got := dev.hrscGetBit(hr, ch); got != 0 {
+				t.Fatalf("Ctest switch (HR=%d,ch=%d) modified without Ctest file: got=%d", hr, ch, got)
+			}
+		}
+	}
+	if dev.ctestTable(3) != nil {
+		t.Fatalf("unexpected Ctest table without Ctest file")
+	}
+
+	err := dev.readCtest("testdata/ctest_4rfm.csv")
+	if err != nil {
+		t.Fatalf("could not read Ctest file: %+v", err)
+	}
+	dev.buildHRFromCSV(3)
+	for hr := uint32(0); hr < nHR; hr++ {
+		for ch := uint32(0); ch < nChans; ch++ {
+			want := uint32(0)
+			if hr == 0 && ch < 8 {
+				want = 1
+			}
+			if got := dev.hrscGetBit(hr, ch); got != want {
+				t.Fatalf("invalid Ctest switch (HR=%d,ch=%d): got=%d, want=%d", hr, ch, got, want)
+			}
+		}
+	}
+
+	dev.buildHRFromCSV(0)
+	for ch := uint32(0); ch < 8; ch++ {
+		if got := dev.hrscGetBit(0, ch); got != 0 {
+			t.Fatalf("invalid Ctest switch (RFM=0,HR=0,ch=%d): got=%d, want=0", ch, got)
+		}
+	}
+
+	if newSCKey(dev.ctestTable(0)) == newSCKey(dev.ctestTable(3)) {
+		t.Fatalf("Ctest switches did not change the configuration key")
+	}
+}
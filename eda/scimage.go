@@ -0,0 +1,77 @@
+// Copyright 2020 The go-lpc Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package eda
+
+import (
+	"errors"
+	"fmt"
+)
+
+// scImager is implemented by devices exporting and importing the
+// slow-control images of their hardrocs.
+type scImager interface {
+	ExportSC(rfm int) ([]byte, error)
+	ImportSC(rfm int, img []byte) error
+}
+
+var _ scImager = (*Device)(nil)
+
+// ExportSC returns a copy of the slow-control image last sent to the
+// hardrocs of the provided RFM slot, as written to the slow-control RAM of
+// the FPGA: the 4-byte loop-back header, followed by the configurations of
+// the hardrocs (last hardroc first).
+func (dev *Device) ExportSC(rfm int) ([]byte, error) {
+	if rfm < 0 || rfm >= nRFM {
+		return nil, fmt.Errorf("eda: invalid RFM slot %d", rfm)
+	}
+	img := dev.sc[rfm]
+	if img == nil {
+		return nil, fmt.Errorf("eda: no slow-control image sent to RFM=%d", rfm)
+	}
+	return append([]byte(nil), img...), nil
+}
+
+// ImportSC sends the provided slow-control image, as returned by ExportSC,
+// to the hardrocs of the RFM slot, and resets their read-registers.
+// The loop-back header of the image is replaced with a new one.
+//
+// The hardrocs of the RFM must have been configured with Initialize.
+func (dev *Device) ImportSC(rfm int, img []byte) error {
+	if rfm < 0 || rfm >= nRFM {
+		return fmt.Errorf("eda: invalid RFM slot %d", rfm)
+	}
+	if len(img) != szCfgHR {
+		return fmt.Errorf(
+			"eda: invalid slow-control image size for RFM=%d (got=%d, want=%d)",
+			rfm, len(img), szCfgHR,
+		)
+	}
+	if dev.sc[rfm] == nil {
+		return fmt.Errorf("eda: could not import slow-control image: RFM=%d not initialized", rfm)
+	}
+
+	copy(dev.cfg.hr.buf[:], img)
+	err := dev.hrscSend(rfm, dev.hrscSetConfig)
+	if errors.Is(err, errSCLoopBack) || errors.Is(err, errSCTimeout) {
+		dev.check.sc[rfm] = scDead
+	}
+	if err != nil {
+		return fmt.Errorf("eda: could not send slow-control image to RFM=%d: %w", rfm, err)
+	}
+	dev.check.sc[rfm] = scAlive
+	dev.msg.Printf("Hardroc configuration imported (RFM=%d): [done]\n", rfm)
+
+	err = dev.hrscSend(rfm, dev.hrscResetReadRegisters)
+	if err != nil {
+		return fmt.Errorf("eda: could not reset read-registers for RFM=%d: %w", rfm, err)
+	}
+	return nil
+}
+
+// recordSC records the slow-control image sent to the hardrocs of the
+// provided RFM slot.
+func (dev *Device) recordSC(rfm int) {
+	dev.sc[rfm] = append(dev.sc[rfm][:0], dev.cfg.hr.buf[:szCfgHR]...)
+}
@@ -0,0 +1,73 @@
+// Copyright 2020 The go-lpc Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package eda
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestSCImage(t *testing.T) {
+	var dev Device
+	for i := range dev.cfg.hr.buf {
+		dev.cfg.hr.buf[i] = byte(i)
+	}
+	dev.recordSC(2)
+
+	img, err := dev.ExportSC(2)
+	if err != nil {
+		t.Fatalf("could not export slow-control image: %+v", err)
+	}
+	if got, want := img, dev.cfg.hr.buf[:]; !bytes.Equal(got, want) {
+		t.Fatalf("invalid slow-control image:\ngot= %x\nwant=%x", got, want)
+	}
+
+	// exported images are copies.
+	img[0]++
+	if dev.sc[2][0] != 0 {
+		t.Fatalf("exported image aliases the recorded one")
+	}
+
+	for _, tc := range []struct {
+		name string
+		err  error
+		want string
+	}{
+		{
+			name: "export-invalid-slot",
+			err:  func() error { _, err := dev.ExportSC(nRFM); return err }(),
+			want: "eda: invalid RFM slot 4",
+		},
+		{
+			name: "export-no-image",
+			err:  func() error { _, err := dev.ExportSC(1); return err }(),
+			want: "eda: no slow-control image sent to RFM=1",
+		},
+		{
+			name: "import-invalid-slot",
+			err:  dev.ImportSC(-1, img),
+			want: "eda: invalid RFM slot -1",
+		},
+		{
+			name: "import-invalid-size",
+			err:  dev.ImportSC(2, img[:10]),
+			want: "eda: invalid slow-control image size for RFM=2 (got=10, want=876)",
+		},
+		{
+			name: "import-not-initialized",
+			err:  dev.ImportSC(1, img),
+			want: "eda: could not import slow-control image: RFM=1 not initialized",
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			if tc.err == nil {
+				t.Fatalf("expected an error")
+			}
+			if got, want := tc.err.Error(), tc.want; got != want {
+				t.Fatalf("invalid error:\ngot= %s\nwant=%s", got, want)
+			}
+		})
+	}
+}
@@ -5,6 +5,7 @@
 package eda
 
 import (
+	"encoding/base64"
 	"encoding/json"
 	"errors"
 	"fmt"
@@ -12,36 +13,112 @@ import (
 	"log"
 	"net"
 	"os"
+	"os/signal"
+	"sort"
 	"strconv"
 	"strings"
-
-	"github.com/go-lpc/mim/conddb"
+	"sync"
+	"syscall"
+	"time"
 )
 
-// server allows to control an EDA board device.
+// errBusy is returned to connections trying to control EDA boards
+// already controlled by another connection.
+var errBusy = errors.New("EDA boards busy")
+
+// Board describes an EDA board managed by an eda-svc server.
+type Board struct {
+	ID     int    // board ID, as used by the "board" field of requests
+	DevMem string // path to the memory device of the board
+	DevSHM string // path to the shared memory directory of the board
+	ODir   string // output directory of the board
+}
+
+// server allows to control EDA board devices.
 type server struct {
 	ctl net.Listener
 
 	msg    *log.Logger
-	odir   string
-	devmem string
-	devshm string
+	boards []Board
 
 	newDevice func(devmem, odir, devshm string, opts ...Option) (device, error)
 
 	opts []Option
-	dev  device
+	lim  runLimits // run limits of the devices, overridden by start requests
+
+	wg    sync.WaitGroup // connections being served
+	mu    sync.Mutex
+	owner net.Conn       // connection controlling the devices
+	devs  map[int]device // devices of the owner connection, by board ID
+	conns map[net.Conn]struct{}
+
+	queues map[int]*boardQueue // requests queues, by board ID
+
+	quit chan struct{} // closed when the server shuts down
+	once sync.Once
 }
 
 func Serve(addr, odir, devmem, devshm string, opts ...Option) error {
-	srv, err := newServer(addr, odir, devmem, devshm, opts...)
+	return ServeBoards(addr, []Board{{
+		DevMem: devmem,
+		DevSHM: devshm,
+		ODir:   odir,
+	}}, opts...)
+}
+
+// ServeBoards serves the JSON control protocol for multiple EDA boards.
+// Requests are dispatched to a board via their "board" field.
+// Requests without a "board" field are sent to the first board.
+//
+// ServeBoards returns when an interrupt or termination signal is received.
+// Runs in progress are then stopped as with a "stop" request (DAQ drained,
+// run recorded, FPGA reset) before the boards are released.
+func ServeBoards(addr string, boards []Board, opts ...Option) error {
+	srv, err := newServer(addr, boards, opts...)
 	if err != nil {
 		return fmt.Errorf("could not create eda server: %w", err)
 	}
+
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, os.Interrupt, syscall.SIGTERM)
+	defer signal.Stop(sigs)
+
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		select {
+		case sig := <-sigs:
+			srv.msg.Printf("received %v signal: shutting down...", sig)
+			srv.shutdown()
+		case <-done:
+		}
+	}()
+
 	return srv.serve()
 }
 
-func newServer(addr, odir, devmem, devshm string, opts ...Option) (*server, error) {
+func newServer(addr string, boards []Board, opts ...Option) (*server, error) {
+	if len(boards) == 0 {
+		return nil, fmt.Errorf("no EDA board to serve")
+	}
+	ids := make(map[int]struct{}, len(boards))
+	for _, b := range boards {
+		if _, dup := ids[b.ID]; dup {
+			return nil, fmt.Errorf("duplicate EDA board ID %d", b.ID)
+		}
+		ids[b.ID] = struct{}{}
+	}
+
+	_, err := lookupBackend(opts)
+	if err != nil {
+		return nil, err
+	}
+
+	cfg := newConfig()
+	for _, opt := range opts {
+		opt(&cfg)
+	}
+
 	ctl, err := net.Listen("tcp", addr)
 	if err != nil {
 		return nil, fmt.Errorf("could not create eda-ctl server on %q: %w", addr, err)
@@ -50,36 +127,266 @@ func newServer(addr, odir, devmem, devshm string, opts ...Option) (*server, erro
 	srv := &server{
 		ctl: ctl,
 
-		msg: log.New(os.Stdout, "eda-svc: ", 0),
+		msg: cfg.logger("eda-svc: "),
 
-		odir:   odir,
-		devmem: devmem,
-		devshm: devshm,
+		boards: append([]Board(nil), boards...),
 
-		newDevice: func(devmem, odir, devshm string, opts ...Option) (device, error) {
-			return newDevice(devmem, odir, devshm, opts...)
-		},
+		newDevice: openDevice,
 
 		opts: opts,
+		lim:  cfg.run.max,
+
+		conns: make(map[net.Conn]struct{}),
+		quit:  make(chan struct{}),
+
+		queues: make(map[int]*boardQueue, len(boards)),
+	}
+	for _, b := range boards {
+		srv.queues[b.ID] = new(boardQueue)
 	}
 	return srv, nil
 }
 
+// serve serves connections concurrently.
+// Only one connection at a time controls the EDA boards: other
+// connections may only inspect them, with the dump commands.
 func (srv *server) serve() error {
 	defer srv.close()
 
 	for {
 		conn, err := srv.ctl.Accept()
 		if err != nil {
+			srv.wg.Wait()
+			if srv.closing() {
+				return nil
+			}
 			return fmt.Errorf("could not accept connection: %w", err)
 		}
 
-		err = srv.handle(conn)
+		srv.track(conn)
+		srv.wg.Add(1)
+		go func() {
+			defer srv.wg.Done()
+			defer srv.untrack(conn)
+			err := srv.handle(conn)
+			if err != nil {
+				srv.msg.Printf("could not run EDA board: %+v", err)
+			}
+		}()
+	}
+}
+
+// track registers a connection being served.
+// A connection accepted while shutting down is immediately unblocked.
+func (srv *server) track(conn net.Conn) {
+	srv.mu.Lock()
+	defer srv.mu.Unlock()
+	srv.conns[conn] = struct{}{}
+	if srv.closing() {
+		_ = conn.SetReadDeadline(time.Now())
+	}
+}
+
+func (srv *server) untrack(conn net.Conn) {
+	srv.mu.Lock()
+	defer srv.mu.Unlock()
+	delete(srv.conns, conn)
+}
+
+// shutdown stops accepting connections and unblocks the connections
+// waiting for requests.
+// The controlling connection stops the runs in progress before releasing
+// the EDA boards (see handle).
+func (srv *server) shutdown() {
+	srv.once.Do(func() { close(srv.quit) })
+
+	srv.mu.Lock()
+	for conn := range srv.conns {
+		_ = conn.SetReadDeadline(time.Now())
+	}
+	srv.mu.Unlock()
+
+	_ = srv.ctl.Close()
+}
+
+// closing returns whether the server is shutting down.
+func (srv *server) closing() bool {
+	select {
+	case <-srv.quit:
+		return true
+	default:
+		return false
+	}
+}
+
+// stopRuns stops the runs in progress, if the boards are controlled by
+// conn.
+func (srv *server) stopRuns(conn net.Conn) {
+	srv.mu.Lock()
+	devs := srv.devs
+	owner := srv.owner
+	srv.mu.Unlock()
+
+	if owner != conn {
+		return
+	}
+
+	boards := make([]int, 0, len(srv.queues))
+	for board := range srv.queues {
+		boards = append(boards, board)
+	}
+	sort.Ints(boards)
+
+	for _, board := range boards {
+		q := srv.queues[board]
+		q.mu.Lock()
+		if q.active() {
+			srv.msg.Printf("stopping run of EDA board %d...", board)
+			err := devs[board].Stop()
+			if err != nil {
+				srv.msg.Printf("could not stop EDA board %d: %+v", board, err)
+			}
+			q.state = runIdle
+		}
+		q.mu.Unlock()
+	}
+}
+
+// acquire gives control of the EDA boards to the provided connection,
+// creating their devices, and returns these devices.
+func (srv *server) acquire(conn net.Conn) (map[int]device, error) {
+	srv.mu.Lock()
+	defer srv.mu.Unlock()
+
+	switch srv.owner {
+	case conn:
+		return srv.devs, nil
+	case nil:
+		// ok.
+	default:
+		return nil, fmt.Errorf(
+			"%w (controlled by %v)",
+			errBusy, srv.owner.RemoteAddr(),
+		)
+	}
+
+	devs := make(map[int]device, len(srv.boards))
+	for _, b := range srv.boards {
+		dev, err := srv.newDevice(b.DevMem, b.ODir, b.DevSHM, srv.opts...)
 		if err != nil {
-			srv.msg.Printf("could not run EDA board: %+v", err)
+			for _, dev := range devs {
+				dev.Close()
+			}
+			return nil, fmt.Errorf("could not create EDA device (board=%d): %w", b.ID, err)
+		}
+		devs[b.ID] = dev
+	}
+	srv.owner = conn
+	srv.devs = devs
+	return devs, nil
+}
+
+// release closes the EDA devices controlled by the provided connection.
+func (srv *server) release(conn net.Conn) {
+	srv.mu.Lock()
+	defer srv.mu.Unlock()
+
+	if srv.owner != conn {
+		return
+	}
+	for board, dev := range srv.devs {
+		q := srv.queues[board]
+		q.mu.Lock()
+		dev.Close()
+		q.state = runIdle
+		q.gen++
+		q.mu.Unlock()
+	}
+	srv.owner = nil
+	srv.devs = nil
+}
+
+// dump dumps the registers of an EDA board.
+// The FIFO status, counters and configuration are dumped for the RFM slot
+// given as argument.
+// The devices of the controlling connection are used, if any.
+func (srv *server) dump(board int, kind string, args *json.RawMessage) (string, error) {
+	rfm := 0
+	switch kind {
+	case "fifo", "counters", "config":
+		var vs []int
+		if args != nil {
+			err := json.Unmarshal(*args, &vs)
+			if err != nil {
+				return "", fmt.Errorf("could not decode RFM slot: %w", err)
+			}
+		}
+		if len(vs) != 1 {
+			return "", fmt.Errorf("invalid number of arguments (got=%d, want=1)", len(vs))
+		}
+		rfm = vs[0]
+	}
+
+	for {
+		srv.mu.Lock()
+		dev, ok := srv.devs[board]
+		if !ok {
+			defer srv.mu.Unlock()
+			i := srv.board(board)
+			if i < 0 {
+				return "", fmt.Errorf("unknown EDA board %d", board)
+			}
+			b := srv.boards[i]
+			dev, err := srv.newDevice(b.DevMem, b.ODir, b.DevSHM, srv.opts...)
+			if err != nil {
+				return "", fmt.Errorf("could not create EDA device (board=%d): %w", b.ID, err)
+			}
+			defer dev.Close()
+			return dumpDevice(dev, kind, rfm)
+		}
+		q := srv.queues[board]
+		gen := q.gen
+		srv.mu.Unlock()
+
+		// wait for the requests of the controlling connection, without
+		// blocking the other connections.
+		var (
+			out      string
+			released bool
+		)
+		err := q.do(func() error {
+			if q.gen != gen {
+				// the device was closed in the meantime.
+				released = true
+				return nil
+			}
+			var err error
+			out, err = dumpDevice(dev, kind, rfm)
+			return err
+		})
+		if released {
 			continue
 		}
+		return out, err
+	}
+}
+
+func dumpDevice(dev device, kind string, rfm int) (string, error) {
+	out := new(strings.Builder)
+	err := dev.dump(out, kind, rfm)
+	if err != nil {
+		return "", err
+	}
+	return out.String(), nil
+}
+
+func (srv *server) board(id int) int {
+	for i, b := range srv.boards {
+		if b.ID == id {
+			return i
+		}
 	}
+	return -1
 }
 
 func (srv *server) handle(conn net.Conn) error {
@@ -87,16 +394,12 @@ func (srv *server) handle(conn net.Conn) error {
 	srv.msg.Printf("serving %v...", conn.RemoteAddr())
 	defer srv.msg.Printf("serving %v... [done]", conn.RemoteAddr())
 
-	srv.dev = nil
-	dev, err := srv.newDevice(
-		srv.devmem, srv.odir, srv.devshm,
-		srv.opts...,
-	)
-	if err != nil {
-		return fmt.Errorf("could not create EDA device: %w", err)
-	}
-	defer dev.Close()
-	srv.dev = dev
+	defer srv.release(conn)
+	defer func() {
+		if srv.closing() {
+			srv.stopRuns(conn)
+		}
+	}()
 
 	dim, _, err := net.SplitHostPort(conn.RemoteAddr().String())
 	if err != nil {
@@ -111,12 +414,16 @@ func (srv *server) handle(conn net.Conn) error {
 loop:
 	for {
 		var req struct {
-			Name string           `json:"name"`
-			Args *json.RawMessage `json:"args"`
+			Name  string           `json:"name"`
+			Board *int             `json:"board,omitempty"`
+			Args  *json.RawMessage `json:"args"`
 		}
 
 		err = json.NewDecoder(conn).Decode(&req)
 		if err != nil {
+			if srv.closing() {
+				break loop
+			}
 			srv.msg.Printf("could not decode command request: %+v", err)
 			srv.reply(conn, err)
 			if errors.Is(err, io.EOF) {
@@ -124,123 +431,377 @@ loop:
 			}
 			continue
 		}
-		srv.msg.Printf("received request: name=%q", req.Name)
 
-		switch strings.ToLower(req.Name) {
-		case "scan":
-			var args []conddb.RFM
-			err = json.Unmarshal(*req.Args, &args)
+		board := srv.boards[0].ID
+		if req.Board != nil {
+			board = *req.Board
+		}
+		srv.msg.Printf("received request: name=%q, board=%d", req.Name, board)
+
+		switch name := strings.ToLower(req.Name); name {
+		case "dump-registers", "dump-fifo", "dump-counters", "dump-config", "dump-disk":
+			out, err := srv.dump(board, strings.TrimPrefix(name, "dump-"), req.Args)
 			if err != nil {
-				srv.msg.Printf("could not decode %q payload: %+v",
-					req.Name, err,
-				)
-				srv.reply(conn, err)
-				continue
+				srv.msg.Printf("could not dump EDA board %d: %+v", board, err)
 			}
+			srv.replyData(conn, out, err)
+			continue
+		}
 
-			err = dev.Boot(args)
-			if err != nil {
-				srv.msg.Printf("could not bootstrap EDA: %+v", err)
-				srv.reply(conn, err)
+		devs, err := srv.acquire(conn)
+		if err != nil {
+			srv.msg.Printf("could not dispatch %q request: %+v", req.Name, err)
+			srv.reply(conn, err)
+			if errors.Is(err, errBusy) {
 				continue
 			}
+			return err
+		}
 
+		dev, ok := devs[board]
+		if !ok {
+			err = fmt.Errorf("unknown EDA board %d", board)
+			srv.msg.Printf("could not dispatch %q request: %+v", req.Name, err)
 			srv.reply(conn, err)
-			// FIXME(sbinet): compare expected scan-result with
-			// EDA introspection functions.
-			// if err != nil {
-			// 	srv.msg.Printf("could not scan EDA device: %+v", err)
-			// 	continue
-			// }
-
-		case "configure":
-			var args []struct {
-				DIF   uint8         `json:"dif"`
-				ASICs []conddb.ASIC `json:"asics"`
-			}
-			err = json.Unmarshal(*req.Args, &args)
+			continue
+		}
+
+		var (
+			q       = srv.queues[board]
+			stopped bool // whether the request stopped a run
+		)
+		err = q.do(func() error {
+			srv.syncRun(q, dev, board)
+			active := q.active()
+			err := srv.exec(conn, q, dev, board, dim, req.Name, req.Args)
+			stopped = active && !q.active()
+			return err
+		})
+		if err != nil {
+			return err
+		}
+
+		if stopped && !srv.running() {
+			break loop
+		}
+	}
+
+	return nil
+}
+
+// exec executes a request on the device of an EDA board and replies to
+// it. The queue of the board must be held.
+// exec only returns the errors that end the connection.
+func (srv *server) exec(conn net.Conn, q *boardQueue, dev device, board int, dim, name string, args *json.RawMessage) error {
+	switch strings.ToLower(name) {
+	case "scan":
+		err := q.requireIdle(board, "scan")
+		if err != nil {
+			srv.msg.Printf("%+v", err)
+			srv.reply(conn, err)
+			return nil
+		}
+
+		// the whole payload is validated before any RFM is booted.
+		args, err := decodeScan(args)
+		if err != nil {
+			srv.msg.Printf("could not decode %q payload: %+v",
+				name, err,
+			)
+			srv.reply(conn, err)
+			return nil
+		}
+
+		err = dev.Boot(args)
+		if err != nil {
+			srv.msg.Printf("could not bootstrap EDA: %+v", err)
+			srv.reply(conn, err)
+			return nil
+		}
+
+		srv.reply(conn, err)
+		// FIXME(sbinet): compare expected scan-result with
+		// EDA introspection functions.
+		// if err != nil {
+		// 	srv.msg.Printf("could not scan EDA device: %+v", err)
+		// 	return nil
+		// }
+
+	case "configure":
+		err := q.requireIdle(board, "configure")
+		if err != nil {
+			srv.msg.Printf("%+v", err)
+			srv.reply(conn, err)
+			return nil
+		}
+
+		// the whole payload is validated before any DIF is configured.
+		args, err := decodeConfigure(args)
+		if err != nil {
+			srv.msg.Printf("could not decode %q payload: %+v",
+				name, err,
+			)
+			srv.reply(conn, err)
+			return nil
+		}
+
+		// so are the device state checks of each DIF.
+		err = args.check(dev, dim)
+		if err != nil {
+			srv.msg.Printf("could not configure EDA device: %+v", err)
+			srv.reply(conn, err)
+			return nil
+		}
+
+		for _, arg := range args {
+			addr := difSink(dim, arg.DIF)
+			srv.msg.Printf("configuring DIF=%d with addr=%q", arg.DIF, addr)
+			err := dev.ConfigureDIF(addr, uint8(arg.DIF), arg.ASICs)
 			if err != nil {
-				srv.msg.Printf("could not decode %q payload: %+v",
-					req.Name, err,
-				)
+				srv.msg.Printf("could not configure EDA device(dif=%d): %+v", arg.DIF, err)
 				srv.reply(conn, err)
-				continue
+				return nil
 			}
-
-			for _, arg := range args {
-				addr := fmt.Sprintf("%s:%d", dim, 10000+int(arg.DIF))
-				srv.msg.Printf("configuring DIF=%d with addr=%q", arg.DIF, addr)
-				err := dev.ConfigureDIF(addr, arg.DIF, arg.ASICs)
+			for _, mon := range arg.Monitors {
+				srv.msg.Printf("configuring DIF=%d with monitor=%q", arg.DIF, mon)
+				err := dev.AddDIFMonitor(mon, uint8(arg.DIF))
 				if err != nil {
-					srv.msg.Printf("could not configure EDA device(dif=%d): %+v", arg.DIF, err)
+					srv.msg.Printf("could not add monitor to EDA device(dif=%d): %+v", arg.DIF, err)
 					srv.reply(conn, err)
-					continue
+					return nil
 				}
 			}
-			srv.reply(conn, nil)
+		}
+		srv.reply(conn, nil)
 
-		case "initialize":
+	case "initialize":
+		err := q.requireIdle(board, "initialize")
+		if err == nil {
 			err = dev.Initialize()
+		}
+		srv.reply(conn, err)
+		if err != nil {
+			srv.msg.Printf("could not initialize EDA device: %+v", err)
+			return nil
+		}
+
+	case "start":
+		err := q.requireIdle(board, "start")
+		if err != nil {
+			srv.msg.Printf("%+v", err)
 			srv.reply(conn, err)
-			if err != nil {
-				srv.msg.Printf("could not initialize EDA device: %+v", err)
-				continue
-			}
+			return nil
+		}
 
-		case "start":
-			var args []string
-			err = json.Unmarshal(*req.Args, &args)
-			if err != nil {
-				srv.msg.Printf("could not decode %q payload: %+v",
-					req.Name, err,
-				)
-				srv.reply(conn, err)
-				continue
-			}
+		// args: run number, optionally followed by run limits
+		// overriding the ones of the device ("max-duration=8h",
+		// "max-cycles=1000").
+		var vs []string
+		if args != nil {
+			err = json.Unmarshal(*args, &vs)
+		}
+		if err == nil && len(vs) == 0 {
+			err = fmt.Errorf("missing run number")
+		}
+		if err != nil {
+			srv.msg.Printf("could not decode %q payload: %+v",
+				name, err,
+			)
+			srv.reply(conn, err)
+			return nil
+		}
 
-			run, err := strconv.Atoi(args[0])
-			if err != nil {
-				srv.msg.Printf("could not decode run-nbr for start-run (args=%v): %+v",
-					req.Args, err,
-				)
-				srv.reply(conn, err)
-				continue
-			}
+		run, err := strconv.Atoi(vs[0])
+		if err != nil {
+			srv.msg.Printf("could not decode run-nbr for start-run (args=%v): %+v",
+				vs, err,
+			)
+			srv.reply(conn, err)
+			return nil
+		}
 
+		if len(vs) > 1 {
+			err = srv.startLimited(dev, uint32(run), vs[1:])
+		} else {
 			err = dev.Start(uint32(run))
+		}
+		srv.reply(conn, err)
+		if err != nil {
+			srv.msg.Printf("could not start EDA device: %+v", err)
+			return nil
+		}
+		q.state = runActive
+
+	case "drain-fifos":
+		err := q.requireIdle(board, "drain FIFOs of")
+		if err != nil {
+			srv.msg.Printf("%+v", err)
 			srv.reply(conn, err)
-			if err != nil {
-				srv.msg.Printf("could not start EDA device: %+v", err)
-				continue
+			return nil
+		}
+		words, err := dev.DrainFIFOs()
+		if err != nil {
+			srv.msg.Printf("could not drain FIFOs of EDA device: %+v", err)
+		}
+		out := new(strings.Builder)
+		for rfm := 0; rfm < nRFM; rfm++ {
+			if n, ok := words[rfm]; ok {
+				fmt.Fprintf(out, "rfm=%d: %d word(s) discarded\n", rfm, n)
 			}
+		}
+		srv.replyData(conn, out.String(), err)
+
+	case "export-sc":
+		// args: RFM slot.
+		// data: base64 encoded slow-control image (see Device.ExportSC).
+		img, err := srv.exportSC(dev, args)
+		if err != nil {
+			srv.msg.Printf("could not export slow-control image: %+v", err)
+		}
+		srv.replyData(conn, base64.StdEncoding.EncodeToString(img), err)
 
-		case "stop":
-			err = dev.Stop()
+	case "import-sc":
+		// args: {"rfm": slot, "image": base64 encoded slow-control image}
+		err := q.requireIdle(board, "import slow-control image to")
+		if err != nil {
+			srv.msg.Printf("%+v", err)
 			srv.reply(conn, err)
-			if err != nil {
-				srv.msg.Printf("could not stop EDA device: %+v", err)
-				return fmt.Errorf("could not stop EDA device: %w", err)
-			}
-			break loop
+			return nil
+		}
+		err = srv.importSC(dev, args)
+		if err != nil {
+			srv.msg.Printf("could not import slow-control image: %+v", err)
+		}
+		srv.reply(conn, err)
 
-		default:
-			srv.msg.Printf("unknown command name=%q, args=%q", req.Name, req.Args)
-			err = fmt.Errorf("unknown command %q", req.Name)
+	case "stop":
+		err := q.requireRun(board, "stop")
+		if err != nil {
+			srv.msg.Printf("%+v", err)
 			srv.reply(conn, err)
-			continue
+			return nil
+		}
+		// the outcome of a run that ended on its own is the one of its
+		// first stop (see Device.Stop).
+		err = dev.Stop()
+		q.state = runIdle
+		srv.reply(conn, err)
+		if err != nil {
+			srv.msg.Printf("could not stop EDA device: %+v", err)
+			return fmt.Errorf("could not stop EDA device (board=%d): %w", board, err)
 		}
+
+	default:
+		srv.msg.Printf("unknown command name=%q, args=%q", name, args)
+		err := fmt.Errorf("unknown command %q", name)
+		srv.reply(conn, err)
 	}
 
 	return nil
 }
 
+// syncRun updates the state of a board whose run ended on its own.
+// The board stays in the runEnded state until the run is stopped, so the
+// stop request gets the outcome of the run.
+// The queue of the board must be held.
+func (srv *server) syncRun(q *boardQueue, dev device, board int) {
+	if q.state != runActive || !hasEnded(dev) {
+		return
+	}
+	srv.msg.Printf("run of EDA board %d ended", board)
+	q.state = runEnded
+}
+
+// running returns whether a run was started and not yet stopped on any
+// EDA board.
+func (srv *server) running() bool {
+	for _, q := range srv.queues {
+		q.mu.Lock()
+		active := q.active()
+		q.mu.Unlock()
+		if active {
+			return true
+		}
+	}
+	return false
+}
+
+// startLimited starts a run with the limits given by the arguments of a
+// start request (see parseRunLimits).
+func (srv *server) startLimited(dev device, run uint32, args []string) error {
+	lim, err := parseRunLimits(srv.lim, args)
+	if err != nil {
+		return err
+	}
+	rl, ok := dev.(runLimiter)
+	if !ok {
+		return fmt.Errorf("eda: run limits not supported by device backend")
+	}
+	return rl.startLimited(run, lim)
+}
+
+// exportSC returns the slow-control image of the RFM slot given as
+// argument of an export-sc request.
+func (srv *server) exportSC(dev device, args *json.RawMessage) ([]byte, error) {
+	var vs []int
+	if args != nil {
+		err := json.Unmarshal(*args, &vs)
+		if err != nil {
+			return nil, fmt.Errorf("could not decode RFM slot: %w", err)
+		}
+	}
+	if len(vs) != 1 {
+		return nil, fmt.Errorf("invalid number of arguments (got=%d, want=1)", len(vs))
+	}
+	sc, ok := dev.(scImager)
+	if !ok {
+		return nil, fmt.Errorf("eda: slow-control images not supported by device backend")
+	}
+	return sc.ExportSC(vs[0])
+}
+
+// importSC sends the slow-control image of an import-sc request to the
+// hardrocs of its RFM slot.
+func (srv *server) importSC(dev device, args *json.RawMessage) error {
+	var arg struct {
+		RFM   *int   `json:"rfm"`
+		Image []byte `json:"image"`
+	}
+	if args != nil {
+		err := json.Unmarshal(*args, &arg)
+		if err != nil {
+			return fmt.Errorf("could not decode slow-control image: %w", err)
+		}
+	}
+	if arg.RFM == nil {
+		return fmt.Errorf("missing RFM slot")
+	}
+	sc, ok := dev.(scImager)
+	if !ok {
+		return fmt.Errorf("eda: slow-control images not supported by device backend")
+	}
+	return sc.ImportSC(*arg.RFM, arg.Image)
+}
+
 func (srv *server) reply(conn net.Conn, err error) {
+	srv.replyData(conn, "", err)
+}
+
+// replyData replies to a request, with the provided data payload.
+// Requests rejected because the EDA boards are busy are replied with the
+// "busy" code, so clients can retry them later.
+func (srv *server) replyData(conn net.Conn, data string, err error) {
 	rep := struct {
-		Msg string `json:"msg"`
-	}{"ok"}
+		Msg  string `json:"msg"`
+		Code string `json:"code,omitempty"`
+		Data string `json:"data,omitempty"`
+	}{Msg: "ok", Data: data}
 	if err != nil {
 		rep.Msg = fmt.Sprintf("%+v", err)
 	}
+	if errors.Is(err, errBusy) {
+		rep.Code = "busy"
+	}
 
 	_ = json.NewEncoder(conn).Encode(rep)
 }
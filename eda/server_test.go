@@ -10,11 +10,15 @@ import (
 	"errors"
 	"fmt"
 	"io"
+	"io/ioutil"
+	"log"
 	"net"
 	"os"
+	"reflect"
 	"strconv"
 	"strings"
 	"testing"
+	"time"
 
 	"github.com/go-lpc/mim/conddb"
 	"github.com/go-lpc/mim/eda/internal/regs"
@@ -52,7 +56,7 @@ func TestServer(t *testing.T) {
 	addr = "localhost:" + addr
 
 	srv, err := newServer(
-		addr, odir, fdev.mem, fdev.shm,
+		addr, []Board{{DevMem: fdev.mem, DevSHM: fdev.shm, ODir: odir}},
 		func(cfg *config) { cfg.mode = "db" },
 		WithRFMMask(1<<1), // dummy
 	)
@@ -215,7 +219,7 @@ func TestServer(t *testing.T) {
 				t.Fatalf("could not send %q: %+v", name, err)
 			}
 			ack(name)
-			fdev.fpga(srv.dev.(*Device), 2, regs.O_SC_DONE_2, nil)
+			fdev.fpga(srv.devs[0].(*Device), 2, regs.O_SC_DONE_2, nil)
 
 		case "err-invalid-req":
 			_, err = dim.Write([]byte("{]"))
@@ -394,3 +398,669 @@ func getTCPPort() (string, error) {
 	defer l.Close()
 	return strconv.Itoa(l.Addr().(*net.TCPAddr).Port), nil
 }
+
+type stubDevice struct {
+	board string
+	cmds  *[]string
+}
+
+func (dev *stubDevice) record(cmd string) error {
+	*dev.cmds = append(*dev.cmds, dev.board+":"+cmd)
+	return nil
+}
+
+func (dev *stubDevice) Boot([]conddb.RFM) error { return dev.record("scan") }
+func (dev *stubDevice) ConfigureDIF(addr string, dif uint8, asics []conddb.ASIC) error {
+	return dev.record("configure")
+}
+func (dev *stubDevice) AddDIFMonitor(addr string, dif uint8) error {
+	return dev.record("monitor")
+}
+func (dev *stubDevice) Initialize() error      { return dev.record("initialize") }
+func (dev *stubDevice) Start(run uint32) error { return dev.record("start") }
+func (dev *stubDevice) Stop() error            { return dev.record("stop") }
+func (dev *stubDevice) Close() error           { return dev.record("close") }
+
+func (dev *stubDevice) startLimited(run uint32, lim runLimits) error {
+	return dev.record(fmt.Sprintf("start(%v, %d)", lim.dur, lim.cycles))
+}
+
+func (dev *stubDevice) ExportSC(rfm int) ([]byte, error) {
+	return []byte{1, 2, 3}, dev.record(fmt.Sprintf("export-sc(%d)", rfm))
+}
+
+func (dev *stubDevice) ImportSC(rfm int, img []byte) error {
+	return dev.record(fmt.Sprintf("import-sc(%d, %v)", rfm, img))
+}
+
+func (dev *stubDevice) DrainFIFOs() (map[int]uint32, error) {
+	return map[int]uint32{0: 0, 1: 5}, dev.record("drain-fifos")
+}
+
+func (dev *stubDevice) dump(w io.Writer, kind string, rfm int) error {
+	fmt.Fprintf(w, "%s:%s:%d", dev.board, kind, rfm)
+	return dev.record("dump-" + kind)
+}
+
+func TestServerBoards(t *testing.T) {
+	addr, err := getTCPPort()
+	if err != nil {
+		t.Fatalf("could not get TCP port: %+v", err)
+	}
+	addr = "localhost:" + addr
+
+	_, err = newServer(addr, []Board{{ID: 1}, {ID: 1}})
+	if err == nil {
+		t.Fatalf("expected an error")
+	}
+	if got, want := err.Error(), "duplicate EDA board ID 1"; got != want {
+		t.Fatalf("invalid error: got=%q, want=%q", got, want)
+	}
+
+	srv, err := newServer(addr, []Board{
+		{ID: 1, DevMem: "board-1"},
+		{ID: 2, DevMem: "board-2"},
+	})
+	if err != nil {
+		t.Fatalf("could not create server: %+v", err)
+	}
+
+	var cmds []string
+	srv.newDevice = func(devmem, odir, devshm string, opts ...Option) (device, error) {
+		return &stubDevice{board: devmem, cmds: &cmds}, nil
+	}
+
+	errch := make(chan error)
+	go func() {
+		errch <- srv.serve()
+	}()
+
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatalf("could not dial eda-srv: %+v", err)
+	}
+	defer conn.Close()
+
+	for _, tc := range []struct {
+		req  string
+		want string
+	}{
+		{`{"name":"scan", "args":[]}`, "ok"},
+		{`{"name":"scan", "board":2, "args":[]}`, "ok"},
+		{`{"name":"scan", "board":3, "args":[]}`, "unknown EDA board 3"},
+		{`{"name":"configure", "board":2, "args":[{"dif":1, "asics":[{},{},{},{},{},{},{},{}], "monitors":["mon:10001", "mon:20001"]}]}`, "ok"},
+		{`{"name":"initialize", "board":1}`, "ok"},
+		{`{"name":"drain-fifos", "board":1}`, "ok"},
+		{`{"name":"start", "board":2, "args":["42"]}`, "ok"},
+		{`{"name":"drain-fifos", "board":2}`, "could not drain FIFOs of EDA board 2: run in progress"},
+		{`{"name":"start", "board":1, "args":["42", "max-cycles=x"]}`, `eda: invalid maximum number of cycles "x"`},
+		{`{"name":"start", "board":1, "args":["42", "max-duration=8h", "max-cycles=1000"]}`, "ok"},
+		{`{"name":"stop", "board":2}`, "ok"},
+		{`{"name":"stop", "board":1}`, "ok"},
+	} {
+		_, err = conn.Write([]byte(tc.req))
+		if err != nil {
+			t.Fatalf("could not send %q: %+v", tc.req, err)
+		}
+		var rep struct {
+			Msg string `json:"msg"`
+		}
+		err = json.NewDecoder(conn).Decode(&rep)
+		if err != nil {
+			t.Fatalf("could not read reply to %q: %+v", tc.req, err)
+		}
+		if got, want := rep.Msg, tc.want; got != want {
+			t.Fatalf("invalid reply to %q: got=%q, want=%q", tc.req, got, want)
+		}
+	}
+
+	srv.close()
+	err = <-errch
+	if err != nil && !isErrClosed(err) {
+		t.Fatalf("could not run server: %+v", err)
+	}
+
+	want := []string{
+		"board-1:scan",
+		"board-2:scan",
+		"board-2:configure",
+		"board-2:monitor",
+		"board-2:monitor",
+		"board-1:initialize",
+		"board-1:drain-fifos",
+		"board-2:start",
+		"board-1:start(8h0m0s, 1000)",
+		"board-2:stop",
+		"board-1:stop",
+	}
+	if got := cmds[:len(want)]; !reflect.DeepEqual(got, want) {
+		t.Fatalf("invalid commands:\ngot= %q\nwant=%q", got, want)
+	}
+	if got, want := len(cmds), len(want)+2; got != want {
+		t.Fatalf("invalid number of commands: got=%d, want=%d (%q)", got, want, cmds)
+	}
+}
+
+func TestServerSC(t *testing.T) {
+	addr, err := getTCPPort()
+	if err != nil {
+		t.Fatalf("could not get TCP port: %+v", err)
+	}
+	addr = "localhost:" + addr
+
+	srv, err := newServer(addr, []Board{{ID: 1, DevMem: "board-1"}})
+	if err != nil {
+		t.Fatalf("could not create server: %+v", err)
+	}
+
+	var cmds []string
+	srv.newDevice = func(devmem, odir, devshm string, opts ...Option) (device, error) {
+		return &stubDevice{board: devmem, cmds: &cmds}, nil
+	}
+
+	errch := make(chan error)
+	go func() {
+		errch <- srv.serve()
+	}()
+
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatalf("could not dial eda-srv: %+v", err)
+	}
+	defer conn.Close()
+
+	for _, tc := range []struct {
+		req  string
+		msg  string
+		data string
+	}{
+		{req: `{"name":"export-sc", "args":[1]}`, msg: "ok", data: "AQID"},
+		{req: `{"name":"export-sc", "args":[]}`, msg: "invalid number of arguments (got=0, want=1)"},
+		{req: `{"name":"import-sc", "args":{"rfm":2, "image":"AQID"}}`, msg: "ok"},
+		{req: `{"name":"import-sc", "args":{"image":"AQID"}}`, msg: "missing RFM slot"},
+		{req: `{"name":"start", "args":["42"]}`, msg: "ok"},
+		{req: `{"name":"import-sc", "args":{"rfm":2, "image":"AQID"}}`, msg: "could not import slow-control image to EDA board 1: run in progress"},
+		{req: `{"name":"stop"}`, msg: "ok"},
+	} {
+		_, err = conn.Write([]byte(tc.req))
+		if err != nil {
+			t.Fatalf("could not send %q: %+v", tc.req, err)
+		}
+		var rep struct {
+			Msg  string `json:"msg"`
+			Data string `json:"data"`
+		}
+		err = json.NewDecoder(conn).Decode(&rep)
+		if err != nil {
+			t.Fatalf("could not read reply to %q: %+v", tc.req, err)
+		}
+		if got, want := rep.Msg, tc.msg; got != want {
+			t.Fatalf("invalid reply to %q: got=%q, want=%q", tc.req, got, want)
+		}
+		if got, want := rep.Data, tc.data; got != want {
+			t.Fatalf("invalid data for %q: got=%q, want=%q", tc.req, got, want)
+		}
+	}
+
+	srv.close()
+	err = <-errch
+	if err != nil && !isErrClosed(err) {
+		t.Fatalf("could not run server: %+v", err)
+	}
+
+	want := []string{
+		"board-1:export-sc(1)",
+		"board-1:import-sc(2, [1 2 3])",
+		"board-1:start",
+		"board-1:stop",
+	}
+	if got := cmds[:len(want)]; !reflect.DeepEqual(got, want) {
+		t.Fatalf("invalid commands:\ngot= %q\nwant=%q", got, want)
+	}
+}
+
+func TestServerDump(t *testing.T) {
+	addr, err := getTCPPort()
+	if err != nil {
+		t.Fatalf("could not get TCP port: %+v", err)
+	}
+	addr = "localhost:" + addr
+
+	srv, err := newServer(addr, []Board{
+		{ID: 1, DevMem: "board-1"},
+		{ID: 2, DevMem: "board-2"},
+	})
+	if err != nil {
+		t.Fatalf("could not create server: %+v", err)
+	}
+
+	var cmds []string
+	srv.newDevice = func(devmem, odir, devshm string, opts ...Option) (device, error) {
+		return &stubDevice{board: devmem, cmds: &cmds}, nil
+	}
+
+	errch := make(chan error)
+	go func() {
+		errch <- srv.serve()
+	}()
+
+	dial := func() net.Conn {
+		conn, err := net.Dial("tcp", addr)
+		if err != nil {
+			t.Fatalf("could not dial eda-srv: %+v", err)
+		}
+		return conn
+	}
+
+	type reply struct {
+		Msg  string `json:"msg"`
+		Data string `json:"data"`
+	}
+	send := func(conn net.Conn, req string) reply {
+		_, err := conn.Write([]byte(req))
+		if err != nil {
+			t.Fatalf("could not send %q: %+v", req, err)
+		}
+		var rep reply
+		err = json.NewDecoder(conn).Decode(&rep)
+		if err != nil {
+			t.Fatalf("could not read reply to %q: %+v", req, err)
+		}
+		return rep
+	}
+
+	ctl := dial()
+	defer ctl.Close()
+	spy := dial()
+	defer spy.Close()
+
+	for _, tc := range []struct {
+		conn net.Conn
+		req  string
+		want reply
+	}{
+		// no controlling connection: devices are created for the dump.
+		{spy, `{"name":"dump-registers", "board":2}`, reply{Msg: "ok", Data: "board-2:registers:0"}},
+		{ctl, `{"name":"scan", "args":[]}`, reply{Msg: "ok"}},
+		{spy, `{"name":"dump-fifo", "board":1, "args":[3]}`, reply{Msg: "ok", Data: "board-1:fifo:3"}},
+		{spy, `{"name":"dump-fifo", "board":1}`, reply{Msg: "invalid number of arguments (got=0, want=1)"}},
+		{spy, `{"name":"dump-counters", "board":1, "args":[2]}`, reply{Msg: "ok", Data: "board-1:counters:2"}},
+		{spy, `{"name":"dump-config", "board":1}`, reply{Msg: "invalid number of arguments (got=0, want=1)"}},
+		{spy, `{"name":"dump-registers", "board":3}`, reply{Msg: "unknown EDA board 3"}},
+		{spy, `{"name":"initialize"}`, reply{Msg: "EDA boards busy (controlled by " + ctl.LocalAddr().String() + ")"}},
+		{ctl, `{"name":"dump-registers"}`, reply{Msg: "ok", Data: "board-1:registers:0"}},
+	} {
+		rep := send(tc.conn, tc.req)
+		if got, want := rep, tc.want; got != want {
+			t.Fatalf("invalid reply to %q:\ngot= %+v\nwant=%+v", tc.req, got, want)
+		}
+	}
+
+	_ = ctl.Close()
+	_ = spy.Close()
+	srv.close()
+	err = <-errch
+	if err != nil && !isErrClosed(err) {
+		t.Fatalf("could not run server: %+v", err)
+	}
+
+	want := []string{
+		"board-2:dump-registers",
+		"board-2:close",
+		"board-1:scan",
+		"board-1:dump-fifo",
+		"board-1:dump-counters",
+		"board-1:dump-registers",
+	}
+	if got := cmds[:len(want)]; !reflect.DeepEqual(got, want) {
+		t.Fatalf("invalid commands:\ngot= %q\nwant=%q", got, want)
+	}
+}
+
+func TestServerShutdown(t *testing.T) {
+	addr, err := getTCPPort()
+	if err != nil {
+		t.Fatalf("could not get TCP port: %+v", err)
+	}
+	addr = "localhost:" + addr
+
+	srv, err := newServer(addr, []Board{
+		{ID: 1, DevMem: "board-1"},
+		{ID: 2, DevMem: "board-2"},
+	})
+	if err != nil {
+		t.Fatalf("could not create server: %+v", err)
+	}
+	srv.msg = log.New(ioutil.Discard, "", 0)
+
+	var cmds []string
+	srv.newDevice = func(devmem, odir, devshm string, opts ...Option) (device, error) {
+		return &stubDevice{board: devmem, cmds: &cmds}, nil
+	}
+
+	errch := make(chan error)
+	go func() {
+		errch <- srv.serve()
+	}()
+
+	ctl, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatalf("could not dial eda-srv: %+v", err)
+	}
+	defer ctl.Close()
+
+	// idle connection, with no request.
+	spy, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatalf("could not dial eda-srv: %+v", err)
+	}
+	defer spy.Close()
+
+	for _, req := range []string{
+		`{"name":"scan", "args":[]}`,
+		`{"name":"start", "board":1, "args":["42"]}`,
+		`{"name":"start", "board":2, "args":["42"]}`,
+		`{"name":"stop", "board":1}`,
+		`{"name":"start", "board":1, "args":["43"]}`,
+	} {
+		_, err = ctl.Write([]byte(req))
+		if err != nil {
+			t.Fatalf("could not send %q: %+v", req, err)
+		}
+		var rep struct {
+			Msg string `json:"msg"`
+		}
+		err = json.NewDecoder(ctl).Decode(&rep)
+		if err != nil {
+			t.Fatalf("could not read reply to %q: %+v", req, err)
+		}
+		if rep.Msg != "ok" {
+			t.Fatalf("invalid reply to %q: %q", req, rep.Msg)
+		}
+	}
+
+	srv.shutdown()
+	select {
+	case err = <-errch:
+		if err != nil {
+			t.Fatalf("could not shut down server: %+v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("timeout shutting down server")
+	}
+
+	want := []string{
+		"board-1:scan",
+		"board-1:start",
+		"board-2:start",
+		"board-1:stop",
+		"board-1:start",
+		"board-1:stop",
+		"board-2:stop",
+	}
+	if got := cmds[:len(want)]; !reflect.DeepEqual(got, want) {
+		t.Fatalf("invalid commands:\ngot= %q\nwant=%q", got, want)
+	}
+	if got, want := len(cmds), len(want)+2; got != want {
+		t.Fatalf("invalid number of commands: got=%d, want=%d (%q)", got, want, cmds)
+	}
+}
+
+// slowDevice is a stubDevice whose first Boot blocks until unblocked.
+type slowDevice struct {
+	stubDevice
+	boot    chan struct{} // closed once Boot was called
+	unblock chan struct{} // closed to let Boot return
+}
+
+func (dev *slowDevice) Boot(rfms []conddb.RFM) error {
+	select {
+	case <-dev.boot:
+	default:
+		close(dev.boot)
+		<-dev.unblock
+	}
+	return dev.stubDevice.Boot(rfms)
+}
+
+func TestServerQueue(t *testing.T) {
+	addr, err := getTCPPort()
+	if err != nil {
+		t.Fatalf("could not get TCP port: %+v", err)
+	}
+	addr = "localhost:" + addr
+
+	srv, err := newServer(addr, []Board{{ID: 1, DevMem: "board-1"}})
+	if err != nil {
+		t.Fatalf("could not create server: %+v", err)
+	}
+	srv.msg = log.New(ioutil.Discard, "", 0)
+
+	var (
+		cmds []string
+		dev  = &slowDevice{
+			stubDevice: stubDevice{board: "board-1", cmds: &cmds},
+			boot:       make(chan struct{}),
+			unblock:    make(chan struct{}),
+		}
+	)
+	srv.newDevice = func(devmem, odir, devshm string, opts ...Option) (device, error) {
+		return dev, nil
+	}
+
+	errch := make(chan error)
+	go func() {
+		errch <- srv.serve()
+	}()
+
+	dial := func() net.Conn {
+		conn, err := net.Dial("tcp", addr)
+		if err != nil {
+			t.Fatalf("could not dial eda-srv: %+v", err)
+		}
+		return conn
+	}
+
+	type reply struct {
+		Msg  string `json:"msg"`
+		Code string `json:"code"`
+		Data string `json:"data"`
+	}
+	send := func(conn net.Conn, req string) {
+		_, err := conn.Write([]byte(req))
+		if err != nil {
+			t.Fatalf("could not send %q: %+v", req, err)
+		}
+	}
+	recv := func(conn net.Conn, req string) reply {
+		var rep reply
+		err := json.NewDecoder(conn).Decode(&rep)
+		if err != nil {
+			t.Fatalf("could not read reply to %q: %+v", req, err)
+		}
+		return rep
+	}
+
+	ctl := dial()
+	defer ctl.Close()
+	spy := dial()
+	defer spy.Close()
+
+	// a dump sent while the board is being scanned waits for the scan.
+	const scan = `{"name":"scan", "args":[]}`
+	send(ctl, scan)
+	<-dev.boot
+
+	const dump = `{"name":"dump-registers"}`
+	send(spy, dump)
+	time.Sleep(50 * time.Millisecond)
+
+	// a pending dump does not block the requests of other connections.
+	other := dial()
+	defer other.Close()
+	const otherScan = `{"name":"scan", "args":[]}`
+	send(other, otherScan)
+	if got := recv(other, otherScan); got.Code != "busy" {
+		t.Fatalf("invalid reply to %q: %+v", otherScan, got)
+	}
+	_ = other.Close()
+	close(dev.unblock)
+
+	if got, want := recv(ctl, scan), (reply{Msg: "ok"}); got != want {
+		t.Fatalf("invalid reply to %q:\ngot= %+v\nwant=%+v", scan, got, want)
+	}
+	if got, want := recv(spy, dump), (reply{Msg: "ok", Data: "board-1:registers:0"}); got != want {
+		t.Fatalf("invalid reply to %q:\ngot= %+v\nwant=%+v", dump, got, want)
+	}
+
+	for _, tc := range []struct {
+		req  string
+		want reply
+	}{
+		{`{"name":"stop"}`, reply{Msg: "could not stop EDA board 1: no run in progress"}},
+		{`{"name":"start"}`, reply{Msg: "missing run number"}},
+		{`{"name":"start", "args":[]}`, reply{Msg: "missing run number"}},
+		{`{"name":"start", "args":["42"]}`, reply{Msg: "ok"}},
+		{`{"name":"start", "args":["43"]}`, reply{Msg: "could not start EDA board 1: run in progress", Code: "busy"}},
+		{`{"name":"configure", "args":[]}`, reply{Msg: "could not configure EDA board 1: run in progress", Code: "busy"}},
+		{`{"name":"initialize"}`, reply{Msg: "could not initialize EDA board 1: run in progress", Code: "busy"}},
+		{`{"name":"drain-fifos"}`, reply{Msg: "could not drain FIFOs of EDA board 1: run in progress", Code: "busy"}},
+		{`{"name":"stop"}`, reply{Msg: "ok"}},
+	} {
+		send(ctl, tc.req)
+		if got := recv(ctl, tc.req); got != tc.want {
+			t.Fatalf("invalid reply to %q:\ngot= %+v\nwant=%+v", tc.req, got, tc.want)
+		}
+	}
+
+	// the controlling connection is released once its last run is stopped.
+	const spyScan = `{"name":"scan", "args":[]}`
+	for i := 0; ; i++ {
+		send(spy, spyScan)
+		rep := recv(spy, spyScan)
+		if rep.Msg == "ok" {
+			break
+		}
+		if rep.Code != "busy" || i > 100 {
+			t.Fatalf("invalid reply to %q: %+v", spyScan, rep)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	_ = ctl.Close()
+	_ = spy.Close()
+	srv.close()
+	err = <-errch
+	if err != nil && !isErrClosed(err) {
+		t.Fatalf("could not run server: %+v", err)
+	}
+
+	want := []string{
+		"board-1:scan",
+		"board-1:dump-registers",
+		"board-1:start",
+		"board-1:stop",
+		"board-1:close",
+	}
+	if got := cmds[:len(want)]; !reflect.DeepEqual(got, want) {
+		t.Fatalf("invalid commands:\ngot= %q\nwant=%q", got, want)
+	}
+}
+
+// endingDevice is a stubDevice whose runs end on their own once done is
+// closed.
+type endingDevice struct {
+	stubDevice
+	done chan struct{}
+}
+
+func (dev *endingDevice) Done() <-chan struct{} { return dev.done }
+
+func TestServerRunEnded(t *testing.T) {
+	addr, err := getTCPPort()
+	if err != nil {
+		t.Fatalf("could not get TCP port: %+v", err)
+	}
+	addr = "localhost:" + addr
+
+	srv, err := newServer(addr, []Board{{ID: 1, DevMem: "board-1"}})
+	if err != nil {
+		t.Fatalf("could not create server: %+v", err)
+	}
+	srv.msg = log.New(ioutil.Discard, "", 0)
+
+	var (
+		cmds []string
+		dev  = &endingDevice{
+			stubDevice: stubDevice{board: "board-1", cmds: &cmds},
+			done:       make(chan struct{}),
+		}
+	)
+	srv.newDevice = func(devmem, odir, devshm string, opts ...Option) (device, error) {
+		return dev, nil
+	}
+
+	errch := make(chan error)
+	go func() {
+		errch <- srv.serve()
+	}()
+
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatalf("could not dial eda-srv: %+v", err)
+	}
+	defer conn.Close()
+
+	for _, tc := range []struct {
+		req  string
+		want string
+	}{
+		{`{"name":"start", "args":["42"]}`, "ok"},
+		{`{"name":"initialize"}`, "could not initialize EDA board 1: run in progress"},
+		{"end", ""},
+		{`{"name":"initialize"}`, "could not initialize EDA board 1: run ended, waiting for stop"},
+		{`{"name":"stop"}`, "ok"},
+	} {
+		if tc.req == "end" {
+			// the run reaches one of its limits.
+			close(dev.done)
+			continue
+		}
+		_, err = conn.Write([]byte(tc.req))
+		if err != nil {
+			t.Fatalf("could not send %q: %+v", tc.req, err)
+		}
+		var rep struct {
+			Msg string `json:"msg"`
+		}
+		err = json.NewDecoder(conn).Decode(&rep)
+		if err != nil {
+			t.Fatalf("could not read reply to %q: %+v", tc.req, err)
+		}
+		if got, want := rep.Msg, tc.want; got != want {
+			t.Fatalf("invalid reply to %q: got=%q, want=%q", tc.req, got, want)
+		}
+	}
+
+	// the connection is closed once the last run is stopped.
+	_, err = conn.Read(make([]byte, 1))
+	if !errors.Is(err, io.EOF) {
+		t.Fatalf("expected the connection to be closed: %+v", err)
+	}
+
+	_ = conn.Close()
+	srv.close()
+	err = <-errch
+	if err != nil && !isErrClosed(err) {
+		t.Fatalf("could not run server: %+v", err)
+	}
+
+	want := []string{
+		"board-1:start",
+		"board-1:stop",
+		"board-1:close",
+	}
+	if got := cmds[:len(want)]; !reflect.DeepEqual(got, want) {
+		t.Fatalf("invalid commands:\ngot= %q\nwant=%q", got, want)
+	}
+}
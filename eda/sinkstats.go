@@ -0,0 +1,78 @@
+// Copyright 2020 The go-lpc Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package eda
+
+import (
+	"strconv"
+	"time"
+)
+
+// sinkStats holds the kernel statistics of the TCP connection to a DIF
+// data sink.
+type sinkStats struct {
+	rtt     time.Duration // smoothed round-trip time
+	retrans uint32        // number of retransmitted segments
+	sendq   int           // number of bytes not yet acknowledged by the sink
+}
+
+// watchSinks polls the kernel statistics of the connections to the DIF
+// data sinks, as configured with WithSinkStats, until the returned
+// function is called.
+func (dev *Device) watchSinks() func() {
+	period := dev.cfg.daq.stats
+	if period <= 0 || dev.cfg.metrics == nil {
+		return func() {}
+	}
+
+	var (
+		quit = make(chan struct{})
+		done = make(chan struct{})
+	)
+	go func() {
+		defer close(done)
+
+		tck := time.NewTicker(period)
+		defer tck.Stop()
+
+		failed := make(map[int]bool)
+		for {
+			dev.pollSinks(failed)
+			select {
+			case <-quit:
+				return
+			case <-tck.C:
+			}
+		}
+	}()
+
+	return func() {
+		close(quit)
+		<-done
+	}
+}
+
+// pollSinks records the kernel statistics of the connections to the DIF
+// data sinks of the active RFMs.
+// Failures are only logged once per RFM, in failed.
+func (dev *Device) pollSinks(failed map[int]bool) {
+	for _, slot := range dev.rfms {
+		rfm := &dev.daq.rfm[slot]
+		if !rfm.valid() || rfm.sck == nil {
+			continue
+		}
+		st, err := tcpStats(rfm.sck)
+		if err != nil {
+			if !failed[slot] {
+				dev.msg.Printf("could not poll statistics of DIF data sink (RFM=%d): %+v", slot, err)
+				failed[slot] = true
+			}
+			continue
+		}
+		name := "eda.sink." + strconv.Itoa(slot)
+		dev.gauge(name+".rtt", st.rtt.Seconds())
+		dev.gauge(name+".retrans", float64(st.retrans))
+		dev.gauge(name+".sendq", float64(st.sendq))
+	}
+}
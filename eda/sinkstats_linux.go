@@ -0,0 +1,54 @@
+// Copyright 2020 The go-lpc Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package eda
+
+import (
+	"fmt"
+	"net"
+	"syscall"
+	"time"
+
+	"golang.org/x/sys/unix"
+)
+
+// tcpStats returns the kernel statistics of the provided TCP connection,
+// from TCP_INFO and the size of its send queue.
+func tcpStats(conn net.Conn) (sinkStats, error) {
+	if c, ok := conn.(interface{ NetConn() net.Conn }); ok {
+		conn = c.NetConn() // e.g. TLS connections, with Go >= 1.18.
+	}
+	sc, ok := conn.(syscall.Conn)
+	if !ok {
+		return sinkStats{}, fmt.Errorf("eda: no socket statistics for %T connections", conn)
+	}
+	raw, err := sc.SyscallConn()
+	if err != nil {
+		return sinkStats{}, fmt.Errorf("eda: could not access socket: %w", err)
+	}
+
+	var (
+		st   sinkStats
+		serr error
+	)
+	err = raw.Control(func(fd uintptr) {
+		info, err := unix.GetsockoptTCPInfo(int(fd), unix.IPPROTO_TCP, unix.TCP_INFO)
+		if err != nil {
+			serr = fmt.Errorf("eda: could not read TCP_INFO: %w", err)
+			return
+		}
+		st.rtt = time.Duration(info.Rtt) * time.Microsecond
+		st.retrans = info.Total_retrans
+
+		st.sendq, err = unix.IoctlGetInt(int(fd), unix.SIOCOUTQ)
+		if err != nil {
+			serr = fmt.Errorf("eda: could not read send queue size: %w", err)
+			return
+		}
+	})
+	if err != nil {
+		return sinkStats{}, fmt.Errorf("eda: could not access socket: %w", err)
+	}
+	return st, serr
+}
@@ -0,0 +1,18 @@
+// Copyright 2020 The go-lpc Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+//go:build !linux
+// +build !linux
+
+package eda
+
+import (
+	"fmt"
+	"net"
+	"runtime"
+)
+
+func tcpStats(conn net.Conn) (sinkStats, error) {
+	return sinkStats{}, fmt.Errorf("eda: socket statistics not supported on %s", runtime.GOOS)
+}
@@ -0,0 +1,81 @@
+// Copyright 2020 The go-lpc Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package eda
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"log"
+	"net"
+	"reflect"
+	"runtime"
+	"testing"
+	"time"
+
+	"github.com/go-lpc/mim/obs"
+)
+
+func TestSinkStats(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		t.Skipf("socket statistics not supported on %s", runtime.GOOS)
+	}
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("could not create listener: %+v", err)
+	}
+	defer ln.Close()
+
+	go func() {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		_, _ = io.Copy(ioutil.Discard, conn)
+	}()
+
+	conn, err := net.Dial("tcp", ln.Addr().String())
+	if err != nil {
+		t.Fatalf("could not dial sink: %+v", err)
+	}
+	defer conn.Close()
+
+	_, err = conn.Write(make([]byte, 1024))
+	if err != nil {
+		t.Fatalf("could not write to sink: %+v", err)
+	}
+
+	var (
+		msg = new(bytes.Buffer)
+		reg = obs.NewRegistry()
+		dev = &Device{msg: log.New(msg, "eda: ", 0), rfms: []int{1, 2}}
+	)
+	dev.cfg.metrics = reg
+	dev.cfg.daq.stats = time.Millisecond
+	dev.daq.rfm = make([]rfmSink, nRFM)
+	dev.daq.rfm[1] = rfmSink{id: 10, sck: conn}
+	dev.daq.rfm[2] = rfmSink{id: 11, sck: nopConn{}}
+
+	stop := dev.watchSinks()
+	time.Sleep(10 * time.Millisecond)
+	stop()
+
+	want := []string{"eda.sink.1.retrans", "eda.sink.1.rtt", "eda.sink.1.sendq"}
+	if got := reg.Names(); !reflect.DeepEqual(got, want) {
+		t.Fatalf("invalid metrics:\ngot= %q\nwant=%q", got, want)
+	}
+	if rtt := reg.Gauge("eda.sink.1.rtt"); rtt <= 0 {
+		t.Fatalf("invalid round-trip time: %v", rtt)
+	}
+
+	// failures are only logged once.
+	if got, want := msg.String(), "eda: could not poll statistics of DIF data sink (RFM=2): eda: no socket statistics for eda.nopConn connections\n"; got != want {
+		t.Fatalf("invalid log:\ngot= %q\nwant=%q", got, want)
+	}
+}
+
+type nopConn struct{ net.Conn }
@@ -5,20 +5,30 @@
 package eda
 
 import (
+	"context"
 	"fmt"
+	"io"
+	"io/ioutil"
 	"os"
 	"os/signal"
 	"path/filepath"
+	"sync/atomic"
 	"syscall"
 	"time"
 
+	"github.com/go-lpc/mim/conddb"
 	"github.com/go-lpc/mim/eda/internal/regs"
 )
 
 type standalone struct {
-	dev  *Device
-	run  uint32
-	stop chan os.Signal
+	// statistics, accessed atomically.
+	// kept first for 64-bit alignment on 32-bit platforms.
+	cycles int64 // number of acquisition cycles
+	kept   int64 // number of acquisition cycles whose data was kept
+	bytes  int64 // number of bytes written to the output file
+
+	dev *Device
+	run uint32
 }
 
 func newStandalone(odir, devmem, devshm string, run int, opts ...Option) (*standalone, error) {
@@ -27,14 +37,53 @@ func newStandalone(odir, devmem, devshm string, run int, opts ...Option) (*stand
 		return nil, fmt.Errorf("could not create EDA device: %w", err)
 	}
 	srv := &standalone{
-		dev:  dev,
-		run:  uint32(run),
-		stop: make(chan os.Signal, 1),
+		dev: dev,
+		run: uint32(run),
 	}
 	return srv, nil
 }
 
+// RunStandalone runs a stand-alone noise data acquisition until an
+// interrupt or termination signal is received, or until a run limit (see
+// WithMaxRunDuration and WithMaxRunCycles) is reached.
 func RunStandalone(cfg string, run, threshold, rfmMask int, opts ...Option) error {
+	stop := make(chan os.Signal, 1)
+	signal.Notify(stop, os.Interrupt, syscall.SIGTERM, syscall.SIGUSR1)
+	defer signal.Stop(stop)
+
+	daq, err := StartStandalone(context.Background(), cfg, run, threshold, rfmMask, opts...)
+	if err != nil {
+		return err
+	}
+
+	select {
+	case <-stop:
+		return daq.Stop()
+	case <-daq.done:
+		return daq.Wait()
+	}
+}
+
+// Standalone is a handle on a running stand-alone noise data acquisition.
+type Standalone struct {
+	srv    *standalone
+	cancel context.CancelFunc
+	done   chan struct{}
+	err    error
+}
+
+// StandaloneStats describes the progress of a stand-alone data acquisition.
+type StandaloneStats struct {
+	Cycles int64 // number of acquisition cycles
+	Kept   int64 // number of acquisition cycles whose data was kept
+	Bytes  int64 // number of bytes written to the output file
+}
+
+// StartStandalone starts a stand-alone noise data acquisition in the
+// background.
+// The acquisition runs until Stop is called, ctx is canceled or a run
+// limit is reached.
+func StartStandalone(ctx context.Context, cfg string, run, threshold, rfmMask int, opts ...Option) (*Standalone, error) {
 	const (
 		odir   = "/home/root/run"
 		devmem = "/dev/mem"
@@ -50,19 +99,58 @@ func RunStandalone(cfg string, run, threshold, rfmMask int, opts ...Option) erro
 
 	srv, err := newStandalone(odir, devmem, devshm, run, xopts...)
 	if err != nil {
-		return fmt.Errorf("could not create standalone server: %w", err)
+		return nil, fmt.Errorf("could not create standalone server: %w", err)
+	}
+	return srv.start(ctx), nil
+}
+
+func (srv *standalone) start(ctx context.Context) *Standalone {
+	ctx, cancel := context.WithCancel(ctx)
+	daq := &Standalone{
+		srv:    srv,
+		cancel: cancel,
+		done:   make(chan struct{}),
 	}
-	return srv.runDAQ()
+	go func() {
+		defer close(daq.done)
+		defer cancel()
+		daq.err = srv.runDAQ(ctx)
+	}()
+	return daq
 }
 
-func (srv *standalone) runDAQ() error {
+// Stop stops the data acquisition and waits for it to complete.
+func (daq *Standalone) Stop() error {
+	daq.cancel()
+	return daq.Wait()
+}
+
+// Wait waits for the data acquisition to complete and returns the error
+// that stopped it, if any.
+func (daq *Standalone) Wait() error {
+	<-daq.done
+	return daq.err
+}
+
+// Stats returns the current statistics of the data acquisition.
+func (daq *Standalone) Stats() StandaloneStats {
+	return StandaloneStats{
+		Cycles: atomic.LoadInt64(&daq.srv.cycles),
+		Kept:   atomic.LoadInt64(&daq.srv.kept),
+		Bytes:  atomic.LoadInt64(&daq.srv.bytes),
+	}
+}
+
+func (srv *standalone) runDAQ(ctx context.Context) (err error) {
 	dev := srv.dev
 	defer dev.Close()
 
-	signal.Notify(srv.stop, os.Interrupt, syscall.SIGUSR1)
-	defer signal.Stop(srv.stop)
+	err = dev.needH2F("standalone DAQ")
+	if err != nil {
+		return fmt.Errorf("eda: could not start standalone DAQ: %w", err)
+	}
 
-	err := dev.Configure()
+	err = dev.Configure()
 	if err != nil {
 		return fmt.Errorf("could not configure EDA board: %w", err)
 	}
@@ -111,22 +199,53 @@ func (srv *standalone) runDAQ() error {
 		return fmt.Errorf("eda: could select cmd-soft mode: %w", err)
 	}
 
+	err = dev.trigSelectThreshold()
+	if err != nil {
+		return fmt.Errorf("eda: could not select trigger threshold: %w", err)
+	}
+
 	// --- init HR ---
 	err = dev.initHR()
 	if err != nil {
 		return fmt.Errorf("eda: could not initialize HardRoc: %w", err)
 	}
+	err = dev.checkRFMs()
+	if err != nil {
+		return err
+	}
 
 	// --- init run ---
-	out, err := os.Create(filepath.Join(
-		dev.cfg.run.dir, fmt.Sprintf("hr_daq_%03d.bin", srv.run),
-	))
+	err = dev.checkDiskSpace()
+	if err != nil {
+		return fmt.Errorf("eda: could not start run: %w", err)
+	}
+	err = dev.checkClock()
+	if err != nil {
+		return fmt.Errorf("eda: could not start run: %w", err)
+	}
+	dev.disk.last = time.Now()
+
+	fname := filepath.Join(dev.cfg.run.dir, fmt.Sprintf("hr_daq_%03d.bin", srv.run))
+	out, err := dev.createRaw(fname)
 	if err != nil {
 		return fmt.Errorf("eda: could not create output DAQ file: %w", err)
 	}
-	defer out.Close()
+	defer func() {
+		// closing the output file flushes the compressed data, if any.
+		e := out.Close()
+		if e != nil && err == nil {
+			err = fmt.Errorf("eda: could not close output raw file: %w", e)
+		}
+	}()
 
-	cycleID := 0
+	var (
+		cycleID = 0
+		thr     = newThrottle(dev.cfg.daq.noise.prescale, dev.cfg.daq.noise.rate)
+	)
+
+	// the FIFOs are read and the data written from this goroutine: there
+	// are no senders to pin.
+	defer dev.pin(&pipeline{})()
 
 	for _, rfm := range dev.rfms {
 		err = dev.daqFIFOInit(rfm)
@@ -145,6 +264,7 @@ func (srv *standalone) runDAQ() error {
 		return fmt.Errorf("eda: could not reset BCID: %w", err)
 	}
 
+	thr.start(time.Now())
 	err = dev.syncStart()
 	if err != nil {
 		return fmt.Errorf("eda: could not start acquisition: %w", err)
@@ -156,10 +276,21 @@ func (srv *standalone) runDAQ() error {
 		return fmt.Errorf("eda: could not arm FIFO: %w", err)
 	}
 
+	dev.daq.set = dev.settings(srv.run)
+	dev.daq.cycles = 0
+	dev.recordRunStart(srv.run, "noise", []conddb.RunFile{{Kind: "raw", Path: fname}})
+	defer dev.recordRunStop()
+
+	if d := dev.cfg.run.max.dur; d > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, d)
+		defer cancel()
+	}
+
 readout:
 	for {
 		select {
-		case <-srv.stop:
+		case <-ctx.Done():
 			dev.msg.Printf("stopping acquisition...")
 			break readout
 		default:
@@ -192,7 +323,7 @@ readout:
 				break fifo
 			default:
 				select {
-				case <-srv.stop:
+				case <-ctx.Done():
 					dev.msg.Printf("stopping acquisition...")
 					break readout
 				default:
@@ -201,20 +332,45 @@ readout:
 		}
 
 		// read hardroc data.
+		dev.watchDiskSpace(time.Now())
+		var w io.Writer = ioutil.Discard // prescaled out, or low disk space.
+		if thr.keep(cycleID) && !dev.diskLow() {
+			w = &statsWriter{w: out, n: &srv.bytes}
+			atomic.AddInt64(&srv.kept, 1)
+		}
 		for _, rfm := range dev.rfms {
-			dev.daqWriteDIFData(out, rfm)
+			dev.daqWriteDIFData(w, rfm)
 		}
 		err = dev.syncAckFIFO()
 		if err != nil {
 			return fmt.Errorf("eda: could not ACK FIFO: %w", err)
 		}
 
+		if d := thr.delay(time.Now()); d > 0 {
+			tmr := time.NewTimer(d)
+			select {
+			case <-ctx.Done():
+				tmr.Stop()
+				dev.msg.Printf("stopping acquisition...")
+				break readout
+			case <-tmr.C:
+			}
+		}
+
+		thr.start(time.Now())
 		err = dev.syncStart()
 		if err != nil {
 			return fmt.Errorf("eda: could not start ACQ (cycle=%d): %w", cycleID, err)
 		}
 
 		cycleID++
+		atomic.AddInt64(&srv.cycles, 1)
+		dev.daq.cycles = int64(cycleID)
+
+		if max := dev.cfg.run.max.cycles; max > 0 && int64(cycleID) >= max {
+			dev.msg.Printf("maximum number of cycles reached, stopping acquisition...")
+			break readout
+		}
 	}
 
 	err = dev.syncStop()
@@ -222,10 +378,17 @@ readout:
 		return fmt.Errorf("eda: could not stop ACQ: %w", err)
 	}
 
-	err = out.Close()
-	if err != nil {
-		return fmt.Errorf("eda: could not close output raw file: %w", err)
-	}
-
 	return nil
 }
+
+// statsWriter counts the bytes written to w.
+type statsWriter struct {
+	w io.Writer
+	n *int64
+}
+
+func (w *statsWriter) Write(p []byte) (int, error) {
+	n, err := w.w.Write(p)
+	atomic.AddInt64(w.n, int64(n))
+	return n, err
+}
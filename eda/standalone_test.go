@@ -5,9 +5,13 @@
 package eda
 
 import (
-	"os"
+	"context"
+	"path/filepath"
+	"reflect"
+	"runtime"
 	"testing"
 
+	"github.com/go-lpc/mim/conddb"
 	"github.com/go-lpc/mim/eda/internal/regs"
 )
 
@@ -45,6 +49,7 @@ func TestStandalone(t *testing.T) {
 		odir, fdev.mem, fdev.shm, 42,
 		WithRFMMask(1<<1),
 		WithConfigDir(cfgdir),
+		WithCPUAffinity(CPUAffinity{MaxProcs: 1, Reader: -1, Senders: -1}),
 	)
 	if err != nil {
 		t.Fatalf("could not create standalone server: %+v", err)
@@ -55,18 +60,72 @@ func TestStandalone(t *testing.T) {
 		rfmDone = regs.O_SC_DONE_1
 	)
 
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
 	// inject callback to automatically stop run when
 	// fake registers ran out
+	// make sure the run changes GOMAXPROCS, even on a single CPU.
+	defer runtime.GOMAXPROCS(runtime.GOMAXPROCS(2))
+	var (
+		procs    = runtime.GOMAXPROCS(0)
+		runProcs = -1
+	)
 	exhaust := func() {
-		go func() {
-			srv.stop <- os.Interrupt
-		}()
+		if runProcs < 0 {
+			runProcs = runtime.GOMAXPROCS(0)
+		}
+		cancel()
 	}
 
 	fdev.fpga(srv.dev, rfmID, rfmDone, exhaust)
 
-	err = srv.runDAQ()
+	db := new(fakeRunDB)
+	srv.dev.cfg.run.db = db
+
+	daq := srv.start(ctx)
+	err = daq.Wait()
 	if err != nil {
 		t.Fatalf("could run standalone server: %+v", err)
 	}
+
+	if got, want := runProcs, 1; got != want {
+		t.Fatalf("invalid GOMAXPROCS during run: got=%d, want=%d", got, want)
+	}
+	if got, want := runtime.GOMAXPROCS(0), procs; got != want {
+		t.Fatalf("GOMAXPROCS not restored: got=%d, want=%d", got, want)
+	}
+
+	stats := daq.Stats()
+	if stats.Cycles <= 0 {
+		t.Fatalf("invalid number of cycles: %d", stats.Cycles)
+	}
+	if got, want := stats.Kept, stats.Cycles; got != want {
+		t.Fatalf("invalid number of kept cycles: got=%d, want=%d", got, want)
+	}
+	if stats.Bytes <= 0 {
+		t.Fatalf("invalid number of bytes: %d", stats.Bytes)
+	}
+
+	if len(db.runs) != 1 {
+		t.Fatalf("invalid number of runs: got=%d, want=1", len(db.runs))
+	}
+	if run := db.runs[0]; run.ID != 42 || run.Mode != "noise" || run.Cycles != stats.Cycles || run.Stop.IsZero() {
+		t.Fatalf("invalid run record: %+v", run)
+	}
+	want := []conddb.RunFile{{
+		Run:  42,
+		Host: db.runs[0].Host,
+		Path: filepath.Join(srv.dev.cfg.run.dir, "hr_daq_042.bin"),
+		Kind: "raw",
+	}}
+	if !reflect.DeepEqual(db.files, want) {
+		t.Fatalf("invalid run files:\ngot= %+v\nwant=%+v", db.files, want)
+	}
+
+	// stopping a completed acquisition is a no-op.
+	err = daq.Stop()
+	if err != nil {
+		t.Fatalf("could not stop standalone server: %+v", err)
+	}
 }
@@ -0,0 +1,159 @@
+// Copyright 2020 The go-lpc Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package eda
+
+import (
+	"fmt"
+	"io"
+	"sync"
+)
+
+const (
+	streamQueue = 64 // number of DIF blocks buffered for a stream reader
+)
+
+// StreamReader returns a reader of the live DIF data stream of the
+// provided RFM slot.
+//
+// The reader delivers the DIF data sent to the sink of the RFM (see
+// ConfigureDIF), byte for byte, without the size headers of the sink
+// protocol: it can be decoded with a DIF decoder. Data of the current
+// run (or, if no run is started, of the next one) is delivered; Read
+// returns io.EOF once that run is stopped.
+//
+// Blocks are buffered for the reader, up to a limit: whole DIF blocks
+// are dropped when the reader does not keep up, so that the DAQ is never
+// delayed by a slow reader.
+// The reader must be closed once done.
+func (dev *Device) StreamReader(rfm int) io.ReadCloser {
+	r := newStreamReader()
+	if rfm < 0 || rfm >= nRFM {
+		r.err = fmt.Errorf("eda: invalid RFM slot %d", rfm)
+		return r
+	}
+
+	dev.streams.Lock()
+	defer dev.streams.Unlock()
+	dev.streams.rfm[rfm] = append(dev.streams.rfm[rfm], r)
+	return r
+}
+
+// sendStreams queues a copy of the provided DIF block for the stream
+// readers of the provided RFM slot.
+func (dev *Device) sendStreams(slot int, data []byte) {
+	dev.streams.Lock()
+	defer dev.streams.Unlock()
+
+	rs := dev.streams.rfm[slot][:0]
+	for _, r := range dev.streams.rfm[slot] {
+		if r.send(data) {
+			rs = append(rs, r)
+		}
+	}
+	dev.streams.rfm[slot] = rs
+}
+
+// endStreams signals the end of the DIF data stream to all stream
+// readers.
+func (dev *Device) endStreams() {
+	dev.streams.Lock()
+	defer dev.streams.Unlock()
+
+	for slot, rs := range dev.streams.rfm {
+		for _, r := range rs {
+			n := r.end()
+			if n > 0 {
+				dev.msg.Printf("stream reader of RFM slot=%d: %d block(s) dropped", slot, n)
+			}
+		}
+		dev.streams.rfm[slot] = nil
+	}
+}
+
+// streamReader is a reader of DIF blocks, fed by the DAQ loop.
+type streamReader struct {
+	mu   sync.Mutex
+	cond sync.Cond
+
+	blks    [][]byte // queued DIF blocks
+	cur     []byte   // remaining bytes of the DIF block being read
+	dropped int
+	eof     bool  // whether the DIF data stream ended
+	err     error // error returned by Read, once queued blocks are consumed
+}
+
+func newStreamReader() *streamReader {
+	r := &streamReader{}
+	r.cond.L = &r.mu
+	return r
+}
+
+// send queues a copy of the provided DIF block.
+// send returns false if the reader has been closed.
+func (r *streamReader) send(data []byte) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.err != nil {
+		return false
+	}
+	if len(r.blks) >= streamQueue {
+		r.dropped++
+		return true
+	}
+	r.blks = append(r.blks, append([]byte(nil), data...))
+	r.cond.Signal()
+	return true
+}
+
+// end marks the end of the DIF data stream and returns the number of
+// dropped blocks.
+func (r *streamReader) end() int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.eof = true
+	r.cond.Broadcast()
+	return r.dropped
+}
+
+func (r *streamReader) Read(p []byte) (int, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for len(r.cur) == 0 {
+		switch {
+		case r.err != nil:
+			return 0, r.err
+		case len(r.blks) > 0:
+			r.cur = r.blks[0]
+			r.blks[0] = nil
+			r.blks = r.blks[1:]
+		case r.eof:
+			return 0, io.EOF
+		default:
+			r.cond.Wait()
+		}
+	}
+
+	n := copy(p, r.cur)
+	r.cur = r.cur[n:]
+	return n, nil
+}
+
+func (r *streamReader) Close() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.err == nil {
+		r.err = io.ErrClosedPipe
+	}
+	r.blks = nil
+	r.cur = nil
+	r.cond.Broadcast()
+	return nil
+}
+
+var _ io.ReadCloser = (*streamReader)(nil)
@@ -0,0 +1,102 @@
+// Copyright 2020 The go-lpc Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package eda
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"strings"
+	"testing"
+)
+
+func TestStreamReader(t *testing.T) {
+	msg := new(strings.Builder)
+	dev := Device{msg: log.New(msg, "", 0), cfg: newConfig()}
+	dev.daq.rfm = make([]rfmSink, nRFM)
+	dev.daq.rfm[1].id = 42
+	dev.daq.rfm[2].id = 43
+
+	var (
+		r1 = dev.StreamReader(1)
+		r2 = dev.StreamReader(2)
+		rc = dev.StreamReader(1)
+	)
+	defer r1.Close()
+	defer r2.Close()
+
+	err := rc.Close()
+	if err != nil {
+		t.Fatalf("could not close stream reader: %+v", err)
+	}
+
+	const n = streamQueue + 5
+	var (
+		sender = sinkSender{&dev}
+		want   = new(strings.Builder)
+	)
+	for i := 0; i < n; i++ {
+		var (
+			blk1 = []byte(fmt.Sprintf("DIF-42-%03d|", i))
+			blk2 = []byte(fmt.Sprintf("DIF-43-%03d|", i))
+		)
+		err := sender.Send(&Cycle{Num: i, DIFs: []DIFBlock{
+			{ID: 42, Slot: 1, Data: blk1},
+			{ID: 43, Slot: 2, Data: blk2},
+		}})
+		if err != nil {
+			t.Fatalf("could not send cycle %d: %+v", i, err)
+		}
+		if i < streamQueue {
+			want.Write(blk1)
+		}
+		blk1[0] = 'X' // blocks are copied.
+
+		if i == 0 {
+			// reading does not wait for the end of the stream.
+			buf := make([]byte, 4)
+			_, err = io.ReadFull(r2, buf)
+			if err != nil {
+				t.Fatalf("could not read stream: %+v", err)
+			}
+			if got, want := string(buf), "DIF-"; got != want {
+				t.Fatalf("invalid stream data: got=%q, want=%q", got, want)
+			}
+		}
+	}
+
+	if got := len(dev.streams.rfm[1]); got != 1 {
+		t.Fatalf("closed stream reader not removed: got=%d readers", got)
+	}
+
+	dev.endStreams()
+
+	raw, err := ioutil.ReadAll(r1)
+	if err != nil {
+		t.Fatalf("could not read stream: %+v", err)
+	}
+	if got, want := string(raw), want.String(); got != want {
+		t.Fatalf("invalid stream data:\ngot= %q\nwant=%q", got, want)
+	}
+
+	// the first block of slot 2 was dequeued by the reader: one less drop.
+	if got, want := msg.String(), ""+
+		"stream reader of RFM slot=1: 5 block(s) dropped\n"+
+		"stream reader of RFM slot=2: 4 block(s) dropped\n"; got != want {
+		t.Fatalf("invalid log:\ngot= %q\nwant=%q", got, want)
+	}
+
+	_, err = rc.Read(make([]byte, 1))
+	if !errors.Is(err, io.ErrClosedPipe) {
+		t.Fatalf("invalid error for closed reader: %+v", err)
+	}
+
+	_, err = dev.StreamReader(nRFM).Read(make([]byte, 1))
+	if got, want := fmt.Sprint(err), "eda: invalid RFM slot 4"; got != want {
+		t.Fatalf("invalid error:\ngot= %v\nwant=%v", got, want)
+	}
+}
@@ -0,0 +1,96 @@
+// Copyright 2020 The go-lpc Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package eda
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+)
+
+// RunSummary is the summary of a run, for one DIF data sink.
+//
+// With WithRunSummary, the summary is sent to the sink at the end of the
+// run, as an "EOR\0" message: an "EOR\0" header with the size of the
+// summary (a little-endian uint32), followed by the JSON encoded summary,
+// each of them acknowledged by the sink with "ACK\0", as DIF blocks are.
+type RunSummary struct {
+	Run     uint32    `json:"run"`
+	Slot    int       `json:"slot"`     // EDA slot of the RFM
+	DIF     uint8     `json:"dif"`      // DIF ID
+	Time    time.Time `json:"time"`     // wall-clock time of the end of the run
+	Cycles  int64     `json:"cycles"`   // number of acquisition cycles of the run
+	Blocks  int64     `json:"blocks"`   // number of DIF blocks sent to the sink
+	Bytes   int64     `json:"bytes"`    // number of DIF data bytes sent to the sink
+	DTC     uint32    `json:"dtc"`      // trigger counter of the last DIF block
+	AbsBCID uint64    `json:"abs_bcid"` // absolute BCID of the last DIF block
+
+	Overflows struct {
+		Cycles int `json:"cycles"` // number of readout cycles with dropped data
+		Bytes  int `json:"bytes"`  // number of dropped bytes
+	} `json:"overflows"`
+
+	Empty int64 `json:"empty_dropped,omitempty"` // number of empty DIF blocks not sent to the sink
+
+	Err string `json:"error,omitempty"` // error that ended the run ("": stopped)
+}
+
+// runSummary returns the summary of the current run, for the sink of the
+// provided RFM slot.
+func (dev *Device) runSummary(slot int) RunSummary {
+	rfm := &dev.daq.rfm[slot]
+	sum := RunSummary{
+		Run:     dev.daq.set.Run,
+		Slot:    slot,
+		DIF:     rfm.id,
+		Time:    time.Now().UTC(),
+		Cycles:  dev.daq.cycles,
+		Blocks:  rfm.sent.blocks,
+		Bytes:   rfm.sent.bytes,
+		DTC:     rfm.trig,
+		AbsBCID: rfm.abs,
+	}
+	sum.Overflows.Cycles = rfm.ovf.cycles
+	sum.Overflows.Bytes = rfm.ovf.bytes
+	sum.Empty = rfm.empty.dropped
+	if dev.err != nil {
+		sum.Err = dev.err.Error()
+	}
+	return sum
+}
+
+// sendRunSummaries sends the summary of the current run to the DIF data
+// sinks of the active RFMs, if enabled.
+// Failures are only logged: the data of the run has already been sent.
+func (dev *Device) sendRunSummaries() {
+	if !dev.cfg.daq.summary {
+		return
+	}
+	for _, slot := range dev.rfms {
+		rfm := &dev.daq.rfm[slot]
+		if !rfm.valid() || rfm.sck == nil {
+			continue
+		}
+		err := dev.sendRunSummary(slot, dev.runSummary(slot))
+		if err != nil {
+			dev.msg.Printf("could not send end-of-run summary (RFM=%d): %+v", slot, err)
+		}
+	}
+}
+
+func (dev *Device) sendRunSummary(slot int, sum RunSummary) error {
+	data, err := json.Marshal(sum)
+	if err != nil {
+		return fmt.Errorf("eda: could not encode end-of-run summary: %w", err)
+	}
+
+	sink := &dev.daq.rfm[slot]
+	if dt := dev.cfg.daq.dial.timeout; dt > 0 {
+		// do not hang the end of the run on a dead sink.
+		_ = sink.sck.SetDeadline(time.Now().Add(dt))
+		defer func() { _ = sink.sck.SetDeadline(time.Time{}) }()
+	}
+	return sendMsg(sink.sck, sink.buf, "EOR\x00", "EOR", data)
+}
@@ -0,0 +1,150 @@
+// Copyright 2020 The go-lpc Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package eda
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestRunSummary(t *testing.T) {
+	want := func(err string) *RunSummary {
+		sum := &RunSummary{
+			Run:     42,
+			Slot:    1,
+			DIF:     0x42,
+			Cycles:  3,
+			Blocks:  2,
+			Bytes:   2 * 66,
+			DTC:     3,
+			AbsBCID: 1234,
+			Err:     err,
+		}
+		sum.Overflows.Cycles = 1
+		sum.Overflows.Bytes = 10
+		return sum
+	}
+
+	for _, tc := range []struct {
+		name    string
+		summary bool
+		err     error
+		want    *RunSummary // nil: no summary
+	}{
+		{
+			name: "disabled",
+		},
+		{
+			name:    "stopped",
+			summary: true,
+			want:    want(""),
+		},
+		{
+			name:    "failed",
+			summary: true,
+			err:     errors.New("boom"),
+			want:    want("boom"),
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			p1, p2 := net.Pipe()
+			defer p1.Close()
+
+			dev := &Device{
+				msg: log.New(ioutil.Discard, "eda: ", 0),
+				buf: make([]byte, 4),
+				cfg: newConfig(),
+				err: tc.err,
+			}
+			WithRunSummary(tc.summary)(&dev.cfg)
+			dev.rfms = []int{1}
+			dev.daq.set.Run = 42
+			dev.daq.cycles = 3
+			dev.daq.rfm = make([]rfmSink, nRFM)
+			dev.daq.rfm[1] = rfmSink{
+				id:   0x42,
+				buf:  make([]byte, 8),
+				sck:  p1,
+				trig: 3,
+				abs:  1234,
+			}
+			dev.daq.rfm[1].ovf.cycles = 1
+			dev.daq.rfm[1].ovf.bytes = 10
+
+			for i := 0; i < 2; i++ {
+				go func() {
+					_, _ = io.ReadFull(p2, make([]byte, 8))
+					_, _ = p2.Write([]byte("ACK\x00"))
+					_, _ = io.ReadFull(p2, make([]byte, 66))
+					_, _ = p2.Write([]byte("ACK\x00"))
+				}()
+				err := dev.daqSendDIFData(1, make([]byte, 66))
+				if err != nil {
+					t.Fatalf("could not send DIF data: %+v", err)
+				}
+			}
+
+			done := make(chan string)
+			go func() {
+				defer close(done)
+				defer p2.Close()
+				done <- readEOR(p2)
+			}()
+
+			dev.sendRunSummaries()
+			_ = p1.Close()
+
+			msg := <-done
+			if tc.want == nil {
+				if msg != "" {
+					t.Fatalf("unexpected end-of-run summary: %s", msg)
+				}
+				return
+			}
+
+			var got RunSummary
+			err := json.Unmarshal([]byte(msg), &got)
+			if err != nil {
+				t.Fatalf("could not decode end-of-run summary %q: %+v", msg, err)
+			}
+			if got.Time.IsZero() {
+				t.Fatalf("missing end-of-run time")
+			}
+			got.Time = time.Time{}
+			if got, want := got, *tc.want; got != want {
+				t.Fatalf("invalid end-of-run summary:\ngot= %+v\nwant=%+v", got, want)
+			}
+		})
+	}
+}
+
+// readEOR reads an end-of-run message from a DIF data sink connection and
+// returns its payload, or the error.
+func readEOR(conn net.Conn) string {
+	hdr := make([]byte, 8)
+	_, err := io.ReadFull(conn, hdr)
+	if err != nil {
+		return ""
+	}
+	if string(hdr[:4]) != "EOR\x00" {
+		return fmt.Sprintf("invalid header %q", hdr[:4])
+	}
+	_, _ = conn.Write([]byte("ACK\x00"))
+	buf := make([]byte, binary.LittleEndian.Uint32(hdr[4:]))
+	_, err = io.ReadFull(conn, buf)
+	if err != nil {
+		return err.Error()
+	}
+	_, _ = conn.Write([]byte("ACK\x00"))
+	return string(buf)
+}
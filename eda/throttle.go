@@ -0,0 +1,47 @@
+// Copyright 2020 The go-lpc Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package eda
+
+import (
+	"time"
+)
+
+// throttle prescales and rate-limits the software-started acquisition
+// cycles of the noise trigger mode.
+type throttle struct {
+	prescale int           // keep the data of 1 cycle out of prescale
+	period   time.Duration // minimum duration between two cycle starts
+	last     time.Time     // start of the current cycle
+}
+
+func newThrottle(prescale int, rate float64) throttle {
+	t := throttle{prescale: prescale}
+	if rate > 0 {
+		t.period = time.Duration(float64(time.Second) / rate)
+	}
+	return t
+}
+
+// keep returns whether the data of the provided cycle should be kept.
+func (t *throttle) keep(cycle int) bool {
+	return t.prescale <= 1 || cycle%t.prescale == 0
+}
+
+// delay returns how long to wait before the next cycle can be started.
+func (t *throttle) delay(now time.Time) time.Duration {
+	if t.period <= 0 || t.last.IsZero() {
+		return 0
+	}
+	d := t.period - now.Sub(t.last)
+	if d < 0 {
+		return 0
+	}
+	return d
+}
+
+// start records the start of a new cycle.
+func (t *throttle) start(now time.Time) {
+	t.last = now
+}
@@ -0,0 +1,56 @@
+// Copyright 2020 The go-lpc Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package eda
+
+import (
+	"testing"
+	"time"
+)
+
+func TestThrottle(t *testing.T) {
+	for _, tc := range []struct {
+		prescale int
+		keep     []bool
+	}{
+		{prescale: 0, keep: []bool{true, true, true, true}},
+		{prescale: 1, keep: []bool{true, true, true, true}},
+		{prescale: 3, keep: []bool{true, false, false, true}},
+	} {
+		thr := newThrottle(tc.prescale, 0)
+		for i, want := range tc.keep {
+			if got := thr.keep(i); got != want {
+				t.Fatalf("prescale=%d, cycle=%d: got=%v, want=%v", tc.prescale, i, got, want)
+			}
+		}
+	}
+
+	var (
+		thr = newThrottle(1, 10) // 10Hz
+		t0  = time.Unix(1000, 0)
+	)
+	if got, want := thr.delay(t0), time.Duration(0); got != want {
+		t.Fatalf("invalid initial delay: got=%v, want=%v", got, want)
+	}
+	thr.start(t0)
+	for _, tc := range []struct {
+		dt   time.Duration
+		want time.Duration
+	}{
+		{0, 100 * time.Millisecond},
+		{30 * time.Millisecond, 70 * time.Millisecond},
+		{100 * time.Millisecond, 0},
+		{time.Second, 0},
+	} {
+		if got := thr.delay(t0.Add(tc.dt)); got != tc.want {
+			t.Fatalf("dt=%v: invalid delay: got=%v, want=%v", tc.dt, got, tc.want)
+		}
+	}
+
+	thr = newThrottle(1, 0)
+	thr.start(t0)
+	if got, want := thr.delay(t0), time.Duration(0); got != want {
+		t.Fatalf("invalid delay w/o rate limit: got=%v, want=%v", got, want)
+	}
+}
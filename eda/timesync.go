@@ -0,0 +1,185 @@
+// Copyright 2020 The go-lpc Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package eda
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net"
+	"os/exec"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	clockTimeout = 5 * time.Second // timeout of clock synchronization queries
+
+	ntpEpoch = 2208988800 // seconds between the NTP (1900) and Unix (1970) epochs
+)
+
+// ErrClockUnsync is returned when the clock of the SoC is not synchronized
+// within the bound configured with WithClockCheck.
+var ErrClockUnsync = errors.New("eda: clock not synchronized")
+
+// ClockSync describes the synchronization of the clock of the SoC, as
+// checked at the start of a run.
+type ClockSync struct {
+	Source string        // source of the check ("chrony" or NTP server)
+	Offset time.Duration // correction to apply to the local clock
+	Synced bool          // whether the clock was synchronized within bounds
+	Error  string        `json:",omitempty"` // error of the check, if any
+}
+
+// clockOffset returns the offset of the local clock with regard to the
+// provided source: "chrony" queries the local chrony daemon, any other
+// value is the [host[:port]] of a NTP server.
+var clockOffset = func(src string, timeout time.Duration) (time.Duration, error) {
+	if src == "chrony" {
+		return chronyOffset(timeout)
+	}
+	return sntpOffset(src, timeout)
+}
+
+// chronyOffset returns the offset of the local clock as tracked by the
+// chrony daemon.
+func chronyOffset(timeout time.Duration) (time.Duration, error) {
+	cmd := exec.Command("chronyc", "-c", "tracking")
+	done := time.AfterFunc(timeout, func() {
+		if cmd.Process != nil {
+			_ = cmd.Process.Kill()
+		}
+	})
+	defer done.Stop()
+
+	out, err := cmd.Output()
+	if err != nil {
+		return 0, fmt.Errorf("could not run chronyc: %w", err)
+	}
+
+	// chronyc -c tracking: ref-id,ref-name,stratum,ref-time,system-time,
+	// last-offset,rms-offset,freq,resid-freq,skew,root-delay,root-disp,
+	// update-interval,leap-status
+	toks := strings.Split(strings.TrimSpace(string(out)), ",")
+	if len(toks) < 14 {
+		return 0, fmt.Errorf("invalid chronyc tracking output %q", out)
+	}
+	if leap := toks[len(toks)-1]; leap == "Not synchronised" {
+		return 0, fmt.Errorf("chrony: %s", strings.ToLower(leap))
+	}
+	v, err := strconv.ParseFloat(toks[4], 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid chronyc system time %q: %w", toks[4], err)
+	}
+	// chrony reports how far the system clock is ahead of NTP time.
+	return -time.Duration(v * float64(time.Second)), nil
+}
+
+// sntpOffset queries the NTP server at addr and returns the offset of the
+// local clock (RFC 4330).
+func sntpOffset(addr string, timeout time.Duration) (time.Duration, error) {
+	if _, _, err := net.SplitHostPort(addr); err != nil {
+		addr = net.JoinHostPort(addr, "123")
+	}
+
+	conn, err := net.DialTimeout("udp", addr, timeout)
+	if err != nil {
+		return 0, fmt.Errorf("could not dial NTP server: %w", err)
+	}
+	defer conn.Close()
+
+	err = conn.SetDeadline(time.Now().Add(timeout))
+	if err != nil {
+		return 0, fmt.Errorf("could not set NTP deadline: %w", err)
+	}
+
+	var req [48]byte
+	req[0] = 0x23 // LI=0, VN=4, mode=3 (client)
+	t1 := time.Now()
+	binary.BigEndian.PutUint64(req[40:], ntpTime(t1))
+
+	_, err = conn.Write(req[:])
+	if err != nil {
+		return 0, fmt.Errorf("could not send NTP request: %w", err)
+	}
+
+	var rep [48]byte
+	_, err = conn.Read(rep[:])
+	if err != nil {
+		return 0, fmt.Errorf("could not read NTP reply: %w", err)
+	}
+	t4 := time.Now()
+
+	switch {
+	case rep[0]&0x7 != 4:
+		return 0, fmt.Errorf("invalid NTP reply mode %d", rep[0]&0x7)
+	case rep[1] == 0:
+		return 0, fmt.Errorf("NTP server is not synchronized (kiss-o'-death %q)", rep[12:16])
+	case rep[0]>>6 == 3:
+		return 0, fmt.Errorf("NTP server is not synchronized (leap indicator)")
+	}
+
+	var (
+		t2 = fromNTPTime(binary.BigEndian.Uint64(rep[32:]))
+		t3 = fromNTPTime(binary.BigEndian.Uint64(rep[40:]))
+	)
+	return (t2.Sub(t1) + t3.Sub(t4)) / 2, nil
+}
+
+func ntpTime(t time.Time) uint64 {
+	sec := uint64(t.Unix() + ntpEpoch)
+	frac := uint64(t.Nanosecond()) << 32 / 1e9
+	return sec<<32 | frac
+}
+
+func fromNTPTime(v uint64) time.Time {
+	sec := int64(v>>32) - ntpEpoch
+	nsec := int64((v & 0xffffffff) * 1e9 >> 32)
+	return time.Unix(sec, nsec)
+}
+
+// checkClock checks the synchronization of the clock of the SoC, as
+// configured with WithClockCheck, and records the result for the run
+// metadata.
+// Errors are only reported when the check is strict.
+func (dev *Device) checkClock() error {
+	dev.daq.clock = nil
+	src := dev.cfg.clock.src
+	if src == "" {
+		return nil
+	}
+
+	clock := &ClockSync{Source: src}
+	dev.daq.clock = clock
+
+	off, err := clockOffset(src, clockTimeout)
+	switch {
+	case err != nil:
+		err = fmt.Errorf("%w: %v", ErrClockUnsync, err)
+	case off > dev.cfg.clock.max || off < -dev.cfg.clock.max:
+		err = fmt.Errorf(
+			"%w: offset=%v (max=%v, source=%s)",
+			ErrClockUnsync, off, dev.cfg.clock.max, src,
+		)
+	}
+	clock.Offset = off
+	clock.Synced = err == nil
+
+	if err != nil {
+		clock.Error = err.Error()
+		if dev.cfg.clock.strict {
+			return err
+		}
+		dev.msg.Printf("%+v", err)
+		return nil
+	}
+
+	dev.msg.Printf(
+		"clock synchronized: offset=%v (max=%v, source=%s)",
+		off, dev.cfg.clock.max, src,
+	)
+	return nil
+}
@@ -0,0 +1,155 @@
+// Copyright 2020 The go-lpc Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package eda
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestCheckClock(t *testing.T) {
+	defer func(f func(string, time.Duration) (time.Duration, error)) { clockOffset = f }(clockOffset)
+
+	var (
+		off time.Duration
+		err error
+	)
+	clockOffset = func(src string, timeout time.Duration) (time.Duration, error) {
+		return off, err
+	}
+
+	for _, tc := range []struct {
+		name   string
+		src    string
+		strict bool
+		off    time.Duration
+		err    error
+		want   *ClockSync
+		fail   string
+		msg    string
+	}{
+		{
+			name: "no-check",
+			off:  time.Hour,
+		},
+		{
+			name: "synced",
+			src:  "chrony",
+			off:  -2 * time.Millisecond,
+			want: &ClockSync{Source: "chrony", Offset: -2 * time.Millisecond, Synced: true},
+			msg:  "clock synchronized: offset=-2ms (max=10ms, source=chrony)\n",
+		},
+		{
+			name: "offset",
+			src:  "ntp.example.org",
+			off:  15 * time.Millisecond,
+			want: &ClockSync{
+				Source: "ntp.example.org", Offset: 15 * time.Millisecond,
+				Error: "eda: clock not synchronized: offset=15ms (max=10ms, source=ntp.example.org)",
+			},
+			msg: "eda: clock not synchronized: offset=15ms (max=10ms, source=ntp.example.org)\n",
+		},
+		{
+			name:   "offset-strict",
+			src:    "ntp.example.org",
+			strict: true,
+			off:    -15 * time.Millisecond,
+			want: &ClockSync{
+				Source: "ntp.example.org", Offset: -15 * time.Millisecond,
+				Error: "eda: clock not synchronized: offset=-15ms (max=10ms, source=ntp.example.org)",
+			},
+			fail: "eda: clock not synchronized: offset=-15ms (max=10ms, source=ntp.example.org)",
+		},
+		{
+			name:   "error-strict",
+			src:    "chrony",
+			strict: true,
+			err:    fmt.Errorf("chrony: not synchronised"),
+			want: &ClockSync{
+				Source: "chrony",
+				Error:  "eda: clock not synchronized: chrony: not synchronised",
+			},
+			fail: "eda: clock not synchronized: chrony: not synchronised",
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			var (
+				msg = new(strings.Builder)
+				dev = Device{cfg: newConfig()}
+			)
+			dev.msg = log.New(msg, "", 0)
+			WithClockCheck(tc.src, 10*time.Millisecond, tc.strict)(&dev.cfg)
+			dev.daq.clock = &ClockSync{Source: "previous run"}
+
+			off, err = tc.off, tc.err
+			e := dev.checkClock()
+			switch {
+			case e != nil && tc.fail != "":
+				if got, want := e.Error(), tc.fail; got != want {
+					t.Fatalf("invalid error:\ngot= %q\nwant=%q", got, want)
+				}
+				if !errors.Is(e, ErrClockUnsync) {
+					t.Fatalf("invalid error type: %+v", e)
+				}
+			case e != nil:
+				t.Fatalf("could not check clock: %+v", e)
+			case tc.fail != "":
+				t.Fatalf("expected an error (%s)", tc.fail)
+			}
+
+			switch {
+			case tc.want == nil && dev.daq.clock != nil:
+				t.Fatalf("unexpected clock record: %+v", dev.daq.clock)
+			case tc.want != nil && (dev.daq.clock == nil || *dev.daq.clock != *tc.want):
+				t.Fatalf("invalid clock record:\ngot= %+v\nwant=%+v", dev.daq.clock, tc.want)
+			}
+			if got, want := msg.String(), tc.msg; got != want {
+				t.Fatalf("invalid log:\ngot= %q\nwant=%q", got, want)
+			}
+		})
+	}
+}
+
+func TestSNTPOffset(t *testing.T) {
+	const skew = 3 * time.Second
+
+	srv, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("could not create NTP server: %+v", err)
+	}
+	defer srv.Close()
+
+	go func() {
+		var buf [48]byte
+		for {
+			_, addr, err := srv.ReadFrom(buf[:])
+			if err != nil {
+				return
+			}
+			now := ntpTime(time.Now().Add(skew))
+			var rep [48]byte
+			rep[0] = 0x24 // LI=0, VN=4, mode=4 (server)
+			rep[1] = 2    // stratum
+			copy(rep[24:32], buf[40:48])
+			binary.BigEndian.PutUint64(rep[32:], now)
+			binary.BigEndian.PutUint64(rep[40:], now)
+			_, _ = srv.WriteTo(rep[:], addr)
+		}
+	}()
+
+	off, err := sntpOffset(srv.LocalAddr().String(), time.Second)
+	if err != nil {
+		t.Fatalf("could not query NTP server: %+v", err)
+	}
+	if d := off - skew; d > 100*time.Millisecond || d < -100*time.Millisecond {
+		t.Fatalf("invalid clock offset: got=%v, want=%v", off, skew)
+	}
+}
@@ -5,6 +5,7 @@ go 1.16
 require (
 	github.com/go-daq/tdaq v0.14.2
 	github.com/go-sql-driver/mysql v1.6.0
+	github.com/klauspost/compress v1.11.3
 	github.com/peterh/liner v1.2.1
 	github.com/sbinet/pmon v0.4.1
 	go-hep.org/x/hep v0.28.5
@@ -0,0 +1,170 @@
+// Copyright 2020 The go-lpc Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Package cbuf provides a bounded, chunked, bytes buffer.
+package cbuf // import "github.com/go-lpc/mim/internal/cbuf"
+
+import (
+	"errors"
+	"io"
+)
+
+// ErrOverflow is returned when data could not be written to a buffer
+// because its maximum size was reached.
+var ErrOverflow = errors.New("cbuf: buffer overflow")
+
+// Buffer is a bounded bytes buffer, made of fixed-size chunks.
+//
+// Chunks are allocated on demand, until the maximum size of the buffer
+// is reached. Chunks are retained across calls to Reset, so a Buffer
+// can be reused without further allocations.
+type Buffer struct {
+	chunks [][]byte
+	size   int // size of a chunk
+	max    int // maximum size of the buffer
+	n      int // number of bytes held by the buffer
+	lost   int // number of bytes dropped since last reset
+}
+
+// New creates a new buffer made of chunks of the provided size, that can
+// hold at most max bytes.
+// If max is smaller than the chunk size, the buffer holds a single chunk.
+func New(chunk, max int) *Buffer {
+	if chunk <= 0 {
+		panic("cbuf: invalid chunk size")
+	}
+	if max < chunk {
+		max = chunk
+	}
+	return &Buffer{
+		size: chunk,
+		max:  max,
+	}
+}
+
+// Len returns the number of bytes held by the buffer.
+func (b *Buffer) Len() int { return b.n }
+
+// Cap returns the number of bytes the buffer can hold without allocating
+// a new chunk.
+func (b *Buffer) Cap() int {
+	n := len(b.chunks) * b.size
+	if n > b.max {
+		n = b.max
+	}
+	return n
+}
+
+// Max returns the maximum number of bytes the buffer can hold.
+func (b *Buffer) Max() int { return b.max }
+
+// Dropped returns the number of bytes that could not be written to the
+// buffer since the last call to Reset.
+func (b *Buffer) Dropped() int { return b.lost }
+
+// Reset resets the buffer to be empty, retaining the allocated chunks.
+func (b *Buffer) Reset() {
+	b.n = 0
+	b.lost = 0
+}
+
+// Write appends p to the buffer.
+// Write returns ErrOverflow if p could not be written in its entirety,
+// with n the number of bytes that were written.
+func (b *Buffer) Write(p []byte) (int, error) {
+	n := 0
+	for len(p) > 0 {
+		dst := b.tail()
+		if dst == nil {
+			b.lost += len(p)
+			return n, ErrOverflow
+		}
+		nn := copy(dst, p)
+		b.n += nn
+		n += nn
+		p = p[nn:]
+	}
+	return n, nil
+}
+
+// ReadFrom reads data from r until io.EOF and appends it to the buffer.
+// ReadFrom returns ErrOverflow if the buffer became full before io.EOF
+// was reached.
+func (b *Buffer) ReadFrom(r io.Reader) (int64, error) {
+	var n int64
+	for {
+		dst := b.tail()
+		if dst == nil {
+			return n, ErrOverflow
+		}
+		nn, err := r.Read(dst)
+		b.n += nn
+		n += int64(nn)
+		if err != nil {
+			if errors.Is(err, io.EOF) {
+				return n, nil
+			}
+			return n, err
+		}
+	}
+}
+
+// WriteTo writes the content of the buffer to w.
+// The buffer is left unchanged.
+func (b *Buffer) WriteTo(w io.Writer) (int64, error) {
+	var (
+		n   int64
+		rem = b.n
+	)
+	for _, chunk := range b.chunks {
+		if rem <= 0 {
+			break
+		}
+		if len(chunk) > rem {
+			chunk = chunk[:rem]
+		}
+		nn, err := w.Write(chunk)
+		n += int64(nn)
+		rem -= nn
+		if err != nil {
+			return n, err
+		}
+	}
+	return n, nil
+}
+
+// Bytes returns a copy of the content of the buffer.
+func (b *Buffer) Bytes() []byte {
+	out := make([]byte, 0, b.n)
+	rem := b.n
+	for _, chunk := range b.chunks {
+		if rem <= 0 {
+			break
+		}
+		if len(chunk) > rem {
+			chunk = chunk[:rem]
+		}
+		out = append(out, chunk...)
+		rem -= len(chunk)
+	}
+	return out
+}
+
+// tail returns the free space of the last used chunk, allocating a new
+// chunk if needed.
+// tail returns nil if the buffer is full.
+func (b *Buffer) tail() []byte {
+	if b.n >= b.max {
+		return nil
+	}
+	i := b.n / b.size
+	if i == len(b.chunks) {
+		sz := b.size
+		if rem := b.max - i*b.size; rem < sz {
+			sz = rem
+		}
+		b.chunks = append(b.chunks, make([]byte, sz))
+	}
+	return b.chunks[i][b.n-i*b.size:]
+}
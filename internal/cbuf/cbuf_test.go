@@ -0,0 +1,195 @@
+// Copyright 2020 The go-lpc Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package cbuf
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"strings"
+	"testing"
+	"testing/iotest"
+)
+
+func TestBufferWrite(t *testing.T) {
+	b := New(4, 10)
+
+	n, err := b.Write([]byte("0123456"))
+	if err != nil {
+		t.Fatalf("could not write: %+v", err)
+	}
+	if got, want := n, 7; got != want {
+		t.Fatalf("invalid write-len: got=%d, want=%d", got, want)
+	}
+	if got, want := b.Len(), 7; got != want {
+		t.Fatalf("invalid len: got=%d, want=%d", got, want)
+	}
+	if got, want := b.Cap(), 8; got != want {
+		t.Fatalf("invalid cap: got=%d, want=%d", got, want)
+	}
+
+	n, err = b.Write([]byte("789abc"))
+	if !errors.Is(err, ErrOverflow) {
+		t.Fatalf("invalid error: got=%v, want=%v", err, ErrOverflow)
+	}
+	if got, want := n, 3; got != want {
+		t.Fatalf("invalid write-len: got=%d, want=%d", got, want)
+	}
+	if got, want := string(b.Bytes()), "0123456789"; got != want {
+		t.Fatalf("invalid content: got=%q, want=%q", got, want)
+	}
+	if got, want := b.Cap(), 10; got != want {
+		t.Fatalf("invalid cap: got=%d, want=%d", got, want)
+	}
+
+	n, err = b.Write([]byte("de"))
+	if !errors.Is(err, ErrOverflow) {
+		t.Fatalf("invalid error: got=%v, want=%v", err, ErrOverflow)
+	}
+	if got, want := n, 0; got != want {
+		t.Fatalf("invalid write-len: got=%d, want=%d", got, want)
+	}
+	if got, want := b.Dropped(), 5; got != want {
+		t.Fatalf("invalid dropped bytes: got=%d, want=%d", got, want)
+	}
+
+	b.Reset()
+	if got, want := b.Len(), 0; got != want {
+		t.Fatalf("invalid len after reset: got=%d, want=%d", got, want)
+	}
+	if got, want := b.Dropped(), 0; got != want {
+		t.Fatalf("invalid dropped bytes after reset: got=%d, want=%d", got, want)
+	}
+	if got, want := b.Cap(), 10; got != want {
+		t.Fatalf("invalid cap after reset: got=%d, want=%d", got, want)
+	}
+
+	_, err = b.Write([]byte("hello"))
+	if err != nil {
+		t.Fatalf("could not write: %+v", err)
+	}
+	if got, want := string(b.Bytes()), "hello"; got != want {
+		t.Fatalf("invalid content: got=%q, want=%q", got, want)
+	}
+}
+
+func TestBufferSingleChunk(t *testing.T) {
+	b := New(8, 0)
+	if got, want := b.Max(), 8; got != want {
+		t.Fatalf("invalid max: got=%d, want=%d", got, want)
+	}
+
+	n, err := b.Write(make([]byte, 9))
+	if !errors.Is(err, ErrOverflow) {
+		t.Fatalf("invalid error: got=%v, want=%v", err, ErrOverflow)
+	}
+	if got, want := n, 8; got != want {
+		t.Fatalf("invalid write-len: got=%d, want=%d", got, want)
+	}
+}
+
+func TestBufferReadFrom(t *testing.T) {
+	for _, tc := range []struct {
+		name string
+		r    io.Reader
+		max  int
+		want string
+		err  error
+	}{
+		{
+			name: "ok",
+			r:    strings.NewReader("0123456789"),
+			max:  16,
+			want: "0123456789",
+		},
+		{
+			name: "one-byte",
+			r:    iotest.OneByteReader(strings.NewReader("0123456789")),
+			max:  16,
+			want: "0123456789",
+		},
+		{
+			name: "exact",
+			r:    strings.NewReader("0123456789"),
+			max:  10,
+			want: "0123456789",
+			err:  ErrOverflow,
+		},
+		{
+			name: "overflow",
+			r:    strings.NewReader("0123456789"),
+			max:  6,
+			want: "012345",
+			err:  ErrOverflow,
+		},
+		{
+			name: "error",
+			r:    iotest.TimeoutReader(strings.NewReader("0123456789")),
+			max:  16,
+			want: "012",
+			err:  iotest.ErrTimeout,
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			b := New(3, tc.max)
+			n, err := b.ReadFrom(tc.r)
+			if !errors.Is(err, tc.err) {
+				t.Fatalf("invalid error: got=%v, want=%v", err, tc.err)
+			}
+			if got, want := int(n), len(tc.want); got != want {
+				t.Fatalf("invalid read-len: got=%d, want=%d", got, want)
+			}
+			if got, want := string(b.Bytes()), tc.want; got != want {
+				t.Fatalf("invalid content: got=%q, want=%q", got, want)
+			}
+		})
+	}
+}
+
+func TestBufferWriteTo(t *testing.T) {
+	b := New(3, 16)
+	_, err := b.Write([]byte("0123456789"))
+	if err != nil {
+		t.Fatalf("could not write: %+v", err)
+	}
+
+	var o bytes.Buffer
+	n, err := b.WriteTo(&o)
+	if err != nil {
+		t.Fatalf("could not write-to: %+v", err)
+	}
+	if got, want := n, int64(10); got != want {
+		t.Fatalf("invalid write-to len: got=%d, want=%d", got, want)
+	}
+	if got, want := o.String(), "0123456789"; got != want {
+		t.Fatalf("invalid content: got=%q, want=%q", got, want)
+	}
+	if got, want := b.Len(), 10; got != want {
+		t.Fatalf("buffer should be left unchanged: got=%d, want=%d", got, want)
+	}
+
+	w := &failWriter{n: 4}
+	n, err = b.WriteTo(w)
+	if !errors.Is(err, io.ErrShortWrite) {
+		t.Fatalf("invalid error: got=%v, want=%v", err, io.ErrShortWrite)
+	}
+	if got, want := n, int64(4); got != want {
+		t.Fatalf("invalid write-to len: got=%d, want=%d", got, want)
+	}
+}
+
+type failWriter struct {
+	n int
+}
+
+func (w *failWriter) Write(p []byte) (int, error) {
+	if len(p) > w.n {
+		n := w.n
+		w.n = 0
+		return n, io.ErrShortWrite
+	}
+	w.n -= len(p)
+	return len(p), nil
+}
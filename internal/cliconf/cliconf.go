@@ -0,0 +1,205 @@
+// Copyright 2020 The go-lpc Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Package cliconf provides consistent command-line flags for the MIM
+// commands, with values resolved from the command line, the environment
+// and a configuration file.
+//
+// Flag values are resolved in the following order, the first match
+// winning:
+//   - the command line,
+//   - the MIM_<NAME> environment variable (e.g. MIM_DEV_MEM for -dev-mem),
+//   - the configuration file given with -config, made of name=value lines,
+//   - the flag default value.
+package cliconf // import "github.com/go-lpc/mim/internal/cliconf"
+
+import (
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+	"time"
+)
+
+// EnvPrefix is the prefix of the environment variables holding flag values.
+const EnvPrefix = "MIM_"
+
+// FlagSet is a set of flags with standard names for the concepts shared
+// across MIM commands.
+type FlagSet struct {
+	*flag.FlagSet
+
+	config *string
+}
+
+// New returns a new flag set wrapping fset, with the -config and -version
+// flags.
+func New(fset *flag.FlagSet) *FlagSet {
+	fs := &FlagSet{
+		FlagSet: fset,
+		config:  fset.String("config", "", "path to a file of name=value lines providing flag values"),
+	}
+	Version(fset)
+	return fs
+}
+
+// Addr defines the -addr flag: the [ip]:port address to listen on.
+func (fs *FlagSet) Addr(value string) *string {
+	return fs.String("addr", value, "[ip]:port to listen on")
+}
+
+// OutDir defines the -o flag: the output directory of a command.
+func (fs *FlagSet) OutDir(value string) *string {
+	return fs.String("o", value, "output directory")
+}
+
+// Run defines the -run flag: the run number.
+func (fs *FlagSet) Run(value int) *int {
+	return fs.Int("run", value, "run number")
+}
+
+// DevMem defines the -dev-mem flag: the path to the memory device of an
+// EDA board.
+func (fs *FlagSet) DevMem() *string {
+	return fs.String("dev-mem", "/dev/mem", "path to the memory device of the EDA board")
+}
+
+// DevSHM defines the -dev-shm flag: the path to the shared memory
+// directory of an EDA board.
+func (fs *FlagSet) DevSHM() *string {
+	return fs.String("dev-shm", "/dev/shm", "path to the shared memory directory of the EDA board")
+}
+
+// Freq defines the -freq flag: the interval between two monitoring probes.
+func (fs *FlagSet) Freq(value time.Duration) *time.Duration {
+	return fs.Duration("freq", value, "monitoring interval")
+}
+
+// Alias defines alias as a deprecated alternative name for the flag name.
+func (fs *FlagSet) Alias(alias, name string) {
+	f := fs.Lookup(name)
+	if f == nil {
+		panic(fmt.Errorf("cliconf: no flag %q to alias", name))
+	}
+	fs.Var(aliasValue{fs: fs.FlagSet, name: name}, alias, "deprecated: use -"+name)
+}
+
+// Parse parses the command line arguments and resolves the values of
+// the flags not set on the command line from the environment and the
+// configuration file.
+func (fs *FlagSet) Parse(args []string) error {
+	err := fs.FlagSet.Parse(args)
+	if err != nil {
+		return err
+	}
+
+	set := make(map[string]bool)
+	fs.Visit(func(f *flag.Flag) { set[f.Name] = true })
+
+	if *fs.config == "" {
+		*fs.config = os.Getenv(EnvName("config"))
+	}
+	if *fs.config != "" {
+		err = fs.load(*fs.config, set)
+		if err != nil {
+			return fmt.Errorf("could not load configuration file: %w", err)
+		}
+	}
+
+	var errs []string
+	fs.VisitAll(func(f *flag.Flag) {
+		if set[f.Name] || f.Name == "config" {
+			return
+		}
+		switch f.Value.(type) {
+		case aliasValue, versionValue:
+			return
+		}
+		v, ok := os.LookupEnv(EnvName(f.Name))
+		if !ok {
+			return
+		}
+		err := fs.Set(f.Name, v)
+		if err != nil {
+			errs = append(errs, fmt.Sprintf("invalid value %q for %s: %v", v, EnvName(f.Name), err))
+		}
+	})
+	if len(errs) > 0 {
+		return fmt.Errorf("could not resolve flags from environment: %s", strings.Join(errs, "; "))
+	}
+
+	return nil
+}
+
+// load sets the flags not in set from the name=value lines of the
+// provided configuration file.
+// Empty lines and lines starting with '#' are ignored.
+func (fs *FlagSet) load(fname string, set map[string]bool) error {
+	raw, err := ioutil.ReadFile(fname)
+	if err != nil {
+		return fmt.Errorf("could not read %q: %w", fname, err)
+	}
+
+	for i, line := range strings.Split(string(raw), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		toks := strings.SplitN(line, "=", 2)
+		if len(toks) != 2 {
+			return fmt.Errorf("invalid line %d in %q: %q", i+1, fname, line)
+		}
+		var (
+			name = strings.TrimLeft(strings.TrimSpace(toks[0]), "-")
+			val  = strings.TrimSpace(toks[1])
+		)
+		if name == "config" {
+			return fmt.Errorf("invalid line %d in %q: nested configuration file", i+1, fname)
+		}
+		if f := fs.Lookup(name); f != nil {
+			if a, ok := f.Value.(aliasValue); ok {
+				name = a.name
+			}
+		}
+		if set[name] {
+			continue
+		}
+		err = fs.Set(name, val)
+		if err != nil {
+			return fmt.Errorf("invalid line %d in %q: %w", i+1, fname, err)
+		}
+	}
+	return nil
+}
+
+// EnvName returns the name of the environment variable holding the value
+// of the provided flag.
+func EnvName(flag string) string {
+	return EnvPrefix + strings.ToUpper(strings.Replace(flag, "-", "_", -1))
+}
+
+type aliasValue struct {
+	fs   *flag.FlagSet
+	name string
+}
+
+func (v aliasValue) String() string {
+	if v.fs == nil {
+		return ""
+	}
+	return v.fs.Lookup(v.name).Value.String()
+}
+
+func (v aliasValue) Set(s string) error {
+	return v.fs.Set(v.name, s)
+}
+
+func (v aliasValue) IsBoolFlag() bool {
+	if v.fs == nil {
+		return false
+	}
+	b, ok := v.fs.Lookup(v.name).Value.(interface{ IsBoolFlag() bool })
+	return ok && b.IsBoolFlag()
+}
@@ -0,0 +1,188 @@
+// Copyright 2020 The go-lpc Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package cliconf
+
+import (
+	"flag"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParse(t *testing.T) {
+	tmp, err := ioutil.TempDir("", "mim-cliconf-")
+	if err != nil {
+		t.Fatalf("could not create tmp dir: %+v", err)
+	}
+	defer os.RemoveAll(tmp)
+
+	cfg := filepath.Join(tmp, "mim.cfg")
+	err = ioutil.WriteFile(cfg, []byte(`# MIM configuration
+run = 42
+-addr=:1234
+dir=/data/file
+
+dev-mem=/dev/mem-file
+`), 0644)
+	if err != nil {
+		t.Fatalf("could not write config file: %+v", err)
+	}
+
+	os.Setenv(EnvName("dev-mem"), "/dev/mem-env")
+	os.Setenv(EnvName("freq"), "2s")
+	defer os.Unsetenv(EnvName("dev-mem"))
+	defer os.Unsetenv(EnvName("freq"))
+
+	var (
+		fs     = New(flag.NewFlagSet("mim", flag.ContinueOnError))
+		addr   = fs.Addr(":8080")
+		odir   = fs.OutDir("/home/root/run")
+		run    = fs.Run(-1)
+		devmem = fs.DevMem()
+		devshm = fs.DevSHM()
+		freq   = fs.Freq(time.Second)
+	)
+	fs.Alias("dir", "o")
+
+	err = fs.Parse([]string{"-config", cfg, "-addr=:9999"})
+	if err != nil {
+		t.Fatalf("could not parse flags: %+v", err)
+	}
+
+	for _, tc := range []struct {
+		name string
+		got  interface{}
+		want interface{}
+	}{
+		{"addr", *addr, ":9999"},             // command line
+		{"o", *odir, "/data/file"},           // config file, via alias
+		{"run", *run, 42},                    // config file
+		{"dev-mem", *devmem, "/dev/mem-env"}, // env wins over config file
+		{"dev-shm", *devshm, "/dev/shm"},     // default
+		{"freq", *freq, 2 * time.Second},     // env
+	} {
+		if tc.got != tc.want {
+			t.Errorf("invalid -%s value: got=%v, want=%v", tc.name, tc.got, tc.want)
+		}
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	tmp, err := ioutil.TempDir("", "mim-cliconf-")
+	if err != nil {
+		t.Fatalf("could not create tmp dir: %+v", err)
+	}
+	defer os.RemoveAll(tmp)
+
+	for _, tc := range []struct {
+		name string
+		cfg  string
+		env  string
+		err  string
+	}{
+		{
+			name: "unknown-flag",
+			cfg:  "rshaper=3\n",
+			err:  `could not load configuration file: invalid line 1 in "%[1]s": no such flag -rshaper`,
+		},
+		{
+			name: "invalid-line",
+			cfg:  "run\n",
+			err:  `could not load configuration file: invalid line 1 in "%[1]s": "run"`,
+		},
+		{
+			name: "nested-config",
+			cfg:  "config=other.cfg\n",
+			err:  `could not load configuration file: invalid line 1 in "%[1]s": nested configuration file`,
+		},
+		{
+			name: "invalid-env",
+			env:  "not-a-number",
+			err:  `could not resolve flags from environment: invalid value "not-a-number" for MIM_RUN: parse error`,
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			cfg := filepath.Join(tmp, tc.name+".cfg")
+			err := ioutil.WriteFile(cfg, []byte(tc.cfg), 0644)
+			if err != nil {
+				t.Fatalf("could not write config file: %+v", err)
+			}
+			if tc.env != "" {
+				os.Setenv(EnvName("run"), tc.env)
+				defer os.Unsetenv(EnvName("run"))
+			}
+
+			fs := New(flag.NewFlagSet("mim", flag.ContinueOnError))
+			_ = fs.Run(-1)
+
+			err = fs.Parse([]string{"-config", cfg})
+			if err == nil {
+				t.Fatalf("expected an error")
+			}
+			if got, want := err.Error(), strings.Replace(tc.err, "%[1]s", cfg, -1); got != want {
+				t.Fatalf("invalid error:\ngot= %s\nwant=%s", got, want)
+			}
+		})
+	}
+}
+
+func TestEnvName(t *testing.T) {
+	for _, tc := range []struct {
+		flag string
+		want string
+	}{
+		{"run", "MIM_RUN"},
+		{"dev-mem", "MIM_DEV_MEM"},
+		{"o", "MIM_O"},
+	} {
+		if got := EnvName(tc.flag); got != tc.want {
+			t.Errorf("invalid env name for %q: got=%q, want=%q", tc.flag, got, tc.want)
+		}
+	}
+}
+
+func TestVersion(t *testing.T) {
+	defer func(w io.Writer, f func(int)) { stdout, exit = w, f }(stdout, exit)
+
+	var (
+		out  = new(strings.Builder)
+		code = -1
+	)
+	stdout = out
+	exit = func(c int) { code = c }
+
+	fs := New(flag.NewFlagSet("mim", flag.ContinueOnError))
+	err := fs.Parse([]string{"-version"})
+	if err != nil {
+		t.Fatalf("could not parse flags: %+v", err)
+	}
+	if code != 0 {
+		t.Fatalf("invalid exit code: got=%d, want=0", code)
+	}
+	if got, want := out.String(), VersionString()+"\n"; got != want {
+		t.Fatalf("invalid version:\ngot= %q\nwant=%q", got, want)
+	}
+	if got := versionString("eda-daq"); !strings.HasPrefix(got, "eda-daq github.com/go-lpc/mim@") {
+		t.Fatalf("invalid version string: %q", got)
+	}
+
+	// -version is not resolved from the environment.
+	os.Setenv(EnvName("version"), "true")
+	defer os.Unsetenv(EnvName("version"))
+
+	out.Reset()
+	fs = New(flag.NewFlagSet("mim", flag.ContinueOnError))
+	err = fs.Parse(nil)
+	if err != nil {
+		t.Fatalf("could not parse flags: %+v", err)
+	}
+	if out.Len() != 0 {
+		t.Fatalf("unexpected version output: %q", out.String())
+	}
+}
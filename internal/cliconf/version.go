@@ -0,0 +1,75 @@
+// Copyright 2020 The go-lpc Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package cliconf
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"runtime"
+	"time"
+
+	"github.com/go-lpc/mim"
+)
+
+var (
+	stdout io.Writer = os.Stdout
+	exit             = os.Exit
+)
+
+// Version defines the -version flag on fset: when given, the command
+// prints its version and exits.
+//
+// Flag sets created with New already define -version.
+func Version(fset *flag.FlagSet) {
+	fset.Var(versionValue{}, "version", "print version and exit")
+}
+
+// VersionString returns the version of the running command: the version
+// of the mim module it was built from, the VCS revision of its sources
+// and the Go toolchain version.
+func VersionString() string {
+	return versionString(filepath.Base(os.Args[0]))
+}
+
+func versionString(cmd string) string {
+	vers, _ := mim.Version()
+	if vers == "" {
+		vers = "(devel)"
+	}
+
+	rev := "rev=unknown"
+	if v, ok := mim.VCS(); ok {
+		id := v.ID
+		if len(id) > 12 {
+			id = id[:12]
+		}
+		rev = "rev=" + id
+		if !v.Time.IsZero() {
+			rev += ", " + v.Time.UTC().Format(time.RFC3339)
+		}
+		if v.Modified {
+			rev += ", modified"
+		}
+	}
+
+	return fmt.Sprintf("%s github.com/go-lpc/mim@%s (%s) %s", cmd, vers, rev, runtime.Version())
+}
+
+type versionValue struct{}
+
+func (versionValue) String() string   { return "false" }
+func (versionValue) IsBoolFlag() bool { return true }
+
+func (versionValue) Set(s string) error {
+	if s != "true" {
+		return nil
+	}
+	fmt.Fprintln(stdout, VersionString())
+	exit(0)
+	return nil
+}
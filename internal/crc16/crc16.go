@@ -18,6 +18,9 @@ type Hash16 interface {
 // The size of a CRC-16 checksum in bytes.
 const Size = 2
 
+// Init is the initial value of a CRC-16 checksum.
+const Init = 0xffff
+
 // Table is a 256-word table representing the polynomial for efficient processing.
 type Table [256]uint16
 
@@ -58,6 +61,57 @@ var (
 	}
 )
 
+// slicing8Table holds the tables used to process 8 bytes at a time:
+// entry k holds the checksums of each byte value followed by k zero bytes.
+type slicing8Table [8]Table
+
+var ftdiTable8 = makeSlicing8Table(&ftdiTable)
+
+func makeSlicing8Table(tab *Table) *slicing8Table {
+	t := new(slicing8Table)
+	t[0] = *tab
+	for i := range tab {
+		crc := tab[i]
+		for k := 1; k < len(t); k++ {
+			crc = crc<<8 ^ tab[crc>>8]
+			t[k][i] = crc
+		}
+	}
+	return t
+}
+
+// Update returns the result of adding the bytes in p to the crc,
+// using the polynomial represented by the Table (or the default FTDI
+// polynomial if tab is nil).
+func Update(crc uint16, tab *Table, p []byte) uint16 {
+	if tab == nil || tab == &ftdiTable {
+		return updateSlicing8(crc, ftdiTable8, p)
+	}
+	return update(crc, tab, p)
+}
+
+func update(crc uint16, tab *Table, p []byte) uint16 {
+	for _, v := range p {
+		crc = crc<<8 ^ tab[byte(crc>>8)^v]
+	}
+	return crc
+}
+
+func updateSlicing8(crc uint16, tab *slicing8Table, p []byte) uint16 {
+	for len(p) >= 8 {
+		crc = tab[7][byte(crc>>8)^p[0]] ^
+			tab[6][byte(crc)^p[1]] ^
+			tab[5][p[2]] ^
+			tab[4][p[3]] ^
+			tab[3][p[4]] ^
+			tab[2][p[5]] ^
+			tab[1][p[6]] ^
+			tab[0][p[7]]
+		p = p[8:]
+	}
+	return update(crc, &tab[0], p)
+}
+
 type digest struct {
 	crc uint16
 	tab *Table
@@ -65,10 +119,10 @@ type digest struct {
 
 func (d *digest) Size() int      { return 2 }
 func (d *digest) BlockSize() int { return 1 }
-func (d *digest) Reset()         { d.crc = 0xffff }
+func (d *digest) Reset()         { d.crc = Init }
 
 func (d *digest) Write(p []byte) (n int, err error) {
-	d.crc = d.update(p)
+	d.crc = Update(d.crc, d.tab, p)
 	return len(p), nil
 }
 
@@ -79,14 +133,6 @@ func (d *digest) Sum(in []byte) []byte {
 	return append(in, byte(s>>8), byte(s))
 }
 
-// update returns the result of adding the bytes in data to the crc.
-func (d *digest) update(data []byte) uint16 {
-	for _, v := range data {
-		d.crc = (d.crc&0xff)<<8 ^ (*d.tab)[(byte(d.crc>>8)^(v&0xff))&0xff]
-	}
-	return d.crc
-}
-
 // New creates a new hash.Hash16 computing the CRC-16 checksum
 // using the polynomial represented by the Table.
 // Its Sum method will lay the value out in big-endian byte order.
@@ -95,7 +141,7 @@ func New(tab *Table) Hash16 {
 		tab = &ftdiTable
 	}
 	return &digest{
-		crc: 0xffff,
+		crc: Init,
 		tab: tab,
 	}
 }
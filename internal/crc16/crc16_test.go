@@ -8,6 +8,7 @@ import (
 	"bytes"
 	"encoding/binary"
 	"fmt"
+	"math/rand"
 	"testing"
 
 	"github.com/go-lpc/mim/internal/crc16"
@@ -56,3 +57,70 @@ func TestCRC16(t *testing.T) {
 		})
 	}
 }
+
+// crc16Bitwise computes the CRC-16/FTDI checksum of p, one bit at a time.
+func crc16Bitwise(crc uint16, p []byte) uint16 {
+	const poly = 0x1021
+	for _, v := range p {
+		crc ^= uint16(v) << 8
+		for i := 0; i < 8; i++ {
+			switch {
+			case crc&0x8000 != 0:
+				crc = crc<<1 ^ poly
+			default:
+				crc <<= 1
+			}
+		}
+	}
+	return crc
+}
+
+func TestUpdate(t *testing.T) {
+	rnd := rand.New(rand.NewSource(1234))
+	raw := make([]byte, 1024)
+	rnd.Read(raw)
+
+	custom := new(crc16.Table)
+	for i := range custom {
+		custom[i] = crc16Bitwise(0, []byte{byte(i)})
+	}
+
+	for n := 0; n < 100; n++ {
+		p := raw[:n]
+		want := crc16Bitwise(crc16.Init, p)
+		if got := crc16.Update(crc16.Init, nil, p); got != want {
+			t.Fatalf("n=%d: invalid checksum: got=0x%04x, want=0x%04x", n, got, want)
+		}
+		if got := crc16.Update(crc16.Init, custom, p); got != want {
+			t.Fatalf("n=%d: invalid custom table checksum: got=0x%04x, want=0x%04x", n, got, want)
+		}
+
+		// checksums may be computed in chunks.
+		crc := crc16.New(nil)
+		for len(p) > 0 {
+			i := rnd.Intn(len(p) + 1)
+			_, _ = crc.Write(p[:i])
+			p = p[i:]
+		}
+		if got := crc.Sum16(); got != want {
+			t.Fatalf("n=%d: invalid chunked checksum: got=0x%04x, want=0x%04x", n, got, want)
+		}
+	}
+}
+
+func BenchmarkCRC16(b *testing.B) {
+	for _, size := range []int{1, 20, 1 << 10, 64 << 10} {
+		raw := make([]byte, size)
+		rand.New(rand.NewSource(1234)).Read(raw)
+		b.Run(fmt.Sprintf("%d", size), func(b *testing.B) {
+			crc := crc16.New(nil)
+			b.SetBytes(int64(size))
+			b.ReportAllocs()
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				crc.Reset()
+				_, _ = crc.Write(raw)
+			}
+		})
+	}
+}
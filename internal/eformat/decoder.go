@@ -17,60 +17,164 @@ import (
 // Decoder computes the CRC-16 checksums on the fly, during the
 // acquisition of DIF Frames.
 type Decoder struct {
-	r io.Reader
+	r  io.Reader
+	jr *journal // journal of the current DIF block, in streaming mode
 
-	dif uint8 // current DIF ID
-	buf []byte
-	err error
-	crc crc16.Hash16
+	dif  uint8 // current DIF ID
+	auto bool  // whether the DIF ID is discovered from the first block
+	buf  []byte
+	err  error
+	crc  uint16 // running CRC-16 checksum
+	n    int    // number of bytes of the current DIF block read so far
+	blk  Block  // accounting of the last DIF block
+
+	hdr [32]byte // global header buffer
+	frm [23]byte // hardroc frame buffer: bcid (3 bytes) + data (16 bytes) + fine timestamp (4 bytes)
+
+	set    Settings // last settings record read from the stream
+	hasSet bool
+
+	prov    Provenance // last provenance trailer read from the stream
+	hasProv bool
+
+	thr    Thresholds // thresholds record of the last DIF block read from the stream
+	hasThr bool
 
 	// IsEDA indicates whether input is from EDA DAQ.
 	// If true, this enables a hack (ignoring trailing CRC16 checksum)
 	// needed to not fail when decoding EDA data coming from the DAQ.
 	IsEDA bool
+
+	// ExtFrames enables the decoding of the extended frame layout of
+	// HR3 ASICs, where each frame carries a 4-byte fine timestamp.
+	// Standard frames are decoded whether ExtFrames is set or not.
+	ExtFrames bool
 }
 
 // NewDecoder returns a new Decoder that reads from r.
+// The Decoder only accepts DIF blocks with the provided DIF ID, unless
+// difID is 0.
 func NewDecoder(difID uint8, r io.Reader) *Decoder {
 	return &Decoder{
 		r:   r,
 		dif: difID,
 		buf: make([]byte, 8),
-		crc: crc16.New(nil),
+		crc: crc16.Init,
 	}
 }
 
+// NewStreamDecoder returns a new Decoder that reads from a live stream r,
+// typically a net.Conn with a read deadline.
+// The Decoder only accepts DIF blocks with the provided DIF ID, unless
+// difID is 0.
+//
+// The bytes of the DIF block being decoded are kept until the block is
+// complete. When a read times out, Decode returns ErrTimeout and the next
+// call to Decode resumes the decoding of that same block, once more data
+// is available.
+func NewStreamDecoder(difID uint8, r io.Reader) *Decoder {
+	dec := NewDecoder(difID, r)
+	dec.jr = &journal{r: r}
+	dec.r = dec.jr
+	return dec
+}
+
+// NewAutoDecoder returns a new Decoder that reads from r.
+// The Decoder accepts the DIF ID of the first DIF block read from r and
+// then only accepts DIF blocks with that same DIF ID.
+func NewAutoDecoder(r io.Reader) *Decoder {
+	dec := NewDecoder(0, r)
+	dec.auto = true
+	return dec
+}
+
+// Block holds the accounting data of a DIF block, as read by a Decoder.
+type Block struct {
+	Size    int    // number of bytes of the block, from the global header marker to the CRC-16 checksum
+	CompCRC uint16 // CRC-16 checksum computed over the received block
+	RecvCRC uint16 // CRC-16 checksum received with the block
+}
+
+// Block returns the size and the CRC-16 checksums of the last DIF block
+// read by Decode or DecodeBytes.
+// Block is also set when decoding failed because of inconsistent CRC-16
+// checksums, and is zero when decoding failed before the global trailer.
+// Settings and thresholds records and provenance trailers are not accounted
+// for.
+func (dec *Decoder) Block() Block {
+	return dec.blk
+}
+
+// DIFID returns the DIF ID the Decoder accepts.
+// DIFID returns 0 if the Decoder accepts any DIF ID or if it has not yet
+// discovered it.
+func (dec *Decoder) DIFID() uint8 {
+	return dec.dif
+}
+
 func (dec *Decoder) crcw(p []byte) {
-	_, _ = dec.crc.Write(p) // can not fail.
+	dec.crc = crc16.Update(dec.crc, nil, p)
 }
 
 func (dec *Decoder) reset() {
-	dec.crc.Reset()
+	dec.crc = crc16.Init
 }
 
 // Decode reads the next DIF data from its input stream and stores it
 // in the value pointed by dif.
+//
+// In streaming mode, Decode returns an error wrapping ErrTimeout when the
+// input stream timed out before a complete DIF block was read.
+// Decode can then be called again to resume decoding.
 func (dec *Decoder) Decode(dif *DIF) error {
+	if dec.jr == nil {
+		return dec.decode(dif)
+	}
+
+	err := dec.decode(dif)
+	if errors.Is(dec.err, ErrTimeout) {
+		dec.err = nil
+		dec.jr.rewind()
+		return err
+	}
+	dec.jr.commit()
+	return err
+}
+
+func (dec *Decoder) decode(dif *DIF) error {
 	dec.reset()
+	dec.blk = Block{}
+	dec.hasThr = false
 
 	v := dec.readU8()
 	if dec.err != nil {
 		return fmt.Errorf("dif: could not read global header marker: %w", dec.err)
 	}
+	for v == setMagic[0] { // settings or thresholds record, or provenance trailer
+		err := dec.decodeRecord()
+		if err != nil {
+			return err
+		}
+		v = dec.readU8()
+		if dec.err != nil {
+			return fmt.Errorf("dif: could not read global header marker: %w", dec.err)
+		}
+	}
 	switch v {
 	case gbHeader, gbHeaderB: // global header. ok
 	default:
 		return fmt.Errorf("dif: could not read global header marker (got=0x%x)", v)
 	}
+	dec.n = 1 // global header marker. records are not part of the block.
 
 	dec.crcU8(v)
 
 	var hdr []byte
 	switch v {
 	case gbHeader:
-		hdr = make([]byte, 23)
+		hdr = dec.hdr[:23]
 	case gbHeaderB:
-		hdr = make([]byte, 32)
+		hdr = dec.hdr[:32]
 	}
 
 	dec.read(hdr)
@@ -83,23 +187,13 @@ func (dec *Decoder) Decode(dif *DIF) error {
 	if dec.dif != 0 && difID != dec.dif {
 		return fmt.Errorf("dif: invalid DIF ID (got=0x%x, want=0x%x)", difID, dec.dif)
 	}
+	if dec.auto && dec.dif == 0 {
+		dec.dif = difID
+	}
 
-	dif.Header.ID = hdr[0]
-	dif.Header.DTC = binary.BigEndian.Uint32(hdr[1 : 1+4])
-	dif.Header.ATC = binary.BigEndian.Uint32(hdr[5 : 5+4])
-	dif.Header.GTC = binary.BigEndian.Uint32(hdr[9 : 9+4])
-	dif.Header.AbsBCID = u64FromU48(hdr[13 : 13+6])
-	dif.Header.TimeDIFTC = u32FromU24(hdr[19 : 19+3])
+	decodeHeader(&dif.Header, v, hdr)
 	dif.Frames = dif.Frames[:0]
 
-	//	var (
-	//		nlines  = int(hdr[22] >> 4)
-	//	)
-
-	var (
-		hrData = make([]byte, 19) // bcid (3 bytes) + data (16 bytes)
-	)
-
 loop:
 	for {
 		v := dec.readU8()
@@ -119,7 +213,14 @@ loop:
 			// analog frame header. not supported.
 			return fmt.Errorf("dif: DIF 0x%x contains an analog frame", dec.dif)
 
-		case frHeader:
+		case frHeader, frHeaderX:
+			hrData := dec.frm[:frameLen-1]
+			if v == frHeaderX {
+				if !dec.ExtFrames {
+					return fmt.Errorf("dif: DIF 0x%x invalid frame/global marker (got=0x%x)", dec.dif, v)
+				}
+				hrData = dec.frm[:frameExtLen-1]
+			}
 		frameLoop:
 			for {
 				v := dec.readU8()
@@ -149,6 +250,9 @@ loop:
 						BCID:   u32FromU24(hrData[:3]),
 					}
 					copy(frame.Data[:], hrData[3:3+16])
+					if len(hrData) == frameExtLen-1 {
+						frame.FineTS = binary.BigEndian.Uint32(hrData[19:])
+					}
 					dif.Frames = append(dif.Frames, frame)
 
 				case incFrame:
@@ -162,7 +266,7 @@ loop:
 
 		case gbTrailer:
 			var (
-				compCRC = dec.crc.Sum16()
+				compCRC = dec.crc
 				recvCRC = dec.readU16()
 			)
 			if dec.err != nil {
@@ -171,6 +275,7 @@ loop:
 					dec.dif, dec.err,
 				)
 			}
+			dec.blk = Block{Size: dec.n, CompCRC: compCRC, RecvCRC: recvCRC}
 
 			if compCRC != recvCRC {
 				if !(dec.IsEDA && recvCRC == 0xc0c0) /*hack for EDA*/ {
@@ -187,11 +292,33 @@ loop:
 	return dec.err
 }
 
+// decodeHeader decodes the global header hdr, read after the v marker.
+func decodeHeader(dst *GlobalHeader, v uint8, hdr []byte) {
+	dst.ID = hdr[0]
+	dst.DTC = binary.BigEndian.Uint32(hdr[1 : 1+4])
+	dst.ATC = binary.BigEndian.Uint32(hdr[5 : 5+4])
+	dst.GTC = binary.BigEndian.Uint32(hdr[9 : 9+4])
+	dst.AbsBCID = u64FromU48(hdr[13 : 13+6])
+	dst.TimeDIFTC = u32FromU24(hdr[19 : 19+3])
+	dst.NbLines = hdr[22]
+	dst.Temp = Temperature{}
+	if v == gbHeaderB {
+		dst.Temp = Temperature{
+			Valid: true,
+			ASU1:  binary.BigEndian.Uint32(hdr[23 : 23+4]),
+			ASU2:  binary.BigEndian.Uint32(hdr[27 : 27+4]),
+			DIF:   hdr[31],
+		}
+	}
+}
+
 func (dec *Decoder) read(p []byte) {
 	if dec.err != nil {
 		return
 	}
-	_, dec.err = io.ReadFull(dec.r, p)
+	var n int
+	n, dec.err = io.ReadFull(dec.r, p)
+	dec.n += n
 }
 
 func (dec *Decoder) readU8() uint8 {
@@ -213,7 +340,8 @@ func (dec *Decoder) load(n int) {
 		dec.buf = append(dec.buf[:len(dec.buf)], make([]byte, n-cap(dec.buf))...)
 	}
 	dec.buf = dec.buf[:n]
-	_, dec.err = io.ReadFull(dec.r, dec.buf[:n])
+	n, dec.err = io.ReadFull(dec.r, dec.buf[:n])
+	dec.n += n
 }
 
 func (dec *Decoder) crcU8(v uint8) {
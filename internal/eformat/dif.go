@@ -0,0 +1,136 @@
+// Copyright 2020 The go-lpc Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package eformat
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"sync"
+
+	"github.com/go-lpc/mim/internal/crc16"
+)
+
+const (
+	gbHeaderLen  = 1 + 23 + 1   // global header marker + header + frame header marker
+	gbTempLen    = 4 + 4 + 1    // temperature block of the 0xBB header variant
+	gbTrailerLen = 1 + 1 + 2    // frame trailer + global trailer + CRC-16
+	frameLen     = 1 + 3 + 16   // hardroc header + bcid + data
+	frameExtLen  = frameLen + 4 // hardroc frame + fine timestamp (HR3)
+)
+
+var bufPool = sync.Pool{
+	New: func() interface{} {
+		buf := make([]byte, 0, gbHeaderLen+gbTrailerLen+frameLen*1024)
+		return &buf
+	},
+}
+
+// Reset resets the DIF header and frames.
+// The memory backing the frames is retained, to be reused by a
+// subsequent call to ReadFrom or Decoder.Decode.
+func (dif *DIF) Reset() {
+	dif.Header = GlobalHeader{}
+	dif.Frames = dif.Frames[:0]
+}
+
+// WriteTo writes the DIF data, with its CRC-16 checksum, to w.
+// WriteTo implements io.WriterTo.
+//
+// The DIF data is marshaled to an internal buffer and written with
+// a single call to w.Write.
+// Frames are written with the standard layout, without their fine
+// timestamps: HR3 extended frames should be written with an Encoder.
+func (dif *DIF) WriteTo(w io.Writer) (int64, error) {
+	pbuf := bufPool.Get().(*[]byte)
+	defer bufPool.Put(pbuf)
+
+	buf := dif.appendTo((*pbuf)[:0])
+	*pbuf = buf
+
+	n, err := w.Write(buf)
+	if err != nil {
+		return int64(n), fmt.Errorf("dif: could not write DIF data: %w", err)
+	}
+	return int64(n), nil
+}
+
+// ReadFrom reads one DIF record from r and stores it into dif,
+// reusing the memory of its frames.
+// ReadFrom implements io.ReaderFrom.
+//
+// ReadFrom does not apply any DIF ID selection and requires a valid
+// CRC-16 checksum: EDA data should be read with a Decoder.
+func (dif *DIF) ReadFrom(r io.Reader) (int64, error) {
+	cr := countingReader{r: r}
+	dec := NewDecoder(0, &cr)
+	err := dec.Decode(dif)
+	return cr.n, err
+}
+
+func (dif *DIF) appendTo(buf []byte) []byte {
+	n := gbHeaderLen + gbTrailerLen + frameLen*len(dif.Frames)
+	marker := byte(gbHeader)
+	if dif.Header.Temp.Valid {
+		n += gbTempLen
+		marker = gbHeaderB
+	}
+	if cap(buf)-len(buf) < n {
+		buf = append(make([]byte, 0, len(buf)+n), buf...)
+	}
+	beg := len(buf)
+
+	var tmp [8]byte
+	buf = append(buf, marker, dif.Header.ID)
+	binary.BigEndian.PutUint32(tmp[:4], dif.Header.DTC)
+	buf = append(buf, tmp[:4]...)
+	binary.BigEndian.PutUint32(tmp[:4], dif.Header.ATC)
+	buf = append(buf, tmp[:4]...)
+	binary.BigEndian.PutUint32(tmp[:4], dif.Header.GTC)
+	buf = append(buf, tmp[:4]...)
+	binary.BigEndian.PutUint64(tmp[:8], dif.Header.AbsBCID)
+	buf = append(buf, tmp[2:8]...)
+	binary.BigEndian.PutUint32(tmp[:4], dif.Header.TimeDIFTC)
+	buf = append(buf, tmp[1:4]...)
+	buf = append(buf, dif.Header.NbLines)
+	if dif.Header.Temp.Valid {
+		binary.BigEndian.PutUint32(tmp[:4], dif.Header.Temp.ASU1)
+		buf = append(buf, tmp[:4]...)
+		binary.BigEndian.PutUint32(tmp[:4], dif.Header.Temp.ASU2)
+		buf = append(buf, tmp[:4]...)
+		buf = append(buf, dif.Header.Temp.DIF)
+	}
+
+	buf = append(buf, frHeader)
+	for i := range dif.Frames {
+		frame := &dif.Frames[i]
+		buf = append(buf,
+			frame.Header,
+			byte(frame.BCID>>16), byte(frame.BCID>>8), byte(frame.BCID),
+		)
+		buf = append(buf, frame.Data[:]...)
+	}
+	buf = append(buf, frTrailer, gbTrailer)
+
+	crc := crc16.Update(crc16.Init, nil, buf[beg:])
+	binary.BigEndian.PutUint16(tmp[:2], crc)
+	return append(buf, tmp[:2]...)
+}
+
+type countingReader struct {
+	r io.Reader
+	n int64
+}
+
+func (r *countingReader) Read(p []byte) (int, error) {
+	n, err := r.r.Read(p)
+	r.n += int64(n)
+	return n, err
+}
+
+var (
+	_ io.WriterTo   = (*DIF)(nil)
+	_ io.ReaderFrom = (*DIF)(nil)
+)
@@ -14,6 +14,7 @@ const (
 	gbTrailer = 0xa0 // global trailer marker
 
 	frHeader  = 0xb4 // frame header marker
+	frHeaderX = 0xb5 // extended frame header marker (HR3 fine timestamps)
 	frTrailer = 0xa3 // frame trailer marker
 
 	anHeader = 0xc4 // analog frame header marker
@@ -34,12 +35,29 @@ type GlobalHeader struct {
 	GTC       uint32 // Global trigger counter
 	AbsBCID   uint64 // Absolute BCID
 	TimeDIFTC uint32 // Time DIF trigger counter
+	NbLines   uint8  // nb-lines field (number of analog lines in the upper nibble)
+
+	Temp Temperature // optional temperature block (0xBB header variant)
+}
+
+// NumLines returns the number of analog lines declared in the header.
+func (hdr GlobalHeader) NumLines() int {
+	return int(hdr.NbLines >> 4)
+}
+
+// Temperature holds the temperature words of a 0xBB DIF global header.
+type Temperature struct {
+	Valid bool   // whether the header carries a temperature block
+	ASU1  uint32 // temperature of the first ASU
+	ASU2  uint32 // temperature of the second ASU
+	DIF   uint8  // temperature of the DIF
 }
 
 type Frame struct {
 	Header uint8 // Hardroc header
 	BCID   uint32
 	Data   [16]uint8
+	FineTS uint32 // fine timestamp (HR3 extended frames only)
 }
 
 type File struct {
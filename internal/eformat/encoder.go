@@ -19,7 +19,15 @@ type Encoder struct {
 	w   io.Writer
 	buf []byte
 	err error
-	crc crc16.Hash16
+	crc uint16 // running CRC-16 checksum
+
+	// ExtFrames enables the encoding of frames with the extended
+	// layout of HR3 ASICs, where each frame carries its fine timestamp.
+	ExtFrames bool
+
+	// Provenance, if not nil, is written as a provenance trailer after
+	// the CRC-16 checksum of each DIF block.
+	Provenance *Provenance
 }
 
 // NewEncoder returns a new Encoder that writes to w.
@@ -27,16 +35,16 @@ func NewEncoder(w io.Writer) *Encoder {
 	return &Encoder{
 		w:   w,
 		buf: make([]byte, 8),
-		crc: crc16.New(nil),
+		crc: crc16.Init,
 	}
 }
 
 func (enc *Encoder) crcw(p []byte) {
-	_, _ = enc.crc.Write(p) // can not fail.
+	enc.crc = crc16.Update(enc.crc, nil, p)
 }
 
 func (enc *Encoder) reset() {
-	enc.crc.Reset()
+	enc.crc = crc16.Init
 }
 
 // Encode writes the DIF data to the stream, computes the corresponding
@@ -48,7 +56,11 @@ func (enc *Encoder) Encode(dif *DIF) error {
 
 	enc.reset()
 
-	enc.writeU8(gbHeader)
+	marker := uint8(gbHeader)
+	if dif.Header.Temp.Valid {
+		marker = gbHeaderB
+	}
+	enc.writeU8(marker)
 	if enc.err != nil {
 		return fmt.Errorf("dif: could not write global header marker: %w", enc.err)
 	}
@@ -59,20 +71,36 @@ func (enc *Encoder) Encode(dif *DIF) error {
 	enc.writeU32(dif.Header.GTC)
 	enc.writeU48(dif.Header.AbsBCID)
 	enc.writeU24(dif.Header.TimeDIFTC)
-	enc.writeU8(0) // nlines
+	enc.writeU8(dif.Header.NbLines)
+	if dif.Header.Temp.Valid {
+		enc.writeU32(dif.Header.Temp.ASU1)
+		enc.writeU32(dif.Header.Temp.ASU2)
+		enc.writeU8(dif.Header.Temp.DIF)
+	}
 
-	enc.writeU8(frHeader)
+	marker = frHeader
+	if enc.ExtFrames {
+		marker = frHeaderX
+	}
+	enc.writeU8(marker)
 	for _, frame := range dif.Frames {
 		enc.writeU8(frame.Header)
 		enc.writeU24(frame.BCID)
 		enc.write(frame.Data[:])
+		if enc.ExtFrames {
+			enc.writeU32(frame.FineTS)
+		}
 	}
 	enc.writeU8(frTrailer)
 	enc.writeU8(gbTrailer)
 
-	crc := enc.crc.Sum16()
+	crc := enc.crc
 	enc.writeU16(crc)
 
+	if enc.Provenance != nil && enc.err == nil {
+		_, enc.err = enc.Provenance.WriteTo(enc.w)
+	}
+
 	return enc.err
 }
 
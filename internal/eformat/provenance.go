@@ -0,0 +1,100 @@
+// Copyright 2020 The go-lpc Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package eformat
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+)
+
+const (
+	provMagic   = "MIMP"        // provenance trailer magic
+	provVersion = 1             // current provenance trailer format version
+	provLenMin  = 4 + 1 + 4 + 1 // provenance trailer payload size, without software version
+)
+
+// Provenance describes where a DIF block was produced.
+//
+// A provenance trailer can be appended after the CRC-16 of a DIF block, so
+// DIF data remains traceable to the EDA board and slot that produced it,
+// e.g. after files of a mixed-board dataset have been renamed.
+//
+// The Decoder skips provenance trailers found in the stream and exposes
+// the last one via Decoder.Provenance.
+type Provenance struct {
+	Version  uint8  // format version of the provenance trailer
+	Board    uint32 // EDA board ID
+	Slot     uint8  // EDA slot of the RFM
+	Firmware uint32 // FPGA firmware version word
+	Software string // version of the DAQ software (at most 255 bytes)
+}
+
+// WriteTo writes the provenance trailer to w, with the current format
+// version.
+// WriteTo implements io.WriterTo.
+func (prov *Provenance) WriteTo(w io.Writer) (int64, error) {
+	if len(prov.Software) > 0xff {
+		return 0, fmt.Errorf("dif: provenance software version too long (len=%d)", len(prov.Software))
+	}
+	size := provLenMin + len(prov.Software)
+	buf := make([]byte, len(provMagic)+1+2+size)
+	copy(buf, provMagic)
+	buf[4] = provVersion
+	binary.BigEndian.PutUint16(buf[5:], uint16(size))
+	p := buf[7:]
+	binary.BigEndian.PutUint32(p[0:], prov.Board)
+	p[4] = prov.Slot
+	binary.BigEndian.PutUint32(p[5:], prov.Firmware)
+	p[9] = uint8(len(prov.Software))
+	copy(p[10:], prov.Software)
+
+	n, err := w.Write(buf)
+	if err != nil {
+		return int64(n), fmt.Errorf("dif: could not write provenance trailer: %w", err)
+	}
+	return int64(n), nil
+}
+
+// decodeProvenance decodes the payload of a provenance trailer.
+func (dec *Decoder) decodeProvenance(vers uint8, size int) error {
+	if size < provLenMin {
+		return fmt.Errorf("dif: invalid provenance trailer size (got=%d, want>=%d)", size, provLenMin)
+	}
+
+	p := make([]byte, size)
+	dec.read(p)
+	if dec.err != nil {
+		if errors.Is(dec.err, io.EOF) {
+			dec.err = io.ErrUnexpectedEOF
+		}
+		return fmt.Errorf("dif: could not read provenance trailer: %w", dec.err)
+	}
+
+	n := int(p[9])
+	if provLenMin+n > size {
+		return fmt.Errorf("dif: invalid provenance software version length (got=%d, max=%d)", n, size-provLenMin)
+	}
+
+	dec.prov = Provenance{
+		Version:  vers,
+		Board:    binary.BigEndian.Uint32(p[0:]),
+		Slot:     p[4],
+		Firmware: binary.BigEndian.Uint32(p[5:]),
+		Software: string(p[10 : 10+n]),
+	}
+	dec.hasProv = true
+	return nil
+}
+
+// Provenance returns the last provenance trailer read from the stream,
+// if any.
+//
+// As a provenance trailer follows the DIF block it describes, it is read
+// (and skipped) by the call to Decode that follows that block.
+func (dec *Decoder) Provenance() (Provenance, bool) {
+	return dec.prov, dec.hasProv
+}
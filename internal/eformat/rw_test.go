@@ -6,9 +6,14 @@ package eformat
 
 import (
 	"bytes"
+	"encoding/binary"
+	"errors"
 	"fmt"
 	"io"
+	"io/ioutil"
+	"math/rand"
 	"reflect"
+	"strings"
 	"testing"
 )
 
@@ -62,6 +67,33 @@ func TestCodec(t *testing.T) {
 				},
 			},
 		},
+		{
+			name: "temperature",
+			dif: DIF{
+				Header: GlobalHeader{
+					ID:        difID,
+					DTC:       10,
+					ATC:       11,
+					GTC:       12,
+					AbsBCID:   0x0000112233445566,
+					TimeDIFTC: 0x00112233,
+					NbLines:   0x30,
+					Temp: Temperature{
+						Valid: true,
+						ASU1:  0x01020304,
+						ASU2:  0x05060708,
+						DIF:   0x09,
+					},
+				},
+				Frames: []Frame{
+					{
+						Header: 1,
+						BCID:   0x001a1b1c,
+						Data:   [16]uint8{0xa, 1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11, 12, 13, 14, 15},
+					},
+				},
+			},
+		},
 	} {
 		t.Run(tc.name, func(t *testing.T) {
 			buf := new(bytes.Buffer)
@@ -536,3 +568,715 @@ func TestDecoder(t *testing.T) {
 		})
 	}
 }
+
+func TestDIFReadWrite(t *testing.T) {
+	want := DIF{
+		Header: GlobalHeader{
+			ID:        0x42,
+			DTC:       10,
+			ATC:       11,
+			GTC:       12,
+			AbsBCID:   0x0000112233445566,
+			TimeDIFTC: 0x00112233,
+		},
+		Frames: []Frame{
+			{Header: 1, BCID: 0x001a1b1c, Data: [16]uint8{0xa, 1, 2, 3}},
+			{Header: 2, BCID: 0x002a2b2c, Data: [16]uint8{0xb, 21, 22, 23}},
+		},
+	}
+
+	ref := new(bytes.Buffer)
+	err := NewEncoder(ref).Encode(&want)
+	if err != nil {
+		t.Fatalf("could not encode dif frames: %+v", err)
+	}
+
+	buf := new(bytes.Buffer)
+	n, err := want.WriteTo(buf)
+	if err != nil {
+		t.Fatalf("could not write dif frames: %+v", err)
+	}
+	if got, want := n, int64(ref.Len()); got != want {
+		t.Fatalf("invalid number of bytes written: got=%d, want=%d", got, want)
+	}
+	if !bytes.Equal(buf.Bytes(), ref.Bytes()) {
+		t.Fatalf("invalid WriteTo output:\ngot= %x\nwant=%x", buf.Bytes(), ref.Bytes())
+	}
+
+	got := DIF{Frames: make([]Frame, 0, 16)}
+	first := &got.Frames[:1][0]
+	n, err = got.ReadFrom(buf)
+	if err != nil {
+		t.Fatalf("could not read dif frames: %+v", err)
+	}
+	if got, want := n, int64(ref.Len()); got != want {
+		t.Fatalf("invalid number of bytes read: got=%d, want=%d", got, want)
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("invalid r/w round-trip:\ngot= %#v\nwant=%#v", got, want)
+	}
+	if &got.Frames[0] != first {
+		t.Fatalf("frames were not reused")
+	}
+
+	got.Reset()
+	if got.Header != (GlobalHeader{}) || len(got.Frames) != 0 {
+		t.Fatalf("invalid reset DIF: %#v", got)
+	}
+	if cap(got.Frames) != 16 {
+		t.Fatalf("invalid reset frames capacity: got=%d, want=%d", cap(got.Frames), 16)
+	}
+
+	_, err = got.ReadFrom(buf)
+	if !errors.Is(err, io.EOF) {
+		t.Fatalf("invalid error: got=%+v, want=%v", err, io.EOF)
+	}
+
+	_, err = want.WriteTo(&failingWriter{n: 0})
+	if !errors.Is(err, io.ErrUnexpectedEOF) {
+		t.Fatalf("invalid error: got=%+v, want=%v", err, io.ErrUnexpectedEOF)
+	}
+}
+
+func TestHeaderTemperature(t *testing.T) {
+	dif := DIF{
+		Header: GlobalHeader{
+			ID:      0x42,
+			NbLines: 0x20,
+			Temp: Temperature{
+				Valid: true,
+				ASU1:  0x11223344,
+				ASU2:  0x55667788,
+				DIF:   0x99,
+			},
+		},
+	}
+
+	buf := new(bytes.Buffer)
+	err := NewEncoder(buf).Encode(&dif)
+	if err != nil {
+		t.Fatalf("could not encode dif: %+v", err)
+	}
+
+	raw := buf.Bytes()
+	if got, want := raw[0], byte(gbHeaderB); got != want {
+		t.Fatalf("invalid global header marker: got=0x%x, want=0x%x", got, want)
+	}
+	if got, want := raw[1+22:1+32], []byte{
+		0x20,                   // nb-lines
+		0x11, 0x22, 0x33, 0x44, // TASU1
+		0x55, 0x66, 0x77, 0x88, // TASU2
+		0x99, // TDIF
+	}; !bytes.Equal(got, want) {
+		t.Fatalf("invalid header tail:\ngot= %x\nwant=%x", got, want)
+	}
+
+	o := new(bytes.Buffer)
+	_, err = dif.WriteTo(o)
+	if err != nil {
+		t.Fatalf("could not write dif: %+v", err)
+	}
+	if !bytes.Equal(o.Bytes(), raw) {
+		t.Fatalf("invalid WriteTo output:\ngot= %x\nwant=%x", o.Bytes(), raw)
+	}
+
+	var got DIF
+	err = NewDecoder(0x42, buf).Decode(&got)
+	if err != nil {
+		t.Fatalf("could not decode dif: %+v", err)
+	}
+	if got.Header != dif.Header {
+		t.Fatalf("invalid header:\ngot= %#v\nwant=%#v", got.Header, dif.Header)
+	}
+	if got, want := got.Header.NumLines(), 2; got != want {
+		t.Fatalf("invalid number of lines: got=%d, want=%d", got, want)
+	}
+}
+
+func TestExtFrames(t *testing.T) {
+	dif := DIF{
+		Header: GlobalHeader{
+			ID:  0x42,
+			DTC: 10,
+		},
+		Frames: []Frame{
+			{
+				Header: 1,
+				BCID:   0x010203,
+				Data:   [16]uint8{0xa, 1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11, 12, 13, 14, 15},
+				FineTS: 0x11223344,
+			},
+			{
+				Header: 2,
+				BCID:   0x040506,
+				FineTS: 0x55667788,
+			},
+		},
+	}
+
+	buf := new(bytes.Buffer)
+	enc := NewEncoder(buf)
+	enc.ExtFrames = true
+	err := enc.Encode(&dif)
+	if err != nil {
+		t.Fatalf("could not encode dif: %+v", err)
+	}
+	raw := buf.Bytes()
+
+	if got, want := len(raw), gbHeaderLen+gbTrailerLen+2*frameExtLen; got != want {
+		t.Fatalf("invalid encoded size: got=%d, want=%d", got, want)
+	}
+	if got, want := raw[gbHeaderLen-1], byte(frHeaderX); got != want {
+		t.Fatalf("invalid frame header marker: got=0x%x, want=0x%x", got, want)
+	}
+
+	t.Run("disabled", func(t *testing.T) {
+		var got DIF
+		err := NewDecoder(0x42, bytes.NewReader(raw)).Decode(&got)
+		if err == nil {
+			t.Fatalf("expected an error")
+		}
+		if got, want := err.Error(), "dif: DIF 0x42 invalid frame/global marker (got=0xb5)"; got != want {
+			t.Fatalf("invalid error:\ngot= %v\nwant=%v", got, want)
+		}
+	})
+
+	t.Run("enabled", func(t *testing.T) {
+		var got DIF
+		dec := NewDecoder(0x42, bytes.NewReader(raw))
+		dec.ExtFrames = true
+		err := dec.Decode(&got)
+		if err != nil {
+			t.Fatalf("could not decode dif: %+v", err)
+		}
+		if !reflect.DeepEqual(got, dif) {
+			t.Fatalf("invalid round-trip:\ngot= %#v\nwant=%#v", got, dif)
+		}
+	})
+
+	t.Run("standard", func(t *testing.T) {
+		std := dif
+		std.Frames = []Frame{dif.Frames[0], dif.Frames[1]}
+		for i := range std.Frames {
+			std.Frames[i].FineTS = 0
+		}
+
+		buf := new(bytes.Buffer)
+		err := NewEncoder(buf).Encode(&std)
+		if err != nil {
+			t.Fatalf("could not encode dif: %+v", err)
+		}
+
+		var got DIF
+		dec := NewDecoder(0x42, buf)
+		dec.ExtFrames = true
+		err = dec.Decode(&got)
+		if err != nil {
+			t.Fatalf("could not decode dif: %+v", err)
+		}
+		if !reflect.DeepEqual(got, std) {
+			t.Fatalf("invalid round-trip:\ngot= %#v\nwant=%#v", got, std)
+		}
+	})
+}
+
+func TestSettings(t *testing.T) {
+	want := Settings{
+		Version: setVersion,
+		Board:   2,
+		Run:     42,
+		RFM:     0xf,
+		RShaper: 3,
+		Delta:   50,
+		HRHash:  0x0123456789abcdef,
+
+		Firmware: 0x01020003,
+	}
+	dif := DIF{Header: GlobalHeader{ID: 0x42, GTC: 1}}
+
+	buf := new(bytes.Buffer)
+	_, err := want.WriteTo(buf)
+	if err != nil {
+		t.Fatalf("could not write settings: %+v", err)
+	}
+	for i := 0; i < 2; i++ {
+		err = NewEncoder(buf).Encode(&dif)
+		if err != nil {
+			t.Fatalf("could not encode dif: %+v", err)
+		}
+	}
+
+	dec := NewDecoder(0x42, buf)
+	if _, ok := dec.Settings(); ok {
+		t.Fatalf("unexpected settings record")
+	}
+	for i := 0; i < 2; i++ {
+		var got DIF
+		err = dec.Decode(&got)
+		if err != nil {
+			t.Fatalf("could not decode dif %d: %+v", i, err)
+		}
+		if got.Header != dif.Header {
+			t.Fatalf("invalid header:\ngot= %#v\nwant=%#v", got.Header, dif.Header)
+		}
+	}
+
+	got, ok := dec.Settings()
+	if !ok {
+		t.Fatalf("missing settings record")
+	}
+	if got != want {
+		t.Fatalf("invalid settings:\ngot= %#v\nwant=%#v", got, want)
+	}
+
+	// newer versions may append fields: they are skipped.
+	ext := new(bytes.Buffer)
+	_, _ = want.WriteTo(ext)
+	raw := ext.Bytes()
+	raw[4] = setVersion + 1 // version
+	raw[6] += 3             // payload size
+	ext.Write([]byte{1, 2, 3})
+	_ = NewEncoder(ext).Encode(&dif)
+
+	dec = NewDecoder(0x42, ext)
+	err = dec.Decode(new(DIF))
+	if err != nil {
+		t.Fatalf("could not decode dif after extended settings: %+v", err)
+	}
+	if got, _ := dec.Settings(); got.Version != setVersion+1 || got.Run != want.Run || got.Firmware != want.Firmware {
+		t.Fatalf("invalid extended settings: %#v", got)
+	}
+
+	// version 1 records have no firmware version.
+	v1 := new(bytes.Buffer)
+	_, _ = want.WriteTo(v1)
+	raw = v1.Bytes()[:7+setLenV1]
+	raw[4] = 1        // version
+	raw[6] = setLenV1 // payload size
+	v1 = bytes.NewBuffer(raw)
+	_ = NewEncoder(v1).Encode(&dif)
+
+	dec = NewDecoder(0x42, v1)
+	err = dec.Decode(new(DIF))
+	if err != nil {
+		t.Fatalf("could not decode dif after v1 settings: %+v", err)
+	}
+	if got, _ := dec.Settings(); got.Version != 1 || got.HRHash != want.HRHash || got.Firmware != 0 {
+		t.Fatalf("invalid v1 settings: %#v", got)
+	}
+
+	err = NewDecoder(0, bytes.NewReader([]byte("MIMX\x01\x00\x1c"))).Decode(new(DIF))
+	if err == nil {
+		t.Fatalf("expected an error on invalid settings magic")
+	}
+}
+
+func TestProvenance(t *testing.T) {
+	want := Provenance{
+		Version:  provVersion,
+		Board:    2,
+		Slot:     3,
+		Firmware: 0x01020003,
+		Software: "v0.5.0",
+	}
+	difs := []DIF{
+		{Header: GlobalHeader{ID: 0x42, GTC: 1}},
+		{Header: GlobalHeader{ID: 0x42, GTC: 2}},
+	}
+
+	buf := new(bytes.Buffer)
+	enc := NewEncoder(buf)
+	enc.Provenance = &want
+	for i := range difs {
+		err := enc.Encode(&difs[i])
+		if err != nil {
+			t.Fatalf("could not encode dif: %+v", err)
+		}
+	}
+
+	dec := NewDecoder(0x42, buf)
+	for i := range difs {
+		var got DIF
+		err := dec.Decode(&got)
+		if err != nil {
+			t.Fatalf("could not decode dif %d: %+v", i, err)
+		}
+		if got.Header != difs[i].Header {
+			t.Fatalf("invalid header:\ngot= %#v\nwant=%#v", got.Header, difs[i].Header)
+		}
+		// the trailer of a block is read with the next block.
+		if _, ok := dec.Provenance(); ok != (i > 0) {
+			t.Fatalf("invalid provenance state after block %d: %v", i, ok)
+		}
+	}
+
+	err := dec.Decode(new(DIF))
+	if !errors.Is(err, io.EOF) {
+		t.Fatalf("expected EOF, got: %+v", err)
+	}
+	got, ok := dec.Provenance()
+	if !ok {
+		t.Fatalf("missing provenance trailer")
+	}
+	if got != want {
+		t.Fatalf("invalid provenance:\ngot= %#v\nwant=%#v", got, want)
+	}
+
+	// decoders skip trailers appended to EDA blocks (fake CRC).
+	eda := new(bytes.Buffer)
+	_ = NewEncoder(eda).Encode(&difs[0])
+	raw := eda.Bytes()
+	raw[len(raw)-2] = 0xc0
+	raw[len(raw)-1] = 0xc0
+	_, _ = want.WriteTo(eda)
+	_ = NewEncoder(eda).Encode(&difs[1])
+	dec = NewDecoder(0x42, eda)
+	dec.IsEDA = true
+	for i := range difs {
+		err = dec.Decode(new(DIF))
+		if err != nil {
+			t.Fatalf("could not decode EDA dif %d: %+v", i, err)
+		}
+	}
+
+	for _, tc := range []struct {
+		name string
+		raw  string
+		err  string
+	}{
+		{
+			name: "too-short",
+			raw:  "MIMP\x01\x00\x02\x00\x00",
+			err:  "dif: invalid provenance trailer size (got=2, want>=10)",
+		},
+		{
+			name: "truncated",
+			raw:  "MIMP\x01\x00\x0a\x00\x00",
+			err:  "dif: could not read provenance trailer: unexpected EOF",
+		},
+		{
+			name: "invalid-software-length",
+			raw:  "MIMP\x01\x00\x0a\x00\x00\x00\x02\x03\x00\x00\x00\x00\x05",
+			err:  "dif: invalid provenance software version length (got=5, max=0)",
+		},
+		{
+			name: "invalid-magic",
+			raw:  "MIMX\x01\x00\x0a",
+			err:  `dif: invalid record magic (got="MIMX")`,
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			err := NewDecoder(0, strings.NewReader(tc.raw)).Decode(new(DIF))
+			if err == nil {
+				t.Fatalf("expected an error")
+			}
+			if got, want := err.Error(), tc.err; got != want {
+				t.Fatalf("invalid error:\ngot= %v\nwant=%v", got, want)
+			}
+		})
+	}
+
+	_, err = (&Provenance{Software: strings.Repeat("x", 256)}).WriteTo(ioutil.Discard)
+	if err == nil {
+		t.Fatalf("expected an error on too long software version")
+	}
+}
+
+func TestThresholds(t *testing.T) {
+	want := Thresholds{Version: thrVersion, Trig: 1, Hit0: 11, Hit1: 22}
+	difs := []DIF{
+		{Header: GlobalHeader{ID: 0x42, GTC: 1}},
+		{Header: GlobalHeader{ID: 0x42, GTC: 2}},
+	}
+
+	buf := new(bytes.Buffer)
+	_, err := want.WriteTo(buf)
+	if err != nil {
+		t.Fatalf("could not write thresholds record: %+v", err)
+	}
+	enc := NewEncoder(buf)
+	for i := range difs {
+		err := enc.Encode(&difs[i])
+		if err != nil {
+			t.Fatalf("could not encode dif: %+v", err)
+		}
+	}
+
+	raw := buf.Bytes()
+	dec := NewDecoder(0x42, bytes.NewReader(raw))
+	for i := range difs {
+		var got DIF
+		err := dec.Decode(&got)
+		if err != nil {
+			t.Fatalf("could not decode dif %d: %+v", i, err)
+		}
+		if got.Header != difs[i].Header {
+			t.Fatalf("invalid header:\ngot= %#v\nwant=%#v", got.Header, difs[i].Header)
+		}
+		// the record only describes the block that follows it.
+		thr, ok := dec.Thresholds()
+		if ok != (i == 0) {
+			t.Fatalf("invalid thresholds state after block %d: %v", i, ok)
+		}
+		if ok && thr != want {
+			t.Fatalf("invalid thresholds:\ngot= %#v\nwant=%#v", thr, want)
+		}
+	}
+
+	var view DIFView
+	_, err = NewDecoder(0x42, nil).DecodeBytes(raw, &view)
+	if err != nil {
+		t.Fatalf("could not decode dif view: %+v", err)
+	}
+	if view.Header != difs[0].Header {
+		t.Fatalf("invalid view header:\ngot= %#v\nwant=%#v", view.Header, difs[0].Header)
+	}
+
+	for _, tc := range []struct {
+		name string
+		raw  string
+		err  string
+	}{
+		{
+			name: "too-short",
+			raw:  "MIMT\x01\x00\x02\x00\x00",
+			err:  "dif: invalid thresholds record size (got=2, want>=9)",
+		},
+		{
+			name: "truncated",
+			raw:  "MIMT\x01\x00\x09\x00\x00",
+			err:  "dif: could not read thresholds record: unexpected EOF",
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			err := NewDecoder(0, strings.NewReader(tc.raw)).Decode(new(DIF))
+			if err == nil {
+				t.Fatalf("expected an error")
+			}
+			if got, want := err.Error(), tc.err; got != want {
+				t.Fatalf("invalid error:\ngot= %v\nwant=%v", got, want)
+			}
+		})
+	}
+}
+
+func TestAutoDecoder(t *testing.T) {
+	buf := new(bytes.Buffer)
+	enc := NewEncoder(buf)
+	for _, id := range []uint8{0x42, 0x42, 0x43} {
+		err := enc.Encode(&DIF{Header: GlobalHeader{ID: id}})
+		if err != nil {
+			t.Fatalf("could not encode dif: %+v", err)
+		}
+	}
+	raw := buf.Bytes()
+
+	dec := NewAutoDecoder(bytes.NewReader(raw))
+	if got, want := dec.DIFID(), uint8(0); got != want {
+		t.Fatalf("invalid DIF ID before decoding: got=0x%x, want=0x%x", got, want)
+	}
+
+	var d DIF
+	for i := 0; i < 2; i++ {
+		err := dec.Decode(&d)
+		if err != nil {
+			t.Fatalf("could not decode dif %d: %+v", i, err)
+		}
+		if got, want := dec.DIFID(), uint8(0x42); got != want {
+			t.Fatalf("invalid discovered DIF ID: got=0x%x, want=0x%x", got, want)
+		}
+	}
+
+	err := dec.Decode(&d)
+	if err == nil {
+		t.Fatalf("expected an error on inconsistent DIF ID")
+	}
+	if got, want := err.Error(), "dif: invalid DIF ID (got=0x43, want=0x42)"; got != want {
+		t.Fatalf("invalid error:\ngot= %s\nwant=%s", got, want)
+	}
+
+	// a plain decoder with DIF ID 0 accepts any DIF ID.
+	dec = NewDecoder(0, bytes.NewReader(raw))
+	for i := 0; i < 3; i++ {
+		err := dec.Decode(&d)
+		if err != nil {
+			t.Fatalf("could not decode dif %d: %+v", i, err)
+		}
+	}
+	if got, want := dec.DIFID(), uint8(0); got != want {
+		t.Fatalf("invalid DIF ID: got=0x%x, want=0x%x", got, want)
+	}
+}
+
+func TestDecoderBlock(t *testing.T) {
+	dif := benchDIF(3)
+	blk := new(bytes.Buffer)
+	err := NewEncoder(blk).Encode(&dif)
+	if err != nil {
+		t.Fatalf("could not encode dif: %+v", err)
+	}
+	crc := binary.BigEndian.Uint16(blk.Bytes()[blk.Len()-2:])
+
+	buf := new(bytes.Buffer)
+	_, err = (&Settings{Board: 2, Run: 42}).WriteTo(buf)
+	if err != nil {
+		t.Fatalf("could not write settings: %+v", err)
+	}
+	buf.Write(blk.Bytes())
+	raw := buf.Bytes()
+
+	var (
+		d    DIF
+		want = Block{Size: blk.Len(), CompCRC: crc, RecvCRC: crc}
+	)
+	dec := NewDecoder(dif.Header.ID, bytes.NewReader(raw))
+	if got := dec.Block(); got != (Block{}) {
+		t.Fatalf("invalid block before decoding: %+v", got)
+	}
+	err = dec.Decode(&d)
+	if err != nil {
+		t.Fatalf("could not decode dif: %+v", err)
+	}
+	if got := dec.Block(); got != want {
+		t.Fatalf("invalid block:\ngot= %+v\nwant=%+v", got, want)
+	}
+
+	// the received CRC-16 is reported when inconsistent.
+	bad := append([]byte(nil), raw...)
+	bad[len(bad)-1] ^= 0xff
+	dec = NewDecoder(dif.Header.ID, bytes.NewReader(bad))
+	err = dec.Decode(&d)
+	if err == nil {
+		t.Fatalf("expected a CRC error")
+	}
+	if got, want := dec.Block(), (Block{Size: want.Size, CompCRC: crc, RecvCRC: crc ^ 0x00ff}); got != want {
+		t.Fatalf("invalid block:\ngot= %+v\nwant=%+v", got, want)
+	}
+
+	// truncated blocks are not accounted for.
+	err = dec.Decode(&d)
+	if !errors.Is(err, io.EOF) {
+		t.Fatalf("invalid error: %+v", err)
+	}
+	if got := dec.Block(); got != (Block{}) {
+		t.Fatalf("invalid block at EOF: %+v", got)
+	}
+
+	dec = NewDecoder(dif.Header.ID, bytes.NewReader(raw[:len(raw)-1]))
+	err = dec.Decode(&d)
+	if !errors.Is(err, io.ErrUnexpectedEOF) {
+		t.Fatalf("invalid error: %+v", err)
+	}
+	if got := dec.Block(); got != (Block{}) {
+		t.Fatalf("invalid block for truncated dif: %+v", got)
+	}
+}
+
+func BenchmarkDIFWriteTo(b *testing.B) {
+	dif := DIF{Frames: make([]Frame, 20000)}
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		_, _ = dif.WriteTo(ioutil.Discard)
+	}
+}
+
+func BenchmarkDIFReadFrom(b *testing.B) {
+	buf := new(bytes.Buffer)
+	_, _ = (&DIF{Frames: make([]Frame, 20000)}).WriteTo(buf)
+	raw := buf.Bytes()
+	r := bytes.NewReader(raw)
+
+	var dif DIF
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		r.Reset(raw)
+		_, err := dif.ReadFrom(r)
+		if err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+// benchDIF returns a DIF block with n frames spread over 8 hardrocs,
+// representative of what a RFM sends for one acquisition cycle.
+func benchDIF(n int) DIF {
+	rnd := rand.New(rand.NewSource(1234))
+	dif := DIF{
+		Header: GlobalHeader{
+			ID:        0x42,
+			DTC:       10,
+			ATC:       11,
+			GTC:       12,
+			AbsBCID:   0x0000112233445566,
+			TimeDIFTC: 0x00112233,
+		},
+		Frames: make([]Frame, n),
+	}
+	for i := range dif.Frames {
+		frame := &dif.Frames[i]
+		frame.Header = uint8(i%8) + 1
+		frame.BCID = uint32(rnd.Intn(1 << 24))
+		frame.FineTS = rnd.Uint32()
+		rnd.Read(frame.Data[:])
+	}
+	return dif
+}
+
+func BenchmarkEncode(b *testing.B) {
+	for _, ext := range []bool{false, true} {
+		for _, n := range []int{8, 128, 2048} {
+			dif := benchDIF(n)
+			b.Run(fmt.Sprintf("frames=%d/ext=%v", n, ext), func(b *testing.B) {
+				buf := new(bytes.Buffer)
+				enc := NewEncoder(buf)
+				enc.ExtFrames = ext
+				err := enc.Encode(&dif)
+				if err != nil {
+					b.Fatalf("could not encode dif: %+v", err)
+				}
+				b.SetBytes(int64(buf.Len()))
+				b.ReportAllocs()
+				b.ResetTimer()
+				for i := 0; i < b.N; i++ {
+					buf.Reset()
+					_ = enc.Encode(&dif)
+				}
+			})
+		}
+	}
+}
+
+func BenchmarkDecode(b *testing.B) {
+	for _, ext := range []bool{false, true} {
+		for _, n := range []int{8, 128, 2048} {
+			want := benchDIF(n)
+			b.Run(fmt.Sprintf("frames=%d/ext=%v", n, ext), func(b *testing.B) {
+				buf := new(bytes.Buffer)
+				enc := NewEncoder(buf)
+				enc.ExtFrames = ext
+				err := enc.Encode(&want)
+				if err != nil {
+					b.Fatalf("could not encode dif: %+v", err)
+				}
+				raw := buf.Bytes()
+				r := bytes.NewReader(raw)
+				dec := NewDecoder(want.Header.ID, r)
+				dec.ExtFrames = ext
+
+				var dif DIF
+				b.SetBytes(int64(len(raw)))
+				b.ReportAllocs()
+				b.ResetTimer()
+				for i := 0; i < b.N; i++ {
+					r.Reset(raw)
+					err := dec.Decode(&dif)
+					if err != nil {
+						b.Fatalf("could not decode dif: %+v", err)
+					}
+				}
+			})
+		}
+	}
+}
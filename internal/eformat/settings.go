@@ -0,0 +1,134 @@
+// Copyright 2020 The go-lpc Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package eformat
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+)
+
+const (
+	setMagic   = "MIMS"       // settings record magic
+	setVersion = 2            // current settings record format version
+	setLen     = setLenV1 + 4 // settings record payload size
+	setLenV1   = 5*4 + 8      // settings record payload size (version 1)
+)
+
+// Settings describes the acquisition settings of a run.
+//
+// A settings record can be prepended to a raw DIF stream to make it
+// self-describing. The Decoder skips settings records found in the
+// stream and exposes the last one via Decoder.Settings.
+type Settings struct {
+	Version uint8  // format version of the settings record
+	Board   uint32 // EDA board ID
+	Run     uint32 // run number
+	RFM     uint32 // RFM ON mask
+	RShaper uint32 // resistance shaper
+	Delta   uint32 // threshold delta
+	HRHash  uint64 // hash of the hardroc slow-control configuration
+
+	Firmware uint32 // FPGA firmware version word (version>=2)
+}
+
+// WriteTo writes the settings record to w, with the current format
+// version.
+// WriteTo implements io.WriterTo.
+func (set *Settings) WriteTo(w io.Writer) (int64, error) {
+	var buf [len(setMagic) + 1 + 2 + setLen]byte
+	copy(buf[:], setMagic)
+	buf[4] = setVersion
+	binary.BigEndian.PutUint16(buf[5:], setLen)
+	p := buf[7:]
+	binary.BigEndian.PutUint32(p[0:], set.Board)
+	binary.BigEndian.PutUint32(p[4:], set.Run)
+	binary.BigEndian.PutUint32(p[8:], set.RFM)
+	binary.BigEndian.PutUint32(p[12:], set.RShaper)
+	binary.BigEndian.PutUint32(p[16:], set.Delta)
+	binary.BigEndian.PutUint64(p[20:], set.HRHash)
+	binary.BigEndian.PutUint32(p[28:], set.Firmware)
+
+	n, err := w.Write(buf[:])
+	if err != nil {
+		return int64(n), fmt.Errorf("dif: could not write settings record: %w", err)
+	}
+	return int64(n), nil
+}
+
+// decodeRecord decodes the rest of a settings or thresholds record or of
+// a provenance trailer, once their first (common) magic byte has been
+// consumed.
+func (dec *Decoder) decodeRecord() error {
+	var buf [len(setMagic) - 1 + 1 + 2]byte
+	dec.read(buf[:])
+	if dec.err != nil {
+		if errors.Is(dec.err, io.EOF) {
+			dec.err = io.ErrUnexpectedEOF
+		}
+		return fmt.Errorf("dif: could not read record header: %w", dec.err)
+	}
+	var (
+		vers = buf[3]
+		size = int(binary.BigEndian.Uint16(buf[4:]))
+	)
+	switch string(buf[:3]) {
+	case setMagic[1:]:
+		return dec.decodeSettings(vers, size)
+	case provMagic[1:]:
+		return dec.decodeProvenance(vers, size)
+	case thrMagic[1:]:
+		return dec.decodeThresholds(vers, size)
+	default:
+		return fmt.Errorf("dif: invalid record magic (got=%q)", setMagic[:1]+string(buf[:3]))
+	}
+}
+
+// decodeSettings decodes the payload of a settings record.
+// Payload bytes of newer format versions are skipped.
+func (dec *Decoder) decodeSettings(vers uint8, size int) error {
+	if size < setLenV1 {
+		return fmt.Errorf("dif: invalid settings record size (got=%d, want>=%d)", size, setLenV1)
+	}
+
+	var (
+		p [setLen]byte
+		n = setLenV1
+	)
+	if vers >= 2 && size >= setLen {
+		n = setLen
+	}
+	dec.read(p[:n])
+	if dec.err == nil && size > n {
+		_, dec.err = io.CopyN(ioutil.Discard, dec.r, int64(size-n))
+	}
+	if dec.err != nil {
+		if errors.Is(dec.err, io.EOF) {
+			dec.err = io.ErrUnexpectedEOF
+		}
+		return fmt.Errorf("dif: could not read settings record: %w", dec.err)
+	}
+
+	dec.set = Settings{
+		Version: vers,
+		Board:   binary.BigEndian.Uint32(p[0:]),
+		Run:     binary.BigEndian.Uint32(p[4:]),
+		RFM:     binary.BigEndian.Uint32(p[8:]),
+		RShaper: binary.BigEndian.Uint32(p[12:]),
+		Delta:   binary.BigEndian.Uint32(p[16:]),
+		HRHash:  binary.BigEndian.Uint64(p[20:]),
+
+		Firmware: binary.BigEndian.Uint32(p[28:]),
+	}
+	dec.hasSet = true
+	return nil
+}
+
+// Settings returns the last settings record read from the stream, if any.
+func (dec *Decoder) Settings() (Settings, bool) {
+	return dec.set, dec.hasSet
+}
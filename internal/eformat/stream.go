@@ -0,0 +1,57 @@
+// Copyright 2020 The go-lpc Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package eformat
+
+import (
+	"errors"
+	"io"
+)
+
+// ErrTimeout is returned by a streaming Decoder when its input stream
+// timed out (e.g. a read deadline of a net.Conn expired) before a complete
+// DIF block could be read.
+// The Decoder state is preserved and decoding can be resumed.
+var ErrTimeout = errors.New("dif: read timeout")
+
+// journal records the bytes read from a stream since the beginning of the
+// current DIF block, so that block can be decoded again from the start
+// after a read timeout.
+type journal struct {
+	r   io.Reader
+	buf []byte // bytes of the current DIF block read so far
+	pos int    // replay position in buf
+}
+
+func (j *journal) Read(p []byte) (int, error) {
+	if j.pos < len(j.buf) {
+		n := copy(p, j.buf[j.pos:])
+		j.pos += n
+		return n, nil
+	}
+
+	n, err := j.r.Read(p)
+	j.buf = append(j.buf, p[:n]...)
+	j.pos += n
+	if err != nil && isTimeout(err) {
+		err = ErrTimeout
+	}
+	return n, err
+}
+
+// rewind replays the recorded bytes on the next reads.
+func (j *journal) rewind() {
+	j.pos = 0
+}
+
+// commit discards the recorded bytes, once a DIF block has been decoded.
+func (j *journal) commit() {
+	j.buf = j.buf[:0]
+	j.pos = 0
+}
+
+func isTimeout(err error) bool {
+	var terr interface{ Timeout() bool }
+	return errors.As(err, &terr) && terr.Timeout()
+}
@@ -0,0 +1,80 @@
+// Copyright 2020 The go-lpc Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package eformat
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"net"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestStreamDecoder(t *testing.T) {
+	dif := benchDIF(3)
+	for i := range dif.Frames {
+		dif.Frames[i].FineTS = 0 // not part of standard frames.
+	}
+	buf := new(bytes.Buffer)
+	enc := NewEncoder(buf)
+	for i := 0; i < 2; i++ {
+		err := enc.Encode(&dif)
+		if err != nil {
+			t.Fatalf("could not encode dif: %+v", err)
+		}
+	}
+	var (
+		raw  = buf.Bytes()
+		size = len(raw) / 2
+	)
+
+	src, dst := net.Pipe()
+	defer dst.Close()
+
+	next := make(chan int)
+	go func() {
+		defer src.Close()
+		for _, p := range [][]byte{raw[:size/2], raw[size/2 : size+10], raw[size+10:]} {
+			_, err := src.Write(p)
+			if err != nil {
+				return
+			}
+			<-next
+		}
+	}()
+
+	var (
+		dec = NewStreamDecoder(dif.Header.ID, dst)
+		got DIF
+	)
+	for i := 0; i < 2; i++ {
+		_ = dst.SetReadDeadline(time.Now().Add(50 * time.Millisecond))
+		err := dec.Decode(&got)
+		if !errors.Is(err, ErrTimeout) {
+			t.Fatalf("dif %d: expected a timeout error, got: %+v", i, err)
+		}
+		next <- 1
+
+		_ = dst.SetReadDeadline(time.Time{})
+		err = dec.Decode(&got)
+		if err != nil {
+			t.Fatalf("could not decode dif %d after timeout: %+v", i, err)
+		}
+		if !reflect.DeepEqual(got, dif) {
+			t.Fatalf("invalid dif %d:\ngot= %+v\nwant=%+v", i, got, dif)
+		}
+		if got, want := dec.Block().Size, size; got != want {
+			t.Fatalf("invalid block size: got=%d, want=%d", got, want)
+		}
+	}
+	close(next)
+
+	err := dec.Decode(&got)
+	if !errors.Is(err, io.EOF) {
+		t.Fatalf("expected EOF, got: %+v", err)
+	}
+}
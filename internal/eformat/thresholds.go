@@ -0,0 +1,90 @@
+// Copyright 2020 The go-lpc Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package eformat
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+)
+
+const (
+	thrMagic   = "MIMT"  // thresholds record magic
+	thrVersion = 1       // current thresholds record format version
+	thrLen     = 1 + 2*4 // thresholds record payload size
+)
+
+// Thresholds describes the discriminators of the hardrocs of an RFM for a
+// readout cycle.
+//
+// A thresholds record can be prepended to a DIF block to carry the hit
+// counters of both discriminators, without changing the layout of the DIF
+// block itself.
+//
+// The Decoder skips thresholds records found in the stream and exposes the
+// one preceding the last decoded DIF block via Decoder.Thresholds.
+type Thresholds struct {
+	Version uint8  // format version of the thresholds record
+	Trig    uint8  // discriminator (0 or 1) whose hits triggered the readout
+	Hit0    uint32 // hit counter of the threshold-0 discriminator
+	Hit1    uint32 // hit counter of the threshold-1 discriminator
+}
+
+// WriteTo writes the thresholds record to w, with the current format
+// version.
+// WriteTo implements io.WriterTo.
+func (thr *Thresholds) WriteTo(w io.Writer) (int64, error) {
+	var buf [len(thrMagic) + 1 + 2 + thrLen]byte
+	copy(buf[:], thrMagic)
+	buf[4] = thrVersion
+	binary.BigEndian.PutUint16(buf[5:], thrLen)
+	p := buf[7:]
+	p[0] = thr.Trig
+	binary.BigEndian.PutUint32(p[1:], thr.Hit0)
+	binary.BigEndian.PutUint32(p[5:], thr.Hit1)
+
+	n, err := w.Write(buf[:])
+	if err != nil {
+		return int64(n), fmt.Errorf("dif: could not write thresholds record: %w", err)
+	}
+	return int64(n), nil
+}
+
+// decodeThresholds decodes the payload of a thresholds record.
+// Payload bytes of newer format versions are skipped.
+func (dec *Decoder) decodeThresholds(vers uint8, size int) error {
+	if size < thrLen {
+		return fmt.Errorf("dif: invalid thresholds record size (got=%d, want>=%d)", size, thrLen)
+	}
+
+	var p [thrLen]byte
+	dec.read(p[:])
+	if dec.err == nil && size > thrLen {
+		_, dec.err = io.CopyN(ioutil.Discard, dec.r, int64(size-thrLen))
+	}
+	if dec.err != nil {
+		if errors.Is(dec.err, io.EOF) {
+			dec.err = io.ErrUnexpectedEOF
+		}
+		return fmt.Errorf("dif: could not read thresholds record: %w", dec.err)
+	}
+
+	dec.thr = Thresholds{
+		Version: vers,
+		Trig:    p[0],
+		Hit0:    binary.BigEndian.Uint32(p[1:]),
+		Hit1:    binary.BigEndian.Uint32(p[5:]),
+	}
+	dec.hasThr = true
+	return nil
+}
+
+// Thresholds returns the thresholds record preceding the last DIF block
+// read from the stream, if any.
+func (dec *Decoder) Thresholds() (Thresholds, bool) {
+	return dec.thr, dec.hasThr
+}
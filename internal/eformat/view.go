@@ -0,0 +1,218 @@
+// Copyright 2020 The go-lpc Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package eformat
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+
+	"github.com/go-lpc/mim/internal/crc16"
+)
+
+// DIFView is a DIF block decoded from a byte slice by Decoder.DecodeBytes.
+//
+// The data of its frames aliases the decoded byte slice: it is only valid
+// as long as that slice is neither modified nor reused, e.g. while the
+// memory-mapped file it points to is still mapped.
+type DIFView struct {
+	Header GlobalHeader
+	Frames []FrameView
+}
+
+// FrameView is a hardroc frame of a DIFView.
+type FrameView struct {
+	Header uint8 // Hardroc header
+	BCID   uint32
+	Data   []byte // 16 bytes, aliasing the decoded byte slice
+	FineTS uint32 // fine timestamp (HR3 extended frames only)
+}
+
+// Frame returns a copy of the frame, that does not alias the decoded
+// byte slice.
+func (f FrameView) Frame() Frame {
+	frame := Frame{
+		Header: f.Header,
+		BCID:   f.BCID,
+		FineTS: f.FineTS,
+	}
+	copy(frame.Data[:], f.Data)
+	return frame
+}
+
+// CopyTo copies the DIF block to dst, that does not alias the decoded
+// byte slice.
+// The memory backing the frames of dst is reused.
+func (dif *DIFView) CopyTo(dst *DIF) {
+	dst.Header = dif.Header
+	dst.Frames = dst.Frames[:0]
+	for _, f := range dif.Frames {
+		dst.Frames = append(dst.Frames, f.Frame())
+	}
+}
+
+// DecodeBytes decodes the next DIF block from buf into dif, and returns
+// the rest of buf.
+//
+// Contrary to Decode, DecodeBytes does not copy the data of frames: the
+// frames of dif alias buf (see DIFView).
+// The Decoder settings (DIF ID, IsEDA, ExtFrames) apply as for Decode.
+// Settings and thresholds records and provenance trailers found in buf
+// are skipped and exposed via Decoder.Settings, Decoder.Thresholds and
+// Decoder.Provenance.
+//
+// DecodeBytes returns an error wrapping io.EOF when buf is empty, and one
+// wrapping io.ErrUnexpectedEOF when buf ends in the middle of a DIF block.
+// The Decoder input stream is left untouched.
+func (dec *Decoder) DecodeBytes(buf []byte, dif *DIFView) ([]byte, error) {
+	dec.blk = Block{}
+	dec.hasThr = false
+
+	var err error
+	for len(buf) > 0 && buf[0] == setMagic[0] { // settings or thresholds record, or provenance trailer
+		buf, err = dec.decodeRecordBytes(buf)
+		if err != nil {
+			return buf, err
+		}
+	}
+	if len(buf) == 0 {
+		return buf, fmt.Errorf("dif: could not read global header marker: %w", io.EOF)
+	}
+
+	var (
+		v = buf[0]
+		n int // global header size
+	)
+	switch v {
+	case gbHeader:
+		n = 23
+	case gbHeaderB:
+		n = 32
+	default:
+		return buf, fmt.Errorf("dif: could not read global header marker (got=0x%x)", v)
+	}
+	if len(buf) < 1+n {
+		return buf, fmt.Errorf("dif: could not read DIF header: %w", io.ErrUnexpectedEOF)
+	}
+	hdr := buf[1 : 1+n]
+
+	difID := hdr[0]
+	if dec.dif != 0 && difID != dec.dif {
+		return buf, fmt.Errorf("dif: invalid DIF ID (got=0x%x, want=0x%x)", difID, dec.dif)
+	}
+	if dec.auto && dec.dif == 0 {
+		dec.dif = difID
+	}
+
+	decodeHeader(&dif.Header, v, hdr)
+	dif.Frames = dif.Frames[:0]
+
+	i := 1 + n
+	for {
+		if i >= len(buf) {
+			return buf, fmt.Errorf(
+				"dif: DIF 0x%x could not read frame header/global trailer: %w",
+				dec.dif, io.ErrUnexpectedEOF,
+			)
+		}
+		v := buf[i]
+		i++
+
+		switch v {
+		default:
+			return buf, fmt.Errorf("dif: DIF 0x%x invalid frame/global marker (got=0x%x)", dec.dif, v)
+
+		case anHeader:
+			// analog frame header. not supported.
+			return buf, fmt.Errorf("dif: DIF 0x%x contains an analog frame", dec.dif)
+
+		case frHeader, frHeaderX:
+			size := frameLen - 1
+			if v == frHeaderX {
+				if !dec.ExtFrames {
+					return buf, fmt.Errorf("dif: DIF 0x%x invalid frame/global marker (got=0x%x)", dec.dif, v)
+				}
+				size = frameExtLen - 1
+			}
+		frameLoop:
+			for {
+				if i >= len(buf) {
+					return buf, fmt.Errorf(
+						"dif: DIF 0x%x could not read frame trailer/hardroc header: %w",
+						dec.dif, io.ErrUnexpectedEOF,
+					)
+				}
+				v := buf[i]
+				i++
+
+				switch v {
+				default: // not a frame trailer, so a hardroc header
+					if len(buf)-i < size {
+						return buf, fmt.Errorf(
+							"dif: DIF 0x%x could not read hardroc frame: %w",
+							dec.dif, io.ErrUnexpectedEOF,
+						)
+					}
+					p := buf[i : i+size]
+					frame := FrameView{
+						Header: v,
+						BCID:   u32FromU24(p[:3]),
+						Data:   p[3 : 3+16 : 3+16],
+					}
+					if size == frameExtLen-1 {
+						frame.FineTS = binary.BigEndian.Uint32(p[19:])
+					}
+					dif.Frames = append(dif.Frames, frame)
+					i += size
+
+				case incFrame:
+					return buf, fmt.Errorf("dif: DIF 0x%x received an incomplete frame", dec.dif)
+
+				case frTrailer:
+					break frameLoop
+				}
+			}
+
+		case gbTrailer:
+			if len(buf)-i < 2 {
+				return buf, fmt.Errorf(
+					"dif: DIF 0x%x could not receive CRC-16: %w",
+					dec.dif, io.ErrUnexpectedEOF,
+				)
+			}
+			var (
+				compCRC = crc16.Update(crc16.Init, nil, buf[:i])
+				recvCRC = binary.BigEndian.Uint16(buf[i:])
+			)
+			dec.blk = Block{Size: i + 2, CompCRC: compCRC, RecvCRC: recvCRC}
+			if compCRC != recvCRC {
+				if !(dec.IsEDA && recvCRC == 0xc0c0) /*hack for EDA*/ {
+					return buf, fmt.Errorf(
+						"dif: DIF 0x%x inconsistent CRC: recv=0x%04x comp=0x%04x",
+						dec.dif, recvCRC, compCRC,
+					)
+				}
+			}
+			return buf[i+2:], nil
+		}
+	}
+}
+
+// decodeRecordBytes decodes the settings record or provenance trailer at
+// the start of buf, and returns the rest of buf.
+func (dec *Decoder) decodeRecordBytes(buf []byte) ([]byte, error) {
+	var (
+		r    = bytes.NewReader(buf[1:])
+		orig = dec.r
+	)
+	dec.r, dec.err = r, nil
+	err := dec.decodeRecord()
+	dec.r, dec.err = orig, nil
+	if err != nil {
+		return buf, err
+	}
+	return buf[len(buf)-r.Len():], nil
+}
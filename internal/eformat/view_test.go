@@ -0,0 +1,198 @@
+// Copyright 2020 The go-lpc Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package eformat
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"reflect"
+	"testing"
+)
+
+func TestDecodeBytes(t *testing.T) {
+	for _, ext := range []bool{false, true} {
+		t.Run(fmt.Sprintf("ext=%v", ext), func(t *testing.T) {
+			difs := []DIF{benchDIF(3), benchDIF(0), benchDIF(5)}
+			difs[1].Header.DTC = 2
+			difs[1].Header.Temp = Temperature{Valid: true, ASU1: 1, ASU2: 2, DIF: 3}
+
+			buf := new(bytes.Buffer)
+			set := Settings{Board: 2, Run: 42}
+			_, err := set.WriteTo(buf)
+			if err != nil {
+				t.Fatalf("could not write settings: %+v", err)
+			}
+			enc := NewEncoder(buf)
+			enc.ExtFrames = ext
+			for i := range difs {
+				err = enc.Encode(&difs[i])
+				if err != nil {
+					t.Fatalf("could not encode dif %d: %+v", i, err)
+				}
+				if i == 0 {
+					prov := Provenance{Board: 2, Slot: 1}
+					_, err = prov.WriteTo(buf)
+					if err != nil {
+						t.Fatalf("could not write provenance: %+v", err)
+					}
+				}
+			}
+			raw := buf.Bytes()
+
+			dec := NewDecoder(difs[0].Header.ID, nil)
+			dec.ExtFrames = ext
+
+			// reference decoder.
+			ref := NewDecoder(difs[0].Header.ID, bytes.NewReader(raw))
+			ref.ExtFrames = ext
+
+			var (
+				rest = raw
+				view DIFView
+			)
+			for i := range difs {
+				var want DIF
+				err = ref.Decode(&want)
+				if err != nil {
+					t.Fatalf("could not decode reference dif %d: %+v", i, err)
+				}
+
+				rest, err = dec.DecodeBytes(rest, &view)
+				if err != nil {
+					t.Fatalf("could not decode dif %d: %+v", i, err)
+				}
+				if got, want := dec.Block(), ref.Block(); got != want {
+					t.Fatalf("dif %d: invalid block:\ngot= %+v\nwant=%+v", i, got, want)
+				}
+				var got DIF
+				view.CopyTo(&got)
+				if !reflect.DeepEqual(got, want) {
+					t.Fatalf("dif %d: invalid decoded value:\ngot= %+v\nwant=%+v", i, got, want)
+				}
+			}
+			_, err = dec.DecodeBytes(rest, &view)
+			if !errors.Is(err, io.EOF) {
+				t.Fatalf("invalid error at end of buffer: %+v", err)
+			}
+
+			if got, ok := dec.Settings(); !ok || got.Run != 42 {
+				t.Fatalf("invalid settings: %+v (ok=%v)", got, ok)
+			}
+			if got, ok := dec.Provenance(); !ok || got.Slot != 1 {
+				t.Fatalf("invalid provenance: %+v (ok=%v)", got, ok)
+			}
+
+			// frames alias the decoded buffer.
+			_, err = dec.DecodeBytes(raw, &view)
+			if err != nil {
+				t.Fatalf("could not decode dif: %+v", err)
+			}
+			view.Frames[0].Data[0] ^= 0xff
+			if got, want := view.Frames[0].Data[0], difs[0].Frames[0].Data[0]^0xff; got != want {
+				t.Fatalf("frame data does not alias buffer")
+			}
+			_, err = dec.DecodeBytes(raw, &view)
+			if err == nil {
+				t.Fatalf("expected a CRC error")
+			}
+			if blk := dec.Block(); blk.CompCRC == blk.RecvCRC {
+				t.Fatalf("invalid block: %+v", blk)
+			}
+		})
+	}
+}
+
+func TestDecodeBytesErrors(t *testing.T) {
+	dif := benchDIF(2)
+	buf := new(bytes.Buffer)
+	err := NewEncoder(buf).Encode(&dif)
+	if err != nil {
+		t.Fatalf("could not encode dif: %+v", err)
+	}
+	raw := buf.Bytes()
+
+	// all truncations of a DIF block are detected.
+	for n := 1; n < len(raw); n++ {
+		var view DIFView
+		_, err := NewDecoder(dif.Header.ID, nil).DecodeBytes(raw[:n], &view)
+		if !errors.Is(err, io.ErrUnexpectedEOF) {
+			t.Fatalf("n=%d: invalid error: %+v", n, err)
+		}
+	}
+
+	for _, tc := range []struct {
+		name string
+		dif  uint8
+		buf  []byte
+		err  string
+	}{
+		{
+			name: "invalid-marker",
+			buf:  []byte{0xff},
+			err:  "dif: could not read global header marker (got=0xff)",
+		},
+		{
+			name: "invalid-dif",
+			dif:  0x43,
+			buf:  raw,
+			err:  "dif: invalid DIF ID (got=0x42, want=0x43)",
+		},
+		{
+			name: "invalid-record",
+			buf:  []byte("MIMX\x01\x00\x00"),
+			err:  `dif: invalid record magic (got="MIMX")`,
+		},
+		{
+			name: "ext-frames",
+			dif:  0x42,
+			buf:  append(append([]byte{gbHeader}, raw[1:24]...), frHeaderX),
+			err:  "dif: DIF 0x42 invalid frame/global marker (got=0xb5)",
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			var view DIFView
+			_, err := NewDecoder(tc.dif, nil).DecodeBytes(tc.buf, &view)
+			if err == nil {
+				t.Fatalf("expected an error")
+			}
+			if got, want := err.Error(), tc.err; got != want {
+				t.Fatalf("invalid error:\ngot= %v\nwant=%v", got, want)
+			}
+		})
+	}
+}
+
+func BenchmarkDecodeBytes(b *testing.B) {
+	for _, ext := range []bool{false, true} {
+		for _, n := range []int{8, 128, 2048} {
+			want := benchDIF(n)
+			b.Run(fmt.Sprintf("frames=%d/ext=%v", n, ext), func(b *testing.B) {
+				buf := new(bytes.Buffer)
+				enc := NewEncoder(buf)
+				enc.ExtFrames = ext
+				err := enc.Encode(&want)
+				if err != nil {
+					b.Fatalf("could not encode dif: %+v", err)
+				}
+				raw := buf.Bytes()
+				dec := NewDecoder(want.Header.ID, nil)
+				dec.ExtFrames = ext
+
+				var dif DIFView
+				b.SetBytes(int64(len(raw)))
+				b.ReportAllocs()
+				b.ResetTimer()
+				for i := 0; i < b.N; i++ {
+					_, err := dec.DecodeBytes(raw, &dif)
+					if err != nil {
+						b.Fatalf("could not decode dif: %+v", err)
+					}
+				}
+			})
+		}
+	}
+}
@@ -6,6 +6,7 @@ package xcnv
 
 import (
 	"bytes"
+	"context"
 	"encoding/binary"
 	"errors"
 	"fmt"
@@ -16,11 +17,34 @@ import (
 
 	"github.com/go-lpc/mim/internal/eformat"
 	"go-hep.org/x/hep/lcio"
+	"golang.org/x/sync/errgroup"
+	"golang.org/x/sync/semaphore"
 )
 
-func EDA2LCIO(w *lcio.Writer, dec *eformat.Decoder, run int32, msg *log.Logger) error {
+const (
+	cnvQueueSize = 64 // maximum number of blocks (events) queued between conversion stages
+)
+
+// EDA2LCIO converts the EDA data read from dec into LCIO events written to w.
+// Blocks rejected by the conversion filter are skipped. Events keep the index
+// of their block in the input stream as event number.
+//
+// Besides the raw DIF block (in the RU_XDAQ collection), the counters of
+// the DIF block are stored as event parameters (see EventParams), so they
+// can be used without decoding the raw payload.
+//
+// With WithMaxMem, blocks are decoded, converted and written by
+// concurrent stages.
+func EDA2LCIO(w *lcio.Writer, dec *eformat.Decoder, run int32, msg *log.Logger, opts ...Option) error {
+	cfg := newConfig(opts)
+	if cfg.maxMem > 0 {
+		return eda2lcioConcurrent(w, dec, run, msg, cfg)
+	}
+
 	var (
 		buf = new(bytes.Buffer)
+		hdr = false // whether the run header has been written
+		n   = 0     // number of skipped blocks
 		raw = &lcio.GenericObject{
 			Data: []lcio.GenericObjectData{
 				{I32s: nil},
@@ -28,12 +52,12 @@ func EDA2LCIO(w *lcio.Writer, dec *eformat.Decoder, run int32, msg *log.Logger)
 		}
 	)
 
+	var d eformat.DIF // reused across events, to recycle frames.
 loop:
 	for i := 0; ; i++ {
 		if i%100 == 0 {
 			msg.Printf("processing evt %d...", i)
 		}
-		var d eformat.DIF
 		err := dec.Decode(&d)
 		if err != nil {
 			if errors.Is(err, io.EOF) {
@@ -42,29 +66,20 @@ loop:
 			return fmt.Errorf("could not decode EDA: %w", err)
 		}
 
-		if i == 0 {
-			err = w.WriteRunHeader(&lcio.RunHeader{
-				RunNumber: run,
-				Detector:  "SD-HCAL",
-				Descr:     "",
-				Params: lcio.Params{
-					Ints: map[string][]int32{
-						"Clock":   {200},
-						"Trigger": {0},
-					},
-				},
-			})
+		if !cfg.filter(&d) {
+			n++
+			continue
+		}
+
+		if !hdr {
+			hdr = true
+			err = writeRunHeader(w, run)
 			if err != nil {
-				return fmt.Errorf("could not write run header: %w", err)
+				return err
 			}
 		}
 
-		evt := lcio.Event{
-			RunNumber:   run,
-			EventNumber: int32(i),
-			TimeStamp:   int64(d.Header.AbsBCID),
-			Detector:    "SD-HCAL",
-		}
+		evt := newEvent(run, i, &d)
 		raw.Data[0].I32s = i32sFrom(buf, &d)
 		evt.Add("RU_XDAQ", raw)
 
@@ -74,15 +89,192 @@ loop:
 		}
 	}
 
+	if n > 0 {
+		msg.Printf("skipped %d filtered out blocks", n)
+	}
+
 	return nil
 }
 
+// eda2lcioConcurrent converts EDA data to LCIO with a decoding, a
+// conversion and a writing goroutines.
+// The decoding stage blocks while the events in flight use more than
+// cfg.maxMem bytes, so the writing stage sets the pace.
+func eda2lcioConcurrent(w *lcio.Writer, dec *eformat.Decoder, run int32, msg *log.Logger, cfg config) error {
+	type block struct {
+		i    int
+		d    *eformat.DIF
+		size int64
+	}
+	type event struct {
+		evt  lcio.Event
+		size int64
+	}
+
+	var (
+		mem      = semaphore.NewWeighted(cfg.maxMem)
+		grp, ctx = errgroup.WithContext(context.Background())
+		blks     = make(chan block, cnvQueueSize)
+		evts     = make(chan event, cnvQueueSize)
+		n        = 0 // number of skipped blocks
+	)
+
+	grp.Go(func() error {
+		defer close(blks)
+		for i := 0; ; i++ {
+			if i%100 == 0 {
+				msg.Printf("processing evt %d...", i)
+			}
+			d := new(eformat.DIF)
+			err := dec.Decode(d)
+			if err != nil {
+				if errors.Is(err, io.EOF) {
+					return nil
+				}
+				return fmt.Errorf("could not decode EDA: %w", err)
+			}
+
+			if !cfg.filter(d) {
+				n++
+				continue
+			}
+
+			size := evtSize(d)
+			if size > cfg.maxMem {
+				size = cfg.maxMem
+			}
+			err = mem.Acquire(ctx, size)
+			if err != nil {
+				return err
+			}
+			select {
+			case blks <- block{i: i, d: d, size: size}:
+			case <-ctx.Done():
+				mem.Release(size)
+				return ctx.Err()
+			}
+		}
+	})
+
+	grp.Go(func() error {
+		defer close(evts)
+		for blk := range blks {
+			evt := newEvent(run, blk.i, blk.d)
+			evt.Add("RU_XDAQ", &lcio.GenericObject{
+				Data: []lcio.GenericObjectData{
+					{I32s: i32sFrom(new(bytes.Buffer), blk.d)},
+				},
+			})
+			select {
+			case evts <- event{evt: evt, size: blk.size}:
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+		}
+		return nil
+	})
+
+	grp.Go(func() error {
+		hdr := false // whether the run header has been written
+		for evt := range evts {
+			if !hdr {
+				hdr = true
+				err := writeRunHeader(w, run)
+				if err != nil {
+					return err
+				}
+			}
+			err := w.WriteEvent(&evt.evt)
+			if err != nil {
+				return fmt.Errorf("could not write EDA event: %w", err)
+			}
+			mem.Release(evt.size)
+		}
+		return nil
+	})
+
+	err := grp.Wait()
+	if err != nil {
+		return err
+	}
+
+	if n > 0 {
+		msg.Printf("skipped %d filtered out blocks", n)
+	}
+
+	return nil
+}
+
+// evtSize returns an estimate of the memory used by the conversion of a
+// DIF block: the decoded block and its raw LCIO payload.
+func evtSize(d *eformat.DIF) int64 {
+	const frameSize = int64(unsafe.Sizeof(eformat.Frame{}))
+	return int64(unsafe.Sizeof(*d)) + 2*frameSize*int64(len(d.Frames))
+}
+
+func writeRunHeader(w *lcio.Writer, run int32) error {
+	err := w.WriteRunHeader(&lcio.RunHeader{
+		RunNumber: run,
+		Detector:  "SD-HCAL",
+		Descr:     "",
+		Params: lcio.Params{
+			Ints: map[string][]int32{
+				"Clock":   {200},
+				"Trigger": {0},
+			},
+		},
+	})
+	if err != nil {
+		return fmt.Errorf("could not write run header: %w", err)
+	}
+	return nil
+}
+
+// newEvent returns the LCIO event of the i-th DIF block d, without its
+// raw payload.
+func newEvent(run int32, i int, d *eformat.DIF) lcio.Event {
+	evt := lcio.Event{
+		RunNumber:   run,
+		EventNumber: int32(i),
+		TimeStamp:   int64(d.Header.AbsBCID),
+		Detector:    "SD-HCAL",
+	}
+	fillParams(&evt.Params, d)
+	return evt
+}
+
+// EventParams lists the names of the integer event parameters filled
+// from the DIF block of an event:
+//   - DIF_ID: the DIF ID,
+//   - DTC, ATC, GTC: the DIF, acquisition and global trigger counters,
+//   - AbsBCID: the absolute BCID, as its low and high 32 bits,
+//   - TimeDIFTC: the time DIF trigger counter,
+//   - NFrames: the number of frames of the DIF block.
+//
+// Counters are stored as int32 values with the bits of their uint32 value.
+var EventParams = []string{
+	"DIF_ID", "DTC", "ATC", "GTC", "AbsBCID", "TimeDIFTC", "NFrames",
+}
+
+func fillParams(p *lcio.Params, d *eformat.DIF) {
+	hdr := &d.Header
+	p.Ints = map[string][]int32{
+		"DIF_ID":    {int32(hdr.ID)},
+		"DTC":       {int32(hdr.DTC)},
+		"ATC":       {int32(hdr.ATC)},
+		"GTC":       {int32(hdr.GTC)},
+		"AbsBCID":   {int32(uint32(hdr.AbsBCID)), int32(uint32(hdr.AbsBCID >> 32))},
+		"TimeDIFTC": {int32(hdr.TimeDIFTC)},
+		"NFrames":   {int32(len(d.Frames))},
+	}
+}
+
 func i32sFrom(w *bytes.Buffer, d *eformat.DIF) []int32 {
 	const i32sz = 4
 
 	w.Reset()
 	_, _ = w.Write(make([]byte, 6*i32sz))
-	err := eformat.NewEncoder(w).Encode(d)
+	_, err := d.WriteTo(w)
 	if err != nil {
 		panic(err)
 	}
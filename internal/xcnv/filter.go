@@ -0,0 +1,113 @@
+// Copyright 2020 The go-lpc Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package xcnv
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/go-lpc/mim/internal/eformat"
+)
+
+// ParseFilter parses a filter expression into a DIF predicate.
+//
+// A filter expression is a list of comparisons joined with "&&" and "||"
+// ("&&" binds tighter than "||"), e.g.:
+//
+//	frames>0 && difid==0xb7
+//	difid==1 || difid==2
+//
+// Comparisons are of the form "VAR OP VALUE", where OP is one of
+// ==, !=, <, <=, > or >=, VALUE is an unsigned integer (possibly in
+// hexadecimal or octal notation) and VAR is one of:
+//   - frames: the number of hardroc frames,
+//   - difid:  the DIF ID,
+//   - dtc:    the DIF trigger counter,
+//   - atc:    the acquisition trigger counter,
+//   - gtc:    the global trigger counter,
+//   - bcid:   the absolute BCID.
+func ParseFilter(expr string) (func(d *eformat.DIF) bool, error) {
+	if strings.TrimSpace(expr) == "" {
+		return nil, fmt.Errorf("xcnv: empty filter expression")
+	}
+
+	var ors []func(d *eformat.DIF) bool
+	for _, txt := range strings.Split(expr, "||") {
+		var ands []func(d *eformat.DIF) bool
+		for _, cmp := range strings.Split(txt, "&&") {
+			f, err := parseCmp(cmp)
+			if err != nil {
+				return nil, fmt.Errorf("xcnv: invalid filter expression %q: %w", expr, err)
+			}
+			ands = append(ands, f)
+		}
+		ors = append(ors, func(d *eformat.DIF) bool {
+			for _, f := range ands {
+				if !f(d) {
+					return false
+				}
+			}
+			return true
+		})
+	}
+
+	return func(d *eformat.DIF) bool {
+		for _, f := range ors {
+			if f(d) {
+				return true
+			}
+		}
+		return false
+	}, nil
+}
+
+var filterVars = map[string]func(d *eformat.DIF) uint64{
+	"frames": func(d *eformat.DIF) uint64 { return uint64(len(d.Frames)) },
+	"difid":  func(d *eformat.DIF) uint64 { return uint64(d.Header.ID) },
+	"dtc":    func(d *eformat.DIF) uint64 { return uint64(d.Header.DTC) },
+	"atc":    func(d *eformat.DIF) uint64 { return uint64(d.Header.ATC) },
+	"gtc":    func(d *eformat.DIF) uint64 { return uint64(d.Header.GTC) },
+	"bcid":   func(d *eformat.DIF) uint64 { return d.Header.AbsBCID },
+}
+
+// filterOps lists the comparison operators, two-character ones first.
+var filterOps = []struct {
+	name string
+	cmp  func(a, b uint64) bool
+}{
+	{"==", func(a, b uint64) bool { return a == b }},
+	{"!=", func(a, b uint64) bool { return a != b }},
+	{"<=", func(a, b uint64) bool { return a <= b }},
+	{">=", func(a, b uint64) bool { return a >= b }},
+	{"<", func(a, b uint64) bool { return a < b }},
+	{">", func(a, b uint64) bool { return a > b }},
+}
+
+func parseCmp(txt string) (func(d *eformat.DIF) bool, error) {
+	for _, op := range filterOps {
+		i := strings.Index(txt, op.name)
+		if i < 0 {
+			continue
+		}
+		var (
+			lhs = strings.TrimSpace(txt[:i])
+			rhs = strings.TrimSpace(txt[i+len(op.name):])
+		)
+		get, ok := filterVars[strings.ToLower(lhs)]
+		if !ok {
+			return nil, fmt.Errorf("unknown variable %q", lhs)
+		}
+		v, err := strconv.ParseUint(rhs, 0, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid value %q: %w", rhs, err)
+		}
+		cmp := op.cmp
+		return func(d *eformat.DIF) bool {
+			return cmp(get(d), v)
+		}, nil
+	}
+	return nil, fmt.Errorf("invalid comparison %q", strings.TrimSpace(txt))
+}
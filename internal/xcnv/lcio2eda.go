@@ -16,42 +16,88 @@ import (
 	"go-hep.org/x/hep/lcio"
 )
 
-func LCIO2EDA(w io.Writer, r *lcio.Reader, freq int, msg *log.Logger) error {
+// LCIO2EDA converts the LCIO events read from r into EDA data written to w.
+// DIF blocks rejected by the conversion filter are skipped.
+// A message is logged every freq events (freq <= 0: no message).
+func LCIO2EDA(w io.Writer, r *lcio.Reader, freq int, msg *log.Logger, opts ...Option) error {
 	var (
-		enc = eformat.NewEncoder(w)
-		i   = 0
+		cfg  = newConfig(opts)
+		enc  = eformat.NewEncoder(w)
+		i    = 0
+		n    = 0 // number of skipped blocks
+		bad  = 0 // number of undecodable payloads
+		nevt = 0 // number of events with undecodable payloads
 	)
 
+	var d eformat.DIF // reused across objects, to recycle frames.
 	for r.Next() {
-		if i%freq == 0 {
+		if freq > 0 && i%freq == 0 {
 			msg.Printf("processing evt %d...", i)
 		}
 
 		evt := r.Event()
-		daq := evt.Get("RU_XDAQ").(*lcio.GenericObject)
-
-		for _, obj := range daq.Data {
-			raw := obj.I32s
-			buf := bytesFromI32s(raw[6:])
-			dec := eformat.NewDecoder(buf[1], bytes.NewReader(buf))
-			dec.IsEDA = true
+		daq, ok := evt.Get("RU_XDAQ").(*lcio.GenericObject)
+		if !ok {
+			if !cfg.skipBad {
+				return fmt.Errorf("could not find RU_XDAQ collection in evt %d", i)
+			}
+			msg.Printf("could not find RU_XDAQ collection in evt %d: skipping event", i)
+			daq = new(lcio.GenericObject)
+			bad++
+		}
 
-			var d eformat.DIF
-			err := dec.Decode(&d)
+		for j, obj := range daq.Data {
+			err := decodeXDAQ(&d, obj.I32s)
 			if err != nil {
-				return fmt.Errorf("could not decode EDA: %w", err)
+				if !cfg.skipBad {
+					return fmt.Errorf("could not decode EDA: %w", err)
+				}
+				msg.Printf("could not decode EDA (evt=%d, obj=%d): %+v: skipping payload", i, j, err)
+				bad++
+				ok = false
+				continue
+			}
+			if !cfg.filter(&d) {
+				n++
+				continue
 			}
 			err = enc.Encode(&d)
 			if err != nil {
 				return fmt.Errorf("could not re-encode EDA: %w", err)
 			}
 		}
+		if !ok {
+			nevt++
+		}
 		i++
+		if cfg.progress != nil {
+			cfg.progress(i)
+		}
+	}
+
+	if n > 0 {
+		msg.Printf("skipped %d filtered out blocks", n)
+	}
+	if bad > 0 {
+		msg.Printf("skipped %d undecodable payloads, in %d/%d events", bad, nevt, i)
 	}
 
 	return nil
 }
 
+// decodeXDAQ decodes the DIF block of a RU_XDAQ payload.
+func decodeXDAQ(d *eformat.DIF, raw []int32) error {
+	const hdr = 6 // number of int32 words before the DIF block
+	if len(raw) < hdr {
+		return fmt.Errorf("invalid RU_XDAQ payload (len=%d)", len(raw))
+	}
+	buf := bytesFromI32s(raw[hdr:])
+	dec := eformat.NewAutoDecoder(bytes.NewReader(buf))
+	dec.IsEDA = true
+
+	return dec.Decode(d)
+}
+
 func bytesFromI32s(raw []int32) []byte {
 	const i32sz = 4
 	hdr := *(*reflect.SliceHeader)(unsafe.Pointer(&raw))
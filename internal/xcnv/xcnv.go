@@ -4,3 +4,64 @@
 
 // Package xcnv provides tools to convert data to/from LCIO to/from DIF/EDA.
 package xcnv // import "github.com/go-lpc/mim/internal/xcnv"
+
+import (
+	"github.com/go-lpc/mim/internal/eformat"
+)
+
+// Option configures a conversion.
+type Option func(*config)
+
+type config struct {
+	filter   func(d *eformat.DIF) bool
+	skipBad  bool        // whether to skip undecodable payloads
+	progress func(n int) // called after each processed event (nil: none)
+	maxMem   int64       // maximum memory in bytes of the events in flight (0: sequential conversion)
+}
+
+func newConfig(opts []Option) config {
+	cfg := config{
+		filter: func(*eformat.DIF) bool { return true },
+	}
+	for _, opt := range opts {
+		opt(&cfg)
+	}
+	return cfg
+}
+
+// WithFilter configures a conversion to only convert the DIF blocks
+// for which the provided predicate returns true.
+func WithFilter(f func(d *eformat.DIF) bool) Option {
+	return func(cfg *config) {
+		if f == nil {
+			return
+		}
+		cfg.filter = f
+	}
+}
+
+// WithSkipBad configures a LCIO to EDA conversion to log and skip the
+// RU_XDAQ payloads that can not be decoded, instead of failing.
+// A summary of the skipped payloads is logged at the end of the conversion.
+func WithSkipBad(skip bool) Option {
+	return func(cfg *config) {
+		cfg.skipBad = skip
+	}
+}
+
+// WithProgress configures a LCIO to EDA conversion to call f after each
+// processed event, with the number of events processed so far.
+func WithProgress(f func(n int)) Option {
+	return func(cfg *config) {
+		cfg.progress = f
+	}
+}
+
+// WithMaxMem configures an EDA to LCIO conversion to decode, convert and
+// write events concurrently, with at most n bytes of events in flight.
+// A zero value (the default) runs the conversion sequentially.
+func WithMaxMem(n int64) Option {
+	return func(cfg *config) {
+		cfg.maxMem = n
+	}
+}
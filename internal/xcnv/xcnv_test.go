@@ -6,11 +6,13 @@ package xcnv
 
 import (
 	"bytes"
+	"fmt"
 	"io/ioutil"
 	"log"
 	"os"
 	"path/filepath"
 	"reflect"
+	"strings"
 	"testing"
 
 	"github.com/go-lpc/mim/internal/eformat"
@@ -111,6 +113,38 @@ func TestEDA2LCIO(t *testing.T) {
 			}
 			defer lr.Close()
 
+			if !lr.Next() {
+				t.Fatalf("could not read LCIO event: %+v", lr.Err())
+			}
+			evt := lr.Event()
+			want := map[string][]int32{
+				"DIF_ID":    {0x42},
+				"DTC":       {10},
+				"ATC":       {11},
+				"GTC":       {12},
+				"AbsBCID":   {0x33445566, 0x1122},
+				"TimeDIFTC": {0x00112233},
+				"NFrames":   {2},
+			}
+			if got := evt.Params.Ints; !reflect.DeepEqual(got, want) {
+				t.Fatalf("invalid event parameters:\ngot= %v\nwant=%v", got, want)
+			}
+			for _, name := range EventParams {
+				if _, ok := evt.Params.Ints[name]; !ok {
+					t.Fatalf("missing event parameter %q", name)
+				}
+			}
+
+			err = lr.Close()
+			if err != nil {
+				t.Fatalf("could not close LCIO file: %+v", err)
+			}
+			lr, err = lcio.Open(fname + ".lcio")
+			if err != nil {
+				t.Fatalf("could not open LCIO file: %+v", err)
+			}
+			defer lr.Close()
+
 			err = LCIO2EDA(ew, lr, 1, msg)
 			if err != nil {
 				t.Fatalf("could not convert to EDA: %+v", err)
@@ -138,3 +172,343 @@ func TestEDA2LCIO(t *testing.T) {
 		})
 	}
 }
+
+func TestParseFilter(t *testing.T) {
+	dif := eformat.DIF{
+		Header: eformat.GlobalHeader{
+			ID:      0xb7,
+			DTC:     10,
+			ATC:     11,
+			GTC:     12,
+			AbsBCID: 0x1234,
+		},
+		Frames: make([]eformat.Frame, 2),
+	}
+
+	for _, tc := range []struct {
+		expr string
+		want bool
+		err  string
+	}{
+		{expr: "frames>0", want: true},
+		{expr: "frames==0", want: false},
+		{expr: "frames>0 && difid==0xb7", want: true},
+		{expr: "frames>0 && difid!=0xb7", want: false},
+		{expr: "difid==1 || difid==0xb7", want: true},
+		{expr: "difid==1 || difid==2", want: false},
+		{expr: "difid==1 || frames>=2 && DTC<11", want: true},
+		{expr: "dtc<=10 && atc>10 && gtc<13 && bcid==0x1234", want: true},
+		{expr: "", err: "xcnv: empty filter expression"},
+		{expr: "frames", err: `xcnv: invalid filter expression "frames": invalid comparison "frames"`},
+		{expr: "foo==1", err: `xcnv: invalid filter expression "foo==1": unknown variable "foo"`},
+		{expr: "frames>x", err: `xcnv: invalid filter expression "frames>x": invalid value "x": strconv.ParseUint: parsing "x": invalid syntax`},
+		{expr: "frames>0 &&", err: `xcnv: invalid filter expression "frames>0 &&": invalid comparison ""`},
+	} {
+		t.Run(tc.expr, func(t *testing.T) {
+			f, err := ParseFilter(tc.expr)
+			switch {
+			case err != nil && tc.err != "":
+				if got, want := err.Error(), tc.err; got != want {
+					t.Fatalf("invalid error:\ngot= %v\nwant=%v", got, want)
+				}
+				return
+			case err != nil:
+				t.Fatalf("could not parse filter: %+v", err)
+			case tc.err != "":
+				t.Fatalf("expected an error (%s)", tc.err)
+			}
+
+			if got, want := f(&dif), tc.want; got != want {
+				t.Fatalf("invalid filter result: got=%v, want=%v", got, want)
+			}
+		})
+	}
+}
+
+func TestEDA2LCIOFilter(t *testing.T) {
+	tmp, err := ioutil.TempDir("", "mim-xcnv-")
+	if err != nil {
+		t.Fatalf("could not create tmp dir: %+v", err)
+	}
+	defer os.RemoveAll(tmp)
+
+	const (
+		run   = 63
+		difID = 0x42
+	)
+
+	var (
+		msg    = log.New(ioutil.Discard, "", 0)
+		edabuf = new(bytes.Buffer)
+		enc    = eformat.NewEncoder(edabuf)
+	)
+	for i := 0; i < 4; i++ {
+		d := eformat.DIF{
+			Header: eformat.GlobalHeader{ID: difID, DTC: uint32(i)},
+			Frames: make([]eformat.Frame, i%2),
+		}
+		err = enc.Encode(&d)
+		if err != nil {
+			t.Fatalf("could not encode EDA: %+v", err)
+		}
+	}
+
+	fname := filepath.Join(tmp, "eda.lcio")
+	lw, err := lcio.Create(fname)
+	if err != nil {
+		t.Fatalf("could not create LCIO file: %+v", err)
+	}
+	defer lw.Close()
+
+	filter, err := ParseFilter("frames>0")
+	if err != nil {
+		t.Fatalf("could not parse filter: %+v", err)
+	}
+
+	err = EDA2LCIO(lw, eformat.NewDecoder(difID, edabuf), run, msg, WithFilter(filter))
+	if err != nil {
+		t.Fatalf("could not convert to LCIO: %+v", err)
+	}
+	err = lw.Close()
+	if err != nil {
+		t.Fatalf("could not close LCIO file: %+v", err)
+	}
+
+	lr, err := lcio.Open(fname)
+	if err != nil {
+		t.Fatalf("could not open LCIO file: %+v", err)
+	}
+	defer lr.Close()
+
+	var evts []int32
+	for lr.Next() {
+		evts = append(evts, lr.Event().EventNumber)
+	}
+	if got, want := evts, []int32{1, 3}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("invalid events: got=%v, want=%v", got, want)
+	}
+
+	lr, err = lcio.Open(fname)
+	if err != nil {
+		t.Fatalf("could not open LCIO file: %+v", err)
+	}
+	defer lr.Close()
+
+	out := new(bytes.Buffer)
+	err = LCIO2EDA(out, lr, 1, msg, WithFilter(func(d *eformat.DIF) bool {
+		return d.Header.DTC == 3
+	}))
+	if err != nil {
+		t.Fatalf("could not convert to EDA: %+v", err)
+	}
+
+	var d eformat.DIF
+	err = eformat.NewDecoder(difID, out).Decode(&d)
+	if err != nil {
+		t.Fatalf("could not decode EDA: %+v", err)
+	}
+	if got, want := d.Header.DTC, uint32(3); got != want {
+		t.Fatalf("invalid DTC: got=%d, want=%d", got, want)
+	}
+	if out.Len() != 0 {
+		t.Fatalf("unexpected trailing EDA data: %d bytes", out.Len())
+	}
+}
+
+func TestEDA2LCIOMaxMem(t *testing.T) {
+	tmp, err := ioutil.TempDir("", "mim-xcnv-")
+	if err != nil {
+		t.Fatalf("could not create tmp dir: %+v", err)
+	}
+	defer os.RemoveAll(tmp)
+
+	const (
+		run   = 63
+		difID = 0x42
+	)
+
+	var (
+		msg    = log.New(ioutil.Discard, "", 0)
+		edabuf = new(bytes.Buffer)
+		enc    = eformat.NewEncoder(edabuf)
+	)
+	for i := 0; i < 50; i++ {
+		d := eformat.DIF{
+			Header: eformat.GlobalHeader{ID: difID, DTC: uint32(i), AbsBCID: uint64(10 * i)},
+			Frames: make([]eformat.Frame, i%7),
+		}
+		for j := range d.Frames {
+			d.Frames[j] = eformat.Frame{Header: uint8(j), BCID: uint32(i + j)}
+		}
+		err = enc.Encode(&d)
+		if err != nil {
+			t.Fatalf("could not encode EDA: %+v", err)
+		}
+	}
+	raw := edabuf.Bytes()
+
+	convert := func(name string, opts ...Option) []lcio.Event {
+		fname := filepath.Join(tmp, name+".lcio")
+		lw, err := lcio.Create(fname)
+		if err != nil {
+			t.Fatalf("could not create LCIO file: %+v", err)
+		}
+		defer lw.Close()
+
+		err = EDA2LCIO(lw, eformat.NewDecoder(difID, bytes.NewReader(raw)), run, msg, opts...)
+		if err != nil {
+			t.Fatalf("could not convert to LCIO: %+v", err)
+		}
+		err = lw.Close()
+		if err != nil {
+			t.Fatalf("could not close LCIO file: %+v", err)
+		}
+
+		lr, err := lcio.Open(fname)
+		if err != nil {
+			t.Fatalf("could not open LCIO file: %+v", err)
+		}
+		defer lr.Close()
+
+		var evts []lcio.Event
+		for lr.Next() {
+			evts = append(evts, lr.Event())
+		}
+		return evts
+	}
+
+	filter := WithFilter(func(d *eformat.DIF) bool { return d.Header.DTC%5 != 0 })
+	want := convert("seq", filter)
+	if got, want := len(want), 40; got != want {
+		t.Fatalf("invalid number of events: got=%d, want=%d", got, want)
+	}
+
+	// a budget smaller than a single event must not stall the conversion.
+	for _, mem := range []int64{1, 1 << 10, 1 << 20} {
+		got := convert(fmt.Sprintf("mem-%d", mem), filter, WithMaxMem(mem))
+		if !reflect.DeepEqual(got, want) {
+			t.Fatalf("invalid events with max-mem=%d", mem)
+		}
+	}
+
+	// decoding errors are reported.
+	lw, err := lcio.Create(filepath.Join(tmp, "bad.lcio"))
+	if err != nil {
+		t.Fatalf("could not create LCIO file: %+v", err)
+	}
+	defer lw.Close()
+
+	bad := append(append([]byte(nil), raw...), 0xff)
+	err = EDA2LCIO(lw, eformat.NewDecoder(difID, bytes.NewReader(bad)), run, msg, WithMaxMem(1<<10))
+	if err == nil || !strings.HasPrefix(err.Error(), "could not decode EDA: ") {
+		t.Fatalf("invalid error: %+v", err)
+	}
+}
+
+func TestLCIO2EDASkipBad(t *testing.T) {
+	tmp, err := ioutil.TempDir("", "mim-xcnv-")
+	if err != nil {
+		t.Fatalf("could not create tmp dir: %+v", err)
+	}
+	defer os.RemoveAll(tmp)
+
+	const difID = 0x42
+
+	fname := filepath.Join(tmp, "bad.lcio")
+	lw, err := lcio.Create(fname)
+	if err != nil {
+		t.Fatalf("could not create LCIO file: %+v", err)
+	}
+	defer lw.Close()
+
+	block := func(dtc uint32) []int32 {
+		d := eformat.DIF{Header: eformat.GlobalHeader{ID: difID, DTC: dtc}}
+		return i32sFrom(new(bytes.Buffer), &d)
+	}
+
+	for i, raw := range [][]lcio.GenericObjectData{
+		{{I32s: block(1)}},
+		{{I32s: []int32{0, 0, 0, 0, 0, 0, 0x42, 0x42}}},
+		{{I32s: []int32{0}}, {I32s: block(3)}},
+		nil, // no RU_XDAQ collection.
+	} {
+		evt := lcio.Event{EventNumber: int32(i)}
+		if raw != nil {
+			evt.Add("RU_XDAQ", &lcio.GenericObject{Data: raw})
+		}
+		err = lw.WriteEvent(&evt)
+		if err != nil {
+			t.Fatalf("could not write event %d: %+v", i, err)
+		}
+	}
+	err = lw.Close()
+	if err != nil {
+		t.Fatalf("could not close LCIO file: %+v", err)
+	}
+
+	for _, tc := range []struct {
+		skip bool
+		err  string
+		dtcs []uint32
+		log  string
+	}{
+		{
+			skip: false,
+			err:  "could not decode EDA: dif: could not read global header marker (got=0x42)",
+		},
+		{
+			skip: true,
+			dtcs: []uint32{1, 3},
+			log:  "skipped 3 undecodable payloads, in 3/4 events\n",
+		},
+	} {
+		t.Run(fmt.Sprintf("skip=%v", tc.skip), func(t *testing.T) {
+			lr, err := lcio.Open(fname)
+			if err != nil {
+				t.Fatalf("could not open LCIO file: %+v", err)
+			}
+			defer lr.Close()
+
+			var (
+				out  = new(bytes.Buffer)
+				msg  = new(strings.Builder)
+				evts []int
+			)
+			err = LCIO2EDA(out, lr, 0, log.New(msg, "", 0),
+				WithSkipBad(tc.skip),
+				WithProgress(func(n int) { evts = append(evts, n) }),
+			)
+			switch {
+			case err != nil && tc.err != "":
+				if got, want := err.Error(), tc.err; got != want {
+					t.Fatalf("invalid error:\ngot= %v\nwant=%v", got, want)
+				}
+				return
+			case err != nil:
+				t.Fatalf("could not convert to EDA: %+v", err)
+			case tc.err != "":
+				t.Fatalf("expected an error (%s)", tc.err)
+			}
+
+			if got, want := evts, []int{1, 2, 3, 4}; !reflect.DeepEqual(got, want) {
+				t.Fatalf("invalid progress: got=%v, want=%v", got, want)
+			}
+
+			var (
+				dec  = eformat.NewDecoder(difID, out)
+				d    eformat.DIF
+				dtcs []uint32
+			)
+			for dec.Decode(&d) == nil {
+				dtcs = append(dtcs, d.Header.DTC)
+			}
+			if got, want := dtcs, tc.dtcs; !reflect.DeepEqual(got, want) {
+				t.Fatalf("invalid DIF blocks: got=%v, want=%v", got, want)
+			}
+
+			if got, want := msg.String(), tc.log; !strings.HasSuffix(got, want) {
+				t.Fatalf("invalid log:\n%s\nwant suffix: %q", got, want)
+			}
+		})
+	}
+}
@@ -0,0 +1,398 @@
+// Copyright 2020 The go-lpc Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Package zseek reads and writes seekable zstd streams.
+//
+// A seekable zstd stream is a sequence of independent zstd frames,
+// followed by a seek table stored in a zstd skippable frame, as described
+// by the zstd seekable format:
+//
+//	https://github.com/facebook/zstd/blob/dev/contrib/seekable_format/zstd_seekable_compression_format.md
+//
+// Seekable zstd streams can be decompressed by any zstd decoder.
+package zseek // import "github.com/go-lpc/mim/internal/zseek"
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+const (
+	frameMagic    = 0xfd2fb528 // zstd frame magic number
+	skipMagic     = 0x184d2a5e // zstd skippable frame magic number (seek table)
+	seekableMagic = 0x8f92eab1 // seek table footer magic number
+
+	footerLen = 4 + 1 + 4 // number of frames + descriptor + magic
+	entryLen  = 4 + 4     // compressed size + decompressed size
+
+	// DefaultFrameSize is the default maximum size of the uncompressed
+	// data of a frame.
+	DefaultFrameSize = 1 << 20
+)
+
+type entry struct {
+	csize uint32 // compressed size of the frame
+	dsize uint32 // decompressed size of the frame
+}
+
+// Writer compresses data into a seekable zstd stream.
+//
+// Data is compressed in independent frames of at most DefaultFrameSize
+// bytes. The seek table is written when the Writer is closed: a stream
+// that was not closed can still be decompressed, but not seeked.
+type Writer struct {
+	w    io.Writer
+	enc  *zstd.Encoder
+	max  int
+	buf  []byte // uncompressed data of the current frame
+	zbuf []byte // compressed data of the current frame
+	tab  []entry
+	err  error
+}
+
+// NewWriter returns a new Writer compressing data to w with the provided
+// zstd compression level.
+func NewWriter(w io.Writer, level int) (*Writer, error) {
+	enc, err := zstd.NewWriter(nil,
+		zstd.WithEncoderLevel(zstd.EncoderLevelFromZstd(level)),
+		zstd.WithEncoderConcurrency(1),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("zseek: could not create zstd encoder: %w", err)
+	}
+	return &Writer{
+		w:   w,
+		enc: enc,
+		max: DefaultFrameSize,
+	}, nil
+}
+
+// Write writes p to the current frame, compressing and flushing the frame
+// to the underlying writer when it is full.
+func (w *Writer) Write(p []byte) (int, error) {
+	n := 0
+	for len(p) > 0 && w.err == nil {
+		nn := w.max - len(w.buf)
+		if nn > len(p) {
+			nn = len(p)
+		}
+		w.buf = append(w.buf, p[:nn]...)
+		n += nn
+		p = p[nn:]
+		if len(w.buf) >= w.max {
+			w.err = w.Flush()
+		}
+	}
+	return n, w.err
+}
+
+// Flush compresses the data of the current frame and writes it to the
+// underlying writer.
+func (w *Writer) Flush() error {
+	if w.err != nil {
+		return w.err
+	}
+	if len(w.buf) == 0 {
+		return nil
+	}
+	w.zbuf = w.enc.EncodeAll(w.buf, w.zbuf[:0])
+	_, err := w.w.Write(w.zbuf)
+	if err != nil {
+		w.err = fmt.Errorf("zseek: could not write frame: %w", err)
+		return w.err
+	}
+	w.tab = append(w.tab, entry{
+		csize: uint32(len(w.zbuf)),
+		dsize: uint32(len(w.buf)),
+	})
+	w.buf = w.buf[:0]
+	return nil
+}
+
+// Close flushes the current frame and writes the seek table.
+// Close does not close the underlying writer.
+func (w *Writer) Close() error {
+	defer w.enc.Close()
+
+	err := w.Flush()
+	if err != nil {
+		return err
+	}
+
+	size := len(w.tab)*entryLen + footerLen
+	buf := make([]byte, 8+size)
+	binary.LittleEndian.PutUint32(buf[0:], skipMagic)
+	binary.LittleEndian.PutUint32(buf[4:], uint32(size))
+	p := buf[8:]
+	for _, e := range w.tab {
+		binary.LittleEndian.PutUint32(p[0:], e.csize)
+		binary.LittleEndian.PutUint32(p[4:], e.dsize)
+		p = p[entryLen:]
+	}
+	binary.LittleEndian.PutUint32(p[0:], uint32(len(w.tab)))
+	p[4] = 0 // descriptor: no checksums.
+	binary.LittleEndian.PutUint32(p[5:], seekableMagic)
+
+	_, err = w.w.Write(buf)
+	if err != nil {
+		w.err = fmt.Errorf("zseek: could not write seek table: %w", err)
+		return w.err
+	}
+	w.err = errors.New("zseek: writer closed")
+	return nil
+}
+
+// Reader decompresses a seekable zstd stream.
+type Reader struct {
+	r   io.ReadSeeker
+	dec *zstd.Decoder
+
+	tab  []entry
+	offs []int64 // compressed offset of each frame
+	size int64   // decompressed size of the stream
+
+	cur  int    // index of the next frame to load
+	pos  int64  // decompressed offset of the reader
+	buf  []byte // decompressed data of the current frame, not yet read
+	zbuf []byte
+}
+
+// NewReader returns a new Reader decompressing the seekable zstd stream r.
+func NewReader(r io.ReadSeeker) (*Reader, error) {
+	dec, err := zstd.NewReader(nil, zstd.WithDecoderConcurrency(1))
+	if err != nil {
+		return nil, fmt.Errorf("zseek: could not create zstd decoder: %w", err)
+	}
+	zr := &Reader{r: r, dec: dec}
+	err = zr.readTable()
+	if err != nil {
+		dec.Close()
+		return nil, err
+	}
+	_, err = r.Seek(0, io.SeekStart)
+	if err != nil {
+		dec.Close()
+		return nil, fmt.Errorf("zseek: could not rewind stream: %w", err)
+	}
+	return zr, nil
+}
+
+func (r *Reader) readTable() error {
+	end, err := r.r.Seek(-footerLen, io.SeekEnd)
+	if err != nil {
+		return fmt.Errorf("zseek: could not seek to seek table footer: %w", err)
+	}
+	var footer [footerLen]byte
+	_, err = io.ReadFull(r.r, footer[:])
+	if err != nil {
+		return fmt.Errorf("zseek: could not read seek table footer: %w", err)
+	}
+	if binary.LittleEndian.Uint32(footer[5:]) != seekableMagic {
+		return fmt.Errorf("zseek: missing seek table (truncated stream?)")
+	}
+	var (
+		n    = int64(binary.LittleEndian.Uint32(footer[0:]))
+		desc = footer[4]
+		elen = int64(entryLen)
+	)
+	if desc&0x80 != 0 {
+		elen += 4 // checksums
+	}
+
+	beg := end - n*elen
+	if beg < 8 {
+		return fmt.Errorf("zseek: invalid seek table size (frames=%d)", n)
+	}
+	_, err = r.r.Seek(beg, io.SeekStart)
+	if err != nil {
+		return fmt.Errorf("zseek: could not seek to seek table: %w", err)
+	}
+	raw := make([]byte, n*elen)
+	_, err = io.ReadFull(r.r, raw)
+	if err != nil {
+		return fmt.Errorf("zseek: could not read seek table: %w", err)
+	}
+
+	r.tab = make([]entry, n)
+	r.offs = make([]int64, n)
+	var off int64
+	for i := range r.tab {
+		p := raw[int64(i)*elen:]
+		r.tab[i] = entry{
+			csize: binary.LittleEndian.Uint32(p[0:]),
+			dsize: binary.LittleEndian.Uint32(p[4:]),
+		}
+		r.offs[i] = off
+		off += int64(r.tab[i].csize)
+		r.size += int64(r.tab[i].dsize)
+	}
+	if off > beg-8 {
+		return fmt.Errorf("zseek: inconsistent seek table (frames size=%d, table offset=%d)", off, beg-8)
+	}
+	return nil
+}
+
+// Size returns the decompressed size of the stream.
+func (r *Reader) Size() int64 { return r.size }
+
+// Read reads decompressed data into p.
+func (r *Reader) Read(p []byte) (int, error) {
+	for len(r.buf) == 0 {
+		if r.cur >= len(r.tab) {
+			return 0, io.EOF
+		}
+		err := r.load(r.cur)
+		if err != nil {
+			return 0, err
+		}
+		r.cur++
+	}
+	n := copy(p, r.buf)
+	r.buf = r.buf[n:]
+	r.pos += int64(n)
+	return n, nil
+}
+
+// load decompresses the i-th frame.
+func (r *Reader) load(i int) error {
+	e := r.tab[i]
+	_, err := r.r.Seek(r.offs[i], io.SeekStart)
+	if err != nil {
+		return fmt.Errorf("zseek: could not seek to frame %d: %w", i, err)
+	}
+	if cap(r.zbuf) < int(e.csize) {
+		r.zbuf = make([]byte, e.csize)
+	}
+	r.zbuf = r.zbuf[:e.csize]
+	_, err = io.ReadFull(r.r, r.zbuf)
+	if err != nil {
+		return fmt.Errorf("zseek: could not read frame %d: %w", i, err)
+	}
+	r.buf, err = r.dec.DecodeAll(r.zbuf, r.buf[:0])
+	if err != nil {
+		return fmt.Errorf("zseek: could not decompress frame %d: %w", i, err)
+	}
+	if len(r.buf) != int(e.dsize) {
+		return fmt.Errorf(
+			"zseek: invalid decompressed size for frame %d (got=%d, want=%d)",
+			i, len(r.buf), e.dsize,
+		)
+	}
+	return nil
+}
+
+// Seek sets the decompressed offset for the next Read.
+func (r *Reader) Seek(offset int64, whence int) (int64, error) {
+	switch whence {
+	case io.SeekStart:
+	case io.SeekCurrent:
+		offset += r.pos
+	case io.SeekEnd:
+		offset += r.size
+	default:
+		return r.pos, fmt.Errorf("zseek: invalid whence %d", whence)
+	}
+	if offset < 0 {
+		return r.pos, fmt.Errorf("zseek: negative offset %d", offset)
+	}
+
+	r.buf = r.buf[:0]
+	r.cur = len(r.tab)
+	r.pos = offset
+	beg := int64(0)
+	for i, e := range r.tab {
+		end := beg + int64(e.dsize)
+		if offset < end {
+			err := r.load(i)
+			if err != nil {
+				return r.pos, err
+			}
+			r.buf = r.buf[offset-beg:]
+			r.cur = i + 1
+			break
+		}
+		beg = end
+	}
+	return r.pos, nil
+}
+
+// Close releases the resources of the decoder.
+// Close does not close the underlying reader.
+func (r *Reader) Close() error {
+	r.dec.Close()
+	return nil
+}
+
+// Open returns a reader of the decompressed content of rs, or rs itself if
+// rs does not start with a zstd frame.
+// Streams without a seek table are decompressed sequentially: seeking
+// backwards is then not supported.
+func Open(rs io.ReadSeeker) (io.ReadSeeker, error) {
+	var magic [4]byte
+	n, err := io.ReadFull(rs, magic[:])
+	if err != nil && !errors.Is(err, io.ErrUnexpectedEOF) && !errors.Is(err, io.EOF) {
+		return nil, fmt.Errorf("zseek: could not read magic: %w", err)
+	}
+	_, err = rs.Seek(0, io.SeekStart)
+	if err != nil {
+		return nil, fmt.Errorf("zseek: could not rewind stream: %w", err)
+	}
+	if n < len(magic) || binary.LittleEndian.Uint32(magic[:]) != frameMagic {
+		return rs, nil
+	}
+
+	r, err := NewReader(rs)
+	if err == nil {
+		return r, nil
+	}
+
+	_, err = rs.Seek(0, io.SeekStart)
+	if err != nil {
+		return nil, fmt.Errorf("zseek: could not rewind stream: %w", err)
+	}
+	dec, err := zstd.NewReader(rs, zstd.WithDecoderConcurrency(1))
+	if err != nil {
+		return nil, fmt.Errorf("zseek: could not create zstd decoder: %w", err)
+	}
+	return &streamReader{dec: dec}, nil
+}
+
+// streamReader decompresses a zstd stream sequentially.
+type streamReader struct {
+	dec *zstd.Decoder
+	pos int64
+}
+
+func (r *streamReader) Read(p []byte) (int, error) {
+	n, err := r.dec.Read(p)
+	r.pos += int64(n)
+	return n, err
+}
+
+// Seek only supports seeking forward, by discarding decompressed data.
+func (r *streamReader) Seek(offset int64, whence int) (int64, error) {
+	switch whence {
+	case io.SeekStart:
+	case io.SeekCurrent:
+		offset += r.pos
+	default:
+		return r.pos, fmt.Errorf("zseek: invalid whence %d for a stream without seek table", whence)
+	}
+	if offset < r.pos {
+		return r.pos, fmt.Errorf("zseek: can not seek backwards in a stream without seek table")
+	}
+	_, err := io.CopyN(ioutil.Discard, r, offset-r.pos)
+	return r.pos, err
+}
+
+var (
+	_ io.WriteCloser = (*Writer)(nil)
+	_ io.ReadSeeker  = (*Reader)(nil)
+	_ io.ReadSeeker  = (*streamReader)(nil)
+)
@@ -0,0 +1,146 @@
+// Copyright 2020 The go-lpc Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package zseek
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"math/rand"
+	"testing"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+func TestRW(t *testing.T) {
+	rnd := rand.New(rand.NewSource(1234))
+	want := make([]byte, 3*1024+17)
+	for i := range want {
+		want[i] = byte(rnd.Intn(8))
+	}
+
+	for _, tc := range []struct {
+		name  string
+		max   int
+		close bool
+	}{
+		{name: "one-frame", max: DefaultFrameSize, close: true},
+		{name: "multi-frames", max: 1000, close: true},
+		{name: "no-seek-table", max: 1000, close: false},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			zbuf := new(bytes.Buffer)
+			w, err := NewWriter(zbuf, 3)
+			if err != nil {
+				t.Fatalf("could not create writer: %+v", err)
+			}
+			w.max = tc.max
+
+			for _, p := range [][]byte{want[:10], want[10:2500], want[2500:]} {
+				_, err = w.Write(p)
+				if err != nil {
+					t.Fatalf("could not write data: %+v", err)
+				}
+			}
+			switch {
+			case tc.close:
+				err = w.Close()
+				if err != nil {
+					t.Fatalf("could not close writer: %+v", err)
+				}
+			default:
+				err = w.Flush()
+				if err != nil {
+					t.Fatalf("could not flush writer: %+v", err)
+				}
+			}
+
+			// seekable streams are regular zstd streams.
+			dec, err := zstd.NewReader(bytes.NewReader(zbuf.Bytes()))
+			if err != nil {
+				t.Fatalf("could not create zstd reader: %+v", err)
+			}
+			defer dec.Close()
+			got, err := ioutil.ReadAll(dec)
+			if err != nil {
+				t.Fatalf("could not decompress stream: %+v", err)
+			}
+			if !bytes.Equal(got, want) {
+				t.Fatalf("invalid zstd round-trip")
+			}
+
+			r, err := Open(bytes.NewReader(zbuf.Bytes()))
+			if err != nil {
+				t.Fatalf("could not open stream: %+v", err)
+			}
+			got, err = ioutil.ReadAll(r)
+			if err != nil {
+				t.Fatalf("could not read stream: %+v", err)
+			}
+			if !bytes.Equal(got, want) {
+				t.Fatalf("invalid round-trip")
+			}
+
+			if !tc.close {
+				_, err = r.Seek(0, io.SeekStart)
+				if err == nil {
+					t.Fatalf("expected an error seeking backwards")
+				}
+				return
+			}
+
+			zr := r.(*Reader)
+			if got, want := zr.Size(), int64(len(want)); got != want {
+				t.Fatalf("invalid size: got=%d, want=%d", got, want)
+			}
+
+			for _, off := range []int64{0, 999, 1000, 1001, 2500, int64(len(want)) - 1} {
+				pos, err := r.Seek(off, io.SeekStart)
+				if err != nil {
+					t.Fatalf("could not seek to %d: %+v", off, err)
+				}
+				if pos != off {
+					t.Fatalf("invalid position: got=%d, want=%d", pos, off)
+				}
+				var p [16]byte
+				n, err := io.ReadFull(r, p[:])
+				if err != nil && err != io.ErrUnexpectedEOF {
+					t.Fatalf("could not read at %d: %+v", off, err)
+				}
+				if !bytes.Equal(p[:n], want[off:off+int64(n)]) {
+					t.Fatalf("invalid data at %d", off)
+				}
+			}
+
+			pos, err := r.Seek(-4, io.SeekEnd)
+			if err != nil {
+				t.Fatalf("could not seek from end: %+v", err)
+			}
+			if got, want := pos, int64(len(want)-4); got != want {
+				t.Fatalf("invalid position: got=%d, want=%d", got, want)
+			}
+		})
+	}
+}
+
+func TestOpenRaw(t *testing.T) {
+	for _, raw := range [][]byte{nil, {1, 2}, []byte("hello world")} {
+		rs := bytes.NewReader(raw)
+		r, err := Open(rs)
+		if err != nil {
+			t.Fatalf("could not open raw stream: %+v", err)
+		}
+		if r != io.ReadSeeker(rs) {
+			t.Fatalf("expected raw stream to be returned as-is")
+		}
+		got, err := ioutil.ReadAll(r)
+		if err != nil {
+			t.Fatalf("could not read raw stream: %+v", err)
+		}
+		if !bytes.Equal(got, raw) {
+			t.Fatalf("invalid raw stream: got=%q, want=%q", got, raw)
+		}
+	}
+}
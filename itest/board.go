@@ -0,0 +1,136 @@
+// Copyright 2020 The go-lpc Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package itest
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+
+	"github.com/go-lpc/mim/conddb"
+	"github.com/go-lpc/mim/eda"
+	"github.com/go-lpc/mim/eda/edatest"
+	"github.com/go-lpc/mim/internal/eformat"
+)
+
+// nHR is the number of hardroc ASICs of an RFM.
+const nHR = 8
+
+// Board is a simulated EDA board: an eda.Device running in test pattern
+// mode on a fake memory device (see edatest), with one RFM per DIF.
+type Board struct {
+	cfg Config
+	dir string // directory of the fake memory device and of the device files
+
+	mu   sync.Mutex
+	blks []eformat.DIF // DIF blocks sent by the device
+}
+
+// NewBoard creates a simulated EDA board for the DIFs described by cfg,
+// storing its files under dir.
+func NewBoard(cfg Config, dir string) *Board {
+	return &Board{cfg: cfg, dir: dir}
+}
+
+// Blocks returns all the DIF blocks sent by the board.
+func (b *Board) Blocks() []eformat.DIF {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return append([]eformat.DIF(nil), b.blks...)
+}
+
+// Run runs the board for the configured number of readout cycles, sending
+// the DIF data of each DIF to the sink at the corresponding address.
+func (b *Board) Run(addrs []string) error {
+	if len(addrs) != len(b.cfg.DIFs) {
+		return fmt.Errorf(
+			"itest: invalid number of sink addresses (got=%d, want=%d)",
+			len(addrs), len(b.cfg.DIFs),
+		)
+	}
+
+	devmem, err := edatest.NewDevMem(b.dir)
+	if err != nil {
+		return fmt.Errorf("itest: could not create fake memory device: %w", err)
+	}
+
+	dev, err := eda.NewDevice(
+		devmem, b.dir,
+		eda.WithDevSHM(b.dir),
+		eda.WithCtlAddr(""),
+		eda.WithLogger(b.cfg.Msg),
+		eda.WithTestPattern(b.cfg.Frames, 0),
+		eda.WithMaxRunCycles(int64(b.cfg.Triggers)),
+		eda.WithRunSummary(true),
+		eda.WithSender(eda.SenderFunc(b.record)),
+	)
+	if err != nil {
+		return fmt.Errorf("itest: could not create EDA device: %w", err)
+	}
+	defer dev.Close()
+
+	rfms := make([]conddb.RFM, len(b.cfg.DIFs))
+	for i, dif := range b.cfg.DIFs {
+		rfms[i] = conddb.RFM{ID: int(dif), Slot: i}
+	}
+	err = dev.Boot(rfms)
+	if err != nil {
+		return fmt.Errorf("itest: could not boot EDA device: %w", err)
+	}
+	for i, dif := range b.cfg.DIFs {
+		err = dev.ConfigureDIF(addrs[i], dif, make([]conddb.ASIC, nHR))
+		if err != nil {
+			return fmt.Errorf("itest: could not configure DIF=%d: %w", dif, err)
+		}
+	}
+
+	err = dev.Initialize()
+	if err != nil {
+		return fmt.Errorf("itest: could not initialize EDA device: %w", err)
+	}
+
+	err = dev.Start(uint32(b.cfg.Run))
+	if err != nil {
+		return fmt.Errorf("itest: could not start run: %w", err)
+	}
+	<-dev.Done()
+
+	err = dev.Stop()
+	if err != nil {
+		return fmt.Errorf("itest: could not stop run: %w", err)
+	}
+
+	err = dev.Close()
+	if err != nil {
+		return fmt.Errorf("itest: could not close EDA device: %w", err)
+	}
+	return nil
+}
+
+// record records the DIF blocks of an acquisition cycle, as sent to the
+// sinks by the device.
+func (b *Board) record(cycle *eda.Cycle) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	for _, blk := range cycle.DIFs {
+		dec := eformat.NewDecoder(blk.ID, bytes.NewReader(blk.Data))
+		dec.IsEDA = true
+		for {
+			var d eformat.DIF
+			err := dec.Decode(&d)
+			if err != nil {
+				if errors.Is(err, io.EOF) {
+					break
+				}
+				return fmt.Errorf("itest: could not decode DIF=%d block: %w", blk.ID, err)
+			}
+			b.blks = append(b.blks, d)
+		}
+	}
+	return nil
+}
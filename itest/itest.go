@@ -0,0 +1,291 @@
+// Copyright 2020 The go-lpc Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Package itest provides an in-process integration test harness for the
+// MIM DAQ pipeline.
+//
+// The harness wires together:
+//   - a simulated EDA board: an eda.Device in test pattern mode, on a fake
+//     memory device, sending its DIF blocks with the EDA DIF data protocol
+//     ("HDR\0"+size, "ACK\0"),
+//   - one sink receiver per DIF, decoding DIF blocks,
+//   - an event builder, assembling DIF blocks into events by GTC,
+//   - the EDA to LCIO converter (and back), from a raw file with CRC-16
+//     checksums,
+//
+// and checks the end-to-end integrity of the data: number of blocks and
+// frames, CRC-16 checksums, GTC and BCID ordering and content.
+package itest // import "github.com/go-lpc/mim/itest"
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+
+	"github.com/go-lpc/mim/internal/eformat"
+	"github.com/go-lpc/mim/internal/xcnv"
+	"go-hep.org/x/hep/lcio"
+	"golang.org/x/sync/errgroup"
+)
+
+// Config describes a simulated DAQ run.
+type Config struct {
+	Run      int32   // run number
+	DIFs     []uint8 // IDs of the simulated DIFs, one per RFM slot (at most 4)
+	Triggers int     // number of readout cycles
+	Frames   int     // number of hardroc frames per DIF block
+
+	Msg *log.Logger // logger for the pipeline (default: discard)
+}
+
+// Result summarizes a simulated DAQ run.
+type Result struct {
+	Blocks int    // number of DIF blocks received by the sinks
+	Frames int    // number of hardroc frames received by the sinks
+	Events int    // number of events assembled by the event builder
+	Raw    string // path to the raw file written from built events
+	LCIO   string // path to the LCIO file converted from the raw file
+}
+
+// Event is a set of DIF blocks with the same global trigger counter.
+type Event struct {
+	GTC  uint32
+	DIFs []eformat.DIF // DIF blocks, sorted by DIF ID
+}
+
+// Run runs a simulated DAQ run, storing the board and output files under
+// dir.
+// Run returns an error if any stage of the pipeline fails or if the data
+// read back from the LCIO file differs from the simulated one.
+func Run(cfg Config, dir string) (Result, error) {
+	var res Result
+	if len(cfg.DIFs) == 0 {
+		return res, fmt.Errorf("itest: no DIF to simulate")
+	}
+	if cfg.Msg == nil {
+		cfg.Msg = log.New(ioutil.Discard, "", 0)
+	}
+
+	var (
+		board = NewBoard(cfg, dir)
+		sinks = make([]*Sink, len(cfg.DIFs))
+		addrs = make([]string, len(cfg.DIFs))
+		recv  = make(chan eformat.DIF)
+	)
+	for i, dif := range cfg.DIFs {
+		sink, err := NewSink(dif, "127.0.0.1:0")
+		if err != nil {
+			return res, fmt.Errorf("itest: could not create sink for DIF=%d: %w", dif, err)
+		}
+		defer sink.Close()
+		sinks[i] = sink
+		addrs[i] = sink.Addr()
+	}
+
+	var grp errgroup.Group
+	for _, sink := range sinks {
+		sink := sink
+		grp.Go(func() error {
+			return sink.Serve(recv)
+		})
+	}
+	grp.Go(func() error {
+		err := board.Run(addrs)
+		if err != nil {
+			// unblock sinks still waiting for a connection.
+			for _, sink := range sinks {
+				_ = sink.Close()
+			}
+		}
+		return err
+	})
+
+	built := make(chan error, 1)
+	var evts []Event
+	go func() {
+		bldr := NewBuilder(cfg.DIFs)
+		for d := range recv {
+			res.Blocks++
+			res.Frames += len(d.Frames)
+			out, err := bldr.Add(d)
+			if err != nil {
+				built <- err
+				for range recv {
+					// drain.
+				}
+				return
+			}
+			evts = append(evts, out...)
+		}
+		built <- bldr.Close()
+	}()
+
+	err := grp.Wait()
+	close(recv)
+	if err != nil {
+		return res, fmt.Errorf("itest: could not transfer DIF data: %w", err)
+	}
+	err = <-built
+	if err != nil {
+		return res, fmt.Errorf("itest: could not build events: %w", err)
+	}
+	res.Events = len(evts)
+
+	res.Raw = filepath.Join(dir, fmt.Sprintf("eda_%03d.000.raw", cfg.Run))
+	err = writeRaw(res.Raw, uint32(cfg.Run), evts)
+	if err != nil {
+		return res, err
+	}
+
+	res.LCIO = res.Raw + ".lcio"
+	err = toLCIO(res.LCIO, res.Raw, cfg.Run, cfg.Msg)
+	if err != nil {
+		return res, err
+	}
+
+	got, err := fromLCIO(res.LCIO, cfg.Msg)
+	if err != nil {
+		return res, err
+	}
+
+	err = check(got, board.Blocks())
+	if err != nil {
+		return res, err
+	}
+
+	return res, nil
+}
+
+func writeRaw(fname string, run uint32, evts []Event) error {
+	f, err := os.Create(fname)
+	if err != nil {
+		return fmt.Errorf("itest: could not create raw file: %w", err)
+	}
+	defer f.Close()
+
+	set := eformat.Settings{Run: run}
+	_, err = set.WriteTo(f)
+	if err != nil {
+		return fmt.Errorf("itest: could not write settings record: %w", err)
+	}
+
+	enc := eformat.NewEncoder(f)
+	for _, evt := range evts {
+		for i := range evt.DIFs {
+			err = enc.Encode(&evt.DIFs[i])
+			if err != nil {
+				return fmt.Errorf("itest: could not encode DIF block: %w", err)
+			}
+		}
+	}
+
+	err = f.Close()
+	if err != nil {
+		return fmt.Errorf("itest: could not close raw file: %w", err)
+	}
+	return nil
+}
+
+func toLCIO(oname, fname string, run int32, msg *log.Logger) error {
+	f, err := os.Open(fname)
+	if err != nil {
+		return fmt.Errorf("itest: could not open raw file: %w", err)
+	}
+	defer f.Close()
+
+	w, err := lcio.Create(oname)
+	if err != nil {
+		return fmt.Errorf("itest: could not create LCIO file: %w", err)
+	}
+	defer w.Close()
+
+	err = xcnv.EDA2LCIO(w, eformat.NewDecoder(0, f), run, msg)
+	if err != nil {
+		return fmt.Errorf("itest: could not convert raw file to LCIO: %w", err)
+	}
+
+	err = w.Close()
+	if err != nil {
+		return fmt.Errorf("itest: could not close LCIO file: %w", err)
+	}
+	return nil
+}
+
+func fromLCIO(fname string, msg *log.Logger) ([]eformat.DIF, error) {
+	r, err := lcio.Open(fname)
+	if err != nil {
+		return nil, fmt.Errorf("itest: could not open LCIO file: %w", err)
+	}
+	defer r.Close()
+
+	buf := new(bytes.Buffer)
+	err = xcnv.LCIO2EDA(buf, r, 1000, msg)
+	if err != nil {
+		return nil, fmt.Errorf("itest: could not convert LCIO file: %w", err)
+	}
+
+	var (
+		dec = eformat.NewDecoder(0, buf)
+		out []eformat.DIF
+	)
+	for {
+		var d eformat.DIF
+		err := dec.Decode(&d)
+		if err != nil {
+			if errors.Is(err, io.EOF) {
+				break
+			}
+			return nil, fmt.Errorf("itest: could not decode DIF block from LCIO: %w", err)
+		}
+		out = append(out, d)
+	}
+	return out, nil
+}
+
+// check compares the DIF blocks read back from the end of the pipeline
+// with the simulated ones.
+func check(got, want []eformat.DIF) error {
+	want = append([]eformat.DIF(nil), want...)
+	sort.SliceStable(want, func(i, j int) bool {
+		wi, wj := want[i].Header, want[j].Header
+		if wi.GTC != wj.GTC {
+			return wi.GTC < wj.GTC
+		}
+		return wi.ID < wj.ID
+	})
+
+	if len(got) != len(want) {
+		return fmt.Errorf("itest: invalid number of DIF blocks: got=%d, want=%d", len(got), len(want))
+	}
+
+	for i := range got {
+		g, w := &got[i], &want[i]
+		if g.Header != w.Header {
+			return fmt.Errorf(
+				"itest: block %d: invalid header:\ngot= %+v\nwant=%+v",
+				i, g.Header, w.Header,
+			)
+		}
+		if len(g.Frames) != len(w.Frames) {
+			return fmt.Errorf(
+				"itest: block %d (DIF=%d, GTC=%d): invalid number of frames: got=%d, want=%d",
+				i, w.Header.ID, w.Header.GTC, len(g.Frames), len(w.Frames),
+			)
+		}
+		if len(w.Frames) > 0 && !reflect.DeepEqual(g.Frames, w.Frames) {
+			return fmt.Errorf(
+				"itest: block %d (DIF=%d, GTC=%d): invalid frames",
+				i, w.Header.ID, w.Header.GTC,
+			)
+		}
+	}
+	return nil
+}
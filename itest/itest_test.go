@@ -0,0 +1,175 @@
+// Copyright 2020 The go-lpc Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package itest
+
+import (
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/go-lpc/mim/internal/eformat"
+)
+
+func TestRun(t *testing.T) {
+	for _, tc := range []struct {
+		name string
+		cfg  Config
+	}{
+		{
+			name: "single-dif",
+			cfg: Config{
+				Run:      42,
+				DIFs:     []uint8{0x42},
+				Triggers: 10,
+				Frames:   5,
+			},
+		},
+		{
+			name: "multi-difs",
+			cfg: Config{
+				Run:      43,
+				DIFs:     []uint8{1, 2, 3, 4},
+				Triggers: 100,
+				Frames:   20,
+			},
+		},
+		{
+			name: "no-frames",
+			cfg: Config{
+				Run:      44,
+				DIFs:     []uint8{3, 1},
+				Triggers: 5,
+			},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			dir, err := ioutil.TempDir("", "mim-itest-")
+			if err != nil {
+				t.Fatalf("could not create tmp dir: %+v", err)
+			}
+			defer os.RemoveAll(dir)
+
+			res, err := Run(tc.cfg, dir)
+			if err != nil {
+				t.Fatalf("could not run DAQ pipeline: %+v", err)
+			}
+
+			frames := len(tc.cfg.DIFs) * tc.cfg.Triggers * tc.cfg.Frames
+			if got, want := res.Blocks, len(tc.cfg.DIFs)*tc.cfg.Triggers; got != want {
+				t.Fatalf("invalid number of blocks: got=%d, want=%d", got, want)
+			}
+			if got, want := res.Frames, frames; got != want {
+				t.Fatalf("invalid number of frames: got=%d, want=%d", got, want)
+			}
+			if got, want := res.Events, tc.cfg.Triggers; got != want {
+				t.Fatalf("invalid number of events: got=%d, want=%d", got, want)
+			}
+
+			f, err := os.Open(res.Raw)
+			if err != nil {
+				t.Fatalf("could not open raw file: %+v", err)
+			}
+			defer f.Close()
+			dec := eformat.NewDecoder(0, f)
+			err = dec.Decode(new(eformat.DIF))
+			if err != nil {
+				t.Fatalf("could not decode raw file: %+v", err)
+			}
+			set, ok := dec.Settings()
+			if !ok {
+				t.Fatalf("missing settings record")
+			}
+			if got, want := set.Run, uint32(tc.cfg.Run); got != want {
+				t.Fatalf("invalid settings run: got=%d, want=%d", got, want)
+			}
+		})
+	}
+}
+
+func TestBuilder(t *testing.T) {
+	blk := func(id uint8, gtc uint32, bcid uint64) eformat.DIF {
+		return eformat.DIF{Header: eformat.GlobalHeader{ID: id, GTC: gtc, AbsBCID: bcid}}
+	}
+
+	for _, tc := range []struct {
+		name string
+		blks []eformat.DIF
+		evts int
+		err  string
+	}{
+		{
+			name: "ok",
+			blks: []eformat.DIF{blk(2, 0, 10), blk(1, 0, 10), blk(1, 1, 20), blk(2, 1, 20)},
+			evts: 2,
+		},
+		{
+			name: "incomplete",
+			blks: []eformat.DIF{blk(2, 0, 10), blk(1, 0, 10), blk(1, 1, 20)},
+			evts: 1,
+			err:  "itest: 1 incomplete event(s) (GTCs=[1])",
+		},
+		{
+			name: "unknown-dif",
+			blks: []eformat.DIF{blk(3, 0, 10)},
+			err:  "itest: unknown DIF=3",
+		},
+		{
+			name: "gtc-gap",
+			blks: []eformat.DIF{blk(1, 0, 10), blk(1, 2, 10)},
+			err:  "itest: DIF=1: invalid GTC sequence (got=2, want=1)",
+		},
+		{
+			name: "inconsistent-bcid",
+			blks: []eformat.DIF{blk(1, 0, 10), blk(2, 0, 11)},
+			err:  "itest: GTC=0: inconsistent BCIDs (DIF=1: 10, DIF=2: 11)",
+		},
+		{
+			name: "bcid-order",
+			blks: []eformat.DIF{blk(1, 0, 10), blk(2, 0, 10), blk(1, 1, 5), blk(2, 1, 5)},
+			evts: 1,
+			err:  "itest: GTC=1: BCID decreasing (got=5, prev=10)",
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			var (
+				b    = NewBuilder([]uint8{1, 2})
+				evts []Event
+				err  error
+			)
+			for _, d := range tc.blks {
+				var out []Event
+				out, err = b.Add(d)
+				evts = append(evts, out...)
+				if err != nil {
+					break
+				}
+			}
+			if err == nil {
+				err = b.Close()
+			}
+
+			switch {
+			case err != nil && tc.err != "":
+				if got, want := err.Error(), tc.err; !strings.HasPrefix(got, want) {
+					t.Fatalf("invalid error:\ngot= %v\nwant=%v", got, want)
+				}
+			case err != nil:
+				t.Fatalf("could not build events: %+v", err)
+			case tc.err != "":
+				t.Fatalf("expected an error (%s)", tc.err)
+			}
+
+			if got, want := len(evts), tc.evts; got != want {
+				t.Fatalf("invalid number of events: got=%d, want=%d", got, want)
+			}
+			for i, evt := range evts {
+				if got, want := evt.GTC, uint32(i); got != want {
+					t.Fatalf("invalid event GTC: got=%d, want=%d", got, want)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,197 @@
+// Copyright 2020 The go-lpc Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package itest
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"sort"
+
+	"github.com/go-lpc/mim/internal/eformat"
+)
+
+// Sink receives the DIF data of a single DIF, sent with the EDA DIF data
+// protocol.
+//
+// Sink stands for the DAQ software receiving the DIF data of EDA boards,
+// which is not part of this repository.
+type Sink struct {
+	dif uint8
+	ln  net.Listener
+}
+
+// NewSink creates a sink for the DIF data of the provided DIF,
+// listening on addr.
+func NewSink(dif uint8, addr string) (*Sink, error) {
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, fmt.Errorf("itest: could not listen on %q: %w", addr, err)
+	}
+	return &Sink{dif: dif, ln: ln}, nil
+}
+
+// Addr returns the address the sink is listening on.
+func (s *Sink) Addr() string { return s.ln.Addr().String() }
+
+// Close closes the sink.
+func (s *Sink) Close() error { return s.ln.Close() }
+
+// Serve accepts a single connection and sends the decoded DIF blocks
+// to out, until the end-of-run summary is received or the connection is
+// closed by the peer.
+func (s *Sink) Serve(out chan<- eformat.DIF) error {
+	conn, err := s.ln.Accept()
+	if err != nil {
+		return fmt.Errorf("itest: sink DIF=%d could not accept connection: %w", s.dif, err)
+	}
+	defer conn.Close()
+
+	var (
+		hdr = make([]byte, 8)
+		ack = []byte("ACK\x00")
+		buf []byte
+	)
+	for {
+		_, err := io.ReadFull(conn, hdr)
+		if err != nil {
+			if errors.Is(err, io.EOF) {
+				return nil
+			}
+			return fmt.Errorf("itest: sink DIF=%d could not read header: %w", s.dif, err)
+		}
+		eor := string(hdr[:4]) == "EOR\x00"
+		if !eor && string(hdr[:4]) != "HDR\x00" {
+			return fmt.Errorf("itest: sink DIF=%d received invalid header %q", s.dif, hdr[:4])
+		}
+		_, err = conn.Write(ack)
+		if err != nil {
+			return fmt.Errorf("itest: sink DIF=%d could not send header ACK: %w", s.dif, err)
+		}
+
+		size := int(binary.LittleEndian.Uint32(hdr[4:]))
+		if size == 0 {
+			continue
+		}
+		if cap(buf) < size {
+			buf = make([]byte, size)
+		}
+		buf = buf[:size]
+		_, err = io.ReadFull(conn, buf)
+		if err != nil {
+			return fmt.Errorf("itest: sink DIF=%d could not read DIF data: %w", s.dif, err)
+		}
+		_, err = conn.Write(ack)
+		if err != nil {
+			return fmt.Errorf("itest: sink DIF=%d could not send data ACK: %w", s.dif, err)
+		}
+		if eor {
+			return nil
+		}
+
+		dec := eformat.NewDecoder(s.dif, bytes.NewReader(buf))
+		dec.IsEDA = true // EDA boards do not compute CRC-16 checksums.
+		for {
+			var d eformat.DIF
+			err = dec.Decode(&d)
+			if err != nil {
+				if errors.Is(err, io.EOF) {
+					break
+				}
+				return fmt.Errorf("itest: sink DIF=%d could not decode DIF data: %w", s.dif, err)
+			}
+			out <- d
+		}
+	}
+}
+
+// Builder assembles DIF blocks into events, by global trigger counter.
+//
+// Builder checks that the GTC of each DIF starts at 0 and increases by
+// one from block to block (see eda.CycleCounter), and that the absolute
+// BCID of events does not decrease.
+type Builder struct {
+	difs map[uint8]uint32         // GTC of the next block, per DIF
+	pend map[uint32][]eformat.DIF // pending DIF blocks, per GTC
+	next uint32                   // GTC of the next event
+	bcid uint64                   // absolute BCID of the last event
+}
+
+// NewBuilder creates a new event builder for the provided DIFs.
+func NewBuilder(difs []uint8) *Builder {
+	b := &Builder{
+		difs: make(map[uint8]uint32, len(difs)),
+		pend: make(map[uint32][]eformat.DIF),
+	}
+	for _, dif := range difs {
+		b.difs[dif] = 0
+	}
+	return b
+}
+
+// Add adds a DIF block to the builder and returns the events that could
+// be completed.
+func (b *Builder) Add(d eformat.DIF) ([]Event, error) {
+	var (
+		id  = d.Header.ID
+		gtc = d.Header.GTC
+	)
+	want, ok := b.difs[id]
+	if !ok {
+		return nil, fmt.Errorf("itest: unknown DIF=%d", id)
+	}
+	if gtc != want {
+		return nil, fmt.Errorf(
+			"itest: DIF=%d: invalid GTC sequence (got=%d, want=%d)",
+			id, gtc, want,
+		)
+	}
+	b.difs[id] = gtc + 1
+	b.pend[gtc] = append(b.pend[gtc], d)
+
+	var evts []Event
+	for len(b.pend[b.next]) == len(b.difs) {
+		blks := b.pend[b.next]
+		delete(b.pend, b.next)
+		sort.Slice(blks, func(i, j int) bool {
+			return blks[i].Header.ID < blks[j].Header.ID
+		})
+		for _, blk := range blks[1:] {
+			if blk.Header.AbsBCID != blks[0].Header.AbsBCID {
+				return evts, fmt.Errorf(
+					"itest: GTC=%d: inconsistent BCIDs (DIF=%d: %d, DIF=%d: %d)",
+					b.next, blks[0].Header.ID, blks[0].Header.AbsBCID,
+					blk.Header.ID, blk.Header.AbsBCID,
+				)
+			}
+		}
+		if bcid := blks[0].Header.AbsBCID; bcid < b.bcid {
+			return evts, fmt.Errorf(
+				"itest: GTC=%d: BCID decreasing (got=%d, prev=%d)",
+				b.next, bcid, b.bcid,
+			)
+		}
+		b.bcid = blks[0].Header.AbsBCID
+		evts = append(evts, Event{GTC: b.next, DIFs: blks})
+		b.next++
+	}
+	return evts, nil
+}
+
+// Close returns an error if some events could not be completed.
+func (b *Builder) Close() error {
+	if len(b.pend) == 0 {
+		return nil
+	}
+	gtcs := make([]int, 0, len(b.pend))
+	for gtc := range b.pend {
+		gtcs = append(gtcs, int(gtc))
+	}
+	sort.Ints(gtcs)
+	return fmt.Errorf("itest: %d incomplete event(s) (GTCs=%v)", len(gtcs), gtcs)
+}
@@ -0,0 +1,133 @@
+// Copyright 2020 The go-lpc Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Package model defines the data model shared by the MIM DAQ tools: runs,
+// acquisition cycles and locations of DIF blocks in files.
+//
+// All types can be marshaled to and from JSON, so tools (run database,
+// monitoring, offline checks, ...) can exchange them.
+package model // import "github.com/go-lpc/mim/model"
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+)
+
+// RunInfo describes a run, as taken by one EDA board.
+type RunInfo struct {
+	ID       uint32          `json:"id"`
+	Board    int             `json:"board"`              // EDA board ID (-1 if unknown)
+	Host     string          `json:"host,omitempty"`     // host that took the run
+	Mode     string          `json:"mode,omitempty"`     // DAQ mode (dcc, noise, ...)
+	Start    time.Time       `json:"start"`              // start of the run
+	Stop     time.Time       `json:"stop"`               // end of the run (zero while running)
+	Cycles   int64           `json:"cycles"`             // number of acquisition cycles
+	Settings json.RawMessage `json:"settings,omitempty"` // run settings
+}
+
+// Running returns whether the run is still in progress.
+func (run RunInfo) Running() bool {
+	return run.Stop.IsZero()
+}
+
+// Duration returns the duration of the run, or zero if it is still in
+// progress.
+func (run RunInfo) Duration() time.Duration {
+	if run.Running() {
+		return 0
+	}
+	return run.Stop.Sub(run.Start)
+}
+
+type runInfoJSON struct {
+	ID       uint32          `json:"id"`
+	Board    int             `json:"board"`
+	Host     string          `json:"host,omitempty"`
+	Mode     string          `json:"mode,omitempty"`
+	Start    time.Time       `json:"start"`
+	Stop     *time.Time      `json:"stop,omitempty"`
+	Cycles   int64           `json:"cycles"`
+	Settings json.RawMessage `json:"settings,omitempty"`
+}
+
+// MarshalJSON implements json.Marshaler.
+// The stop time of a run in progress is omitted.
+func (run RunInfo) MarshalJSON() ([]byte, error) {
+	v := runInfoJSON{
+		ID:       run.ID,
+		Board:    run.Board,
+		Host:     run.Host,
+		Mode:     run.Mode,
+		Start:    run.Start,
+		Cycles:   run.Cycles,
+		Settings: run.Settings,
+	}
+	if !run.Running() {
+		v.Stop = &run.Stop
+	}
+	return json.Marshal(v)
+}
+
+// UnmarshalJSON implements json.Unmarshaler.
+func (run *RunInfo) UnmarshalJSON(p []byte) error {
+	var v runInfoJSON
+	err := json.Unmarshal(p, &v)
+	if err != nil {
+		return err
+	}
+	*run = RunInfo{
+		ID:       v.ID,
+		Board:    v.Board,
+		Host:     v.Host,
+		Mode:     v.Mode,
+		Start:    v.Start,
+		Cycles:   v.Cycles,
+		Settings: v.Settings,
+	}
+	if v.Stop != nil {
+		run.Stop = *v.Stop
+	}
+	return nil
+}
+
+// CycleInfo describes an acquisition cycle of a run.
+type CycleInfo struct {
+	Run     uint32        `json:"run"`
+	ID      int           `json:"id"`       // acquisition cycle number, starting at 0
+	Time    time.Time     `json:"time"`     // wall-clock time of the end of the readout
+	GTC     uint32        `json:"gtc"`      // global trigger counter
+	AbsBCID uint64        `json:"abs_bcid"` // absolute BCID of the readout
+	RFMs    []RFMCounters `json:"rfms"`     // counters of the active RFMs
+}
+
+// Frames returns the number of hardroc frames read out during the cycle.
+func (cycle CycleInfo) Frames() int {
+	n := 0
+	for _, rfm := range cycle.RFMs {
+		n += rfm.Frames
+	}
+	return n
+}
+
+// RFMCounters holds the counters of an RFM for an acquisition cycle.
+type RFMCounters struct {
+	Slot   int   `json:"slot"`   // EDA slot of the RFM
+	DIF    uint8 `json:"dif"`    // DIF ID
+	Frames int   `json:"frames"` // number of hardroc frames
+	Bytes  int   `json:"bytes"`  // size of the DIF block, in bytes
+}
+
+// DIFBlockRef locates a DIF block in a file.
+type DIFBlockRef struct {
+	File   string `json:"file"`
+	Offset int64  `json:"offset"` // offset of the DIF block in the file
+	Size   int64  `json:"size"`   // size of the DIF block, in bytes
+	DIF    uint8  `json:"dif"`    // DIF ID
+	GTC    uint32 `json:"gtc"`    // global trigger counter
+}
+
+func (ref DIFBlockRef) String() string {
+	return fmt.Sprintf("%s[%d:%d]", ref.File, ref.Offset, ref.Offset+ref.Size)
+}
@@ -0,0 +1,93 @@
+// Copyright 2020 The go-lpc Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package model
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestJSON(t *testing.T) {
+	start := time.Date(2021, 5, 12, 10, 0, 0, 0, time.UTC)
+	for _, tc := range []struct {
+		name string
+		v    interface{}
+		json string
+	}{
+		{
+			name: "run",
+			v: &RunInfo{
+				ID: 42, Board: 1, Host: "eda01", Mode: "dcc",
+				Start: start, Stop: start.Add(90 * time.Second),
+				Cycles: 12, Settings: json.RawMessage(`{"Run":42}`),
+			},
+			json: `{"id":42,"board":1,"host":"eda01","mode":"dcc","start":"2021-05-12T10:00:00Z","stop":"2021-05-12T10:01:30Z","cycles":12,"settings":{"Run":42}}`,
+		},
+		{
+			name: "run-in-progress",
+			v:    &RunInfo{ID: 43, Board: -1, Start: start},
+			json: `{"id":43,"board":-1,"start":"2021-05-12T10:00:00Z","cycles":0}`,
+		},
+		{
+			name: "cycle",
+			v: &CycleInfo{
+				Run: 42, ID: 3, Time: start, GTC: 4, AbsBCID: 0x123456789a,
+				RFMs: []RFMCounters{
+					{Slot: 0, DIF: 10, Frames: 2, Bytes: 100},
+					{Slot: 2, DIF: 12, Frames: 0, Bytes: 40},
+				},
+			},
+			json: `{"run":42,"id":3,"time":"2021-05-12T10:00:00Z","gtc":4,"abs_bcid":78187493530,"rfms":[{"slot":0,"dif":10,"frames":2,"bytes":100},{"slot":2,"dif":12,"frames":0,"bytes":40}]}`,
+		},
+		{
+			name: "dif-block",
+			v:    &DIFBlockRef{File: "eda_042.raw", Offset: 1024, Size: 100, DIF: 10, GTC: 4},
+			json: `{"file":"eda_042.raw","offset":1024,"size":100,"dif":10,"gtc":4}`,
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			raw, err := json.Marshal(tc.v)
+			if err != nil {
+				t.Fatalf("could not marshal value: %+v", err)
+			}
+			if got, want := string(raw), tc.json; got != want {
+				t.Fatalf("invalid JSON:\ngot= %s\nwant=%s", got, want)
+			}
+
+			got := reflect.New(reflect.TypeOf(tc.v).Elem()).Interface()
+			err = json.Unmarshal(raw, got)
+			if err != nil {
+				t.Fatalf("could not unmarshal value: %+v", err)
+			}
+			if !reflect.DeepEqual(got, tc.v) {
+				t.Fatalf("invalid round-trip:\ngot= %+v\nwant=%+v", got, tc.v)
+			}
+		})
+	}
+}
+
+func TestRunInfo(t *testing.T) {
+	start := time.Date(2021, 5, 12, 10, 0, 0, 0, time.UTC)
+	run := RunInfo{Start: start}
+	if !run.Running() || run.Duration() != 0 {
+		t.Fatalf("invalid run in progress: running=%v, duration=%v", run.Running(), run.Duration())
+	}
+	run.Stop = start.Add(time.Minute)
+	if run.Running() || run.Duration() != time.Minute {
+		t.Fatalf("invalid stopped run: running=%v, duration=%v", run.Running(), run.Duration())
+	}
+
+	cycle := CycleInfo{RFMs: []RFMCounters{{Frames: 2}, {Frames: 3}}}
+	if got, want := cycle.Frames(), 5; got != want {
+		t.Fatalf("invalid number of frames: got=%d, want=%d", got, want)
+	}
+
+	ref := DIFBlockRef{File: "eda_042.raw", Offset: 1024, Size: 100}
+	if got, want := ref.String(), "eda_042.raw[1024:1124]"; got != want {
+		t.Fatalf("invalid block ref: got=%q, want=%q", got, want)
+	}
+}
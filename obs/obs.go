@@ -0,0 +1,142 @@
+// Copyright 2020 The go-lpc Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Package obs defines the logging and metrics interfaces through which
+// the mim subsystems report their activity, so applications embedding
+// them can route logs and metrics to their own infrastructure.
+package obs // import "github.com/go-lpc/mim/obs"
+
+import (
+	"bytes"
+	"encoding/json"
+	"log"
+	"net/http"
+	"sort"
+	"sync"
+)
+
+// Logger logs formatted messages.
+// *log.Logger implements Logger.
+type Logger interface {
+	Printf(format string, args ...interface{})
+}
+
+// StdLogger returns a *log.Logger writing its messages to l, with the
+// provided prefix.
+// Each write to the Writer of the returned logger is logged as a single
+// message of l: partial lines should not be written to it.
+func StdLogger(l Logger, prefix string) *log.Logger {
+	return log.New(logWriter{l}, prefix, 0)
+}
+
+type logWriter struct {
+	l Logger
+}
+
+func (w logWriter) Write(p []byte) (int, error) {
+	w.l.Printf("%s", bytes.TrimSuffix(p, []byte("\n")))
+	return len(p), nil
+}
+
+// Metrics records the metrics of a subsystem, as named counters and
+// gauges.
+// Implementations must be safe for concurrent use.
+type Metrics interface {
+	// Add adds delta to the counter name.
+	Add(name string, delta int64)
+	// Set sets the gauge name to v.
+	Set(name string, v float64)
+}
+
+// Discard is a Metrics discarding all its values.
+var Discard Metrics = discard{}
+
+type discard struct{}
+
+func (discard) Add(name string, delta int64) {}
+func (discard) Set(name string, v float64)   {}
+
+// Registry is an in-memory Metrics.
+type Registry struct {
+	mu     sync.RWMutex
+	counts map[string]int64
+	gauges map[string]float64
+}
+
+// NewRegistry returns a new, empty, registry.
+func NewRegistry() *Registry {
+	return &Registry{
+		counts: make(map[string]int64),
+		gauges: make(map[string]float64),
+	}
+}
+
+// Add adds delta to the counter name.
+func (r *Registry) Add(name string, delta int64) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.counts[name] += delta
+}
+
+// Set sets the gauge name to v.
+func (r *Registry) Set(name string, v float64) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.gauges[name] = v
+}
+
+// Counter returns the value of the counter name.
+func (r *Registry) Counter(name string) int64 {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.counts[name]
+}
+
+// Gauge returns the value of the gauge name.
+func (r *Registry) Gauge(name string) float64 {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.gauges[name]
+}
+
+// Names returns the sorted names of the counters and gauges recorded so far.
+func (r *Registry) Names() []string {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	names := make([]string, 0, len(r.counts)+len(r.gauges))
+	for name := range r.counts {
+		names = append(names, name)
+	}
+	for name := range r.gauges {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// ServeHTTP serves the counters and gauges of the registry as a JSON
+// object, keyed by name.
+func (r *Registry) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	r.mu.RLock()
+	vs := make(map[string]interface{}, len(r.counts)+len(r.gauges))
+	for name, v := range r.counts {
+		vs[name] = v
+	}
+	for name, v := range r.gauges {
+		vs[name] = v
+	}
+	r.mu.RUnlock()
+
+	w.Header().Set("Content-Type", "application/json")
+	err := json.NewEncoder(w).Encode(vs)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
+
+var (
+	_ Logger       = (*log.Logger)(nil)
+	_ Metrics      = (*Registry)(nil)
+	_ http.Handler = (*Registry)(nil)
+)
@@ -0,0 +1,72 @@
+// Copyright 2020 The go-lpc Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package obs
+
+import (
+	"fmt"
+	"net/http/httptest"
+	"reflect"
+	"sync"
+	"testing"
+)
+
+type recorder struct {
+	msgs []string
+}
+
+func (r *recorder) Printf(format string, args ...interface{}) {
+	r.msgs = append(r.msgs, fmt.Sprintf(format, args...))
+}
+
+func TestStdLogger(t *testing.T) {
+	var (
+		rec = new(recorder)
+		msg = StdLogger(rec, "eda: ")
+	)
+	msg.Printf("run %d started", 42)
+	msg.Printf("run %d stopped\n", 42)
+
+	want := []string{"eda: run 42 started", "eda: run 42 stopped"}
+	if got := rec.msgs; !reflect.DeepEqual(got, want) {
+		t.Fatalf("invalid messages:\ngot= %q\nwant=%q", got, want)
+	}
+}
+
+func TestRegistry(t *testing.T) {
+	reg := NewRegistry()
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			reg.Add("cycles", 2)
+			reg.Set("run", 42)
+		}()
+	}
+	wg.Wait()
+
+	if got, want := reg.Counter("cycles"), int64(20); got != want {
+		t.Fatalf("invalid counter: got=%d, want=%d", got, want)
+	}
+	if got, want := reg.Gauge("run"), 42.0; got != want {
+		t.Fatalf("invalid gauge: got=%v, want=%v", got, want)
+	}
+	if got, want := reg.Names(), []string{"cycles", "run"}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("invalid names: got=%q, want=%q", got, want)
+	}
+
+	rec := httptest.NewRecorder()
+	reg.ServeHTTP(rec, httptest.NewRequest("GET", "/metrics", nil))
+	if got, want := rec.Body.String(), "{\"cycles\":20,\"run\":42}\n"; got != want {
+		t.Fatalf("invalid served metrics:\ngot= %q\nwant=%q", got, want)
+	}
+	if got, want := rec.Header().Get("Content-Type"), "application/json"; got != want {
+		t.Fatalf("invalid content type: got=%q, want=%q", got, want)
+	}
+
+	Discard.Add("cycles", 1)
+	Discard.Set("run", 1)
+}
@@ -0,0 +1,31 @@
+// Copyright 2020 The go-lpc Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package mim
+
+import (
+	"runtime/debug"
+	"time"
+)
+
+// Revision describes the version control state of the sources a binary
+// was built from.
+type Revision struct {
+	ID       string    // revision identifier (e.g. a git commit hash)
+	Time     time.Time // time of the revision
+	Modified bool      // whether the sources had local modifications
+}
+
+// VCS returns the version control revision of the main module of the
+// binary.
+// VCS returns false if that information is not available: VCS stamping
+// requires binaries built with Go >= 1.18 from a version control checkout.
+func VCS() (Revision, bool) {
+	b, ok := debug.ReadBuildInfo()
+	if !ok {
+		return Revision{}, false
+	}
+	rev := vcsOf(b)
+	return rev, rev.ID != ""
+}
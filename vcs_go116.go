@@ -0,0 +1,18 @@
+// Copyright 2020 The go-lpc Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+//go:build !go1.18
+// +build !go1.18
+
+package mim
+
+import (
+	"runtime/debug"
+)
+
+// vcsOf returns an empty revision: VCS information is only recorded in
+// binaries built with Go >= 1.18.
+func vcsOf(b *debug.BuildInfo) Revision {
+	return Revision{}
+}
@@ -0,0 +1,31 @@
+// Copyright 2020 The go-lpc Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+//go:build go1.18
+// +build go1.18
+
+package mim
+
+import (
+	"runtime/debug"
+	"time"
+)
+
+func vcsOf(b *debug.BuildInfo) Revision {
+	var rev Revision
+	if b == nil {
+		return rev
+	}
+	for _, kv := range b.Settings {
+		switch kv.Key {
+		case "vcs.revision":
+			rev.ID = kv.Value
+		case "vcs.time":
+			rev.Time, _ = time.Parse(time.RFC3339, kv.Value)
+		case "vcs.modified":
+			rev.Modified = kv.Value == "true"
+		}
+	}
+	return rev
+}
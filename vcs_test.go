@@ -0,0 +1,63 @@
+// Copyright 2020 The go-lpc Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+//go:build go1.18
+// +build go1.18
+
+package mim
+
+import (
+	"runtime/debug"
+	"testing"
+	"time"
+)
+
+func TestVCS(t *testing.T) {
+	for _, tc := range []struct {
+		name string
+		b    *debug.BuildInfo
+		want Revision
+	}{
+		{name: "nil"},
+		{name: "no-vcs", b: &debug.BuildInfo{}},
+		{
+			name: "clean",
+			b: &debug.BuildInfo{Settings: []debug.BuildSetting{
+				{Key: "vcs", Value: "git"},
+				{Key: "vcs.revision", Value: "0123456789abcdef"},
+				{Key: "vcs.time", Value: "2021-05-12T10:00:00Z"},
+				{Key: "vcs.modified", Value: "false"},
+			}},
+			want: Revision{
+				ID:   "0123456789abcdef",
+				Time: time.Date(2021, 5, 12, 10, 0, 0, 0, time.UTC),
+			},
+		},
+		{
+			name: "modified",
+			b: &debug.BuildInfo{Settings: []debug.BuildSetting{
+				{Key: "vcs.revision", Value: "0123456789abcdef"},
+				{Key: "vcs.modified", Value: "true"},
+			}},
+			want: Revision{ID: "0123456789abcdef", Modified: true},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			got := vcsOf(tc.b)
+			if got != tc.want {
+				t.Fatalf("invalid revision:\ngot= %#v\nwant=%#v", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestVersionOfMain(t *testing.T) {
+	b := &debug.BuildInfo{
+		Main: debug.Module{Path: "github.com/go-lpc/mim", Version: "v0.5.0", Sum: "h1:xyz"},
+	}
+	vers, sum := versionOf(b)
+	if vers != "v0.5.0" || sum != "h1:xyz" {
+		t.Fatalf("invalid version: got=(%q, %q)", vers, sum)
+	}
+}